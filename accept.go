@@ -0,0 +1,122 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// AcceptFilter is called by the server with the remote address of every incoming connection before the connection is
+// wrapped, handshaked, or given its own goroutine. If it returns false, the connection is closed right away, which allows
+// obvious abusers to be rejected without spending any resources on them.
+type AcceptFilter func(remote net.Addr) bool
+
+// CIDRFilter returns an AcceptFilter that rejects connections from addresses that are in any of the deny prefixes, and (if
+// the allow list is not empty) only accepts connections from addresses that are in one of the allow prefixes. Prefixes
+// are written in CIDR notation (like "10.0.0.0/8"), and bare IP addresses are treated as prefixes matching only themselves.
+//
+// Connections from addresses that are not IP addresses are only accepted if the allow list is empty.
+func CIDRFilter(allow []string, deny []string) (AcceptFilter, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, err
+	}
+	return func(remote net.Addr) bool {
+		addr, ok := remoteIP(remote)
+		if !ok {
+			return len(allowed) == 0
+		}
+		if containsAddr(denied, addr) {
+			return false
+		}
+		return len(allowed) == 0 || containsAddr(allowed, addr)
+	}, nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP address of a remote address, with IPv4-mapped IPv6 addresses converted to IPv4
+func remoteIP(remote net.Addr) (netip.Addr, bool) {
+	switch v := remote.(type) {
+	case nil:
+		return netip.Addr{}, false
+	case *net.TCPAddr:
+		addr, ok := netip.AddrFromSlice(v.IP)
+		return addr.Unmap(), ok
+	case *net.UDPAddr:
+		addr, ok := netip.AddrFromSlice(v.IP)
+		return addr.Unmap(), ok
+	}
+	host := remote.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// accept returns whether conn should be served, which requires the server's AcceptFilter to accept it, its address
+// not to be banned (see Server.SetReconnectTracking), and a connection slot to be available for it
+// (see Server.SetMaxConnections). If it should not, conn is closed.
+func (s *Server) accept(conn net.Conn) bool {
+	if s.acceptFilter != nil && !s.acceptFilter(conn.RemoteAddr()) {
+		s.Logger().Debug().Str("Remote", conn.RemoteAddr().String()).Msg("Connection rejected by accept filter")
+		_ = conn.Close()
+		return false
+	}
+	if !s.allowReconnect(addrIdentity(conn.RemoteAddr())) {
+		_ = conn.Close()
+		return false
+	}
+	return s.acquire(conn)
+}
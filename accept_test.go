@@ -0,0 +1,123 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	t.Parallel()
+
+	tcp := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 8192}
+	}
+
+	filter, err := CIDRFilter(nil, []string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32"})
+	require.NoError(t, err)
+	assert.False(t, filter(tcp("10.1.2.3")))
+	assert.False(t, filter(tcp("::ffff:10.1.2.3")))
+	assert.False(t, filter(tcp("192.168.1.7")))
+	assert.True(t, filter(tcp("192.168.1.8")))
+	assert.False(t, filter(tcp("2001:db8::1")))
+	assert.True(t, filter(tcp("2001:db9::1")))
+	assert.True(t, filter(&net.UnixAddr{Name: "/tmp/frisbee.sock", Net: "unix"}))
+
+	filter, err = CIDRFilter([]string{"127.0.0.0/8", "172.16.0.0/12"}, []string{"172.16.5.0/24"})
+	require.NoError(t, err)
+	assert.True(t, filter(tcp("127.0.0.1")))
+	assert.True(t, filter(tcp("172.16.4.1")))
+	assert.False(t, filter(tcp("172.16.5.1")))
+	assert.False(t, filter(tcp("8.8.8.8")))
+	assert.False(t, filter(&net.UnixAddr{Name: "/tmp/frisbee.sock", Net: "unix"}))
+	assert.False(t, filter(nil))
+
+	_, err = CIDRFilter([]string{"not an address"}, nil)
+	assert.Error(t, err)
+	_, err = CIDRFilter(nil, []string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
+
+func TestServerAcceptFilter(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+
+	for name, deny := range map[string]bool{"allowed": false, "denied": true} {
+		deny := deny
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			assert.ErrorIs(t, s.SetAcceptFilter(nil), AcceptFilterNil)
+			filter, err := CIDRFilter(nil, []string{"192.0.2.0/24"})
+			require.NoError(t, err)
+			if deny {
+				filter, err = CIDRFilter(nil, []string{"127.0.0.0/8", "::1"})
+				require.NoError(t, err)
+			}
+			require.NoError(t, s.SetAcceptFilter(filter))
+
+			go func() {
+				_ = s.Start(conn.Listen)
+			}()
+			<-s.started()
+
+			received := make(chan struct{}, 1)
+			clientHandlerTable := make(HandlerTable)
+			clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+				received <- struct{}{}
+				return
+			}
+			c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			require.NoError(t, c.Connect(s.listener.Addr().String()))
+
+			if deny {
+				assert.Eventually(t, c.Closed, time.Second*5, time.Millisecond*10)
+			} else {
+				p := packet.Get()
+				p.Metadata.Operation = metadata.PacketPing
+				require.NoError(t, c.WritePacket(p))
+				packet.Put(p)
+				<-received
+				assert.NoError(t, c.Close())
+			}
+
+			assert.NoError(t, s.Shutdown())
+		})
+	}
+}
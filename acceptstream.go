@@ -0,0 +1,58 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+)
+
+// AcceptStream waits for the next stream that is opened by the peer and returns it, which allows incoming streams
+// to be accepted in a loop (like net.Listener.Accept) instead of with a NewStreamHandler. It returns the error of the
+// context if it is done first, and ConnectionClosed once the connection is closed.
+//
+// Incoming streams are only accepted once AcceptStream has been called for the first time, and until then they are
+// dropped like when no NewStreamHandler is set. AcceptStream cannot be used if a NewStreamHandler was set (including
+// the StreamHandler of a Server), and setting one afterwards replaces it.
+func (c *Async) AcceptStream(ctx context.Context) (*Stream, error) {
+	c.newStreamHandlerMu.Lock()
+	if !c.accepting {
+		if c.newStreamHandler != nil {
+			c.newStreamHandlerMu.Unlock()
+			return nil, NewStreamHandlerSet
+		}
+		c.accepting = true
+		c.newStreamHandler = c.acceptStream
+	}
+	c.newStreamHandlerMu.Unlock()
+
+	select {
+	case stream := <-c.accepted:
+		return stream, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closeCh:
+		return nil, ConnectionClosed
+	}
+}
+
+// acceptStream is the NewStreamHandler that hands incoming streams to AcceptStream
+func (c *Async) acceptStream(stream *Stream) {
+	select {
+	case c.accepted <- stream:
+	case <-c.closeCh:
+	}
+}
@@ -0,0 +1,85 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptStream(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	_, err := readerConn.AcceptStream(ctx)
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	for id := uint16(0); id < 2; id++ {
+		p := packet.Get()
+		p.Content.Write([]byte{byte(id)})
+		p.Metadata.ContentLength = 1
+		require.NoError(t, writerConn.NewStream(id).WritePacket(p))
+		packet.Put(p)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), DefaultDeadline)
+	defer cancel()
+	accepted := make(map[uint16]bool)
+	for i := 0; i < 2; i++ {
+		stream, err := readerConn.AcceptStream(ctx)
+		require.NoError(t, err)
+		p, err := stream.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, byte(stream.ID()), (*p.Content)[0])
+		packet.Put(p)
+		accepted[stream.ID()] = true
+	}
+	assert.Len(t, accepted, 2)
+
+	writerConn.SetNewStreamHandler(func(*Stream) {})
+	_, err = writerConn.AcceptStream(ctx)
+	assert.ErrorIs(t, err, NewStreamHandlerSet)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := readerConn.AcceptStream(ctx)
+		errCh <- err
+	}()
+	require.NoError(t, readerConn.Close())
+	select {
+	case err = <-errCh:
+		assert.ErrorIs(t, err, ConnectionClosed)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for AcceptStream to return")
+	}
+	require.NoError(t, writerConn.Close())
+}
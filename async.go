@@ -19,15 +19,19 @@ package frisbee
 import (
 	"bufio"
 	"context"
+	"crypto/hmac"
 	"crypto/tls"
 	"encoding/binary"
 	"github.com/loopholelabs/common/pkg/queue"
-	"github.com/loopholelabs/frisbee-go/internal/dialer"
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
 	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"go.uber.org/atomic"
+	"hash"
+	"io"
+	"math"
 	"net"
 	"sync"
 	"time"
@@ -38,9 +42,13 @@ import (
 // meant to be used by frisbee client and server implementations
 type Async struct {
 	sync.Mutex
+	id                 uint64
+	peerID             string
 	conn               net.Conn
 	closed             *atomic.Bool
 	writer             *bufio.Writer
+	sent               *writeCounter
+	tokens             []*WriteToken
 	flushCh            chan struct{}
 	closeCh            chan struct{}
 	incoming           *queue.Circular[packet.Packet, *packet.Packet]
@@ -53,58 +61,155 @@ type Async struct {
 	streams            map[uint16]*Stream
 	newStreamHandlerMu sync.Mutex
 	newStreamHandler   NewStreamHandler
+	features           Features
+	options            *Options
+	rotatorsMu         sync.Mutex
+	rotators           []KeyRotator
+	signing            *KeySchedule
+	writeSequence      uint64
+	sequence           *sequenceWindow
+	rekeyMu            sync.Mutex
+	writeEpoch         uint32
+	recorder           PacketRecorder
+	decompressor       io.ReadCloser
+	busyPoll           *atomic.Duration
+	active             *atomic.Bool
+	lastActive         *atomic.Int64
+	idling             *atomic.Bool
+	wakeCh             chan struct{}
+	flushStarted       bool
+	readThrottle       *atomic.Pointer[Throttle]
+	writeThrottle      *atomic.Pointer[Throttle]
+	initiator          *atomic.Bool
+	nextStreamID       uint16
+	outbound           *outbound
+	accepting          bool
+	accepted           chan *Stream
+	outbox             *atomic.Pointer[Outbox]
+	deliveries         *sequenceWindow
+	healthChecks       healthChecks
+	loops              atomic.Uint32
+	readPhase          atomic.Uint32
+	levelLogger        atomic.Pointer[zerolog.Logger]
+	values             values
+	flushQueued        atomic.Bool
+	flushPending       bool
+	flushRate          flushRate
+	coalesceQueued     atomic.Bool
+	handlerTable       HandlerTable
+	encryption         *contentCipher
 }
 
+// connectionIDs is used to assign every Async connection a unique ID
+var connectionIDs = atomic.NewUint64(0)
+
 // ConnectAsync creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
 func ConnectAsync(addr string, keepAlive time.Duration, logger *zerolog.Logger, TLSConfig *tls.Config, streamHandler ...NewStreamHandler) (*Async, error) {
-	var conn net.Conn
-	var err error
-
-	d := dialer.NewRetry()
-
-	if TLSConfig != nil {
-		conn, err = d.DialTLS("tcp", addr, TLSConfig)
-	} else {
-		conn, err = d.Dial("tcp", addr)
-		if err == nil {
-			_ = conn.(*net.TCPConn).SetKeepAlive(true)
-			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
-		}
+	conn, err := dial(addr, keepAlive, TLSConfig)
+	if err != nil {
+		return nil, err
 	}
 
+	options := loadOptions(WithLogger(logger))
+	options.initiator = true
+	return newAsync(conn, options, NoFeatures, streamHandler...), nil
+}
+
+// ConnectAsyncWithDialer creates a new connection to addr using the given DialFunc (which is responsible for
+// establishing TLS, if required) and wraps it in a frisbee connection
+func ConnectAsyncWithDialer(ctx context.Context, addr string, dial DialFunc, logger *zerolog.Logger, streamHandler ...NewStreamHandler) (*Async, error) {
+	conn, err := dial(ctx, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewAsync(conn, logger, streamHandler...), nil
+	options := loadOptions(WithLogger(logger))
+	options.initiator = true
+	return newAsync(conn, options, NoFeatures, streamHandler...), nil
 }
 
 // NewAsync takes an existing net.Conn object and wraps it in a frisbee connection
 func NewAsync(c net.Conn, logger *zerolog.Logger, streamHandler ...NewStreamHandler) (conn *Async) {
-	conn = &Async{
-		conn:     c,
-		closed:   atomic.NewBool(false),
-		writer:   bufio.NewWriterSize(c, DefaultBufferSize),
-		incoming: queue.NewCircular[packet.Packet, *packet.Packet](DefaultBufferSize),
-		flushCh:  make(chan struct{}, 3),
-		closeCh:  make(chan struct{}),
-		streams:  make(map[uint16]*Stream),
-		logger:   logger,
-		error:    atomic.NewError(nil),
-	}
+	return newAsync(c, loadOptions(WithLogger(logger)), NoFeatures, streamHandler...)
+}
 
-	if logger == nil {
-		conn.logger = &defaultLogger
+// newAsync wraps an existing net.Conn object in a frisbee connection which has already
+// completed the handshake and negotiated the given features
+func newAsync(c net.Conn, options *Options, features Features, streamHandler ...NewStreamHandler) (conn *Async) {
+	writeThrottle := atomic.NewPointer(options.WriteBandwidth.throttle())
+	sent := &writeCounter{conn: c, throttle: writeThrottle, pending: atomic.NewInt64(0)}
+	conn = &Async{
+		id:            connectionIDs.Inc(),
+		conn:          c,
+		closed:        atomic.NewBool(false),
+		writer:        bufio.NewWriterSize(sent, DefaultBufferSize),
+		sent:          sent,
+		incoming:      queue.NewCircular[packet.Packet, *packet.Packet](DefaultBufferSize),
+		flushCh:       make(chan struct{}, 3),
+		closeCh:       make(chan struct{}),
+		accepted:      make(chan *Stream),
+		outbox:        atomic.NewPointer[Outbox](nil),
+		streams:       make(map[uint16]*Stream),
+		logger:        options.Logger,
+		error:         atomic.NewError(nil),
+		features:      features,
+		options:       options,
+		recorder:      options.Recorder,
+		busyPoll:      atomic.NewDuration(0),
+		active:        atomic.NewBool(false),
+		lastActive:    atomic.NewInt64(time.Now().UnixNano()),
+		idling:        atomic.NewBool(false),
+		wakeCh:        make(chan struct{}, 1),
+		readThrottle:  atomic.NewPointer(options.ReadBandwidth.throttle()),
+		writeThrottle: writeThrottle,
+		initiator:     atomic.NewBool(options.initiator),
 	}
 
 	if len(streamHandler) > 0 {
 		conn.newStreamHandler = streamHandler[0]
 	}
 
-	conn.wg.Add(3)
-	go conn.flushLoop()
+	if options.limiter != nil {
+		options.limiter.connections.Inc()
+	}
+
+	if options.Priorities != nil {
+		conn.outbound = newOutbound(*options.Priorities, &conn.Mutex)
+	}
+
+	if options.signingKeys.write != nil {
+		conn.signing = NewKeySchedule(options.signingKeys.read, options.signingKeys.write)
+		conn.rotators = append(conn.rotators, conn.signing)
+	}
+
+	if options.encryptionKeys.write != nil {
+		conn.encryption, _ = newContentCipher(options.encryptionKeys)
+	}
+
+	if conn.sequenced() {
+		conn.sequence = newSequenceWindow(options.SequenceWindow)
+	}
+
+	if options.BusyPoll > 0 {
+		if err := conn.SetBusyPoll(options.BusyPoll); err != nil {
+			conn.Logger().Warn().Err(err).Msg("error while setting SO_BUSY_POLL socket option")
+		}
+	}
+
+	// The flush loop is started before the read loop, since handlers called by the read loop can write packets
+	if !options.LazyStart {
+		conn.startFlushLoop()
+	}
+	conn.wg.Add(1)
+	conn.enterLoop(loopRead)
 	go conn.readLoop()
-	go conn.pingLoop()
+	if options.Scheduler != nil && !options.Idle.enabled() {
+		options.Scheduler.start(conn)
+	} else if !options.LazyStart || conn.needsPingLoop() {
+		conn.wg.Add(1)
+		conn.enterLoop(loopPing)
+		go conn.pingLoop()
+	}
 
 	return
 }
@@ -182,7 +287,7 @@ func (c *Async) WritePacket(p *packet.Packet) error {
 	if p.Metadata.Operation <= RESERVED9 {
 		return InvalidOperation
 	}
-	return c.writePacket(p)
+	return c.withCause(c.writePacket(p))
 }
 
 // ReadPacket is a blocking function that will wait until a Frisbee packet is available and then return it (and its content).
@@ -198,7 +303,11 @@ func (c *Async) ReadPacket() (*packet.Packet, error) {
 		}
 		c.staleMu.Unlock()
 		c.Logger().Debug().Err(ConnectionClosed).Msg("error while popping from packet queue")
-		return nil, ConnectionClosed
+		return nil, c.withCause(ConnectionClosed)
+	}
+
+	if busyPoll := c.busyPoll.Load(); busyPoll > 0 {
+		c.spin(busyPoll)
 	}
 
 	readPacket, err := c.incoming.Pop()
@@ -213,7 +322,7 @@ func (c *Async) ReadPacket() (*packet.Packet, error) {
 			}
 			c.staleMu.Unlock()
 			c.Logger().Debug().Err(ConnectionClosed).Msg("error while popping from packet queue")
-			return nil, ConnectionClosed
+			return nil, c.withCause(ConnectionClosed)
 		}
 		c.Logger().Debug().Err(err).Msg("error while popping from packet queue")
 		return nil, err
@@ -226,7 +335,7 @@ func (c *Async) ReadPacket() (*packet.Packet, error) {
 func (c *Async) Flush() error {
 	err := c.flush()
 	if err != nil {
-		return c.closeWithError(err)
+		return c.withCause(c.closeWithError(err))
 	}
 	return nil
 }
@@ -245,14 +354,36 @@ func (c *Async) WriteBufferSize() int {
 
 // Logger returns the underlying logger of the frisbee connection
 func (c *Async) Logger() *zerolog.Logger {
-	return c.logger
+	if logger := c.levelLogger.Load(); logger != nil {
+		return logger
+	}
+	return c.options.logger()
 }
 
-// Error returns the error that caused the frisbee.Async connection to close
+// Error returns the error that caused the frisbee.Async connection to close, which is a
+// *CloseError if the connection was closed because of an error
 func (c *Async) Error() error {
 	return c.error.Load()
 }
 
+// CloseReason returns the CloseReason of the error that caused the connection to close
+func (c *Async) CloseReason() CloseReason {
+	return CloseReasonOf(c.Error())
+}
+
+// withCause replaces ConnectionClosed with a ConnectionClosedError that carries the error that closed the connection
+func (c *Async) withCause(err error) error {
+	if err != nil && errors.Is(err, ConnectionClosed) {
+		return closedError(c.Error())
+	}
+	return err
+}
+
+// Features returns the Features that were negotiated for this connection during the handshake
+func (c *Async) Features() Features {
+	return c.features
+}
+
 // Closed returns whether the frisbee.Async connection is closed
 func (c *Async) Closed() bool {
 	return c.closed.Load()
@@ -264,17 +395,69 @@ func (c *Async) Raw() net.Conn {
 	return c.conn
 }
 
-// NewStream returns a new stream that can be used to send and receive packets
+// ID returns the unique ID of the connection, which can be used with Server.WriteTo
+func (c *Async) ID() uint64 {
+	return c.id
+}
+
+// NewStream returns a new MessageMode stream that can be used to send and receive packets
+//
+// If both peers may open streams, they should use OpenNextStream instead so that their stream IDs never collide.
 func (c *Async) NewStream(id uint16) (stream *Stream) {
 	c.streamsMu.Lock()
 	if stream = c.streams[id]; stream == nil {
-		stream = newStream(id, c)
+		stream = newStream(id, c, MessageMode)
+		stream.local = true
 		c.streams[id] = stream
 	}
 	c.streamsMu.Unlock()
 	return
 }
 
+// OpenStream opens a new stream in the given StreamMode. If a stream with the given ID already exists, it is returned
+// as long as it was opened in the same mode.
+//
+// ByteMode streams are signalled to the peer with a STREAMOPEN packet so that the peer's stream is created in the
+// same mode, which requires the FeatureByteStreams feature to have been negotiated during the handshake (otherwise
+// FeatureNotNegotiated is returned). MessageMode streams behave the same as streams returned by NewStream.
+//
+// If both peers open the same stream ID at the same time in different modes, the stream of the initiator of the
+// connection wins (see Async.Initiator), and the stream of the other peer is closed and replaced by a stream in
+// the initiator's mode, which is passed to its NewStreamHandler. Use OpenNextStream to avoid such collisions.
+func (c *Async) OpenStream(id uint16, mode StreamMode) (*Stream, error) {
+	err := c.checkStreamMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	c.streamsMu.Lock()
+	stream := c.streams[id]
+	if stream != nil {
+		c.streamsMu.Unlock()
+		if stream.mode != mode {
+			return nil, InvalidStreamMode
+		}
+		return stream, nil
+	}
+	stream = newStream(id, c, mode)
+	stream.local = true
+	c.streams[id] = stream
+	c.streamsMu.Unlock()
+
+	err = c.announceStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// removeStream removes the stream with the given ID from the connection
+func (c *Async) removeStream(id uint16) {
+	c.streamsMu.Lock()
+	delete(c.streams, id)
+	c.streamsMu.Unlock()
+}
+
 // SetNewStreamHandler sets the callback handler for new streams.
 //
 // It's important to note that this handler is called for new streams and if it is
@@ -282,9 +465,12 @@ func (c *Async) NewStream(id uint16) (stream *Stream) {
 //
 // It's also important to note that the handler itself is called in its own goroutine to
 // avoid blocking the read lop. This means that the handler must be thread-safe.`
+//
+// Setting a handler replaces the one installed by AcceptStream.
 func (c *Async) SetNewStreamHandler(handler NewStreamHandler) {
 	c.newStreamHandlerMu.Lock()
 	c.newStreamHandler = handler
+	c.accepting = false
 	c.newStreamHandlerMu.Unlock()
 }
 
@@ -294,69 +480,228 @@ func (c *Async) Close() error {
 	if err != nil && errors.Is(err, ConnectionClosed) {
 		return nil
 	}
-	_ = c.conn.Close()
+	_ = c.closeConn()
 	return err
 }
 
+// closeConn closes the underlying connection, first half-closing it and waiting for the peer to close its side
+// of the connection if Options.CloseNotify is set
+func (c *Async) closeConn() error {
+	if c.options.CloseNotify > 0 {
+		if cw, ok := c.conn.(closeWriter); ok && cw.CloseWrite() == nil {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.options.CloseNotify))
+			_, _ = io.Copy(io.Discard, c.conn)
+		}
+	}
+	return c.conn.Close()
+}
+
 // write packet is the internal write packet function that does not check for reserved operations.
 func (c *Async) writePacket(p *packet.Packet) error {
-	if int(p.Metadata.ContentLength) != len(*p.Content) {
+	return c.writePacketTracked(p, nil)
+}
+
+// writePacketTracked is like writePacket, but it also registers the token (if it is not nil)
+// so that it is resolved once the packet has been flushed
+func (c *Async) writePacketTracked(p *packet.Packet, token *WriteToken) error {
+	return c.writePacketPriority(p, PriorityNormal, token)
+}
+
+// writePacketPriority is like writePacketTracked, but it writes the packet with the given Priority
+func (c *Async) writePacketPriority(p *packet.Packet, priority Priority, token *WriteToken) error {
+	err := c.writePriority(p, priority, false, token)
+	if err != nil && err != ConnectionClosed && err != InvalidContentLength && err != ContentTooLarge {
+		return c.closeWithError(err)
+	}
+	return err
+}
+
+// write is an internal function for writing a packet, however it is unique in that it does not call closeWithError
+// (and so does not try and close the underlying connection) when it encounters an error, and instead leaves that
+// responsibility to its parent caller. This allows it to be used by the read and ping loops.
+func (c *Async) write(p *packet.Packet) error {
+	return c.writeWith(p, false, nil)
+}
+
+// writeWith writes the packet p like write does, and if flush is true it also flushes the packet directly
+// from the calling goroutine instead of waking up the flush loop. If token is not nil, it is resolved once
+// the packet has been flushed.
+func (c *Async) writeWith(p *packet.Packet, flush bool, token *WriteToken) error {
+	return c.writePriority(p, PriorityControl, flush, token)
+}
+
+// writePriority is like writeWith, but packets that are not flushed directly are queued with the given Priority
+// if the connection schedules its outbound packets by priority (see WithPriorities)
+func (c *Async) writePriority(p *packet.Packet, priority Priority, flush bool, token *WriteToken) error {
+	return c.writeFrame(p, priority, flush, token, 0)
+}
+
+// writeFrame is like writePriority, but if delivery is not 0 the packet also carries it as its delivery ID,
+// which requires the FeatureAcknowledgements feature (see Outbox)
+func (c *Async) writeFrame(p *packet.Packet, priority Priority, flush bool, token *WriteToken, delivery uint64) error {
+	if !validContentLength(p) {
 		return InvalidContentLength
 	}
 
+	content := *p.Content
+	if len(content) > 0 && c.compressible(p.Metadata.Operation) {
+		buf := c.compress(p.Metadata.Operation, content)
+		defer compressionBuffers.Put(buf)
+		content = buf.Bytes()
+	}
+	if len(content) > 0 && c.encrypted(p.Metadata.Operation) {
+		buf, err := c.encrypt(p.Metadata.Id, p.Metadata.Operation, content)
+		if err != nil {
+			return err
+		}
+		defer encryptionBuffers.Put(buf)
+		content = *buf
+	}
+
 	encodedMetadata := metadata.GetBuffer()
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
-	binary.BigEndian.PutUint32(encodedMetadata[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], p.Metadata.ContentLength)
+	header := encodedMetadata[:]
+	if c.extended() {
+		extendedHeader := extendedHeaders.Get().(*[extendedHeaderSize]byte)
+		defer extendedHeaders.Put(extendedHeader)
+		header, content = encodeExtendedHeader(extendedHeader[:metadata.Size], content, c.inlineThreshold(), c.sequenced())
+		if delivery != 0 {
+			header = appendDelivery(header, delivery)
+		}
+		if p.ContentType != frame.ContentTypeUnspecified {
+			header = appendContentType(header, p.ContentType)
+		}
+	}
+	contentLength := uint32(len(content))
+	if large(len(content)) && c.features.Has(FeatureLargeContent) {
+		header = appendContentLength(header, uint64(len(content)))
+		contentLength = metadata.LargeContentLength
+	} else if uint64(len(content)) > uint64(metadata.LargeContentLength) {
+		metadata.PutBuffer(encodedMetadata)
+		return ContentTooLarge
+	}
+	binary.BigEndian.PutUint16(header[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
+	binary.BigEndian.PutUint16(header[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
+	binary.BigEndian.PutUint32(header[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], contentLength)
+
+	var err error
+	if priority == priorityUrgent {
+		err = c.writeUrgent(p, header, content)
+	} else if c.outbound != nil && priority != PriorityControl && !flush {
+		err = c.enqueue(p, priority, header, content, token)
+	} else {
+		err = c.writeEncoded(p, header, content, flush, token)
+	}
+	metadata.PutBuffer(encodedMetadata)
+	return err
+}
+
+// writeEncoded writes the already encoded header and content of the packet p to the connection. Like write,
+// it does not call closeWithError when it encounters an error.
+func (c *Async) writeEncoded(p *packet.Packet, header []byte, content []byte, flush bool, token *WriteToken) error {
+	if c.tracksActivity() && p.Metadata.Operation != PING && p.Metadata.Operation != PONG && p.Metadata.Operation != REKEY {
+		c.markActive()
+	}
 
 	c.Lock()
 	if c.closed.Load() {
 		c.Unlock()
 		return ConnectionClosed
 	}
-	err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+	err := c.emit(p, header, content, token)
 	if err != nil {
 		c.Unlock()
+		return err
+	}
+
+	if flush {
+		err = c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+		if err == nil {
+			err = c.writer.Flush()
+		}
+		c.settleWrites(err)
+		c.Unlock()
+		if err != nil {
+			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while flushing packet")
+		}
+		return err
+	}
+
+	c.settleWrites(nil)
+	c.startFlushLoop()
+	c.signalFlush()
+
+	c.Unlock()
+
+	return nil
+}
+
+// emit writes the already encoded header and content of the packet p to the write buffer, stamping its sequence
+// number and signing it if required. It must be called with the connection locked, and leaves it locked.
+func (c *Async) emit(p *packet.Packet, header []byte, content []byte, token *WriteToken) error {
+	c.stampSequence(header)
+	err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+	if err != nil {
 		if c.closed.Load() {
 			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while setting write deadline before writing packet")
 			return ConnectionClosed
 		}
 		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while setting write deadline before writing packet")
-		return c.closeWithError(err)
+		return err
 	}
-	_, err = c.writer.Write(encodedMetadata[:])
-	metadata.PutBuffer(encodedMetadata)
+	err = c.writeHeader(header)
 	if err != nil {
-		c.Unlock()
 		if c.closed.Load() {
 			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
 			return ConnectionClosed
 		}
 		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-		return c.closeWithError(err)
+		return err
 	}
-	if p.Metadata.ContentLength != 0 {
-		_, err = c.writer.Write((*p.Content)[:p.Metadata.ContentLength])
+	if len(content) != 0 {
+		_, err = c.writer.Write(content)
 		if err != nil {
-			c.Unlock()
 			if c.closed.Load() {
 				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet content")
 				return ConnectionClosed
 			}
 			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet content")
-			return c.closeWithError(err)
+			return err
 		}
 	}
 
-	if len(c.flushCh) == 0 {
-		select {
-		case c.flushCh <- struct{}{}:
-		default:
+	if c.signing != nil {
+		signature := c.writeSignature()
+		signature.Write(header)
+		signature.Write(content)
+		_, err = c.writer.Write(signature.Sum(nil))
+		if err != nil {
+			if c.closed.Load() {
+				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet signature")
+				return ConnectionClosed
+			}
+			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet signature")
+			return err
 		}
 	}
 
-	c.Unlock()
+	if p.Metadata.Operation == REKEY {
+		err = c.rotateWrite(p)
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error while rotating write keys")
+			return err
+		}
+	}
 
+	if c.recorder != nil {
+		c.recorder.RecordWrite(p)
+	}
+
+	if token != nil {
+		c.track(token)
+	}
+	if c.sent.tracking {
+		c.sent.ends = append(c.sent.ends, c.sent.sent+uint64(c.writer.Buffered()))
+	}
 	return nil
 }
 
@@ -365,22 +710,38 @@ func (c *Async) writePacket(p *packet.Packet) error {
 // when it encounters an error, and instead leaves that responsibility to its parent caller
 func (c *Async) flush() error {
 	c.Lock()
-	if c.closed.Load() {
-		c.Unlock()
-		return ConnectionClosed
-	}
-	if c.writer.Buffered() > 0 {
-		err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
-		if err != nil {
+	for {
+		if c.closed.Load() {
 			c.Unlock()
-			return err
+			return ConnectionClosed
 		}
-		err = c.writer.Flush()
-		if err != nil {
-			c.Unlock()
-			c.Logger().Err(err).Msg("error while flushing data")
-			return err
+		if c.outbound != nil {
+			err := c.drain()
+			if err != nil {
+				c.Unlock()
+				return err
+			}
 		}
+		if c.writer.Buffered() > 0 {
+			err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+			if err != nil {
+				c.Unlock()
+				return err
+			}
+			err = c.writer.Flush()
+			c.settleWrites(err)
+			if err != nil {
+				c.Unlock()
+				c.Logger().Err(err).Msg("error while flushing data")
+				return err
+			}
+		}
+		if c.outbound == nil || c.outbound.queued == 0 {
+			break
+		}
+		// Let the writers of control packets in between every write buffer of queued packets
+		c.Unlock()
+		c.Lock()
 	}
 	c.Unlock()
 	return nil
@@ -395,22 +756,39 @@ func (c *Async) close() error {
 		c.incoming.Close()
 		close(c.closeCh)
 		close(c.flushCh)
+		if c.outbound != nil {
+			c.outbound.cond.Broadcast()
+		}
 		c.Unlock()
 		_ = c.conn.SetDeadline(pastTime)
 		c.wg.Wait()
 		_ = c.conn.SetDeadline(emptyTime)
 		c.stale = c.incoming.Drain()
 		c.staleMu.Unlock()
-		for _, stream := range c.streams {
-			_ = stream.Close()
+		if c.options.limiter != nil {
+			c.options.limiter.connections.Dec()
+		}
+		for id, stream := range c.streams {
+			stream.close()
+			delete(c.streams, id)
 		}
 		c.streamsMu.Unlock()
 		c.Lock()
+		if c.outbound != nil {
+			for c.outbound.queued > 0 {
+				if c.drain() != nil {
+					break
+				}
+			}
+			c.dropQueued()
+		}
 		if c.writer.Buffered() > 0 {
 			_ = c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
 			_ = c.writer.Flush()
 			_ = c.conn.SetWriteDeadline(emptyTime)
 		}
+		c.settleWrites(nil)
+		c.dropWrites()
 		c.Unlock()
 		return nil
 	}
@@ -420,6 +798,7 @@ func (c *Async) close() error {
 }
 
 func (c *Async) closeWithError(err error) error {
+	err = closeError(err)
 	closeError := c.close()
 	if closeError != nil {
 		c.Logger().Debug().Err(closeError).Msgf("attempted to close connection with error `%s`, but got error while closing", err)
@@ -430,7 +809,32 @@ func (c *Async) closeWithError(err error) error {
 	return err
 }
 
+// signalFlush signals that packets have been written to the write buffer (or queued) and need to be flushed,
+// either to the flush loop or to the WriteCoalescer or Scheduler of the connection. It must be called with the
+// connection locked.
+func (c *Async) signalFlush() {
+	if w := c.options.WriteCoalescer; w != nil {
+		w.signal(c)
+		return
+	}
+	if s := c.options.Scheduler; s != nil {
+		s.signal(c)
+		return
+	}
+	if len(c.flushCh) == 0 {
+		select {
+		case c.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
 func (c *Async) flushLoop() {
+	defer c.exitLoop(loopFlush)
+	if strategy := c.options.FlushStrategy; !strategy.immediate() {
+		c.batchFlushLoop(strategy)
+		return
+	}
 	var err error
 	for {
 		if _, ok := <-c.flushCh; !ok {
@@ -447,16 +851,105 @@ func (c *Async) flushLoop() {
 }
 
 func (c *Async) pingLoop() {
-	ticker := time.NewTicker(DefaultPingInterval)
-	defer ticker.Stop()
+	defer c.exitLoop(loopPing)
+	pingInterval := c.options.Liveness.PingInterval
+	idle := c.options.Idle.enabled()
+	maxPingInterval := c.options.Idle.maxPingInterval(c.options.Liveness)
+
+	// baseInterval is how often the loop wakes up while the connection is not idle, which is the ping interval
+	// (or the idle period if the connection only responds to pings)
+	baseInterval := pingInterval
+	if baseInterval <= 0 && idle {
+		baseInterval = c.options.Idle.After
+	}
+	interval := baseInterval
+	var timer *time.Timer
+	var tick <-chan time.Time
+	if interval > 0 {
+		timer = time.NewTimer(interval)
+		defer timer.Stop()
+		tick = timer.C
+	}
+	var rekey <-chan time.Time
+	if c.features.Has(FeatureRekey) && c.options.RekeyInterval > 0 {
+		rekeyTicker := time.NewTicker(c.options.RekeyInterval)
+		defer rekeyTicker.Stop()
+		rekey = rekeyTicker.C
+	}
+
+	var idleFor time.Duration
+	var rekeyDue bool
+	// wake takes the connection out of idle mode
+	wake := func() {
+		idleFor = 0
+		if c.idling.Load() {
+			c.idling.Store(false)
+			interval = baseInterval
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(interval)
+		}
+	}
+
 	var err error
 	for {
 		select {
 		case <-c.closeCh:
 			c.wg.Done()
 			return
-		case <-ticker.C:
-			err = c.writePacket(PINGPacket)
+		case <-c.wakeCh:
+			c.active.Store(false)
+			wake()
+			if rekeyDue {
+				rekeyDue = false
+				err = c.rekey()
+				if err != nil {
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			}
+		case <-tick:
+			if idle {
+				if c.active.Swap(false) {
+					wake()
+				} else if idleFor += interval; idleFor >= c.options.Idle.After {
+					if c.idling.Load() {
+						if interval *= 2; interval > maxPingInterval {
+							interval = maxPingInterval
+						}
+					} else {
+						c.Logger().Debug().Msg("connection is entering idle mode")
+						c.idling.Store(true)
+					}
+				}
+			}
+			if pingInterval > 0 {
+				err = c.writeWith(PINGPacket, c.idling.Load(), nil)
+				if err == nil && rekeyDue {
+					rekeyDue = false
+					err = c.rekey()
+				}
+				if err != nil {
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			} else if c.idling.Load() {
+				// Connections that only respond to pings have nothing to do until they are woken up
+				continue
+			}
+			timer.Reset(interval)
+		case <-rekey:
+			if c.idling.Load() && pingInterval > 0 {
+				rekeyDue = true
+				continue
+			}
+			err = c.rekey()
 			if err != nil {
 				c.wg.Done()
 				_ = c.closeWithError(err)
@@ -466,217 +959,621 @@ func (c *Async) pingLoop() {
 	}
 }
 
+// startFlushLoop starts the flush loop if it has not been started yet (connections that use a Scheduler or a
+// WriteCoalescer have no flush loop). Except in newAsync, it must be called with the connection locked after checking
+// that the connection has not been closed.
+func (c *Async) startFlushLoop() {
+	if !c.flushStarted && c.options.Scheduler == nil && c.options.WriteCoalescer == nil {
+		c.flushStarted = true
+		c.wg.Add(1)
+		c.enterLoop(loopFlush)
+		go c.flushLoop()
+	}
+}
+
+// needsPingLoop returns whether the ping loop has anything to do on the connection
+func (c *Async) needsPingLoop() bool {
+	return c.options.Liveness.PingInterval > 0 || c.options.Idle.enabled() ||
+		(c.features.Has(FeatureRekey) && c.options.RekeyInterval > 0)
+}
+
+// extendReadDeadline extends the read deadline of the underlying connection by the liveness timeout. Since close
+// unblocks the read loop by setting a deadline in the past, ConnectionClosed is returned if the connection was closed
+// so that the read loop does not overwrite that deadline and block until the timeout.
+func (c *Async) extendReadDeadline() error {
+	err := c.conn.SetReadDeadline(time.Now().Add(c.options.Liveness.Timeout))
+	if err == nil && c.closed.Load() {
+		return ConnectionClosed
+	}
+	return err
+}
+
 func (c *Async) readLoop() {
+	defer c.exitLoop(loopRead)
 	buf := make([]byte, DefaultBufferSize)
 	var index int
+	var n int
 	var stream *Stream
 	var isStream bool
+	var isStreamClose bool
+	var isStreamOpen bool
+	var isRekey bool
+	var isAck bool
+	var isHealth bool
+	var isControl bool
+	var isInline bool
+	var sequence uint64
+	var delivery uint64
+	var contentLength int
 	var newStreamHandler NewStreamHandler
-	for {
-		buf = buf[:cap(buf)]
-		if len(buf) < metadata.Size {
-			c.Logger().Debug().Err(InvalidBufferLength).Msg("error during read loop, calling closeWithError")
-			c.wg.Done()
-			_ = c.closeWithError(InvalidBufferLength)
-			return
-		}
+	var header []byte
+	var grown time.Time
+	extended := c.extended()
+	unknownExtension := c.unknownExtension
+	peeker, _ := c.conn.(PeekReader)
+	if peeker != nil {
+		buf = nil
+	}
 
-		var n int
-		var err error
-		for n < metadata.Size {
-			var nn int
-			err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
+	// fill makes sure that at least size bytes are available in buf[index:n],
+	// moving them to the start of buf and reading from the connection if required
+	fill := func(size int) error {
+		if n-index >= size {
+			return nil
+		}
+		if peeker != nil {
+			peeked, err := c.peek(peeker, index, size)
+			index = 0
+			if len(peeked) < size {
+				n = 0
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			buf, n = peeked, len(peeked)
+			return nil
+		}
+		n = copy(buf[:cap(buf)], buf[index:n])
+		index = 0
+		for cap(buf) < size {
+			buf = append(buf[:cap(buf)], 0)
+		}
+		buf = buf[:cap(buf)]
+		for n < size {
+			err := c.extendReadDeadline()
 			if err != nil {
-				c.Logger().Debug().Err(err).Msg("error setting read deadline during read loop, calling closeWithError")
-				c.wg.Done()
-				_ = c.closeWithError(err)
-				return
+				return err
 			}
-			nn, err = c.conn.Read(buf[n:])
+			var nn int
+			nn, err = c.read(buf[n:])
 			n += nn
+			if err != nil && n < size {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// readCompactMetadata reads the varint encoded metadata of the next packet (when the FeatureCompactMetadata feature
+	// has been negotiated) into compactMetadata, one byte at a time since its size is not known upfront
+	compact := c.compactMetadata()
+	var compactMetadata metadata.Metadata
+	var compactMetadataSize int
+	readCompactMetadata := func() error {
+		for size := 1; ; size++ {
+			err := fill(size)
 			if err != nil {
-				if n < metadata.Size {
-					c.wg.Done()
-					_ = c.closeWithError(err)
-					return
+				return err
+			}
+			compactMetadata, compactMetadataSize, err = frame.DecodeCompactMetadata(buf[index : index+size])
+			if err == nil {
+				return nil
+			}
+			if err != io.ErrUnexpectedEOF {
+				return InvalidMetadata
+			}
+		}
+	}
+
+	// discard skips over the next size bytes (writing them to w), reading from the connection if required
+	discard := func(size int, w io.Writer) error {
+		for size > 0 {
+			if index == n && peeker != nil {
+				chunk := size
+				if chunk > DefaultBufferSize {
+					chunk = DefaultBufferSize
+				}
+				peeked, err := c.peek(peeker, index, chunk)
+				index = 0
+				buf, n = peeked, len(peeked)
+				if n == 0 {
+					if err == nil {
+						err = io.ErrUnexpectedEOF
+					}
+					return err
+				}
+			} else if index == n {
+				buf = buf[:cap(buf)]
+				index = 0
+				n = 0
+				err := c.extendReadDeadline()
+				if err != nil {
+					return err
+				}
+				n, err = c.read(buf)
+				if err != nil && n == 0 {
+					return err
 				}
-				break
 			}
+			skipped := n - index
+			if skipped > size {
+				skipped = size
+			}
+			if w != nil {
+				_, _ = w.Write(buf[index : index+skipped])
+			}
+			index += skipped
+			size -= skipped
 		}
+		return nil
+	}
 
-		index = 0
-		for index < n {
-			p := packet.Get()
+	// verify reads the signature that follows a packet and checks it against the given HMAC, which
+	// must already contain the header and content of the packet
+	verify := func(signature hash.Hash) error {
+		err := fill(signatureSize)
+		if err != nil {
+			return err
+		}
+		valid := hmac.Equal(signature.Sum(nil), buf[index:index+signatureSize])
+		index += signatureSize
+		if !valid {
+			return InvalidSignature
+		}
+		return nil
+	}
+
+	// verifyPacket verifies the signature of a packet with the given content, if signing is enabled
+	verifyPacket := func(content []byte) error {
+		if c.signing == nil {
+			return nil
+		}
+		signature := c.readSignature()
+		signature.Write(header)
+		signature.Write(content)
+		return verify(signature)
+	}
+
+	for {
+		if index == n && cap(buf) > DefaultBufferSize && c.options.ReadBuffer.shrink(grown) {
+			buf = make([]byte, DefaultBufferSize)
+			index, n = 0, 0
+		}
+		var err error
+		if compact {
+			err = readCompactMetadata()
+		} else {
+			err = fill(metadata.Size)
+		}
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error while reading packet metadata, calling closeWithError")
+			c.wg.Done()
+			_ = c.closeWithError(err)
+			return
+		}
+		p := packet.Get()
+		if compact {
+			*p.Metadata = compactMetadata
+			if c.signing != nil {
+				header = appendMetadata(header[:0], compactMetadata)
+			}
+			index += compactMetadataSize
+		} else {
 			p.Metadata.Id = binary.BigEndian.Uint16(buf[index+metadata.IdOffset : index+metadata.IdOffset+metadata.IdSize])
 			p.Metadata.Operation = binary.BigEndian.Uint16(buf[index+metadata.OperationOffset : index+metadata.OperationOffset+metadata.OperationSize])
 			p.Metadata.ContentLength = binary.BigEndian.Uint32(buf[index+metadata.ContentLengthOffset : index+metadata.ContentLengthOffset+metadata.ContentLengthSize])
+			if c.signing != nil {
+				header = append(header[:0], buf[index:index+metadata.Size]...)
+			}
 			index += metadata.Size
+		}
+		contentLength = int(p.Metadata.ContentLength)
 
-			switch p.Metadata.Operation {
-			case PING:
-				c.Logger().Debug().Msg("PING Packet received by read loop, sending back PONG packet")
-				err = c.writePacket(PONGPacket)
-				if err != nil {
-					c.wg.Done()
-					_ = c.closeWithError(err)
-					return
+		if extended {
+			err = fill(1)
+			if err == nil {
+				size := int(buf[index])
+				err = fill(1 + size)
+				if err == nil {
+					if c.signing != nil {
+						header = append(header, buf[index:index+1+size]...)
+					}
+					isInline, sequence, err = decodeExtensions(p, buf[index+1:index+1+size], unknownExtension)
+					delivery = decodeDelivery(buf[index+1 : index+1+size])
+					if err == nil && c.sequence != nil && !c.checkSequence(sequence) {
+						err = InvalidSequence
+					}
+					if length := decodeContentLength(buf[index+1 : index+1+size]); err == nil && length != 0 {
+						if !c.features.Has(FeatureLargeContent) || length > c.options.LargeContentLimit || length > math.MaxInt {
+							err = ContentTooLarge
+						} else {
+							contentLength = int(length)
+							setContentLength(p, contentLength)
+						}
+					}
+					index += 1 + size
 				}
+			}
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while reading extended header")
 				packet.Put(p)
-			case PONG:
-				c.Logger().Debug().Msg("PONG Packet received by read loop")
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+		}
+
+		if c.options.limiter != nil && !c.limit(metadata.Size+contentLength) {
+			packet.Put(p)
+			c.wg.Done()
+			return
+		}
+
+		operation := p.Metadata.Operation
+		if operation <= PONG && p.Metadata.Id != 0 && c.features.Has(FeatureHealth) {
+			// Health checks are PING and PONG packets with an ID, whose content is read like that of ACK packets
+			isHealth = true
+			operation = ACK
+		} else if operation == AUTH && c.features.Has(FeatureControl) {
+			// Control packets are AUTH packets, whose content is also read like that of ACK packets
+			isControl = true
+			operation = ACK
+		}
+
+		switch operation {
+		case PING:
+			err = verifyPacket(nil)
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while verifying PING packet signature")
 				packet.Put(p)
-			case STREAM:
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+			c.Logger().Debug().Msg("PING Packet received by read loop, sending back PONG packet")
+			if c.recorder != nil {
+				c.recorder.RecordRead(p)
+			}
+			err = c.writeWith(PONGPacket, c.idling.Load(), nil)
+			if err != nil {
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+			packet.Put(p)
+		case PONG:
+			err = verifyPacket(nil)
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while verifying PONG packet signature")
+				packet.Put(p)
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+			c.Logger().Debug().Msg("PONG Packet received by read loop")
+			if c.recorder != nil {
+				c.recorder.RecordRead(p)
+			}
+			packet.Put(p)
+		case STREAM, STREAMCLOSE, STREAMOPEN:
+			switch p.Metadata.Operation {
+			case STREAMCLOSE:
+				c.Logger().Debug().Msg("STREAMCLOSE Packet received by read loop")
+			case STREAMOPEN:
+				c.Logger().Debug().Msg("STREAMOPEN Packet received by read loop")
+			default:
 				c.Logger().Debug().Msg("STREAM Packet received by read loop")
-				isStream = true
-				c.newStreamHandlerMu.Lock()
-				newStreamHandler = c.newStreamHandler
-				c.newStreamHandlerMu.Unlock()
-				if newStreamHandler != nil || p.Metadata.ContentLength == 0 {
-					c.streamsMu.Lock()
-					stream = c.streams[p.Metadata.Id]
-					c.streamsMu.Unlock()
+			}
+			isStream = true
+			isStreamOpen = p.Metadata.Operation == STREAMOPEN
+			isStreamClose = p.Metadata.Operation == STREAMCLOSE ||
+				(p.Metadata.Operation == STREAM && p.Metadata.ContentLength == 0 && !c.features.Has(FeatureStreamClose))
+			c.newStreamHandlerMu.Lock()
+			newStreamHandler = c.newStreamHandler
+			c.newStreamHandlerMu.Unlock()
+			c.streamsMu.Lock()
+			stream = c.streams[p.Metadata.Id]
+			c.streamsMu.Unlock()
+			fallthrough
+		case REKEY, ACK:
+			isRekey = p.Metadata.Operation == REKEY
+			isAck = p.Metadata.Operation == ACK
+			fallthrough
+		default:
+			if c.options.Filter != nil && p.Metadata.Operation > RESERVED9 {
+				if action := c.options.Filter(*p.Metadata); action != FilterAccept {
+					if !isInline {
+						err = discard(contentLength, nil)
+					}
+					if err == nil && c.signing != nil {
+						err = discard(signatureSize, nil)
+					}
+					packet.Put(p)
+					isInline = false
+					switch {
+					case err != nil:
+					case action == FilterReject:
+						c.Logger().Debug().Err(PacketRejected).Msg("packet rejected by filter, calling closeWithError")
+						err = PacketRejected
+					case action == FilterClose:
+						c.Logger().Debug().Msg("packet rejected by filter, closing connection")
+						c.wg.Done()
+						_ = c.Close()
+						return
+					default:
+						c.Logger().Debug().Msg("packet dropped by filter")
+						continue
+					}
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
 				}
-				fallthrough
-			default:
-				if p.Metadata.ContentLength > 0 {
-					if n-index < int(p.Metadata.ContentLength) {
-						min := int(p.Metadata.ContentLength) - p.Content.Write(buf[index:n])
-						n = 0
-						for cap(buf) < min {
-							buf = append(buf[:cap(buf)], 0)
-						}
-						buf = buf[:cap(buf)]
-						for n < min {
-							var nn int
-							err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
-							if err != nil {
-								c.wg.Done()
-								_ = c.closeWithError(err)
-								return
-							}
-							nn, err = c.conn.Read(buf[n:])
-							n += nn
-							if err != nil {
-								if n < min {
-									c.wg.Done()
-									_ = c.closeWithError(err)
-									return
-								}
-								break
-							}
-						}
-						p.Content.Write(buf[:min])
-						index = min
+			}
+			if !isRekey && !isHealth && c.tracksActivity() {
+				c.markActive()
+			}
+			if c.options.ContentRouter != nil && p.Metadata.Operation > RESERVED9 && !c.compressible(p.Metadata.Operation) && !c.encrypted(p.Metadata.Operation) {
+				if w := c.options.ContentRouter(*p.Metadata); w != nil {
+					routed := &routedWriter{w: w}
+					var signature hash.Hash
+					var sink io.Writer = routed
+					if c.signing != nil {
+						signature = c.readSignature()
+						signature.Write(header)
+						sink = io.MultiWriter(routed, signature)
+					}
+					if isInline {
+						_, _ = routed.Write(*p.Content)
 					} else {
-						index += p.Content.Write(buf[index : index+int(p.Metadata.ContentLength)])
+						err = discard(contentLength, sink)
+					}
+					if err == nil && signature != nil {
+						err = verify(signature)
+					}
+					_ = w.Close()
+					packet.Put(p)
+					isInline = false
+					if err != nil {
+						c.Logger().Debug().Err(err).Msg("error while routing packet content, calling closeWithError")
+						c.wg.Done()
+						_ = c.closeWithError(err)
+						return
 					}
+					continue
 				}
-				if !isStream {
-					err = c.incoming.Push(p)
+			}
+			if !isInline && p.Metadata.ContentLength > 0 {
+				if peeker != nil {
+					err = discard(contentLength, contentWriter{p.Content})
 					if err != nil {
-						c.Logger().Debug().Err(err).Msg("error while pushing to incoming packet queue")
+						packet.Put(p)
 						c.wg.Done()
 						_ = c.closeWithError(err)
 						return
 					}
-				} else {
-					if p.Metadata.ContentLength == 0 {
-						if stream != nil {
-							stream.close()
-							c.streamsMu.Lock()
-							delete(c.streams, p.Metadata.Id)
-							c.streamsMu.Unlock()
-						}
+				} else if maxSize := c.options.ReadBuffer.MaxSize; (large(contentLength) || maxSize > 0 && contentLength > maxSize) && n-index < contentLength {
+					remaining := contentLength - p.Content.Write(buf[index:n])
+					index, n = 0, 0
+					err = c.readContent(p, remaining)
+					if err != nil {
 						packet.Put(p)
-					} else {
-						if newStreamHandler == nil {
-							c.Logger().Debug().Msg("STREAM Packet discarded by read loop")
-							packet.Put(p)
-						} else {
-							if stream == nil {
-								stream = newStream(p.Metadata.Id, c)
-								c.streamsMu.Lock()
-								c.streams[p.Metadata.Id] = stream
-								c.streamsMu.Unlock()
-								go newStreamHandler(stream)
-							}
-							err = stream.queue.Push(p)
-							if err != nil {
-								c.Logger().Debug().Err(err).Msg("error while pushing to a stream queue packet queue")
+						c.wg.Done()
+						_ = c.closeWithError(err)
+						return
+					}
+				} else if n-index < contentLength {
+					min := contentLength - p.Content.Write(buf[index:n])
+					n = 0
+					if min > DefaultBufferSize {
+						grown = time.Now()
+					}
+					for cap(buf) < min {
+						buf = append(buf[:cap(buf)], 0)
+					}
+					buf = buf[:cap(buf)]
+					for n < min {
+						var nn int
+						err = c.extendReadDeadline()
+						if err != nil {
+							c.wg.Done()
+							_ = c.closeWithError(err)
+							return
+						}
+						nn, err = c.read(buf[n:])
+						n += nn
+						if err != nil {
+							if n < min {
 								c.wg.Done()
 								_ = c.closeWithError(err)
 								return
 							}
+							break
 						}
 					}
+					p.Content.Write(buf[:min])
+					index = min
+				} else {
+					index += p.Content.Write(buf[index : index+contentLength])
 				}
-				newStreamHandler = nil
-				stream = nil
-				isStream = false
 			}
-			if n == index {
-				index = 0
-				buf = buf[:cap(buf)]
-				if len(buf) < metadata.Size {
+			if isInline {
+				err = verifyPacket(nil)
+			} else {
+				err = verifyPacket(*p.Content)
+			}
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while verifying packet signature")
+				packet.Put(p)
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+			if p.Metadata.ContentLength > 0 && c.encrypted(p.Metadata.Operation) {
+				err = c.decrypt(p)
+				if err != nil {
+					c.Logger().Debug().Err(err).Msg("error while decrypting packet content")
+					packet.Put(p)
 					c.wg.Done()
-					_ = c.closeWithError(InvalidBufferLength)
+					_ = c.closeWithError(err)
 					return
 				}
-				n = 0
-				for n < metadata.Size {
-					var nn int
-					err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
-					if err != nil {
+			}
+			if p.Metadata.ContentLength > 0 && c.compressible(p.Metadata.Operation) {
+				err = c.decompress(p)
+				if err != nil {
+					c.Logger().Debug().Err(err).Msg("error while decompressing packet content")
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			}
+			if c.recorder != nil {
+				c.recorder.RecordRead(p)
+			}
+			if isRekey {
+				c.Logger().Debug().Msg("REKEY Packet received by read loop")
+				err = c.rotateRead(p)
+				packet.Put(p)
+				if err != nil {
+					c.Logger().Debug().Err(err).Msg("error while rotating read keys")
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			} else if isHealth {
+				c.Logger().Debug().Msg("health check Packet received by read loop")
+				err = c.handleHealth(p)
+				packet.Put(p)
+				if err != nil {
+					c.Logger().Debug().Err(err).Msg("error while answering health check")
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			} else if isControl {
+				c.Logger().Debug().Uint16("Control Operation", p.Metadata.Id).Msg("control Packet received by read loop")
+				c.handleControl(p)
+				packet.Put(p)
+			} else if isAck {
+				c.Logger().Debug().Msg("ACK Packet received by read loop")
+				c.acknowledged(p)
+				packet.Put(p)
+			} else if delivery != 0 && c.duplicate(delivery) {
+				c.Logger().Debug().Uint64("Delivery", delivery).Msg("duplicate packet dropped by read loop")
+				packet.Put(p)
+			} else if !isStream {
+				c.readPhase.Store(readPhaseQueueing)
+				err = c.incoming.Push(p)
+				c.readPhase.Store(readPhaseReading)
+				if err != nil {
+					c.Logger().Debug().Err(err).Msg("error while pushing to incoming packet queue")
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			} else {
+				if isStreamClose {
+					if stream != nil {
+						stream.closeRemote(p, c.features)
+						c.streamsMu.Lock()
+						delete(c.streams, p.Metadata.Id)
+						c.streamsMu.Unlock()
+					}
+					packet.Put(p)
+				} else if isStreamOpen {
+					if p.Metadata.ContentLength != 1 || StreamMode((*p.Content)[0]) > ByteMode {
+						c.Logger().Debug().Err(InvalidStreamMode).Msg("error while opening stream")
+						packet.Put(p)
 						c.wg.Done()
-						_ = c.closeWithError(err)
+						_ = c.closeWithError(InvalidStreamMode)
 						return
 					}
-					nn, err = c.conn.Read(buf[n:])
-					n += nn
-					if err != nil {
-						if n < metadata.Size {
+					if stream == nil && newStreamHandler == nil {
+						c.Logger().Debug().Msg("STREAMOPEN Packet discarded by read loop")
+					} else if stream == nil {
+						stream = newStream(p.Metadata.Id, c, StreamMode((*p.Content)[0]))
+						c.streamsMu.Lock()
+						c.streams[p.Metadata.Id] = stream
+						c.streamsMu.Unlock()
+						go newStreamHandler(stream)
+					} else if stream.local && stream.mode != StreamMode((*p.Content)[0]) {
+						stream, err = c.resolveStreamOpen(stream, StreamMode((*p.Content)[0]))
+						if err != nil {
+							c.Logger().Debug().Err(err).Msg("error while resolving stream opened by both peers")
+							packet.Put(p)
 							c.wg.Done()
 							_ = c.closeWithError(err)
 							return
 						}
-						break
-					}
-				}
-			} else if n-index < metadata.Size {
-				copy(buf, buf[index:n])
-				n -= index
-				index = n
-
-				buf = buf[:cap(buf)]
-				min := metadata.Size - index
-				if len(buf) < min {
-					c.wg.Done()
-					_ = c.closeWithError(InvalidBufferLength)
-					return
-				}
-				n = 0
-				for n < min {
-					var nn int
-					err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
-					if err != nil {
-						c.wg.Done()
-						_ = c.closeWithError(err)
-						return
+						if stream != nil && newStreamHandler != nil {
+							go newStreamHandler(stream)
+						}
 					}
-					nn, err = c.conn.Read(buf[index+n:])
-					n += nn
-					if err != nil {
-						if n < min {
+					packet.Put(p)
+				} else {
+					if stream == nil && newStreamHandler == nil {
+						c.Logger().Debug().Msg("STREAM Packet discarded by read loop")
+						packet.Put(p)
+					} else {
+						if stream == nil {
+							stream = newStream(p.Metadata.Id, c, MessageMode)
+							c.streamsMu.Lock()
+							c.streams[p.Metadata.Id] = stream
+							c.streamsMu.Unlock()
+							go newStreamHandler(stream)
+						}
+						if p.Metadata.ContentLength > 0 && c.features.Has(FeatureStreamCompression) {
+							err = stream.decompressStream(p)
+							if err != nil {
+								c.Logger().Debug().Err(err).Msg("error while decompressing stream packet content")
+								packet.Put(p)
+								c.wg.Done()
+								_ = c.closeWithError(err)
+								return
+							}
+						}
+						c.readPhase.Store(readPhaseStreaming)
+						err = stream.push(p)
+						c.readPhase.Store(readPhaseReading)
+						if err != nil {
+							c.Logger().Debug().Err(err).Msg("error while pushing to a stream queue packet queue")
 							c.wg.Done()
 							_ = c.closeWithError(err)
 							return
 						}
-						break
 					}
 				}
-				n += index
-				index = 0
 			}
+			if delivery != 0 {
+				err = c.acknowledge(delivery)
+				if err != nil {
+					c.Logger().Debug().Err(err).Msg("error while acknowledging packet")
+					c.wg.Done()
+					_ = c.closeWithError(err)
+					return
+				}
+			}
+			newStreamHandler = nil
+			stream = nil
+			isStream = false
+			isStreamClose = false
+			isStreamOpen = false
+			isRekey = false
+			isAck = false
+			isHealth = false
+			isControl = false
+			isInline = false
 		}
 	}
 }
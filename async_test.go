@@ -19,6 +19,7 @@ package frisbee
 import (
 	"crypto/rand"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/frisbee-go/pkg/recorder"
 	"github.com/loopholelabs/polyglot"
 	"github.com/loopholelabs/testing/conn/pair"
 	"github.com/rs/zerolog"
@@ -427,6 +428,56 @@ func TestAsyncTimeout(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAsyncRecorder(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	readerRecorder := recorder.New(0)
+	writerRecorder := recorder.New(0)
+
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithRecorder(readerRecorder)), NoFeatures)
+	writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), WithRecorder(writerRecorder)), NoFeatures)
+
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte("recorded"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+
+	err := writerConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	packet.Put(p)
+
+	find := func(frames []recorder.Frame, direction recorder.Direction) *recorder.Frame {
+		for i := range frames {
+			if frames[i].Direction == direction && frames[i].Metadata.Operation == 32 {
+				return &frames[i]
+			}
+		}
+		return nil
+	}
+
+	written := find(writerRecorder.Frames(), recorder.Outbound)
+	require.NotNil(t, written)
+	read := find(readerRecorder.Frames(), recorder.Inbound)
+	require.NotNil(t, read)
+	assert.Equal(t, written.Metadata, read.Metadata)
+	assert.Equal(t, []byte("recorded"), read.Content)
+	assert.Equal(t, written.Content, read.Content)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
 func BenchmarkAsyncThroughputPipe(b *testing.B) {
 	const testSize = 100
 
@@ -573,3 +624,53 @@ func BenchmarkAsyncThroughputNetworkMultiple(b *testing.B) {
 	b.Run("CPU Pair, 4096 Bytes", runner(runtime.NumCPU(), 4096))
 	b.Run("Double CPU Pair, 4096 Bytes", runner(runtime.NumCPU()*2, 4096))
 }
+
+func TestAsyncLazyStart(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	flushStarted := func(c *Async) bool {
+		c.Lock()
+		defer c.Unlock()
+		return c.flushStarted
+	}
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithLazyStart(), WithLiveness(Liveness{PingInterval: -1})), NoFeatures)
+	writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), WithLiveness(Liveness{PingInterval: -1})), NoFeatures)
+	assert.False(t, flushStarted(readerConn))
+	assert.True(t, flushStarted(writerConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	err = writerConn.WritePacket(p)
+	require.NoError(t, err)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32), p.Metadata.Operation)
+	assert.False(t, flushStarted(readerConn))
+
+	err = readerConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+	assert.True(t, flushStarted(readerConn))
+
+	p, err = writerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32), p.Metadata.Operation)
+	packet.Put(p)
+
+	// Connections that never wrote a packet can be closed without their flush loop
+	unused, peer, err := pair.New()
+	require.NoError(t, err)
+	unusedConn := newAsync(unused, loadOptions(WithLogger(&emptyLogger), WithLazyStart()), NoFeatures)
+	assert.NoError(t, unusedConn.Close())
+	_ = peer.Close()
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+}
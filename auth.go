@@ -0,0 +1,136 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/handshake"
+)
+
+// authChallengeSize is the size of the challenges sent by HMACVerifier
+const authChallengeSize = 32
+
+// AuthExchange is used by an Authenticator and a Verifier to exchange messages with each other during
+// the authentication handshake. Messages are sent as AUTH packets and can be at most 4KB in size.
+type AuthExchange = handshake.AuthExchange
+
+// Authenticator performs the client side of the authentication handshake, which happens right after the client
+// connects (and after the HELLO handshake, if it is enabled) and before any other packets are sent.
+type Authenticator func(exchange AuthExchange) error
+
+// Verifier performs the server side of the authentication handshake and returns the authenticated identity of the peer,
+// which is used as the PeerID of the connection. If an error is returned the connection is rejected before any other
+// packets are read from it.
+type Verifier func(exchange AuthExchange) (identity string, err error)
+
+// TokenAuthenticator returns an Authenticator that sends the given token to the server
+func TokenAuthenticator(token []byte) Authenticator {
+	return func(exchange AuthExchange) error {
+		return exchange.Send(token)
+	}
+}
+
+// TokenVerifier returns a Verifier that receives a token from the client (see TokenAuthenticator)
+// and uses verify to check it and return the identity of the peer
+func TokenVerifier(verify func(token []byte) (identity string, err error)) Verifier {
+	return func(exchange AuthExchange) (string, error) {
+		token, err := exchange.Receive()
+		if err != nil {
+			return "", err
+		}
+		return verify(token)
+	}
+}
+
+// HMACAuthenticator returns an Authenticator that proves that the client holds the key of the given identity
+// without sending the key, by responding to a random challenge from the server with its HMAC-SHA256
+func HMACAuthenticator(identity string, key []byte) Authenticator {
+	return func(exchange AuthExchange) error {
+		err := exchange.Send([]byte(identity))
+		if err != nil {
+			return err
+		}
+		challenge, err := exchange.Receive()
+		if err != nil {
+			return err
+		}
+		return exchange.Send(authHMAC(key, challenge))
+	}
+}
+
+// HMACVerifier returns a Verifier for clients using HMACAuthenticator, which uses lookup to find
+// the key of the identity claimed by the client
+func HMACVerifier(lookup func(identity string) (key []byte, err error)) Verifier {
+	return func(exchange AuthExchange) (string, error) {
+		identity, err := exchange.Receive()
+		if err != nil {
+			return "", err
+		}
+		key, err := lookup(string(identity))
+		if err != nil {
+			return "", err
+		}
+		challenge := make([]byte, authChallengeSize)
+		if _, err = rand.Read(challenge); err != nil {
+			return "", err
+		}
+		err = exchange.Send(challenge)
+		if err != nil {
+			return "", err
+		}
+		response, err := exchange.Receive()
+		if err != nil {
+			return "", err
+		}
+		if !hmac.Equal(response, authHMAC(key, challenge)) {
+			return "", AuthenticationFailed
+		}
+		return string(identity), nil
+	}
+}
+
+func authHMAC(key []byte, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// authenticateInitiate performs the client side of the authentication handshake on conn
+func authenticateInitiate(conn net.Conn, authenticator Authenticator) error {
+	_ = conn.SetDeadline(time.Now().Add(DefaultDeadline))
+	defer func() {
+		_ = conn.SetDeadline(emptyTime)
+	}()
+
+	return handshake.Authenticate(conn, authenticator)
+}
+
+// authenticateAccept performs the server side of the authentication handshake on conn,
+// and returns the authenticated identity of the peer
+func authenticateAccept(conn net.Conn, verifier Verifier) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(DefaultDeadline))
+	defer func() {
+		_ = conn.SetDeadline(emptyTime)
+	}()
+
+	return handshake.Verify(conn, verifier)
+}
@@ -0,0 +1,134 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthentication(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	keys := map[string][]byte{"device": []byte("device key")}
+	unknownIdentity := errors.New("unknown identity")
+	invalidToken := errors.New("invalid token")
+
+	verifiers := map[string]Verifier{
+		"hmac": HMACVerifier(func(identity string) ([]byte, error) {
+			if key, ok := keys[identity]; ok {
+				return key, nil
+			}
+			return nil, unknownIdentity
+		}),
+		"token": TokenVerifier(func(token []byte) (string, error) {
+			if string(token) == "device key" {
+				return "device", nil
+			}
+			return "", invalidToken
+		}),
+	}
+	authenticators := map[string][2]Authenticator{
+		"hmac":  {HMACAuthenticator("device", []byte("device key")), HMACAuthenticator("device", []byte("wrong key"))},
+		"token": {TokenAuthenticator([]byte("device key")), TokenAuthenticator([]byte("wrong key"))},
+	}
+
+	for name, verifier := range verifiers {
+		name, verifier := name, verifier
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			serverHandlerTable := make(HandlerTable)
+			serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+				incoming.Metadata.Operation = metadata.PacketPong
+				outgoing = incoming
+				return
+			}
+			s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			assert.ErrorIs(t, s.SetVerifier(nil), VerifierNil)
+			require.NoError(t, s.SetVerifier(verifier))
+
+			go func() {
+				_ = s.Start(conn.Listen)
+			}()
+			<-s.started()
+			addr := s.listener.Addr().String()
+
+			received := make(chan struct{}, 1)
+			clientHandlerTable := make(HandlerTable)
+			clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+				received <- struct{}{}
+				return
+			}
+			c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithAuthenticator(authenticators[name][0]))
+			require.NoError(t, err)
+			err = c.Connect(addr)
+			require.NoError(t, err)
+
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			err = c.WritePacket(p)
+			require.NoError(t, err)
+			packet.Put(p)
+			<-received
+
+			serverConn := s.Connection("device")
+			require.NotNil(t, serverConn)
+			assert.Equal(t, "device", serverConn.PeerID())
+			assert.NoError(t, c.Close())
+
+			rejected, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithAuthenticator(authenticators[name][1]))
+			require.NoError(t, err)
+			err = rejected.Connect(addr)
+			assert.ErrorIs(t, err, AuthenticationFailed)
+
+			// Clients that do not authenticate are closed before any of their packets are handled
+			unauthenticated, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			err = unauthenticated.Connect(addr)
+			require.NoError(t, err)
+			p = packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			_ = unauthenticated.WritePacket(p)
+			packet.Put(p)
+			select {
+			case <-unauthenticated.CloseChannel():
+			case <-time.After(DefaultDeadline):
+				t.Fatal("unauthenticated client was not closed")
+			}
+			select {
+			case <-received:
+				t.Fatal("packet from unauthenticated client was handled")
+			default:
+			}
+
+			assert.NoError(t, s.Shutdown())
+		})
+	}
+}
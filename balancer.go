@@ -0,0 +1,438 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.uber.org/atomic"
+)
+
+const (
+	// DefaultBalancerInterval is the default interval at which a Balancer reconnects to unhealthy backends
+	DefaultBalancerInterval = time.Second
+
+	// balancerReplicas is the number of points that every backend has on the hash ring of a Balancer
+	balancerReplicas = 64
+)
+
+// BalancePolicy decides which backend a Balancer sends a request to
+type BalancePolicy uint8
+
+const (
+	// RoundRobin sends requests to the healthy backends in turn
+	RoundRobin BalancePolicy = iota
+
+	// LeastPending sends requests to the healthy backend with the fewest requests that are waiting for a response
+	LeastPending
+
+	// ConsistentHash sends requests with the same key to the same backend for as long as it is healthy, and
+	// only moves the keys of a backend when it becomes unhealthy or is removed (see Balancer.Do)
+	ConsistentHash
+)
+
+func (p BalancePolicy) String() string {
+	switch p {
+	case RoundRobin:
+		return "round-robin"
+	case LeastPending:
+		return "least-pending"
+	case ConsistentHash:
+		return "consistent-hash"
+	}
+	return "unknown"
+}
+
+// BalancerConfig configures a Balancer
+type BalancerConfig struct {
+	// Policy decides which backend requests are sent to (defaults to RoundRobin)
+	Policy BalancePolicy
+
+	// Interval is how often the Balancer tries to reconnect to unhealthy backends (defaults to DefaultBalancerInterval)
+	Interval time.Duration
+
+	// ResolveInterval is how often the Balancer polls its Resolver for the addresses of the backends, unless the Resolver
+	// is a Watcher (defaults to DefaultResolveInterval)
+	ResolveInterval time.Duration
+}
+
+func (c BalancerConfig) withDefaults() BalancerConfig {
+	if c.Interval <= 0 {
+		c.Interval = DefaultBalancerInterval
+	}
+	if c.ResolveInterval <= 0 {
+		c.ResolveInterval = DefaultResolveInterval
+	}
+	return c
+}
+
+// BackendStatus describes a backend of a Balancer
+type BackendStatus struct {
+	// Addr is the address of the backend
+	Addr string
+
+	// Healthy is whether the Balancer is connected to the backend
+	Healthy bool
+
+	// Pending is the number of requests to the backend that are waiting for a response
+	Pending int64
+}
+
+// backend is a server that a Balancer connects to
+type backend struct {
+	addr    string
+	client  *Client
+	pending *atomic.Int64
+	removed bool
+}
+
+// ringPoint is a point on the hash ring of a Balancer
+type ringPoint struct {
+	hash    uint64
+	backend *backend
+}
+
+// Balancer maintains connections to a set of backend servers and spreads requests between them according to its
+// BalancePolicy. Backends whose connection fails are taken out of rotation, and are added back once the Balancer
+// has reconnected to them. If the FeatureHealth feature is negotiated with a backend, it is also health checked every
+// BalancerConfig.Interval, and taken out of rotation unless it reports that it is serving.
+type Balancer struct {
+	mu           sync.RWMutex
+	handlerTable HandlerTable
+	ctx          context.Context
+	config       BalancerConfig
+	opts         []Option
+	logger       *zerolog.Logger
+	backends     []*backend
+	ring         []ringPoint
+	next         *atomic.Uint64
+	wake         chan struct{}
+	closed       chan struct{}
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewBalancer returns a new Balancer that connects to the given backend addresses with Clients created using the given
+// HandlerTable and Options. Backends that cannot be reached are retried in the background.
+func NewBalancer(handlerTable HandlerTable, ctx context.Context, addrs []string, config BalancerConfig, opts ...Option) (*Balancer, error) {
+	if len(addrs) == 0 {
+		return nil, NoAddresses
+	}
+	if _, err := NewClient(handlerTable, ctx, opts...); err != nil {
+		return nil, err
+	}
+	return newBalancer(handlerTable, ctx, addrs, config, opts...), nil
+}
+
+// newBalancer returns a new Balancer for the given backend addresses and starts maintaining its connections
+func newBalancer(handlerTable HandlerTable, ctx context.Context, addrs []string, config BalancerConfig, opts ...Option) *Balancer {
+	b := &Balancer{
+		handlerTable: handlerTable,
+		ctx:          ctx,
+		config:       config.withDefaults(),
+		opts:         opts,
+		logger:       loadOptions(opts...).Logger,
+		next:         atomic.NewUint64(0),
+		wake:         make(chan struct{}, 1),
+		closed:       make(chan struct{}),
+	}
+	b.SetBackends(addrs)
+	b.reconnect()
+	b.wg.Add(1)
+	go b.maintain()
+	return b
+}
+
+// Logger returns the logger of the Balancer
+func (b *Balancer) Logger() *zerolog.Logger {
+	return b.logger
+}
+
+// SetBackends replaces the backends of the Balancer. The connections to the backends that are still in addrs are
+// kept, the connections to the backends that are not are closed, and new backends are connected to in the background.
+func (b *Balancer) SetBackends(addrs []string) {
+	b.mu.Lock()
+	existing := make(map[string]*backend, len(b.backends))
+	for _, backend := range b.backends {
+		existing[backend.addr] = backend
+	}
+	seen := make(map[string]bool, len(addrs))
+	backends := make([]*backend, 0, len(addrs))
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		current, ok := existing[addr]
+		if !ok {
+			current = &backend{addr: addr, pending: atomic.NewInt64(0)}
+		}
+		backends = append(backends, current)
+	}
+	for _, current := range b.backends {
+		if !seen[current.addr] {
+			current.removed = true
+			if current.client != nil {
+				_ = current.client.Close()
+				current.client = nil
+			}
+		}
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].addr < backends[j].addr
+	})
+	b.backends = backends
+	b.ring = b.ring[:0]
+	for _, backend := range backends {
+		for i := 0; i < balancerReplicas; i++ {
+			b.ring = append(b.ring, ringPoint{hash: hashKey([]byte(backend.addr + "#" + strconv.Itoa(i))), backend: backend})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i].hash < b.ring[j].hash
+	})
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Backends returns the status of the backends of the Balancer
+func (b *Balancer) Backends() []BackendStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	statuses := make([]BackendStatus, 0, len(b.backends))
+	for _, backend := range b.backends {
+		statuses = append(statuses, BackendStatus{
+			Addr:    backend.addr,
+			Healthy: backend.client != nil,
+			Pending: backend.pending.Load(),
+		})
+	}
+	return statuses
+}
+
+// Client returns the Client of the backend that a request with the given key would be sent to (the key
+// is only used by the ConsistentHash policy), or NoHealthyBackends if no backends are healthy
+func (b *Balancer) Client(key []byte) (*Client, error) {
+	backend, client := b.pick(key)
+	if backend == nil {
+		return nil, NoHealthyBackends
+	}
+	return client, nil
+}
+
+// Do calls f with the Client of the backend that a request with the given key should be sent to (the key is only
+// used by the ConsistentHash policy), and counts the request as pending until f returns. Requests are usually made
+// by calling rpc.Call with the Client (see the rpc package), and NoHealthyBackends is returned if no backends are healthy.
+func (b *Balancer) Do(key []byte, f func(client *Client) error) error {
+	backend, client := b.pick(key)
+	if backend == nil {
+		return NoHealthyBackends
+	}
+	backend.pending.Inc()
+	defer backend.pending.Dec()
+	return f(client)
+}
+
+// Close closes the connections to all the backends of the Balancer
+func (b *Balancer) Close() error {
+	select {
+	case <-b.closed:
+		return nil
+	default:
+	}
+	close(b.closed)
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, backend := range b.backends {
+		backend.removed = true
+		if backend.client != nil {
+			_ = backend.client.Close()
+			backend.client = nil
+		}
+	}
+	return nil
+}
+
+// pick returns the backend that a request with the given key should be sent to, along with its Client
+func (b *Balancer) pick(key []byte) (*backend, *Client) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.backends) == 0 {
+		return nil, nil
+	}
+	var picked *backend
+	switch {
+	case b.config.Policy == ConsistentHash && key != nil:
+		hash := hashKey(key)
+		start := sort.Search(len(b.ring), func(i int) bool {
+			return b.ring[i].hash >= hash
+		})
+		for i := 0; i < len(b.ring); i++ {
+			point := b.ring[(start+i)%len(b.ring)]
+			if point.backend.client != nil {
+				picked = point.backend
+				break
+			}
+		}
+	case b.config.Policy == LeastPending:
+		start := int(b.next.Inc() % uint64(len(b.backends)))
+		for i := 0; i < len(b.backends); i++ {
+			backend := b.backends[(start+i)%len(b.backends)]
+			if backend.client != nil && (picked == nil || backend.pending.Load() < picked.pending.Load()) {
+				picked = backend
+			}
+		}
+	default:
+		start := int(b.next.Inc() % uint64(len(b.backends)))
+		for i := 0; i < len(b.backends); i++ {
+			backend := b.backends[(start+i)%len(b.backends)]
+			if backend.client != nil {
+				picked = backend
+				break
+			}
+		}
+	}
+	if picked == nil {
+		return nil, nil
+	}
+	return picked, picked.client
+}
+
+// maintain reconnects to the unhealthy backends of the Balancer until it is closed
+func (b *Balancer) maintain() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+			b.check()
+		case <-b.wake:
+		}
+		b.reconnect()
+	}
+}
+
+// check health checks the healthy backends, and takes the ones that fail out of rotation
+func (b *Balancer) check() {
+	b.mu.RLock()
+	clients := make([]*Client, 0, len(b.backends))
+	for _, backend := range b.backends {
+		if backend.client != nil {
+			clients = append(clients, backend.client)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, client := range clients {
+		if !b.healthy(client) {
+			_ = client.Close()
+		}
+	}
+}
+
+// healthy health checks the backend of the given Client if the FeatureHealth feature was negotiated,
+// and returns whether it is serving
+func (b *Balancer) healthy(client *Client) bool {
+	if !client.Features().Has(FeatureHealth) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, b.config.Interval)
+	defer cancel()
+	health, err := client.HealthCheck(ctx)
+	if err != nil || health.Status != HealthServing {
+		b.Logger().Debug().Err(err).Str("Status", health.Status.String()).Str("Backend", client.conn.RemoteAddr().String()).Msg("backend failed health check")
+		return false
+	}
+	return true
+}
+
+// reconnect connects to the backends that are unhealthy
+func (b *Balancer) reconnect() {
+	b.mu.RLock()
+	var unhealthy []*backend
+	for _, backend := range b.backends {
+		if backend.client == nil {
+			unhealthy = append(unhealthy, backend)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, backend := range unhealthy {
+		client, err := NewClient(b.handlerTable, b.ctx, b.opts...)
+		if err == nil {
+			err = client.Connect(backend.addr)
+		}
+		if err != nil {
+			b.Logger().Debug().Err(err).Str("Backend", backend.addr).Msg("error while connecting to backend")
+			continue
+		}
+		if !b.healthy(client) {
+			_ = client.Close()
+			continue
+		}
+		b.mu.Lock()
+		if backend.removed || backend.client != nil {
+			b.mu.Unlock()
+			_ = client.Close()
+			continue
+		}
+		backend.client = client
+		b.mu.Unlock()
+		b.Logger().Debug().Str("Backend", backend.addr).Msg("backend is healthy")
+		go b.watch(backend, client)
+	}
+}
+
+// watch takes the backend out of rotation once the connection of its Client is closed
+func (b *Balancer) watch(backend *backend, client *Client) {
+	<-client.CloseChannel()
+	b.mu.Lock()
+	if backend.client == client {
+		backend.client = nil
+		b.Logger().Debug().Str("Backend", backend.addr).Msg("backend is unhealthy")
+	}
+	b.mu.Unlock()
+	_ = client.Close()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// hashKey hashes the given key onto the hash ring of a Balancer
+func hashKey(key []byte) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write(key)
+	return hash.Sum64()
+}
@@ -0,0 +1,170 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBalancer(t *testing.T) {
+	t.Parallel()
+
+	const op = 10
+	const requests = 8
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	// start starts a backend that responds with its name
+	start := func(name string, handled *atomic.Int32) *Server {
+		server, err := NewServer(HandlerTable{
+			op: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+				handled.Inc()
+				incoming.Content.Reset()
+				incoming.Content.Write([]byte(name))
+				incoming.Metadata.ContentLength = uint32(len(name))
+				return incoming, NONE
+			},
+		}, WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		go func() {
+			_ = server.Start(conn.Listen)
+		}()
+		<-server.started()
+		t.Cleanup(func() {
+			_ = server.Shutdown()
+		})
+		return server
+	}
+
+	firstHandled, secondHandled, thirdHandled := atomic.NewInt32(0), atomic.NewInt32(0), atomic.NewInt32(0)
+	first, second := start("first", firstHandled), start("second", secondHandled)
+	addrs := []string{first.listener.Addr().String(), second.listener.Addr().String()}
+
+	_, err := NewBalancer(HandlerTable{}, context.Background(), nil, BalancerConfig{})
+	assert.ErrorIs(t, err, NoAddresses)
+
+	// The backends respond with their name, which is passed to call by the handler table of the balancer
+	responses := make(chan string, 1)
+	handlerTable := HandlerTable{
+		op: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			responses <- string(*incoming.Content)
+			return nil, NONE
+		},
+	}
+
+	call := func(balancer *Balancer, key []byte) string {
+		var name string
+		require.NoError(t, balancer.Do(key, func(client *Client) error {
+			request := packet.Get()
+			request.Metadata.Operation = op
+			err := client.WritePacket(request)
+			packet.Put(request)
+			if err != nil {
+				return err
+			}
+			name = <-responses
+			return nil
+		}))
+		return name
+	}
+
+	t.Run("round-robin", func(t *testing.T) {
+		balancer, err := NewBalancer(handlerTable, context.Background(), addrs, BalancerConfig{Policy: RoundRobin}, WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, balancer.Close())
+		}()
+
+		firstBefore, secondBefore := firstHandled.Load(), secondHandled.Load()
+		for i := 0; i < requests; i++ {
+			call(balancer, nil)
+		}
+		assert.Equal(t, int32(requests/2), firstHandled.Load()-firstBefore)
+		assert.Equal(t, int32(requests/2), secondHandled.Load()-secondBefore)
+	})
+
+	t.Run("consistent-hash", func(t *testing.T) {
+		balancer, err := NewBalancer(handlerTable, context.Background(), addrs, BalancerConfig{Policy: ConsistentHash}, WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, balancer.Close())
+		}()
+
+		key := []byte("key")
+		backend := call(balancer, key)
+		for i := 0; i < requests; i++ {
+			assert.Equal(t, backend, call(balancer, key))
+		}
+	})
+
+	t.Run("health", func(t *testing.T) {
+		third := start("third", thirdHandled)
+		balancer, err := NewBalancer(handlerTable, context.Background(), []string{addrs[0], third.listener.Addr().String()}, BalancerConfig{Policy: LeastPending}, WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, balancer.Close())
+		}()
+
+		for _, status := range balancer.Backends() {
+			assert.True(t, status.Healthy)
+		}
+
+		// Backends that shut down are taken out of rotation
+		require.NoError(t, third.Shutdown())
+		require.Eventually(t, func() bool {
+			for _, status := range balancer.Backends() {
+				if status.Addr == third.listener.Addr().String() {
+					return !status.Healthy
+				}
+			}
+			return false
+		}, DefaultDeadline, time.Millisecond)
+		for i := 0; i < requests; i++ {
+			assert.Equal(t, "first", call(balancer, nil))
+		}
+
+		// New backends are added back into rotation once they are connected to
+		balancer.SetBackends(addrs)
+		require.Eventually(t, func() bool {
+			statuses := balancer.Backends()
+			return len(statuses) == 2 && statuses[0].Healthy && statuses[1].Healthy
+		}, DefaultDeadline, time.Millisecond)
+
+		require.NoError(t, first.Shutdown())
+		require.Eventually(t, func() bool {
+			_, err := balancer.Client(nil)
+			return err == nil && balancer.Backends()[0].Healthy != balancer.Backends()[1].Healthy
+		}, DefaultDeadline, time.Millisecond)
+		assert.Equal(t, "second", call(balancer, nil))
+
+		require.NoError(t, second.Shutdown())
+		require.Eventually(t, func() bool {
+			_, err := balancer.Client(nil)
+			return err == NoHealthyBackends
+		}, DefaultDeadline, time.Millisecond)
+	})
+}
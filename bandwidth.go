@@ -0,0 +1,89 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"time"
+)
+
+// Bandwidth is a rate limit of BytesPerSecond bytes per second on average, with bursts of up to Burst bytes
+// (which defaults to BytesPerSecond). A BytesPerSecond of 0 means that the bandwidth is not limited.
+type Bandwidth struct {
+	BytesPerSecond int
+	Burst          int
+}
+
+// throttle returns a new Throttle for the bandwidth, or nil if the bandwidth is not limited
+func (b Bandwidth) throttle() *Throttle {
+	if b.BytesPerSecond <= 0 {
+		return nil
+	}
+	return NewThrottle(b.BytesPerSecond, b.Burst)
+}
+
+// SetReadThrottle limits the rate at which bytes are read from the underlying connection using the given Throttle
+// (use nil to remove the limit). Since the limit applies to the bytes on the connection, it includes the metadata
+// of packets and the packets of streams. A Throttle can be shared between many connections to limit their aggregate
+// bandwidth.
+func (c *Async) SetReadThrottle(t *Throttle) {
+	c.readThrottle.Store(t)
+}
+
+// SetWriteThrottle limits the rate at which bytes are flushed to the underlying connection using the given Throttle
+// (use nil to remove the limit). Writes that fill up the write buffer block until it has been flushed, so a slow
+// Throttle applies backpressure to the writers of the connection. A Throttle can be shared between many connections
+// to limit their aggregate bandwidth.
+func (c *Async) SetWriteThrottle(t *Throttle) {
+	c.writeThrottle.Store(t)
+}
+
+// throttleRead waits until the read Throttle (if there is one) allows n bytes that have been read from the connection
+func (c *Async) throttleRead(n int) {
+	if t := c.readThrottle.Load(); t != nil && n > 0 {
+		t.waitN(n)
+	}
+}
+
+// read reads from the underlying connection, subject to the read Throttle
+func (c *Async) read(b []byte) (int, error) {
+	n, err := c.conn.Read(b)
+	c.throttleRead(n)
+	return n, directed("read", err)
+}
+
+// writeThrottled writes b to the underlying connection in chunks that are allowed by the Throttle t, extending
+// the write deadline of the connection before every chunk so that waiting for the Throttle does not cause a timeout
+func (w *writeCounter) writeThrottled(t *Throttle, b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		size := len(b) - written
+		if size > t.burst {
+			size = t.burst
+		}
+		t.wait(size)
+		err := w.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+		if err != nil {
+			return written, err
+		}
+		n, err := w.conn.Write(b[written : written+size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,92 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncBandwidth(t *testing.T) {
+	t.Parallel()
+
+	const packetSize = 1 << 12
+	const packets = 8
+
+	emptyLogger := zerolog.New(io.Discard)
+	bandwidth := Bandwidth{BytesPerSecond: 1 << 15, Burst: 1 << 12}
+
+	// transfer writes packets from the writer to the reader, and returns how long it took
+	transfer := func(t *testing.T, readerConn *Async, writerConn *Async) time.Duration {
+		content := make([]byte, packetSize)
+		start := time.Now()
+		for i := 0; i < packets; i++ {
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			p.Content.Write(content)
+			p.Metadata.ContentLength = packetSize
+			require.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint32(packetSize), p.Metadata.ContentLength)
+			packet.Put(p)
+		}
+		return time.Since(start)
+	}
+
+	t.Run("read", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer := net.Pipe()
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithBandwidth(bandwidth, Bandwidth{})), NoFeatures)
+		writerConn := NewAsync(writer, &emptyLogger)
+
+		assert.GreaterOrEqual(t, transfer(t, readerConn, writerConn), time.Millisecond*500)
+
+		readerConn.SetReadThrottle(nil)
+		assert.Less(t, transfer(t, readerConn, writerConn), time.Millisecond*500)
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+
+	t.Run("write", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer := net.Pipe()
+		readerConn := NewAsync(reader, &emptyLogger)
+		writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), WithBandwidth(Bandwidth{}, bandwidth)), NoFeatures)
+
+		assert.GreaterOrEqual(t, transfer(t, readerConn, writerConn), time.Millisecond*500)
+
+		writerConn.SetWriteThrottle(nil)
+		assert.Less(t, transfer(t, readerConn, writerConn), time.Millisecond*500)
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// WriteErrors is returned by Server.Broadcast and Server.WriteTo when a packet could not be written to
+// some of the connections, and maps the ID of each of those connections to the error that occurred
+type WriteErrors map[uint64]error
+
+func (e WriteErrors) Error() string {
+	return fmt.Sprintf("packet could not be written to %d connection(s)", len(e))
+}
+
+// Broadcast writes the packet p to every active connection of the server.
+//
+// The metadata of the packet is only encoded once and is shared between all the connections that
+// do not require their own encoding (because compression, encryption or extended headers were negotiated for them).
+// If the packet could not be written to some of the connections, a WriteErrors is returned and the packet
+// is still written to all the other connections.
+//
+// Servers that broadcast many packets can use a WriteCoalescer (see the WithWriteCoalescer option) so that
+// every connection is flushed once per tick instead of once per packet.
+func (s *Server) Broadcast(p *packet.Packet) error {
+	s.connectionsMu.Lock()
+	conns := make([]*Async, 0, len(s.connections))
+	for _, c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.connectionsMu.Unlock()
+	return writeMany(conns, p, nil)
+}
+
+// WriteTo writes the packet p to the active connections of the server with the given IDs (see Async.ID)
+// in the same way as Broadcast. IDs that do not belong to an active connection are reported as
+// UnknownConnection errors in the returned WriteErrors.
+func (s *Server) WriteTo(connIDs []uint64, p *packet.Packet) error {
+	var errs WriteErrors
+	conns := make([]*Async, 0, len(connIDs))
+	s.connectionsMu.Lock()
+	for _, id := range connIDs {
+		if c, ok := s.connections[id]; ok {
+			conns = append(conns, c)
+		} else {
+			if errs == nil {
+				errs = make(WriteErrors)
+			}
+			errs[id] = UnknownConnection
+		}
+	}
+	s.connectionsMu.Unlock()
+	return writeMany(conns, p, errs)
+}
+
+// writeMany writes the packet p to every connection in conns, adding any errors to errs
+func writeMany(conns []*Async, p *packet.Packet, errs WriteErrors) error {
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if !validContentLength(p) {
+		return InvalidContentLength
+	}
+
+	content := *p.Content
+	var encodedMetadata *metadata.Buffer
+	for _, c := range conns {
+		var err error
+		if c.compressible(p.Metadata.Operation) || c.encrypted(p.Metadata.Operation) || c.extended() || large(len(content)) {
+			err = c.write(p)
+		} else {
+			if encodedMetadata == nil {
+				encodedMetadata = metadata.GetBuffer()
+				binary.BigEndian.PutUint16(encodedMetadata[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
+				binary.BigEndian.PutUint16(encodedMetadata[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
+				binary.BigEndian.PutUint32(encodedMetadata[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], uint32(len(content)))
+			}
+			err = c.writeEncoded(p, encodedMetadata[:], content, false, nil)
+		}
+		if err != nil {
+			if err != ConnectionClosed {
+				err = c.closeWithError(err)
+			}
+			if errs == nil {
+				errs = make(WriteErrors)
+			}
+			errs[c.ID()] = err
+		}
+	}
+	if encodedMetadata != nil {
+		metadata.PutBuffer(encodedMetadata)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerBroadcast(t *testing.T) {
+	t.Parallel()
+
+	const testOperation = uint16(11)
+	const clients = 3
+
+	emptyLogger := zerolog.New(io.Discard)
+	s, err := NewServer(make(HandlerTable), WithLogger(&emptyLogger), WithFeatures(FeatureCompression|FeatureExtendedHeaders))
+	require.NoError(t, err)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+	addr := s.listener.Addr().String()
+
+	// The last client negotiates compression and extended headers, so its packets cannot share the encoded metadata
+	features := []Features{NoFeatures, NoFeatures, FeatureCompression | FeatureExtendedHeaders}
+	received := make([]chan string, clients)
+	ids := make([]uint64, clients)
+	for i := 0; i < clients; i++ {
+		ch := make(chan string, 2)
+		received[i] = ch
+		clientHandlerTable := make(HandlerTable)
+		clientHandlerTable[testOperation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			ch <- string(*incoming.Content)
+			return
+		}
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithFeatures(features[i]))
+		require.NoError(t, err)
+		err = c.Connect(addr)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = c.Close()
+		})
+
+		local := c.conn.LocalAddr().String()
+		require.Eventually(t, func() bool {
+			s.connectionsMu.Lock()
+			defer s.connectionsMu.Unlock()
+			for id, serverConn := range s.connections {
+				if serverConn.RemoteAddr().String() == local {
+					ids[i] = id
+					return true
+				}
+			}
+			return false
+		}, time.Second, time.Millisecond)
+	}
+
+	p := packet.Get()
+	p.Metadata.Operation = testOperation
+	p.Content.Write([]byte("broadcast"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	err = s.Broadcast(p)
+	require.NoError(t, err)
+	for i := 0; i < clients; i++ {
+		assert.Equal(t, "broadcast", <-received[i])
+	}
+
+	p.Content.Reset()
+	p.Content.Write([]byte("multicast"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	unknown := ids[0] + ids[1] + ids[2]
+	err = s.WriteTo([]uint64{ids[0], ids[2], unknown}, p)
+	require.Error(t, err)
+	writeErrors, ok := err.(WriteErrors)
+	require.True(t, ok)
+	assert.Equal(t, WriteErrors{unknown: UnknownConnection}, writeErrors)
+	assert.Equal(t, "multicast", <-received[0])
+	assert.Equal(t, "multicast", <-received[2])
+	select {
+	case <-received[1]:
+		t.Fatal("packet was written to a connection that was not selected")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.Metadata.Operation = PING
+	err = s.Broadcast(p)
+	assert.ErrorIs(t, err, InvalidOperation)
+	packet.Put(p)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,80 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/tls"
+	"math"
+	"net"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// SetBusyPoll enables busy-polling on the connection for up to d (use 0 to disable).
+//
+// When busy-polling is enabled, ReadPacket spins for up to d waiting for a packet to arrive before parking
+// the calling goroutine, and on Linux the SO_BUSY_POLL socket option is set on the underlying TCP socket so that
+// the kernel also busy-polls the network device for incoming data. This trades a significant amount of CPU for
+// lower and more predictable latency, and should only be used for co-located, latency-critical connections.
+//
+// Raising SO_BUSY_POLL above the net.core.busy_poll sysctl requires the CAP_NET_ADMIN capability, in which case an error
+// is returned but ReadPacket will still spin. Connections that are not backed by a socket only spin.
+func (c *Async) SetBusyPoll(d time.Duration) error {
+	if d < 0 {
+		d = 0
+	}
+	c.busyPoll.Store(d)
+	if d > 0 {
+		c.Logger().Warn().Msgf("busy-polling enabled for %s, this will use a significant amount of CPU", d)
+	}
+	return setSocketBusyPoll(c.conn, d)
+}
+
+// BusyPoll returns how long ReadPacket will spin for before parking (0 if busy-polling is disabled)
+func (c *Async) BusyPoll() time.Duration {
+	return c.busyPoll.Load()
+}
+
+// spin waits for up to d for a packet to be available in the incoming queue
+func (c *Async) spin(d time.Duration) {
+	deadline := time.Now().Add(d)
+	for c.incoming.IsEmpty() && !c.closed.Load() && time.Now().Before(deadline) {
+		runtime.Gosched()
+	}
+}
+
+// setSocketBusyPoll sets the SO_BUSY_POLL socket option on the socket underlying conn,
+// and does nothing if conn is not backed by a socket
+func setSocketBusyPoll(conn net.Conn, d time.Duration) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	usec := d.Microseconds()
+	if usec > math.MaxInt32 {
+		usec = math.MaxInt32
+	}
+	return setBusyPoll(rc, int(usec))
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setBusyPoll sets the SO_BUSY_POLL socket option (in microseconds) on the given socket
+func setBusyPoll(rc syscall.RawConn, usec int) error {
+	var err error
+	controlErr := rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BUSY_POLL, usec)
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
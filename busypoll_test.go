@@ -0,0 +1,95 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncBusyPoll(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger), WithBusyPoll(time.Millisecond))
+
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, NoFeatures)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	assert.Equal(t, time.Millisecond, readerConn.BusyPoll())
+	assert.Equal(t, time.Duration(0), writerConn.BusyPoll())
+
+	for i := 0; i < testSize; i++ {
+		p := packet.Get()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = metadata.PacketPing
+		err := writerConn.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), p.Metadata.Id)
+		packet.Put(p)
+	}
+
+	err := readerConn.SetBusyPoll(0)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), readerConn.BusyPoll())
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestAsyncBusyPollSocket(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	err = readerConn.SetBusyPoll(time.Microsecond * 50)
+	if err != nil {
+		assert.ErrorIs(t, err, syscall.EPERM)
+	}
+	assert.Equal(t, time.Microsecond*50, readerConn.BusyPoll())
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
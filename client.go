@@ -54,6 +54,7 @@ func NewClient(handlerTable HandlerTable, ctx context.Context, opts ...Option) (
 	}
 
 	options := loadOptions(opts...)
+	options.initiator = true
 	var heartbeatChannel chan struct{}
 
 	return &Client{
@@ -69,29 +70,35 @@ func NewClient(handlerTable HandlerTable, ctx context.Context, opts ...Option) (
 // to receive and handle incoming packets. If this function is called, FromConn should not be called.
 func (c *Client) Connect(addr string, streamHandler ...NewStreamHandler) error {
 	c.Logger().Debug().Msgf("Connecting to %s", addr)
-	var frisbeeConn *Async
-	var err error
-	frisbeeConn, err = ConnectAsync(addr, c.options.KeepAlive, c.Logger(), c.options.TLSConfig, streamHandler...)
+	conn, err := connect(c.ctx, addr, c.options)
+	if err != nil {
+		return err
+	}
+	if c.options.Session != nil {
+		conn, err = dialSession(conn, *c.options.Session, func() (net.Conn, error) {
+			return connect(c.ctx, addr, c.options)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = c.fromConn(conn, true, streamHandler...)
 	if err != nil {
 		return err
 	}
-	c.conn = frisbeeConn
 	c.Logger().Info().Msgf("Connected to %s", addr)
-
-	c.wg.Add(1)
-	go c.handleConn()
-	c.Logger().Debug().Msgf("Connection handler started for %s", addr)
 	return nil
 }
 
 // FromConn takes a pre-existing connection to a Frisbee server and starts the reactor goroutines
 // to receive and handle incoming packets. If this function is called, Connect should not be called.
 func (c *Client) FromConn(conn net.Conn, streamHandler ...NewStreamHandler) error {
-	c.conn = NewAsync(conn, c.Logger(), streamHandler...)
-	c.wg.Add(1)
-	go c.handleConn()
-	c.Logger().Debug().Msgf("Connection handler started for %s", c.conn.RemoteAddr())
-	return nil
+	return c.fromConn(conn, false, streamHandler...)
+}
+
+// Features returns the Features that were negotiated with the server during the handshake
+func (c *Client) Features() Features {
+	return c.conn.Features()
 }
 
 // Closed checks whether this client has been closed
@@ -122,6 +129,27 @@ func (c *Client) WritePacket(p *packet.Packet) error {
 	return c.conn.WritePacket(p)
 }
 
+// WritePacketPriority sends a frisbee packet.Packet from the client to the server with the given Priority
+// (see WithPriorities)
+func (c *Client) WritePacketPriority(p *packet.Packet, priority Priority) error {
+	return c.conn.WritePacketPriority(p, priority)
+}
+
+// WriteUrgent sends a small urgent control packet from the client to the server, bypassing the packets that are
+// already buffered (see Async.WriteUrgent)
+func (c *Client) WriteUrgent(p *packet.Packet) error {
+	return c.conn.WriteUrgent(p)
+}
+
+// AttachOutbox attaches the Outbox to the client's connection, and writes the packets of the Outbox that have
+// not been acknowledged yet to it (see Outbox.Attach)
+func (c *Client) AttachOutbox(outbox *Outbox) error {
+	if c.conn == nil {
+		return ConnectionNotInitialized
+	}
+	return outbox.Attach(c.conn)
+}
+
 // Flush flushes any queued frisbee Packets from the client to the server
 func (c *Client) Flush() error {
 	return c.conn.Flush()
@@ -151,6 +179,11 @@ func (c *Client) Stream(id uint16) *Stream {
 	return c.conn.NewStream(id)
 }
 
+// OpenStream opens a new Stream in the given StreamMode (see Async.OpenStream)
+func (c *Client) OpenStream(id uint16, mode StreamMode) (*Stream, error) {
+	return c.conn.OpenStream(id, mode)
+}
+
 // SetNewStreamHandler sets the callback handler for new streams.
 //
 // It's important to note that this handler is called for new streams and if it is
@@ -164,10 +197,65 @@ func (c *Client) SetNewStreamHandler(handler NewStreamHandler) {
 
 // Logger returns the client's logger (useful for ClientRouter functions)
 func (c *Client) Logger() *zerolog.Logger {
-	return c.options.Logger
+	return c.options.logger()
+}
+
+// fromConn installs the connection wrappers on conn (including the wire wrappers unless they have already
+// been installed), performs the handshake and authentication on conn (if they are enabled), wraps it in a frisbee connection,
+// and starts the reactor goroutines
+func (c *Client) fromConn(conn net.Conn, wired bool, streamHandler ...NewStreamHandler) error {
+	conn = c.options.wrapConn(conn, wired)
+	features := NoFeatures
+	var signingKeys, encryptionKeys connectionKeys
+	var dict *dictionary
+	if c.options.Handshake {
+		nonce, params, err := c.options.signingParams()
+		var exchange *encryptionExchange
+		if err == nil {
+			exchange, params, err = c.options.encryptionParams(params)
+		}
+		var dictionaries []byte
+		if err == nil {
+			dictionaries, params = c.options.dictionaryParams(params)
+		}
+		if err == nil {
+			var reply map[uint8][]byte
+			features, reply, err = handshakeInitiate(conn, c.options.Features, params)
+			if err == nil && !features.Has(c.options.requiredFeatures()) {
+				err = FeatureNotNegotiated
+			}
+			if err == nil {
+				signingKeys, err = c.options.connectionSigningKeys(nonce, reply[helloParamSigningNonce], true)
+			}
+			if err == nil {
+				encryptionKeys, err = c.options.connectionEncryptionKeys(exchange, reply[helloParamEncryptionShare], true)
+			}
+			dict = c.options.negotiateDictionary(dictionaries, reply[helloParamDictionaries], features)
+		}
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error during handshake")
+			_ = conn.Close()
+			return err
+		}
+	}
+	if c.options.Authenticator != nil {
+		err := authenticateInitiate(conn, c.options.Authenticator)
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error during authentication")
+			_ = conn.Close()
+			return err
+		}
+	}
+	c.conn = newAsync(conn, c.options.withSigningKeys(signingKeys).withEncryptionKeys(encryptionKeys).withDictionary(dict), features, streamHandler...)
+	c.wg.Add(1)
+	go c.handleConn()
+	c.Logger().Debug().Msgf("Connection handler started for %s", c.conn.RemoteAddr())
+	return nil
 }
 
 func (c *Client) handleConn() {
+	ctx, cancel := connContext(c.ctx, c.conn, nil)
+	defer cancel()
 	var p *packet.Packet
 	var outgoing *packet.Packet
 	var action Action
@@ -185,9 +273,14 @@ func (c *Client) handleConn() {
 			_ = c.Close()
 			return
 		}
-		handlerFunc = c.handlerTable[p.Metadata.Operation]
+		if p.Metadata.Operation == DEPRECATED {
+			c.handleDeprecation(p)
+			packet.Put(p)
+			continue
+		}
+		handlerFunc = lookupHandler(c.handlerTable, c.options.Router, p.Metadata.Operation)
 		if handlerFunc != nil {
-			packetCtx := c.ctx
+			packetCtx := ctx
 			if c.PacketContext != nil {
 				packetCtx = c.PacketContext(packetCtx, p)
 			}
@@ -0,0 +1,224 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/loopholelabs/frisbee-go"
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// defaultOffset is the first operation that is not reserved by frisbee
+const defaultOffset = uint(frisbee.RESERVED9 + 1)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	frisbeePackage = protogen.GoImportPath("github.com/loopholelabs/frisbee-go")
+	codecPackage   = protogen.GoImportPath("github.com/loopholelabs/frisbee-go/pkg/codec")
+	rpcPackage     = protogen.GoImportPath("github.com/loopholelabs/frisbee-go/pkg/rpc")
+)
+
+// generateFile generates the frisbee clients and servers for the services in f, with operations starting at offset
+func generateFile(gen *protogen.Plugin, f *protogen.File, offset uint) error {
+	if len(f.Services) == 0 {
+		return nil
+	}
+	operations := offset
+	for _, service := range f.Services {
+		operations += uint(len(service.Methods)) + 1
+	}
+	if offset <= uint(frisbee.RESERVED9) || operations-1 > math.MaxUint16 {
+		return fmt.Errorf("%s: operations %d to %d are not valid frisbee operations", f.Desc.Path(), offset, operations-1)
+	}
+
+	g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".frisbee.go", f.GoImportPath)
+	g.P("// Code generated by protoc-gen-frisbee. DO NOT EDIT.")
+	g.P("// source: ", f.Desc.Path())
+	g.P()
+	g.P("package ", f.GoPackageName)
+	g.P()
+
+	operation := offset
+	for _, service := range f.Services {
+		generateService(g, service, operation)
+		operation += uint(len(service.Methods)) + 1
+	}
+	return nil
+}
+
+// generateService generates the operations, server interface and client for service
+func generateService(g *protogen.GeneratedFile, service *protogen.Service, offset uint) {
+	name := service.GoName
+
+	g.P("// These are the frisbee operations used by the ", name, " service:")
+	g.P("const (")
+	g.P(errorOperation(service), " = uint16(", offset, ")")
+	for i, method := range service.Methods {
+		g.P(methodOperation(method), " = uint16(", offset+uint(i)+1, ")")
+	}
+	g.P(")")
+	g.P()
+
+	g.P("// ", name, "Server is the server API for the ", name, " service.")
+	g.P("type ", name, "Server interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, serverSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// Register", name, "Server adds the handlers for the ", name, " service to the router.")
+	g.P("func Register", name, "Server(r *", g.QualifiedGoIdent(rpcPackage.Ident("Router")), ", impl ", name, "Server) error {")
+	for _, method := range service.Methods {
+		codecIdent := g.QualifiedGoIdent(codecPackage.Ident("Protobuf"))
+		if handler := streamHandler(method); handler != "" {
+			g.P("if err := r.HandleStream(", methodOperation(method), ", ", g.QualifiedGoIdent(rpcPackage.Ident(handler)), "[", messageType(g, method.Input), ", ", messageType(g, method.Output), "](", codecIdent, ", impl.", method.GoName, ")); err != nil {")
+		} else {
+			g.P("if err := r.Handle(", methodOperation(method), ", ", g.QualifiedGoIdent(rpcPackage.Ident("Handle")), "(", codecIdent, ", ", errorOperation(service), ", impl.", method.GoName, ")); err != nil {")
+		}
+		g.P("return err")
+		g.P("}")
+	}
+	g.P("return nil")
+	g.P("}")
+	g.P()
+
+	g.P("// ", name, "Client is the client API for the ", name, " service, and is a frisbee.Client that")
+	g.P("// must be connected to a server before its methods can be called.")
+	g.P("type ", name, "Client struct {")
+	g.P("*", g.QualifiedGoIdent(frisbeePackage.Ident("Client")))
+	g.P("caller *", g.QualifiedGoIdent(rpcPackage.Ident("Caller")))
+	g.P("}")
+	g.P()
+
+	var unary []string
+	for _, method := range service.Methods {
+		if !isStreaming(method) {
+			unary = append(unary, methodOperation(method))
+		}
+	}
+	g.P("// New", name, "Client returns a new, unconnected ", name, "Client.")
+	g.P("func New", name, "Client(ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")), ", opts ...", g.QualifiedGoIdent(frisbeePackage.Ident("Option")), ") (*", name, "Client, error) {")
+	g.P("caller := ", g.QualifiedGoIdent(rpcPackage.Ident("NewCaller")), "(", g.QualifiedGoIdent(codecPackage.Ident("Protobuf")), ", ", errorOperation(service), ")")
+	g.P("handlerTable := make(", g.QualifiedGoIdent(frisbeePackage.Ident("HandlerTable")), ")")
+	if len(unary) > 0 {
+		g.P("if err := caller.Register(handlerTable, ", strings.Join(unary, ", "), "); err != nil {")
+	} else {
+		g.P("if err := caller.Register(handlerTable); err != nil {")
+	}
+	g.P("return nil, err")
+	g.P("}")
+	g.P("client, err := ", g.QualifiedGoIdent(frisbeePackage.Ident("NewClient")), "(handlerTable, ctx, opts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return &", name, "Client{Client: client, caller: caller}, nil")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		if method.Comments.Leading == "" {
+			if isStreaming(method) {
+				g.P("// ", method.GoName, " opens a stream for the ", method.GoName, " method of the ", name, " service.")
+			} else {
+				g.P("// ", method.GoName, " calls the ", method.GoName, " method of the ", name, " service.")
+			}
+		}
+		g.P(method.Comments.Leading, "func (c *", name, "Client) ", clientSignature(g, method), " {")
+		typeArguments := "[" + messageType(g, method.Input) + ", " + messageType(g, method.Output) + "]"
+		switch {
+		case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+			g.P("return ", g.QualifiedGoIdent(rpcPackage.Ident("OpenStream")), typeArguments, "(c.caller, c.Client, ", methodOperation(method), ")")
+		case method.Desc.IsStreamingClient():
+			g.P("return ", g.QualifiedGoIdent(rpcPackage.Ident("ClientStreaming")), typeArguments, "(c.caller, c.Client, ", methodOperation(method), ")")
+		case method.Desc.IsStreamingServer():
+			g.P("return ", g.QualifiedGoIdent(rpcPackage.Ident("ServerStreaming")), typeArguments, "(c.caller, c.Client, ", methodOperation(method), ", req)")
+		default:
+			g.P("return ", g.QualifiedGoIdent(rpcPackage.Ident("Call")), typeArguments, "(ctx, c.caller, c.Client, ", methodOperation(method), ", req)")
+		}
+		g.P("}")
+		g.P()
+	}
+}
+
+// serverSignature returns the signature of the server interface method for method
+func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	input, output := messageType(g, method.Input), messageType(g, method.Output)
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		return method.GoName + "(stream *" + g.QualifiedGoIdent(rpcPackage.Ident("Stream")) + "[" + output + ", " + input + "]) error"
+	case method.Desc.IsStreamingClient():
+		return method.GoName + "(stream " + g.QualifiedGoIdent(rpcPackage.Ident("Receiver")) + "[" + input + "]) (*" + output + ", error)"
+	case method.Desc.IsStreamingServer():
+		return method.GoName + "(req *" + input + ", stream " + g.QualifiedGoIdent(rpcPackage.Ident("Sender")) + "[" + output + "]) error"
+	default:
+		return method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context")) + ", req *" + input + ") (*" + output + ", error)"
+	}
+}
+
+// clientSignature returns the signature of the client method for method
+func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	input, output := messageType(g, method.Input), messageType(g, method.Output)
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		return method.GoName + "() (*" + g.QualifiedGoIdent(rpcPackage.Ident("Stream")) + "[" + input + ", " + output + "], error)"
+	case method.Desc.IsStreamingClient():
+		return method.GoName + "() (*" + g.QualifiedGoIdent(rpcPackage.Ident("ClientStream")) + "[" + input + ", " + output + "], error)"
+	case method.Desc.IsStreamingServer():
+		return method.GoName + "(req *" + input + ") (*" + g.QualifiedGoIdent(rpcPackage.Ident("Stream")) + "[" + input + ", " + output + "], error)"
+	default:
+		return serverSignature(g, method)
+	}
+}
+
+// streamHandler returns the name of the function in the rpc package that creates the StreamHandler
+// for method, or an empty string if method is a unary method
+func streamHandler(method *protogen.Method) string {
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		return "BidiStreamingHandler"
+	case method.Desc.IsStreamingClient():
+		return "ClientStreamingHandler"
+	case method.Desc.IsStreamingServer():
+		return "ServerStreamingHandler"
+	default:
+		return ""
+	}
+}
+
+// messageType returns the qualified name of the Go type of message
+func messageType(g *protogen.GeneratedFile, message *protogen.Message) string {
+	return g.QualifiedGoIdent(message.GoIdent)
+}
+
+// errorOperation returns the name of the constant for the error operation of service
+func errorOperation(service *protogen.Service) string {
+	return service.GoName + "ErrorOperation"
+}
+
+// methodOperation returns the name of the constant for the operation of method
+func methodOperation(method *protogen.Method) string {
+	return method.Parent.GoName + method.GoName + "Operation"
+}
+
+// isStreaming returns whether method is a client, server or bidirectional streaming method
+func isStreaming(method *protogen.Method) bool {
+	return method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer()
+}
@@ -0,0 +1,122 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update the generated code of the example service")
+
+// exampleFile is the descriptor of internal/example/example.proto
+func exampleFile() *descriptorpb.FileDescriptorProto {
+	method := func(name string, input string, output string, clientStreaming bool, serverStreaming bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".google.protobuf." + input),
+			OutputType:      proto.String(".google.protobuf." + output),
+			ClientStreaming: proto.Bool(clientStreaming),
+			ServerStreaming: proto.Bool(serverStreaming),
+		}
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("example.proto"),
+		Package:    proto.String("example"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/wrappers.proto"},
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("github.com/loopholelabs/frisbee-go/cmd/protoc-gen-frisbee/internal/example"),
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Example"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Upper", "StringValue", "StringValue", false, false),
+				method("Count", "UInt32Value", "UInt32Value", false, true),
+				method("Sum", "Int64Value", "Int64Value", true, false),
+				method("Chat", "StringValue", "StringValue", true, true),
+			},
+		}},
+	}
+}
+
+// generate runs the generator on the example file with the given parameter
+func generate(t *testing.T, parameter string) *pluginpb.CodeGeneratorResponse {
+	request := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"example.proto"},
+		Parameter:      proto.String(parameter),
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(wrapperspb.File_google_protobuf_wrappers_proto),
+			exampleFile(),
+		},
+	}
+
+	var flags flag.FlagSet
+	offset := flags.Uint("offset", defaultOffset, "")
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(request)
+	require.NoError(t, err)
+	for _, f := range gen.Files {
+		if f.Generate {
+			if err = generateFile(gen, f, *offset); err != nil {
+				gen.Error(err)
+			}
+		}
+	}
+	return gen.Response()
+}
+
+func TestGenerate(t *testing.T) {
+	response := generate(t, "")
+	require.Nil(t, response.Error)
+	require.Len(t, response.File, 1)
+
+	generated := response.File[0]
+	assert.Equal(t, "github.com/loopholelabs/frisbee-go/cmd/protoc-gen-frisbee/internal/example/example.frisbee.go", generated.GetName())
+
+	path := filepath.Join("internal", "example", "example.frisbee.go")
+	if *update {
+		require.NoError(t, os.WriteFile(path, []byte(generated.GetContent()), 0644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), generated.GetContent(), "run `go test ./cmd/protoc-gen-frisbee -update` to update the example")
+}
+
+func TestGenerateOffset(t *testing.T) {
+	response := generate(t, "offset=64")
+	require.Nil(t, response.Error)
+	require.Len(t, response.File, 1)
+	assert.Contains(t, response.File[0].GetContent(), "ExampleErrorOperation = uint16(64)")
+	assert.Contains(t, response.File[0].GetContent(), "ExampleChatOperation  = uint16(68)")
+
+	response = generate(t, "offset=9")
+	assert.NotNil(t, response.Error)
+
+	response = generate(t, "offset=65533")
+	assert.NotNil(t, response.Error)
+}
@@ -0,0 +1,87 @@
+// Code generated by protoc-gen-frisbee. DO NOT EDIT.
+// source: example.proto
+
+package example
+
+import (
+	context "context"
+	frisbee_go "github.com/loopholelabs/frisbee-go"
+	codec "github.com/loopholelabs/frisbee-go/pkg/codec"
+	rpc "github.com/loopholelabs/frisbee-go/pkg/rpc"
+	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// These are the frisbee operations used by the Example service:
+const (
+	ExampleErrorOperation = uint16(10)
+	ExampleUpperOperation = uint16(11)
+	ExampleCountOperation = uint16(12)
+	ExampleSumOperation   = uint16(13)
+	ExampleChatOperation  = uint16(14)
+)
+
+// ExampleServer is the server API for the Example service.
+type ExampleServer interface {
+	Upper(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	Count(req *wrapperspb.UInt32Value, stream rpc.Sender[wrapperspb.UInt32Value]) error
+	Sum(stream rpc.Receiver[wrapperspb.Int64Value]) (*wrapperspb.Int64Value, error)
+	Chat(stream *rpc.Stream[wrapperspb.StringValue, wrapperspb.StringValue]) error
+}
+
+// RegisterExampleServer adds the handlers for the Example service to the router.
+func RegisterExampleServer(r *rpc.Router, impl ExampleServer) error {
+	if err := r.Handle(ExampleUpperOperation, rpc.Handle(codec.Protobuf, ExampleErrorOperation, impl.Upper)); err != nil {
+		return err
+	}
+	if err := r.HandleStream(ExampleCountOperation, rpc.ServerStreamingHandler[wrapperspb.UInt32Value, wrapperspb.UInt32Value](codec.Protobuf, impl.Count)); err != nil {
+		return err
+	}
+	if err := r.HandleStream(ExampleSumOperation, rpc.ClientStreamingHandler[wrapperspb.Int64Value, wrapperspb.Int64Value](codec.Protobuf, impl.Sum)); err != nil {
+		return err
+	}
+	if err := r.HandleStream(ExampleChatOperation, rpc.BidiStreamingHandler[wrapperspb.StringValue, wrapperspb.StringValue](codec.Protobuf, impl.Chat)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ExampleClient is the client API for the Example service, and is a frisbee.Client that
+// must be connected to a server before its methods can be called.
+type ExampleClient struct {
+	*frisbee_go.Client
+	caller *rpc.Caller
+}
+
+// NewExampleClient returns a new, unconnected ExampleClient.
+func NewExampleClient(ctx context.Context, opts ...frisbee_go.Option) (*ExampleClient, error) {
+	caller := rpc.NewCaller(codec.Protobuf, ExampleErrorOperation)
+	handlerTable := make(frisbee_go.HandlerTable)
+	if err := caller.Register(handlerTable, ExampleUpperOperation); err != nil {
+		return nil, err
+	}
+	client, err := frisbee_go.NewClient(handlerTable, ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ExampleClient{Client: client, caller: caller}, nil
+}
+
+// Upper calls the Upper method of the Example service.
+func (c *ExampleClient) Upper(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	return rpc.Call[wrapperspb.StringValue, wrapperspb.StringValue](ctx, c.caller, c.Client, ExampleUpperOperation, req)
+}
+
+// Count opens a stream for the Count method of the Example service.
+func (c *ExampleClient) Count(req *wrapperspb.UInt32Value) (*rpc.Stream[wrapperspb.UInt32Value, wrapperspb.UInt32Value], error) {
+	return rpc.ServerStreaming[wrapperspb.UInt32Value, wrapperspb.UInt32Value](c.caller, c.Client, ExampleCountOperation, req)
+}
+
+// Sum opens a stream for the Sum method of the Example service.
+func (c *ExampleClient) Sum() (*rpc.ClientStream[wrapperspb.Int64Value, wrapperspb.Int64Value], error) {
+	return rpc.ClientStreaming[wrapperspb.Int64Value, wrapperspb.Int64Value](c.caller, c.Client, ExampleSumOperation)
+}
+
+// Chat opens a stream for the Chat method of the Example service.
+func (c *ExampleClient) Chat() (*rpc.Stream[wrapperspb.StringValue, wrapperspb.StringValue], error) {
+	return rpc.OpenStream[wrapperspb.StringValue, wrapperspb.StringValue](c.caller, c.Client, ExampleChatOperation)
+}
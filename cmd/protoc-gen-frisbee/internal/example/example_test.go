@@ -0,0 +1,156 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package example
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/rpc"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type server struct{}
+
+func (server) Upper(_ context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	if req.Value == "" {
+		return nil, errors.New("empty request")
+	}
+	return wrapperspb.String(strings.ToUpper(req.Value)), nil
+}
+
+func (server) Count(req *wrapperspb.UInt32Value, stream rpc.Sender[wrapperspb.UInt32Value]) error {
+	if req.Value > 10 {
+		return errors.New("count too large")
+	}
+	for i := uint32(1); i <= req.Value; i++ {
+		if err := stream.Send(wrapperspb.UInt32(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server) Sum(stream rpc.Receiver[wrapperspb.Int64Value]) (*wrapperspb.Int64Value, error) {
+	var sum int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return wrapperspb.Int64(sum), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum += req.Value
+	}
+}
+
+func (server) Chat(stream *rpc.Stream[wrapperspb.StringValue, wrapperspb.StringValue]) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(wrapperspb.String(strings.ToUpper(req.Value))); err != nil {
+			return err
+		}
+	}
+}
+
+func TestExample(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	r := rpc.NewRouter()
+	require.NoError(t, RegisterExampleServer(r, server{}))
+	assert.ErrorIs(t, RegisterExampleServer(r, server{}), rpc.OperationInUse)
+
+	s, err := frisbee.NewServer(make(frisbee.HandlerTable), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, r.Register(s))
+
+	c, err := NewExampleClient(context.Background(), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	require.NoError(t, c.FromConn(clientConn))
+
+	res, err := c.Upper(context.Background(), wrapperspb.String("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", res.Value)
+
+	_, err = c.Upper(context.Background(), wrapperspb.String(""))
+	assert.Equal(t, rpc.RemoteError("empty request"), err)
+
+	count, err := c.Count(wrapperspb.UInt32(3))
+	require.NoError(t, err)
+	for i := uint32(1); i <= 3; i++ {
+		msg, err := count.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, i, msg.Value)
+	}
+	_, err = count.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	count, err = c.Count(wrapperspb.UInt32(11))
+	require.NoError(t, err)
+	_, err = count.Recv()
+	assert.Equal(t, rpc.RemoteError("count too large"), err)
+
+	sum, err := c.Sum()
+	require.NoError(t, err)
+	for _, value := range []int64{1, 2, 3} {
+		require.NoError(t, sum.Send(wrapperspb.Int64(value)))
+	}
+	total, err := sum.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), total.Value)
+
+	chat, err := c.Chat()
+	require.NoError(t, err)
+	for _, value := range []string{"first", "second"} {
+		require.NoError(t, chat.Send(wrapperspb.String(value)))
+		msg, err := chat.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToUpper(value), msg.Value)
+	}
+	require.NoError(t, chat.CloseSend())
+	_, err = chat.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
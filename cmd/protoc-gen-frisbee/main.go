@@ -0,0 +1,54 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Command protoc-gen-frisbee is a protoc plugin that generates typed frisbee clients and server interfaces
+// from the services in protobuf files. Every method is assigned its own frisbee operation, and streaming methods
+// (client, server and bidirectional) are mapped onto frisbee Streams using the pkg/rpc package.
+//
+// Install it with `go install github.com/loopholelabs/frisbee-go/cmd/protoc-gen-frisbee` and run it alongside
+// protoc-gen-go:
+//
+//	protoc --go_out=. --frisbee_out=. example.proto
+//
+// The operations of a file's services are assigned in order, starting at the operation given with the
+// `offset` parameter (which defaults to the first operation that is not reserved by frisbee), so services that are
+// served together must be generated with offsets that do not overlap:
+//
+//	protoc --frisbee_out=. --frisbee_opt=offset=64 example.proto
+package main
+
+import (
+	"flag"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+func main() {
+	var flags flag.FlagSet
+	offset := flags.Uint("offset", defaultOffset, "the first frisbee operation used by the generated services")
+	protogen.Options{
+		ParamFunc: flags.Set,
+	}.Run(func(gen *protogen.Plugin) error {
+		for _, f := range gen.Files {
+			if f.Generate {
+				if err := generateFile(gen, f, *offset); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
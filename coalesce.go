@@ -0,0 +1,165 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultCoalesceTick is the tick of a WriteCoalescer when none is given
+const DefaultCoalesceTick = time.Millisecond
+
+// WriteCoalescer coalesces the pending writes of many connections into batches, which are flushed once per tick.
+// Connections that use a WriteCoalescer (see the WithWriteCoalescer option) do not flush their write buffers on
+// their own, and instead join the batch of the next tick whenever packets are written to them. This makes servers
+// that fan out the same packets to thousands of connections (see Server.Broadcast) flush every connection once per
+// tick instead of once per packet, at the cost of up to one tick of added latency.
+//
+// Async.Flush and packets written with an immediate flush are still flushed right away. A WriteCoalescer can be
+// shared by any number of clients and servers, and must only be closed once none of its connections are open anymore.
+type WriteCoalescer struct {
+	tick     time.Duration
+	workers  int
+	mu       sync.Mutex
+	pending  []*Async
+	spare    []*Async
+	signalCh chan struct{}
+	closeCh  chan struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewWriteCoalescer returns a new WriteCoalescer that flushes its batches every tick (which defaults to
+// DefaultCoalesceTick if it is not positive), spreading every batch over the given number of goroutines (which
+// defaults to runtime.NumCPU if it is not positive) so that a slow connection does not hold up the rest of the batch
+func NewWriteCoalescer(tick time.Duration, workers int) *WriteCoalescer {
+	if tick <= 0 {
+		tick = DefaultCoalesceTick
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	w := &WriteCoalescer{
+		tick:     tick,
+		workers:  workers,
+		signalCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+// Close stops the WriteCoalescer, discarding the batch of the next tick
+func (w *WriteCoalescer) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.pending = nil
+	close(w.closeCh)
+	w.mu.Unlock()
+	w.wg.Wait()
+}
+
+// signal adds the connection to the batch of the next tick if it is not already part of it. It is called with the
+// connection locked whenever packets have been written to its write buffer.
+func (w *WriteCoalescer) signal(c *Async) {
+	if !c.coalesceQueued.CompareAndSwap(false, true) {
+		return
+	}
+	w.mu.Lock()
+	if !w.closed {
+		w.pending = append(w.pending, c)
+		if len(w.pending) == 1 {
+			select {
+			case w.signalCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+	w.mu.Unlock()
+}
+
+// loop waits for a batch to start, and flushes it once the tick has passed
+func (w *WriteCoalescer) loop() {
+	defer w.wg.Done()
+	timer := time.NewTimer(w.tick)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case <-w.signalCh:
+		}
+		timer.Reset(w.tick)
+		select {
+		case <-w.closeCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		w.mu.Lock()
+		batch := w.pending
+		w.pending = w.spare[:0]
+		w.mu.Unlock()
+
+		w.flush(batch)
+		for i := range batch {
+			batch[i] = nil
+		}
+		w.spare = batch[:0]
+	}
+}
+
+// flush flushes every connection of the batch, spread over the workers of the WriteCoalescer
+func (w *WriteCoalescer) flush(batch []*Async) {
+	if len(batch) == 0 {
+		return
+	}
+	size := (len(batch) + w.workers - 1) / w.workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(batch); start += size {
+		end := start + size
+		if end > len(batch) {
+			end = len(batch)
+		}
+		wg.Add(1)
+		go func(part []*Async) {
+			defer wg.Done()
+			for _, c := range part {
+				c.coalesceQueued.Store(false)
+				if c.closed.Load() {
+					continue
+				}
+				if err := c.flush(); err != nil {
+					go func(c *Async) {
+						_ = c.closeWithError(err)
+					}(c)
+				}
+			}
+		}(batch[start:end])
+	}
+	wg.Wait()
+}
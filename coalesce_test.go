@@ -0,0 +1,80 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestWriteCoalescer(t *testing.T) {
+	t.Parallel()
+
+	const testOperation = uint16(11)
+	const conns = 4
+	const packets = 16
+
+	emptyLogger := zerolog.New(io.Discard)
+	coalescer := NewWriteCoalescer(time.Millisecond*50, 2)
+	t.Cleanup(coalescer.Close)
+
+	readers := make([]*Async, conns)
+	writers := make([]*Async, conns)
+	counters := make([]*writeCountingConn, conns)
+	for i := 0; i < conns; i++ {
+		reader, writer := net.Pipe()
+		counters[i] = &writeCountingConn{Conn: writer, writes: atomic.NewInt64(0)}
+		readers[i] = NewAsync(reader, &emptyLogger)
+		writers[i] = newAsync(counters[i], loadOptions(WithLogger(&emptyLogger), WithWriteCoalescer(coalescer)), NoFeatures)
+		assert.NotContains(t, writers[i].Debug().Goroutines, "flush")
+		assert.Contains(t, writers[i].modes(), "coalesced")
+	}
+
+	p := packet.Get()
+	p.Metadata.Operation = testOperation
+	p.Content.Write([]byte("coalesced"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	for i := 0; i < packets; i++ {
+		require.NoError(t, writeMany(writers, p, nil))
+	}
+	packet.Put(p)
+
+	// Every connection is flushed once for all the packets that were written to it during the tick
+	for i := 0; i < conns; i++ {
+		for j := 0; j < packets; j++ {
+			p, err := readers[i].ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, "coalesced", string(*p.Content))
+			packet.Put(p)
+		}
+		assert.Equal(t, int64(1), counters[i].writes.Load())
+	}
+
+	for i := 0; i < conns; i++ {
+		assert.NoError(t, readers[i].Close())
+		assert.NoError(t, writers[i].Close())
+	}
+	coalescer.Close()
+}
@@ -0,0 +1,79 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+)
+
+// When the FeatureCompactMetadata feature has been negotiated, the Id, Operation, and ContentLength in the metadata
+// of every packet are encoded as unsigned varints, so packets with small IDs, operations, and content take up as
+// little as 3 bytes of metadata instead of 8. Packets are still encoded, stamped, and signed with the regular 8 byte
+// metadata, which is only swapped for the compact metadata as the packet is written to the connection (and swapped
+// back as the packet is read), so the other features of the connection do not need to know about it.
+//
+// The wire format of the compact metadata is implemented by the frame package (see frame.Format.Compact).
+
+// compactHeaders is a pool of buffers used to encode the compact metadata and extended header of outgoing packets
+var compactHeaders = sync.Pool{
+	New: func() interface{} {
+		return new([frame.MaxCompactHeaderSize]byte)
+	},
+}
+
+// compactMetadata returns whether the metadata of the packets on the connection is varint encoded
+func (c *Async) compactMetadata() bool {
+	return c.features.Has(FeatureCompactMetadata)
+}
+
+// compactHeader appends header (which holds the regular metadata of a packet followed by its extended header,
+// if any) to b with its metadata replaced by the compact metadata
+func compactHeader(b []byte, header []byte) []byte {
+	b = frame.AppendCompactMetadata(b, metadata.Metadata{
+		Id:            binary.BigEndian.Uint16(header[metadata.IdOffset : metadata.IdOffset+metadata.IdSize]),
+		Operation:     binary.BigEndian.Uint16(header[metadata.OperationOffset : metadata.OperationOffset+metadata.OperationSize]),
+		ContentLength: binary.BigEndian.Uint32(header[metadata.ContentLengthOffset : metadata.ContentLengthOffset+metadata.ContentLengthSize]),
+	})
+	return append(b, header[metadata.Size:]...)
+}
+
+// writeHeader writes the encoded header of a packet to the write buffer, replacing its metadata with the compact
+// metadata if the FeatureCompactMetadata feature has been negotiated. It must be called with the connection locked.
+func (c *Async) writeHeader(header []byte) error {
+	if !c.compactMetadata() {
+		_, err := c.writer.Write(header)
+		return err
+	}
+	compact := compactHeaders.Get().(*[frame.MaxCompactHeaderSize]byte)
+	_, err := c.writer.Write(compactHeader(compact[:0], header))
+	compactHeaders.Put(compact)
+	return err
+}
+
+// appendMetadata appends the regular 8 byte encoding of m to b, which is what the signature of a
+// packet covers regardless of how its metadata was encoded on the wire
+func appendMetadata(b []byte, m metadata.Metadata) []byte {
+	var encoded [metadata.Size]byte
+	binary.BigEndian.PutUint16(encoded[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], m.Id)
+	binary.BigEndian.PutUint16(encoded[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], m.Operation)
+	binary.BigEndian.PutUint32(encoded[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], m.ContentLength)
+	return append(b, encoded[:]...)
+}
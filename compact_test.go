@@ -0,0 +1,129 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactMetadata(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	for name, features := range map[string]Features{
+		"compact":  FeatureCompactMetadata,
+		"extended": FeatureCompactMetadata | FeatureExtendedHeaders | FeatureSequenceNumbers | FeatureSigning,
+	} {
+		features := features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			options := loadOptions(WithLogger(&emptyLogger))
+			options.signingKeys = connectionKeys{read: []byte("connection key"), write: []byte("connection key")}
+			readerConn := newAsync(reader, options, features)
+			writerConn := newAsync(writer, options, features)
+
+			packets := []metadata.Metadata{
+				{Id: 0, Operation: metadata.PacketPing, ContentLength: 0},
+				{Id: 1, Operation: metadata.PacketPing, ContentLength: 4},
+				{Id: 0xFFFF, Operation: 0xFFFF, ContentLength: DefaultBufferSize * 2},
+			}
+			for _, m := range packets {
+				p := packet.Get()
+				p.Metadata.Id = m.Id
+				p.Metadata.Operation = m.Operation
+				p.Content.Write(make([]byte, m.ContentLength))
+				p.Metadata.ContentLength = m.ContentLength
+				require.NoError(t, writerConn.WritePacket(p))
+				packet.Put(p)
+			}
+
+			for _, m := range packets {
+				p, err := readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, m, *p.Metadata)
+				assert.Equal(t, int(m.ContentLength), len(*p.Content))
+				packet.Put(p)
+			}
+
+			_ = readerConn.Close()
+			_ = writerConn.Close()
+		})
+	}
+
+	t.Run("wire", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), FeatureCompactMetadata)
+
+		p := packet.Get()
+		p.Metadata.Id = 1
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write([]byte("ab"))
+		p.Metadata.ContentLength = 2
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		// Small packets only take up 3 bytes of metadata, and can be decoded by the frame package
+		encoded := make([]byte, 5)
+		_, err = io.ReadFull(reader, encoded)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, byte(metadata.PacketPing), 2, 'a', 'b'}, encoded)
+
+		f, n, err := frame.Decode(encoded, frame.Format{Compact: true})
+		require.NoError(t, err)
+		assert.Equal(t, len(encoded), n)
+		assert.Equal(t, uint16(1), f.Metadata.Id)
+		assert.Equal(t, []byte("ab"), f.Payload())
+
+		_ = writerConn.Close()
+		_ = reader.Close()
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger)), FeatureCompactMetadata)
+
+		// The Id of a packet does not fit in more than 3 bytes
+		_, err = writer.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+		require.NoError(t, err)
+
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), InvalidMetadata)
+
+		_ = readerConn.Close()
+		_ = writer.Close()
+	})
+}
@@ -0,0 +1,202 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"go.uber.org/atomic"
+)
+
+// WriteStatus is the outcome of a packet written using Async.WritePacketTracked
+type WriteStatus uint32
+
+const (
+	// WritePending means that the packet is still in the write buffer of the connection
+	WritePending = WriteStatus(iota)
+
+	// WriteFlushed means that the packet was handed to the underlying connection (and so to the kernel for
+	// TCP connections), which does not guarantee that the peer has received it
+	WriteFlushed
+
+	// WriteFailed means that the packet was never handed to the underlying connection because flushing the
+	// write buffer failed
+	WriteFailed
+
+	// WriteDropped means that the packet was never handed to the underlying connection because the
+	// connection was closed before its write buffer could be flushed
+	WriteDropped
+)
+
+func (s WriteStatus) String() string {
+	switch s {
+	case WritePending:
+		return "pending"
+	case WriteFlushed:
+		return "flushed"
+	case WriteFailed:
+		return "failed"
+	case WriteDropped:
+		return "dropped"
+	}
+	return "unknown"
+}
+
+// WriteToken is resolved once the packet that it was returned for has either been flushed to the underlying
+// connection or will never be, so that accounting layers can know exactly which packets left the process
+// before the connection was closed
+type WriteToken struct {
+	end    uint64
+	done   chan struct{}
+	status WriteStatus
+	err    error
+}
+
+func newWriteToken() *WriteToken {
+	return &WriteToken{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once the token has been resolved
+func (t *WriteToken) Done() <-chan struct{} {
+	return t.done
+}
+
+// Status returns the status of the packet, which is WritePending until the token has been resolved
+func (t *WriteToken) Status() WriteStatus {
+	select {
+	case <-t.done:
+		return t.status
+	default:
+		return WritePending
+	}
+}
+
+// Err returns the error that caused the packet to fail or be dropped, if it has been resolved as such
+func (t *WriteToken) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the token has been resolved or ctx is done, and returns the status of the packet
+func (t *WriteToken) Wait(ctx context.Context) (WriteStatus, error) {
+	select {
+	case <-t.done:
+		return t.status, t.err
+	case <-ctx.Done():
+		return WritePending, ctx.Err()
+	}
+}
+
+func (t *WriteToken) resolve(status WriteStatus, err error) {
+	t.status = status
+	t.err = err
+	close(t.done)
+}
+
+// WritePacketTracked is like WritePacket, but it also returns a WriteToken that is resolved once the packet has been
+// flushed to the underlying connection, or once it is known that it never will be (because flushing failed or the
+// connection was closed first). If an error is returned, the packet was not written and no token is returned.
+func (c *Async) WritePacketTracked(p *packet.Packet) (*WriteToken, error) {
+	if p.Metadata.Operation <= RESERVED9 {
+		return nil, InvalidOperation
+	}
+	token := newWriteToken()
+	err := c.writePacketTracked(p, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// writeCounter counts the bytes that are flushed from the write buffer to the underlying connection
+type writeCounter struct {
+	conn     net.Conn
+	sent     uint64
+	throttle *atomic.Pointer[Throttle]
+
+	// tracking is true once the connection has written urgent packets, from which point the ends of the
+	// frames in the write buffer are tracked so that urgent packets can be written between them (see Async.WriteUrgent)
+	tracking bool
+	ends     []uint64
+	lastEnd  uint64
+	urgentMu sync.Mutex
+	urgent   []*urgentFrame
+	pending  *atomic.Int64
+}
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	if w.tracking {
+		return w.writeTracked(b)
+	}
+	return w.write(b)
+}
+
+// write writes b to the underlying connection and counts the bytes that were written
+func (w *writeCounter) write(b []byte) (int, error) {
+	var n int
+	var err error
+	if t := w.throttle.Load(); t != nil {
+		n, err = w.writeThrottled(t, b)
+	} else {
+		n, err = w.conn.Write(b)
+	}
+	w.sent += uint64(n)
+	return n, directed("write", err)
+}
+
+// track registers a token for the packet that was just written to the write buffer. It must be called with the
+// connection locked.
+func (c *Async) track(token *WriteToken) {
+	token.end = c.sent.sent + uint64(c.writer.Buffered())
+	c.tokens = append(c.tokens, token)
+}
+
+// settleWrites resolves the tokens of the packets that have been flushed, and if err is not nil, it fails the rest
+// since the write buffer will not accept any more data. It must be called with the connection locked.
+func (c *Async) settleWrites(err error) {
+	if len(c.tokens) == 0 {
+		return
+	}
+	i := 0
+	for ; i < len(c.tokens) && c.tokens[i].end <= c.sent.sent; i++ {
+		c.tokens[i].resolve(WriteFlushed, nil)
+		c.tokens[i] = nil
+	}
+	c.tokens = c.tokens[i:]
+	if err != nil {
+		for _, token := range c.tokens {
+			token.resolve(WriteFailed, err)
+		}
+		c.tokens = nil
+	}
+}
+
+// dropWrites resolves the tokens of the packets that were still buffered when the connection was closed. It
+// must be called with the connection locked.
+func (c *Async) dropWrites() {
+	for _, token := range c.tokens {
+		token.resolve(WriteDropped, ConnectionClosed)
+	}
+	c.tokens = nil
+}
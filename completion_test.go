@@ -0,0 +1,139 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingConn is a net.Conn that fails every write
+type failingConn struct {
+	net.Conn
+}
+
+func (c *failingConn) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestAsyncWritePacketTracked(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	newPacket := func() *packet.Packet {
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write([]byte("tracked"))
+		p.Metadata.ContentLength = uint32(len("tracked"))
+		return p
+	}
+	wait := func(token *WriteToken) (WriteStatus, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		return token.Wait(ctx)
+	}
+
+	t.Run("flushed", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := NewAsync(reader, &emptyLogger)
+		writerConn := NewAsync(writer, &emptyLogger)
+
+		reserved := newPacket()
+		reserved.Metadata.Operation = RESERVED9
+		_, err = writerConn.WritePacketTracked(reserved)
+		assert.ErrorIs(t, err, InvalidOperation)
+
+		tokens := make([]*WriteToken, 0, 16)
+		for i := 0; i < cap(tokens); i++ {
+			token, err := writerConn.WritePacketTracked(newPacket())
+			require.NoError(t, err)
+			tokens = append(tokens, token)
+		}
+		for _, token := range tokens {
+			status, err := wait(token)
+			require.NoError(t, err)
+			assert.Equal(t, WriteFlushed, status)
+			assert.Equal(t, WriteFlushed, token.Status())
+		}
+		for range tokens {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, "tracked", string(*p.Content))
+			packet.Put(p)
+		}
+
+		require.NoError(t, writerConn.Close())
+		require.NoError(t, readerConn.Close())
+		_, err = writerConn.WritePacketTracked(newPacket())
+		assert.ErrorIs(t, err, ConnectionClosed)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		writerConn := NewAsync(&failingConn{Conn: writer}, &emptyLogger)
+
+		token, err := writerConn.WritePacketTracked(newPacket())
+		require.NoError(t, err)
+		status, err := wait(token)
+		assert.Equal(t, WriteFailed, status)
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+		_ = writerConn.Close()
+		_ = reader.Close()
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		writerConn := NewAsync(&failingConn{Conn: writer}, &emptyLogger)
+
+		// The packet is buffered without waking up the flush loop, so it is only flushed when the connection is closed
+		token := newWriteToken()
+		writerConn.Lock()
+		_, err = writerConn.writer.Write([]byte("buffered"))
+		require.NoError(t, err)
+		writerConn.track(token)
+		writerConn.Unlock()
+		assert.Equal(t, WritePending, token.Status())
+		assert.NoError(t, token.Err())
+
+		_ = writerConn.Close()
+		status, err := wait(token)
+		assert.Equal(t, WriteDropped, status)
+		assert.ErrorIs(t, err, ConnectionClosed)
+
+		_ = reader.Close()
+	})
+}
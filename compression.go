@@ -0,0 +1,164 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// These are the encodings that can be used for the content of a packet when compression has been negotiated,
+// and are written as the first byte of the packet's content on the wire
+const (
+	encodingIdentity = byte(iota)
+	encodingDeflate
+	encodingDictionary
+)
+
+// CompressionPolicy decides whether the content of packets with the given operation should be compressed.
+type CompressionPolicy func(operation uint16) bool
+
+// defaultCompressionPolicy compresses the content of every packet
+func defaultCompressionPolicy(_ uint16) bool {
+	return true
+}
+
+// CompressOperations returns a CompressionPolicy that only compresses packets with the given operations
+func CompressOperations(operations ...uint16) CompressionPolicy {
+	allowed := make(map[uint16]struct{}, len(operations))
+	for _, operation := range operations {
+		allowed[operation] = struct{}{}
+	}
+	return func(operation uint16) bool {
+		_, ok := allowed[operation]
+		return ok
+	}
+}
+
+// SkipOperations returns a CompressionPolicy that compresses every packet except for those with the given operations,
+// which is useful for operations that carry already-compressed data (like video frames or images)
+func SkipOperations(operations ...uint16) CompressionPolicy {
+	denied := make(map[uint16]struct{}, len(operations))
+	for _, operation := range operations {
+		denied[operation] = struct{}{}
+	}
+	return func(operation uint16) bool {
+		_, ok := denied[operation]
+		return !ok
+	}
+}
+
+// compressors holds a pool of flate.Writers for each compression level from flate.HuffmanOnly to flate.BestCompression
+var compressors [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+// compressionBuffers is a pool of buffers used to hold compressed and decompressed content
+var compressionBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// validCompressionLevel returns whether level is a valid flate compression level
+func validCompressionLevel(level int) bool {
+	return level >= flate.HuffmanOnly && level <= flate.BestCompression
+}
+
+// compressible returns whether the content of packets with the given operation is encoded on the wire
+func (c *Async) compressible(operation uint16) bool {
+	return c.features.Has(FeatureCompression) && (operation > RESERVED9 || operation == STREAM)
+}
+
+// compress encodes content for the wire, prefixing it with the encoding that was used. The returned buffer
+// must be returned to the compressionBuffers pool once it has been written.
+func (c *Async) compress(operation uint16, content []byte) *bytes.Buffer {
+	buf := compressionBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	if c.options.CompressionPolicy(operation) {
+		level := c.options.CompressionLevel
+		encoding, pool, dict := encodingDeflate, &compressors[level-flate.HuffmanOnly], []byte(nil)
+		if d := c.options.dictionary; d != nil {
+			level = dictionaryLevel(level)
+			encoding, pool, dict = encodingDictionary, &d.writers[level-minDictionaryLevel], d.Data
+		}
+		buf.WriteByte(encoding)
+		w, _ := pool.Get().(*flate.Writer)
+		if w == nil {
+			w, _ = flate.NewWriterDict(buf, level, dict)
+		} else {
+			w.Reset(buf)
+		}
+		_, err := w.Write(content)
+		if err == nil {
+			err = w.Close()
+		}
+		pool.Put(w)
+		if err == nil && buf.Len() <= len(content) {
+			return buf
+		}
+		buf.Reset()
+	}
+	buf.WriteByte(encodingIdentity)
+	buf.Write(content)
+	return buf
+}
+
+// decompress decodes the content of p in place after it has been read from the wire
+func (c *Async) decompress(p *packet.Packet) error {
+	content := *p.Content
+	switch content[0] {
+	case encodingIdentity:
+		copy(content, content[1:])
+		*p.Content = content[:len(content)-1]
+		setContentLength(p, len(content)-1)
+		return nil
+	case encodingDeflate, encodingDictionary:
+		var dict []byte
+		if content[0] == encodingDictionary {
+			if c.options.dictionary == nil {
+				return InvalidContentEncoding
+			}
+			dict = c.options.dictionary.Data
+		}
+		if c.decompressor == nil {
+			c.decompressor = flate.NewReaderDict(bytes.NewReader(content[1:]), dict)
+		} else if err := c.decompressor.(flate.Resetter).Reset(bytes.NewReader(content[1:]), dict); err != nil {
+			return err
+		}
+		buf := compressionBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		limit := int64(c.contentLimit())
+		n, err := io.Copy(buf, io.LimitReader(c.decompressor, limit+1))
+		if err == nil && n > limit {
+			err = InvalidContentLength
+		}
+		if err != nil {
+			compressionBuffers.Put(buf)
+			return err
+		}
+		p.Content.Reset()
+		p.Content.Write(buf.Bytes())
+		setContentLength(p, int(n))
+		compressionBuffers.Put(buf)
+		return nil
+	default:
+		return InvalidContentEncoding
+	}
+}
@@ -0,0 +1,152 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressionPolicies(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, defaultCompressionPolicy(32))
+
+	compress := CompressOperations(32, 33)
+	assert.True(t, compress(32))
+	assert.True(t, compress(33))
+	assert.False(t, compress(34))
+
+	skip := SkipOperations(32)
+	assert.False(t, skip(32))
+	assert.True(t, skip(33))
+
+	options := loadOptions()
+	assert.Equal(t, flate.DefaultCompression, options.CompressionLevel)
+
+	options = loadOptions(WithCompression(flate.BestSpeed, nil))
+	assert.Equal(t, flate.BestSpeed, options.CompressionLevel)
+	assert.NotNil(t, options.CompressionPolicy)
+
+	options = loadOptions(WithCompression(42, skip))
+	assert.Equal(t, flate.DefaultCompression, options.CompressionLevel)
+}
+
+func TestAsyncCompression(t *testing.T) {
+	t.Parallel()
+
+	const compressibleOperation = uint16(32)
+	const skippedOperation = uint16(33)
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger), WithCompression(flate.BestCompression, SkipOperations(skippedOperation)))
+
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureCompression)
+	writerConn := newAsync(writer, options, FeatureCompression)
+
+	compressible := bytes.Repeat([]byte("frisbee telemetry "), 512)
+	buf := writerConn.compress(compressibleOperation, compressible)
+	assert.Equal(t, encodingDeflate, buf.Bytes()[0])
+	assert.Less(t, buf.Len(), len(compressible))
+	compressionBuffers.Put(buf)
+
+	random := make([]byte, 512)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	buf = writerConn.compress(compressibleOperation, random)
+	assert.Equal(t, encodingIdentity, buf.Bytes()[0])
+	assert.Equal(t, random, buf.Bytes()[1:])
+	compressionBuffers.Put(buf)
+
+	buf = writerConn.compress(skippedOperation, compressible)
+	assert.Equal(t, encodingIdentity, buf.Bytes()[0])
+	compressionBuffers.Put(buf)
+
+	for _, c := range []struct {
+		operation uint16
+		content   []byte
+	}{
+		{compressibleOperation, compressible},
+		{compressibleOperation, random},
+		{skippedOperation, compressible},
+		{compressibleOperation, nil},
+	} {
+		p := packet.Get()
+		p.Metadata.Id = 64
+		p.Metadata.Operation = c.operation
+		p.Content.Write(c.content)
+		p.Metadata.ContentLength = uint32(len(c.content))
+		err = writerConn.WritePacket(p)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(len(c.content)), p.Metadata.ContentLength)
+		packet.Put(p)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, c.operation, p.Metadata.Operation)
+		assert.Equal(t, uint32(len(c.content)), p.Metadata.ContentLength)
+		assert.Equal(t, len(c.content), len(*p.Content))
+		if len(c.content) > 0 {
+			assert.Equal(t, c.content, p.Content.Bytes())
+		}
+		packet.Put(p)
+	}
+
+	writerStream := writerConn.NewStream(1)
+	readerStreamCh := make(chan *Stream, 1)
+	readerConn.SetNewStreamHandler(func(stream *Stream) {
+		readerStreamCh <- stream
+	})
+
+	p := packet.Get()
+	p.Content.Write(compressible)
+	p.Metadata.ContentLength = uint32(len(compressible))
+	err = writerStream.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	readerStream := <-readerStreamCh
+	p, err = readerStream.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, compressible, p.Content.Bytes())
+	packet.Put(p)
+
+	err = readerStream.Close()
+	require.NoError(t, err)
+
+	time.Sleep(DefaultDeadline)
+
+	err = writerStream.Close()
+	require.ErrorIs(t, err, StreamClosed)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
@@ -19,6 +19,7 @@ package frisbee
 import (
 	"context"
 	"crypto/tls"
+	"github.com/loopholelabs/frisbee-go/internal/dialer"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -44,6 +45,7 @@ var (
 
 var (
 	NotTLSConnectionError = errors.New("connection is not of type *tls.Conn")
+	NotCloseWriter        = errors.New("connection does not support CloseWrite")
 )
 
 type Conn interface {
@@ -62,3 +64,47 @@ type Conn interface {
 	Error() error
 	Raw() net.Conn
 }
+
+// PacketRecorder is used to record every packet (including internal control packets) that is read from
+// or written to a frisbee connection. The packets are returned to their pools after the
+// recorder returns, so implementations must copy anything they want to keep.
+//
+// See the pkg/recorder package for an implementation that can export pcapng captures.
+type PacketRecorder interface {
+	RecordRead(*packet.Packet)
+	RecordWrite(*packet.Packet)
+}
+
+// closeWriter is implemented by connections that can be half-closed (like *tls.Conn and *net.TCPConn)
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// DialFunc dials a new connection to addr on the given network. It has the same signature as the DialContext
+// methods of net.Dialer and tls.Dialer, so custom dialers (like a SPIFFE-aware or proxy-aware TLS dialer) can be
+// used to establish frisbee connections.
+type DialFunc func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+// dial creates a new TCP connection (using net.Dial), optionally wrapped in TLS
+func dial(addr string, keepAlive time.Duration, TLSConfig *tls.Config) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	d := dialer.NewRetry()
+
+	if TLSConfig != nil {
+		conn, err = d.DialTLS("tcp", addr, TLSConfig)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.(*net.TCPConn).SetKeepAlive(true)
+			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,227 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestClientDialer(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithTLS(serverTLS))
+	require.NoError(t, err)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+	addr := s.listener.Addr().String()
+
+	dials := atomic.NewUint32(0)
+	netDialer := new(net.Dialer)
+	countingDialer := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		dials.Inc()
+		return netDialer.DialContext(ctx, network, addr)
+	}
+
+	for name, options := range map[string][]Option{
+		"dialer":     {WithDialer(countingDialer), WithTLS(clientTLS)},
+		"tls-dialer": {WithTLSDialer(&tls.Dialer{Config: clientTLS})},
+	} {
+		received := make(chan struct{}, 1)
+		clientHandlerTable := make(HandlerTable)
+		clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+			received <- struct{}{}
+			return
+		}
+		c, err := NewClient(clientHandlerTable, context.Background(), append(options, WithLogger(&emptyLogger))...)
+		require.NoError(t, err, name)
+
+		err = c.Connect(addr)
+		require.NoError(t, err, name)
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		err = c.WritePacket(p)
+		require.NoError(t, err, name)
+		packet.Put(p)
+
+		<-received
+
+		err = c.Close()
+		assert.NoError(t, err, name)
+	}
+	assert.Equal(t, uint32(1), dials.Load())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, err := NewClient(make(HandlerTable), ctx, WithLogger(&emptyLogger), WithTLSDialer(&tls.Dialer{Config: clientTLS}))
+	require.NoError(t, err)
+	err = c.Connect(addr)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestConnectWithDialer(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	listener, err := tls.Listen("tcp", conn.Listen, serverTLS)
+	require.NoError(t, err)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := listener.Accept()
+		if err == nil && c.(*tls.Conn).Handshake() == nil {
+			accepted <- c
+		}
+	}()
+
+	d := &tls.Dialer{Config: clientTLS}
+	clientConn, err := ConnectAsyncWithDialer(context.Background(), listener.Addr().String(), d.DialContext, &emptyLogger)
+	require.NoError(t, err)
+	serverConn := NewAsync(<-accepted, &emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = clientConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = serverConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	packet.Put(p)
+
+	_, ok := clientConn.Raw().(*tls.Conn)
+	assert.True(t, ok)
+
+	assert.NoError(t, clientConn.Close())
+	assert.NoError(t, serverConn.Close())
+	assert.NoError(t, listener.Close())
+}
+
+func TestCloseNotify(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	listener, err := tls.Listen("tcp", conn.Listen, serverTLS)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	// The peer reads until it receives the close_notify alert, and then closes its side of the connection,
+	// or keeps it open if linger is true
+	peer := func(linger bool) (<-chan error, chan<- struct{}) {
+		errCh := make(chan error, 1)
+		done := make(chan struct{})
+		go func() {
+			c, err := listener.Accept()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			_, err = io.Copy(io.Discard, c)
+			errCh <- err
+			if linger {
+				<-done
+			}
+			_ = c.Close()
+		}()
+		return errCh, done
+	}
+
+	for name, test := range map[string]struct {
+		linger  bool
+		timeout time.Duration
+		drained error
+	}{
+		"closed":    {linger: false, timeout: DefaultDeadline, drained: io.EOF},
+		"lingering": {linger: true, timeout: time.Millisecond * 50, drained: os.ErrDeadlineExceeded},
+	} {
+		errCh, done := peer(test.linger)
+		d := &tls.Dialer{Config: clientTLS}
+		c, err := d.DialContext(context.Background(), "tcp", listener.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, c.(*tls.Conn).Handshake())
+
+		drain := &drainConn{Conn: c.(*tls.Conn)}
+		clientConn := newAsync(drain, loadOptions(WithLogger(&emptyLogger), WithCloseNotify(test.timeout)), NoFeatures)
+		require.NoError(t, clientConn.Close(), name)
+
+		// The peer saw the close_notify alert as EOF, and the connection was drained until the peer
+		// closed its side of the connection or until the timeout
+		assert.NoError(t, <-errCh, name)
+		assert.ErrorIs(t, drain.err(), test.drained, name)
+		close(done)
+	}
+}
+
+// drainConn records the first error returned when reading from the connection
+type drainConn struct {
+	*tls.Conn
+	mu      sync.Mutex
+	readErr error
+}
+
+func (c *drainConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *drainConn) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readErr
+}
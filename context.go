@@ -0,0 +1,62 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// connContextKey is the context key used to store the connection in the contexts that are passed to handlers
+type connContextKey struct{}
+
+// ConnHandler is like Handler, but it is also given the connection that the incoming packet was read from, so
+// that it can write further packets to it. It is registered in a HandlerTable using HandleConn.
+type ConnHandler func(ctx context.Context, conn Conn, incoming *packet.Packet) (outgoing *packet.Packet, action Action)
+
+// HandleConn returns a Handler that calls h with the connection that the incoming packet was read from
+func HandleConn(h ConnHandler) Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		return h(ctx, ConnFromContext(ctx), incoming)
+	}
+}
+
+// ConnFromContext returns the connection that the packet being handled was read from, using the context that
+// was passed to the Handler. It returns nil if the context was not created by a Server or Client.
+func ConnFromContext(ctx context.Context) Conn {
+	conn, ok := ctx.Value(connContextKey{}).(*Async)
+	if !ok {
+		return nil
+	}
+	return conn
+}
+
+// connContext returns the context for the handlers of conn, which holds conn and is cancelled once conn
+// is closed or done is closed (done may be nil)
+func connContext(ctx context.Context, conn *Async, done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, connContextKey{}, conn))
+	go func() {
+		select {
+		case <-conn.CloseChannel():
+		case <-done:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
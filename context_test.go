@@ -0,0 +1,124 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnHandler(t *testing.T) {
+	t.Parallel()
+
+	const echo = uint16(10)
+
+	emptyLogger := zerolog.New(io.Discard)
+	for name, concurrency := range map[string]uint64{"unlimited": 0, "single": 1, "limited": 4} {
+		concurrency := concurrency
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// The handler answers with a second packet written to the connection, and then blocks until the
+			// connection is closed
+			cancelled := make(chan struct{})
+			serverHandlerTable := make(HandlerTable)
+			serverHandlerTable[echo] = HandleConn(func(ctx context.Context, conn Conn, incoming *packet.Packet) (*packet.Packet, Action) {
+				p := packet.Get()
+				p.Metadata.Operation = echo
+				p.Content.Write(*incoming.Content)
+				p.Metadata.ContentLength = incoming.Metadata.ContentLength
+				assert.NoError(t, conn.WritePacket(p))
+				packet.Put(p)
+				<-ctx.Done()
+				close(cancelled)
+				return nil, NONE
+			})
+
+			server, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			server.SetConcurrency(concurrency)
+
+			serverConn, clientConn, err := pair.New()
+			require.NoError(t, err)
+			go server.ServeConn(serverConn)
+
+			received := make(chan string, 1)
+			clientHandlerTable := make(HandlerTable)
+			clientHandlerTable[echo] = func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+				assert.NotNil(t, ConnFromContext(ctx))
+				received <- string(*incoming.Content)
+				return nil, NONE
+			}
+			client, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			require.NoError(t, client.FromConn(clientConn))
+
+			p := packet.Get()
+			p.Metadata.Operation = echo
+			p.Content.Write([]byte("hello"))
+			p.Metadata.ContentLength = 5
+			require.NoError(t, client.WritePacket(p))
+			packet.Put(p)
+			assert.Equal(t, "hello", <-received)
+
+			require.NoError(t, client.Close())
+			select {
+			case <-cancelled:
+			case <-time.After(time.Second * 5):
+				t.Fatal("handler context was not cancelled after the connection was closed")
+			}
+
+			require.NoError(t, server.Shutdown())
+		})
+	}
+}
+
+func TestConnFromContext(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, ConnFromContext(context.Background()))
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	done := make(chan struct{})
+	ctx, cancel := connContext(context.Background(), readerConn, done)
+	defer cancel()
+	assert.Equal(t, readerConn, ConnFromContext(ctx))
+	assert.NoError(t, ctx.Err())
+
+	require.NoError(t, readerConn.Close())
+	<-ctx.Done()
+
+	ctx, cancel = connContext(context.Background(), writerConn, done)
+	defer cancel()
+	close(done)
+	<-ctx.Done()
+
+	_ = writerConn.Close()
+}
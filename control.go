@@ -0,0 +1,67 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// When the FeatureControl feature has been negotiated, AUTH packets (which are otherwise only sent during the authentication
+// handshake, before the connection is established) are control packets whose ID is their control operation. Control operations
+// have a space of their own, so the control packets of extensions (like window updates or cancellations) never collide with
+// the operations of application packets, and they are handled by the read loop itself instead of being queued.
+
+// ControlHandler is called by the read loop of a connection for every control packet with the control operation that it was
+// registered for (see WithControlHandler). The content is only valid until the handler returns, and the handler must not block.
+type ControlHandler func(conn *Async, operation uint16, content []byte)
+
+// WriteControl writes a control packet with the given control operation and content to the connection, and flushes it
+// immediately. It requires the FeatureControl feature to be negotiated, and returns FeatureNotNegotiated if it was not.
+func (c *Async) WriteControl(operation uint16, content []byte) error {
+	if !c.features.Has(FeatureControl) {
+		return FeatureNotNegotiated
+	}
+	p := packet.Get()
+	p.Metadata.Id = operation
+	p.Metadata.Operation = AUTH
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content))
+	err := c.writeWith(p, true, nil)
+	packet.Put(p)
+	if err != nil && err != ConnectionClosed && err != InvalidContentLength && err != ContentTooLarge {
+		return c.withCause(c.closeWithError(err))
+	}
+	return c.withCause(err)
+}
+
+// handleControl calls the ControlHandler of the control operation of p, and drops control packets without one
+func (c *Async) handleControl(p *packet.Packet) {
+	handler := c.options.ControlHandlers[p.Metadata.Id]
+	if handler == nil {
+		c.Logger().Debug().Uint16("Control Operation", p.Metadata.Id).Msg("control Packet without a handler discarded by read loop")
+		return
+	}
+	handler(c, p.Metadata.Id, *p.Content)
+}
+
+// WriteControl writes a control packet from the client to the server (see Async.WriteControl)
+func (c *Client) WriteControl(operation uint16, content []byte) error {
+	if c.conn == nil {
+		return ConnectionNotInitialized
+	}
+	return c.conn.WriteControl(operation, content)
+}
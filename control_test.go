@@ -0,0 +1,112 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncControl(t *testing.T) {
+	t.Parallel()
+
+	const windowUpdate = uint16(1)
+
+	emptyLogger := zerolog.New(io.Discard)
+	received := make(chan string, 1)
+	readerOptions := loadOptions(WithLogger(&emptyLogger), WithControlHandler(windowUpdate, func(conn *Async, operation uint16, content []byte) {
+		assert.NotNil(t, conn)
+		assert.Equal(t, windowUpdate, operation)
+		received <- string(content)
+	}))
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := newAsync(reader, readerOptions, FeatureControl)
+	writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), FeatureControl)
+
+	require.NoError(t, writerConn.WriteControl(windowUpdate, []byte("window")))
+	assert.Equal(t, "window", <-received)
+
+	// Control packets without a handler are dropped, and control packets are never queued
+	require.NoError(t, writerConn.WriteControl(windowUpdate+1, []byte("unknown")))
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	packet.Put(p)
+
+	// Control operations do not collide with the operations of application packets
+	require.NoError(t, writerConn.WriteControl(metadata.PacketPing, []byte("control")))
+	require.NoError(t, writerConn.WriteControl(windowUpdate, []byte("again")))
+	assert.Equal(t, "again", <-received)
+
+	_ = writerConn.Close()
+	_ = readerConn.Close()
+}
+
+func TestClientControl(t *testing.T) {
+	t.Parallel()
+
+	const cancel = uint16(2)
+
+	emptyLogger := zerolog.New(io.Discard)
+	received := make(chan string, 1)
+	server, err := NewServer(HandlerTable{}, WithLogger(&emptyLogger), WithFeatures(FeatureControl), WithControlHandler(cancel, func(conn *Async, _ uint16, content []byte) {
+		received <- string(content)
+	}))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	plain, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	assert.ErrorIs(t, plain.WriteControl(cancel, nil), ConnectionNotInitialized)
+
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureControl))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+	require.True(t, client.Features().Has(FeatureControl))
+
+	require.NoError(t, client.WriteControl(cancel, []byte("request")))
+	assert.Equal(t, "request", <-received)
+
+	require.NoError(t, client.Close())
+	require.NoError(t, server.Shutdown())
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+	assert.ErrorIs(t, writerConn.WriteControl(cancel, nil), FeatureNotNegotiated)
+	_ = writerConn.Close()
+	_ = readerConn.Close()
+}
@@ -0,0 +1,264 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package datagram carries frisbee packets over UDP, for telemetry-style workloads
+// where a late packet is worth less than a lost one. Every packet is sent as a single datagram, so it is never
+// retransmitted, and it may be lost, duplicated, or delivered out of order.
+//
+// Only operations that have been marked as unreliable-ok (see Config.Unreliable and Conn.MarkUnreliable) are carried
+// by a Conn. Packets for any other operation, and packets that do not fit in a single datagram, are handed to the
+// Reliable writer of the Config (which is usually a frisbee.Async connected to the same peer) instead.
+//
+// Datagrams are sent in plaintext, since this package does not implement any security. The Secure function of the
+// Config is called with every new connection, and can wrap it with a message-oriented secure connection provided by
+// the application.
+package datagram
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+var (
+	PacketTooLarge   = errors.New("packet does not fit in a single datagram")
+	ReliableRequired = errors.New("operation has not been marked as unreliable-ok")
+	InvalidNetwork   = errors.New("invalid datagram network")
+	SecureFailed     = errors.New("datagram connection could not be secured")
+)
+
+const (
+	// DefaultDatagramSize is the default maximum size of a datagram, which avoids IP fragmentation on most paths
+	DefaultDatagramSize = 1200
+
+	// MaxDatagramSize is the largest payload of a UDP datagram
+	MaxDatagramSize = 65507
+)
+
+// PacketWriter writes frisbee packets reliably, and is implemented by frisbee.Async
+type PacketWriter interface {
+	WritePacket(p *packet.Packet) error
+}
+
+// Config is used to configure datagram connections and listeners
+type Config struct {
+	// DatagramSize is the maximum size of a datagram, including the metadata of the packet
+	// (DefaultDatagramSize by default)
+	DatagramSize int
+
+	// Unreliable is the list of operations that are unreliable-ok, and can be carried by the connection
+	Unreliable []uint16
+
+	// Reliable receives the packets that cannot be sent as datagrams. If it is nil, writing such a packet
+	// returns an error instead.
+	Reliable PacketWriter
+
+	// Secure is called with every new connection, and returns the connection that is used in its place (for
+	// example, one that encrypts every datagram). The server argument is true for connections that are accepted
+	// by a Listener.
+	Secure func(conn net.Conn, server bool) (net.Conn, error)
+
+	// QueueSize is the number of datagrams that a Listener buffers for every peer before it starts dropping
+	// them (DefaultQueueSize by default)
+	QueueSize int
+
+	// IdleTimeout is how long a Listener keeps a peer that it has not received any datagrams from before it
+	// closes the connection of that peer (DefaultIdleTimeout by default)
+	IdleTimeout time.Duration
+
+	// MaxPeers is the number of peers that a Listener keeps at once. Datagrams from new peers are dropped while
+	// the Listener has this many peers (DefaultMaxPeers by default)
+	MaxPeers int
+}
+
+func (c *Config) datagramSize() int {
+	if c == nil || c.DatagramSize <= 0 {
+		return DefaultDatagramSize
+	}
+	if c.DatagramSize > MaxDatagramSize {
+		return MaxDatagramSize
+	}
+	return c.DatagramSize
+}
+
+// Conn sends and receives frisbee packets as datagrams over a message-oriented net.Conn
+type Conn struct {
+	conn     net.Conn
+	reliable PacketWriter
+	size     int
+
+	unreliableMu sync.RWMutex
+	unreliable   map[uint16]struct{}
+
+	readMu  sync.Mutex
+	readBuf []byte
+
+	writeMu  sync.Mutex
+	writeBuf []byte
+
+	dropped *atomic.Uint64
+}
+
+// NewConn returns a Conn that sends and receives packets over conn, which must preserve message
+// boundaries (like a connected *net.UDPConn)
+func NewConn(conn net.Conn, config *Config) *Conn {
+	return newConn(conn, config, atomic.NewUint64(0))
+}
+
+func newConn(conn net.Conn, config *Config, dropped *atomic.Uint64) *Conn {
+	c := &Conn{
+		conn:       conn,
+		size:       config.datagramSize(),
+		unreliable: make(map[uint16]struct{}),
+		readBuf:    make([]byte, MaxDatagramSize),
+		dropped:    dropped,
+	}
+	c.writeBuf = make([]byte, 0, c.size)
+	if config != nil {
+		c.reliable = config.Reliable
+		c.MarkUnreliable(config.Unreliable...)
+	}
+	return c
+}
+
+// Dial connects to the UDP address on the named network ("udp", "udp4", or "udp6"), and secures the connection
+// using the Secure function of config (if there is one)
+func Dial(ctx context.Context, network string, address string, config *Config) (*Conn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, errors.Wrap(InvalidNetwork, network)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.Secure != nil {
+		secure, err := config.Secure(conn, false)
+		if err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, SecureFailed.Error())
+		}
+		conn = secure
+	}
+	return NewConn(conn, config), nil
+}
+
+// MarkUnreliable marks the given operations as unreliable-ok, so they are carried by the connection
+func (c *Conn) MarkUnreliable(operations ...uint16) {
+	c.unreliableMu.Lock()
+	for _, operation := range operations {
+		c.unreliable[operation] = struct{}{}
+	}
+	c.unreliableMu.Unlock()
+}
+
+// Unreliable returns true if the operation has been marked as unreliable-ok
+func (c *Conn) Unreliable(operation uint16) bool {
+	c.unreliableMu.RLock()
+	_, ok := c.unreliable[operation]
+	c.unreliableMu.RUnlock()
+	return ok
+}
+
+// WritePacket sends the packet as a single datagram if its operation has been marked as unreliable-ok and it fits
+// in a datagram, and otherwise hands it to the Reliable writer of the Config. Like with frisbee.Async, the
+// operation of the packet must be greater than frisbee.RESERVED9.
+func (c *Conn) WritePacket(p *packet.Packet) error {
+	if p.Metadata.Operation <= frisbee.RESERVED9 {
+		return frisbee.InvalidOperation
+	}
+	if int(p.Metadata.ContentLength) != len(*p.Content) {
+		return frisbee.InvalidContentLength
+	}
+	if !c.Unreliable(p.Metadata.Operation) {
+		if c.reliable == nil {
+			return ReliableRequired
+		}
+		return c.reliable.WritePacket(p)
+	}
+	if metadata.Size+len(*p.Content) > c.size {
+		if c.reliable == nil {
+			return PacketTooLarge
+		}
+		return c.reliable.WritePacket(p)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	encoded, err := p.Metadata.Encode()
+	if err != nil {
+		return err
+	}
+	c.writeBuf = append(append(c.writeBuf[:0], encoded[:]...), *p.Content...)
+	_, err = c.conn.Write(c.writeBuf)
+	return err
+}
+
+// ReadPacket blocks until a valid datagram is received, and returns the packet that it carries. Datagrams that are
+// malformed, or that carry an operation that has not been marked as unreliable-ok, are dropped (see Dropped).
+func (c *Conn) ReadPacket() (*packet.Packet, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		n, err := c.conn.Read(c.readBuf)
+		if err != nil {
+			return nil, err
+		}
+		if n < metadata.Size || n > c.size {
+			c.dropped.Inc()
+			continue
+		}
+		p := packet.Get()
+		_ = p.Metadata.Decode((*metadata.Buffer)(c.readBuf[:metadata.Size]))
+		if int(p.Metadata.ContentLength) != n-metadata.Size || p.Metadata.Operation <= frisbee.RESERVED9 || !c.Unreliable(p.Metadata.Operation) {
+			packet.Put(p)
+			c.dropped.Inc()
+			continue
+		}
+		p.Content.Write(c.readBuf[metadata.Size:n])
+		return p, nil
+	}
+}
+
+// Dropped returns the number of datagrams that were dropped by the connection, either because they were invalid or
+// because they were received faster than they were read
+func (c *Conn) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the connection
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,130 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datagram
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reliableWriter struct {
+	packets []*packet.Packet
+}
+
+func (w *reliableWriter) WritePacket(p *packet.Packet) error {
+	w.packets = append(w.packets, p)
+	return nil
+}
+
+func newPacket(t *testing.T, operation uint16, size int) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = operation
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	p.Content.Write(data)
+	p.Metadata.ContentLength = uint32(size)
+	return p
+}
+
+func TestConnWritePacket(t *testing.T) {
+	t.Parallel()
+
+	reliable := new(reliableWriter)
+	clientRaw, serverRaw := net.Pipe()
+	client := NewConn(clientRaw, &Config{DatagramSize: 512, Unreliable: []uint16{10}, Reliable: reliable})
+	server := NewConn(serverRaw, &Config{Unreliable: []uint16{10}})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	assert.ErrorIs(t, client.WritePacket(newPacket(t, frisbee.RESERVED9, 32)), frisbee.InvalidOperation)
+
+	p := newPacket(t, 10, 32)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.WritePacket(p)
+	}()
+	read, err := server.ReadPacket()
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+	assert.Equal(t, p.Metadata.Id, read.Metadata.Id)
+	assert.Equal(t, p.Metadata.Operation, read.Metadata.Operation)
+	assert.Equal(t, *p.Content, *read.Content)
+	packet.Put(read)
+
+	// Operations that have not been marked as unreliable-ok, and packets that are too large, are written reliably
+	unmarked := newPacket(t, 11, 32)
+	require.NoError(t, client.WritePacket(unmarked))
+	large := newPacket(t, 10, 512)
+	require.NoError(t, client.WritePacket(large))
+	assert.Equal(t, []*packet.Packet{unmarked, large}, reliable.packets)
+
+	// Without a reliable writer, they are rejected instead
+	assert.ErrorIs(t, server.WritePacket(newPacket(t, 11, 32)), ReliableRequired)
+	assert.ErrorIs(t, server.WritePacket(newPacket(t, 10, DefaultDatagramSize)), PacketTooLarge)
+
+	server.MarkUnreliable(11)
+	assert.True(t, server.Unreliable(11))
+	assert.False(t, client.Unreliable(11))
+}
+
+func TestConnReadPacketDropped(t *testing.T) {
+	t.Parallel()
+
+	clientRaw, serverRaw := net.Pipe()
+	client := NewConn(clientRaw, &Config{Unreliable: []uint16{10, 11}})
+	server := NewConn(serverRaw, &Config{Unreliable: []uint16{10}})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	valid := newPacket(t, 10, 16)
+	errCh := make(chan error, 1)
+	go func() {
+		// A truncated datagram, a datagram with an invalid content length, and a datagram with an operation that is not
+		// unreliable-ok for the server are all dropped before the valid packet is received
+		if _, err := clientRaw.Write([]byte{1, 2, 3}); err != nil {
+			errCh <- err
+			return
+		}
+		if _, err := clientRaw.Write([]byte{0, 1, 0, 10, 0, 0, 0, 4, 1}); err != nil {
+			errCh <- err
+			return
+		}
+		if err := client.WritePacket(newPacket(t, 11, 16)); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- client.WritePacket(valid)
+	}()
+
+	read, err := server.ReadPacket()
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+	assert.Equal(t, *valid.Content, *read.Content)
+	assert.Equal(t, uint64(3), server.Dropped())
+}
@@ -0,0 +1,376 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datagram
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+const (
+	// DefaultQueueSize is the default number of datagrams that a Listener buffers for every peer
+	DefaultQueueSize = 256
+
+	// DefaultIdleTimeout is the default time after which a Listener closes the connection of a peer
+	// that it has not received any datagrams from
+	DefaultIdleTimeout = time.Minute * 2
+
+	// DefaultMaxPeers is the default number of peers that a Listener keeps at once
+	DefaultMaxPeers = 1024
+
+	// acceptBacklog is the number of new peers that a Listener buffers before new peers are dropped
+	acceptBacklog = 128
+)
+
+// Listener demultiplexes the datagrams that are received on a single net.PacketConn by their source address, and
+// returns a Conn for every peer that it receives datagrams from. Since any source address can become a peer, the
+// connections of idle peers are closed and the number of peers is limited (see Config.IdleTimeout and
+// Config.MaxPeers).
+type Listener struct {
+	conn        net.PacketConn
+	config      *Config
+	queueSize   int
+	idleTimeout time.Duration
+	maxPeers    int
+
+	peersMu sync.Mutex
+	peers   map[string]*peerConn
+
+	dropped *atomic.Uint64
+
+	accept    chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Listen announces on the local UDP address on the named network ("udp", "udp4", or "udp6")
+func Listen(network string, address string, config *Config) (*Listener, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, errors.Wrap(InvalidNetwork, network)
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(conn, config), nil
+}
+
+// NewListener returns a Listener that receives datagrams from conn. Closing the Listener also closes conn.
+func NewListener(conn net.PacketConn, config *Config) *Listener {
+	l := &Listener{
+		conn:        conn,
+		config:      config,
+		queueSize:   DefaultQueueSize,
+		idleTimeout: DefaultIdleTimeout,
+		maxPeers:    DefaultMaxPeers,
+		peers:       make(map[string]*peerConn),
+		dropped:     atomic.NewUint64(0),
+		accept:      make(chan *Conn, acceptBacklog),
+		closed:      make(chan struct{}),
+	}
+	if config != nil {
+		if config.QueueSize > 0 {
+			l.queueSize = config.QueueSize
+		}
+		if config.IdleTimeout > 0 {
+			l.idleTimeout = config.IdleTimeout
+		}
+		if config.MaxPeers > 0 {
+			l.maxPeers = config.MaxPeers
+		}
+	}
+	l.wg.Add(2)
+	go l.readLoop()
+	go l.evictLoop()
+	return l
+}
+
+// Accept blocks until a datagram is received from a new peer (and its connection has been secured, if the Config
+// has a Secure function), and returns the Conn for that peer
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Dropped returns the number of datagrams from new peers that were dropped because the Listener already had
+// Config.MaxPeers peers
+func (l *Listener) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Addr returns the local address of the Listener
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Close closes the Listener along with the connections of all of its peers
+func (l *Listener) Close() error {
+	err := l.close()
+	l.wg.Wait()
+	return err
+}
+
+func (l *Listener) close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.conn.Close()
+		l.peersMu.Lock()
+		peers := l.peers
+		l.peers = make(map[string]*peerConn)
+		l.peersMu.Unlock()
+		for _, peer := range peers {
+			peer.closeOnce.Do(func() { close(peer.closed) })
+		}
+	})
+	return err
+}
+
+func (l *Listener) readLoop() {
+	defer l.wg.Done()
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			_ = l.close()
+			return
+		}
+		key := addr.String()
+		l.peersMu.Lock()
+		select {
+		case <-l.closed:
+			l.peersMu.Unlock()
+			return
+		default:
+		}
+		peer, ok := l.peers[key]
+		if !ok {
+			if len(l.peers) >= l.maxPeers {
+				l.peersMu.Unlock()
+				l.dropped.Inc()
+				continue
+			}
+			peer = l.newPeer(addr, key)
+			l.peers[key] = peer
+			l.wg.Add(1)
+			go l.handshake(peer)
+		}
+		l.peersMu.Unlock()
+		peer.active.Store(time.Now().UnixNano())
+
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+		select {
+		case peer.incoming <- datagram:
+		default:
+			peer.dropped.Inc()
+		}
+	}
+}
+
+// evictLoop closes the connections of the peers that the Listener has not received any datagrams from
+// for longer than the idle timeout
+func (l *Listener) evictLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closed:
+			return
+		case now := <-ticker.C:
+			idle := now.Add(-l.idleTimeout).UnixNano()
+			var evicted []*peerConn
+			l.peersMu.Lock()
+			for _, peer := range l.peers {
+				if peer.active.Load() < idle {
+					evicted = append(evicted, peer)
+				}
+			}
+			l.peersMu.Unlock()
+			for _, peer := range evicted {
+				_ = peer.Close()
+			}
+		}
+	}
+}
+
+func (l *Listener) newPeer(addr net.Addr, key string) *peerConn {
+	return &peerConn{
+		listener:        l,
+		addr:            addr,
+		key:             key,
+		incoming:        make(chan []byte, l.queueSize),
+		closed:          make(chan struct{}),
+		deadlineChanged: make(chan struct{}),
+		active:          atomic.NewInt64(time.Now().UnixNano()),
+		dropped:         atomic.NewUint64(0),
+	}
+}
+
+// handshake secures the connection of a new peer, and queues it to be accepted. Peers whose connection
+// cannot be secured, or that arrive while the accept backlog is full, are forgotten.
+func (l *Listener) handshake(peer *peerConn) {
+	defer l.wg.Done()
+	var conn net.Conn = peer
+	if l.config != nil && l.config.Secure != nil {
+		secure, err := l.config.Secure(peer, true)
+		if err != nil {
+			_ = peer.Close()
+			return
+		}
+		conn = secure
+	}
+	select {
+	case l.accept <- newConn(conn, l.config, peer.dropped):
+	default:
+		_ = conn.Close()
+	}
+}
+
+func (l *Listener) forget(peer *peerConn) {
+	l.peersMu.Lock()
+	if l.peers[peer.key] == peer {
+		delete(l.peers, peer.key)
+	}
+	l.peersMu.Unlock()
+}
+
+// peerConn is the net.Conn of a single peer of a Listener
+type peerConn struct {
+	listener *Listener
+	addr     net.Addr
+	key      string
+
+	incoming  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	deadlineMu      sync.Mutex
+	deadline        time.Time
+	deadlineChanged chan struct{}
+
+	// active is the time at which the last datagram was received from the peer, in nanoseconds since the Unix epoch
+	active *atomic.Int64
+
+	dropped *atomic.Uint64
+}
+
+// Read reads a single datagram into b, and discards whatever part of the datagram does not fit
+func (c *peerConn) Read(b []byte) (int, error) {
+	for {
+		c.deadlineMu.Lock()
+		deadline, changed := c.deadline, c.deadlineChanged
+		c.deadlineMu.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case datagram := <-c.incoming:
+			if timer != nil {
+				timer.Stop()
+			}
+			return copy(b, datagram), nil
+		case <-c.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, net.ErrClosed
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// Write sends b to the peer as a single datagram
+func (c *peerConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.listener.conn.WriteTo(b, c.addr)
+}
+
+// Close closes the connection of the peer without closing the Listener. If the peer sends another datagram,
+// the Listener accepts it as a new peer.
+func (c *peerConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.listener.forget(c)
+	})
+	return nil
+}
+
+func (c *peerConn) LocalAddr() net.Addr {
+	return c.listener.conn.LocalAddr()
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *peerConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *peerConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.deadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	c.deadlineMu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is a no-op, since writing a datagram never blocks on the peer
+func (c *peerConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,210 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datagram
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xorConn is a toy secure connection that only exists to check that Secure is applied to both peers
+type xorConn struct {
+	net.Conn
+}
+
+func (c *xorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := range b[:n] {
+		b[i] ^= 0xAA
+	}
+	return n, err
+}
+
+func (c *xorConn) Write(b []byte) (int, error) {
+	encrypted := make([]byte, len(b))
+	for i := range b {
+		encrypted[i] = b[i] ^ 0xAA
+	}
+	return c.Conn.Write(encrypted)
+}
+
+func TestListener(t *testing.T) {
+	t.Parallel()
+
+	var secured [2]int
+	secure := func(conn net.Conn, server bool) (net.Conn, error) {
+		if server {
+			secured[1]++
+		} else {
+			secured[0]++
+		}
+		return &xorConn{Conn: conn}, nil
+	}
+
+	l, err := Listen("udp", "127.0.0.1:0", &Config{Unreliable: []uint16{10}, Secure: secure})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	client, err := Dial(ctx, "udp", l.Addr().String(), &Config{Unreliable: []uint16{10}, Secure: secure})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	p := newPacket(t, 10, 64)
+	require.NoError(t, client.WritePacket(p))
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String())
+
+	read, err := server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, *p.Content, *read.Content)
+
+	reply := newPacket(t, 10, 64)
+	require.NoError(t, server.WritePacket(reply))
+	read, err = client.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, *reply.Content, *read.Content)
+	assert.Equal(t, [2]int{1, 1}, secured)
+
+	require.NoError(t, l.Close())
+	_, err = l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	_, err = server.ReadPacket()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestListenerReadDeadline(t *testing.T) {
+	t.Parallel()
+
+	l, err := Listen("udp", "127.0.0.1:0", &Config{Unreliable: []uint16{10}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	client, err := Dial(context.Background(), "udp", l.Addr().String(), &Config{Unreliable: []uint16{10}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	require.NoError(t, client.WritePacket(newPacket(t, 10, 8)))
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	_, err = server.ReadPacket()
+	require.NoError(t, err)
+
+	// Setting a deadline while a read is blocked wakes it up
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := server.ReadPacket()
+		errCh <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, server.conn.SetReadDeadline(time.Now().Add(time.Millisecond*10)))
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second * 5):
+		t.Fatal("read did not return after its deadline")
+	}
+
+	_, err = Listen("tcp", "127.0.0.1:0", nil)
+	assert.ErrorIs(t, err, InvalidNetwork)
+}
+
+func TestListenerIdlePeers(t *testing.T) {
+	t.Parallel()
+
+	l, err := Listen("udp", "127.0.0.1:0", &Config{Unreliable: []uint16{10}, IdleTimeout: time.Millisecond * 50})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	client, err := Dial(context.Background(), "udp", l.Addr().String(), &Config{Unreliable: []uint16{10}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	require.NoError(t, client.WritePacket(newPacket(t, 10, 8)))
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	_, err = server.ReadPacket()
+	require.NoError(t, err)
+
+	// The connection of a peer that stops sending datagrams is closed, and the peer is accepted again once it
+	// sends another one
+	_, err = server.ReadPacket()
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	require.NoError(t, client.WritePacket(newPacket(t, 10, 8)))
+	server, err = l.Accept()
+	require.NoError(t, err)
+	_, err = server.ReadPacket()
+	require.NoError(t, err)
+}
+
+func TestListenerMaxPeers(t *testing.T) {
+	t.Parallel()
+
+	l, err := Listen("udp", "127.0.0.1:0", &Config{Unreliable: []uint16{10}, MaxPeers: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	clients := make([]*Conn, 2)
+	for i := range clients {
+		clients[i], err = Dial(context.Background(), "udp", l.Addr().String(), &Config{Unreliable: []uint16{10}})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = clients[i].Close()
+		})
+	}
+
+	require.NoError(t, clients[0].WritePacket(newPacket(t, 10, 8)))
+	server, err := l.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, clients[0].LocalAddr().String(), server.RemoteAddr().String())
+
+	// Datagrams from a new peer are dropped until the connection of the first peer is closed
+	require.NoError(t, clients[1].WritePacket(newPacket(t, 10, 8)))
+	require.Eventually(t, func() bool {
+		return l.Dropped() == 1
+	}, time.Second*5, time.Millisecond)
+
+	require.NoError(t, server.Close())
+	require.NoError(t, clients[1].WritePacket(newPacket(t, 10, 8)))
+	server, err = l.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, clients[1].LocalAddr().String(), server.RemoteAddr().String())
+}
@@ -0,0 +1,169 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// These are the goroutines of a connection, which are tracked for debugging (see Async.Debug)
+const (
+	loopRead = uint32(1 << iota)
+	loopPing
+	loopFlush
+)
+
+// These are the phases of the read loop of a connection, which are tracked for debugging (see Async.Debug)
+const (
+	readPhaseReading = uint32(iota)
+	readPhaseQueueing
+	readPhaseStreaming
+)
+
+// ConnectionDebug is the internal state of a connection, which is used to debug stuck connections (see Server.DebugHandler)
+type ConnectionDebug struct {
+	// ID is the unique ID of the connection (see Async.ID)
+	ID uint64 `json:"id"`
+
+	// PeerID is the peer ID of the connection (empty if the peer was not identified)
+	PeerID string `json:"peer_id,omitempty"`
+
+	// RemoteAddr is the remote address of the connection
+	RemoteAddr string `json:"remote_addr"`
+
+	// Closed is whether the connection has been closed
+	Closed bool `json:"closed"`
+
+	// Goroutines are the names of the goroutines of the connection that are running (like "read", "ping", or "flush")
+	Goroutines []string `json:"goroutines"`
+
+	// ReadLoop is what the read loop of the connection is doing: "reading" from the connection, "queueing" a packet
+	// in the incoming queue (which blocks while the queue is full), or "streaming" a packet to a stream
+	ReadLoop string `json:"read_loop"`
+
+	// Incoming is the number of packets in the incoming queue of the connection that have not been read yet
+	Incoming int `json:"incoming"`
+
+	// Buffered is the number of bytes in the write buffer of the connection, or -1 if the connection
+	// was locked (for example by a blocked write) and the write buffer could not be inspected
+	Buffered int `json:"buffered"`
+
+	// BufferSize is the size of the write buffer of the connection
+	BufferSize int `json:"buffer_size"`
+
+	// Streams is the number of open streams on the connection
+	Streams int `json:"streams"`
+}
+
+// enterLoop marks the given goroutine of the connection as running. It is called before the goroutine is started, so
+// that Debug reports it as soon as the connection is returned (every goroutine of a connection only runs once).
+func (c *Async) enterLoop(loop uint32) {
+	c.loops.Add(loop)
+}
+
+// exitLoop marks the given goroutine of the connection as stopped
+func (c *Async) exitLoop(loop uint32) {
+	c.loops.Sub(loop)
+}
+
+// Debug returns the internal state of the connection. It never blocks, even if the connection is stuck.
+func (c *Async) Debug() ConnectionDebug {
+	debug := ConnectionDebug{
+		ID:         c.id,
+		PeerID:     c.peerID,
+		RemoteAddr: c.RemoteAddr().String(),
+		Closed:     c.closed.Load(),
+		Incoming:   c.incoming.Length(),
+		Buffered:   -1,
+	}
+	loops := c.loops.Load()
+	for _, loop := range []struct {
+		loop uint32
+		name string
+	}{{loopRead, "read"}, {loopPing, "ping"}, {loopFlush, "flush"}} {
+		if loops&loop.loop != 0 {
+			debug.Goroutines = append(debug.Goroutines, loop.name)
+		}
+	}
+	switch c.readPhase.Load() {
+	case readPhaseQueueing:
+		debug.ReadLoop = "queueing"
+	case readPhaseStreaming:
+		debug.ReadLoop = "streaming"
+	default:
+		debug.ReadLoop = "reading"
+	}
+	if c.TryLock() {
+		debug.Buffered = c.writer.Buffered()
+		debug.BufferSize = c.writer.Size()
+		c.Unlock()
+	}
+	c.streamsMu.Lock()
+	debug.Streams = len(c.streams)
+	c.streamsMu.Unlock()
+	return debug
+}
+
+// ServerDebug is the internal state of a server and its connections (see Server.DebugHandler)
+type ServerDebug struct {
+	// Connections is the internal state of the connections of the server, ordered by their ID
+	Connections []ConnectionDebug `json:"connections"`
+
+	// Pool is how the packet pool has been used by the process
+	Pool packet.PoolStats `json:"pool"`
+
+	// PoolHitRate is the fraction of packets that were reused from the packet pool instead of allocated
+	PoolHitRate float64 `json:"pool_hit_rate"`
+}
+
+// Debug returns the internal state of the server and its connections
+func (s *Server) Debug() ServerDebug {
+	s.connectionsMu.Lock()
+	connections := make([]*Async, 0, len(s.connections))
+	for _, conn := range s.connections {
+		connections = append(connections, conn)
+	}
+	s.connectionsMu.Unlock()
+
+	debug := ServerDebug{
+		Connections: make([]ConnectionDebug, 0, len(connections)),
+		Pool:        packet.Stats(),
+	}
+	debug.PoolHitRate = debug.Pool.HitRate()
+	for _, conn := range connections {
+		debug.Connections = append(debug.Connections, conn.Debug())
+	}
+	sort.Slice(debug.Connections, func(i, j int) bool {
+		return debug.Connections[i].ID < debug.Connections[j].ID
+	})
+	return debug
+}
+
+// DebugHandler returns an http.Handler that serves the ServerDebug of the server as JSON, which can be mounted on
+// an admin HTTP mux to debug stuck connections while the server is running. It should not be exposed publicly.
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(s.Debug())
+	})
+}
@@ -0,0 +1,88 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebug(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	server, err := NewServer(HandlerTable{
+		10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+			return nil, NONE
+		},
+	}, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Shutdown()
+	})
+
+	require.Eventually(t, func() bool {
+		return len(server.Debug().Connections) == 1
+	}, DefaultDeadline, time.Millisecond)
+
+	debug := client.conn.Debug()
+	assert.Equal(t, client.conn.ID(), debug.ID)
+	assert.Contains(t, debug.Goroutines, "read")
+	assert.Equal(t, "reading", debug.ReadLoop)
+	assert.Equal(t, 0, debug.Incoming)
+	assert.Equal(t, 0, debug.Buffered)
+	assert.Greater(t, debug.BufferSize, 0)
+	assert.False(t, debug.Closed)
+
+	// The write buffer is not inspected while the connection is locked
+	client.conn.Lock()
+	assert.Equal(t, -1, client.conn.Debug().Buffered)
+	client.conn.Unlock()
+
+	recorder := httptest.NewRecorder()
+	server.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	var serverDebug ServerDebug
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&serverDebug))
+	require.Len(t, serverDebug.Connections, 1)
+	assert.Contains(t, serverDebug.Connections[0].Goroutines, "read")
+	assert.Greater(t, serverDebug.Pool.Gets, uint64(0))
+
+	require.NoError(t, client.Close())
+	require.Eventually(t, func() bool {
+		return len(client.conn.Debug().Goroutines) == 0
+	}, DefaultDeadline, time.Millisecond)
+}
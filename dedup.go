@@ -0,0 +1,103 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// Deduplicator suppresses the packets that are delivered more than once by an Outbox (see WithDeduplicator), so that
+// the packets written again after a reconnect are not handled twice. It remembers the delivery IDs that were received
+// from every sender within a window (like the sequence window of WithSequenceNumbers), and the duplicate packets are
+// acknowledged and dropped by the read loop of the connection.
+//
+// The senders are identified across connections by their peer IDs on servers (which requires a PeerIdentifier or a
+// Verifier), and a client only has a single sender, so a Deduplicator must be shared by the clients that reconnect
+// to the same server. Connections to peers without a peer ID only suppress duplicates within the connection.
+//
+// Delivery IDs must not be reused by a sender, so the Outbox of the sender must outlive the Deduplicator or
+// persist its delivery IDs using an OutboxStore.
+type Deduplicator struct {
+	mu         sync.Mutex
+	window     int
+	windows    map[string]*sequenceWindow
+	duplicates *atomic.Uint64
+}
+
+// NewDeduplicator returns a new Deduplicator that accepts packets up to window delivery IDs out of order
+// (which is capped to MaxSequenceWindow)
+func NewDeduplicator(window int) *Deduplicator {
+	if window < 0 {
+		window = 0
+	} else if window > MaxSequenceWindow {
+		window = MaxSequenceWindow
+	}
+	return &Deduplicator{
+		window:     window,
+		windows:    make(map[string]*sequenceWindow),
+		duplicates: atomic.NewUint64(0),
+	}
+}
+
+// Duplicates returns the number of duplicate packets that were suppressed
+func (d *Deduplicator) Duplicates() uint64 {
+	return d.duplicates.Load()
+}
+
+// Forget forgets the delivery IDs that were received from the peer with the given peer ID
+// (or from the server, if peerID is empty), which must be called once a sender's Outbox is replaced
+func (d *Deduplicator) Forget(peerID string) {
+	d.mu.Lock()
+	delete(d.windows, peerID)
+	d.mu.Unlock()
+}
+
+// accept returns whether the packet with the given delivery ID from the given sender should be handled
+func (d *Deduplicator) accept(sender string, delivery uint64) bool {
+	d.mu.Lock()
+	window := d.windows[sender]
+	if window == nil {
+		window = newSequenceWindow(d.window)
+		d.windows[sender] = window
+	}
+	accepted := window.accept(delivery)
+	d.mu.Unlock()
+	return accepted
+}
+
+// duplicate returns whether the packet with the given delivery ID is a duplicate that must be dropped
+func (c *Async) duplicate(delivery uint64) bool {
+	deduplicator := c.options.Deduplicator
+	if deduplicator == nil {
+		return false
+	}
+	var accepted bool
+	if c.peerID == "" && !c.options.initiator {
+		if c.deliveries == nil {
+			c.deliveries = newSequenceWindow(deduplicator.window)
+		}
+		accepted = c.deliveries.accept(delivery)
+	} else {
+		accepted = deduplicator.accept(c.peerID, delivery)
+	}
+	if !accepted {
+		deduplicator.duplicates.Inc()
+	}
+	return !accepted
+}
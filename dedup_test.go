@@ -0,0 +1,76 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicator(t *testing.T) {
+	t.Parallel()
+
+	deduplicator := NewDeduplicator(4)
+	assert.True(t, deduplicator.accept("peer", 1))
+	assert.True(t, deduplicator.accept("peer", 3))
+	assert.False(t, deduplicator.accept("peer", 3))
+	assert.True(t, deduplicator.accept("peer", 2))
+	assert.False(t, deduplicator.accept("peer", 1))
+	assert.True(t, deduplicator.accept("other", 1))
+
+	deduplicator.Forget("peer")
+	assert.True(t, deduplicator.accept("peer", 1))
+}
+
+func TestDeduplicatorReadLoop(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	deduplicator := NewDeduplicator(16)
+	options := loadOptions(WithLogger(&emptyLogger), WithDeduplicator(deduplicator))
+
+	reader, writer := net.Pipe()
+	readerConn := newAsync(reader, options, FeatureAcknowledgements)
+	writerConn := newAsync(writer, options, FeatureAcknowledgements)
+	t.Cleanup(func() {
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+
+	for _, delivery := range []uint64{1, 2, 1, 2, 3} {
+		p := packet.Get()
+		p.Metadata.Operation = 10
+		p.Content.Write([]byte{byte(delivery)})
+		p.Metadata.ContentLength = 1
+		require.NoError(t, writerConn.writeFrame(p, PriorityNormal, true, nil, delivery))
+		packet.Put(p)
+	}
+
+	for _, delivery := range []byte{1, 2, 3} {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, delivery, (*p.Content)[0])
+		packet.Put(p)
+	}
+	assert.Equal(t, uint64(2), deduplicator.Duplicates())
+}
@@ -0,0 +1,254 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// deliverySize is the size of a delivery ID, in the extended header of a packet and in the content of an ACK packet
+const deliverySize = 8
+
+// DefaultOutboxSize is the default number of unacknowledged packets that an Outbox holds
+const DefaultOutboxSize = 1 << 10
+
+// appendDelivery appends the delivery extension with the given delivery ID to an encoded extended header
+func appendDelivery(header []byte, delivery uint64) []byte {
+	var value [deliverySize]byte
+	binary.BigEndian.PutUint64(value[:], delivery)
+	header = append(header, extensionDelivery, deliverySize)
+	header = append(header, value[:]...)
+	header[metadata.Size] += 2 + deliverySize
+	return header
+}
+
+// decodeDelivery returns the delivery ID in the given extensions, or 0 if they do not carry one
+func decodeDelivery(extensions []byte) uint64 {
+	for len(extensions) > 0 {
+		extension, rest, err := frame.NextExtension(extensions)
+		if err != nil {
+			return 0
+		}
+		if extension.Type == extensionDelivery && len(extension.Value) == deliverySize {
+			return binary.BigEndian.Uint64(extension.Value)
+		}
+		extensions = rest
+	}
+	return 0
+}
+
+// acknowledge sends an ACK packet for the packets up to the given delivery ID, if the
+// FeatureAcknowledgements feature was negotiated
+func (c *Async) acknowledge(delivery uint64) error {
+	if !c.features.Has(FeatureAcknowledgements) {
+		return nil
+	}
+	p := packet.Get()
+	p.Metadata.Operation = ACK
+	var content [deliverySize]byte
+	binary.BigEndian.PutUint64(content[:], delivery)
+	p.Content.Write(content[:])
+	p.Metadata.ContentLength = deliverySize
+	err := c.write(p)
+	packet.Put(p)
+	return err
+}
+
+// acknowledged passes the delivery ID in the content of an ACK packet to the Outbox attached to the connection
+func (c *Async) acknowledged(p *packet.Packet) {
+	if p.Metadata.ContentLength != deliverySize {
+		return
+	}
+	if outbox := c.outbox.Load(); outbox != nil {
+		outbox.acknowledge(binary.BigEndian.Uint64(*p.Content))
+	}
+}
+
+// Outbox delivers packets at least once over a series of connections. Every packet written to the Outbox is given a
+// delivery ID and kept until the receiver acknowledges it, and the packets that were not acknowledged are written
+// again whenever a new connection is attached (for example after reconnecting to the server), so packets are not
+// silently lost when a connection dies with packets still buffered.
+//
+// Since packets are written again if their acknowledgement was lost, the receiver can see the same packet more
+// than once. Packets are acknowledged by the receiver's read loop once they have been read from the connection,
+// which requires the FeatureAcknowledgements feature to be negotiated.
+type Outbox struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	size    int
+	conn    *Async
+	next    uint64
+	pending []outboxEntry
+	closed  bool
+	store   OutboxStore
+}
+
+// outboxEntry is a packet that has been written to an Outbox and not acknowledged yet
+type outboxEntry struct {
+	delivery uint64
+	packet   *packet.Packet
+}
+
+// NewOutbox returns a new Outbox that holds up to size unacknowledged packets
+// (which defaults to DefaultOutboxSize if size is less than 1)
+func NewOutbox(size int) *Outbox {
+	if size < 1 {
+		size = DefaultOutboxSize
+	}
+	o := &Outbox{size: size}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// NewOutboxWithStore returns a new Outbox like NewOutbox, which persists its packets to the given OutboxStore
+// until they are acknowledged. The packets that are already in the store (for example because the process
+// restarted before they were acknowledged) are loaded into the Outbox, and are written once a connection is attached.
+func NewOutboxWithStore(size int, store OutboxStore) (*Outbox, error) {
+	o := NewOutbox(size)
+	o.store = store
+	last, err := store.Load(func(delivery uint64, p *packet.Packet) {
+		o.pending = append(o.pending, outboxEntry{delivery: delivery, packet: p})
+	})
+	if err != nil {
+		for _, entry := range o.pending {
+			packet.Put(entry.packet)
+		}
+		return nil, err
+	}
+	o.next = last
+	return o, nil
+}
+
+// Attach makes conn the connection that the Outbox writes to, and writes the packets that have not been acknowledged
+// to it in order. It returns FeatureNotNegotiated if the FeatureAcknowledgements feature was not negotiated on conn.
+func (o *Outbox) Attach(conn *Async) error {
+	if !conn.Features().Has(FeatureAcknowledgements) {
+		return FeatureNotNegotiated
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ConnectionClosed
+	}
+	if o.conn != nil {
+		o.conn.outbox.CompareAndSwap(o, nil)
+	}
+	o.conn = conn
+	conn.outbox.Store(o)
+	for _, entry := range o.pending {
+		if err := o.write(entry); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// WritePacket writes a copy of p to the attached connection (if there is one), and keeps it until the receiver
+// acknowledges it. It blocks while the Outbox is full, and only returns an error once the Outbox is closed (or if
+// the packet cannot be persisted to the Outbox's store), since packets that cannot be written are written again
+// once a new connection is attached.
+func (o *Outbox) WritePacket(p *packet.Packet) error {
+	if !validContentLength(p) {
+		return InvalidContentLength
+	}
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.pending) >= o.size && !o.closed {
+		o.cond.Wait()
+	}
+	if o.closed {
+		return ConnectionClosed
+	}
+	entry := outboxEntry{delivery: o.next + 1, packet: packet.Get()}
+	*entry.packet.Metadata = *p.Metadata
+	entry.packet.ContentType = p.ContentType
+	entry.packet.Content.Write(*p.Content)
+	if o.store != nil {
+		if err := o.store.Append(entry.delivery, entry.packet); err != nil {
+			packet.Put(entry.packet)
+			return err
+		}
+	}
+	o.next++
+	o.pending = append(o.pending, entry)
+	_ = o.write(entry)
+	return nil
+}
+
+// write writes the entry to the attached connection, and must be called with the Outbox locked
+func (o *Outbox) write(entry outboxEntry) error {
+	if o.conn == nil {
+		return ConnectionNotInitialized
+	}
+	return o.conn.writeFrame(entry.packet, PriorityNormal, false, nil, entry.delivery)
+}
+
+// acknowledge forgets the packets up to the given delivery ID
+func (o *Outbox) acknowledge(delivery uint64) {
+	o.mu.Lock()
+	acknowledged := 0
+	for acknowledged < len(o.pending) && o.pending[acknowledged].delivery <= delivery {
+		packet.Put(o.pending[acknowledged].packet)
+		acknowledged++
+	}
+	if acknowledged > 0 {
+		o.pending = append(o.pending[:0], o.pending[acknowledged:]...)
+		o.cond.Broadcast()
+		if o.store != nil {
+			_ = o.store.Acknowledge(delivery)
+		}
+	}
+	o.mu.Unlock()
+}
+
+// Pending returns the number of packets that have not been acknowledged yet
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// Close detaches the Outbox from its connection and discards the packets that have not been acknowledged (which
+// remain in the Outbox's store, if it has one, and the store is closed), and any blocked calls to WritePacket
+// return ConnectionClosed
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	if o.conn != nil {
+		o.conn.outbox.CompareAndSwap(o, nil)
+	}
+	for _, entry := range o.pending {
+		packet.Put(entry.packet)
+	}
+	o.pending = nil
+	o.cond.Broadcast()
+	if o.store != nil {
+		_ = o.store.Close()
+	}
+}
@@ -0,0 +1,107 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryExtension(t *testing.T) {
+	t.Parallel()
+
+	header, _ := encodeExtendedHeader(make([]byte, metadata.Size), []byte("inline"), DefaultInlineThreshold, true)
+	header = appendDelivery(header, 42)
+	extensions := header[metadata.Size+1:]
+	require.Equal(t, int(header[metadata.Size]), len(extensions))
+	assert.Equal(t, uint64(42), decodeDelivery(extensions))
+
+	p := packet.Get()
+	defer packet.Put(p)
+	inline, _, err := decodeExtensions(p, extensions, nil)
+	require.NoError(t, err)
+	assert.True(t, inline)
+	assert.Equal(t, "inline", string(*p.Content))
+}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+
+	const packets = 3
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+
+	pair := func() (*Async, *Async) {
+		reader, writer := net.Pipe()
+		readerConn := newAsync(reader, options, FeatureAcknowledgements)
+		writerConn := newAsync(writer, options, FeatureAcknowledgements)
+		t.Cleanup(func() {
+			_ = readerConn.Close()
+			_ = writerConn.Close()
+		})
+		return readerConn, writerConn
+	}
+
+	outbox := NewOutbox(packets)
+	plain, _ := net.Pipe()
+	plainConn := NewAsync(plain, &emptyLogger)
+	assert.ErrorIs(t, outbox.Attach(plainConn), FeatureNotNegotiated)
+	require.NoError(t, plainConn.Close())
+
+	// The first connection dies before any packets are acknowledged
+	deadReader, deadWriter := pair()
+	require.NoError(t, deadReader.Close())
+	require.NoError(t, outbox.Attach(deadWriter))
+	for i := 0; i < packets; i++ {
+		p := packet.Get()
+		p.Metadata.Operation = 10
+		p.Content.Write([]byte{byte(i)})
+		p.Metadata.ContentLength = 1
+		require.NoError(t, outbox.WritePacket(p))
+		packet.Put(p)
+	}
+	assert.Equal(t, packets, outbox.Pending())
+
+	// Attaching a new connection writes the packets again, which are acknowledged once they have been read
+	readerConn, writerConn := pair()
+	require.NoError(t, outbox.Attach(writerConn))
+	for i := 0; i < packets; i++ {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(10), p.Metadata.Operation)
+		assert.Equal(t, byte(i), (*p.Content)[0])
+		packet.Put(p)
+	}
+	require.Eventually(t, func() bool {
+		return outbox.Pending() == 0
+	}, DefaultDeadline, time.Millisecond)
+
+	outbox.Close()
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	assert.ErrorIs(t, outbox.WritePacket(p), ConnectionClosed)
+	packet.Put(p)
+}
@@ -0,0 +1,165 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// deprecationHeaderSize is the size of the fixed part of the content of a DEPRECATED packet,
+// which holds the deprecated operation, its replacement, and its sunset (in Unix seconds)
+const deprecationHeaderSize = 2 + 2 + 8
+
+// Deprecation describes a deprecated operation of a frisbee server (see Server.DeprecateOperation), and is sent
+// to clients in DEPRECATED packets when they use the operation
+type Deprecation struct {
+	// Operation is the deprecated operation
+	Operation uint16
+
+	// Replacement is the operation that should be used instead (0 if there is no replacement)
+	Replacement uint16
+
+	// Sunset is the time after which the server stops handling the operation (the zero time if it keeps handling it)
+	Sunset time.Time
+
+	// Message is an optional human-readable explanation of the deprecation
+	Message string
+}
+
+// DeprecationHandler is called by the frisbee client whenever the server signals that an operation used by the
+// client is deprecated (see the WithDeprecationHandler option). It is called from the client's packet handling
+// goroutine, so it must not block.
+type DeprecationHandler func(Deprecation)
+
+// SunsetPassed returns whether the sunset of the deprecation has passed at the given time
+func (d Deprecation) SunsetPassed(now time.Time) bool {
+	return !d.Sunset.IsZero() && !now.Before(d.Sunset)
+}
+
+// encode writes the deprecation to the content of p
+func (d Deprecation) encode(p *packet.Packet) {
+	var header [deprecationHeaderSize]byte
+	binary.BigEndian.PutUint16(header[0:2], d.Operation)
+	binary.BigEndian.PutUint16(header[2:4], d.Replacement)
+	if !d.Sunset.IsZero() {
+		binary.BigEndian.PutUint64(header[4:12], uint64(d.Sunset.Unix()))
+	}
+	p.Content.Write(header[:])
+	p.Content.Write([]byte(d.Message))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+}
+
+// decodeDeprecation reads a deprecation from the content of a DEPRECATED packet
+func decodeDeprecation(content []byte) (Deprecation, error) {
+	if len(content) < deprecationHeaderSize {
+		return Deprecation{}, InvalidDeprecation
+	}
+	d := Deprecation{
+		Operation:   binary.BigEndian.Uint16(content[0:2]),
+		Replacement: binary.BigEndian.Uint16(content[2:4]),
+		Message:     string(content[deprecationHeaderSize:]),
+	}
+	if sunset := int64(binary.BigEndian.Uint64(content[4:12])); sunset != 0 {
+		d.Sunset = time.Unix(sunset, 0)
+	}
+	return d, nil
+}
+
+// deprecationWarnings records which deprecated operations a connection has already been warned about,
+// so that the connection is only sent a single DEPRECATED packet per operation before its sunset
+type deprecationWarnings struct {
+	mu     sync.Mutex
+	warned map[uint16]struct{}
+}
+
+// DeprecateOperation marks the given operation as deprecated. Clients that use a deprecated operation are sent a
+// DEPRECATED packet describing the deprecation (once per connection), and the operation is still handled by the server until
+// the sunset of the deprecation has passed. Once it has, packets for the operation are no longer handled, and every one
+// of them is answered with a DEPRECATED packet instead.
+//
+// DEPRECATED packets are only sent on connections that have negotiated the FeatureDeprecation feature. If the
+// operation is reserved, InvalidOperation is returned. This function should not be called once the server has started.
+func (s *Server) DeprecateOperation(deprecation Deprecation) error {
+	if deprecation.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if s.deprecations == nil {
+		s.deprecations = make(map[uint16]Deprecation)
+	}
+	s.deprecations[deprecation.Operation] = deprecation
+	return nil
+}
+
+// newDeprecationWarnings returns the deprecationWarnings for a connection, or nil if no operations have been deprecated
+func (s *Server) newDeprecationWarnings() *deprecationWarnings {
+	if s.deprecations == nil {
+		return nil
+	}
+	return &deprecationWarnings{warned: make(map[uint16]struct{})}
+}
+
+// sunset signals the deprecation of the operation of p to the connection (if it is deprecated), and returns
+// true if the sunset of the deprecation has passed so that the packet should not be handled
+func (s *Server) sunset(conn *Async, p *packet.Packet, warnings *deprecationWarnings) bool {
+	if warnings == nil {
+		return false
+	}
+	deprecation, ok := s.deprecations[p.Metadata.Operation]
+	if !ok {
+		return false
+	}
+	passed := deprecation.SunsetPassed(time.Now())
+	if !conn.features.Has(FeatureDeprecation) {
+		return passed
+	}
+	if !passed {
+		warnings.mu.Lock()
+		_, warned := warnings.warned[deprecation.Operation]
+		warnings.warned[deprecation.Operation] = struct{}{}
+		warnings.mu.Unlock()
+		if warned {
+			return false
+		}
+	}
+	notice := packet.Get()
+	notice.Metadata.Id = p.Metadata.Id
+	notice.Metadata.Operation = DEPRECATED
+	deprecation.encode(notice)
+	err := conn.writePacket(notice)
+	packet.Put(notice)
+	if err != nil {
+		s.Logger().Debug().Err(err).Uint16("Operation", deprecation.Operation).Msg("error while writing DEPRECATED packet")
+	}
+	return passed
+}
+
+// handleDeprecation decodes a DEPRECATED packet received by the client and passes it to the client's DeprecationHandler
+func (c *Client) handleDeprecation(p *packet.Packet) {
+	if c.options.DeprecationHandler == nil {
+		return
+	}
+	deprecation, err := decodeDeprecation((*p.Content)[:p.Metadata.ContentLength])
+	if err != nil {
+		c.Logger().Debug().Err(err).Msg("error while decoding DEPRECATED packet")
+		return
+	}
+	c.options.DeprecationHandler(deprecation)
+}
@@ -0,0 +1,129 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestDeprecationEncoding(t *testing.T) {
+	t.Parallel()
+
+	deprecation := Deprecation{
+		Operation:   32,
+		Replacement: 33,
+		Sunset:      time.Unix(1700000000, 0),
+		Message:     "use operation 33",
+	}
+	p := packet.Get()
+	deprecation.encode(p)
+	decoded, err := decodeDeprecation(*p.Content)
+	require.NoError(t, err)
+	assert.Equal(t, deprecation.Operation, decoded.Operation)
+	assert.Equal(t, deprecation.Replacement, decoded.Replacement)
+	assert.True(t, deprecation.Sunset.Equal(decoded.Sunset))
+	assert.Equal(t, deprecation.Message, decoded.Message)
+	packet.Put(p)
+
+	p = packet.Get()
+	Deprecation{Operation: 32}.encode(p)
+	decoded, err = decodeDeprecation(*p.Content)
+	require.NoError(t, err)
+	assert.True(t, decoded.Sunset.IsZero())
+	assert.False(t, decoded.SunsetPassed(time.Now()))
+	packet.Put(p)
+
+	_, err = decodeDeprecation(make([]byte, deprecationHeaderSize-1))
+	assert.ErrorIs(t, err, InvalidDeprecation)
+}
+
+func TestServerDeprecateOperation(t *testing.T) {
+	t.Parallel()
+
+	const deprecated = 10
+	const sunset = 11
+	const replacement = 12
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	handled := atomic.NewUint32(0)
+	handler := func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+		handled.Inc()
+		return nil, NONE
+	}
+	server, err := NewServer(HandlerTable{deprecated: handler, sunset: handler}, WithLogger(&emptyLogger), WithFeatures(FeatureDeprecation))
+	require.NoError(t, err)
+	server.SetConcurrency(1)
+
+	assert.ErrorIs(t, server.DeprecateOperation(Deprecation{Operation: PING}), InvalidOperation)
+	require.NoError(t, server.DeprecateOperation(Deprecation{Operation: deprecated, Replacement: replacement, Sunset: time.Now().Add(time.Hour)}))
+	require.NoError(t, server.DeprecateOperation(Deprecation{Operation: sunset, Replacement: replacement, Sunset: time.Now().Add(-time.Hour)}))
+
+	go func() {
+		_ = server.Start(conn.Listen)
+	}()
+	<-server.started()
+
+	deprecations := make(chan Deprecation, 8)
+	client, err := NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureDeprecation), WithDeprecationHandler(func(deprecation Deprecation) {
+		deprecations <- deprecation
+	}))
+	require.NoError(t, err)
+	require.NoError(t, client.Connect(server.listener.Addr().String()))
+
+	write := func(operation uint16) {
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		require.NoError(t, client.WritePacket(p))
+		packet.Put(p)
+	}
+
+	// Deprecated operations are still handled before their sunset, and the client is only warned once
+	write(deprecated)
+	write(deprecated)
+	d := <-deprecations
+	assert.Equal(t, uint16(deprecated), d.Operation)
+	assert.Equal(t, uint16(replacement), d.Replacement)
+	assert.False(t, d.SunsetPassed(time.Now()))
+	assert.Eventually(t, func() bool {
+		return handled.Load() == 2
+	}, time.Second, time.Millisecond*10)
+
+	// Operations whose sunset has passed are no longer handled, and every packet is answered
+	write(sunset)
+	write(sunset)
+	for i := 0; i < 2; i++ {
+		d = <-deprecations
+		assert.Equal(t, uint16(sunset), d.Operation)
+		assert.True(t, d.SunsetPassed(time.Now()))
+	}
+	assert.Equal(t, uint32(2), handled.Load())
+	assert.Len(t, deprecations, 0)
+
+	require.NoError(t, client.Close())
+	require.NoError(t, server.Shutdown())
+}
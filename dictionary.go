@@ -0,0 +1,144 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// helloParamDictionaries is the HELLO parameter that lists the compression dictionaries of a peer
+	helloParamDictionaries = uint8(3)
+
+	// dictionaryReferenceSize is the size of the reference to a compression dictionary in the HELLO parameter, which is
+	// its ID followed by a fingerprint of its content
+	dictionaryReferenceSize = 4 + 8
+)
+
+// CompressionDictionary is a pre-shared dictionary for compressing the content of packets, which holds content that
+// is typical for the packets of an application (like the keys and common values of telemetry JSON). Small packets
+// that repeat the content of the dictionary compress well even though they are too small to compress on their own.
+type CompressionDictionary struct {
+	// ID identifies the dictionary during the handshake, and must be unique among the dictionaries of a peer
+	ID uint32
+
+	// Data is the content of the dictionary, of which only the last 32KB are used
+	Data []byte
+}
+
+// minDictionaryLevel is the lowest compression level of compress/flate that finds matches in the dictionary for small
+// inputs. The faster levels compress small packets as if there was no dictionary, which defeats its purpose.
+const minDictionaryLevel = 7
+
+// dictionary is a CompressionDictionary that is ready to be used, along with its reference and its pools of writers
+// for each compression level from minDictionaryLevel to flate.BestCompression
+type dictionary struct {
+	CompressionDictionary
+	reference [dictionaryReferenceSize]byte
+	writers   [flate.BestCompression - minDictionaryLevel + 1]sync.Pool
+}
+
+// dictionaryLevel returns the compression level that content is compressed at with a dictionary, which is the given
+// level if it finds matches in the dictionary, and flate.BestCompression otherwise
+func dictionaryLevel(level int) int {
+	if level < minDictionaryLevel {
+		return flate.BestCompression
+	}
+	return level
+}
+
+// newDictionary prepares the given CompressionDictionary to be used
+func newDictionary(d CompressionDictionary) *dictionary {
+	prepared := &dictionary{CompressionDictionary: d}
+	binary.BigEndian.PutUint32(prepared.reference[:4], d.ID)
+	fingerprint := sha256.Sum256(d.Data)
+	copy(prepared.reference[4:], fingerprint[:])
+	return prepared
+}
+
+// dictionaryParams adds the references to the compression dictionaries to the HELLO parameters that should be sent
+// during the handshake, and returns the references along with the parameters (the references are nil if there are no
+// dictionaries or FeatureCompression is not enabled)
+func (o *Options) dictionaryParams(params map[uint8][]byte) ([]byte, map[uint8][]byte) {
+	if len(o.dictionaries) == 0 || !o.Features.Has(FeatureCompression) {
+		return nil, params
+	}
+	references := make([]byte, 0, len(o.dictionaries)*dictionaryReferenceSize)
+	for _, d := range o.dictionaries {
+		references = append(references, d.reference[:]...)
+	}
+	if params == nil {
+		params = make(map[uint8][]byte, 1)
+	}
+	params[helloParamDictionaries] = references
+	return references, params
+}
+
+// negotiateDictionary returns the compression dictionary that a connection should use, which is the first dictionary
+// in the references of the client that the server also has (with the same content). Both peers choose the same
+// dictionary without another round trip, since both know the references of the client and the server. If no
+// dictionary is shared or FeatureCompression was not negotiated, nil is returned.
+func (o *Options) negotiateDictionary(client []byte, server []byte, features Features) *dictionary {
+	if len(o.dictionaries) == 0 || !features.Has(FeatureCompression) {
+		return nil
+	}
+	for ; len(client) >= dictionaryReferenceSize; client = client[dictionaryReferenceSize:] {
+		reference := client[:dictionaryReferenceSize]
+		if !containsReference(server, reference) {
+			continue
+		}
+		for _, d := range o.dictionaries {
+			if bytes.Equal(d.reference[:], reference) {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// containsReference returns whether the list of dictionary references contains the given reference
+func containsReference(references []byte, reference []byte) bool {
+	for ; len(references) >= dictionaryReferenceSize; references = references[dictionaryReferenceSize:] {
+		if bytes.Equal(references[:dictionaryReferenceSize], reference) {
+			return true
+		}
+	}
+	return false
+}
+
+// withDictionary returns a copy of the options that compresses connections with the given (already negotiated) dictionary
+func (o *Options) withDictionary(d *dictionary) *Options {
+	if d == nil {
+		return o
+	}
+	options := *o
+	options.dictionary = d
+	return &options
+}
+
+// Dictionary returns the ID of the compression dictionary that was negotiated for the connection, and false if no
+// dictionary is used (see the WithCompressionDictionary option)
+func (c *Async) Dictionary() (uint32, bool) {
+	if c.options.dictionary == nil {
+		return 0, false
+	}
+	return c.options.dictionary.ID, true
+}
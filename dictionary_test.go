@@ -0,0 +1,144 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"compress/flate"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDictionary = []byte(`{"device":"sensor","metrics":{"temperature":{"unit":"celsius","value":0},"humidity":{"unit":"percent","value":0}}}`)
+	testTelemetry  = []byte(`{"device":"sensor","metrics":{"temperature":{"unit":"celsius","value":21},"humidity":{"unit":"percent","value":40}}}`)
+)
+
+func TestNegotiateDictionary(t *testing.T) {
+	t.Parallel()
+
+	client := loadOptions(WithFeatures(FeatureCompression), WithCompressionDictionary(1, []byte("first")), WithCompressionDictionary(2, []byte("second")))
+	server := loadOptions(WithFeatures(FeatureCompression), WithCompressionDictionary(2, []byte("second")), WithCompressionDictionary(1, []byte("first")))
+	mismatched := loadOptions(WithFeatures(FeatureCompression), WithCompressionDictionary(1, []byte("changed")))
+
+	clientReferences, params := client.dictionaryParams(nil)
+	assert.Len(t, params[helloParamDictionaries], 2*dictionaryReferenceSize)
+	serverReferences, _ := server.dictionaryParams(nil)
+	mismatchedReferences, _ := mismatched.dictionaryParams(nil)
+
+	// Both peers choose the first dictionary of the client that the server has
+	assert.Equal(t, uint32(1), client.negotiateDictionary(clientReferences, serverReferences, FeatureCompression).ID)
+	assert.Equal(t, uint32(1), server.negotiateDictionary(clientReferences, serverReferences, FeatureCompression).ID)
+
+	// Dictionaries with the same ID but different content are not shared
+	assert.Nil(t, client.negotiateDictionary(clientReferences, mismatchedReferences, FeatureCompression))
+	assert.Nil(t, client.negotiateDictionary(clientReferences, serverReferences, NoFeatures))
+
+	references, params := loadOptions(WithCompressionDictionary(1, []byte("first"))).dictionaryParams(nil)
+	assert.Nil(t, references)
+	assert.Nil(t, params)
+}
+
+func TestCompressionDictionary(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger), WithFeatures(FeatureCompression), WithCompressionDictionary(7, testDictionary))
+
+	reader, writer := net.Pipe()
+	plainConn := newAsync(reader, options, FeatureCompression)
+	dictConn := newAsync(writer, options.withDictionary(options.dictionaries[0]), FeatureCompression)
+
+	plain := plainConn.compress(metadata.PacketPing, testTelemetry)
+	compressed := dictConn.compress(metadata.PacketPing, testTelemetry)
+	assert.Less(t, compressed.Len(), plain.Len()/2)
+	compressionBuffers.Put(plain)
+	compressionBuffers.Put(compressed)
+
+	// Levels that ignore the dictionary for small packets are raised, and writers are pooled per level
+	assert.Equal(t, flate.BestCompression, dictionaryLevel(flate.DefaultCompression))
+	assert.Equal(t, flate.BestCompression, dictionaryLevel(flate.BestSpeed))
+	assert.Equal(t, 8, dictionaryLevel(8))
+
+	_, ok := plainConn.Dictionary()
+	assert.False(t, ok)
+	id, ok := dictConn.Dictionary()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(7), id)
+
+	assert.NoError(t, plainConn.Close())
+	assert.NoError(t, dictConn.Close())
+}
+
+func TestServerCompressionDictionary(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverConns := make(chan *Async, 1)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		serverConns <- ConnFromContext(ctx).(*Async)
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithFeatures(FeatureCompression), WithCompressionDictionary(7, testDictionary))
+	require.NoError(t, err)
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	received := make(chan string, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- string(*incoming.Content)
+		return
+	}
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureCompression),
+		WithCompressionDictionary(3, []byte("unknown")), WithCompressionDictionary(7, testDictionary))
+	require.NoError(t, err)
+	require.NoError(t, c.Connect(s.listener.Addr().String()))
+
+	id, ok := c.conn.Dictionary()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(7), id)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write(testTelemetry)
+	p.Metadata.ContentLength = uint32(len(testTelemetry))
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+	assert.Equal(t, string(testTelemetry), <-received)
+
+	id, ok = (<-serverConns).Dictionary()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(7), id)
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,230 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"go.uber.org/atomic"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// encryptionNonceSize is the size of the nonce that prefixes the content of every encrypted packet, which holds
+	// the direction of the packet followed by its sequence number
+	encryptionNonceSize = 12
+
+	// encryptionSequenceOffset is the offset of the sequence number in the nonce of an encrypted packet
+	encryptionSequenceOffset = encryptionNonceSize - 8
+
+	// helloParamEncryptionShare is the HELLO parameter that carries the ephemeral X25519 public key of a peer
+	helloParamEncryptionShare = uint8(2)
+)
+
+// encryptionLabel is the label used when deriving the encryption keys of a connection
+var encryptionLabel = []byte("frisbee encryption")
+
+// encryptionExchange is the ephemeral X25519 key pair that a peer uses to derive the encryption key of a
+// single connection
+type encryptionExchange struct {
+	private []byte
+	share   []byte
+}
+
+// newEncryptionExchange generates a new ephemeral X25519 key pair
+func newEncryptionExchange() (*encryptionExchange, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, private); err != nil {
+		return nil, err
+	}
+	share, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionExchange{private: private, share: share}, nil
+}
+
+// encryptionParams adds the public key of a new key exchange to the HELLO parameters that should be sent during
+// the handshake, and returns the exchange along with the parameters (the exchange is nil if encryption was not configured)
+func (o *Options) encryptionParams(params map[uint8][]byte) (*encryptionExchange, map[uint8][]byte, error) {
+	if o.EncryptionKey == nil {
+		return nil, params, nil
+	}
+	exchange, err := newEncryptionExchange()
+	if err != nil {
+		return nil, nil, err
+	}
+	if params == nil {
+		params = make(map[uint8][]byte, 1)
+	}
+	params[helloParamEncryptionShare] = exchange.share
+	return exchange, params, nil
+}
+
+// connectionEncryptionKeys returns the AES-256 keys that the client (if initiator is true) or the server should
+// encrypt and decrypt its packets with, given the key exchange of the connection and the public key that the peer
+// sent during the handshake. The keys are derived from the X25519 shared secret, which makes them forward secret,
+// and from EncryptionKey, which authenticates the exchange. Each direction of the connection has its own key, so that
+// packets cannot be reflected back to the side that wrote them. If encryption was not configured, no keys are returned.
+func (o *Options) connectionEncryptionKeys(exchange *encryptionExchange, peerShare []byte, initiator bool) (connectionKeys, error) {
+	if o.EncryptionKey == nil {
+		return connectionKeys{}, nil
+	}
+	if len(peerShare) != curve25519.PointSize {
+		return connectionKeys{}, InvalidHandshake
+	}
+	secret, err := curve25519.X25519(exchange.private, peerShare)
+	if err != nil {
+		return connectionKeys{}, InvalidHandshake
+	}
+	clientShare, serverShare := exchange.share, peerShare
+	if !initiator {
+		clientShare, serverShare = peerShare, exchange.share
+	}
+	derive := func(direction []byte) []byte {
+		mac := hmac.New(sha256.New, o.EncryptionKey)
+		mac.Write(encryptionLabel)
+		mac.Write(direction)
+		mac.Write(clientShare)
+		mac.Write(serverShare)
+		mac.Write(secret)
+		return mac.Sum(nil)
+	}
+	return directionalKeys(derive(clientToServerLabel), derive(serverToClientLabel), initiator), nil
+}
+
+// withEncryptionKeys returns a copy of the options that encrypts connections with the given (already derived) keys
+func (o *Options) withEncryptionKeys(keys connectionKeys) *Options {
+	if keys.write == nil {
+		return o
+	}
+	options := *o
+	options.encryptionKeys = keys
+	return &options
+}
+
+// contentCipher encrypts the content of the packets that a connection writes and decrypts the content of the
+// packets that it reads, each with the AES-GCM key of its direction. Every packet is encrypted with a nonce that holds
+// the next sequence number of the connection, so nonces are never reused and the keys do not have to be rotated.
+type contentCipher struct {
+	read           cipher.AEAD
+	write          cipher.AEAD
+	readDirection  byte
+	writeDirection byte
+	sequence       *atomic.Uint64
+}
+
+// newContentCipher returns the contentCipher for the given (already derived) encryption keys
+func newContentCipher(keys connectionKeys) (*contentCipher, error) {
+	read, err := newGCM(keys.read)
+	if err != nil {
+		return nil, err
+	}
+	write, err := newGCM(keys.write)
+	if err != nil {
+		return nil, err
+	}
+	readDirection, writeDirection := keys.directions()
+	return &contentCipher{
+		read:           read,
+		write:          write,
+		readDirection:  readDirection,
+		writeDirection: writeDirection,
+		sequence:       atomic.NewUint64(0),
+	}, nil
+}
+
+// newGCM returns the AES-GCM cipher for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypted returns whether the content of packets with the given operation is encrypted on the wire
+func (c *Async) encrypted(operation uint16) bool {
+	return c.encryption != nil && (operation > RESERVED9 || operation == STREAM)
+}
+
+// contentAdditionalData returns the additional data that the encryption of a packet's content is bound to, so that
+// encrypted content cannot be replayed in the other direction, with a different sequence number or with a different
+// operation or ID
+func contentAdditionalData(direction byte, sequence uint64, id uint16, operation uint16) []byte {
+	var data [13]byte
+	data[0] = direction
+	binary.BigEndian.PutUint64(data[1:9], sequence)
+	binary.BigEndian.PutUint16(data[9:11], id)
+	binary.BigEndian.PutUint16(data[11:], operation)
+	return data[:]
+}
+
+// encryptionBuffers is a pool of buffers used to hold encrypted content
+var encryptionBuffers = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// encrypt encrypts content for the wire, prefixing it with the nonce that was used. The returned buffer
+// must be returned to the encryptionBuffers pool once it has been written.
+func (c *Async) encrypt(id uint16, operation uint16, content []byte) (*[]byte, error) {
+	sequence := c.encryption.sequence.Inc()
+	if sequence == 0 {
+		return nil, EncryptionExhausted
+	}
+	buf := encryptionBuffers.Get().(*[]byte)
+	if size := encryptionNonceSize + len(content) + c.encryption.write.Overhead(); cap(*buf) < size {
+		*buf = make([]byte, 0, size)
+	}
+	nonce := (*buf)[:encryptionNonceSize]
+	for i := range nonce[:encryptionSequenceOffset] {
+		nonce[i] = 0
+	}
+	nonce[0] = c.encryption.writeDirection
+	binary.BigEndian.PutUint64(nonce[encryptionSequenceOffset:], sequence)
+	*buf = c.encryption.write.Seal(nonce, nonce, content, contentAdditionalData(c.encryption.writeDirection, sequence, id, operation))
+	return buf, nil
+}
+
+// decrypt decrypts the content of p in place after it has been read from the wire
+func (c *Async) decrypt(p *packet.Packet) error {
+	content := *p.Content
+	if len(content) < encryptionNonceSize+c.encryption.read.Overhead() {
+		return DecryptionFailed
+	}
+	sequence := binary.BigEndian.Uint64(content[encryptionSequenceOffset:encryptionNonceSize])
+	ciphertext := content[encryptionNonceSize:]
+	additionalData := contentAdditionalData(c.encryption.readDirection, sequence, p.Metadata.Id, p.Metadata.Operation)
+	plaintext, err := c.encryption.read.Open(ciphertext[:0], content[:encryptionNonceSize], ciphertext, additionalData)
+	if err != nil {
+		return DecryptionFailed
+	}
+	copy(content, plaintext)
+	*p.Content = content[:len(plaintext)]
+	setContentLength(p, len(plaintext))
+	return nil
+}
@@ -0,0 +1,268 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capturingConn records everything that is written to the underlying connection
+type capturingConn struct {
+	net.Conn
+	mu       sync.Mutex
+	captured bytes.Buffer
+}
+
+func (c *capturingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.captured.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *capturingConn) contains(b []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Contains(c.captured.Bytes(), b)
+}
+
+func TestAsyncEncryption(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 1 << 14
+
+	emptyLogger := zerolog.New(io.Discard)
+	encrypted := func(key string, initiator bool) *Options {
+		options := loadOptions(WithLogger(&emptyLogger))
+		clientToServer, serverToClient := make([]byte, 32), make([]byte, 32)
+		copy(clientToServer, key+" client")
+		copy(serverToClient, key+" server")
+		options.encryptionKeys = directionalKeys(clientToServer, serverToClient, initiator)
+		return options
+	}
+
+	for name, features := range map[string]Features{"plain": FeatureEncryption, "compressed": FeatureEncryption | FeatureCompression | FeatureExtendedHeaders} {
+		features := features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			capture := &capturingConn{Conn: writer}
+			readerConn := newAsync(reader, encrypted("connection key", false), features)
+			writerConn := newAsync(capture, encrypted("connection key", true), features)
+
+			large := bytes.Repeat([]byte("confidential"), testSize/12)
+			write := func(content []byte) {
+				p := packet.Get()
+				p.Metadata.Id = 7
+				p.Metadata.Operation = metadata.PacketPing
+				p.Content.Write(content)
+				p.Metadata.ContentLength = uint32(len(content))
+				require.NoError(t, writerConn.WritePacket(p))
+				packet.Put(p)
+			}
+			read := func(content []byte) {
+				p, err := readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, uint16(7), p.Metadata.Id)
+				assert.Equal(t, string(content), string(*p.Content))
+				packet.Put(p)
+			}
+
+			write([]byte("secret"))
+			read([]byte("secret"))
+			write(large)
+			read(large)
+			write(nil)
+			read(nil)
+			assert.False(t, capture.contains([]byte("secret")))
+			assert.False(t, capture.contains([]byte("confidential")))
+
+			_, err = writerConn.Forward(metadata.Metadata{Operation: metadata.PacketPing, ContentLength: testSize})
+			assert.ErrorIs(t, err, ForwardUnsupported)
+
+			assert.NoError(t, writerConn.Close())
+			assert.NoError(t, readerConn.Close())
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, encrypted("connection key", false), FeatureEncryption)
+		writerConn := newAsync(writer, encrypted("wrong key", true), FeatureEncryption)
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write([]byte("secret"))
+		p.Metadata.ContentLength = 6
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), DecryptionFailed)
+
+		_ = writerConn.Close()
+		_ = readerConn.Close()
+	})
+
+	t.Run("reflected", func(t *testing.T) {
+		t.Parallel()
+
+		// A packet written by the client is rejected when it is sent back to the client
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, encrypted("connection key", true), FeatureEncryption)
+		writerConn := newAsync(writer, encrypted("connection key", true), FeatureEncryption)
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write([]byte("secret"))
+		p.Metadata.ContentLength = 6
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), DecryptionFailed)
+
+		_ = writerConn.Close()
+		_ = readerConn.Close()
+	})
+}
+
+func TestEncryptionNonces(t *testing.T) {
+	t.Parallel()
+
+	keys := directionalKeys(make([]byte, 32), make([]byte, 32), true)
+	c := &Async{}
+	var err error
+	c.encryption, err = newContentCipher(keys)
+	require.NoError(t, err)
+
+	// Every packet is encrypted with the next sequence number instead of a random nonce
+	for sequence := uint64(1); sequence <= 3; sequence++ {
+		buf, err := c.encrypt(7, metadata.PacketPing, []byte("secret"))
+		require.NoError(t, err)
+		nonce := (*buf)[:encryptionNonceSize]
+		assert.Equal(t, directionClientToServer, nonce[0])
+		assert.Equal(t, sequence, binary.BigEndian.Uint64(nonce[encryptionSequenceOffset:]))
+		encryptionBuffers.Put(buf)
+	}
+
+	// and the sequence numbers are never reused
+	c.encryption.sequence.Store(^uint64(0))
+	_, err = c.encrypt(7, metadata.PacketPing, []byte("secret"))
+	assert.ErrorIs(t, err, EncryptionExhausted)
+}
+
+func TestEncryptionKeyExchange(t *testing.T) {
+	t.Parallel()
+
+	options := loadOptions(WithEncryptionKey([]byte("shared encryption key")))
+	client, params, err := options.encryptionParams(nil)
+	require.NoError(t, err)
+	assert.Equal(t, client.share, params[helloParamEncryptionShare])
+	server, _, err := options.encryptionParams(nil)
+	require.NoError(t, err)
+
+	// Both peers derive the same keys from the exchange, with a different key for each direction
+	clientKeys, err := options.connectionEncryptionKeys(client, server.share, true)
+	require.NoError(t, err)
+	serverKeys, err := options.connectionEncryptionKeys(server, client.share, false)
+	require.NoError(t, err)
+	assert.Equal(t, clientKeys.write, serverKeys.read)
+	assert.Equal(t, clientKeys.read, serverKeys.write)
+	assert.NotEqual(t, clientKeys.read, clientKeys.write)
+	assert.Len(t, clientKeys.write, 32)
+
+	// Every connection has its own keys, and peers with a different shared key derive different keys
+	other, _, err := options.encryptionParams(nil)
+	require.NoError(t, err)
+	otherKeys, err := options.connectionEncryptionKeys(other, server.share, true)
+	require.NoError(t, err)
+	assert.NotEqual(t, clientKeys.write, otherKeys.write)
+	mismatchedKeys, err := loadOptions(WithEncryptionKey([]byte("other encryption key"))).connectionEncryptionKeys(server, client.share, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, clientKeys.write, mismatchedKeys.read)
+
+	// Missing and low-order public keys are rejected
+	_, err = options.connectionEncryptionKeys(client, nil, true)
+	assert.ErrorIs(t, err, InvalidHandshake)
+	_, err = options.connectionEncryptionKeys(client, make([]byte, 32), true)
+	assert.ErrorIs(t, err, InvalidHandshake)
+}
+
+func TestServerEncryption(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	key := []byte("shared encryption key")
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithEncryptionKey(key))
+	require.NoError(t, err)
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	received := make(chan string, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- string(*incoming.Content)
+		return
+	}
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithEncryptionKey(key))
+	require.NoError(t, err)
+	require.NoError(t, c.Connect(s.listener.Addr().String()))
+	assert.True(t, c.Features().Has(FeatureEncryption))
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write([]byte("encrypted"))
+	p.Metadata.ContentLength = 9
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+	assert.Equal(t, "encrypted", <-received)
+	assert.NoError(t, c.Close())
+
+	assert.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,241 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// protocolErrors are the sentinel errors that indicate the remote peer violated the frisbee protocol,
+// and that are wrapped in a ProtocolError when they cause a connection to be closed
+var protocolErrors = []error{
+	InvalidSignature,
+	InvalidSequence,
+	InvalidExtension,
+	UnknownExtension,
+	InvalidContentEncoding,
+	InvalidStreamMode,
+	InvalidStreamPacket,
+	InvalidRekey,
+	InvalidDeprecation,
+	InvalidOperation,
+	PacketRejected,
+}
+
+// ProtocolError is returned when a connection was closed because the remote peer violated the frisbee protocol.
+//
+// It wraps the underlying sentinel error, so errors.Is(err, InvalidSignature) continues to work.
+type ProtocolError struct {
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol violation: " + e.Err.Error()
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectionClosedError is returned when an operation is attempted on a connection that has been closed.
+//
+// Cause is the error that caused the connection to be closed, and is nil if the connection was closed
+// without an error. The error satisfies errors.Is(err, ConnectionClosed), as well as errors.Is and errors.As
+// for the wrapped Cause.
+type ConnectionClosedError struct {
+	Cause error
+}
+
+func (e *ConnectionClosedError) Error() string {
+	if e.Cause == nil {
+		return ConnectionClosed.Error()
+	}
+	return ConnectionClosed.Error() + ": " + e.Cause.Error()
+}
+
+func (e *ConnectionClosedError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *ConnectionClosedError) Is(target error) bool {
+	return target == ConnectionClosed
+}
+
+// CloseReason is the category of the error that caused a connection to be closed (see CloseError)
+type CloseReason uint8
+
+const (
+	// CloseReasonNone means that the connection was closed without an error
+	CloseReasonNone CloseReason = iota
+
+	// CloseReasonReadTimeout means that the peer did not send anything before the read deadline of the connection
+	// passed (for example, because it stopped responding to the PING packets of the liveness check)
+	CloseReasonReadTimeout
+
+	// CloseReasonWriteTimeout means that the write deadline of the connection passed before the peer accepted the
+	// data that was written to it
+	CloseReasonWriteTimeout
+
+	// CloseReasonRemoteClose means that the peer closed or reset the connection
+	CloseReasonRemoteClose
+
+	// CloseReasonProtocol means that the peer violated the frisbee protocol (see ProtocolError)
+	CloseReasonProtocol
+
+	// CloseReasonNetwork means that the connection failed with any other error
+	CloseReasonNetwork
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonReadTimeout:
+		return "read-timeout"
+	case CloseReasonWriteTimeout:
+		return "write-timeout"
+	case CloseReasonRemoteClose:
+		return "remote-close"
+	case CloseReasonProtocol:
+		return "protocol"
+	case CloseReasonNetwork:
+		return "network"
+	}
+	return "none"
+}
+
+// CloseError is the error returned by Async.Error and Sync.Error (and passed to the OnDisconnect callback of
+// the Server) when a connection was closed because of an error. It wraps the error, along with its CloseReason.
+type CloseError struct {
+	Reason CloseReason
+	Err    error
+}
+
+func (e *CloseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+// CloseReasonOf returns the CloseReason of the error that caused a connection to be closed, which is
+// CloseReasonNone if err is nil
+func CloseReasonOf(err error) CloseReason {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Reason
+	}
+	return closeReason(classifyError(err))
+}
+
+// IsTimeout returns true if the error was caused by a read or write deadline being exceeded
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsPeerReset returns true if the error was caused by the remote peer resetting or abruptly closing the connection
+func IsPeerReset(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsProtocolError returns true if the error was caused by the remote peer violating the frisbee protocol
+func IsProtocolError(err error) bool {
+	var protocolErr *ProtocolError
+	return errors.As(err, &protocolErr)
+}
+
+// classifyError wraps protocol violations in a ProtocolError, and returns all other errors unchanged
+func classifyError(err error) error {
+	if err == nil || IsProtocolError(err) {
+		return err
+	}
+	for _, protocolErr := range protocolErrors {
+		if errors.Is(err, protocolErr) {
+			return &ProtocolError{Err: err}
+		}
+	}
+	return err
+}
+
+// closedError returns a ConnectionClosedError with the given cause, which is the error that closed the connection
+func closedError(cause error) error {
+	var closedErr *ConnectionClosedError
+	if errors.As(cause, &closedErr) {
+		return closedErr
+	}
+	if cause == ConnectionClosed {
+		cause = nil
+	}
+	return &ConnectionClosedError{Cause: cause}
+}
+
+// closeReason categorizes err, which is an error that caused a connection to be closed. Timeouts
+// are read timeouts unless the error says that it was caused by a write (see directed).
+func closeReason(err error) CloseReason {
+	switch {
+	case err == nil:
+		return CloseReasonNone
+	case IsProtocolError(err):
+		return CloseReasonProtocol
+	case IsTimeout(err):
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "write" {
+			return CloseReasonWriteTimeout
+		}
+		return CloseReasonReadTimeout
+	case IsPeerReset(err):
+		return CloseReasonRemoteClose
+	}
+	return CloseReasonNetwork
+}
+
+// closeError wraps err, which is an error that caused a connection to be closed, in a CloseError
+func closeError(err error) error {
+	var closeErr *CloseError
+	if err == nil || errors.As(err, &closeErr) {
+		return err
+	}
+	err = classifyError(err)
+	return &CloseError{Reason: closeReason(err), Err: err}
+}
+
+// directed adds the direction op ("read" or "write") to timeouts that do not already carry one (connections
+// other than net.TCPConn may return os.ErrDeadlineExceeded as is), so that their CloseReason is correct
+func directed(op string, err error) error {
+	if err == nil || !IsTimeout(err) {
+		return err
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	return &net.OpError{Op: op, Err: err}
+}
@@ -0,0 +1,219 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionClosedError(t *testing.T) {
+	t.Parallel()
+
+	err := error(&ConnectionClosedError{})
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.Equal(t, ConnectionClosed.Error(), err.Error())
+
+	err = &ConnectionClosedError{Cause: os.ErrDeadlineExceeded}
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Equal(t, "connection closed: "+os.ErrDeadlineExceeded.Error(), err.Error())
+	assert.True(t, IsTimeout(err))
+	assert.False(t, IsPeerReset(err))
+	assert.False(t, IsProtocolError(err))
+
+	var closedErr *ConnectionClosedError
+	require.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &closedErr))
+	assert.Equal(t, os.ErrDeadlineExceeded, closedErr.Cause)
+}
+
+func TestErrorClassification(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, IsTimeout(nil))
+	assert.False(t, IsPeerReset(nil))
+	assert.False(t, IsProtocolError(nil))
+
+	assert.True(t, IsTimeout(&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}))
+	assert.True(t, IsPeerReset(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}))
+	assert.True(t, IsPeerReset(&ConnectionClosedError{Cause: io.EOF}))
+	assert.False(t, IsTimeout(io.EOF))
+
+	err := classifyError(InvalidSignature)
+	assert.True(t, IsProtocolError(err))
+	assert.ErrorIs(t, err, InvalidSignature)
+	assert.Equal(t, "protocol violation: "+InvalidSignature.Error(), err.Error())
+	assert.Equal(t, err, classifyError(err))
+
+	assert.True(t, IsProtocolError(&ConnectionClosedError{Cause: classifyError(fmt.Errorf("reading: %w", PacketRejected))}))
+	assert.Equal(t, io.EOF, classifyError(io.EOF))
+	assert.Nil(t, classifyError(nil))
+}
+
+func TestProtocolErrorClose(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	signed := func(key string) *Options {
+		options := loadOptions(WithLogger(&emptyLogger))
+		options.signingKeys = connectionKeys{read: []byte(key), write: []byte(key)}
+		return options
+	}
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := newAsync(reader, signed("connection key"), NoFeatures)
+	writerConn := newAsync(writer, signed("wrong key"), NoFeatures)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	_, err = readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+
+	closeErr := readerConn.Error()
+	assert.True(t, IsProtocolError(closeErr))
+	assert.False(t, IsTimeout(closeErr))
+	assert.False(t, IsPeerReset(closeErr))
+	assert.ErrorIs(t, closeErr, InvalidSignature)
+	assert.Equal(t, CloseReasonProtocol, readerConn.CloseReason())
+
+	var protocolErr *ProtocolError
+	require.True(t, errors.As(closeErr, &protocolErr))
+	assert.Equal(t, InvalidSignature, protocolErr.Err)
+
+	// The errors returned once the connection is closed carry the error that closed it
+	var closedErr *ConnectionClosedError
+	require.True(t, errors.As(err, &closedErr))
+	assert.Equal(t, closeErr, closedErr.Cause)
+	assert.True(t, IsProtocolError(err))
+
+	p = packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = readerConn.WritePacket(p)
+	packet.Put(p)
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.ErrorIs(t, err, InvalidSignature)
+
+	_ = writerConn.Close()
+	_ = readerConn.Close()
+}
+
+func TestConnectionClosedCause(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := NewSync(reader, &emptyLogger)
+	writerConn := NewSync(writer, &emptyLogger)
+
+	// Connections that are closed without an error return a ConnectionClosedError without a cause
+	require.NoError(t, readerConn.Close())
+	_, err = readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+	var closedErr *ConnectionClosedError
+	require.True(t, errors.As(err, &closedErr))
+	assert.NoError(t, closedErr.Cause)
+	assert.Equal(t, ConnectionClosed.Error(), err.Error())
+
+	// The peer notices the reset once it reads from the connection
+	_, err = writerConn.ReadPacket()
+	assert.Error(t, err)
+	assert.True(t, IsPeerReset(writerConn.Error()))
+	assert.Equal(t, CloseReasonRemoteClose, writerConn.CloseReason())
+	assert.Equal(t, CloseReasonNone, readerConn.CloseReason())
+	_, err = writerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.True(t, IsPeerReset(err))
+	assert.False(t, IsTimeout(err))
+
+	_ = writerConn.Close()
+}
+
+func TestCloseReason(t *testing.T) {
+	t.Parallel()
+
+	reasons := map[error]CloseReason{
+		nil:                                       CloseReasonNone,
+		InvalidSignature:                          CloseReasonProtocol,
+		fmt.Errorf("%w", PacketRejected):          CloseReasonProtocol,
+		os.ErrDeadlineExceeded:                    CloseReasonReadTimeout,
+		directed("read", os.ErrDeadlineExceeded):  CloseReasonReadTimeout,
+		directed("write", os.ErrDeadlineExceeded): CloseReasonWriteTimeout,
+		io.EOF: CloseReasonRemoteClose,
+		&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}: CloseReasonRemoteClose,
+		errors.New("unreachable"): CloseReasonNetwork,
+	}
+	for err, reason := range reasons {
+		assert.Equal(t, reason, CloseReasonOf(err), "%v", err)
+		assert.Equal(t, reason, CloseReasonOf(closeError(err)), "%v", err)
+	}
+
+	// Timeouts that already carry their direction are left as they are
+	writeTimeout := &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}
+	assert.Equal(t, writeTimeout, directed("read", writeTimeout))
+	assert.Equal(t, io.EOF, directed("read", io.EOF))
+
+	err := closeError(InvalidSignature)
+	var closeErr *CloseError
+	require.True(t, errors.As(&ConnectionClosedError{Cause: err}, &closeErr))
+	assert.Equal(t, CloseReasonProtocol, closeErr.Reason)
+	assert.Equal(t, err, closeError(err))
+	assert.ErrorIs(t, err, InvalidSignature)
+	assert.Equal(t, "protocol", CloseReasonProtocol.String())
+	assert.Equal(t, "write-timeout", CloseReasonWriteTimeout.String())
+}
+
+func TestReadTimeoutCloseReason(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := NewSync(reader, &emptyLogger)
+	writerConn := NewSync(writer, &emptyLogger)
+
+	require.NoError(t, readerConn.SetReadDeadline(time.Now().Add(time.Millisecond*10)))
+	_, err = readerConn.ReadPacket()
+	assert.True(t, IsTimeout(err))
+	assert.Equal(t, CloseReasonReadTimeout, readerConn.CloseReason())
+
+	_, err = readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.Equal(t, CloseReasonReadTimeout, CloseReasonOf(err))
+
+	_ = readerConn.Close()
+	_ = writerConn.Close()
+}
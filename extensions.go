@@ -0,0 +1,201 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// When the FeatureExtendedHeaders feature has been negotiated, the metadata of every packet is followed by an
+// extended header, which is made up of a single byte holding the size of the extensions, followed by the extensions
+// themselves. Each extension is encoded as a type byte, a length byte, and then the value of the extension.
+//
+// Extensions with unknown types are handled according to the UnknownExtensionPolicy of the receiver (by default they are
+// skipped), unless an ExtensionHandler has been registered for them.
+//
+// The wire format of the extensions is implemented by the frame package.
+const (
+	// extensionInline carries the entire content of a small packet, in which case the
+	// ContentLength in the packet's metadata is 0 and no content follows the extended header
+	extensionInline = frame.ExtensionInline
+
+	// extensionSequence carries the sequence number of the packet as a uint64 when the FeatureSequenceNumbers
+	// feature has been negotiated. It is always the first extension, so that it can be stamped into an already
+	// encoded header right before the packet is written (see Async.stampSequence).
+	extensionSequence = frame.ExtensionSequence
+
+	// extensionDelivery carries the delivery ID of a packet written by an Outbox as a uint64 when the
+	// FeatureAcknowledgements feature has been negotiated, which the receiver acknowledges with an ACK packet
+	extensionDelivery = frame.ExtensionDelivery
+
+	// extensionContentType carries the content type hint of a packet (see packet.Packet.ContentType) as a single byte
+	extensionContentType = frame.ExtensionContentType
+)
+
+const (
+	// DefaultInlineThreshold is the default size (in bytes) at or below which the content of a packet is
+	// inlined into its extended header
+	DefaultInlineThreshold = 32
+
+	// MaxInlineThreshold is the largest supported inline threshold, which leaves room in the
+	// extended header for other extensions
+	MaxInlineThreshold = 128
+
+	// extendedHeaderSize is the maximum size of the packet metadata along with its extended header
+	extendedHeaderSize = frame.MaxHeaderSize
+)
+
+// extendedHeaders is a pool of buffers used to encode the metadata and extended header of outgoing packets
+var extendedHeaders = sync.Pool{
+	New: func() interface{} {
+		return new([extendedHeaderSize]byte)
+	},
+}
+
+// extended returns whether the packets on the connection carry an extended header
+func (c *Async) extended() bool {
+	return c.features.Has(FeatureExtendedHeaders) || c.sequenced() || c.features.Has(FeatureAcknowledgements) || c.features.Has(FeatureLargeContent)
+}
+
+// inlineThreshold returns the size at or below which the content of packets written to the connection is inlined
+// into their extended header, which is 0 unless the FeatureExtendedHeaders feature has been negotiated
+func (c *Async) inlineThreshold() int {
+	if c.features.Has(FeatureExtendedHeaders) {
+		return c.options.InlineThreshold
+	}
+	return 0
+}
+
+// encodeExtendedHeader appends the extended header of a packet to header (which must already hold the
+// packet's metadata), inlining the content if it is no larger than threshold. It returns the encoded header
+// along with the content that still needs to be written after it.
+//
+// If sequenced is true, space is reserved for the sequence extension, which must be stamped before the header is written.
+func encodeExtendedHeader(header []byte, content []byte, threshold int, sequenced bool) ([]byte, []byte) {
+	sizeOffset := len(header)
+	header = append(header, 0)
+	if sequenced {
+		header = append(header, extensionSequence, sequenceSize)
+		header = append(header, make([]byte, sequenceSize)...)
+	}
+	if len(content) > 0 && len(content) <= threshold {
+		header = append(header, extensionInline, uint8(len(content)))
+		header = append(header, content...)
+		content = nil
+	}
+	header[sizeOffset] = uint8(len(header) - sizeOffset - 1)
+	return header, content
+}
+
+// appendContentType appends the content type extension with the given content type to an encoded extended header
+func appendContentType(header []byte, contentType frame.ContentType) []byte {
+	header = append(header, extensionContentType, 1, uint8(contentType))
+	header[metadata.Size] += 3
+	return header
+}
+
+// decodeExtensions applies the extensions of an extended header to p, and returns whether the content
+// of the packet was inlined into the extended header along with the sequence number of the packet
+// (which is 0 if the packet did not carry one).
+//
+// Extensions with unknown types are passed to unknown (if it is not nil) along with the metadata of
+// the packet as it appeared on the wire, and the packet is rejected if it returns an error.
+func decodeExtensions(p *packet.Packet, extensions []byte, unknown func(metadata.Metadata, frame.Extension) error) (inline bool, sequence uint64, err error) {
+	m := *p.Metadata
+	for len(extensions) > 0 {
+		var extension frame.Extension
+		extension, extensions, err = frame.NextExtension(extensions)
+		if err != nil {
+			return false, 0, InvalidExtension
+		}
+		switch extension.Type {
+		case extensionInline:
+			if inline || p.Metadata.ContentLength != 0 {
+				return false, 0, InvalidExtension
+			}
+			p.Content.Write(extension.Value)
+			p.Metadata.ContentLength = uint32(len(extension.Value))
+			inline = true
+		case extensionSequence:
+			if sequence != 0 || len(extension.Value) != sequenceSize {
+				return false, 0, InvalidExtension
+			}
+			sequence = binary.BigEndian.Uint64(extension.Value)
+		case extensionDelivery:
+			if len(extension.Value) != deliverySize {
+				return false, 0, InvalidExtension
+			}
+		case extensionContentLength:
+			if len(extension.Value) != contentLengthSize || p.Metadata.ContentLength != metadata.LargeContentLength {
+				return false, 0, InvalidExtension
+			}
+		case extensionContentType:
+			if len(extension.Value) != 1 {
+				return false, 0, InvalidExtension
+			}
+			p.ContentType = frame.ContentType(extension.Value[0])
+		default:
+			if unknown != nil {
+				if err = unknown(m, extension); err != nil {
+					return false, 0, err
+				}
+			}
+		}
+	}
+	return inline, sequence, nil
+}
+
+// UnknownExtensionPolicy decides what a connection does when it reads a packet with an extension that it does not
+// know about (and that no ExtensionHandler has been registered for)
+type UnknownExtensionPolicy uint8
+
+const (
+	// IgnoreUnknownExtensions skips over unknown extensions, which keeps connections forward-compatible with peers
+	// that send newer extensions
+	IgnoreUnknownExtensions UnknownExtensionPolicy = iota
+
+	// LogUnknownExtensions skips over unknown extensions, and logs a warning for every one of them
+	LogUnknownExtensions
+
+	// RejectUnknownExtensions closes the connection with the UnknownExtension error
+	RejectUnknownExtensions
+)
+
+// ExtensionHandler is called by the read loop of a connection with the metadata (as it appeared on the wire) of every
+// incoming packet that carries the extension that the handler was registered for (see WithExtensionHandler), along
+// with the value of the extension. The value is only valid until the handler returns, and the handler must not block.
+type ExtensionHandler func(m metadata.Metadata, value []byte)
+
+// unknownExtension handles an extension of an incoming packet that frisbee does not know about
+func (c *Async) unknownExtension(m metadata.Metadata, extension frame.Extension) error {
+	if handler := c.options.ExtensionHandlers[extension.Type]; handler != nil {
+		handler(m, extension.Value)
+		return nil
+	}
+	switch c.options.UnknownExtensions {
+	case LogUnknownExtensions:
+		c.Logger().Warn().Uint8("Extension", extension.Type).Uint16("Operation", m.Operation).Msg("unknown extension in packet")
+	case RejectUnknownExtensions:
+		return UnknownExtension
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedHeader(t *testing.T) {
+	t.Parallel()
+
+	small := []byte("ack")
+	header, content := encodeExtendedHeader(make([]byte, metadata.Size), small, DefaultInlineThreshold, false)
+	assert.Nil(t, content)
+	assert.Equal(t, metadata.Size+1+2+len(small), len(header))
+
+	p := packet.Get()
+	inline, _, err := decodeExtensions(p, header[metadata.Size+1:], nil)
+	require.NoError(t, err)
+	assert.True(t, inline)
+	assert.Equal(t, uint32(len(small)), p.Metadata.ContentLength)
+	assert.Equal(t, small, p.Content.Bytes())
+	packet.Put(p)
+
+	large := make([]byte, DefaultInlineThreshold+1)
+	header, content = encodeExtendedHeader(make([]byte, metadata.Size), large, DefaultInlineThreshold, false)
+	assert.Equal(t, large, content)
+	assert.Equal(t, metadata.Size+1, len(header))
+
+	_, content = encodeExtendedHeader(make([]byte, metadata.Size), small, -1, false)
+	assert.Equal(t, small, content)
+
+	p = packet.Get()
+	inline, _, err = decodeExtensions(p, []byte{0xFF, 2, 1, 2}, nil)
+	require.NoError(t, err)
+	assert.False(t, inline)
+
+	_, _, err = decodeExtensions(p, []byte{extensionInline, 4, 1}, nil)
+	assert.ErrorIs(t, err, InvalidExtension)
+
+	p.Metadata.ContentLength = 1
+	_, _, err = decodeExtensions(p, []byte{extensionInline, 1, 1}, nil)
+	assert.ErrorIs(t, err, InvalidExtension)
+	packet.Put(p)
+
+	options := loadOptions()
+	assert.Equal(t, DefaultInlineThreshold, options.InlineThreshold)
+
+	options = loadOptions(WithInlineThreshold(MaxInlineThreshold * 2))
+	assert.Equal(t, MaxInlineThreshold, options.InlineThreshold)
+}
+
+func TestAsyncExtendedHeaders(t *testing.T) {
+	t.Parallel()
+
+	const packetCount = 512
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+
+	for _, features := range []Features{FeatureExtendedHeaders, FeatureExtendedHeaders | FeatureCompression} {
+		reader, writer := net.Pipe()
+
+		readerConn := newAsync(reader, options, features)
+		writerConn := newAsync(writer, options, features)
+
+		sizes := []int{0, 1, DefaultInlineThreshold, DefaultInlineThreshold + 1, 1024}
+		go func() {
+			for i := 0; i < packetCount; i++ {
+				p := packet.Get()
+				p.Metadata.Id = uint16(i)
+				p.Metadata.Operation = metadata.PacketPing
+				p.Content.Write(bytes.Repeat([]byte{byte(i)}, sizes[i%len(sizes)]))
+				p.Metadata.ContentLength = uint32(len(*p.Content))
+				err := writerConn.WritePacket(p)
+				packet.Put(p)
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < packetCount; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(i), p.Metadata.Id)
+			assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+			assert.Equal(t, uint32(sizes[i%len(sizes)]), p.Metadata.ContentLength)
+			assert.Equal(t, bytes.Repeat([]byte{byte(i)}, sizes[i%len(sizes)]), []byte(*p.Content))
+			packet.Put(p)
+		}
+
+		err := readerConn.Close()
+		assert.NoError(t, err)
+		err = writerConn.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func TestFrameCompatibility(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	options := loadOptions(WithLogger(&emptyLogger))
+	options.signingKeys = connectionKeys{read: []byte("connection key"), write: []byte("connection key")}
+	writerConn := newAsync(writer, options, FeatureExtendedHeaders|FeatureSequenceNumbers|FeatureSigning)
+
+	contents := [][]byte{[]byte("small"), make([]byte, DefaultInlineThreshold*4)}
+	for i, content := range contents {
+		p := packet.Get()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write(content)
+		p.Metadata.ContentLength = uint32(len(content))
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+	}
+
+	// Packets written by a connection can be decoded by the frame package
+	decoder := frame.NewDecoder(reader, frame.Format{Extended: true, Signed: true})
+	for i, content := range contents {
+		f, err := decoder.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), f.Metadata.Id)
+		assert.Equal(t, metadata.PacketPing, f.Metadata.Operation)
+		assert.Equal(t, content, f.Payload())
+		sequence, ok := f.Sequence()
+		assert.True(t, ok)
+		assert.Equal(t, uint64(i+1), sequence)
+		assert.Len(t, f.Signature, signatureSize)
+	}
+
+	_ = writerConn.Close()
+	_ = reader.Close()
+}
+
+func TestContentType(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	options := loadOptions(WithLogger(&emptyLogger))
+	readerConn := newAsync(reader, options, FeatureExtendedHeaders)
+	writerConn := newAsync(writer, options, FeatureExtendedHeaders)
+
+	contents := []struct {
+		contentType frame.ContentType
+		content     []byte
+	}{
+		{frame.ContentTypeJSON, []byte(`{"small":true}`)},
+		{frame.ContentTypeProtobuf, make([]byte, DefaultInlineThreshold*4)},
+		{frame.ContentTypeUnspecified, []byte("untyped")},
+	}
+	for i, c := range contents {
+		p := packet.Get()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = metadata.PacketPing
+		p.ContentType = c.contentType
+		p.Content.Write(c.content)
+		p.Metadata.ContentLength = uint32(len(c.content))
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+	}
+
+	for i, c := range contents {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), p.Metadata.Id)
+		assert.Equal(t, c.contentType, p.ContentType)
+		assert.Equal(t, c.content, []byte(*p.Content))
+		packet.Put(p)
+	}
+
+	_ = readerConn.Close()
+	_ = writerConn.Close()
+
+	// The content type can be read by the frame package without a connection
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.ContentType = frame.ContentTypeText
+	header, _ := encodeExtendedHeader(make([]byte, metadata.Size), nil, 0, false)
+	header = appendContentType(header, p.ContentType)
+	f, _, err := frame.Decode(header, frame.Format{Extended: true})
+	require.NoError(t, err)
+	assert.Equal(t, frame.ContentTypeText, f.ContentType())
+
+	// Decoding a malformed content type extension fails
+	_, _, err = decodeExtensions(p, []byte{extensionContentType, 2, 1, 2}, nil)
+	assert.ErrorIs(t, err, InvalidExtension)
+	packet.Put(p)
+}
+
+func TestUnknownExtensions(t *testing.T) {
+	t.Parallel()
+
+	const registeredExtension = uint8(0x70)
+	const unknownExtension = uint8(0x71)
+
+	for name, policy := range map[string]UnknownExtensionPolicy{"ignore": IgnoreUnknownExtensions, "log": LogUnknownExtensions, "reject": RejectUnknownExtensions} {
+		policy := policy
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var logs bytes.Buffer
+			logger := zerolog.New(&logs)
+			handled := make(chan string, 2)
+			options := loadOptions(WithLogger(&logger), WithUnknownExtensionPolicy(policy), WithExtensionHandler(registeredExtension, func(m metadata.Metadata, value []byte) {
+				assert.Equal(t, metadata.PacketPing, m.Operation)
+				handled <- string(value)
+			}))
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			readerConn := newAsync(reader, options, FeatureExtendedHeaders)
+
+			write := func(id uint16, extensions ...frame.Extension) {
+				f := &frame.Frame{Metadata: metadata.Metadata{Id: id, Operation: metadata.PacketPing}, Extensions: extensions, Content: []byte("content")}
+				b, err := f.Append(nil, frame.Format{Extended: true})
+				require.NoError(t, err)
+				_, err = writer.Write(b)
+				require.NoError(t, err)
+			}
+
+			// Registered extensions are passed to their handler regardless of the policy
+			write(1, frame.Extension{Type: registeredExtension, Value: []byte("registered")})
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(1), p.Metadata.Id)
+			assert.Equal(t, "content", string(*p.Content))
+			packet.Put(p)
+			assert.Equal(t, "registered", <-handled)
+
+			write(2, frame.Extension{Type: unknownExtension, Value: []byte("unknown")})
+			if policy == RejectUnknownExtensions {
+				_, err = readerConn.ReadPacket()
+				assert.Error(t, err)
+				assert.ErrorIs(t, readerConn.Error(), UnknownExtension)
+			} else {
+				p, err = readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, uint16(2), p.Metadata.Id)
+				packet.Put(p)
+				assert.Equal(t, policy == LogUnknownExtensions, bytes.Contains(logs.Bytes(), []byte("unknown extension in packet")))
+			}
+
+			_ = writer.Close()
+			_ = readerConn.Close()
+		})
+	}
+}
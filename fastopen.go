@@ -0,0 +1,54 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"github.com/loopholelabs/frisbee-go/internal/dialer"
+)
+
+// fastOpenQueueLength is the maximum number of pending TCP Fast Open requests of a server's listener
+const fastOpenQueueLength = 256
+
+// listenFastOpen listens on addr with TCP Fast Open enabled on the listening socket (where the platform supports it)
+func listenFastOpen(network string, addr string) (net.Listener, error) {
+	config := net.ListenConfig{
+		Control: func(_ string, _ string, rc syscall.RawConn) error {
+			return control(rc, func(fd uintptr) error {
+				setFastOpenListener(fd, fastOpenQueueLength)
+				return nil
+			})
+		},
+	}
+	return config.Listen(context.Background(), network, addr)
+}
+
+// fastOpenDialer returns a dialer that enables TCP Fast Open on the sockets that it dials (where the platform supports it),
+// so that the first bytes written to a connection are sent in the SYN packet when the client has a Fast Open cookie for the server
+func fastOpenDialer() *dialer.Retry {
+	d := dialer.NewRetry()
+	d.Control = func(_ string, _ string, rc syscall.RawConn) error {
+		return control(rc, func(fd uintptr) error {
+			setFastOpenConnect(fd)
+			return nil
+		})
+	}
+	return d
+}
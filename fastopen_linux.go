@@ -0,0 +1,35 @@
+//go:build linux
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setFastOpenListener sets the TCP_FASTOPEN socket option on the given listening socket, and ignores
+// errors (like when Fast Open is disabled by the net.ipv4.tcp_fastopen sysctl) so that the listener falls back to regular TCP
+func setFastOpenListener(fd uintptr, queueLength int) {
+	_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, queueLength)
+}
+
+// setFastOpenConnect sets the TCP_FASTOPEN_CONNECT socket option on the given socket before it connects, and ignores
+// errors (like on kernels older than 4.11) so that the connection falls back to regular TCP
+func setFastOpenConnect(fd uintptr) {
+	_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+}
@@ -0,0 +1,74 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastOpen(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithFastOpen())
+	require.NoError(t, err)
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	received := make(chan struct{}, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- struct{}{}
+		return
+	}
+
+	// The first connection gets a Fast Open cookie from the server (if Fast Open is available), which the
+	// second connection uses to send its first packet in the SYN
+	for i := 0; i < 2; i++ {
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithFastOpen())
+		require.NoError(t, err)
+		require.NoError(t, c.Connect(s.listener.Addr().String()))
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		require.NoError(t, c.WritePacket(p))
+		packet.Put(p)
+		<-received
+		assert.NoError(t, c.Close())
+	}
+
+	assert.NoError(t, s.Shutdown())
+}
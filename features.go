@@ -0,0 +1,144 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"hash/fnv"
+	"net"
+
+	"go.uber.org/atomic"
+)
+
+// Features is a bitmask of optional wire behaviours that are negotiated between
+// a frisbee client and server during the handshake.
+//
+// A feature is only ever enabled on a connection if the client requested it, the server
+// supports it, and the server's FeaturePolicy allowed it.
+type Features uint32
+
+// NoFeatures is the empty feature set
+const NoFeatures = Features(0)
+
+// These are the Features that can be negotiated during the handshake:
+const (
+	// FeatureRekey allows REKEY packets to be sent to rotate the symmetric keys of a connection
+	FeatureRekey = Features(1 << iota)
+
+	// FeatureCompression allows the content of packets to be compressed (see the WithCompression option)
+	FeatureCompression
+
+	// FeatureExtendedHeaders adds an extended header to every packet, which allows small packets to carry
+	// their content inline in the header (see the WithInlineThreshold option)
+	FeatureExtendedHeaders
+
+	// FeatureStreamClose closes streams with a dedicated STREAMCLOSE packet instead of an empty STREAM packet,
+	// which allows empty messages to be sent on streams
+	FeatureStreamClose
+
+	// FeatureByteStreams allows streams to be opened in ByteMode, where the boundaries between packets are
+	// not preserved (see Async.OpenStream)
+	FeatureByteStreams
+
+	// FeatureSigning appends an HMAC of the header and content to every packet, which is verified by the
+	// receiver (see the WithSigningKey option)
+	FeatureSigning
+
+	// FeatureSequenceNumbers adds a sequence number to the extended header of every packet, which is validated
+	// by the receiver to reject duplicate or replayed packets (see the WithSequenceNumbers option)
+	FeatureSequenceNumbers
+
+	// FeatureDeprecation allows the server to send DEPRECATED packets when the client uses a deprecated operation
+	// (see Server.DeprecateOperation and the WithDeprecationHandler option)
+	FeatureDeprecation
+
+	// FeatureStreamReset allows streams to be reset with an error code, which is sent to the peer in the content of
+	// the STREAMCLOSE packet (see Stream.CloseWithError)
+	FeatureStreamReset
+
+	// FeatureAcknowledgements allows packets to carry delivery IDs that the receiver acknowledges with ACK packets,
+	// which an Outbox uses to deliver packets at least once (see NewOutbox)
+	FeatureAcknowledgements
+
+	// FeatureHealth allows PING packets to carry an ID, in which case they are health checks that are answered with
+	// the Health of the receiver in the content of the PONG packet (see Async.HealthCheck)
+	FeatureHealth
+
+	// FeatureControl allows AUTH packets to be sent once the connection has been established, in which case they
+	// are control packets for the control operation in their ID (see Async.WriteControl and WithControlHandler)
+	FeatureControl
+
+	// FeatureEncryption encrypts the content of every packet with AES-GCM, using a key derived from an X25519 key
+	// exchange during the handshake (see the WithEncryptionKey option)
+	FeatureEncryption
+
+	// FeatureStreamCompression compresses the content of the packets of every stream with a persistent compression
+	// context per stream, independently of FeatureCompression (see the WithStreamCompression option)
+	FeatureStreamCompression
+
+	// FeatureLargeContent allows packets to carry content that does not fit in a uint32, whose length is carried
+	// as a uint64 in the extended header of the packet (see metadata.LargeContentLength)
+	FeatureLargeContent
+
+	// FeatureCompactMetadata encodes the Id, Operation, and ContentLength in the metadata of every packet as varints,
+	// which shrinks the metadata of packets with small IDs, operations, and content from 8 bytes to as little as 3
+	FeatureCompactMetadata
+)
+
+// Has returns whether all the features in f are present in the feature set
+func (fs Features) Has(f Features) bool {
+	return fs&f == f
+}
+
+// FeaturePolicy is called by the server during the handshake for every incoming connection with the
+// remote address of the connection and the features that were requested by the client (already limited to
+// the features supported by the server). It returns the features that should be enabled for the connection.
+//
+// Any features returned by the policy that were not requested are ignored.
+type FeaturePolicy func(remote net.Addr, requested Features) Features
+
+// defaultFeaturePolicy enables every requested feature
+func defaultFeaturePolicy(_ net.Addr, requested Features) Features {
+	return requested
+}
+
+// RolloutPolicy returns a FeaturePolicy that enables the given features for roughly percent% of
+// connections (the decision is stable for a given remote IP), and passes through all other requested features.
+//
+// The percentage is read for every new connection, so it can be raised gradually during a rollout or
+// set to 0 to act as a kill-switch without restarting the server.
+func RolloutPolicy(features Features, percent *atomic.Uint32) FeaturePolicy {
+	return func(remote net.Addr, requested Features) Features {
+		if rolloutBucket(remote) >= percent.Load() {
+			return requested &^ features
+		}
+		return requested
+	}
+}
+
+// rolloutBucket deterministically maps a remote address to a bucket between 0 and 99
+func rolloutBucket(remote net.Addr) uint32 {
+	if remote == nil {
+		return 0
+	}
+	host := remote.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(host))
+	return hash.Sum32() % 100
+}
@@ -0,0 +1,62 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+)
+
+// FilterAction is returned by a Filter to decide what happens to an incoming packet
+type FilterAction int
+
+// These are the actions that a Filter can take for an incoming packet:
+const (
+	// FilterAccept reads the packet and queues it as usual (default)
+	FilterAccept = FilterAction(iota)
+
+	// FilterDrop discards the packet without reading its content into a packet
+	FilterDrop
+
+	// FilterReject discards the packet and closes the connection with the PacketRejected error
+	FilterReject
+
+	// FilterClose discards the packet and closes the connection without an error
+	FilterClose
+)
+
+// Filter is called by the read loop of a connection with the metadata of every incoming packet (other than packets
+// with reserved operations) before its content is read, which allows unwanted packets from untrusted peers to be
+// discarded with minimal cost. It must not block, since no packets can be read while it is running.
+//
+// The ContentLength of the metadata is the size of the content on the wire, which is smaller than the size of the
+// content that would be read if compression was negotiated for the connection.
+type Filter func(metadata.Metadata) FilterAction
+
+// OperationFilter returns a Filter that accepts packets with the given operations,
+// and takes the given action for packets with any other operation
+func OperationFilter(action FilterAction, operations ...uint16) Filter {
+	allowed := make(map[uint16]struct{}, len(operations))
+	for _, operation := range operations {
+		allowed[operation] = struct{}{}
+	}
+	return func(m metadata.Metadata) FilterAction {
+		if _, ok := allowed[m.Operation]; ok {
+			return FilterAccept
+		}
+		return action
+	}
+}
@@ -0,0 +1,109 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	const allowedOperation = uint16(11)
+	const blockedOperation = uint16(12)
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	write := func(t *testing.T, c *Async, operation uint16, size int) {
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		content := make([]byte, size)
+		_, _ = rand.Read(content)
+		p.Content.Write(content)
+		p.Metadata.ContentLength = uint32(size)
+		err := c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+	}
+
+	for name, features := range map[string]Features{"plain": NoFeatures, "extended": FeatureExtendedHeaders} {
+		features := features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("drop", func(t *testing.T) {
+				reader, writer, err := pair.New()
+				require.NoError(t, err)
+
+				readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithFilter(OperationFilter(FilterDrop, allowedOperation))), features)
+				writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), features)
+
+				for _, size := range []int{0, 16, DefaultBufferSize * 3} {
+					write(t, writerConn, blockedOperation, size)
+					write(t, writerConn, allowedOperation, size)
+
+					p, err := readerConn.ReadPacket()
+					require.NoError(t, err)
+					assert.Equal(t, allowedOperation, p.Metadata.Operation)
+					assert.Equal(t, uint32(size), p.Metadata.ContentLength)
+					assert.Equal(t, size, len(*p.Content))
+					packet.Put(p)
+				}
+
+				assert.NoError(t, readerConn.Close())
+				assert.NoError(t, writerConn.Close())
+			})
+
+			t.Run("reject", func(t *testing.T) {
+				reader, writer, err := pair.New()
+				require.NoError(t, err)
+
+				readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithFilter(OperationFilter(FilterReject, allowedOperation))), features)
+				writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), features)
+
+				write(t, writerConn, blockedOperation, 16)
+				require.Eventually(t, readerConn.Closed, time.Second, time.Millisecond)
+				assert.ErrorIs(t, readerConn.Error(), PacketRejected)
+
+				_ = writerConn.Close()
+			})
+
+			t.Run("close", func(t *testing.T) {
+				reader, writer, err := pair.New()
+				require.NoError(t, err)
+
+				readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithFilter(OperationFilter(FilterClose, allowedOperation))), features)
+				writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), features)
+
+				write(t, writerConn, blockedOperation, 16)
+				require.Eventually(t, readerConn.Closed, time.Second, time.Millisecond)
+				assert.NoError(t, readerConn.Error())
+
+				_ = writerConn.Close()
+			})
+		})
+	}
+}
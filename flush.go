@@ -0,0 +1,139 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"time"
+)
+
+// DefaultFlushDelay is the longest time that a FlushStrategy lets a packet wait in the write buffer when its Delay is not set
+const DefaultFlushDelay = time.Millisecond
+
+// FlushStrategy controls when the packets written to a connection are flushed to the underlying connection. The zero value
+// flushes the write buffer as soon as possible after every write, which minimizes latency. Batching writes instead
+// cuts the number of syscalls dramatically for workloads that write many small packets, while Delay keeps the added
+// latency bounded.
+//
+// Packets are always flushed once the write buffer (of DefaultBufferSize bytes) is full, and Async.Flush flushes
+// the write buffer right away regardless of the strategy.
+type FlushStrategy struct {
+	// Bytes flushes the write buffer as soon as it holds at least this many bytes
+	Bytes int
+
+	// Delay is the longest time that a packet waits in the write buffer before it is flushed
+	// (defaults to DefaultFlushDelay if Bytes is set or Adaptive is true)
+	Delay time.Duration
+
+	// Adaptive observes the rate at which packets are written, and only batches them while they are being
+	// written faster than Delay apart. Packets that are written at least Delay after the previous one (or while
+	// the average interval between writes is at least Delay) are flushed right away, since waiting for more
+	// packets would only add latency.
+	Adaptive bool
+}
+
+// immediate returns true if the strategy flushes the write buffer after every write
+func (f FlushStrategy) immediate() bool {
+	return f.Bytes <= 0 && f.Delay <= 0 && !f.Adaptive
+}
+
+// withDefaults replaces the zero Delay of a batching strategy with DefaultFlushDelay
+func (f FlushStrategy) withDefaults() FlushStrategy {
+	if !f.immediate() && f.Delay <= 0 {
+		f.Delay = DefaultFlushDelay
+	}
+	return f
+}
+
+// flushRate tracks the average interval between the writes of a connection for adaptive flushing
+type flushRate struct {
+	last     time.Time
+	interval time.Duration
+}
+
+// observe records a write at the given time, and returns the average interval between writes
+func (r *flushRate) observe(now time.Time) time.Duration {
+	if r.last.IsZero() {
+		r.last = now
+		r.interval = time.Duration(1<<63 - 1)
+		return r.interval
+	}
+	interval := now.Sub(r.last)
+	r.last = now
+	if r.interval == time.Duration(1<<63-1) {
+		r.interval = interval
+	} else {
+		r.interval = r.interval - r.interval/8 + interval/8
+	}
+	return r.interval
+}
+
+// sparse records a write at the given time, and returns true if it should be flushed right away because writes are
+// at least delay apart, either on average or since the previous write
+func (r *flushRate) sparse(now time.Time, delay time.Duration) bool {
+	last := r.last
+	return r.observe(now) >= delay || now.Sub(last) >= delay
+}
+
+// buffered returns the number of bytes in the write buffer
+func (c *Async) buffered() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.writer.Buffered()
+}
+
+// batchFlushLoop is the flush loop of connections whose FlushStrategy batches writes, which flushes the write buffer
+// once it holds enough bytes or its oldest packet has waited for long enough
+func (c *Async) batchFlushLoop(strategy FlushStrategy) {
+	var err error
+	var rate flushRate
+	timer := time.NewTimer(strategy.Delay)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	pending := false
+	for {
+		select {
+		case _, ok := <-c.flushCh:
+			if !ok {
+				timer.Stop()
+				c.wg.Done()
+				return
+			}
+			sparse := strategy.Adaptive && rate.sparse(time.Now(), strategy.Delay)
+			if !sparse && (strategy.Bytes <= 0 || c.buffered() < strategy.Bytes) {
+				if !pending {
+					pending = true
+					timer.Reset(strategy.Delay)
+				}
+				continue
+			}
+			if pending && !timer.Stop() {
+				<-timer.C
+			}
+			pending = false
+		case <-timer.C:
+			pending = false
+		}
+		err = c.flush()
+		if err != nil {
+			timer.Stop()
+			c.wg.Done()
+			_ = c.closeWithError(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// writeCountingConn counts the writes to the underlying connection
+type writeCountingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes.Inc()
+	return c.Conn.Write(b)
+}
+
+func TestFlushStrategyDefaults(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, FlushStrategy{}.immediate())
+	assert.Equal(t, FlushStrategy{}, FlushStrategy{}.withDefaults())
+	assert.Equal(t, FlushStrategy{Bytes: 512, Delay: DefaultFlushDelay}, FlushStrategy{Bytes: 512}.withDefaults())
+	assert.Equal(t, FlushStrategy{Adaptive: true, Delay: DefaultFlushDelay}, FlushStrategy{Adaptive: true}.withDefaults())
+	assert.Equal(t, FlushStrategy{Delay: time.Second}, loadOptions(WithFlushStrategy(FlushStrategy{Delay: time.Second})).FlushStrategy)
+
+	var rate flushRate
+	now := time.Now()
+	rate.observe(now)
+	assert.Equal(t, time.Millisecond*8, rate.observe(now.Add(time.Millisecond*8)))
+	assert.Equal(t, time.Millisecond*7, rate.observe(now.Add(time.Millisecond*8)))
+
+	// Writes are flushed right away once the average interval or the gap since the previous write reaches the delay
+	rate = flushRate{}
+	assert.True(t, rate.sparse(now, time.Second))
+	assert.True(t, rate.sparse(now.Add(time.Second), time.Second))
+	assert.False(t, rate.sparse(now.Add(time.Second+time.Millisecond), time.Second))
+	assert.True(t, rate.sparse(now.Add(time.Second*2+time.Millisecond), time.Second))
+	assert.False(t, rate.sparse(now.Add(time.Second*2+time.Millisecond*2), time.Second))
+	for i := 0; i < 32; i++ {
+		assert.False(t, rate.sparse(now.Add(time.Second*3+time.Millisecond*time.Duration(i)), time.Second))
+	}
+	assert.True(t, rate.sparse(now.Add(time.Second*5), time.Second))
+}
+
+func TestFlushStrategy(t *testing.T) {
+	t.Parallel()
+
+	const packets = 32
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	// transfer writes packets from the writer to the reader without waiting for them in between,
+	// and returns the number of writes to the underlying connection and how long it took
+	transfer := func(t *testing.T, strategy FlushStrategy) (int64, time.Duration) {
+		reader, writer := net.Pipe()
+		counter := &writeCountingConn{Conn: writer, writes: atomic.NewInt64(0)}
+		readerConn := NewAsync(reader, &emptyLogger)
+		writerConn := newAsync(counter, loadOptions(WithLogger(&emptyLogger), WithFlushStrategy(strategy)), NoFeatures)
+
+		start := time.Now()
+		for i := 0; i < packets; i++ {
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			require.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+		}
+		for i := 0; i < packets; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			packet.Put(p)
+		}
+		elapsed := time.Since(start)
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+		return counter.writes.Load(), elapsed
+	}
+
+	t.Run("bytes", func(t *testing.T) {
+		t.Parallel()
+
+		writes, elapsed := transfer(t, FlushStrategy{Bytes: packets * metadata.Size, Delay: time.Minute})
+		assert.Equal(t, int64(1), writes)
+		assert.Less(t, elapsed, time.Second*5)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		t.Parallel()
+
+		writes, elapsed := transfer(t, FlushStrategy{Delay: time.Millisecond * 100})
+		assert.LessOrEqual(t, writes, int64(2))
+		assert.GreaterOrEqual(t, elapsed, time.Millisecond*100)
+	})
+
+	t.Run("adaptive", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer := net.Pipe()
+		readerConn := NewAsync(reader, &emptyLogger)
+		writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), WithLiveness(Liveness{PingInterval: -1}), WithFlushStrategy(FlushStrategy{Adaptive: true, Delay: time.Minute})), NoFeatures)
+
+		// The first packet is not held back for the whole delay, since no packets were written before it
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		packet.Put(p)
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+}
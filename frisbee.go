@@ -18,6 +18,7 @@ package frisbee
 
 import (
 	"context"
+	"github.com/loopholelabs/frisbee-go/pkg/handshake"
 	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 	"github.com/loopholelabs/polyglot"
@@ -35,6 +36,34 @@ var (
 	InvalidBufferLength      = errors.New("invalid buffer length")
 	InvalidHandlerTable      = errors.New("invalid handler table configuration, a reserved value may have been used")
 	InvalidOperation         = errors.New("invalid operation in packet, a reserved value may have been used")
+	InvalidHandshake         = handshake.InvalidHandshake
+	InvalidRekey             = errors.New("invalid rekey packet")
+	FeatureNotNegotiated     = errors.New("feature was not negotiated during the handshake")
+	InvalidContentEncoding   = errors.New("invalid content encoding in packet")
+	InvalidExtension         = errors.New("invalid extended header in packet")
+	UnknownExtension         = errors.New("unknown extension in packet")
+	InvalidUpgrade           = errors.New("invalid HTTP upgrade response")
+	InvalidProxyResponse     = errors.New("invalid HTTP CONNECT proxy response")
+	InvalidStreamMode        = errors.New("invalid stream mode")
+	UnknownConnection        = errors.New("unknown connection")
+	PacketRejected           = errors.New("packet was rejected by the filter")
+	AuthenticationFailed     = handshake.AuthenticationFailed
+	InvalidSignature         = errors.New("invalid packet signature")
+	InvalidSequence          = errors.New("invalid or replayed packet sequence number")
+	StreamQuotaExceeded      = errors.New("stream quota exceeded")
+	InvalidDeprecation       = errors.New("invalid deprecation packet")
+	StreamIDsExhausted       = errors.New("no unused stream IDs are available")
+	InvalidPriority          = errors.New("invalid packet priority")
+	NewStreamHandlerSet      = errors.New("a NewStreamHandler is already set")
+	SessionExpired           = errors.New("session expired or could not be resumed")
+	NoAddresses              = errors.New("no server addresses were given")
+	NoHealthyBackends        = errors.New("no healthy backends are available")
+	VarPublished             = errors.New("an expvar variable with the same name has already been published")
+	InvalidRange             = errors.New("invalid range of operations")
+	DecryptionFailed         = errors.New("packet content could not be decrypted")
+	EncryptionExhausted      = errors.New("the encryption sequence numbers of the connection are exhausted")
+	ContentTooLarge          = errors.New("packet content is too large for the connection")
+	InvalidMetadata          = errors.New("invalid packet metadata")
 )
 
 // Action is an ENUM used to modify the state of the client or server from a Handler function
@@ -53,7 +82,10 @@ const (
 	CLOSE
 )
 
-// Handler is the handler function called by frisbee for incoming packets of data, depending on the packet's Metadata.Operation field
+// Handler is the handler function called by frisbee for incoming packets of data, depending on the packet's Metadata.Operation field.
+//
+// The context passed to the handler is cancelled once the connection that the packet was read from is closed (or the
+// server is shut down), and holds that connection (see ConnFromContext and ConnHandler).
 type Handler func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action)
 
 // HandlerTable is the lookup table for Frisbee handler functions - based on the Metadata.Operation field of a packet,
@@ -69,16 +101,61 @@ const (
 	PONG
 
 	// STREAM is used to request that a new stream be created by the receiver to
-	// receive packets with the same packet ID until a packet with a ContentLength of 0 is received
+	// receive packets with the same packet ID until the stream is closed, which is signalled with a STREAMCLOSE packet
+	// if the FeatureStreamClose feature was negotiated, and otherwise with a STREAM packet with a ContentLength of 0
 	STREAM
 
-	RESERVED3
-	RESERVED4
-	RESERVED5
-	RESERVED6
-	RESERVED7
-	RESERVED8
-	RESERVED9
+	// HELLO is used during the handshake to negotiate the protocol version and Features of a connection
+	HELLO
+
+	// REKEY is used to signal that the sender has rotated its write keys, and that the receiver
+	// must rotate its read keys before reading any further packets
+	REKEY
+
+	// STREAMCLOSE is used to close the stream with the same packet ID when the FeatureStreamClose feature was negotiated,
+	// and to reset it with the error code contained in its content when the FeatureStreamReset feature was negotiated
+	STREAMCLOSE
+
+	// STREAMOPEN is used to open the stream with the same packet ID in the StreamMode contained in its content
+	// when the FeatureByteStreams feature was negotiated
+	STREAMOPEN
+
+	// AUTH is used during the authentication handshake to exchange messages between
+	// the Authenticator of the client and the Verifier of the server, and afterwards for control
+	// packets when the FeatureControl feature was negotiated (see Async.WriteControl)
+	AUTH
+
+	// DEPRECATED is sent by the server when a client uses a deprecated operation and the FeatureDeprecation feature
+	// was negotiated, and describes the replacement and the sunset of the operation (see Server.DeprecateOperation)
+	DEPRECATED
+
+	// ACK is used to acknowledge all the packets up to the delivery ID contained in its content when the
+	// FeatureAcknowledgements feature was negotiated (see Outbox)
+	ACK
+
+	// RESERVED9 is the last of the reserved packet types
+	RESERVED9 = ACK
+)
+
+// These are the names that the reserved packet types had before they were assigned, which are kept for compatibility:
+const (
+	// Deprecated: Use HELLO instead.
+	RESERVED3 = HELLO
+
+	// Deprecated: Use REKEY instead.
+	RESERVED4 = REKEY
+
+	// Deprecated: Use STREAMCLOSE instead.
+	RESERVED5 = STREAMCLOSE
+
+	// Deprecated: Use STREAMOPEN instead.
+	RESERVED6 = STREAMOPEN
+
+	// Deprecated: Use AUTH instead.
+	RESERVED7 = AUTH
+
+	// Deprecated: Use DEPRECATED instead.
+	RESERVED8 = DEPRECATED
 )
 
 var (
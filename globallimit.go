@@ -0,0 +1,119 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// GlobalLimit is a capacity ceiling that is shared by all the connections of a server (see Server.SetGlobalLimit).
+// A PacketsPerSecond or BytesPerSecond of 0 means that the packets or bytes are not limited, and the bursts default
+// to the corresponding rates.
+type GlobalLimit struct {
+	// PacketsPerSecond is the number of packets (of any kind, including stream and control packets)
+	// that the server reads per second across all of its connections
+	PacketsPerSecond int
+	PacketBurst      int
+
+	// BytesPerSecond is the number of bytes (including packet metadata) that the server
+	// reads per second across all of its connections
+	BytesPerSecond int
+	ByteBurst      int
+}
+
+// globalLimiter enforces a GlobalLimit, sharing it fairly between the connections that are using it
+type globalLimiter struct {
+	packets     *Throttle
+	bytes       *Throttle
+	connections *atomic.Int64
+}
+
+// SetGlobalLimit limits the aggregate rate at which the server reads packets and bytes from all of its connections,
+// so that it degrades gracefully under load spikes instead of running out of memory. Connections that exceed the
+// limit stop being read from (which applies backpressure to their peers through TCP flow control) until the limit
+// allows their next packet.
+//
+// The limit is shared fairly: every connection waits for at most one packet at a time, and the bytes of large packets
+// are reserved in chunks of the connection's fair share of the byte burst, so connections that send large packets
+// are served in turn with the connections that send small ones rather than ahead of them.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetGlobalLimit(limit GlobalLimit) {
+	s.options.limiter = nil
+	if limit.PacketsPerSecond <= 0 && limit.BytesPerSecond <= 0 {
+		return
+	}
+	l := &globalLimiter{connections: atomic.NewInt64(0)}
+	if limit.PacketsPerSecond > 0 {
+		l.packets = NewThrottle(limit.PacketsPerSecond, limit.PacketBurst)
+	}
+	if limit.BytesPerSecond > 0 {
+		l.bytes = NewThrottle(limit.BytesPerSecond, limit.ByteBurst)
+	}
+	s.options.limiter = l
+}
+
+// share returns the fair share of the byte burst for every connection that is using the limiter
+func (l *globalLimiter) share() int {
+	share := l.bytes.burst
+	if connections := int(l.connections.Load()); connections > 1 {
+		share /= connections
+	}
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
+
+// limit waits until the connection's GlobalLimit allows a packet of the given size (including its metadata)
+// to be read, and returns false if the connection was closed while waiting
+func (c *Async) limit(size int) bool {
+	l := c.options.limiter
+	if l.packets != nil && !c.sleep(l.packets.reserve(1)) {
+		return false
+	}
+	if l.bytes != nil {
+		for size > 0 {
+			chunk := l.share()
+			if chunk > size {
+				chunk = size
+			}
+			if !c.sleep(l.bytes.reserve(chunk)) {
+				return false
+			}
+			size -= chunk
+		}
+	}
+	return true
+}
+
+// sleep waits for the given delay, and returns false if the connection was closed while waiting
+func (c *Async) sleep(delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.closeCh:
+		return false
+	}
+}
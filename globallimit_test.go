@@ -0,0 +1,132 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalLimit(t *testing.T) {
+	t.Parallel()
+
+	const packetSize = 1 << 12
+	const packets = 8
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	// transfer writes packets from the writer to the reader
+	transfer := func(t *testing.T, readerConn *Async, writerConn *Async) {
+		content := make([]byte, packetSize)
+		for i := 0; i < packets; i++ {
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			p.Content.Write(content)
+			p.Metadata.ContentLength = packetSize
+			assert.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+
+			p, err := readerConn.ReadPacket()
+			assert.NoError(t, err)
+			packet.Put(p)
+		}
+	}
+
+	s, err := NewServer(nil, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	s.SetGlobalLimit(GlobalLimit{})
+	assert.Nil(t, s.options.limiter)
+	s.SetGlobalLimit(GlobalLimit{BytesPerSecond: 1 << 16, ByteBurst: 1 << 12})
+	require.NotNil(t, s.options.limiter)
+	assert.Nil(t, s.options.limiter.packets)
+
+	readers := make([]*Async, 2)
+	writers := make([]*Async, 2)
+	for i := range readers {
+		reader, writer := net.Pipe()
+		readers[i] = newAsync(reader, s.options, NoFeatures)
+		writers[i] = NewAsync(writer, &emptyLogger)
+	}
+	assert.Equal(t, int64(2), s.options.limiter.connections.Load())
+	assert.Equal(t, 1<<11, s.options.limiter.share())
+
+	// Both connections share the limit, so transferring 64KiB takes around a second
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range readers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			transfer(t, readers[i], writers[i])
+		}(i)
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*750)
+
+	for i := range readers {
+		assert.NoError(t, readers[i].Close())
+		assert.NoError(t, writers[i].Close())
+	}
+	assert.Equal(t, int64(0), s.options.limiter.connections.Load())
+}
+
+func TestGlobalLimitPackets(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	s, err := NewServer(nil, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	s.SetGlobalLimit(GlobalLimit{PacketsPerSecond: 10, PacketBurst: 1})
+
+	reader, writer := net.Pipe()
+	readerConn := newAsync(reader, s.options, NoFeatures)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		packet.Put(p)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*450)
+
+	// Closing a connection that is waiting for the limit does not wait for it
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	s.options.limiter.packets.reserve(10)
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+	start = time.Now()
+	assert.NoError(t, readerConn.Close())
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+	assert.NoError(t, writerConn.Close())
+}
@@ -0,0 +1,57 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package grpcgateway
+
+import (
+	"github.com/pkg/errors"
+)
+
+// CodecName is the name of the Codec
+const CodecName = "frisbee-raw"
+
+// InvalidMessageType is returned by the Codec when it is used with anything other than a *[]byte
+var InvalidMessageType = errors.New("invalid message type, expected *[]byte")
+
+// Codec is a gRPC codec that passes the encoded bytes of messages through unchanged, which allows the content
+// of frisbee packets to be carried as gRPC messages without the gateway knowing their schema.
+//
+// Messages must be of type *[]byte.
+type Codec struct{}
+
+// Marshal returns the bytes held by v
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, InvalidMessageType
+	}
+	return *b, nil
+}
+
+// Unmarshal copies data into v
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return InvalidMessageType
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// Name returns CodecName
+func (Codec) Name() string {
+	return CodecName
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package grpcgateway
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Forwarder exposes unary gRPC methods as frisbee operations. Packets with a mapped operation are sent as calls to the
+// mapped gRPC method, and the response is written back to the frisbee connection as a packet with the same ID and operation.
+//
+// The packet's ID and operation are added to the outgoing gRPC metadata using the IdMetadataKey and OperationMetadataKey.
+type Forwarder struct {
+	conn           grpc.ClientConnInterface
+	logger         *zerolog.Logger
+	errorOperation uint16
+	methods        []Method
+}
+
+// NewForwarder returns a new Forwarder that calls the given methods using conn. Calls that fail are answered
+// with a packet with the errorOperation, whose content is the message of the gRPC status.
+//
+// If logger is nil, frisbee.DefaultLogger is used.
+func NewForwarder(conn grpc.ClientConnInterface, errorOperation uint16, logger *zerolog.Logger, methods ...Method) (*Forwarder, error) {
+	if err := validMethods(errorOperation, methods); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = &frisbee.DefaultLogger
+	}
+	return &Forwarder{
+		conn:           conn,
+		logger:         logger,
+		errorOperation: errorOperation,
+		methods:        methods,
+	}, nil
+}
+
+// AddHandlers adds a handler for the operation of every method to handlerTable, which can then be
+// used to create a frisbee Server or Client
+func (f *Forwarder) AddHandlers(handlerTable frisbee.HandlerTable) error {
+	for _, method := range f.methods {
+		if _, ok := handlerTable[method.Operation]; ok {
+			return OperationInUse
+		}
+	}
+	for _, method := range f.methods {
+		handlerTable[method.Operation] = f.handler(method.Name)
+	}
+	return nil
+}
+
+// handler returns a frisbee.Handler that calls the given gRPC method with the content of incoming packets
+func (f *Forwarder) handler(method string) frisbee.Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			IdMetadataKey, strconv.FormatUint(uint64(incoming.Metadata.Id), 10),
+			OperationMetadataKey, strconv.FormatUint(uint64(incoming.Metadata.Operation), 10),
+		)
+		request := []byte((*incoming.Content)[:incoming.Metadata.ContentLength])
+		var reply []byte
+		err := f.conn.Invoke(ctx, method, &request, &reply, grpc.ForceCodec(Codec{}))
+
+		incoming.Content.Reset()
+		if err != nil {
+			f.logger.Debug().Err(err).Str("Method", method).Msg("error while forwarding packet to gRPC method")
+			incoming.Metadata.Operation = f.errorOperation
+			incoming.Content.Write([]byte(status.Convert(err).Message()))
+		} else {
+			incoming.Content.Write(reply)
+		}
+		incoming.Metadata.ContentLength = uint32(len(*incoming.Content))
+		return incoming, frisbee.NONE
+	}
+}
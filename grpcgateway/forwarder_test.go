@@ -0,0 +1,122 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package grpcgateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestForwarder(t *testing.T) {
+	t.Parallel()
+
+	backend := func(_ interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		if method == "/backend.Backend/Fail" {
+			return status.Error(codes.NotFound, "backend failure")
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		var request []byte
+		if err := stream.RecvMsg(&request); err != nil {
+			return err
+		}
+		reply := []byte(strings.Join([]string{method, md.Get(IdMetadataKey)[0], md.Get(OperationMetadataKey)[0], string(request)}, " "))
+		return stream.SendMsg(&reply)
+	}
+	gs, cc := newGRPC(t, grpc.UnknownServiceHandler(backend), grpc.ForceServerCodec(Codec{}))
+
+	emptyLogger := zerolog.New(io.Discard)
+	f, err := NewForwarder(cc, errorOperation, &emptyLogger,
+		Method{Name: "/backend.Backend/Echo", Operation: upperOperation},
+		Method{Name: "/backend.Backend/Fail", Operation: failOperation},
+	)
+	require.NoError(t, err)
+
+	serverHandlerTable := make(frisbee.HandlerTable)
+	require.NoError(t, f.AddHandlers(serverHandlerTable))
+	assert.ErrorIs(t, f.AddHandlers(serverHandlerTable), OperationInUse)
+
+	s, err := frisbee.NewServer(serverHandlerTable, frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	responses := make(chan *packet.Packet, 2)
+	respond := func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		p := packet.Get()
+		p.Metadata.Id = incoming.Metadata.Id
+		p.Metadata.Operation = incoming.Metadata.Operation
+		p.Content.Write(*incoming.Content)
+		p.Metadata.ContentLength = incoming.Metadata.ContentLength
+		responses <- p
+		return nil, frisbee.NONE
+	}
+	deviceHandlerTable := make(frisbee.HandlerTable)
+	deviceHandlerTable[upperOperation] = respond
+	deviceHandlerTable[errorOperation] = respond
+	device, err := frisbee.NewClient(deviceHandlerTable, context.Background(), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, deviceConn := net.Pipe()
+	go s.ServeConn(serverConn)
+	require.NoError(t, device.FromConn(deviceConn))
+
+	p := packet.Get()
+	p.Metadata.Id = 7
+	p.Metadata.Operation = upperOperation
+	p.Content.Write([]byte("request"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	require.NoError(t, device.WritePacket(p))
+	packet.Put(p)
+
+	p = <-responses
+	assert.Equal(t, uint16(7), p.Metadata.Id)
+	assert.Equal(t, upperOperation, p.Metadata.Operation)
+	assert.Equal(t, "/backend.Backend/Echo 7 32 request", string(*p.Content))
+	packet.Put(p)
+
+	p = packet.Get()
+	p.Metadata.Id = 8
+	p.Metadata.Operation = failOperation
+	require.NoError(t, device.WritePacket(p))
+	packet.Put(p)
+
+	p = <-responses
+	assert.Equal(t, uint16(8), p.Metadata.Id)
+	assert.Equal(t, errorOperation, p.Metadata.Operation)
+	assert.Equal(t, "backend failure", string(*p.Content))
+	packet.Put(p)
+
+	err = device.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+
+	assert.NoError(t, cc.Close())
+	gs.Stop()
+}
@@ -0,0 +1,244 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package grpcgateway
+
+import (
+	"context"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// connContextKey is the context key used to store the *frisbee.Async of a connection in handler contexts
+type connContextKey struct{}
+
+// pendingKey identifies a call that is waiting for a response from a device
+type pendingKey struct {
+	conn *frisbee.Async
+	id   uint16
+}
+
+// response is the response to a call from a device
+type response struct {
+	content []byte
+	failed  bool
+}
+
+// Gateway exposes frisbee operations as unary gRPC methods. Calls to a method are sent to the device
+// selected by the DeviceMetadataKey of the call's metadata, and the device's response is returned to the caller.
+type Gateway struct {
+	logger         *zerolog.Logger
+	errorOperation uint16
+	methods        map[string]uint16
+	operations     []uint16
+
+	devicesMu sync.RWMutex
+	devices   map[string]*frisbee.Async
+
+	pendingMu sync.Mutex
+	pending   map[pendingKey]chan response
+	nextId    uint16
+}
+
+// NewGateway returns a new Gateway for the given methods. Devices that fail to handle a call must respond with a packet
+// with the errorOperation, whose content is returned to the caller as the message of the gRPC status.
+//
+// If logger is nil, frisbee.DefaultLogger is used.
+func NewGateway(errorOperation uint16, logger *zerolog.Logger, methods ...Method) (*Gateway, error) {
+	if err := validMethods(errorOperation, methods); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = &frisbee.DefaultLogger
+	}
+	g := &Gateway{
+		logger:         logger,
+		errorOperation: errorOperation,
+		methods:        make(map[string]uint16, len(methods)),
+		operations:     []uint16{errorOperation},
+		devices:        make(map[string]*frisbee.Async),
+		pending:        make(map[pendingKey]chan response),
+	}
+	for _, method := range methods {
+		g.methods[method.Name] = method.Operation
+		g.operations = append(g.operations, method.Operation)
+	}
+	return g, nil
+}
+
+// ServerOptions returns the grpc.ServerOptions that must be used to create the grpc.Server that the Gateway
+// serves its methods on. Calls to methods that are not registered with the grpc.Server are handled by the Gateway.
+func (g *Gateway) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnknownServiceHandler(g.handleStream),
+		grpc.ForceServerCodec(Codec{}),
+	}
+}
+
+// Register adds the handlers for the responses from devices to the handler table of the server.
+//
+// This function should not be called once the server has started.
+func (g *Gateway) Register(s *frisbee.Server) error {
+	handlerTable := make(frisbee.HandlerTable)
+	for operation, handler := range s.GetHandlerTable() {
+		handlerTable[operation] = handler
+	}
+	for _, operation := range g.operations {
+		if _, ok := handlerTable[operation]; ok {
+			return OperationInUse
+		}
+		handlerTable[operation] = g.handleResponse
+	}
+	err := s.SetHandlerTable(handlerTable)
+	if err != nil {
+		return err
+	}
+
+	connContext := s.ConnContext
+	s.ConnContext = func(ctx context.Context, conn *frisbee.Async) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		return context.WithValue(ctx, connContextKey{}, conn)
+	}
+	return nil
+}
+
+// AddDevice makes the device with the given ID reachable through the Gateway using its frisbee connection,
+// replacing any existing connection for the device. The device is removed automatically once its connection is closed.
+func (g *Gateway) AddDevice(id string, conn *frisbee.Async) {
+	g.devicesMu.Lock()
+	g.devices[id] = conn
+	g.devicesMu.Unlock()
+	go func() {
+		<-conn.CloseChannel()
+		g.RemoveDevice(id, conn)
+	}()
+}
+
+// RemoveDevice removes the device with the given ID if it is still using the given connection
+func (g *Gateway) RemoveDevice(id string, conn *frisbee.Async) {
+	g.devicesMu.Lock()
+	if g.devices[id] == conn {
+		delete(g.devices, id)
+	}
+	g.devicesMu.Unlock()
+}
+
+func (g *Gateway) handleStream(_ interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	operation, ok := g.methods[method]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	ctx := stream.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	device := md.Get(DeviceMetadataKey)
+	if len(device) == 0 {
+		return status.Errorf(codes.InvalidArgument, "missing %s metadata", DeviceMetadataKey)
+	}
+	g.devicesMu.RLock()
+	conn := g.devices[device[0]]
+	g.devicesMu.RUnlock()
+	if conn == nil {
+		return status.Errorf(codes.Unavailable, "device %s is not connected", device[0])
+	}
+
+	var request []byte
+	if err := stream.RecvMsg(&request); err != nil {
+		return err
+	}
+
+	resp, err := g.call(ctx, conn, operation, request)
+	if err != nil {
+		return err
+	}
+	if resp.failed {
+		return status.Error(codes.Unknown, string(resp.content))
+	}
+	return stream.SendMsg(&resp.content)
+}
+
+// call sends a request to a device and waits for its response
+func (g *Gateway) call(ctx context.Context, conn *frisbee.Async, operation uint16, request []byte) (response, error) {
+	ch := make(chan response, 1)
+	g.pendingMu.Lock()
+	key := pendingKey{conn: conn, id: g.nextId}
+	for _, ok := g.pending[key]; ok; _, ok = g.pending[key] {
+		key.id++
+	}
+	g.nextId = key.id + 1
+	g.pending[key] = ch
+	g.pendingMu.Unlock()
+
+	defer func() {
+		g.pendingMu.Lock()
+		delete(g.pending, key)
+		g.pendingMu.Unlock()
+	}()
+
+	p := packet.Get()
+	p.Metadata.Id = key.id
+	p.Metadata.Operation = operation
+	p.Content.Write(request)
+	p.Metadata.ContentLength = uint32(len(request))
+	err := conn.WritePacket(p)
+	packet.Put(p)
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("error while writing request to device")
+		return response{}, status.Error(codes.Unavailable, err.Error())
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return response{}, status.FromContextError(ctx.Err()).Err()
+	case <-conn.CloseChannel():
+		return response{}, status.Error(codes.Unavailable, frisbee.ConnectionClosed.Error())
+	}
+}
+
+func (g *Gateway) handleResponse(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+	conn, ok := ctx.Value(connContextKey{}).(*frisbee.Async)
+	if !ok {
+		return nil, frisbee.NONE
+	}
+	key := pendingKey{conn: conn, id: incoming.Metadata.Id}
+	g.pendingMu.Lock()
+	ch, ok := g.pending[key]
+	if ok {
+		delete(g.pending, key)
+	}
+	g.pendingMu.Unlock()
+	if !ok {
+		g.logger.Debug().Uint16("Packet ID", incoming.Metadata.Id).Msg("discarding response from device with no pending call")
+		return nil, frisbee.NONE
+	}
+	ch <- response{
+		content: append([]byte(nil), (*incoming.Content)[:incoming.Metadata.ContentLength]...),
+		failed:  incoming.Metadata.Operation == g.errorOperation,
+	}
+	return nil, frisbee.NONE
+}
@@ -0,0 +1,169 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package grpcgateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	errorOperation    = uint16(31)
+	upperOperation    = uint16(32)
+	failOperation     = uint16(33)
+	registerOperation = uint16(34)
+)
+
+// newGRPC serves a grpc.Server created with the given options on an in-memory listener, and returns a client connection to it
+func newGRPC(t *testing.T, options ...grpc.ServerOption) (*grpc.Server, *grpc.ClientConn) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(options...)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	return s, cc
+}
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("payload")
+	encoded, err := Codec{}.Marshal(&data)
+	require.NoError(t, err)
+	assert.Equal(t, data, encoded)
+
+	var decoded []byte
+	require.NoError(t, Codec{}.Unmarshal(encoded, &decoded))
+	assert.Equal(t, data, decoded)
+
+	_, err = Codec{}.Marshal("payload")
+	assert.ErrorIs(t, err, InvalidMessageType)
+	assert.ErrorIs(t, Codec{}.Unmarshal(encoded, &data[0]), InvalidMessageType)
+}
+
+func TestGateway(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewGateway(errorOperation, nil, Method{Name: "/device.Device/Upper", Operation: frisbee.STREAM})
+	assert.ErrorIs(t, err, InvalidMethod)
+	_, err = NewGateway(errorOperation, nil, Method{Name: "/device.Device/Upper", Operation: errorOperation})
+	assert.ErrorIs(t, err, InvalidMethod)
+
+	emptyLogger := zerolog.New(io.Discard)
+	g, err := NewGateway(errorOperation, &emptyLogger,
+		Method{Name: "/device.Device/Upper", Operation: upperOperation},
+		Method{Name: "/device.Device/Fail", Operation: failOperation},
+	)
+	require.NoError(t, err)
+
+	serverHandlerTable := make(frisbee.HandlerTable)
+	serverHandlerTable[registerOperation] = func(_ context.Context, _ *packet.Packet) (*packet.Packet, frisbee.Action) {
+		return nil, frisbee.NONE
+	}
+	s, err := frisbee.NewServer(serverHandlerTable, frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	registered := make(chan struct{})
+	s.ConnContext = func(ctx context.Context, conn *frisbee.Async) context.Context {
+		g.AddDevice("device-1", conn)
+		close(registered)
+		return ctx
+	}
+	require.NoError(t, g.Register(s))
+	assert.ErrorIs(t, g.Register(s), OperationInUse)
+
+	deviceHandlerTable := make(frisbee.HandlerTable)
+	deviceHandlerTable[upperOperation] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		upper := bytes.ToUpper(*incoming.Content)
+		incoming.Content.Reset()
+		incoming.Content.Write(upper)
+		return incoming, frisbee.NONE
+	}
+	deviceHandlerTable[failOperation] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		incoming.Metadata.Operation = errorOperation
+		incoming.Content.Reset()
+		incoming.Content.Write([]byte("device failure"))
+		incoming.Metadata.ContentLength = uint32(len(*incoming.Content))
+		return incoming, frisbee.NONE
+	}
+	device, err := frisbee.NewClient(deviceHandlerTable, context.Background(), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, deviceConn := net.Pipe()
+	go s.ServeConn(serverConn)
+	require.NoError(t, device.FromConn(deviceConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = registerOperation
+	require.NoError(t, device.WritePacket(p))
+	packet.Put(p)
+	<-registered
+
+	gs, cc := newGRPC(t, g.ServerOptions()...)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), DeviceMetadataKey, "device-1")
+	request := []byte("hello device")
+	var reply []byte
+	err = cc.Invoke(ctx, "/device.Device/Upper", &request, &reply, grpc.ForceCodec(Codec{}))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("HELLO DEVICE"), reply)
+
+	err = cc.Invoke(ctx, "/device.Device/Fail", &request, &reply, grpc.ForceCodec(Codec{}))
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, "device failure", status.Convert(err).Message())
+
+	err = cc.Invoke(ctx, "/device.Device/Unknown", &request, &reply, grpc.ForceCodec(Codec{}))
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	err = cc.Invoke(context.Background(), "/device.Device/Upper", &request, &reply, grpc.ForceCodec(Codec{}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	otherCtx := metadata.AppendToOutgoingContext(context.Background(), DeviceMetadataKey, "device-2")
+	err = cc.Invoke(otherCtx, "/device.Device/Upper", &request, &reply, grpc.ForceCodec(Codec{}))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	assert.NoError(t, cc.Close())
+	gs.Stop()
+
+	err = device.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
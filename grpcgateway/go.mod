@@ -0,0 +1,30 @@
+module github.com/loopholelabs/frisbee-go/grpcgateway
+
+go 1.23.0
+
+replace github.com/loopholelabs/frisbee-go => ../
+
+require (
+	github.com/loopholelabs/frisbee-go v0.7.2
+	github.com/pkg/errors v0.9.1
+	github.com/rs/zerolog v1.30.0
+	github.com/stretchr/testify v1.8.4
+	google.golang.org/grpc v1.75.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/loopholelabs/common v0.4.9 // indirect
+	github.com/loopholelabs/polyglot v1.1.2 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.42.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,68 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package grpcgateway translates between gRPC and frisbee in both directions. The Gateway exposes frisbee operations
+// as unary gRPC methods, so that internal gRPC services can call out to devices connected to a frisbee Server, and
+// the Forwarder exposes gRPC methods as frisbee operations, so that devices can call internal gRPC services.
+//
+// In both directions a request is carried as the content of a frisbee packet, and the response is sent back as a
+// packet with the same ID and operation. Failed requests are answered with a packet with the same ID and the
+// configured error operation, whose content is the error message.
+//
+// It lives in its own module so that the core frisbee module does not depend on gRPC.
+package grpcgateway
+
+import (
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/pkg/errors"
+)
+
+// These are the keys of the gRPC metadata used by the gateway:
+const (
+	// DeviceMetadataKey selects the device that a gRPC call to the Gateway is sent to
+	DeviceMetadataKey = "frisbee-device"
+
+	// IdMetadataKey holds the ID of the frisbee packet that a call made by the Forwarder was translated from
+	IdMetadataKey = "frisbee-id"
+
+	// OperationMetadataKey holds the operation of the frisbee packet that a call made by the Forwarder was translated from
+	OperationMetadataKey = "frisbee-operation"
+)
+
+// These are various errors that can be returned by the Gateway and the Forwarder:
+var (
+	InvalidMethod  = errors.New("invalid method, a reserved operation or an empty method name may have been used")
+	OperationInUse = errors.New("operation is already in use by the handler table")
+)
+
+// Method maps the full name of a unary gRPC method (like "/package.Service/Method") to a frisbee operation
+type Method struct {
+	Name      string
+	Operation uint16
+}
+
+// validMethods checks that none of the methods use reserved operations or the error operation
+func validMethods(errorOperation uint16, methods []Method) error {
+	if errorOperation <= frisbee.RESERVED9 {
+		return InvalidMethod
+	}
+	for _, method := range methods {
+		if method.Name == "" || method.Operation <= frisbee.RESERVED9 || method.Operation == errorOperation {
+			return InvalidMethod
+		}
+	}
+	return nil
+}
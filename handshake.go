@@ -0,0 +1,57 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/handshake"
+)
+
+// handshakeInitiate performs the client side of the HELLO handshake on conn, requesting the given features and
+// sending the given parameters, and returns the features that were enabled by the server and the server's parameters.
+func handshakeInitiate(conn net.Conn, requested Features, params map[uint8][]byte) (Features, map[uint8][]byte, error) {
+	_ = conn.SetDeadline(time.Now().Add(DefaultDeadline))
+	defer func() {
+		_ = conn.SetDeadline(emptyTime)
+	}()
+
+	reply, err := handshake.Initiate(conn, uint32(requested), params)
+	if err != nil {
+		return NoFeatures, nil, err
+	}
+	return Features(reply.Features), reply.Params, nil
+}
+
+// handshakeAccept performs the server side of the HELLO handshake on conn, enabling the requested features
+// that are supported by the server and allowed by the policy and sending the given parameters, and returns
+// the enabled features and the client's parameters.
+func handshakeAccept(conn net.Conn, supported Features, policy FeaturePolicy, params map[uint8][]byte) (Features, map[uint8][]byte, error) {
+	_ = conn.SetDeadline(time.Now().Add(DefaultDeadline))
+	defer func() {
+		_ = conn.SetDeadline(emptyTime)
+	}()
+
+	request, enabled, err := handshake.Accept(conn, func(requested uint32) uint32 {
+		return uint32(policy(conn.RemoteAddr(), Features(requested)&supported) & supported)
+	}, params)
+	if err != nil {
+		return NoFeatures, nil, err
+	}
+	return Features(enabled), request.Params, nil
+}
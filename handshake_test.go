@@ -0,0 +1,151 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/handshake"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+const (
+	testFeatureA = Features(1 << (29 + iota))
+	testFeatureB
+	testFeatureC
+)
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, HELLO, handshake.OperationHello)
+	assert.Equal(t, AUTH, handshake.OperationAuth)
+
+	clientConn, serverConn := net.Pipe()
+
+	policy := func(_ net.Addr, requested Features) Features {
+		return requested &^ testFeatureB
+	}
+
+	type result struct {
+		features Features
+		err      error
+	}
+	serverResult := make(chan result, 1)
+	go func() {
+		features, _, err := handshakeAccept(serverConn, testFeatureA|testFeatureB, policy, nil)
+		serverResult <- result{features, err}
+	}()
+
+	features, _, err := handshakeInitiate(clientConn, testFeatureA|testFeatureB|testFeatureC, nil)
+	require.NoError(t, err)
+	assert.Equal(t, testFeatureA, features)
+
+	r := <-serverResult
+	require.NoError(t, r.err)
+	assert.Equal(t, testFeatureA, r.features)
+
+	assert.NoError(t, clientConn.Close())
+	assert.NoError(t, serverConn.Close())
+}
+
+func TestHandshakeInvalid(t *testing.T) {
+	t.Parallel()
+
+	clientConn, serverConn := net.Pipe()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		_, _, err := handshakeAccept(serverConn, testFeatureA, defaultFeaturePolicy, nil)
+		serverErr <- err
+	}()
+
+	_, err := clientConn.Write(make([]byte, 8))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, <-serverErr, InvalidHandshake)
+
+	assert.NoError(t, clientConn.Close())
+	assert.NoError(t, serverConn.Close())
+}
+
+func TestClientServerFeatures(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(make(HandlerTable), WithFeatures(testFeatureA|testFeatureB))
+	require.NoError(t, err)
+
+	serverFeatures := make(chan Features, 1)
+	s.ConnContext = func(ctx context.Context, c *Async) context.Context {
+		serverFeatures <- c.Features()
+		return ctx
+	}
+
+	percent := atomic.NewUint32(0)
+	err = s.SetFeaturePolicy(RolloutPolicy(testFeatureB, percent))
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetFeaturePolicy(nil), FeaturePolicyNil)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithFeatures(testFeatureA|testFeatureB|testFeatureC))
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, testFeatureA, c.Features())
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	assert.Equal(t, testFeatureA, <-serverFeatures)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestRolloutPolicy(t *testing.T) {
+	t.Parallel()
+
+	percent := atomic.NewUint32(100)
+	policy := RolloutPolicy(testFeatureB, percent)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8192}
+
+	assert.Equal(t, testFeatureA|testFeatureB, policy(addr, testFeatureA|testFeatureB))
+
+	percent.Store(0)
+	assert.Equal(t, testFeatureA, policy(addr, testFeatureA|testFeatureB))
+
+	otherPort := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8193}
+	assert.Equal(t, rolloutBucket(addr), rolloutBucket(otherPort))
+}
@@ -0,0 +1,218 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// When the FeatureHealth feature has been negotiated, PING packets with an ID other than 0 are health checks, which are
+// answered with a PONG packet with the same ID whose content holds the Health of the receiver. The content of these
+// PONG packets is made up of the HealthStatus as a single byte, followed by the number of connections and the load
+// as big-endian uint32s.
+//
+// Health checks reuse PING and PONG because every reserved operation is already assigned. This is safe since a
+// feature is only enabled when both peers negotiated it, and since the PING packets of the ping loop (and the PONG
+// packets that answer them) always have an ID of 0, which is never used by a health check. A peer that did not
+// negotiate FeatureHealth answers a PING packet with an ID like any other PING packet, and is never sent one by
+// HealthCheck.
+const healthSize = 1 + 4 + 4
+
+// HealthStatus is the status of a frisbee server (or client) reported by a health check
+type HealthStatus uint8
+
+const (
+	// HealthUnknown means that the peer did not report its status
+	HealthUnknown HealthStatus = iota
+
+	// HealthServing means that the peer is accepting requests
+	HealthServing
+
+	// HealthDraining means that the peer is shutting down, and that requests should be sent elsewhere
+	HealthDraining
+
+	// HealthNotServing means that the peer is not accepting requests
+	HealthNotServing
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthServing:
+		return "serving"
+	case HealthDraining:
+		return "draining"
+	case HealthNotServing:
+		return "not-serving"
+	}
+	return "unknown"
+}
+
+// Health is the status and load reported by the peer of a connection in response to a health check (see Async.HealthCheck)
+type Health struct {
+	// Status is the status of the peer
+	Status HealthStatus
+
+	// Connections is the number of connections that the peer is handling (always 0 for clients)
+	Connections uint32
+
+	// Load is an application-defined measure of the load of the peer (see Server.SetHealthReporter)
+	Load uint32
+}
+
+// HealthReporter is called by the server with its own view of its Health whenever a connection is health checked,
+// and returns the Health that is reported to the peer, which lets applications report their load or mark the server
+// as not serving.
+type HealthReporter func(health Health) Health
+
+// healthChecks tracks the health checks of a connection that are waiting for a response
+type healthChecks struct {
+	mu      sync.Mutex
+	next    uint16
+	pending map[uint16]chan Health
+}
+
+// add registers a new health check and returns its ID along with the channel that its Health is sent on
+func (h *healthChecks) add() (uint16, chan Health) {
+	result := make(chan Health, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending == nil {
+		h.pending = make(map[uint16]chan Health)
+	}
+	for {
+		h.next++
+		if _, ok := h.pending[h.next]; h.next != 0 && !ok {
+			break
+		}
+	}
+	h.pending[h.next] = result
+	return h.next, result
+}
+
+func (h *healthChecks) remove(id uint16) {
+	h.mu.Lock()
+	delete(h.pending, id)
+	h.mu.Unlock()
+}
+
+func (h *healthChecks) resolve(id uint16, health Health) {
+	h.mu.Lock()
+	result, ok := h.pending[id]
+	delete(h.pending, id)
+	h.mu.Unlock()
+	if ok {
+		result <- health
+	}
+}
+
+// HealthCheck sends a health check to the peer and waits for it to report its Health, which requires the
+// FeatureHealth feature to be negotiated. It returns FeatureNotNegotiated if it was not, and ConnectionClosed
+// if the connection is closed before the peer responds.
+func (c *Async) HealthCheck(ctx context.Context) (Health, error) {
+	if !c.features.Has(FeatureHealth) {
+		return Health{}, FeatureNotNegotiated
+	}
+	id, result := c.healthChecks.add()
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = PING
+	err := c.writeWith(p, true, nil)
+	packet.Put(p)
+	if err != nil {
+		c.healthChecks.remove(id)
+		return Health{}, err
+	}
+	select {
+	case health := <-result:
+		return health, nil
+	case <-ctx.Done():
+		c.healthChecks.remove(id)
+		return Health{}, ctx.Err()
+	case <-c.CloseChannel():
+		c.healthChecks.remove(id)
+		return Health{}, ConnectionClosed
+	}
+}
+
+// handleHealth answers a health check or resolves the health check that p is the response to
+func (c *Async) handleHealth(p *packet.Packet) error {
+	if p.Metadata.Operation == PONG {
+		var health Health
+		if len(*p.Content) >= healthSize {
+			health.Status = HealthStatus((*p.Content)[0])
+			health.Connections = binary.BigEndian.Uint32((*p.Content)[1:5])
+			health.Load = binary.BigEndian.Uint32((*p.Content)[5:9])
+		}
+		c.healthChecks.resolve(p.Metadata.Id, health)
+		return nil
+	}
+
+	health := Health{Status: HealthServing}
+	if c.options.health != nil {
+		health = c.options.health()
+	}
+	var content [healthSize]byte
+	content[0] = byte(health.Status)
+	binary.BigEndian.PutUint32(content[1:5], health.Connections)
+	binary.BigEndian.PutUint32(content[5:9], health.Load)
+
+	pong := packet.Get()
+	pong.Metadata.Id = p.Metadata.Id
+	pong.Metadata.Operation = PONG
+	pong.Content.Write(content[:])
+	pong.Metadata.ContentLength = healthSize
+	err := c.writeWith(pong, c.idling.Load(), nil)
+	packet.Put(pong)
+	return err
+}
+
+// HealthCheck sends a health check to the server and waits for it to report its Health (see Async.HealthCheck)
+func (c *Client) HealthCheck(ctx context.Context) (Health, error) {
+	if c.conn == nil {
+		return Health{}, ConnectionNotInitialized
+	}
+	return c.conn.HealthCheck(ctx)
+}
+
+// SetHealthReporter sets the HealthReporter of the server, which decides the Health that the server reports when
+// its connections are health checked (see Async.HealthCheck). If f is nil, it returns an error.
+func (s *Server) SetHealthReporter(f HealthReporter) error {
+	if f == nil {
+		return HealthReporterNil
+	}
+	s.healthReporter = f
+	return nil
+}
+
+// health returns the Health of the server
+func (s *Server) health() Health {
+	health := Health{Status: HealthServing}
+	if s.Draining() {
+		health.Status = HealthDraining
+	}
+	s.connectionsMu.Lock()
+	health.Connections = uint32(len(s.connections))
+	s.connectionsMu.Unlock()
+	if s.healthReporter != nil {
+		health = s.healthReporter(health)
+	}
+	return health
+}
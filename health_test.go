@@ -0,0 +1,170 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	serving := atomic.NewBool(true)
+	start := func() *Server {
+		server, err := NewServer(HandlerTable{}, WithLogger(&emptyLogger), WithFeatures(FeatureHealth))
+		require.NoError(t, err)
+		assert.ErrorIs(t, server.SetHealthReporter(nil), HealthReporterNil)
+		require.NoError(t, server.SetHealthReporter(func(health Health) Health {
+			health.Load = 7
+			if !serving.Load() {
+				health.Status = HealthNotServing
+			}
+			return health
+		}))
+		go func() {
+			_ = server.Start(conn.Listen)
+		}()
+		<-server.started()
+		t.Cleanup(func() {
+			_ = server.Shutdown()
+		})
+		return server
+	}
+	server := start()
+
+	plain, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger), WithFeatures(NoFeatures))
+	require.NoError(t, err)
+	_, err = plain.HealthCheck(context.Background())
+	assert.ErrorIs(t, err, ConnectionNotInitialized)
+	require.NoError(t, plain.Connect(server.listener.Addr().String()))
+	_, err = plain.HealthCheck(context.Background())
+	assert.ErrorIs(t, err, FeatureNotNegotiated)
+	require.NoError(t, plain.Close())
+
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureHealth))
+	require.NoError(t, err)
+	require.NoError(t, client.Connect(server.listener.Addr().String()))
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	require.True(t, client.Features().Has(FeatureHealth))
+
+	for i := 0; i < 3; i++ {
+		health, err := client.HealthCheck(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, HealthServing, health.Status)
+		assert.GreaterOrEqual(t, health.Connections, uint32(1))
+		assert.Equal(t, uint32(7), health.Load)
+	}
+
+	// Regular pings are still answered while health checks are enabled
+	require.NoError(t, client.conn.writeWith(PINGPacket, true, nil))
+
+	// The balancer takes backends that are not serving out of rotation
+	other := start()
+	balancer, err := NewBalancer(HandlerTable{}, context.Background(), []string{server.listener.Addr().String(), other.listener.Addr().String()},
+		BalancerConfig{Interval: time.Millisecond * 10}, WithLogger(&emptyLogger), WithFeatures(FeatureHealth))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = balancer.Close()
+	})
+	for _, status := range balancer.Backends() {
+		assert.True(t, status.Healthy)
+	}
+	serving.Store(false)
+	require.Eventually(t, func() bool {
+		_, err := balancer.Client(nil)
+		return err == NoHealthyBackends
+	}, DefaultDeadline, time.Millisecond)
+	serving.Store(true)
+	require.Eventually(t, func() bool {
+		statuses := balancer.Backends()
+		return statuses[0].Healthy && statuses[1].Healthy
+	}, DefaultDeadline, time.Millisecond)
+
+	health, err := client.HealthCheck(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, HealthServing, health.Status)
+}
+
+func TestHealthMixedFeatures(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	// ping writes a PING packet with the given ID to the connection, and returns the PONG packet that answers it
+	ping := func(t *testing.T, features Features, id uint16) (*metadata.Metadata, []byte) {
+		raw, peer := net.Pipe()
+		c := newAsync(peer, loadOptions(WithLogger(&emptyLogger), WithLiveness(Liveness{PingInterval: -1})), features)
+		t.Cleanup(func() {
+			_ = c.Close()
+			_ = raw.Close()
+		})
+
+		encoded, err := metadata.Encode(id, PING, 0)
+		require.NoError(t, err)
+		_, err = raw.Write(encoded[:])
+		require.NoError(t, err)
+
+		var header [metadata.Size]byte
+		_, err = io.ReadFull(raw, header[:])
+		require.NoError(t, err)
+		pong, err := metadata.Decode(header[:])
+		require.NoError(t, err)
+		content := make([]byte, pong.ContentLength)
+		_, err = io.ReadFull(raw, content)
+		require.NoError(t, err)
+		assert.Equal(t, PONG, pong.Operation)
+		return pong, content
+	}
+
+	t.Run("health", func(t *testing.T) {
+		t.Parallel()
+
+		// A PING packet with an ID is a health check
+		pong, content := ping(t, FeatureHealth, 5)
+		assert.Equal(t, uint16(5), pong.Id)
+		require.Len(t, content, healthSize)
+		assert.Equal(t, HealthServing, HealthStatus(content[0]))
+
+		// A PING packet from the ping loop is answered with a plain PONG packet
+		pong, content = ping(t, FeatureHealth, 0)
+		assert.Equal(t, uint16(0), pong.Id)
+		assert.Empty(t, content)
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		t.Parallel()
+
+		// Without FeatureHealth, a PING packet with an ID is answered like any other PING packet
+		pong, content := ping(t, NoFeatures, 5)
+		assert.Equal(t, uint16(0), pong.Id)
+		assert.Empty(t, content)
+	})
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+)
+
+// CertificateVerifier is called during the TLS handshake of every connection of a frisbee client or server with the
+// state of the connection, once the certificate chain of the peer has been verified against the TLS configuration
+// (and also for connections that resume a previous session). Returning an error aborts the handshake, which closes the
+// connection.
+//
+// Since it is installed as the VerifyConnection hook of the TLS configuration, it can check the presented chain
+// (state.PeerCertificates) and the verified chains (state.VerifiedChains) against any custom policy, such as
+// pinned keys or SPIFFE IDs. Servers that require client certificates must still set ClientAuth in their TLS
+// configuration.
+type CertificateVerifier func(state tls.ConnectionState) error
+
+// withCertificateVerifier returns the TLS configuration that runs the verifier after the VerifyConnection hook of
+// the given configuration (if it has one), or the configuration itself if there is no verifier or configuration
+func withCertificateVerifier(config *tls.Config, verifier CertificateVerifier) *tls.Config {
+	if config == nil || verifier == nil {
+		return config
+	}
+	config = config.Clone()
+	previous := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if previous != nil {
+			if err := previous(state); err != nil {
+				return err
+			}
+		}
+		return verifier(state)
+	}
+	return config
+}
+
+// PeerIdentity is the identity that the peer of a connection proved with its TLS certificate
+type PeerIdentity struct {
+	// CommonName is the common name of the subject of the certificate
+	CommonName string
+
+	// DNSNames are the DNS subject alternative names of the certificate
+	DNSNames []string
+
+	// URIs are the URI subject alternative names of the certificate (which hold the SPIFFE ID of SPIFFE peers)
+	URIs []*url.URL
+
+	// Verified is true if the certificate was verified against the trusted roots of the TLS configuration,
+	// and false if the TLS configuration does not verify the certificates of peers
+	Verified bool
+
+	// Certificate is the certificate of the peer
+	Certificate *x509.Certificate
+}
+
+// PeerIdentityOf returns the PeerIdentity of the peer of a TLS connection with the given state, which uses the leaf
+// of the first verified chain if the chain of the peer was verified. It returns MissingPeerCertificate if the peer
+// did not present a certificate.
+func PeerIdentityOf(state tls.ConnectionState) (PeerIdentity, error) {
+	var certificate *x509.Certificate
+	verified := len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0
+	if verified {
+		certificate = state.VerifiedChains[0][0]
+	} else if len(state.PeerCertificates) > 0 {
+		certificate = state.PeerCertificates[0]
+	} else {
+		return PeerIdentity{}, MissingPeerCertificate
+	}
+	return PeerIdentity{
+		CommonName:  certificate.Subject.CommonName,
+		DNSNames:    certificate.DNSNames,
+		URIs:        certificate.URIs,
+		Verified:    verified,
+		Certificate: certificate,
+	}, nil
+}
+
+// peerIdentityKey is the key of the PeerIdentity in the values of a connection
+type peerIdentityKey struct{}
+
+// PeerIdentity returns the PeerIdentity of the peer of the connection, completing the TLS handshake first if
+// required. The identity is stored with the values of the connection (see Async.Value) the first time it is
+// requested. It returns NotTLSConnectionError for connections that do not use TLS, and MissingPeerCertificate if
+// the peer did not present a certificate.
+func (c *Async) PeerIdentity() (PeerIdentity, error) {
+	if identity, ok := c.Value(peerIdentityKey{}).(PeerIdentity); ok {
+		return identity, nil
+	}
+	if err := c.Handshake(); err != nil {
+		return PeerIdentity{}, err
+	}
+	state, err := c.ConnectionState()
+	if err != nil {
+		return PeerIdentity{}, err
+	}
+	identity, err := PeerIdentityOf(state)
+	if err != nil {
+		return PeerIdentity{}, err
+	}
+	actual, _ := c.LoadOrStoreValue(peerIdentityKey{}, identity)
+	return actual.(PeerIdentity), nil
+}
+
+// PeerIdentityFromContext returns the PeerIdentity of the peer of the connection that the packet being handled was
+// read from, using the context that was passed to the Handler (see ConnFromContext). The boolean is false if the
+// context does not hold a connection or the peer has no identity.
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	conn, ok := ctx.Value(connContextKey{}).(*Async)
+	if !ok {
+		return PeerIdentity{}, false
+	}
+	identity, err := conn.PeerIdentity()
+	return identity, err == nil
+}
@@ -0,0 +1,167 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClientCertificate returns a self-signed client certificate with the given common name and URI
+func testClientCertificate(t *testing.T, commonName string, uri string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		URIs:         []*url.URL{parsed},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certificate
+}
+
+func TestPeerIdentityOf(t *testing.T) {
+	t.Parallel()
+
+	_, err := PeerIdentityOf(tls.ConnectionState{})
+	assert.ErrorIs(t, err, MissingPeerCertificate)
+
+	_, certificate := testClientCertificate(t, "client", "spiffe://example.org/client")
+	identity, err := PeerIdentityOf(tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}})
+	require.NoError(t, err)
+	assert.Equal(t, "client", identity.CommonName)
+	assert.False(t, identity.Verified)
+
+	identity, err = PeerIdentityOf(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}})
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/client", identity.URIs[0].String())
+	assert.True(t, identity.Verified)
+}
+
+func TestCertificateVerifier(t *testing.T) {
+	t.Parallel()
+
+	const testOperation = uint16(11)
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	clientCertificate, clientParsed := testClientCertificate(t, "client", "spiffe://example.org/client")
+	intruderCertificate, intruderParsed := testClientCertificate(t, "intruder", "spiffe://example.org/intruder")
+	pool := x509.NewCertPool()
+	pool.AddCert(clientParsed)
+	pool.AddCert(intruderParsed)
+	serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+	serverTLS.ClientCAs = pool
+
+	// Only the peers with the expected SPIFFE ID are accepted, even though both certificates are trusted
+	rejected := errors.New("unexpected SPIFFE ID")
+	verifier := func(state tls.ConnectionState) error {
+		identity, err := PeerIdentityOf(state)
+		if err != nil {
+			return err
+		}
+		if len(identity.URIs) == 0 || identity.URIs[0].String() != "spiffe://example.org/client" {
+			return rejected
+		}
+		return nil
+	}
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[testOperation] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		identity, ok := PeerIdentityFromContext(ctx)
+		if ok {
+			incoming.Content.Reset()
+			incoming.Content.Write([]byte(identity.CommonName))
+			incoming.Metadata.ContentLength = uint32(len(*incoming.Content))
+		}
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithTLS(serverTLS), WithCertificateVerifier(verifier))
+	require.NoError(t, err)
+	assert.Nil(t, serverTLS.VerifyConnection)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	connect := func(certificate tls.Certificate, received chan string) *Client {
+		config := clientTLS.Clone()
+		config.Certificates = []tls.Certificate{certificate}
+		clientHandlerTable := make(HandlerTable)
+		clientHandlerTable[testOperation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			received <- string(*incoming.Content)
+			return
+		}
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithTLS(config))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = c.Close()
+		})
+		if err = c.Connect(s.listener.Addr().String()); err != nil {
+			return nil
+		}
+
+		p := packet.Get()
+		p.Metadata.Operation = testOperation
+		_ = c.WritePacket(p)
+		packet.Put(p)
+		return c
+	}
+
+	received := make(chan string, 1)
+	c := connect(clientCertificate, received)
+	require.NotNil(t, c)
+	assert.Equal(t, "client", <-received)
+
+	intruderReceived := make(chan string, 1)
+	if c = connect(intruderCertificate, intruderReceived); c != nil {
+		assert.Eventually(t, c.conn.Closed, time.Second, time.Millisecond*10)
+	}
+	assert.Empty(t, intruderReceived)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
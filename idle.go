@@ -0,0 +1,80 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"time"
+)
+
+// Idle configures the idle mode of a frisbee connection, which reduces the CPU and energy usage of connections
+// that have had no traffic for a while (like agents running on laptops and phones).
+//
+// A connection enters idle mode once no packets (other than PING, PONG and REKEY packets) have been read or written
+// for longer than After. While idle, the ping interval of the connection doubles after every PING packet (up to the
+// MaxPingInterval), REKEY packets are deferred so that they are sent along with the next PING packet, and packets
+// sent by the background goroutines are flushed directly instead of waking up the flush goroutine. Connections that only
+// respond to pings (see Liveness) stop their timers entirely while idle. The connection leaves idle mode as soon as a
+// packet is read or written.
+//
+// Since the peer still closes the connection if nothing is read for longer than its Liveness.Timeout, the MaxPingInterval
+// must be shorter than the Timeout of the peer.
+type Idle struct {
+	// After is how long a connection must go without any traffic before it enters idle mode (use 0 to disable idle mode)
+	After time.Duration
+
+	// MaxPingInterval is the longest that the ping interval is stretched to while the connection is idle (defaults to
+	// half of the Liveness.Timeout of the connection)
+	MaxPingInterval time.Duration
+}
+
+// enabled returns whether idle mode is enabled
+func (i Idle) enabled() bool {
+	return i.After > 0
+}
+
+// maxPingInterval returns the MaxPingInterval of the Idle configuration for a connection with the given Liveness
+func (i Idle) maxPingInterval(liveness Liveness) time.Duration {
+	if i.MaxPingInterval > 0 {
+		return i.MaxPingInterval
+	}
+	return liveness.Timeout / 2
+}
+
+// Idle returns whether the connection is currently in idle mode
+func (c *Async) Idle() bool {
+	return c.idling.Load()
+}
+
+// tracksActivity returns whether markActive must be called whenever a packet is read or written on the connection
+func (c *Async) tracksActivity() bool {
+	return c.options.Idle.enabled() || c.options.trackActivity
+}
+
+// markActive records that a packet was read or written on the connection, waking the connection
+// up if it was in idle mode
+func (c *Async) markActive() {
+	if c.options.trackActivity {
+		c.lastActive.Store(time.Now().UnixNano())
+	}
+	c.active.Store(true)
+	if c.idling.Load() {
+		select {
+		case c.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// pingCounter is a PacketRecorder that counts the PING packets that were read
+type pingCounter struct {
+	pings atomic.Uint32
+}
+
+func (p *pingCounter) RecordRead(incoming *packet.Packet) {
+	if incoming.Metadata.Operation == PING {
+		p.pings.Inc()
+	}
+}
+
+func (p *pingCounter) RecordWrite(*packet.Packet) {}
+
+func TestIdle(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	pinger, responder, err := pair.New()
+	require.NoError(t, err)
+
+	counter := new(pingCounter)
+	pingerConn := newAsync(pinger, loadOptions(WithLogger(&emptyLogger), WithLiveness(Liveness{
+		PingInterval: time.Millisecond * 10,
+		Timeout:      time.Second,
+	}), WithIdle(Idle{
+		After:           time.Millisecond * 50,
+		MaxPingInterval: time.Millisecond * 200,
+	})), NoFeatures)
+	responderConn := newAsync(responder, loadOptions(WithLogger(&emptyLogger), WithRecorder(counter), WithLiveness(Liveness{
+		PingInterval: -1,
+		Timeout:      time.Second,
+	}), WithIdle(Idle{
+		After: time.Millisecond * 50,
+	})), NoFeatures)
+
+	require.Eventually(t, pingerConn.Idle, time.Second, time.Millisecond)
+	require.Eventually(t, responderConn.Idle, time.Second, time.Millisecond)
+
+	// Without idle mode, the pinger would send 50 pings in the next 500ms
+	pings := counter.pings.Load()
+	time.Sleep(time.Millisecond * 500)
+	assert.Less(t, counter.pings.Load()-pings, uint32(15))
+	assert.True(t, pingerConn.Idle())
+	assert.True(t, responderConn.Idle())
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = responderConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+	assert.Eventually(t, func() bool {
+		return !responderConn.Idle()
+	}, time.Millisecond*100, time.Millisecond)
+
+	p, err = pingerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	packet.Put(p)
+	assert.Eventually(t, func() bool {
+		return !pingerConn.Idle()
+	}, time.Millisecond*100, time.Millisecond)
+
+	// Connections that only respond to pings must stay open while idle
+	require.Eventually(t, responderConn.Idle, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 500)
+	assert.False(t, pingerConn.Closed())
+	assert.False(t, responderConn.Closed())
+	assert.NoError(t, pingerConn.Error())
+	assert.NoError(t, responderConn.Error())
+
+	err = pingerConn.Close()
+	assert.NoError(t, err)
+	err = responderConn.Close()
+	assert.NoError(t, err)
+}
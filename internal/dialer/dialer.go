@@ -14,6 +14,7 @@
 package dialer
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"time"
@@ -47,6 +48,17 @@ func (r *Retry) Dial(network, address string) (c net.Conn, err error) {
 	return
 }
 
+// DialContext calls the underlying *net.DialContext to dial a net.Conn, but retries on failure until the context is done
+func (r *Retry) DialContext(ctx context.Context, network, address string) (c net.Conn, err error) {
+	for i := 0; i < r.NumRetries; i++ {
+		c, err = r.Dialer.DialContext(ctx, network, address)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
 // DialTLS creates a new TLS Dialer using the underlying *net.Dial and uses it to dial a net.Conn, but retries on failure
 func (r *Retry) DialTLS(network, address string, config *tls.Config) (c net.Conn, err error) {
 	d := &tls.Dialer{
@@ -0,0 +1,200 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package kcp runs frisbee connections over KCP, a reliable ARQ protocol on top of UDP, for clients on lossy links
+// (like mobile or satellite links) where the throughput of TCP collapses. KCP retransmits lost segments much more
+// aggressively than TCP does, trading bandwidth for latency.
+//
+// The connections returned by Dial and the Listener are ordinary net.Conns in stream mode, so they are used with
+// frisbee.Client (using Dialer and frisbee.WithDialer) and frisbee.Server (using Listen and
+// frisbee.Server.StartWithListener) without any changes to the frisbee API. Since KCP does not notify the peer when a
+// session is closed, the liveness options of frisbee should be used so that dead peers are detected.
+//
+// It lives in its own module so that the core frisbee module does not depend on kcp-go.
+package kcp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/pkg/errors"
+	"github.com/xtaci/kcp-go/v5"
+)
+
+var (
+	InvalidMTU = errors.New("invalid kcp mtu")
+)
+
+// Config is used to configure KCP sessions
+type Config struct {
+	// NoDelay enables the nodelay mode of KCP, which retransmits lost segments sooner
+	NoDelay bool
+
+	// Interval is the interval of the internal update timer of KCP (40 milliseconds by default)
+	Interval time.Duration
+
+	// Resend is the number of duplicate acknowledgements after which a segment is retransmitted
+	// immediately (0 disables fast retransmission)
+	Resend int
+
+	// NoCongestion disables the congestion control of KCP
+	NoCongestion bool
+
+	// SendWindow and ReceiveWindow are the sizes of the send and receive windows, in segments
+	// (128 by default, or the defaults of kcp-go if they are 0)
+	SendWindow    int
+	ReceiveWindow int
+
+	// MTU is the maximum size of a UDP datagram sent by KCP (1400 bytes by default)
+	MTU int
+
+	// DataShards and ParityShards configure the forward error correction of KCP, which recovers lost
+	// datagrams without waiting for retransmission. FEC is disabled if either of them is 0.
+	DataShards   int
+	ParityShards int
+
+	// Block encrypts the datagrams of the session, if it is not nil
+	Block kcp.BlockCrypt
+}
+
+// DefaultConfig returns the Config that is used when a nil Config is given, which matches
+// the "normal" mode of KCP
+func DefaultConfig() *Config {
+	return &Config{
+		Interval:      time.Millisecond * 40,
+		SendWindow:    128,
+		ReceiveWindow: 128,
+		MTU:           1400,
+	}
+}
+
+// TurboConfig returns a Config that matches the "fast3" mode of KCP, which minimizes latency on lossy links at
+// the cost of bandwidth, and uses forward error correction to recover lost datagrams
+func TurboConfig() *Config {
+	return &Config{
+		NoDelay:       true,
+		Interval:      time.Millisecond * 10,
+		Resend:        2,
+		NoCongestion:  true,
+		SendWindow:    1024,
+		ReceiveWindow: 1024,
+		MTU:           1400,
+		DataShards:    10,
+		ParityShards:  3,
+	}
+}
+
+func (c *Config) validate() (*Config, error) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	if c.MTU < 0 {
+		return nil, InvalidMTU
+	}
+	return c, nil
+}
+
+// apply configures session using c, and puts it into stream mode since frisbee expects a byte stream
+func (c *Config) apply(session *kcp.UDPSession) error {
+	session.SetStreamMode(true)
+	session.SetWriteDelay(false)
+	session.SetACKNoDelay(c.NoDelay)
+	var noDelay, noCongestion int
+	if c.NoDelay {
+		noDelay = 1
+	}
+	if c.NoCongestion {
+		noCongestion = 1
+	}
+	interval := int(c.Interval / time.Millisecond)
+	if interval <= 0 {
+		interval = 40
+	}
+	session.SetNoDelay(noDelay, interval, c.Resend, noCongestion)
+	if c.SendWindow > 0 || c.ReceiveWindow > 0 {
+		session.SetWindowSize(c.SendWindow, c.ReceiveWindow)
+	}
+	if c.MTU > 0 && !session.SetMtu(c.MTU) {
+		return InvalidMTU
+	}
+	return nil
+}
+
+// Dial establishes a new KCP session with the server at addr. Since KCP does not perform a handshake, the session
+// is established immediately, and ctx is only checked before the session is created.
+func Dial(ctx context.Context, addr string, config *Config) (net.Conn, error) {
+	config, err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := kcp.DialWithOptions(addr, config.Block, config.DataShards, config.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	if err = config.apply(session); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// Dialer returns a frisbee.DialFunc that dials KCP sessions using config (ignoring the network that it is called
+// with). It can be used with the frisbee.WithDialer option.
+func Dialer(config *Config) frisbee.DialFunc {
+	return func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+		return Dial(ctx, addr, config)
+	}
+}
+
+// Listener is a net.Listener that accepts KCP sessions
+type Listener struct {
+	*kcp.Listener
+	config *Config
+}
+
+// Listen announces on the local UDP address addr, and returns a Listener that can be passed to
+// frisbee.Server.StartWithListener
+func Listen(addr string, config *Config) (*Listener, error) {
+	config, err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	l, err := kcp.ListenWithOptions(addr, config.Block, config.DataShards, config.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{Listener: l, config: config}, nil
+}
+
+// Accept waits for the next KCP session and returns it configured using the Config of the Listener
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		session, err := l.Listener.AcceptKCP()
+		if err != nil {
+			return nil, err
+		}
+		if err = l.config.apply(session); err != nil {
+			_ = session.Close()
+			continue
+		}
+		return session, nil
+	}
+}
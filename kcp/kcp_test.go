@@ -0,0 +1,95 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package kcp
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrisbee(t *testing.T) {
+	t.Parallel()
+
+	for name, config := range map[string]*Config{"default": nil, "turbo": TurboConfig()} {
+		config := config
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			emptyLogger := zerolog.New(io.Discard)
+			serverHandlerTable := make(frisbee.HandlerTable)
+			serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+				incoming.Metadata.Operation = metadata.PacketPong
+				outgoing = incoming
+				return
+			}
+			s, err := frisbee.NewServer(serverHandlerTable, frisbee.WithLogger(&emptyLogger))
+			require.NoError(t, err)
+
+			l, err := Listen("127.0.0.1:0", config)
+			require.NoError(t, err)
+			go func() {
+				_ = s.StartWithListener(l)
+			}()
+
+			received := make(chan []byte, 1)
+			clientHandlerTable := make(frisbee.HandlerTable)
+			clientHandlerTable[metadata.PacketPong] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+				received <- append([]byte(nil), *incoming.Content...)
+				return
+			}
+			c, err := frisbee.NewClient(clientHandlerTable, context.Background(), frisbee.WithLogger(&emptyLogger), frisbee.WithDialer(Dialer(config)))
+			require.NoError(t, err)
+			err = c.Connect(l.Addr().String())
+			require.NoError(t, err)
+
+			data := make([]byte, 1<<16)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			p.Content.Write(data)
+			p.Metadata.ContentLength = uint32(len(data))
+			err = c.WritePacket(p)
+			require.NoError(t, err)
+			packet.Put(p)
+			assert.Equal(t, data, <-received)
+
+			assert.NoError(t, c.Close())
+			assert.NoError(t, s.Shutdown())
+		})
+	}
+}
+
+func TestInvalidMTU(t *testing.T) {
+	t.Parallel()
+
+	_, err := Dial(context.Background(), "127.0.0.1:9", &Config{MTU: -1})
+	assert.ErrorIs(t, err, InvalidMTU)
+
+	// MTUs that are larger than kcp-go supports are rejected when the session is configured
+	_, err = Dial(context.Background(), "127.0.0.1:9", &Config{MTU: 1 << 20})
+	assert.ErrorIs(t, err, InvalidMTU)
+}
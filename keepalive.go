@@ -0,0 +1,110 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/tls"
+	"math"
+	"net"
+	"time"
+)
+
+// KeepAliveConfig configures the TCP keepalive probes of the sockets underlying frisbee connections in more detail
+// than the WithKeepAlive option, so that dead peers can be detected within seconds instead of the OS default of around
+// 15 minutes (see the WithKeepAliveConfig option and Async.SetKeepAliveConfig). Zero values leave the corresponding
+// setting unchanged.
+type KeepAliveConfig struct {
+	// Idle is how long the connection must be idle before the first keepalive probe is sent (TCP_KEEPIDLE)
+	Idle time.Duration
+
+	// Interval is how long to wait between keepalive probes that are not acknowledged (TCP_KEEPINTVL). It is
+	// only supported on Linux, and is the same as Idle on other platforms.
+	Interval time.Duration
+
+	// Count is the number of keepalive probes that can go unacknowledged before the connection is closed
+	// (TCP_KEEPCNT). It is only supported on Linux, and is ignored on other platforms.
+	Count int
+
+	// UserTimeout is how long transmitted data may remain unacknowledged before the connection is closed
+	// (TCP_USER_TIMEOUT), which detects dead peers while there is data in flight and keepalive probes are not
+	// being sent. It is only supported on Linux, and is ignored on other platforms.
+	UserTimeout time.Duration
+}
+
+// SetKeepAliveConfig applies the given KeepAliveConfig to the TCP socket underlying the connection, which is useful for
+// connections created using ConnectAsync or NewAsync. Connections that are not backed by a TCP socket (or that are
+// wrapped using a ConnWrapper) are left unchanged.
+func (c *Async) SetKeepAliveConfig(config KeepAliveConfig) error {
+	return config.apply(c.conn)
+}
+
+// apply enables keepalives with the given configuration on the TCP socket underlying conn,
+// and does nothing if conn is not backed by a TCP socket
+func (k *KeepAliveConfig) apply(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	err := tcpConn.SetKeepAlive(true)
+	if err != nil {
+		return err
+	}
+	if k.Idle > 0 {
+		err = tcpConn.SetKeepAlivePeriod(k.Idle)
+		if err != nil {
+			return err
+		}
+	}
+	if k.Interval <= 0 && k.Count <= 0 && k.UserTimeout <= 0 {
+		return nil
+	}
+	rc, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if k.Interval > 0 || k.Count > 0 {
+		err = setKeepAliveProbes(rc, durationSeconds(k.Interval), k.Count)
+		if err != nil {
+			return err
+		}
+	}
+	if k.UserTimeout > 0 {
+		return setUserTimeout(rc, durationMilliseconds(k.UserTimeout))
+	}
+	return nil
+}
+
+// durationSeconds returns d in whole seconds (rounded up), capped at math.MaxInt32
+func durationSeconds(d time.Duration) int {
+	secs := (d + time.Second - 1) / time.Second
+	if secs > math.MaxInt32 {
+		secs = math.MaxInt32
+	}
+	return int(secs)
+}
+
+// durationMilliseconds returns d in milliseconds, capped at math.MaxInt32
+func durationMilliseconds(d time.Duration) int {
+	msec := d.Milliseconds()
+	if msec > math.MaxInt32 {
+		msec = math.MaxInt32
+	}
+	return int(msec)
+}
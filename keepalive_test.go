@@ -0,0 +1,105 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncSetKeepAliveConfig(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	err = readerConn.SetKeepAliveConfig(KeepAliveConfig{
+		Idle:        time.Second * 5,
+		Interval:    time.Second,
+		Count:       3,
+		UserTimeout: time.Second * 8,
+	})
+	assert.NoError(t, err)
+
+	// Connections that are not backed by a TCP socket are left unchanged
+	pipeReader, pipeWriter := net.Pipe()
+	pipeConn := NewAsync(pipeReader, &emptyLogger)
+	assert.NoError(t, pipeConn.SetKeepAliveConfig(KeepAliveConfig{Count: 3}))
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+	assert.NoError(t, pipeConn.Close())
+	assert.NoError(t, pipeWriter.Close())
+
+	assert.Equal(t, 1, durationSeconds(time.Millisecond))
+	assert.Equal(t, 2, durationSeconds(time.Second*2))
+}
+
+func TestKeepAliveConfigOption(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	config := KeepAliveConfig{Idle: time.Second * 5, Interval: time.Second, Count: 3, UserTimeout: time.Second * 8}
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithKeepAliveConfig(config))
+	require.NoError(t, err)
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	received := make(chan struct{}, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- struct{}{}
+		return
+	}
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithKeepAliveConfig(config))
+	require.NoError(t, err)
+	require.NoError(t, c.Connect(s.listener.Addr().String()))
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+	<-received
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,38 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/tls"
+	"io"
+)
+
+// KeyLogConfig returns a copy of the TLS configuration that writes the TLS secrets of its connections to w in the
+// NSS key log format (the format of SSLKEYLOGFILE), so that captures of frisbee connections that use the
+// configuration can be decrypted with tools like Wireshark. It can be passed to ConnectAsync and ConnectSync, while
+// frisbee clients and servers can use the WithKeyLogWriter option instead.
+//
+// Key logging compromises the security of every connection that uses the configuration, and must only be used
+// for debugging.
+func KeyLogConfig(config *tls.Config, w io.Writer) *tls.Config {
+	if config == nil || w == nil {
+		return config
+	}
+	config = config.Clone()
+	config.KeyLogWriter = w
+	return config
+}
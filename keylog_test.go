@@ -0,0 +1,86 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a bytes.Buffer that can be written to and read from concurrently
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestKeyLogWriter(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	assert.Nil(t, KeyLogConfig(nil, io.Discard))
+	assert.Same(t, clientTLS, KeyLogConfig(clientTLS, nil))
+
+	serverKeys, clientKeys := new(lockedBuffer), new(lockedBuffer)
+	s, err := NewServer(make(HandlerTable), WithLogger(&emptyLogger), WithTLS(serverTLS), WithKeyLogWriter(serverKeys))
+	require.NoError(t, err)
+	assert.Nil(t, serverTLS.KeyLogWriter)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), 0, &emptyLogger, KeyLogConfig(clientTLS, clientKeys))
+	require.NoError(t, err)
+	assert.Nil(t, clientTLS.KeyLogWriter)
+
+	assert.Contains(t, clientKeys.String(), "CLIENT_TRAFFIC_SECRET_0")
+	// Both peers log the same secrets, which is what allows either side's log to decrypt a capture
+	assert.Eventually(t, func() bool {
+		return strings.Contains(serverKeys.String(), "CLIENT_TRAFFIC_SECRET_0")
+	}, time.Second, time.Millisecond*10)
+	for _, line := range strings.Split(strings.TrimSpace(clientKeys.String()), "\n") {
+		assert.Contains(t, serverKeys.String(), line)
+	}
+
+	assert.NoError(t, c.Close())
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Packets whose content does not fit in a uint32 have their ContentLength set to metadata.LargeContentLength, and the
+// length of their content is the length of the packet's Content. When the FeatureLargeContent feature has been
+// negotiated they are written with metadata.LargeContentLength in their metadata, and the length of their content is
+// carried in the content length extension of their extended header. Connections that have not negotiated the feature
+// refuse to write them with the ContentTooLarge error, so peers that do not support large content never see them.
+
+// DefaultLargeContentLimit is the default largest content length (in bytes) that is accepted in packets with
+// content that does not fit in a uint32
+const DefaultLargeContentLimit = 1 << 34
+
+// extensionContentLength carries the length of the content of a packet that does not fit in a uint32 as a uint64
+const extensionContentLength = frame.ExtensionContentLength
+
+// contentLengthSize is the size of the value of the content length extension
+const contentLengthSize = 8
+
+// large returns whether content of the given length does not fit in the ContentLength of a packet's metadata
+func large(length int) bool {
+	return uint64(length) >= uint64(metadata.LargeContentLength)
+}
+
+// validContentLength returns whether the ContentLength of p matches the length of its content
+func validContentLength(p *packet.Packet) bool {
+	if p.Metadata.ContentLength == metadata.LargeContentLength {
+		return large(len(*p.Content))
+	}
+	return int(p.Metadata.ContentLength) == len(*p.Content)
+}
+
+// setContentLength sets the ContentLength of p to the given length of its content
+func setContentLength(p *packet.Packet, length int) {
+	if large(length) {
+		p.Metadata.ContentLength = metadata.LargeContentLength
+	} else {
+		p.Metadata.ContentLength = uint32(length)
+	}
+}
+
+// appendContentLength appends the content length extension with the given length to an encoded extended header
+func appendContentLength(header []byte, length uint64) []byte {
+	var value [contentLengthSize]byte
+	binary.BigEndian.PutUint64(value[:], length)
+	header = append(header, extensionContentLength, contentLengthSize)
+	header = append(header, value[:]...)
+	header[metadata.Size] += 2 + contentLengthSize
+	return header
+}
+
+// decodeContentLength returns the length in the content length extension of the given extensions,
+// or 0 if they do not carry one
+func decodeContentLength(extensions []byte) uint64 {
+	for len(extensions) > 0 {
+		extension, rest, err := frame.NextExtension(extensions)
+		if err != nil {
+			return 0
+		}
+		if extension.Type == extensionContentLength && len(extension.Value) == contentLengthSize {
+			return binary.BigEndian.Uint64(extension.Value)
+		}
+		extensions = rest
+	}
+	return 0
+}
+
+// contentLimit returns the largest content length that the connection accepts, once it has been decompressed
+func (c *Async) contentLimit() uint64 {
+	if c.features.Has(FeatureLargeContent) {
+		return c.options.LargeContentLimit
+	}
+	return uint64(metadata.LargeContentLength)
+}
@@ -0,0 +1,145 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// largeFrame encodes a frame whose content length is carried in the content length extension, as it is
+// for packets whose content does not fit in a uint32
+func largeFrame(t *testing.T, id uint16, content []byte) []byte {
+	b := make([]byte, metadata.Size)
+	binary.BigEndian.PutUint16(b[metadata.IdOffset:], id)
+	binary.BigEndian.PutUint16(b[metadata.OperationOffset:], metadata.PacketPing)
+	binary.BigEndian.PutUint32(b[metadata.ContentLengthOffset:], metadata.LargeContentLength)
+	var length [contentLengthSize]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(content)))
+	b, err := frame.AppendExtensions(b, frame.Extension{Type: extensionContentLength, Value: length[:]})
+	require.NoError(t, err)
+	return append(b, content...)
+}
+
+func TestLargeContent(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	t.Run("negotiated", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger)), FeatureLargeContent)
+		writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), FeatureLargeContent)
+
+		// Packets with content that fits in a uint32 are written as usual
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write([]byte("small"))
+		p.Metadata.ContentLength = 5
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(5), p.Metadata.ContentLength)
+		assert.Equal(t, []byte("small"), []byte(*p.Content))
+		packet.Put(p)
+
+		// The length of the content is taken from the content length extension
+		_, err = writer.Write(largeFrame(t, 1, []byte("large content")))
+		require.NoError(t, err)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(1), p.Metadata.Id)
+		assert.Equal(t, uint32(13), p.Metadata.ContentLength)
+		assert.Equal(t, []byte("large content"), []byte(*p.Content))
+		packet.Put(p)
+
+		// Packets that claim to have large content must carry it
+		p = packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write([]byte("small"))
+		p.Metadata.ContentLength = metadata.LargeContentLength
+		assert.ErrorIs(t, writerConn.WritePacket(p), InvalidContentLength)
+		packet.Put(p)
+
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithLargeContentLimit(4)), FeatureLargeContent)
+
+		_, err = writer.Write(largeFrame(t, 1, []byte("large content")))
+		require.NoError(t, err)
+
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), ContentTooLarge)
+
+		_ = readerConn.Close()
+		_ = writer.Close()
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger)), FeatureExtendedHeaders)
+
+		_, err = writer.Write(largeFrame(t, 1, []byte("large content")))
+		require.NoError(t, err)
+
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), ContentTooLarge)
+
+		_ = readerConn.Close()
+		_ = writer.Close()
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		p := packet.Get()
+		p.Metadata.ContentLength = 5
+		_, _, err := decodeExtensions(p, appendContentLength(make([]byte, metadata.Size+1), 5)[metadata.Size+1:], nil)
+		assert.ErrorIs(t, err, InvalidExtension)
+		packet.Put(p)
+
+		assert.True(t, large(int(metadata.LargeContentLength)))
+		assert.False(t, large(int(metadata.LargeContentLength)-1))
+	})
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"time"
+)
+
+// OverflowPolicy decides what the server does with an incoming connection when it already has the maximum number
+// of connections (see Server.SetMaxConnections)
+type OverflowPolicy uint8
+
+const (
+	// RejectOverflow closes the incoming connection right away (before the handshake)
+	RejectOverflow OverflowPolicy = iota
+
+	// QueueOverflow stops accepting connections until one of the existing connections is closed, so that incoming
+	// connections wait in the backlog of the listener
+	QueueOverflow
+
+	// EvictIdle closes the connection that has gone the longest without reading or writing a packet (other than PING,
+	// PONG, and REKEY packets) to make room for the incoming connection. If none of the existing connections can be
+	// evicted (because they are still being established), the incoming connection is closed right away.
+	EvictIdle
+)
+
+// SetMaxConnections limits the number of connections that the server handles at the same time to max (connections count
+// from the moment they are accepted until they are closed), and sets the policy that is used when the limit is reached.
+// A max of 0 removes the limit. This prevents accept storms from exhausting the server's memory.
+//
+// Connections that were handed off (see SetHandoff) count towards the limit until the handoff function returns.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetMaxConnections(max int, policy OverflowPolicy) {
+	s.overflowPolicy = policy
+	s.slots = nil
+	if max > 0 {
+		s.slots = make(chan struct{}, max)
+	}
+	s.options.trackActivity = max > 0 && policy == EvictIdle
+}
+
+// acquire reserves a connection slot for conn according to the server's OverflowPolicy,
+// and returns false (after closing conn) if the connection cannot be served
+func (s *Server) acquire(conn net.Conn) bool {
+	if s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+	switch s.overflowPolicy {
+	case QueueOverflow:
+		s.Logger().Debug().Msg("Maximum connections reached, waiting for a connection to be closed")
+		return s.wait(conn)
+	case EvictIdle:
+		if evicted := s.evictIdle(); evicted != nil {
+			s.Logger().Debug().Str("Remote", evicted.RemoteAddr().String()).Msg("Maximum connections reached, evicted idle connection")
+			return s.wait(conn)
+		}
+	}
+	s.Logger().Debug().Str("Remote", conn.RemoteAddr().String()).Msg("Maximum connections reached, rejecting connection")
+	_ = conn.Close()
+	return false
+}
+
+// wait blocks until a connection slot is available for conn, and returns false (after closing conn) if the server was
+// shut down while waiting
+func (s *Server) wait(conn net.Conn) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-s.closeCh:
+		_ = conn.Close()
+		return false
+	}
+}
+
+// release frees the connection slot of a connection that is done being served
+func (s *Server) release() {
+	<-s.slots
+}
+
+// evictIdle closes the connection that has been idle for the longest time,
+// and returns it (or nil if there were no connections to evict)
+func (s *Server) evictIdle() *Async {
+	var evicted *Async
+	oldest := time.Now().UnixNano()
+	s.connectionsMu.Lock()
+	for _, c := range s.connections {
+		if lastActive := c.lastActive.Load(); evicted == nil || lastActive < oldest {
+			evicted, oldest = c, lastActive
+		}
+	}
+	s.connectionsMu.Unlock()
+	if evicted != nil {
+		_ = evicted.Close()
+	}
+	return evicted
+}
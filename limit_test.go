@@ -0,0 +1,135 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMaxConnections(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+
+	for name, policy := range map[string]OverflowPolicy{"reject": RejectOverflow, "queue": QueueOverflow, "evict": EvictIdle} {
+		policy := policy
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+			require.NoError(t, err)
+			s.SetMaxConnections(1, policy)
+			go func() {
+				_ = s.Start(conn.Listen)
+			}()
+			<-s.started()
+			addr := s.listener.Addr().String()
+
+			connect := func() (*Client, chan struct{}) {
+				received := make(chan struct{}, 1)
+				clientHandlerTable := make(HandlerTable)
+				clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+					received <- struct{}{}
+					return
+				}
+				c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+				require.NoError(t, err)
+				require.NoError(t, c.Connect(addr))
+				return c, received
+			}
+			ping := func(c *Client) {
+				p := packet.Get()
+				p.Metadata.Operation = metadata.PacketPing
+				require.NoError(t, c.WritePacket(p))
+				packet.Put(p)
+			}
+
+			first, firstReceived := connect()
+			ping(first)
+			<-firstReceived
+
+			second, secondReceived := connect()
+			switch policy {
+			case RejectOverflow:
+				assert.Eventually(t, second.Closed, time.Second*5, time.Millisecond*10)
+				assert.False(t, first.Closed())
+			case QueueOverflow:
+				// The second connection is only served once the first one has been closed
+				ping(second)
+				select {
+				case <-secondReceived:
+					t.Fatal("queued connection was served while the server was full")
+				case <-time.After(time.Millisecond * 100):
+				}
+				assert.NoError(t, first.Close())
+				<-secondReceived
+			case EvictIdle:
+				assert.Eventually(t, first.Closed, time.Second*5, time.Millisecond*10)
+				ping(second)
+				<-secondReceived
+			}
+
+			_ = first.Close()
+			_ = second.Close()
+			assert.NoError(t, s.Shutdown())
+		})
+	}
+
+	// Shutting down a server that is waiting for a free slot stops it from accepting connections
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	s.SetMaxConnections(1, QueueOverflow)
+	done := make(chan struct{})
+	go func() {
+		_ = s.Start(conn.Listen)
+		close(done)
+	}()
+	<-s.started()
+	clients := make([]*Client, 2)
+	for i := range clients {
+		clients[i], err = NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		require.NoError(t, clients[i].Connect(s.listener.Addr().String()))
+	}
+	assert.Eventually(t, func() bool {
+		s.connectionsMu.Lock()
+		defer s.connectionsMu.Unlock()
+		return len(s.connections) == 1
+	}, time.Second*5, time.Millisecond*10)
+	assert.NoError(t, s.Shutdown())
+	<-done
+	for _, c := range clients {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,84 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"time"
+)
+
+// Liveness configures how a frisbee connection checks that its peer is still alive.
+//
+// A connection is considered dead (and is closed) when nothing has been read from it for longer than the
+// Timeout, so the Timeout of a connection must be longer than the PingInterval of its peer. Setting the
+// PingInterval of clients to -1 and only sending pings from the server allows heartbeat traffic to be cut in
+// half, since clients will then only respond to the server's pings with PONG packets.
+type Liveness struct {
+	// PingInterval is how often PING packets are sent to the peer (use -1 to only respond to pings from the peer)
+	PingInterval time.Duration
+
+	// Timeout is how long the connection waits for a packet from the peer before closing the connection
+	Timeout time.Duration
+}
+
+// DefaultLiveness is the Liveness used by frisbee connections by default, where both peers ping each other
+var DefaultLiveness = Liveness{
+	PingInterval: DefaultPingInterval,
+	Timeout:      DefaultDeadline,
+}
+
+// withDefaults replaces the zero values of l with the values from DefaultLiveness
+func (l Liveness) withDefaults() Liveness {
+	if l.PingInterval == 0 {
+		l.PingInterval = DefaultLiveness.PingInterval
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultLiveness.Timeout
+	}
+	return l
+}
+
+// LivenessPolicy is called by the server for every incoming connection with the remote address of the
+// connection, and returns the Liveness that should be used for the connection. This allows aggressive liveness
+// checks to be used for internet clients while using relaxed checks for trusted internal links.
+type LivenessPolicy func(remote net.Addr) Liveness
+
+// NetworkLivenessPolicy returns a LivenessPolicy that uses the internal Liveness for connections from
+// loopback and private network addresses, and the external Liveness for all other connections.
+func NetworkLivenessPolicy(internal Liveness, external Liveness) LivenessPolicy {
+	return func(remote net.Addr) Liveness {
+		var ip net.IP
+		switch addr := remote.(type) {
+		case *net.TCPAddr:
+			ip = addr.IP
+		case *net.UDPAddr:
+			ip = addr.IP
+		default:
+			if remote != nil {
+				host := remote.String()
+				if h, _, err := net.SplitHostPort(host); err == nil {
+					host = h
+				}
+				ip = net.ParseIP(host)
+			}
+		}
+		if ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+			return internal
+		}
+		return external
+	}
+}
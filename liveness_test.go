@@ -0,0 +1,160 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLivenessAsymmetric(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	pinger, responder, err := pair.New()
+	require.NoError(t, err)
+
+	pingerConn := newAsync(pinger, loadOptions(WithLogger(&emptyLogger), WithLiveness(Liveness{
+		PingInterval: time.Millisecond * 50,
+		Timeout:      time.Millisecond * 250,
+	})), NoFeatures)
+	responderConn := newAsync(responder, loadOptions(WithLogger(&emptyLogger), WithLiveness(Liveness{
+		PingInterval: -1,
+		Timeout:      time.Millisecond * 250,
+	})), NoFeatures)
+
+	time.Sleep(time.Second)
+
+	assert.False(t, pingerConn.Closed())
+	assert.False(t, responderConn.Closed())
+	assert.NoError(t, pingerConn.Error())
+	assert.NoError(t, responderConn.Error())
+
+	err = pingerConn.Close()
+	assert.NoError(t, err)
+	err = responderConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestLivenessTimeout(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	liveness := WithLiveness(Liveness{
+		PingInterval: -1,
+		Timeout:      time.Millisecond * 100,
+	})
+	readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), liveness), NoFeatures)
+	writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), liveness), NoFeatures)
+
+	assert.Eventually(t, func() bool {
+		return readerConn.Closed() && writerConn.Closed()
+	}, time.Second*2, time.Millisecond*10)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestServerLivenessPolicy(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	s, err := NewServer(make(HandlerTable), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetLivenessPolicy(nil), LivenessPolicyNil)
+
+	external := Liveness{PingInterval: time.Millisecond * 50, Timeout: time.Millisecond * 250}
+	err = s.SetLivenessPolicy(func(_ net.Addr) Liveness {
+		return external
+	})
+	require.NoError(t, err)
+
+	serverLiveness := make(chan Liveness, 1)
+	s.ConnContext = func(ctx context.Context, c *Async) context.Context {
+		serverLiveness <- c.options.Liveness
+		return ctx
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger), WithLiveness(Liveness{
+		PingInterval: -1,
+		Timeout:      time.Millisecond * 250,
+	}))
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	assert.Equal(t, external, <-serverLiveness)
+
+	time.Sleep(time.Second)
+	assert.False(t, c.Closed())
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestNetworkLivenessPolicy(t *testing.T) {
+	t.Parallel()
+
+	internal := Liveness{PingInterval: -1, Timeout: time.Minute}
+	external := Liveness{PingInterval: time.Second, Timeout: time.Second * 3}
+	policy := NetworkLivenessPolicy(internal, external)
+
+	assert.Equal(t, internal, policy(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8192}))
+	assert.Equal(t, internal, policy(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8192}))
+	assert.Equal(t, internal, policy(&net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 8192}))
+	assert.Equal(t, external, policy(&net.TCPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 8192}))
+	assert.Equal(t, external, policy(pipeAddr{}))
+	assert.Equal(t, external, policy(nil))
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,133 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/rs/zerolog"
+)
+
+// KeyValueLogger is a structured logger that takes its fields as alternating keys and values, which is
+// implemented by logr.Logger (see NewLogrLogger)
+type KeyValueLogger interface {
+	Info(msg string, keysAndValues ...interface{})
+	Error(err error, msg string, keysAndValues ...interface{})
+}
+
+// NewLogrLogger returns a logger for WithLogger that writes the logs of frisbee to the given logr.Logger (or any other
+// KeyValueLogger). Debug and trace logs are written to debug (like logger.V(1)), and are not even generated if debug
+// is nil, since the debug logs of the read loop are far too hot to leave on in production.
+func NewLogrLogger(logger KeyValueLogger, debug KeyValueLogger) *zerolog.Logger {
+	level := zerolog.TraceLevel
+	if debug == nil {
+		level = zerolog.InfoLevel
+	}
+	l := zerolog.New(&keyValueWriter{log: func(level zerolog.Level, msg string, err error, keysAndValues []interface{}) {
+		if level >= zerolog.ErrorLevel && level != zerolog.NoLevel {
+			logger.Error(err, msg, keysAndValues...)
+			return
+		}
+		if err != nil {
+			keysAndValues = append(keysAndValues, zerolog.ErrorFieldName, err.Error())
+		}
+		if level < zerolog.InfoLevel {
+			if debug != nil {
+				debug.Info(msg, keysAndValues...)
+			}
+			return
+		}
+		logger.Info(msg, keysAndValues...)
+	}}).Level(level)
+	return &l
+}
+
+// keyValueWriter is a zerolog.LevelWriter that decodes the events of a zerolog.Logger into
+// their message, error, and fields, and passes them to a structured logger
+type keyValueWriter struct {
+	log func(level zerolog.Level, msg string, err error, keysAndValues []interface{})
+}
+
+func (w *keyValueWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *keyValueWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return 0, err
+	}
+	if level == zerolog.NoLevel {
+		if name, ok := fields[zerolog.LevelFieldName].(string); ok {
+			level, _ = zerolog.ParseLevel(name)
+		}
+	}
+	delete(fields, zerolog.LevelFieldName)
+	msg, _ := fields[zerolog.MessageFieldName].(string)
+	delete(fields, zerolog.MessageFieldName)
+	var err error
+	if e, ok := fields[zerolog.ErrorFieldName].(string); ok {
+		err = errors.New(e)
+		delete(fields, zerolog.ErrorFieldName)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keysAndValues := make([]interface{}, 0, len(keys)*2)
+	for _, key := range keys {
+		keysAndValues = append(keysAndValues, key, fields[key])
+	}
+	w.log(level, msg, err, keysAndValues)
+	return len(p), nil
+}
+
+// SetLogLevel changes the level of the logs of the connection at runtime, which takes precedence over the log level
+// of the client or server that the connection belongs to
+func (c *Async) SetLogLevel(level zerolog.Level) {
+	logger := c.logger.Level(level)
+	c.levelLogger.Store(&logger)
+}
+
+// SetLogLevel changes the level of the logs of the server and all of its connections at runtime
+// (unless their log level was set with Async.SetLogLevel)
+func (s *Server) SetLogLevel(level zerolog.Level) {
+	s.options.setLogLevel(level)
+}
+
+// SetLogLevel changes the level of the logs of the client and its connection at runtime
+// (unless the log level of the connection was set with Async.SetLogLevel)
+func (c *Client) SetLogLevel(level zerolog.Level) {
+	c.options.setLogLevel(level)
+}
+
+// setLogLevel changes the level of the logger of the options, which is shared by the connections that use them
+func (o *Options) setLogLevel(level zerolog.Level) {
+	logger := o.Logger.Level(level)
+	o.levelLogger.Store(&logger)
+}
+
+// logger returns the logger of the options with its log level applied
+func (o *Options) logger() *zerolog.Logger {
+	if logger := o.levelLogger.Load(); logger != nil {
+		return logger
+	}
+	return o.Logger
+}
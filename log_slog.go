@@ -0,0 +1,55 @@
+//go:build go1.21
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rs/zerolog"
+)
+
+// NewSlogLogger returns a logger for WithLogger that writes the logs of frisbee to the given slog.Logger. Debug and trace
+// logs are only generated if the handler of the slog.Logger is enabled for slog.LevelDebug when NewSlogLogger is called.
+func NewSlogLogger(logger *slog.Logger) *zerolog.Logger {
+	level := zerolog.TraceLevel
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		level = zerolog.InfoLevel
+	}
+	l := zerolog.New(&keyValueWriter{log: func(level zerolog.Level, msg string, err error, keysAndValues []interface{}) {
+		if err != nil {
+			keysAndValues = append(keysAndValues, zerolog.ErrorFieldName, err)
+		}
+		logger.Log(context.Background(), slogLevel(level), msg, keysAndValues...)
+	}}).Level(level)
+	return &l
+}
+
+// slogLevel returns the slog.Level of the given zerolog.Level
+func slogLevel(level zerolog.Level) slog.Level {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return slog.LevelDebug
+	case zerolog.InfoLevel, zerolog.NoLevel:
+		return slog.LevelInfo
+	case zerolog.WarnLevel:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
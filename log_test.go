@@ -0,0 +1,96 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLogger is a KeyValueLogger that records the messages that it logs
+type recordingLogger struct {
+	entries []string
+	fields  [][]interface{}
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.entries = append(l.entries, "info: "+msg)
+	l.fields = append(l.fields, keysAndValues)
+}
+
+func (l *recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.entries = append(l.entries, "error: "+msg+": "+err.Error())
+	l.fields = append(l.fields, keysAndValues)
+}
+
+func TestLogrLogger(t *testing.T) {
+	t.Parallel()
+
+	logger, debug := new(recordingLogger), new(recordingLogger)
+	l := NewLogrLogger(logger, debug)
+	l.Info().Str("Key", "value").Int("Count", 2).Msg("connected")
+	l.Debug().Msg("packet received")
+	l.Error().Err(errors.New("broken")).Msg("closing")
+
+	assert.Equal(t, []string{"info: connected", "error: closing: broken"}, logger.entries)
+	assert.Equal(t, []interface{}{"Count", float64(2), "Key", "value"}, logger.fields[0])
+	assert.Equal(t, []string{"info: packet received"}, debug.entries)
+
+	// Debug logs are not generated without a debug logger
+	logger = new(recordingLogger)
+	l = NewLogrLogger(logger, nil)
+	assert.Equal(t, zerolog.InfoLevel, l.GetLevel())
+	l.Debug().Msg("packet received")
+	l.Warn().Err(errors.New("slow")).Msg("write")
+	assert.Equal(t, []string{"info: write"}, logger.entries)
+	assert.Equal(t, []interface{}{"error", "slow"}, logger.fields[0])
+}
+
+func TestSetLogLevel(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard).Level(zerolog.InfoLevel)
+	options := loadOptions(WithLogger(&emptyLogger))
+	reader, writer := net.Pipe()
+	readerConn := newAsync(reader, options, NoFeatures)
+	writerConn := newAsync(writer, options, NoFeatures)
+	t.Cleanup(func() {
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+	assert.Equal(t, zerolog.InfoLevel, readerConn.Logger().GetLevel())
+
+	options.setLogLevel(zerolog.DebugLevel)
+	assert.Equal(t, zerolog.DebugLevel, readerConn.Logger().GetLevel())
+	assert.Equal(t, zerolog.DebugLevel, writerConn.Logger().GetLevel())
+
+	readerConn.SetLogLevel(zerolog.ErrorLevel)
+	assert.Equal(t, zerolog.ErrorLevel, readerConn.Logger().GetLevel())
+	assert.Equal(t, zerolog.DebugLevel, writerConn.Logger().GetLevel())
+
+	server, err := NewServer(HandlerTable{}, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	server.SetLogLevel(zerolog.WarnLevel)
+	assert.Equal(t, zerolog.WarnLevel, server.Logger().GetLevel())
+	assert.Equal(t, zerolog.InfoLevel, emptyLogger.GetLevel())
+}
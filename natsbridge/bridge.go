@@ -0,0 +1,264 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package natsbridge bridges frisbee operations to NATS subjects in both directions, so that existing
+// NATS infrastructure can interoperate with frisbee edge connections.
+//
+// It lives in its own module so that the core frisbee module does not depend on the NATS client.
+package natsbridge
+
+import (
+	"context"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// These are various errors that can be returned by the Bridge:
+var (
+	InvalidMapping = errors.New("invalid mapping, a reserved operation or an empty subject may have been used")
+	OperationInUse = errors.New("operation is already in use by the handler table")
+	BridgeStarted  = errors.New("bridge has already been started")
+	BridgeClosed   = errors.New("bridge has been closed")
+)
+
+// Direction is the direction that packets are forwarded in for a Mapping
+type Direction uint8
+
+const (
+	// ToNATS forwards the content of frisbee packets with the mapped operation to the mapped NATS subject
+	ToNATS = Direction(1 << iota)
+
+	// FromNATS forwards NATS messages on the mapped subject to every attached frisbee connection as
+	// packets with the mapped operation
+	FromNATS
+
+	// Both forwards packets and messages in both directions
+	Both = ToNATS | FromNATS
+)
+
+// Mapping maps a frisbee operation to a NATS subject
+type Mapping struct {
+	Operation uint16
+	Subject   string
+	Direction Direction
+}
+
+// Conn is the subset of *nats.Conn used by the Bridge
+type Conn interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error)
+}
+
+// Writer is a frisbee connection (like *frisbee.Async or *frisbee.Client) that NATS messages can be forwarded to
+type Writer interface {
+	WritePacket(p *packet.Packet) error
+	CloseChannel() <-chan struct{}
+}
+
+// Bridge forwards frisbee packets to NATS subjects and NATS messages to frisbee connections
+// based on its Mappings.
+type Bridge struct {
+	conn          Conn
+	logger        *zerolog.Logger
+	mappings      []Mapping
+	mu            sync.Mutex
+	writers       map[Writer]struct{}
+	subscriptions []*nats.Subscription
+	started       bool
+	closed        bool
+}
+
+// New returns a new Bridge that uses the given NATS connection. The Start method must then be called to
+// subscribe to the NATS subjects of all FromNATS mappings.
+//
+// If logger is nil, frisbee.DefaultLogger is used.
+func New(conn Conn, logger *zerolog.Logger, mappings ...Mapping) (*Bridge, error) {
+	for _, mapping := range mappings {
+		if mapping.Operation <= frisbee.RESERVED9 || mapping.Subject == "" || mapping.Direction&Both == 0 {
+			return nil, InvalidMapping
+		}
+	}
+	if logger == nil {
+		logger = &frisbee.DefaultLogger
+	}
+	return &Bridge{
+		conn:     conn,
+		logger:   logger,
+		mappings: mappings,
+		writers:  make(map[Writer]struct{}),
+	}, nil
+}
+
+// AddHandlers adds a handler for the operation of every ToNATS mapping to handlerTable, which
+// publishes the content of incoming packets to the mapped NATS subject.
+func (b *Bridge) AddHandlers(handlerTable frisbee.HandlerTable) error {
+	for _, mapping := range b.mappings {
+		if mapping.Direction&ToNATS == 0 {
+			continue
+		}
+		if _, ok := handlerTable[mapping.Operation]; ok {
+			return OperationInUse
+		}
+		handlerTable[mapping.Operation] = b.handler(mapping.Subject)
+	}
+	return nil
+}
+
+// Register adds the handlers for the ToNATS mappings to the handler table of the server, and attaches
+// every connection to the server so that it receives the messages of the FromNATS mappings.
+//
+// This function should not be called once the server has started.
+func (b *Bridge) Register(s *frisbee.Server) error {
+	handlerTable := make(frisbee.HandlerTable)
+	for operation, handler := range s.GetHandlerTable() {
+		handlerTable[operation] = handler
+	}
+	err := b.AddHandlers(handlerTable)
+	if err != nil {
+		return err
+	}
+	err = s.SetHandlerTable(handlerTable)
+	if err != nil {
+		return err
+	}
+
+	connContext := s.ConnContext
+	s.ConnContext = func(ctx context.Context, conn *frisbee.Async) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		b.Attach(conn)
+		return ctx
+	}
+	return nil
+}
+
+// Attach adds a frisbee connection that messages from the FromNATS mappings will be forwarded to.
+// The connection is detached automatically once it is closed.
+func (b *Bridge) Attach(w Writer) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.writers[w] = struct{}{}
+	b.mu.Unlock()
+	go func() {
+		<-w.CloseChannel()
+		b.Detach(w)
+	}()
+}
+
+// Detach removes a frisbee connection that was previously attached
+func (b *Bridge) Detach(w Writer) {
+	b.mu.Lock()
+	delete(b.writers, w)
+	b.mu.Unlock()
+}
+
+// Start subscribes to the NATS subject of every FromNATS mapping
+func (b *Bridge) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return BridgeClosed
+	}
+	if b.started {
+		return BridgeStarted
+	}
+	for _, mapping := range b.mappings {
+		if mapping.Direction&FromNATS == 0 {
+			continue
+		}
+		subscription, err := b.conn.Subscribe(mapping.Subject, b.forward(mapping.Operation))
+		if err != nil {
+			b.unsubscribe()
+			return err
+		}
+		b.subscriptions = append(b.subscriptions, subscription)
+	}
+	b.started = true
+	return nil
+}
+
+// Close unsubscribes from all NATS subjects and detaches all frisbee connections. It does not
+// close the NATS connection or any of the frisbee connections.
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.writers = make(map[Writer]struct{})
+	return b.unsubscribe()
+}
+
+// unsubscribe removes all the NATS subscriptions of the bridge, and must be called with b.mu held
+func (b *Bridge) unsubscribe() (err error) {
+	for _, subscription := range b.subscriptions {
+		if subscription == nil {
+			continue
+		}
+		if unsubscribeErr := subscription.Unsubscribe(); unsubscribeErr != nil && err == nil {
+			err = unsubscribeErr
+		}
+	}
+	b.subscriptions = nil
+	return
+}
+
+// handler returns a frisbee.Handler that publishes the content of incoming packets to subject
+func (b *Bridge) handler(subject string) frisbee.Handler {
+	return func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		data := make([]byte, incoming.Metadata.ContentLength)
+		copy(data, *incoming.Content)
+		if err := b.conn.Publish(subject, data); err != nil {
+			b.logger.Error().Err(err).Str("Subject", subject).Msg("error while publishing packet to NATS")
+		}
+		return nil, frisbee.NONE
+	}
+}
+
+// forward returns a nats.MsgHandler that writes the data of incoming messages to every attached
+// frisbee connection as packets with the given operation
+func (b *Bridge) forward(operation uint16) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		b.mu.Lock()
+		writers := make([]Writer, 0, len(b.writers))
+		for w := range b.writers {
+			writers = append(writers, w)
+		}
+		b.mu.Unlock()
+
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		p.Content.Write(msg.Data)
+		p.Metadata.ContentLength = uint32(len(msg.Data))
+		for _, w := range writers {
+			if err := w.WritePacket(p); err != nil {
+				b.logger.Debug().Err(err).Str("Subject", msg.Subject).Msg("error while forwarding NATS message, detaching connection")
+				b.Detach(w)
+			}
+		}
+		packet.Put(p)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package natsbridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	upOperation   = uint16(32)
+	downOperation = uint16(33)
+)
+
+type fakeConn struct {
+	mu        sync.Mutex
+	handlers  map[string]nats.MsgHandler
+	published chan *nats.Msg
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		handlers:  make(map[string]nats.MsgHandler),
+		published: make(chan *nats.Msg, 16),
+	}
+}
+
+func (f *fakeConn) Publish(subject string, data []byte) error {
+	f.published <- &nats.Msg{Subject: subject, Data: data}
+	return nil
+}
+
+func (f *fakeConn) Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	f.mu.Lock()
+	f.handlers[subject] = cb
+	f.mu.Unlock()
+	return nil, nil
+}
+
+func (f *fakeConn) deliver(subject string, data []byte) {
+	f.mu.Lock()
+	cb := f.handlers[subject]
+	f.mu.Unlock()
+	cb(&nats.Msg{Subject: subject, Data: data})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(newFakeConn(), nil, Mapping{Operation: frisbee.STREAM, Subject: "edge", Direction: Both})
+	assert.ErrorIs(t, err, InvalidMapping)
+
+	_, err = New(newFakeConn(), nil, Mapping{Operation: upOperation, Direction: Both})
+	assert.ErrorIs(t, err, InvalidMapping)
+
+	_, err = New(newFakeConn(), nil, Mapping{Operation: upOperation, Subject: "edge"})
+	assert.ErrorIs(t, err, InvalidMapping)
+
+	b, err := New(newFakeConn(), nil, Mapping{Operation: upOperation, Subject: "edge", Direction: Both})
+	require.NoError(t, err)
+
+	handlerTable := make(frisbee.HandlerTable)
+	handlerTable[upOperation] = func(_ context.Context, _ *packet.Packet) (*packet.Packet, frisbee.Action) {
+		return nil, frisbee.NONE
+	}
+	assert.ErrorIs(t, b.AddHandlers(handlerTable), OperationInUse)
+
+	require.NoError(t, b.Start())
+	assert.ErrorIs(t, b.Start(), BridgeStarted)
+	require.NoError(t, b.Close())
+	assert.ErrorIs(t, b.Start(), BridgeClosed)
+}
+
+func TestBridge(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	nc := newFakeConn()
+
+	b, err := New(nc, &emptyLogger,
+		Mapping{Operation: upOperation, Subject: "edge.up", Direction: ToNATS},
+		Mapping{Operation: downOperation, Subject: "edge.down", Direction: FromNATS},
+	)
+	require.NoError(t, err)
+
+	s, err := frisbee.NewServer(make(frisbee.HandlerTable), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, b.Register(s))
+	require.NoError(t, b.Start())
+
+	down := make(chan []byte, 1)
+	clientHandlerTable := make(frisbee.HandlerTable)
+	clientHandlerTable[downOperation] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		down <- append([]byte(nil), incoming.Content.Bytes()...)
+		return nil, frisbee.NONE
+	}
+	c, err := frisbee.NewClient(clientHandlerTable, context.Background(), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = upOperation
+	p.Content.Write([]byte("telemetry"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+
+	msg := <-nc.published
+	assert.Equal(t, "edge.up", msg.Subject)
+	assert.Equal(t, []byte("telemetry"), msg.Data)
+
+	nc.deliver("edge.down", []byte("command"))
+	assert.Equal(t, []byte("command"), <-down)
+
+	require.NoError(t, b.Close())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
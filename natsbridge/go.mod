@@ -0,0 +1,29 @@
+module github.com/loopholelabs/frisbee-go/natsbridge
+
+go 1.23.0
+
+replace github.com/loopholelabs/frisbee-go => ../
+
+require (
+	github.com/loopholelabs/frisbee-go v0.7.2
+	github.com/nats-io/nats.go v1.48.0
+	github.com/pkg/errors v0.9.1
+	github.com/rs/zerolog v1.30.0
+	github.com/stretchr/testify v1.8.4
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/loopholelabs/common v0.4.9 // indirect
+	github.com/loopholelabs/polyglot v1.1.2 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,268 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package noise
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxMessageSize is the largest Noise message, including its authentication tag
+	maxMessageSize = 65535
+
+	// maxPayloadSize is the largest amount of data that is encrypted into a single Noise message
+	maxPayloadSize = maxMessageSize - tagLen
+)
+
+// Config is used to configure the Noise handshake of a connection
+type Config struct {
+	// Pattern is the handshake pattern (XX by default)
+	Pattern Pattern
+
+	// StaticKey is the static key pair that identifies this peer
+	StaticKey KeyPair
+
+	// RemoteStatic is the static public key of the responder, which is required by initiators using the IK pattern
+	RemoteStatic []byte
+
+	// Prologue is data that both peers must agree on for the handshake to succeed (like a protocol version)
+	Prologue []byte
+
+	// VerifyPeer is called with the static public key of the remote peer as soon as it is known, and the
+	// handshake fails if it returns an error. If it is nil, any remote peer is accepted.
+	VerifyPeer func(remoteStatic []byte) error
+}
+
+// Conn is a net.Conn secured using the Noise protocol. The handshake is performed the first time that the
+// connection is read from or written to (or when Handshake is called).
+type Conn struct {
+	net.Conn
+	config    *Config
+	initiator bool
+
+	handshakeOnce sync.Once
+	handshakeErr  error
+	remoteStatic  []byte
+
+	readMu  sync.Mutex
+	recv    *cipherState
+	pending []byte
+	frame   []byte
+
+	writeMu sync.Mutex
+	send    *cipherState
+	out     []byte
+}
+
+// Client returns a new Conn that performs the initiator side of the Noise handshake on conn
+func Client(conn net.Conn, config *Config) *Conn {
+	return &Conn{Conn: conn, config: config, initiator: true}
+}
+
+// Server returns a new Conn that performs the responder side of the Noise handshake on conn
+func Server(conn net.Conn, config *Config) *Conn {
+	return &Conn{Conn: conn, config: config}
+}
+
+// Handshake performs the Noise handshake if it has not been performed yet
+func (c *Conn) Handshake() error {
+	c.handshakeOnce.Do(func() {
+		c.handshakeErr = c.handshake()
+		if c.handshakeErr != nil {
+			c.handshakeErr = errors.Wrap(c.handshakeErr, HandshakeFailed.Error())
+		}
+	})
+	return c.handshakeErr
+}
+
+// RemoteStatic returns the static public key of the remote peer, which is only available once the handshake has completed
+func (c *Conn) RemoteStatic() []byte {
+	if c.Handshake() != nil {
+		return nil
+	}
+	return c.remoteStatic
+}
+
+func (c *Conn) handshake() error {
+	hs, err := newHandshakeState(c.config, c.initiator)
+	if err != nil {
+		return err
+	}
+	writing := c.initiator
+	verified := false
+	for !hs.done() {
+		if writing {
+			message, err := hs.writeMessage()
+			if err != nil {
+				return err
+			}
+			if err = c.writeFrame(message); err != nil {
+				return err
+			}
+		} else {
+			message, err := c.readFrame()
+			if err != nil {
+				return err
+			}
+			if err = hs.readMessage(message); err != nil {
+				return err
+			}
+		}
+		writing = !writing
+		if !verified && hs.rs != nil {
+			verified = true
+			if c.config.VerifyPeer != nil {
+				if err = c.config.VerifyPeer(hs.rs.Bytes()); err != nil {
+					return errors.Wrap(err, PeerVerifyRejected.Error())
+				}
+			}
+		}
+	}
+	c.remoteStatic = hs.rs.Bytes()
+	initiatorCipher, responderCipher := hs.ss.split()
+	if c.initiator {
+		c.send, c.recv = initiatorCipher, responderCipher
+	} else {
+		c.send, c.recv = responderCipher, initiatorCipher
+	}
+	return nil
+}
+
+// writeFrame writes a length-prefixed Noise message to the underlying connection
+func (c *Conn) writeFrame(message []byte) error {
+	frame := make([]byte, 2, 2+len(message))
+	binary.BigEndian.PutUint16(frame, uint16(len(message)))
+	_, err := c.Conn.Write(append(frame, message...))
+	return err
+}
+
+// readFrame reads a length-prefixed Noise message from the underlying connection
+func (c *Conn) readFrame() ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(c.Conn, size[:]); err != nil {
+		return nil, err
+	}
+	if cap(c.frame) < maxMessageSize {
+		c.frame = make([]byte, maxMessageSize)
+	}
+	frame := c.frame[:binary.BigEndian.Uint16(size[:])]
+	if _, err := io.ReadFull(c.Conn, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Read reads and decrypts data from the connection, performing the handshake first if required
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.pending) == 0 {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.pending, err = c.recv.decrypt(frame[:0], nil, frame)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write encrypts and writes data to the connection, performing the handshake first if required
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxPayloadSize {
+			chunk = chunk[:maxPayloadSize]
+		}
+		out := append(c.out[:0], 0, 0)
+		out, err := c.send.encrypt(out, nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		c.out = out
+		binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+		if _, err = c.Conn.Write(out); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+type listener struct {
+	net.Listener
+	config *Config
+}
+
+// NewListener returns a net.Listener that accepts connections from inner and wraps them using Server. It can be
+// passed to frisbee.Server.StartWithListener to serve frisbee connections secured using Noise.
+func NewListener(inner net.Listener, config *Config) net.Listener {
+	return &listener{Listener: inner, config: config}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, l.config), nil
+}
+
+// Dialer returns a frisbee.DialFunc that dials connections using a net.Dialer and wraps them using Client, performing
+// the handshake before the connection is returned. It can be used with the frisbee.WithDialer option.
+func Dialer(config *Config) frisbee.DialFunc {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		noiseConn := Client(conn, config)
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		err = noiseConn.Handshake()
+		_ = conn.SetDeadline(time.Time{})
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return noiseConn, nil
+	}
+}
@@ -0,0 +1,411 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package noise implements the Noise_XX and Noise_IK handshakes (using X25519, AES-GCM and SHA-256) as an
+// alternative to TLS for peer-to-peer frisbee deployments without a PKI. Peers are identified by their static
+// public keys, which are verified using a callback instead of certificates.
+//
+// Connections are wrapped using Client and Server (or NewListener and Dialer) before they are passed to frisbee.
+package noise
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	InvalidPattern     = errors.New("invalid handshake pattern")
+	MissingStaticKey   = errors.New("a static key is required")
+	MissingRemoteKey   = errors.New("the static key of the remote peer is required for the IK pattern")
+	InvalidMessage     = errors.New("invalid handshake message")
+	DecryptionFailed   = errors.New("message could not be decrypted")
+	NonceExhausted     = errors.New("nonce has been exhausted")
+	HandshakeFailed    = errors.New("noise handshake failed")
+	PeerVerifyRejected = errors.New("static key of the remote peer was rejected")
+)
+
+const (
+	dhLen   = 32
+	hashLen = sha256.Size
+	tagLen  = 16
+	keyLen  = 32
+)
+
+// Pattern is a Noise handshake pattern
+type Pattern int
+
+const (
+	// XX is the Noise_XX pattern, where both peers transmit their static keys during the handshake, so neither peer
+	// needs to know the other's static key in advance
+	XX = Pattern(iota)
+
+	// IK is the Noise_IK pattern, where the initiator already knows the static key of the responder (see
+	// Config.RemoteStatic), which completes the handshake in a single round trip
+	IK
+)
+
+func (p Pattern) name() string {
+	switch p {
+	case XX:
+		return "Noise_XX_25519_AESGCM_SHA256"
+	case IK:
+		return "Noise_IK_25519_AESGCM_SHA256"
+	}
+	return ""
+}
+
+// KeyPair is an X25519 key pair
+type KeyPair struct {
+	Private []byte
+	Public  []byte
+}
+
+// GenerateKeyPair returns a new random X25519 KeyPair
+func GenerateKeyPair() (KeyPair, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{Private: key.Bytes(), Public: key.PublicKey().Bytes()}, nil
+}
+
+// cipherState is the CipherState of the Noise specification
+type cipherState struct {
+	aead cipher.AEAD
+	n    uint64
+}
+
+func newCipherState(key []byte) *cipherState {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return &cipherState{aead: aead}
+}
+
+func (c *cipherState) nonce() ([]byte, error) {
+	if c.n == math.MaxUint64 {
+		return nil, NonceExhausted
+	}
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], c.n)
+	c.n++
+	return nonce[:], nil
+}
+
+func (c *cipherState) encrypt(dst []byte, ad []byte, plaintext []byte) ([]byte, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(dst, nonce, plaintext, ad), nil
+}
+
+func (c *cipherState) decrypt(dst []byte, ad []byte, ciphertext []byte) ([]byte, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.aead.Open(dst, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, DecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// symmetricState is the SymmetricState of the Noise specification
+type symmetricState struct {
+	ck []byte
+	h  []byte
+	k  *cipherState
+}
+
+func newSymmetricState(protocolName string) *symmetricState {
+	s := new(symmetricState)
+	if len(protocolName) <= hashLen {
+		s.h = make([]byte, hashLen)
+		copy(s.h, protocolName)
+	} else {
+		sum := sha256.Sum256([]byte(protocolName))
+		s.h = sum[:]
+	}
+	s.ck = append([]byte(nil), s.h...)
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h)
+	h.Write(data)
+	s.h = h.Sum(nil)
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	var key []byte
+	s.ck, key = hkdf(s.ck, ikm)
+	s.k = newCipherState(key[:keyLen])
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	ciphertext := plaintext
+	if s.k != nil {
+		var err error
+		ciphertext, err = s.k.encrypt(nil, s.h, plaintext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext := ciphertext
+	if s.k != nil {
+		var err error
+		plaintext, err = s.k.decrypt(nil, s.h, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the cipher states for messages sent by the initiator and by the responder
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf(s.ck, nil)
+	return newCipherState(k1[:keyLen]), newCipherState(k2[:keyLen])
+}
+
+// hkdf is the HKDF function of the Noise specification, returning two outputs
+func hkdf(chainingKey []byte, ikm []byte) ([]byte, []byte) {
+	tempKey := hmacSum(chainingKey, ikm)
+	output1 := hmacSum(tempKey, []byte{0x01})
+	output2 := hmacSum(tempKey, append(append([]byte(nil), output1...), 0x02))
+	return output1, output2
+}
+
+func hmacSum(key []byte, data []byte) []byte {
+	var mac hash.Hash = hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// token is a token of a Noise message pattern
+type token int
+
+const (
+	tokenE = token(iota)
+	tokenS
+	tokenEE
+	tokenES
+	tokenSE
+	tokenSS
+)
+
+// messagePatterns returns the message patterns of p, where the first message is sent by the initiator
+func (p Pattern) messagePatterns() [][]token {
+	switch p {
+	case XX:
+		return [][]token{
+			{tokenE},
+			{tokenE, tokenEE, tokenS, tokenES},
+			{tokenS, tokenSE},
+		}
+	case IK:
+		return [][]token{
+			{tokenE, tokenES, tokenS, tokenSS},
+			{tokenE, tokenEE, tokenSE},
+		}
+	}
+	return nil
+}
+
+// handshakeState is the HandshakeState of the Noise specification
+type handshakeState struct {
+	ss        *symmetricState
+	initiator bool
+	s         *ecdh.PrivateKey
+	e         *ecdh.PrivateKey
+	rs        *ecdh.PublicKey
+	re        *ecdh.PublicKey
+	patterns  [][]token
+}
+
+func newHandshakeState(config *Config, initiator bool) (*handshakeState, error) {
+	name := config.Pattern.name()
+	if name == "" {
+		return nil, InvalidPattern
+	}
+	if len(config.StaticKey.Private) == 0 {
+		return nil, MissingStaticKey
+	}
+	s, err := ecdh.X25519().NewPrivateKey(config.StaticKey.Private)
+	if err != nil {
+		return nil, err
+	}
+	hs := &handshakeState{
+		ss:        newSymmetricState(name),
+		initiator: initiator,
+		s:         s,
+		patterns:  config.Pattern.messagePatterns(),
+	}
+	hs.ss.mixHash(config.Prologue)
+
+	// The IK pattern has a pre-message with the static key of the responder
+	if config.Pattern == IK {
+		if initiator {
+			if len(config.RemoteStatic) == 0 {
+				return nil, MissingRemoteKey
+			}
+			hs.rs, err = ecdh.X25519().NewPublicKey(config.RemoteStatic)
+			if err != nil {
+				return nil, err
+			}
+			hs.ss.mixHash(config.RemoteStatic)
+		} else {
+			hs.ss.mixHash(s.PublicKey().Bytes())
+		}
+	}
+	return hs, nil
+}
+
+func (hs *handshakeState) dh(private *ecdh.PrivateKey, public *ecdh.PublicKey) ([]byte, error) {
+	if private == nil || public == nil {
+		return nil, InvalidMessage
+	}
+	return private.ECDH(public)
+}
+
+func (hs *handshakeState) mixDH(token token) error {
+	var private *ecdh.PrivateKey
+	var public *ecdh.PublicKey
+	switch token {
+	case tokenEE:
+		private, public = hs.e, hs.re
+	case tokenSS:
+		private, public = hs.s, hs.rs
+	case tokenES:
+		if hs.initiator {
+			private, public = hs.e, hs.rs
+		} else {
+			private, public = hs.s, hs.re
+		}
+	case tokenSE:
+		if hs.initiator {
+			private, public = hs.s, hs.re
+		} else {
+			private, public = hs.e, hs.rs
+		}
+	}
+	shared, err := hs.dh(private, public)
+	if err != nil {
+		return err
+	}
+	hs.ss.mixKey(shared)
+	return nil
+}
+
+// writeMessage writes the next handshake message (which must be sent by this peer) along with an empty payload
+func (hs *handshakeState) writeMessage() ([]byte, error) {
+	var message []byte
+	pattern := hs.patterns[0]
+	hs.patterns = hs.patterns[1:]
+	for _, t := range pattern {
+		switch t {
+		case tokenE:
+			e, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+			hs.e = e
+			message = append(message, e.PublicKey().Bytes()...)
+			hs.ss.mixHash(e.PublicKey().Bytes())
+		case tokenS:
+			ciphertext, err := hs.ss.encryptAndHash(hs.s.PublicKey().Bytes())
+			if err != nil {
+				return nil, err
+			}
+			message = append(message, ciphertext...)
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	payload, err := hs.ss.encryptAndHash(nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(message, payload...), nil
+}
+
+// readMessage reads the next handshake message (which must have been sent by the remote peer)
+func (hs *handshakeState) readMessage(message []byte) error {
+	var err error
+	pattern := hs.patterns[0]
+	hs.patterns = hs.patterns[1:]
+	for _, t := range pattern {
+		switch t {
+		case tokenE:
+			if len(message) < dhLen {
+				return InvalidMessage
+			}
+			hs.re, err = ecdh.X25519().NewPublicKey(message[:dhLen])
+			if err != nil {
+				return InvalidMessage
+			}
+			hs.ss.mixHash(message[:dhLen])
+			message = message[dhLen:]
+		case tokenS:
+			size := dhLen
+			if hs.ss.k != nil {
+				size += tagLen
+			}
+			if len(message) < size {
+				return InvalidMessage
+			}
+			static, err := hs.ss.decryptAndHash(message[:size])
+			if err != nil {
+				return err
+			}
+			hs.rs, err = ecdh.X25519().NewPublicKey(static)
+			if err != nil {
+				return InvalidMessage
+			}
+			message = message[size:]
+		default:
+			if err = hs.mixDH(t); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = hs.ss.decryptAndHash(message)
+	return err
+}
+
+// done returns whether all the handshake messages have been sent and received
+func (hs *handshakeState) done() bool {
+	return len(hs.patterns) == 0
+}
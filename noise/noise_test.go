@@ -0,0 +1,206 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package noise
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshake performs the handshake between a client and server using the given configs
+func handshake(t *testing.T, clientConfig *Config, serverConfig *Config) (*Conn, *Conn, error, error) {
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientRaw.Close()
+		_ = serverRaw.Close()
+	})
+	client, server := Client(clientRaw, clientConfig), Server(serverRaw, serverConfig)
+	serverErr := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		if err != nil {
+			_ = serverRaw.Close()
+		}
+		serverErr <- err
+	}()
+	clientErr := client.Handshake()
+	if clientErr != nil {
+		_ = clientRaw.Close()
+	}
+	return client, server, clientErr, <-serverErr
+}
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	clientKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+	serverKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	for name, pattern := range map[string]Pattern{"XX": XX, "IK": IK} {
+		pattern := pattern
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client, server, clientErr, serverErr := handshake(t,
+				&Config{Pattern: pattern, StaticKey: clientKey, RemoteStatic: serverKey.Public, Prologue: []byte("test")},
+				&Config{Pattern: pattern, StaticKey: serverKey, Prologue: []byte("test")},
+			)
+			require.NoError(t, clientErr)
+			require.NoError(t, serverErr)
+			assert.Equal(t, serverKey.Public, client.RemoteStatic())
+			assert.Equal(t, clientKey.Public, server.RemoteStatic())
+
+			data := make([]byte, maxPayloadSize*3+7)
+			_, _ = rand.Read(data)
+			go func() {
+				_, _ = client.Write(data)
+			}()
+			received := make([]byte, len(data))
+			_, err := io.ReadFull(server, received)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, received))
+
+			go func() {
+				_, _ = server.Write([]byte("reply"))
+			}()
+			reply := make([]byte, 5)
+			_, err = io.ReadFull(client, reply)
+			require.NoError(t, err)
+			assert.Equal(t, "reply", string(reply))
+		})
+	}
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	t.Parallel()
+
+	clientKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+	serverKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+	otherKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	rejected := errors.New("unknown peer")
+	verifyPeer := func(remoteStatic []byte) error {
+		if !bytes.Equal(remoteStatic, clientKey.Public) {
+			return rejected
+		}
+		return nil
+	}
+
+	// The server only accepts the client's key
+	_, _, _, serverErr := handshake(t,
+		&Config{StaticKey: otherKey},
+		&Config{StaticKey: serverKey, VerifyPeer: verifyPeer},
+	)
+	assert.ErrorIs(t, serverErr, rejected)
+
+	// The client expects a different server key
+	_, _, clientErr, serverErr := handshake(t,
+		&Config{Pattern: IK, StaticKey: clientKey, RemoteStatic: otherKey.Public},
+		&Config{Pattern: IK, StaticKey: serverKey},
+	)
+	assert.Error(t, clientErr)
+	assert.ErrorIs(t, serverErr, DecryptionFailed)
+
+	// The peers use different prologues
+	_, _, clientErr, serverErr = handshake(t,
+		&Config{StaticKey: clientKey, Prologue: []byte("v1")},
+		&Config{StaticKey: serverKey, Prologue: []byte("v2")},
+	)
+	assert.Error(t, clientErr)
+	assert.Error(t, serverErr)
+
+	client := Client(nil, &Config{Pattern: IK, StaticKey: clientKey})
+	assert.ErrorIs(t, client.Handshake(), MissingRemoteKey)
+}
+
+func TestFrisbee(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	clientKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+	serverKey, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	serverHandlerTable := make(frisbee.HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := frisbee.NewServer(serverHandlerTable, frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(NewListener(inner, &Config{Pattern: IK, StaticKey: serverKey}))
+	}()
+
+	received := make(chan []byte, 1)
+	clientHandlerTable := make(frisbee.HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		received <- append([]byte(nil), *incoming.Content...)
+		return
+	}
+	c, err := frisbee.NewClient(clientHandlerTable, context.Background(), frisbee.WithLogger(&emptyLogger),
+		frisbee.WithDialer(Dialer(&Config{Pattern: IK, StaticKey: clientKey, RemoteStatic: serverKey.Public})))
+	require.NoError(t, err)
+	err = c.Connect(inner.Addr().String())
+	require.NoError(t, err)
+
+	raw, err := c.Raw()
+	require.NoError(t, err)
+	_, ok := raw.(*Conn)
+	assert.True(t, ok)
+	_ = raw.Close()
+
+	c, err = frisbee.NewClient(clientHandlerTable, context.Background(), frisbee.WithLogger(&emptyLogger),
+		frisbee.WithDialer(Dialer(&Config{Pattern: IK, StaticKey: clientKey, RemoteStatic: serverKey.Public})))
+	require.NoError(t, err)
+	err = c.Connect(inner.Addr().String())
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write([]byte("hello"))
+	p.Metadata.ContentLength = 5
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+	assert.Equal(t, []byte("hello"), <-received)
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, s.Shutdown())
+}
@@ -17,9 +17,12 @@
 package frisbee
 
 import (
+	"compress/flate"
 	"crypto/tls"
 	"github.com/rs/zerolog"
+	"go.uber.org/atomic"
 	"io"
+	"net/url"
 	"time"
 )
 
@@ -36,11 +39,119 @@ var DefaultLogger = zerolog.New(io.Discard)
 //	options := Options {
 //		KeepAlive: time.Minute * 3,
 //		Logger: &DefaultLogger,
+//		CompressionLevel: flate.DefaultCompression,
+//		InlineThreshold: DefaultInlineThreshold,
+//		Liveness: DefaultLiveness,
 //	}
 type Options struct {
-	KeepAlive time.Duration
-	Logger    *zerolog.Logger
-	TLSConfig *tls.Config
+	KeepAlive     time.Duration
+	Logger        *zerolog.Logger
+	TLSConfig     *tls.Config
+	TLSSessions   tls.ClientSessionCache
+	TLSVerifier   CertificateVerifier
+	TLSKeyLog     io.Writer
+	Handshake     bool
+	Features      Features
+	RekeyInterval time.Duration
+	Recorder      PacketRecorder
+
+	CompressionLevel        int
+	CompressionPolicy       CompressionPolicy
+	CompressionDictionaries []CompressionDictionary
+
+	StreamCompressionLevel int
+
+	InlineThreshold int
+
+	BusyPoll time.Duration
+
+	Liveness Liveness
+	Idle     Idle
+
+	Proxy       *url.URL
+	UpgradePath string
+
+	ConnWrappers []ConnWrapper
+	WireWrappers []ConnWrapper
+
+	Dialer DialFunc
+
+	CloseNotify time.Duration
+
+	Filter Filter
+
+	Authenticator Authenticator
+
+	ContentRouter ContentRouter
+
+	LazyStart bool
+
+	SigningKey    []byte
+	EncryptionKey []byte
+
+	SequenceNumbers     bool
+	SequenceWindow      int
+	SequenceDiagnostics bool
+
+	UnknownExtensions UnknownExtensionPolicy
+	ExtensionHandlers map[uint8]ExtensionHandler
+	ControlHandlers   map[uint16]ControlHandler
+
+	SocketOptions *SocketOptions
+
+	DeprecationHandler DeprecationHandler
+
+	FastOpen bool
+
+	KeepAliveConfig *KeepAliveConfig
+
+	ReadBandwidth  Bandwidth
+	WriteBandwidth Bandwidth
+
+	FlushStrategy FlushStrategy
+
+	Priorities *PriorityWeights
+
+	StreamQueue StreamQueue
+
+	Session *Session
+
+	Deduplicator *Deduplicator
+
+	Router *Router
+
+	ReadBuffer ReadBuffer
+
+	LargeContentLimit uint64
+
+	Scheduler *Scheduler
+
+	WriteCoalescer *WriteCoalescer
+
+	// signingKeys are the keys derived for a single connection from SigningKey during the handshake
+	signingKeys connectionKeys
+
+	// encryptionKeys are the keys derived for a single connection from EncryptionKey and the key exchange during the handshake
+	encryptionKeys connectionKeys
+
+	// dictionaries are the prepared CompressionDictionaries, and dictionary is the one negotiated for a single connection
+	dictionaries []*dictionary
+	dictionary   *dictionary
+
+	// health returns the Health reported by the connections of a server when they are health checked
+	health func() Health
+
+	// initiator is true for the connections of a client (see Async.Initiator)
+	initiator bool
+
+	// limiter is the GlobalLimit of the server that the connection belongs to (see Server.SetGlobalLimit)
+	limiter *globalLimiter
+
+	// trackActivity records the last time a packet was read or written on a connection (see EvictIdle)
+	trackActivity bool
+
+	// levelLogger is Logger with the log level set by Server.SetLogLevel or Client.SetLogLevel (if nil, Logger is used)
+	levelLogger *atomic.Pointer[zerolog.Logger]
 }
 
 func loadOptions(options ...Option) *Options {
@@ -52,14 +163,77 @@ func loadOptions(options ...Option) *Options {
 	if opts.Logger == nil {
 		opts.Logger = &DefaultLogger
 	}
+	opts.levelLogger = atomic.NewPointer[zerolog.Logger](nil)
 
 	if opts.KeepAlive == 0 {
 		opts.KeepAlive = time.Minute * 3
 	}
 
+	if opts.CompressionLevel == 0 || !validCompressionLevel(opts.CompressionLevel) {
+		opts.CompressionLevel = flate.DefaultCompression
+	}
+
+	if opts.CompressionPolicy == nil {
+		opts.CompressionPolicy = defaultCompressionPolicy
+	}
+
+	if opts.LargeContentLimit == 0 {
+		opts.LargeContentLimit = DefaultLargeContentLimit
+	}
+
+	opts.dictionaries = make([]*dictionary, 0, len(opts.CompressionDictionaries))
+	for _, d := range opts.CompressionDictionaries {
+		opts.dictionaries = append(opts.dictionaries, newDictionary(d))
+	}
+
+	opts.TLSConfig = withSessionCache(opts.TLSConfig, opts.TLSSessions)
+	opts.TLSConfig = withCertificateVerifier(opts.TLSConfig, opts.TLSVerifier)
+	if opts.TLSKeyLog != nil && opts.TLSConfig != nil {
+		opts.TLSConfig = KeyLogConfig(opts.TLSConfig, opts.TLSKeyLog)
+		opts.Logger.Warn().Msg("TLS key logging is enabled, which must only be used for debugging")
+	}
+
+	opts.Liveness = opts.Liveness.withDefaults()
+
+	opts.FlushStrategy = opts.FlushStrategy.withDefaults()
+
+	opts.StreamQueue = opts.StreamQueue.withDefaults()
+
+	if required := opts.requiredFeatures(); required != NoFeatures {
+		opts.Handshake = true
+		opts.Features |= required
+	}
+
+	if opts.SequenceWindow < 0 {
+		opts.SequenceWindow = 0
+	} else if opts.SequenceWindow > MaxSequenceWindow || (opts.SequenceDiagnostics && !opts.SequenceNumbers) {
+		opts.SequenceWindow = MaxSequenceWindow
+	}
+
+	if opts.InlineThreshold == 0 {
+		opts.InlineThreshold = DefaultInlineThreshold
+	} else if opts.InlineThreshold > MaxInlineThreshold {
+		opts.InlineThreshold = MaxInlineThreshold
+	}
+
 	return opts
 }
 
+// requiredFeatures returns the features that must be negotiated during the handshake for a connection to be used
+func (o *Options) requiredFeatures() Features {
+	required := NoFeatures
+	if o.SigningKey != nil {
+		required |= FeatureSigning
+	}
+	if o.SequenceNumbers || o.SequenceDiagnostics {
+		required |= FeatureSequenceNumbers
+	}
+	if o.EncryptionKey != nil {
+		required |= FeatureEncryption
+	}
+	return required
+}
+
 // WithOptions allows users to pass in an Options struct to configure a frisbee client or server
 func WithOptions(options Options) Option {
 	return func(opts *Options) {
@@ -74,6 +248,15 @@ func WithKeepAlive(keepAlive time.Duration) Option {
 	}
 }
 
+// WithKeepAliveConfig sets the KeepAliveConfig of the TCP sockets of the frisbee client or server, which controls how long
+// the connection can be idle before keepalive probes are sent, how often they are sent, and how many can go unacknowledged
+// (as well as TCP_USER_TIMEOUT) so that dead peers are detected quickly. It is applied after the WithKeepAlive option.
+func WithKeepAliveConfig(config KeepAliveConfig) Option {
+	return func(opts *Options) {
+		opts.KeepAliveConfig = &config
+	}
+}
+
 // WithLogger sets the logger for the frisbee client or server
 func WithLogger(logger *zerolog.Logger) Option {
 	return func(opts *Options) {
@@ -89,3 +272,466 @@ func WithTLS(tlsConfig *tls.Config) Option {
 		opts.TLSConfig = tlsConfig
 	}
 }
+
+// WithFeatures enables the HELLO handshake for the frisbee client or server and sets the Features
+// that will be negotiated during it. For a client these are the features that will be requested,
+// and for a server these are the features that it supports.
+//
+// When the handshake is enabled on a server, every client connecting to it must also have the handshake enabled.
+func WithFeatures(features Features) Option {
+	return func(opts *Options) {
+		opts.Handshake = true
+		opts.Features = features
+	}
+}
+
+// WithRekeyInterval sets how often the frisbee client or server will send REKEY packets to rotate the
+// symmetric keys of a connection (use 0 to disable). It only has an effect on connections that have
+// negotiated the FeatureRekey feature.
+func WithRekeyInterval(rekeyInterval time.Duration) Option {
+	return func(opts *Options) {
+		opts.RekeyInterval = rekeyInterval
+	}
+}
+
+// WithRecorder sets a PacketRecorder that will record every packet read from or written to
+// the connections of the frisbee client or server
+func WithRecorder(recorder PacketRecorder) Option {
+	return func(opts *Options) {
+		opts.Recorder = recorder
+	}
+}
+
+// WithCompression sets the compression level (as defined by the compress/flate package) and the CompressionPolicy that
+// will be used for the content of packets on connections that have negotiated the FeatureCompression feature.
+//
+// A level of 0 (or an invalid level) uses flate.DefaultCompression, and a nil policy compresses every packet.
+// Packets that do not get smaller when compressed are always sent uncompressed.
+func WithCompression(level int, policy CompressionPolicy) Option {
+	return func(opts *Options) {
+		opts.CompressionLevel = level
+		opts.CompressionPolicy = policy
+	}
+}
+
+// WithCompressionDictionary adds a pre-shared CompressionDictionary to the frisbee client or server, which is used to
+// compress the content of packets on connections that have negotiated the FeatureCompression feature. The option can
+// be used more than once, and clients prefer their dictionaries in the order they were added.
+//
+// The dictionaries of the client and the server are referenced by their ID and a fingerprint of their content during
+// the handshake, and connections use the first dictionary of the client that the server also has. Connections without
+// a shared dictionary are compressed without one (see Async.Dictionary). Content that is compressed with a dictionary
+// uses at least compression level 7, since the faster levels do not find matches in the dictionary for small packets.
+func WithCompressionDictionary(id uint32, data []byte) Option {
+	return func(opts *Options) {
+		opts.CompressionDictionaries = append(opts.CompressionDictionaries, CompressionDictionary{ID: id, Data: data})
+	}
+}
+
+// WithStreamCompression sets the compression level (as defined by the compress/flate package) of the streams of
+// connections that have negotiated the FeatureStreamCompression feature. A level of 0 (or an invalid level) uses
+// DefaultStreamCompressionLevel.
+//
+// Every stream keeps its own compression context for as long as it is open, so packets that repeat the content of
+// earlier packets on the same stream compress far better than they would on their own, which suits streams of many
+// small, similar messages. The compression context of a stream holds up to a few hundred kilobytes of memory on the
+// writer (depending on the level) and about 40KB on the reader. Since streams are compressed on their own, combining
+// FeatureStreamCompression with FeatureCompression only adds overhead for stream packets.
+func WithStreamCompression(level int) Option {
+	return func(opts *Options) {
+		opts.StreamCompressionLevel = level
+	}
+}
+
+// WithInlineThreshold sets the size (in bytes) at or below which the content of a packet is carried inline in
+// its extended header instead of after it (use -1 to disable). It only has an effect on connections that have
+// negotiated the FeatureExtendedHeaders feature, and is capped at MaxInlineThreshold.
+func WithInlineThreshold(threshold int) Option {
+	return func(opts *Options) {
+		opts.InlineThreshold = threshold
+	}
+}
+
+// WithBusyPoll enables busy-polling for up to busyPoll on every connection of the frisbee client or server (see Async.SetBusyPoll).
+//
+// Busy-polling uses a significant amount of CPU and should only be enabled for co-located, latency-critical deployments. Use
+// Async.SetBusyPoll (for example from the Server's ConnContext) to enable it for individual connections instead.
+func WithBusyPoll(busyPoll time.Duration) Option {
+	return func(opts *Options) {
+		opts.BusyPoll = busyPoll
+	}
+}
+
+// WithLiveness sets the Liveness used by the connections of the frisbee client or server, which controls how often PING
+// packets are sent and how long a connection can be idle before it is closed. Zero values in the Liveness are replaced
+// with the values from DefaultLiveness.
+//
+// For a server, the liveness of individual connections can be set using Server.SetLivenessPolicy instead.
+func WithLiveness(liveness Liveness) Option {
+	return func(opts *Options) {
+		opts.Liveness = liveness
+	}
+}
+
+// WithIdle enables the idle mode of the connections of the frisbee client or server, where connections that have
+// had no traffic for idle.After stretch their ping interval and stop waking up their background goroutines
+// until traffic resumes (see Idle for more details).
+func WithIdle(idle Idle) Option {
+	return func(opts *Options) {
+		opts.Idle = idle
+	}
+}
+
+// WithDialer sets the DialFunc that is used by the frisbee client to dial the server (or the proxy, if one has been
+// configured with the WithProxy option). If the WithTLS option is also used, the connection returned by the dialer is
+// wrapped in TLS by frisbee, otherwise the connection is used as-is.
+func WithDialer(dial DialFunc) Option {
+	return func(opts *Options) {
+		opts.Dialer = dial
+	}
+}
+
+// WithTLSResumption makes the frisbee client cache the TLS sessions of its connections in the given cache (or in a
+// new LRU cache of the default size if it is nil), so that clients that reconnect to the same server resume their
+// previous session instead of performing a full TLS handshake (see Client.Resumed). Clients that share a cache
+// can resume each other's sessions. Servers resume sessions by default, unless SessionTicketsDisabled is set in
+// their TLS configuration. It has no effect if the TLS configuration of the client already has a ClientSessionCache.
+//
+// TLS 1.3 0-RTT (early data) is not offered, since crypto/tls does not support it. Resumed sessions still skip the
+// certificate exchange and verification, and the WithFastOpen option can be used to remove the round trip of
+// the TCP handshake instead (without the replay risks that early data carries).
+func WithTLSResumption(cache tls.ClientSessionCache) Option {
+	return func(opts *Options) {
+		if cache == nil {
+			cache = tls.NewLRUClientSessionCache(0)
+		}
+		opts.TLSSessions = cache
+	}
+}
+
+// WithCertificateVerifier sets the CertificateVerifier that is called during the TLS handshake of every connection
+// of the frisbee client or server to verify the certificate of the peer with a custom policy. The verified identity
+// of the peer is available to handlers through PeerIdentityFromContext (see Async.PeerIdentity).
+func WithCertificateVerifier(verifier CertificateVerifier) Option {
+	return func(opts *Options) {
+		opts.TLSVerifier = verifier
+	}
+}
+
+// WithKeyLogWriter makes the TLS connections of the frisbee client or server write their TLS secrets to w in the
+// NSS key log format, so that captures of them can be decrypted with tools like Wireshark (see KeyLogConfig). It has
+// no effect without the WithTLS option, and must only be used for debugging.
+func WithKeyLogWriter(w io.Writer) Option {
+	return func(opts *Options) {
+		opts.TLSKeyLog = w
+	}
+}
+
+// WithTLSDialer makes the frisbee client dial the server using the given tls.Dialer, which establishes TLS itself
+// (so it should not be combined with the WithTLS option).
+func WithTLSDialer(dialer *tls.Dialer) Option {
+	return WithDialer(dialer.DialContext)
+}
+
+// WithCloseNotify makes connections of the frisbee client or server close gracefully when they are closed locally, by
+// sending a TLS close_notify alert and then waiting up to timeout for the peer's close_notify before closing the underlying
+// connection. This keeps strict TLS peers (and some middleboxes) from reporting truncation errors for every graceful
+// shutdown. Plain TCP connections are half-closed in the same way, and all other connections are closed immediately.
+//
+// Any packets that arrive while waiting for the peer to close the connection are discarded.
+func WithCloseNotify(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.CloseNotify = timeout
+	}
+}
+
+// WithFilter sets the Filter that is called by the connections of the frisbee client or server for every incoming packet
+// before its content is read, which can be used to drop unwanted packets or close connections with misbehaving peers.
+func WithFilter(filter Filter) Option {
+	return func(opts *Options) {
+		opts.Filter = filter
+	}
+}
+
+// WithAuthenticator makes the frisbee client authenticate itself to the server using the given Authenticator right after
+// it connects, before any other packets are sent. The server must be using a matching Verifier (see Server.SetVerifier).
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(opts *Options) {
+		opts.Authenticator = authenticator
+	}
+}
+
+// WithContentRouter sets the ContentRouter that is called by the connections of the frisbee client or server for every
+// incoming packet before its content is read, which can be used to stream the content of large packets to another
+// connection (see Async.Forward) or to a sink instead of buffering it.
+func WithContentRouter(router ContentRouter) Option {
+	return func(opts *Options) {
+		opts.ContentRouter = router
+	}
+}
+
+// WithLazyStart defers starting the background goroutines of the connections of the frisbee client or server until they
+// are needed: the flush goroutine is started when the first packet is written, and the ping goroutine is only started if
+// the connection sends PING or REKEY packets (or uses idle mode). This reduces the cost of constructing many connections
+// up-front (like in connection pools or tests) when only some of them are used.
+func WithLazyStart() Option {
+	return func(opts *Options) {
+		opts.LazyStart = true
+	}
+}
+
+// WithSigningKey makes the connections of the frisbee client or server sign every packet with an HMAC-SHA256 of its header
+// and content, which is verified by the receiver before the packet is used. This protects the integrity of packets on
+// deployments where TLS is terminated before the frisbee server (like at an untrusted edge proxy).
+//
+// Both the client and the server must be configured with the same key. Each connection is signed with its own key, which is
+// derived from the given key and random nonces exchanged during the handshake (so the handshake and FeatureSigning are
+// enabled automatically), and which is ratcheted forward whenever the connection is rekeyed. Connections where the peer
+// does not negotiate FeatureSigning are rejected, and connections that receive a packet with an invalid signature are
+// closed with the InvalidSignature error.
+func WithSigningKey(key []byte) Option {
+	return func(opts *Options) {
+		opts.SigningKey = key
+	}
+}
+
+// WithEncryptionKey makes the connections of the frisbee client or server encrypt the content of every packet with
+// AES-256-GCM, which keeps payloads confidential end-to-end on deployments where TLS is terminated before the frisbee
+// server (like at an untrusted edge proxy). The operation and ID of a packet are authenticated along with its
+// content, but are not encrypted.
+//
+// Both the client and the server must be configured with the same key. Each direction of every connection is encrypted
+// with its own key, which is derived from an ephemeral X25519 key exchange during the handshake (so the handshake and
+// FeatureEncryption are enabled automatically), and every packet is bound to its direction and sequence number. The connection keys are forward secret, since learning the given key later does not
+// reveal them, and the given key authenticates the exchange, so a proxy that does not have it cannot read or modify
+// payloads by interposing itself in the exchange. Connections where the peer does not negotiate FeatureEncryption
+// are rejected, and connections that receive a packet that cannot be decrypted are closed with the DecryptionFailed error.
+func WithEncryptionKey(key []byte) Option {
+	return func(opts *Options) {
+		opts.EncryptionKey = key
+	}
+}
+
+// WithSequenceNumbers makes the connections of the frisbee client or server add a monotonically increasing sequence number to
+// every packet they write, and validate the sequence numbers of the packets they read. Packets with a sequence number that
+// has already been received, or that is more than window packets behind the highest sequence number received, are rejected
+// by closing the connection with the InvalidSequence error. Gaps in the sequence numbers are counted (see Async.MissedPackets).
+//
+// The window must be between 0 and MaxSequenceWindow. The handshake and FeatureSequenceNumbers are enabled automatically, and
+// connections where the peer does not negotiate FeatureSequenceNumbers are rejected. Combined with WithSigningKey, this
+// protects connections against replayed packets.
+func WithSequenceNumbers(window int) Option {
+	return func(opts *Options) {
+		opts.SequenceNumbers = true
+		opts.SequenceWindow = window
+	}
+}
+
+// WithSequenceDiagnostics enables a debug mode where the connections of the frisbee client or server add sequence numbers
+// to the packets they write (like WithSequenceNumbers), and log every gap, reordered packet, or duplicate packet in the
+// sequence numbers of the packets they read as a warning. The anomalies are also counted (see Async.SequenceDiagnostics),
+// which helps to tell whether packets are lost by frisbee, by the application, or by the network.
+//
+// Unlike WithSequenceNumbers, connections are not closed because of invalid sequence numbers unless both options are used.
+func WithSequenceDiagnostics() Option {
+	return func(opts *Options) {
+		opts.SequenceDiagnostics = true
+	}
+}
+
+// WithUnknownExtensionPolicy sets what the connections of the frisbee client or server do when they read a packet with an
+// extension in its extended header that they do not know about. The default policy is IgnoreUnknownExtensions.
+func WithUnknownExtensionPolicy(policy UnknownExtensionPolicy) Option {
+	return func(opts *Options) {
+		opts.UnknownExtensions = policy
+	}
+}
+
+// WithExtensionHandler registers an ExtensionHandler that is called by the connections of the frisbee client or server
+// for every incoming packet that carries an extension with the given type, which lets middleware consume extensions that
+// frisbee does not know about. Handlers cannot be registered for the extensions that are defined by frisbee (see the
+// frame package), which are always handled by frisbee itself.
+func WithExtensionHandler(extension uint8, handler ExtensionHandler) Option {
+	return func(opts *Options) {
+		if opts.ExtensionHandlers == nil {
+			opts.ExtensionHandlers = make(map[uint8]ExtensionHandler)
+		}
+		opts.ExtensionHandlers[extension] = handler
+	}
+}
+
+// WithControlHandler registers a ControlHandler that is called by the connections of the frisbee client or server for every
+// incoming control packet with the given control operation. It only has an effect on connections that have negotiated the
+// FeatureControl feature (see the WithFeatures option).
+func WithControlHandler(operation uint16, handler ControlHandler) Option {
+	return func(opts *Options) {
+		if opts.ControlHandlers == nil {
+			opts.ControlHandlers = make(map[uint16]ControlHandler)
+		}
+		opts.ControlHandlers[operation] = handler
+	}
+}
+
+// WithSocketOptions sets the SocketOptions (like TCP_NODELAY, the socket buffer sizes, TCP_USER_TIMEOUT, and the type of service)
+// on the TCP sockets of the frisbee client or server. The options are set right after the client dials the server or the server
+// accepts a connection, before the TLS handshake. Connections created with ConnectAsync or NewAsync can use Async.SetSocketOptions instead.
+func WithSocketOptions(options SocketOptions) Option {
+	return func(opts *Options) {
+		opts.SocketOptions = &options
+	}
+}
+
+// WithFastOpen enables TCP Fast Open on the sockets of the frisbee client or server, which lets clients that reconnect to a
+// server include the first bytes of the connection (like the TLS ClientHello or the HELLO handshake) in the SYN packet,
+// saving a round trip. Fast Open is only supported on Linux (where it must also be enabled by the net.ipv4.tcp_fastopen
+// sysctl), and connections fall back to regular TCP everywhere else.
+//
+// For servers, Fast Open is only enabled on the listener created by Server.Start. For clients, it has no effect when a
+// custom dialer is used (see the WithDialer option).
+func WithFastOpen() Option {
+	return func(opts *Options) {
+		opts.FastOpen = true
+	}
+}
+
+// WithBandwidth limits the rate at which bytes are read from and written to every connection of the frisbee client or server,
+// where each connection gets its own limits (see Async.SetReadThrottle and Async.SetWriteThrottle for sharing limits between
+// connections or changing them at runtime). This keeps background traffic on one connection from saturating a constrained link
+// that is shared with interactive traffic.
+func WithBandwidth(read Bandwidth, write Bandwidth) Option {
+	return func(opts *Options) {
+		opts.ReadBandwidth = read
+		opts.WriteBandwidth = write
+	}
+}
+
+// WithFlushStrategy sets the FlushStrategy used by the connections of the frisbee client or server, which controls
+// how writes are batched before they are flushed to the underlying connection. By default, the write buffer is
+// flushed as soon as possible after every write.
+func WithFlushStrategy(strategy FlushStrategy) Option {
+	return func(opts *Options) {
+		opts.FlushStrategy = strategy
+	}
+}
+
+// WithPriorities makes the connections of the frisbee client or server schedule their outbound packets by Priority,
+// using separate outbound queues that are drained by a weighted round-robin with the given PriorityWeights. This keeps
+// small urgent packets (written with Async.WritePacketPriority) from getting stuck behind megabytes of buffered bulk data.
+func WithPriorities(weights PriorityWeights) Option {
+	return func(opts *Options) {
+		opts.Priorities = &weights
+	}
+}
+
+// WithStreamQueue sets the StreamQueue of the streams of the frisbee client or server, which bounds the number of
+// received packets that are queued for every stream and decides what happens when a stream's queue is full
+func WithStreamQueue(queue StreamQueue) Option {
+	return func(opts *Options) {
+		opts.StreamQueue = queue
+	}
+}
+
+// WithSession enables the session layer of the frisbee client or server (which must both enable it), which resumes
+// the connection after brief network failures by redialing the server and replaying the bytes that were not received
+// (see Session). Sessions are only resumed for clients that were connected with Client.Connect.
+func WithSession(session Session) Option {
+	return func(opts *Options) {
+		session = session.withDefaults()
+		opts.Session = &session
+	}
+}
+
+// WithDeduplicator sets the Deduplicator that the connections of the frisbee client or server use to drop the
+// duplicate packets delivered by an Outbox, so that they are processed exactly once (see Deduplicator)
+func WithDeduplicator(deduplicator *Deduplicator) Option {
+	return func(opts *Options) {
+		opts.Deduplicator = deduplicator
+	}
+}
+
+// WithRouter sets the Router that the frisbee client or server uses to find the Handler for incoming packets whose
+// operations are not in its HandlerTable (see Router)
+func WithRouter(router *Router) Option {
+	return func(opts *Options) {
+		opts.Router = router
+	}
+}
+
+// WithReadBuffer sets the ReadBuffer policy that limits the memory held by the read buffers of the connections of the
+// frisbee client or server (see ReadBuffer)
+func WithReadBuffer(readBuffer ReadBuffer) Option {
+	return func(opts *Options) {
+		opts.ReadBuffer = readBuffer
+	}
+}
+
+// WithLargeContentLimit sets the largest content length (in bytes) that the connections of the frisbee client or
+// server accept in packets with content that does not fit in a uint32, which can only be sent once the
+// FeatureLargeContent feature has been negotiated. The content of every packet is read into memory, so the limit
+// keeps peers from making the connection allocate arbitrarily large buffers. A limit of 0 uses DefaultLargeContentLimit.
+func WithLargeContentLimit(limit uint64) Option {
+	return func(opts *Options) {
+		opts.LargeContentLimit = limit
+	}
+}
+
+// WithScheduler makes the connections of the frisbee client or server share the timers and goroutines of the given
+// Scheduler for flushing, pinging and rekeying, instead of running their own flush and ping loops (see Scheduler)
+func WithScheduler(scheduler *Scheduler) Option {
+	return func(opts *Options) {
+		opts.Scheduler = scheduler
+	}
+}
+
+// WithWriteCoalescer makes the connections of the frisbee client or server flush their writes in the batches of the
+// given WriteCoalescer instead of on their own, which takes precedence over the FlushStrategy (see WriteCoalescer)
+func WithWriteCoalescer(coalescer *WriteCoalescer) Option {
+	return func(opts *Options) {
+		opts.WriteCoalescer = coalescer
+	}
+}
+
+// WithDeprecationHandler sets the DeprecationHandler that is called by the frisbee client whenever the server signals that
+// an operation used by the client is deprecated. It only has an effect on connections that have negotiated the
+// FeatureDeprecation feature (see the WithFeatures option).
+func WithDeprecationHandler(handler DeprecationHandler) Option {
+	return func(opts *Options) {
+		opts.DeprecationHandler = handler
+	}
+}
+
+// WithProxy makes the frisbee client tunnel its connection through the HTTP proxy at the given URL using an HTTP CONNECT request,
+// which allows frisbee to traverse proxies that only forward HTTP. Credentials in the URL are sent using basic authentication.
+//
+// If a TLS configuration is also set, the TLS handshake is done with the frisbee server through the tunnel.
+func WithProxy(proxy *url.URL) Option {
+	return func(opts *Options) {
+		opts.Proxy = proxy
+	}
+}
+
+// WithUpgrade makes the frisbee client establish its connection by upgrading an HTTP/1.1 request for the given path
+// (similar to a WebSocket), which allows frisbee to traverse L7 proxies and ingress controllers that only forward HTTP.
+// The frisbee server must be serving the path using Server.ServeHTTP.
+func WithUpgrade(path string) Option {
+	return func(opts *Options) {
+		opts.UpgradePath = path
+	}
+}
+
+// WithConnWrapper installs a ConnWrapper (like a ByteCounter or a Throttle) around every connection of the frisbee
+// client or server. Wrappers are applied in the order that they were added, so the last wrapper is the outermost one.
+//
+// If includeTLS is false, the wrapper sees the bytes that frisbee reads and writes, otherwise it is installed beneath
+// TLS so that it sees the exact bytes on the wire (including the overhead of TLS). Wrappers are only installed beneath TLS
+// when the TLS connection is established by frisbee itself (see the WithTLS option), connections that are passed to
+// Client.FromConn or Server.ServeConn as *tls.Conn are wrapped above TLS instead.
+func WithConnWrapper(wrapper ConnWrapper, includeTLS bool) Option {
+	return func(opts *Options) {
+		if includeTLS {
+			opts.WireWrappers = append(opts.WireWrappers, wrapper)
+		} else {
+			opts.ConnWrappers = append(opts.ConnWrappers, wrapper)
+		}
+	}
+}
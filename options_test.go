@@ -83,3 +83,17 @@ func TestIndividualOptions(t *testing.T) {
 	assert.Equal(t, &logger, options.Logger)
 	assert.Equal(t, tlsConfig, options.TLSConfig)
 }
+
+func TestFeaturesOption(t *testing.T) {
+	t.Parallel()
+
+	options := loadOptions()
+	assert.False(t, options.Handshake)
+	assert.Equal(t, NoFeatures, options.Features)
+
+	options = loadOptions(WithFeatures(Features(1 << 2)))
+	assert.True(t, options.Handshake)
+	assert.Equal(t, Features(1<<2), options.Features)
+	assert.True(t, options.Features.Has(Features(1<<2)))
+	assert.False(t, options.Features.Has(Features(1<<3)))
+}
@@ -0,0 +1,318 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+)
+
+var (
+	InvalidOutboxStore = errors.New("invalid outbox store record")
+)
+
+// OutboxStore persists the packets of an Outbox that have not been acknowledged yet (see NewOutboxWithStore), so
+// that packets written while the client is disconnected (or before it restarts) are not lost.
+type OutboxStore interface {
+	// Append persists the packet with the given delivery ID, which is larger than the ID of every packet
+	// that was appended before it
+	Append(delivery uint64, p *packet.Packet) error
+
+	// Acknowledge removes the packets with delivery IDs up to the given delivery ID
+	Acknowledge(delivery uint64) error
+
+	// Load calls fn in order for every packet that was appended and not acknowledged, and returns the largest
+	// delivery ID that was ever appended (including the IDs of the packets that were acknowledged)
+	Load(fn func(delivery uint64, p *packet.Packet)) (uint64, error)
+
+	// Close closes the store
+	Close() error
+}
+
+// These are the types of the records in the file of a FileOutboxStore:
+const (
+	// outboxRecordPacket holds a delivery ID followed by the metadata and content of a packet
+	outboxRecordPacket = byte(iota + 1)
+
+	// outboxRecordAcknowledge holds a delivery ID, up to which the packets were acknowledged
+	outboxRecordAcknowledge
+
+	// outboxRecordLast holds the largest delivery ID that was ever appended, and is written when the file is compacted
+	outboxRecordLast
+)
+
+// outboxRecordHeaderSize is the size of the type and delivery ID at the start of every record
+const outboxRecordHeaderSize = 1 + deliverySize
+
+// FileOutboxStore is an OutboxStore that appends packets and acknowledgements to a single file, which is compacted
+// once most of the packets in it have been acknowledged. It only uses the standard library, and every append is
+// synced to disk before it returns.
+type FileOutboxStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+
+	// contentLimit is the largest content length that is accepted in the records of the file
+	contentLimit uint64
+
+	// live is the number of packets in the file that have not been acknowledged,
+	// and dead is the number of packets that have
+	live int
+	dead int
+
+	// acknowledged is the largest delivery ID that was acknowledged, and last is the largest that was appended
+	acknowledged uint64
+	last         uint64
+}
+
+// OpenFileOutboxStore opens the FileOutboxStore stored in the file at path, creating it if it does not exist.
+// Records with more content than the connections that send the packets accept (see WithLargeContentLimit)
+// are rejected when the file is loaded, so the given options should match those of the client or server.
+func OpenFileOutboxStore(path string, options ...Option) (*FileOutboxStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	contentLimit := loadOptions(options...).LargeContentLimit
+	if contentLimit > uint64(metadata.LargeContentLength) {
+		contentLimit = uint64(metadata.LargeContentLength)
+	}
+	return &FileOutboxStore{path: path, file: file, contentLimit: contentLimit}, nil
+}
+
+// Append persists p with the given delivery ID
+func (s *FileOutboxStore) Append(delivery uint64, p *packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := appendOutboxRecord(nil, outboxRecordPacket, delivery, p)
+	if _, err := s.file.Write(record); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.live++
+	if delivery > s.last {
+		s.last = delivery
+	}
+	return nil
+}
+
+// Acknowledge removes the packets up to the given delivery ID, and compacts the file
+// once there are more acknowledged packets in it than packets that have not been acknowledged
+func (s *FileOutboxStore) Acknowledge(delivery uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if delivery <= s.acknowledged {
+		return nil
+	}
+	if _, err := s.file.Write(appendOutboxRecord(nil, outboxRecordAcknowledge, delivery, nil)); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	acknowledged := int(delivery - s.acknowledged)
+	if acknowledged > s.live {
+		acknowledged = s.live
+	}
+	s.acknowledged = delivery
+	s.live -= acknowledged
+	s.dead += acknowledged
+	if s.dead > s.live {
+		return s.compact()
+	}
+	return nil
+}
+
+// Load calls fn for every packet in the file that has not been acknowledged. A record that was only partially
+// written before a crash is cut off the end of the file, since the records appended after it would otherwise
+// be unreadable.
+func (s *FileOutboxStore) Load(fn func(delivery uint64, p *packet.Packet)) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, last, size, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+	info, err := s.file.Stat()
+	if err == nil && info.Size() > size {
+		if err = s.file.Truncate(size); err == nil {
+			err = s.file.Sync()
+		}
+	}
+	if err != nil {
+		for _, record := range records {
+			packet.Put(record.packet)
+		}
+		return 0, err
+	}
+	s.live, s.dead, s.last = len(records), 0, last
+	if len(records) > 0 {
+		s.acknowledged = records[0].delivery - 1
+	} else {
+		s.acknowledged = last
+	}
+	for _, record := range records {
+		fn(record.delivery, record.packet)
+	}
+	return last, nil
+}
+
+// Close closes the file of the store
+func (s *FileOutboxStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// read reads the packets in the file that have not been acknowledged, along with the largest delivery ID in it
+// and the size of the records that were completely written to it
+func (s *FileOutboxStore) read() ([]outboxEntry, uint64, int64, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	reader := bufio.NewReader(file)
+	var entries []outboxEntry
+	var last uint64
+	var size int64
+	var header [outboxRecordHeaderSize]byte
+	for {
+		if _, err = io.ReadFull(reader, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// A record that was only partially written before a crash is ignored
+				return entries, last, size, nil
+			}
+			for _, entry := range entries {
+				packet.Put(entry.packet)
+			}
+			return nil, 0, 0, err
+		}
+		recordSize := int64(outboxRecordHeaderSize)
+		delivery := binary.BigEndian.Uint64(header[1:])
+		switch header[0] {
+		case outboxRecordPacket:
+			var encoded [metadata.Size]byte
+			if _, err = io.ReadFull(reader, encoded[:]); err != nil {
+				return entries, last, size, nil
+			}
+			contentLength := binary.BigEndian.Uint32(encoded[metadata.ContentLengthOffset:])
+			if uint64(contentLength) > s.contentLimit {
+				for _, entry := range entries {
+					packet.Put(entry.packet)
+				}
+				return nil, 0, 0, InvalidOutboxStore
+			}
+			recordSize += metadata.Size + int64(contentLength)
+			if size+recordSize > info.Size() {
+				// The content was only partially written, so it is not read into memory
+				return entries, last, size, nil
+			}
+			p := packet.Get()
+			p.Metadata.Id = binary.BigEndian.Uint16(encoded[metadata.IdOffset:])
+			p.Metadata.Operation = binary.BigEndian.Uint16(encoded[metadata.OperationOffset:])
+			p.Metadata.ContentLength = contentLength
+			content := make([]byte, contentLength)
+			if _, err = io.ReadFull(reader, content); err != nil {
+				packet.Put(p)
+				return entries, last, size, nil
+			}
+			p.Content.Write(content)
+			entries = append(entries, outboxEntry{delivery: delivery, packet: p})
+		case outboxRecordAcknowledge:
+			acknowledged := 0
+			for acknowledged < len(entries) && entries[acknowledged].delivery <= delivery {
+				packet.Put(entries[acknowledged].packet)
+				acknowledged++
+			}
+			entries = append(entries[:0], entries[acknowledged:]...)
+		case outboxRecordLast:
+		default:
+			for _, entry := range entries {
+				packet.Put(entry.packet)
+			}
+			return nil, 0, 0, InvalidOutboxStore
+		}
+		if delivery > last {
+			last = delivery
+		}
+		size += recordSize
+	}
+}
+
+// compact rewrites the file with only the packets that have not been acknowledged, and must be called with the store locked
+func (s *FileOutboxStore) compact() error {
+	entries, last, _, err := s.read()
+	if err != nil {
+		return err
+	}
+	record := appendOutboxRecord(nil, outboxRecordLast, last, nil)
+	for _, entry := range entries {
+		record = appendOutboxRecord(record, outboxRecordPacket, entry.delivery, entry.packet)
+		packet.Put(entry.packet)
+	}
+	temporary := s.path + ".compact"
+	if err = os.WriteFile(temporary, record, 0o600); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(temporary, os.O_RDWR|os.O_APPEND, 0o600)
+	if err == nil {
+		err = file.Sync()
+		if err == nil {
+			err = os.Rename(temporary, s.path)
+		}
+		if err != nil {
+			_ = file.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_ = s.file.Close()
+	s.file = file
+	s.live, s.dead = len(entries), 0
+	return nil
+}
+
+// appendOutboxRecord appends a record of the given type to b, which holds the metadata and content of p if it is not nil
+func appendOutboxRecord(b []byte, recordType byte, delivery uint64, p *packet.Packet) []byte {
+	var header [outboxRecordHeaderSize + metadata.Size]byte
+	header[0] = recordType
+	binary.BigEndian.PutUint64(header[1:outboxRecordHeaderSize], delivery)
+	if p == nil {
+		return append(b, header[:outboxRecordHeaderSize]...)
+	}
+	encoded := header[outboxRecordHeaderSize:]
+	binary.BigEndian.PutUint16(encoded[metadata.IdOffset:], p.Metadata.Id)
+	binary.BigEndian.PutUint16(encoded[metadata.OperationOffset:], p.Metadata.Operation)
+	binary.BigEndian.PutUint32(encoded[metadata.ContentLengthOffset:], p.Metadata.ContentLength)
+	b = append(b, header[:]...)
+	return append(b, (*p.Content)[:p.Metadata.ContentLength]...)
+}
@@ -0,0 +1,166 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOutboxStore(t *testing.T) {
+	t.Parallel()
+
+	const packets = 3
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	path := filepath.Join(t.TempDir(), "outbox")
+
+	open := func() *Outbox {
+		store, err := OpenFileOutboxStore(path)
+		require.NoError(t, err)
+		outbox, err := NewOutboxWithStore(packets, store)
+		require.NoError(t, err)
+		return outbox
+	}
+
+	// Packets written while the client is offline are persisted
+	outbox := open()
+	for i := 0; i < packets; i++ {
+		p := packet.Get()
+		p.Metadata.Operation = 10
+		p.Content.Write([]byte{byte(i)})
+		p.Metadata.ContentLength = 1
+		require.NoError(t, outbox.WritePacket(p))
+		packet.Put(p)
+	}
+	outbox.Close()
+
+	// and are written in order once a connection is attached after restarting
+	outbox = open()
+	assert.Equal(t, packets, outbox.Pending())
+	reader, writer := net.Pipe()
+	readerConn := newAsync(reader, options, FeatureAcknowledgements)
+	writerConn := newAsync(writer, options, FeatureAcknowledgements)
+	t.Cleanup(func() {
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+	require.NoError(t, outbox.Attach(writerConn))
+	for i := 0; i < packets; i++ {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, byte(i), (*p.Content)[0])
+		packet.Put(p)
+	}
+	require.Eventually(t, func() bool {
+		return outbox.Pending() == 0
+	}, DefaultDeadline, time.Millisecond)
+	outbox.Close()
+
+	// Acknowledged packets are compacted away, but their delivery IDs are not reused
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(outboxRecordHeaderSize), info.Size())
+	outbox = open()
+	assert.Equal(t, 0, outbox.Pending())
+	assert.Equal(t, uint64(packets), outbox.next)
+	outbox.Close()
+}
+
+func TestFileOutboxStoreTornRecord(t *testing.T) {
+	t.Parallel()
+
+	const packets = 3
+
+	path := filepath.Join(t.TempDir(), "outbox")
+
+	newPacket := func(content byte) *packet.Packet {
+		p := packet.Get()
+		p.Metadata.Operation = 10
+		p.Content.Write([]byte{content})
+		p.Metadata.ContentLength = 1
+		return p
+	}
+
+	load := func(store *FileOutboxStore) ([]uint64, []byte) {
+		var deliveries []uint64
+		var contents []byte
+		_, err := store.Load(func(delivery uint64, p *packet.Packet) {
+			deliveries = append(deliveries, delivery)
+			contents = append(contents, (*p.Content)[0])
+			packet.Put(p)
+		})
+		require.NoError(t, err)
+		return deliveries, contents
+	}
+
+	store, err := OpenFileOutboxStore(path)
+	require.NoError(t, err)
+	for i := 0; i < packets; i++ {
+		p := newPacket(byte(i))
+		require.NoError(t, store.Append(uint64(i+1), p))
+		packet.Put(p)
+	}
+	require.NoError(t, store.Close())
+
+	// The last record is cut off in the middle of its metadata, as if the client crashed while appending it
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-metadata.Size))
+
+	store, err = OpenFileOutboxStore(path)
+	require.NoError(t, err)
+	deliveries, contents := load(store)
+	assert.Equal(t, []uint64{1, 2}, deliveries)
+	assert.Equal(t, []byte{0, 1}, contents)
+
+	// Packets appended after loading the store are not lost behind the torn record
+	p := newPacket(packets)
+	require.NoError(t, store.Append(packets+1, p))
+	packet.Put(p)
+	require.NoError(t, store.Close())
+
+	store, err = OpenFileOutboxStore(path)
+	require.NoError(t, err)
+	deliveries, contents = load(store)
+	assert.Equal(t, []uint64{1, 2, packets + 1}, deliveries)
+	assert.Equal(t, []byte{0, 1, packets}, contents)
+	require.NoError(t, store.Close())
+
+	// Records with more content than the connections accept are rejected instead of being read into memory
+	store, err = OpenFileOutboxStore(path, WithLargeContentLimit(1))
+	require.NoError(t, err)
+	p = packet.Get()
+	p.Content.Write([]byte{0, 1})
+	p.Metadata.ContentLength = 2
+	require.NoError(t, store.Append(packets+2, p))
+	packet.Put(p)
+	_, err = store.Load(func(uint64, *packet.Packet) {})
+	assert.ErrorIs(t, err, InvalidOutboxStore)
+	require.NoError(t, store.Close())
+}
@@ -0,0 +1,95 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/loopholelabs/polyglot"
+)
+
+// PeekReader is implemented by connections that buffer their input and can expose it without copying it, like
+// connections backed by a bufio.Reader or by the LinkBuffer of netpoll. If the connection of an Async implements
+// PeekReader, its read loop decodes packets directly from the peeked bytes instead of first copying them into
+// its own buffer.
+//
+// Peek returns the next n bytes without consuming them, and the returned slice only needs to remain valid
+// until the next call to Peek or Discard. If n is larger than the buffer of the reader, Peek may return
+// fewer bytes along with an error (like bufio.ErrBufferFull), so the buffer must be able to hold at least
+// the header of a packet and its signature. Discard consumes the next n bytes.
+type PeekReader interface {
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+}
+
+// BufferedConn is a net.Conn whose reads are buffered using a bufio.Reader, and which implements
+// PeekReader so that it can be read from without an intermediate copy
+type BufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+var _ PeekReader = (*BufferedConn)(nil)
+
+// NewBufferedConn returns a BufferedConn that buffers up to size bytes that are read from conn
+func NewBufferedConn(conn net.Conn, size int) *BufferedConn {
+	return &BufferedConn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, size),
+	}
+}
+
+// Read reads from the buffer of the connection
+func (c *BufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// Peek returns the next n bytes that will be read from the connection without consuming them
+func (c *BufferedConn) Peek(n int) ([]byte, error) {
+	return c.reader.Peek(n)
+}
+
+// Discard consumes the next n bytes that would be read from the connection
+func (c *BufferedConn) Discard(n int) (int, error) {
+	return c.reader.Discard(n)
+}
+
+// peek discards the given number of bytes that have already been consumed by the read loop, and then
+// peeks at the next size bytes of the connection
+func (c *Async) peek(peeker PeekReader, consumed int, size int) ([]byte, error) {
+	if consumed > 0 {
+		if _, err := peeker.Discard(consumed); err != nil {
+			return nil, directed("read", err)
+		}
+		c.throttleRead(consumed)
+	}
+	if err := c.extendReadDeadline(); err != nil {
+		return nil, err
+	}
+	peeked, err := peeker.Peek(size)
+	return peeked, directed("read", err)
+}
+
+// contentWriter writes to the content of a packet
+type contentWriter struct {
+	*polyglot.Buffer
+}
+
+func (w contentWriter) Write(b []byte) (int, error) {
+	return w.Buffer.Write(b), nil
+}
@@ -0,0 +1,93 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncPeekReader(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 1 << 14
+	const packets = 64
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := func(signed bool) *Options {
+		options := loadOptions(WithLogger(&emptyLogger))
+		if signed {
+			options.signingKeys = connectionKeys{read: []byte("connection key"), write: []byte("connection key")}
+		}
+		return options
+	}
+
+	for name, features := range map[string]Features{
+		"plain":    NoFeatures,
+		"extended": FeatureRekey | FeatureExtendedHeaders,
+		"signed":   FeatureRekey | FeatureExtendedHeaders,
+	} {
+		name, features := name, features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+
+			// The buffer of the reader is smaller than the large packets, so their content is read in chunks
+			buffered := NewBufferedConn(reader, frame.MaxHeaderSize+frame.SignatureSize)
+			readerConn := newAsync(buffered, options(name == "signed"), features)
+			writerConn := newAsync(writer, options(name == "signed"), features)
+
+			large := make([]byte, testSize)
+			for i := range large {
+				large[i] = byte(i)
+			}
+			contents := [][]byte{[]byte("small"), nil, large}
+
+			for i := 0; i < packets; i++ {
+				content := contents[i%len(contents)]
+				p := packet.Get()
+				p.Metadata.Id = uint16(i)
+				p.Metadata.Operation = metadata.PacketPing
+				p.Content.Write(content)
+				p.Metadata.ContentLength = uint32(len(content))
+				require.NoError(t, writerConn.WritePacket(p))
+				packet.Put(p)
+			}
+
+			for i := 0; i < packets; i++ {
+				p, err := readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, uint16(i), p.Metadata.Id)
+				assert.Equal(t, string(contents[i%len(contents)]), string(*p.Content))
+				packet.Put(p)
+			}
+
+			assert.NoError(t, writerConn.Close())
+			assert.NoError(t, readerConn.Close())
+		})
+	}
+}
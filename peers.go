@@ -0,0 +1,87 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	DuplicatePeer          = errors.New("a connection with the same peer ID already exists")
+	MissingPeerCertificate = errors.New("peer did not present a TLS certificate")
+)
+
+// PeerIdentifier is called by the server for every incoming connection (after the handshake, but before any packets
+// are handled) and returns the ID of the peer, which the server uses to index the connection (see Server.Connection).
+//
+// The PeerIdentifier can authenticate the peer by inspecting the TLS state of the connection, or by reading and
+// writing packets with Async.ReadPacket and Async.WritePacket. If it returns an error (or an empty peer ID), the
+// connection is closed.
+type PeerIdentifier func(conn *Async) (peerID string, err error)
+
+// CertificatePeerIdentifier is a PeerIdentifier for mutual TLS, which identifies peers by the
+// common name of the certificate that they presented during the TLS handshake
+func CertificatePeerIdentifier(conn *Async) (string, error) {
+	identity, err := conn.PeerIdentity()
+	if err != nil {
+		return "", err
+	}
+	return identity.CommonName, nil
+}
+
+// DuplicatePolicy decides what the server does when a peer connects while it already has a connection with the same peer ID
+type DuplicatePolicy uint8
+
+const (
+	// KickOld closes the existing connection of the peer and replaces it with the new connection
+	KickOld DuplicatePolicy = iota
+
+	// RejectNew closes the new connection and keeps the existing connection of the peer
+	RejectNew
+)
+
+// PeerID returns the ID of the peer of the connection, which is only set for server connections when a
+// PeerIdentifier or a Verifier has been set using Server.SetPeerIdentifier or Server.SetVerifier
+func (c *Async) PeerID() string {
+	return c.peerID
+}
+
+// Connection returns the active connection of the peer with the given peer ID, or nil if the peer is not connected
+func (s *Server) Connection(peerID string) *Async {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	return s.peers[peerID]
+}
+
+// registerPeer indexes conn by its peer ID, applying the server's DuplicatePolicy if the peer is already connected. It
+// returns the connection that was replaced (which must be closed) and must be called with the connectionsMu held.
+func (s *Server) registerPeer(conn *Async) (*Async, error) {
+	old := s.peers[conn.peerID]
+	if old != nil && s.duplicatePolicy == RejectNew {
+		return nil, DuplicatePeer
+	}
+	s.peers[conn.peerID] = conn
+	return old, nil
+}
+
+// unregisterPeer removes conn from the peer index if it is still the connection of its peer,
+// and must be called with the connectionsMu held
+func (s *Server) unregisterPeer(conn *Async) {
+	if conn.peerID != "" && s.peers[conn.peerID] == conn {
+		delete(s.peers, conn.peerID)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerPeers(t *testing.T) {
+	t.Parallel()
+
+	const authOperation = uint16(11)
+
+	emptyLogger := zerolog.New(io.Discard)
+	s, err := NewServer(make(HandlerTable), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetPeerIdentifier(nil)
+	assert.ErrorIs(t, err, PeerIdentifierNil)
+
+	// Peers authenticate by sending their peer ID in the first packet
+	err = s.SetPeerIdentifier(func(conn *Async) (string, error) {
+		p, err := conn.ReadPacket()
+		if err != nil {
+			return "", err
+		}
+		defer packet.Put(p)
+		if p.Metadata.Operation != authOperation {
+			return "", InvalidHandshake
+		}
+		return string(*p.Content), nil
+	})
+	require.NoError(t, err)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+	addr := s.listener.Addr().String()
+
+	connect := func(peerID string) *Client {
+		c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		err = c.Connect(addr)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = c.Close()
+		})
+
+		p := packet.Get()
+		p.Metadata.Operation = authOperation
+		p.Content.Write([]byte(peerID))
+		p.Metadata.ContentLength = uint32(len(*p.Content))
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+		return c
+	}
+
+	closed := func(c *Client) bool {
+		select {
+		case <-c.CloseChannel():
+			return true
+		case <-time.After(time.Millisecond * 100):
+			return false
+		}
+	}
+
+	assert.Nil(t, s.Connection("device"))
+
+	first := connect("device")
+	require.Eventually(t, func() bool {
+		return s.Connection("device") != nil
+	}, time.Second, time.Millisecond)
+	firstConn := s.Connection("device")
+	assert.Equal(t, "device", firstConn.PeerID())
+
+	other := connect("other")
+	require.Eventually(t, func() bool {
+		return s.Connection("other") != nil
+	}, time.Second, time.Millisecond)
+
+	// By default the existing connection of a peer is replaced by its new connection
+	second := connect("device")
+	require.Eventually(t, func() bool {
+		c := s.Connection("device")
+		return c != nil && c != firstConn
+	}, time.Second, time.Millisecond)
+	secondConn := s.Connection("device")
+	assert.True(t, closed(first))
+	assert.True(t, firstConn.Closed())
+
+	s.SetDuplicatePolicy(RejectNew)
+	third := connect("device")
+	assert.True(t, closed(third))
+	assert.Equal(t, secondConn, s.Connection("device"))
+	assert.False(t, closed(second))
+	assert.False(t, closed(other))
+
+	err = second.Close()
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return s.Connection("device") == nil
+	}, time.Second, time.Millisecond)
+	assert.NotNil(t, s.Connection("other"))
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	assert.Nil(t, s.Connection("other"))
+}
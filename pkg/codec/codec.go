@@ -0,0 +1,108 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package codec provides pluggable serialization for the content of frisbee packets, along with generic helpers
+// for writing and reading typed messages, so that applications do not have to hand-roll serialization around
+// packet.Packet.
+//
+// The JSON, Protobuf and Msgpack codecs are provided, and any other serialization format can be used by
+// implementing the Marshaler and Unmarshaler interfaces.
+package codec
+
+import (
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+)
+
+// These are various errors that can be returned by the codecs:
+var (
+	InvalidMessageType = errors.New("invalid message type for codec")
+)
+
+// Marshaler encodes messages into bytes
+type Marshaler interface {
+	Marshal(msg any) ([]byte, error)
+}
+
+// Unmarshaler decodes bytes into messages
+type Unmarshaler interface {
+	Unmarshal(data []byte, msg any) error
+}
+
+// Codec is both a Marshaler and an Unmarshaler
+type Codec interface {
+	Marshaler
+	Unmarshaler
+}
+
+// PacketWriter is a frisbee connection that packets can be written to (like *frisbee.Async or *frisbee.Client)
+type PacketWriter interface {
+	WritePacket(*packet.Packet) error
+}
+
+// PacketReader is a frisbee connection that packets can be read from (like *frisbee.Async or *frisbee.Stream)
+type PacketReader interface {
+	ReadPacket() (*packet.Packet, error)
+}
+
+// Encode encodes msg using m and sets it as the content of p (along with its ContentLength)
+func Encode(m Marshaler, p *packet.Packet, msg any) error {
+	data, err := m.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.Content.Reset()
+	p.Content.Write(data)
+	p.Metadata.ContentLength = uint32(len(data))
+	return nil
+}
+
+// Decode decodes the content of p into a new message using u
+func Decode[T any](u Unmarshaler, p *packet.Packet) (*T, error) {
+	msg := new(T)
+	err := u.Unmarshal((*p.Content)[:p.Metadata.ContentLength], msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage encodes msg using m, and writes it to w as a packet with the given operation
+func WriteMessage[T any](w PacketWriter, m Marshaler, operation uint16, msg *T) error {
+	p := packet.Get()
+	defer packet.Put(p)
+	p.Metadata.Operation = operation
+	err := Encode(m, p, msg)
+	if err != nil {
+		return err
+	}
+	return w.WritePacket(p)
+}
+
+// ReadMessage reads the next packet from r and decodes its content into a new message using u. It
+// returns the message along with the operation of the packet.
+func ReadMessage[T any](r PacketReader, u Unmarshaler) (*T, uint16, error) {
+	p, err := r.ReadPacket()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer packet.Put(p)
+	msg, err := Decode[T](u, p)
+	if err != nil {
+		return nil, p.Metadata.Operation, err
+	}
+	return msg, p.Metadata.Operation, nil
+}
@@ -0,0 +1,108 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package codec
+
+import (
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testMessage struct {
+	Name  string `json:"name" msgpack:"name"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+// testConn is an in-memory PacketWriter and PacketReader
+type testConn struct {
+	packets []*packet.Packet
+}
+
+func (c *testConn) WritePacket(p *packet.Packet) error {
+	q := packet.Get()
+	q.Metadata.Id = p.Metadata.Id
+	q.Metadata.Operation = p.Metadata.Operation
+	q.Metadata.ContentLength = p.Metadata.ContentLength
+	q.Content.Write(*p.Content)
+	c.packets = append(c.packets, q)
+	return nil
+}
+
+func (c *testConn) ReadPacket() (*packet.Packet, error) {
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return p, nil
+}
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	for name, c := range map[string]Codec{"json": JSON, "msgpack": Msgpack} {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := new(testConn)
+			err := WriteMessage(conn, c, 32, &testMessage{Name: "frisbee", Count: 3})
+			require.NoError(t, err)
+
+			msg, operation, err := ReadMessage[testMessage](conn, c)
+			require.NoError(t, err)
+			assert.Equal(t, uint16(32), operation)
+			assert.Equal(t, &testMessage{Name: "frisbee", Count: 3}, msg)
+		})
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	t.Parallel()
+
+	p := packet.Get()
+	defer packet.Put(p)
+
+	err := Encode(Protobuf, p, wrapperspb.String("frisbee"))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(len(*p.Content)), p.Metadata.ContentLength)
+
+	msg, err := Decode[wrapperspb.StringValue](Protobuf, p)
+	require.NoError(t, err)
+	assert.Equal(t, "frisbee", msg.GetValue())
+
+	err = Encode(Protobuf, p, &testMessage{})
+	assert.ErrorIs(t, err, InvalidMessageType)
+	_, err = Decode[testMessage](Protobuf, p)
+	assert.ErrorIs(t, err, InvalidMessageType)
+}
+
+func TestDecodeError(t *testing.T) {
+	t.Parallel()
+
+	conn := new(testConn)
+	p := packet.Get()
+	p.Metadata.Operation = 33
+	p.Content.Write([]byte("not json"))
+	p.Metadata.ContentLength = uint32(len("not json"))
+	require.NoError(t, conn.WritePacket(p))
+	packet.Put(p)
+
+	_, operation, err := ReadMessage[testMessage](conn, JSON)
+	assert.Error(t, err)
+	assert.Equal(t, uint16(33), operation)
+}
@@ -0,0 +1,34 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package codec
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Msgpack is a Codec that encodes messages as MessagePack using the github.com/vmihailenco/msgpack package
+var Msgpack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(msg any) ([]byte, error) {
+	return msgpack.Marshal(msg)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, msg any) error {
+	return msgpack.Unmarshal(data, msg)
+}
@@ -0,0 +1,510 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package frame implements the wire framing of frisbee packets: the metadata of every packet, the optional extended
+// header that follows it, the content of the packet, and the optional signature that follows the content. It only
+// depends on the metadata package, so that tools which need to parse or produce frisbee traffic (like log processors,
+// packet dissectors, and bindings for other languages) can do so without importing the connection stack.
+//
+// Which parts of a frame are present depends on the features that were negotiated for the connection, which is
+// described using a Format.
+package frame
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/pkg/errors"
+)
+
+var (
+	InvalidExtension = errors.New("invalid extended header in frame")
+	InvalidFrame     = errors.New("invalid frame")
+)
+
+// These are the types of the extensions that are defined by frisbee. Extensions with other types are carried
+// through the frame unchanged, and are skipped by frisbee connections.
+const (
+	// ExtensionInline carries the entire content of a small packet, in which case the ContentLength
+	// in the metadata of the frame is 0 and no content follows the extended header
+	ExtensionInline = uint8(iota + 1)
+
+	// ExtensionSequence carries the sequence number of the packet as a big-endian uint64
+	ExtensionSequence
+
+	// ExtensionDelivery carries the delivery ID of a packet that must be acknowledged by the receiver
+	// as a big-endian uint64
+	ExtensionDelivery
+
+	// ExtensionContentType carries the ContentType of the content of the packet as a single byte
+	ExtensionContentType
+
+	// ExtensionContentLength carries the length of the content of a packet that does not fit in a uint32 as a
+	// big-endian uint64, in which case the ContentLength in the metadata of the frame is metadata.LargeContentLength
+	ExtensionContentLength
+)
+
+// ContentType is a hint that describes how the content of a packet is encoded, so that middleware, gateways, and
+// tools that inspect frisbee traffic can interpret the content of packets without knowing the protocol that
+// is spoken on top of frisbee. Content types from ContentTypeApplication onwards are free to be used by applications.
+type ContentType uint8
+
+const (
+	// ContentTypeUnspecified is the ContentType of packets that do not carry a content type hint
+	ContentTypeUnspecified = ContentType(iota)
+
+	// ContentTypeBinary is opaque binary content
+	ContentTypeBinary
+
+	// ContentTypeText is UTF-8 encoded text
+	ContentTypeText
+
+	// ContentTypeJSON is JSON encoded content
+	ContentTypeJSON
+
+	// ContentTypeProtobuf is content encoded with the protocol buffers wire format
+	ContentTypeProtobuf
+
+	// ContentTypePolyglot is content encoded with polyglot
+	ContentTypePolyglot
+
+	// ContentTypeMsgPack is MessagePack encoded content
+	ContentTypeMsgPack
+
+	// ContentTypeCBOR is CBOR encoded content
+	ContentTypeCBOR
+
+	// ContentTypeGzip is content that was compressed with gzip by the application
+	ContentTypeGzip
+
+	// ContentTypeDeflate is content that was compressed with deflate by the application
+	ContentTypeDeflate
+)
+
+// ContentTypeApplication is the first ContentType that is reserved for applications
+const ContentTypeApplication = ContentType(0x80)
+
+var contentTypeNames = [...]string{
+	ContentTypeUnspecified: "unspecified",
+	ContentTypeBinary:      "binary",
+	ContentTypeText:        "text",
+	ContentTypeJSON:        "json",
+	ContentTypeProtobuf:    "protobuf",
+	ContentTypePolyglot:    "polyglot",
+	ContentTypeMsgPack:     "msgpack",
+	ContentTypeCBOR:        "cbor",
+	ContentTypeGzip:        "gzip",
+	ContentTypeDeflate:     "deflate",
+}
+
+// String returns the name of the ContentType
+func (t ContentType) String() string {
+	if int(t) < len(contentTypeNames) {
+		return contentTypeNames[t]
+	}
+	if t >= ContentTypeApplication {
+		return "application-" + strconv.Itoa(int(t-ContentTypeApplication))
+	}
+	return "unknown-" + strconv.Itoa(int(t))
+}
+
+const (
+	// MaxExtensionsSize is the maximum size of the extensions in an extended header
+	MaxExtensionsSize = math.MaxUint8
+
+	// MaxHeaderSize is the maximum size of the metadata of a frame along with its extended header
+	MaxHeaderSize = metadata.Size + 1 + MaxExtensionsSize
+
+	// MaxCompactMetadataSize is the maximum size of the metadata of a frame in the Compact format
+	MaxCompactMetadataSize = binary.MaxVarintLen16*2 + binary.MaxVarintLen32
+
+	// MaxCompactHeaderSize is the maximum size of the metadata of a frame in the Compact format along with its extended header
+	MaxCompactHeaderSize = MaxCompactMetadataSize + 1 + MaxExtensionsSize
+
+	// SignatureSize is the size of the signature that follows the content of signed frames
+	SignatureSize = 32
+)
+
+// Format describes which optional parts are present in the frames of a connection
+type Format struct {
+	// Extended is true if every frame carries an extended header (when FeatureExtendedHeaders or
+	// FeatureSequenceNumbers have been negotiated)
+	Extended bool
+
+	// Signed is true if every frame is followed by a signature (when FeatureSigning has been negotiated)
+	Signed bool
+
+	// Compact is true if the Id, Operation, and ContentLength in the metadata of every frame are encoded as
+	// unsigned varints instead of fixed size integers (when FeatureCompactMetadata has been negotiated)
+	Compact bool
+}
+
+// compactFieldSizes are the maximum sizes of the Id, Operation, and ContentLength in the Compact format
+var compactFieldSizes = [3]int{binary.MaxVarintLen16, binary.MaxVarintLen16, binary.MaxVarintLen32}
+
+// AppendCompactMetadata appends the metadata m to b in the Compact format
+func AppendCompactMetadata(b []byte, m metadata.Metadata) []byte {
+	var encoded [MaxCompactMetadataSize]byte
+	n := binary.PutUvarint(encoded[:], uint64(m.Id))
+	n += binary.PutUvarint(encoded[n:], uint64(m.Operation))
+	n += binary.PutUvarint(encoded[n:], uint64(m.ContentLength))
+	return append(b, encoded[:n]...)
+}
+
+// DecodeCompactMetadata decodes the metadata in the Compact format at the start of b, and returns it along with the
+// number of bytes that it took up. If b does not hold the entire metadata, io.ErrUnexpectedEOF is returned.
+func DecodeCompactMetadata(b []byte) (metadata.Metadata, int, error) {
+	var values [3]uint64
+	n := 0
+	for i, maxSize := range compactFieldSizes {
+		value, size := binary.Uvarint(b[n:])
+		if size == 0 && len(b)-n < maxSize {
+			return metadata.Metadata{}, 0, io.ErrUnexpectedEOF
+		}
+		if size <= 0 || size > maxSize {
+			return metadata.Metadata{}, 0, InvalidFrame
+		}
+		values[i] = value
+		n += size
+	}
+	if values[0] > math.MaxUint16 || values[1] > math.MaxUint16 || values[2] > math.MaxUint32 {
+		return metadata.Metadata{}, 0, InvalidFrame
+	}
+	return metadata.Metadata{Id: uint16(values[0]), Operation: uint16(values[1]), ContentLength: uint32(values[2])}, n, nil
+}
+
+// decodeMetadata decodes the metadata at the start of b in the given format, and returns it along with the number
+// of bytes that it took up
+func decodeMetadata(b []byte, format Format) (metadata.Metadata, int, error) {
+	if format.Compact {
+		return DecodeCompactMetadata(b)
+	}
+	if len(b) < metadata.Size {
+		return metadata.Metadata{}, 0, io.ErrUnexpectedEOF
+	}
+	return metadata.Metadata{
+		Id:            binary.BigEndian.Uint16(b[metadata.IdOffset:]),
+		Operation:     binary.BigEndian.Uint16(b[metadata.OperationOffset:]),
+		ContentLength: binary.BigEndian.Uint32(b[metadata.ContentLengthOffset:]),
+	}, metadata.Size, nil
+}
+
+// Extension is a single TLV extension in the extended header of a frame
+type Extension struct {
+	Type  uint8
+	Value []byte
+}
+
+// Frame is a single frisbee packet as it appears on the wire
+type Frame struct {
+	// Metadata is the metadata of the frame as it appears on the wire, so its ContentLength is the
+	// size of the content that follows the header (which is 0 for inlined content)
+	Metadata metadata.Metadata
+
+	// Extensions are the extensions in the extended header of the frame
+	Extensions []Extension
+
+	// Content is the content of the frame that follows the header
+	Content []byte
+
+	// Signature is the signature that follows the content of the frame
+	Signature []byte
+}
+
+// Extension returns the value of the first extension of the frame with the given type
+func (f *Frame) Extension(t uint8) ([]byte, bool) {
+	for _, e := range f.Extensions {
+		if e.Type == t {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Payload returns the content of the packet carried by the frame, which is either the
+// value of its inline extension or the content that follows the header
+func (f *Frame) Payload() []byte {
+	if inline, ok := f.Extension(ExtensionInline); ok {
+		return inline
+	}
+	return f.Content
+}
+
+// Sequence returns the sequence number of the frame, if it carries one
+func (f *Frame) Sequence() (uint64, bool) {
+	value, ok := f.Extension(ExtensionSequence)
+	if !ok || len(value) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(value), true
+}
+
+// Delivery returns the delivery ID of the frame, if it carries one
+func (f *Frame) Delivery() (uint64, bool) {
+	value, ok := f.Extension(ExtensionDelivery)
+	if !ok || len(value) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(value), true
+}
+
+// Length returns the length of the content of the frame, which is carried in its content length extension
+// for frames with content that does not fit in a uint32
+func (f *Frame) Length() uint64 {
+	if f.Metadata.ContentLength == metadata.LargeContentLength {
+		if value, ok := f.Extension(ExtensionContentLength); ok && len(value) == 8 {
+			return binary.BigEndian.Uint64(value)
+		}
+	}
+	return uint64(f.Metadata.ContentLength)
+}
+
+// ContentType returns the ContentType of the content of the frame, which is ContentTypeUnspecified
+// if it does not carry one
+func (f *Frame) ContentType() ContentType {
+	value, ok := f.Extension(ExtensionContentType)
+	if !ok || len(value) != 1 {
+		return ContentTypeUnspecified
+	}
+	return ContentType(value[0])
+}
+
+// AppendExtensions appends an extended header holding the given extensions to b
+func AppendExtensions(b []byte, extensions ...Extension) ([]byte, error) {
+	size := 0
+	for _, e := range extensions {
+		if len(e.Value) > math.MaxUint8 {
+			return b, InvalidExtension
+		}
+		size += 2 + len(e.Value)
+	}
+	if size > MaxExtensionsSize {
+		return b, InvalidExtension
+	}
+	b = append(b, uint8(size))
+	for _, e := range extensions {
+		b = append(b, e.Type, uint8(len(e.Value)))
+		b = append(b, e.Value...)
+	}
+	return b, nil
+}
+
+// NextExtension decodes the first extension in the given extensions (which must not include the size of
+// the extended header), and returns it along with the remaining extensions. The value of the returned
+// extension refers to the given slice.
+func NextExtension(extensions []byte) (Extension, []byte, error) {
+	if len(extensions) < 2 || len(extensions) < 2+int(extensions[1]) {
+		return Extension{}, nil, InvalidExtension
+	}
+	value := extensions[2 : 2+int(extensions[1])]
+	return Extension{Type: extensions[0], Value: value}, extensions[2+len(value):], nil
+}
+
+// Append appends the encoded frame to b. The ContentLength in the metadata of the frame is ignored,
+// and the length of its Content is used instead. Frames with content that does not fit in a uint32
+// also carry a content length extension, so they can only be encoded in the Extended format.
+func (f *Frame) Append(b []byte, format Format) ([]byte, error) {
+	extensions := f.Extensions
+	contentLength := uint32(len(f.Content))
+	if uint64(len(f.Content)) >= uint64(metadata.LargeContentLength) {
+		if !format.Extended {
+			return b, InvalidFrame
+		}
+		var value [8]byte
+		binary.BigEndian.PutUint64(value[:], uint64(len(f.Content)))
+		extensions = append([]Extension{{Type: ExtensionContentLength, Value: value[:]}}, extensions...)
+		contentLength = metadata.LargeContentLength
+	}
+	start := len(b)
+	if format.Compact {
+		b = AppendCompactMetadata(b, metadata.Metadata{Id: f.Metadata.Id, Operation: f.Metadata.Operation, ContentLength: contentLength})
+	} else {
+		b = append(b, make([]byte, metadata.Size)...)
+		binary.BigEndian.PutUint16(b[start+metadata.IdOffset:], f.Metadata.Id)
+		binary.BigEndian.PutUint16(b[start+metadata.OperationOffset:], f.Metadata.Operation)
+		binary.BigEndian.PutUint32(b[start+metadata.ContentLengthOffset:], contentLength)
+	}
+	if format.Extended {
+		var err error
+		b, err = AppendExtensions(b, extensions...)
+		if err != nil {
+			return b[:start], err
+		}
+	} else if len(f.Extensions) > 0 {
+		return b[:start], InvalidExtension
+	}
+	b = append(b, f.Content...)
+	if format.Signed {
+		if len(f.Signature) != SignatureSize {
+			return b[:start], InvalidFrame
+		}
+		b = append(b, f.Signature...)
+	}
+	return b, nil
+}
+
+// Decode decodes the frame at the start of b, and returns it along with the number of bytes that it took up.
+// If b does not hold an entire frame, io.ErrUnexpectedEOF is returned. The extensions, content, and
+// signature of the returned frame refer to b.
+func Decode(b []byte, format Format) (*Frame, int, error) {
+	m, n, err := decodeMetadata(b, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	f := &Frame{Metadata: m}
+	if format.Extended {
+		if len(b) < n+1 || len(b) < n+1+int(b[n]) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		extensions := b[n+1 : n+1+int(b[n])]
+		n += 1 + len(extensions)
+		for len(extensions) > 0 {
+			var e Extension
+			var err error
+			e, extensions, err = NextExtension(extensions)
+			if err != nil {
+				return nil, 0, err
+			}
+			f.Extensions = append(f.Extensions, e)
+		}
+	}
+	contentLength := f.Length()
+	if uint64(len(b)-n) < contentLength {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	f.Content = b[n : n+int(contentLength)]
+	n += len(f.Content)
+	if format.Signed {
+		if len(b) < n+SignatureSize {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		f.Signature = b[n : n+SignatureSize]
+		n += SignatureSize
+	}
+	return f, n, nil
+}
+
+// Decoder decodes frames from an io.Reader
+type Decoder struct {
+	// MaxContentLength is the largest content length that will be accepted (0 means no limit). Frames with
+	// larger content return InvalidFrame, since the content of every frame is read into memory.
+	MaxContentLength uint32
+
+	r      io.Reader
+	format Format
+	header [MaxCompactHeaderSize]byte
+}
+
+// NewDecoder returns a Decoder that reads frames in the given format from r
+func NewDecoder(r io.Reader, format Format) *Decoder {
+	return &Decoder{r: r, format: format}
+}
+
+// Decode reads the next frame. It returns io.EOF if r ends before the frame starts, and
+// io.ErrUnexpectedEOF if r ends in the middle of the frame.
+func (d *Decoder) Decode() (*Frame, error) {
+	m, n, err := d.decodeMetadata()
+	if err != nil {
+		return nil, err
+	}
+	metadataSize := n
+	if d.format.Extended {
+		if err = d.read(d.header[n : n+1]); err != nil {
+			return nil, err
+		}
+		size := int(d.header[n])
+		if err = d.read(d.header[n+1 : n+1+size]); err != nil {
+			return nil, err
+		}
+		n += 1 + size
+	}
+	contentLength := uint64(m.ContentLength)
+	if contentLength == uint64(metadata.LargeContentLength) && d.format.Extended {
+		contentLength = largeContentLength(d.header[metadataSize+1:n], contentLength)
+	}
+	if (d.MaxContentLength > 0 && contentLength > uint64(d.MaxContentLength)) || contentLength > math.MaxInt-MaxHeaderSize-SignatureSize {
+		return nil, InvalidFrame
+	}
+	b := make([]byte, n, n+int(contentLength)+SignatureSize)
+	copy(b, d.header[:n])
+	b = b[:n+int(contentLength)]
+	if err = d.read(b[n:]); err != nil {
+		return nil, err
+	}
+	if d.format.Signed {
+		b = b[:len(b)+SignatureSize]
+		if err = d.read(b[len(b)-SignatureSize:]); err != nil {
+			return nil, err
+		}
+	}
+	f, _, err := Decode(b, d.format)
+	return f, err
+}
+
+// decodeMetadata reads the metadata of the next frame into the header of the Decoder, and returns it along with
+// its size. The metadata of frames in the Compact format is read one byte at a time, since its size is not known upfront.
+func (d *Decoder) decodeMetadata() (metadata.Metadata, int, error) {
+	if !d.format.Compact {
+		_, err := io.ReadFull(d.r, d.header[:metadata.Size])
+		if err != nil {
+			return metadata.Metadata{}, 0, err
+		}
+		return decodeMetadata(d.header[:metadata.Size], d.format)
+	}
+	for n := 0; n < MaxCompactMetadataSize; n++ {
+		var err error
+		if n == 0 {
+			_, err = io.ReadFull(d.r, d.header[:1])
+		} else {
+			err = d.read(d.header[n : n+1])
+		}
+		if err != nil {
+			return metadata.Metadata{}, 0, err
+		}
+		m, size, err := DecodeCompactMetadata(d.header[:n+1])
+		if err != io.ErrUnexpectedEOF {
+			return m, size, err
+		}
+	}
+	return metadata.Metadata{}, 0, InvalidFrame
+}
+
+// largeContentLength returns the length in the content length extension of the given extensions, or
+// contentLength if they do not carry one
+func largeContentLength(extensions []byte, contentLength uint64) uint64 {
+	for len(extensions) > 0 {
+		e, rest, err := NextExtension(extensions)
+		if err != nil {
+			break
+		}
+		if e.Type == ExtensionContentLength && len(e.Value) == 8 {
+			return binary.BigEndian.Uint64(e.Value)
+		}
+		extensions = rest
+	}
+	return contentLength
+}
+
+func (d *Decoder) read(b []byte) error {
+	_, err := io.ReadFull(d.r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
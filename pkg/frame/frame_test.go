@@ -0,0 +1,233 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	signature := bytes.Repeat([]byte{0xAB}, SignatureSize)
+	frames := []*Frame{
+		{Metadata: metadata.Metadata{Id: 1, Operation: 10}, Content: []byte("content")},
+		{Metadata: metadata.Metadata{Id: 2, Operation: 11}},
+		{
+			Metadata: metadata.Metadata{Id: 3, Operation: 12},
+			Extensions: []Extension{
+				{Type: ExtensionSequence, Value: []byte{0, 0, 0, 0, 0, 0, 0, 9}},
+				{Type: ExtensionInline, Value: []byte("inline")},
+				{Type: 0xFF, Value: []byte{}},
+			},
+		},
+	}
+
+	for name, format := range map[string]Format{"plain": {}, "extended": {Extended: true}, "signed": {Extended: true, Signed: true}} {
+		format := format
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var encoded []byte
+			var err error
+			for _, f := range frames {
+				if !format.Extended && len(f.Extensions) > 0 {
+					_, err = f.Append(nil, format)
+					assert.ErrorIs(t, err, InvalidExtension)
+					continue
+				}
+				if format.Signed {
+					f := *f
+					f.Signature = signature
+					encoded, err = f.Append(encoded, format)
+				} else {
+					encoded, err = f.Append(encoded, format)
+				}
+				require.NoError(t, err)
+			}
+
+			decoder := NewDecoder(bytes.NewReader(encoded), format)
+			for _, expected := range frames {
+				if !format.Extended && len(expected.Extensions) > 0 {
+					continue
+				}
+				f, n, err := Decode(encoded, format)
+				require.NoError(t, err)
+				encoded = encoded[n:]
+
+				streamed, err := decoder.Decode()
+				require.NoError(t, err)
+
+				for _, f := range []*Frame{f, streamed} {
+					assert.Equal(t, expected.Metadata.Id, f.Metadata.Id)
+					assert.Equal(t, expected.Metadata.Operation, f.Metadata.Operation)
+					assert.Equal(t, uint32(len(expected.Content)), f.Metadata.ContentLength)
+					assert.Equal(t, len(expected.Extensions), len(f.Extensions))
+					assert.Equal(t, string(expected.Payload()), string(f.Payload()))
+					if format.Signed {
+						assert.Equal(t, signature, f.Signature)
+					}
+				}
+			}
+			assert.Empty(t, encoded)
+			_, err = decoder.Decode()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+
+	sequence, ok := frames[2].Sequence()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(9), sequence)
+	_, ok = frames[0].Sequence()
+	assert.False(t, ok)
+}
+
+func TestFrameContentType(t *testing.T) {
+	t.Parallel()
+
+	f := &Frame{Metadata: metadata.Metadata{Operation: 10}, Content: []byte(`{}`), Extensions: []Extension{{Type: ExtensionContentType, Value: []byte{uint8(ContentTypeJSON)}}}}
+	encoded, err := f.Append(nil, Format{Extended: true})
+	require.NoError(t, err)
+	decoded, _, err := Decode(encoded, Format{Extended: true})
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypeJSON, decoded.ContentType())
+	assert.Equal(t, "json", decoded.ContentType().String())
+
+	assert.Equal(t, ContentTypeUnspecified, (&Frame{}).ContentType())
+	assert.Equal(t, "application-1", (ContentTypeApplication + 1).String())
+	assert.Equal(t, "unknown-100", ContentType(100).String())
+}
+
+func TestFrameLargeContent(t *testing.T) {
+	t.Parallel()
+
+	encoded := make([]byte, metadata.Size)
+	binary.BigEndian.PutUint16(encoded[metadata.OperationOffset:], 10)
+	binary.BigEndian.PutUint32(encoded[metadata.ContentLengthOffset:], metadata.LargeContentLength)
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], 7)
+	encoded, err := AppendExtensions(encoded, Extension{Type: ExtensionContentLength, Value: length[:]})
+	require.NoError(t, err)
+	encoded = append(encoded, "content"...)
+
+	f, n, err := Decode(encoded, Format{Extended: true})
+	require.NoError(t, err)
+	assert.Equal(t, len(encoded), n)
+	assert.Equal(t, uint64(7), f.Length())
+	assert.Equal(t, []byte("content"), f.Payload())
+
+	f, err = NewDecoder(bytes.NewReader(encoded), Format{Extended: true}).Decode()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), f.Length())
+	assert.Equal(t, []byte("content"), f.Payload())
+
+	assert.Equal(t, uint64(0), (&Frame{}).Length())
+}
+
+func TestFrameCompact(t *testing.T) {
+	t.Parallel()
+
+	format := Format{Compact: true, Extended: true}
+	frames := []*Frame{
+		{Metadata: metadata.Metadata{Id: 1, Operation: 10}, Content: []byte("content")},
+		{Metadata: metadata.Metadata{Id: math.MaxUint16, Operation: math.MaxUint16}, Extensions: []Extension{{Type: ExtensionContentType, Value: []byte{uint8(ContentTypeText)}}}},
+	}
+	var encoded []byte
+	for _, f := range frames {
+		var err error
+		encoded, err = f.Append(encoded, format)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3+1+len("content")+3+3+1+1+3, len(encoded))
+
+	decoder := NewDecoder(bytes.NewReader(encoded), format)
+	for _, expected := range frames {
+		f, n, err := Decode(encoded, format)
+		require.NoError(t, err)
+		encoded = encoded[n:]
+
+		streamed, err := decoder.Decode()
+		require.NoError(t, err)
+
+		for _, f := range []*Frame{f, streamed} {
+			assert.Equal(t, expected.Metadata.Id, f.Metadata.Id)
+			assert.Equal(t, expected.Metadata.Operation, f.Metadata.Operation)
+			assert.Equal(t, string(expected.Content), string(f.Payload()))
+			assert.Equal(t, expected.ContentType(), f.ContentType())
+		}
+	}
+	_, err := decoder.Decode()
+	assert.ErrorIs(t, err, io.EOF)
+
+	m := metadata.Metadata{Id: 300, Operation: 20, ContentLength: math.MaxUint32}
+	compact := AppendCompactMetadata(nil, m)
+	assert.Len(t, compact, 2+1+5)
+	for i := 0; i < len(compact); i++ {
+		_, _, err = DecodeCompactMetadata(compact[:i])
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+	decoded, n, err := DecodeCompactMetadata(compact)
+	require.NoError(t, err)
+	assert.Equal(t, m, decoded)
+	assert.Equal(t, len(compact), n)
+
+	_, _, err = DecodeCompactMetadata([]byte{0xFF, 0xFF, 0xFF, 0x7F, 0, 0})
+	assert.ErrorIs(t, err, InvalidFrame)
+	_, _, err = DecodeCompactMetadata([]byte{0xFF, 0xFF, 0x7F, 0, 0})
+	assert.ErrorIs(t, err, InvalidFrame)
+}
+
+func TestFrameInvalid(t *testing.T) {
+	t.Parallel()
+
+	f := &Frame{Metadata: metadata.Metadata{Operation: 10}, Content: []byte("content")}
+	encoded, err := f.Append(nil, Format{Extended: true})
+	require.NoError(t, err)
+
+	for i := 0; i < len(encoded); i++ {
+		_, _, err = Decode(encoded[:i], Format{Extended: true})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		_, err = NewDecoder(bytes.NewReader(encoded[:i]), Format{Extended: true}).Decode()
+		if i == 0 {
+			assert.ErrorIs(t, err, io.EOF)
+		} else {
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	decoder := NewDecoder(bytes.NewReader(encoded), Format{Extended: true})
+	decoder.MaxContentLength = 4
+	_, err = decoder.Decode()
+	assert.ErrorIs(t, err, InvalidFrame)
+
+	_, _, err = NextExtension([]byte{ExtensionInline, 4, 1})
+	assert.ErrorIs(t, err, InvalidExtension)
+
+	_, err = AppendExtensions(nil, Extension{Type: ExtensionInline, Value: make([]byte, MaxExtensionsSize)})
+	assert.ErrorIs(t, err, InvalidExtension)
+
+	_, err = f.Append(nil, Format{Signed: true})
+	assert.ErrorIs(t, err, InvalidFrame)
+}
@@ -0,0 +1,163 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbeetest
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+// InjectedFault is returned by a FaultConn when a write fails because a fault was injected
+var InjectedFault = errors.New("injected fault")
+
+// Faults configures the faults that are injected into the writes of a FaultConn. Each rate is the
+// probability (between 0 and 1) that the fault is injected into a single write.
+type Faults struct {
+	// CloseRate is the probability that a write abruptly closes the connection instead of writing
+	CloseRate float64
+
+	// DelayRate is the probability that a write is delayed by a random duration of up to MaxDelay
+	DelayRate float64
+	MaxDelay  time.Duration
+
+	// SplitRate is the probability that a write is split into several smaller writes
+	SplitRate float64
+}
+
+// Enabled returns whether any faults will be injected
+func (f Faults) Enabled() bool {
+	return f.CloseRate > 0 || (f.DelayRate > 0 && f.MaxDelay > 0) || f.SplitRate > 0
+}
+
+// FaultConn is a net.Conn that injects faults into the writes of the wrapped connection
+type FaultConn struct {
+	net.Conn
+	faults   Faults
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected *atomic.Uint64
+}
+
+// NewFaultConn returns a FaultConn that injects the given faults into the writes of conn, using the given seed to
+// decide which writes are faulted
+func NewFaultConn(conn net.Conn, faults Faults, seed int64) *FaultConn {
+	return newFaultConn(conn, faults, seed, atomic.NewUint64(0))
+}
+
+func newFaultConn(conn net.Conn, faults Faults, seed int64, injected *atomic.Uint64) *FaultConn {
+	return &FaultConn{
+		Conn:     conn,
+		faults:   faults,
+		rand:     rand.New(rand.NewSource(seed)),
+		injected: injected,
+	}
+}
+
+// Injected returns the number of faults that have been injected
+func (c *FaultConn) Injected() uint64 {
+	return c.injected.Load()
+}
+
+// Write writes b to the wrapped connection, possibly injecting a fault
+func (c *FaultConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	closeConn := c.rand.Float64() < c.faults.CloseRate
+	var delay time.Duration
+	if c.faults.MaxDelay > 0 && c.rand.Float64() < c.faults.DelayRate {
+		delay = time.Duration(c.rand.Int63n(int64(c.faults.MaxDelay)))
+	}
+	var splits []int
+	if len(b) > 1 && c.rand.Float64() < c.faults.SplitRate {
+		for remaining := len(b); remaining > 0; {
+			size := 1 + c.rand.Intn(remaining)
+			splits = append(splits, size)
+			remaining -= size
+		}
+	}
+	c.mu.Unlock()
+
+	if closeConn {
+		c.injected.Inc()
+		_ = c.Conn.Close()
+		return 0, InjectedFault
+	}
+
+	if delay > 0 {
+		c.injected.Inc()
+		time.Sleep(delay)
+	}
+
+	if len(splits) == 0 {
+		return c.Conn.Write(b)
+	}
+
+	c.injected.Inc()
+	var n int
+	for _, size := range splits {
+		nn, err := c.Conn.Write(b[n : n+size])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// FaultListener is a net.Listener that wraps every accepted connection in a FaultConn
+type FaultListener struct {
+	net.Listener
+	faults   Faults
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected *atomic.Uint64
+}
+
+// NewFaultListener returns a FaultListener that injects the given faults into every connection accepted by listener
+func NewFaultListener(listener net.Listener, faults Faults, seed int64) *FaultListener {
+	return newFaultListener(listener, faults, seed, atomic.NewUint64(0))
+}
+
+func newFaultListener(listener net.Listener, faults Faults, seed int64, injected *atomic.Uint64) *FaultListener {
+	return &FaultListener{
+		Listener: listener,
+		faults:   faults,
+		rand:     rand.New(rand.NewSource(seed)),
+		injected: injected,
+	}
+}
+
+// Injected returns the number of faults that have been injected into the accepted connections
+func (l *FaultListener) Injected() uint64 {
+	return l.injected.Load()
+}
+
+// Accept waits for the next connection and wraps it in a FaultConn
+func (l *FaultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	seed := l.rand.Int63()
+	l.mu.Unlock()
+	return newFaultConn(conn, l.faults, seed, l.injected), nil
+}
@@ -0,0 +1,93 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbeetest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultConnSplit(t *testing.T) {
+	t.Parallel()
+
+	writer, reader := net.Pipe()
+	c := NewFaultConn(writer, Faults{SplitRate: 1, DelayRate: 1, MaxDelay: time.Millisecond}, 1)
+	assert.True(t, Faults{SplitRate: 1}.Enabled())
+	assert.False(t, Faults{DelayRate: 1}.Enabled())
+
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	go func() {
+		n, err := c.Write(data)
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), n)
+	}()
+
+	received := make([]byte, len(data))
+	_, err := io.ReadFull(reader, received)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+	assert.Equal(t, uint64(2), c.Injected())
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, reader.Close())
+}
+
+func TestFaultConnClose(t *testing.T) {
+	t.Parallel()
+
+	writer, reader := net.Pipe()
+	c := NewFaultConn(writer, Faults{CloseRate: 1}, 1)
+
+	_, err := c.Write([]byte("data"))
+	assert.ErrorIs(t, err, InjectedFault)
+	assert.Equal(t, uint64(1), c.Injected())
+
+	_, err = reader.Read(make([]byte, 4))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, reader.Close())
+}
+
+func TestFaultListener(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := NewFaultListener(listener, Faults{CloseRate: 1}, 1)
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	require.IsType(t, &FaultConn{}, conn)
+
+	_, err = conn.Write([]byte("data"))
+	assert.ErrorIs(t, err, InjectedFault)
+	assert.Equal(t, uint64(1), l.Injected())
+
+	assert.NoError(t, client.Close())
+	assert.NoError(t, l.Close())
+}
@@ -0,0 +1,372 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package frisbeetest provides tools for testing frisbee and the applications that are built on it.
+//
+// Soak runs a long-running soak test against a frisbee server, which continuously connects and disconnects
+// clients, sends packets with random sizes, injects faults into the connections (using FaultConn), and checks for
+// corrupted packets, memory growth and leaked goroutines. It can be run for minutes or hours (for example in CI) to
+// catch leaks and rare races in both frisbee and the handlers of an application.
+package frisbeetest
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+// EchoOperation is the operation used by Soak for the packets that are echoed back by the server
+const EchoOperation = uint16(10)
+
+// checksumSize is the size of the checksum at the start of the content of every echoed packet
+const checksumSize = 4
+
+const (
+	// DefaultClients is the default number of concurrent clients used by Soak
+	DefaultClients = 8
+
+	// DefaultMaxPacketSize is the default maximum content size of the packets sent by Soak
+	DefaultMaxPacketSize = 1 << 16
+
+	// DefaultMaxPacketsPerConnection is the default maximum number of packets sent by a client before it disconnects
+	DefaultMaxPacketsPerConnection = 256
+
+	// DefaultLeakTimeout is the default amount of time Soak waits for goroutines to exit after it has finished
+	DefaultLeakTimeout = time.Second * 5
+
+	// memoryInterval is how often Soak samples the memory usage of the process
+	memoryInterval = time.Millisecond * 100
+)
+
+// These are the errors that can be returned by Soak:
+var (
+	CorruptPacket         = errors.New("corrupt packet received")
+	PacketLoss            = errors.New("packets were lost without any faults being injected")
+	MemoryCeilingExceeded = errors.New("memory ceiling exceeded")
+	GoroutineLeak         = errors.New("goroutines leaked")
+)
+
+// Workload is called by Soak for every connected client after the echo traffic has been sent, and can be used to
+// exercise the handlers of an application. Errors caused by closed connections are expected (since faults are
+// injected), and any other error fails the soak test.
+type Workload func(ctx context.Context, r *rand.Rand, c *frisbee.Client) error
+
+// SoakConfig configures a soak test. Zero values are replaced with their defaults.
+type SoakConfig struct {
+	// Duration is how long the soak test runs for (it also stops when the context given to Soak is cancelled)
+	Duration time.Duration
+
+	// Clients is the number of clients that are connected concurrently
+	Clients int
+
+	// MaxPacketSize is the maximum size of the content of the echoed packets
+	MaxPacketSize int
+
+	// MaxPacketsPerConnection is the maximum number of packets a client sends before disconnecting (the actual
+	// number is random for every connection)
+	MaxPacketsPerConnection int
+
+	// Faults are the faults that are injected into the connections of both the clients and the server
+	Faults Faults
+
+	// MemoryCeiling is the maximum number of bytes of in-use heap memory (use 0 to disable)
+	MemoryCeiling uint64
+
+	// LeakTimeout is how long Soak waits for goroutines to exit before reporting a GoroutineLeak
+	LeakTimeout time.Duration
+
+	// Seed is the seed used for all randomness, and is chosen randomly when it is 0
+	Seed int64
+
+	// Options are the options used for both the server and the clients
+	Options []frisbee.Option
+
+	// Handlers are additional handlers for the server, which must not use the EchoOperation
+	Handlers frisbee.HandlerTable
+
+	// Workload is called for every connected client (optional)
+	Workload Workload
+}
+
+// SoakReport contains the results of a soak test
+type SoakReport struct {
+	Seed          int64
+	Connections   uint64
+	PacketsSent   uint64
+	PacketsEchoed uint64
+	Faults        uint64
+	PeakHeap      uint64
+	Goroutines    int
+}
+
+// soak holds the state of a running soak test
+type soak struct {
+	config   SoakConfig
+	addr     string
+	injected *atomic.Uint64
+	cancel   context.CancelFunc
+
+	connections   *atomic.Uint64
+	packetsSent   *atomic.Uint64
+	packetsEchoed *atomic.Uint64
+
+	errMu sync.Mutex
+	err   error
+}
+
+// Soak runs a soak test with the given configuration, and returns a report of the test along with the
+// first error that was found. It should not be run in parallel with other tests, since any goroutines they start
+// may be reported as leaked.
+func Soak(ctx context.Context, config SoakConfig) (*SoakReport, error) {
+	if config.Clients <= 0 {
+		config.Clients = DefaultClients
+	}
+	if config.MaxPacketSize < checksumSize {
+		config.MaxPacketSize = DefaultMaxPacketSize
+	}
+	if config.MaxPacketsPerConnection <= 0 {
+		config.MaxPacketsPerConnection = DefaultMaxPacketsPerConnection
+	}
+	if config.LeakTimeout <= 0 {
+		config.LeakTimeout = DefaultLeakTimeout
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	report := &SoakReport{Seed: config.Seed}
+	goroutines := runtime.NumGoroutine()
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &soak{
+		config:        config,
+		injected:      atomic.NewUint64(0),
+		cancel:        cancel,
+		connections:   atomic.NewUint64(0),
+		packetsSent:   atomic.NewUint64(0),
+		packetsEchoed: atomic.NewUint64(0),
+	}
+
+	handlerTable := make(frisbee.HandlerTable)
+	for operation, handler := range config.Handlers {
+		handlerTable[operation] = handler
+	}
+	handlerTable[EchoOperation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		outgoing = incoming
+		return
+	}
+
+	server, err := frisbee.NewServer(handlerTable, config.Options...)
+	if err != nil {
+		return report, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return report, err
+	}
+	s.addr = listener.Addr().String()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.StartWithListener(newFaultListener(listener, config.Faults, config.Seed, s.injected))
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(config.Clients + 1)
+	go func() {
+		defer wg.Done()
+		report.PeakHeap = s.monitor(ctx)
+	}()
+	for i := 0; i < config.Clients; i++ {
+		r := rand.New(rand.NewSource(config.Seed + int64(i) + 1))
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				s.connection(ctx, r)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = server.Shutdown()
+	if err != nil {
+		s.fail(err)
+	}
+	if err = <-serverErr; err != nil {
+		s.fail(err)
+	}
+
+	report.Connections = s.connections.Load()
+	report.PacketsSent = s.packetsSent.Load()
+	report.PacketsEchoed = s.packetsEchoed.Load()
+	report.Faults = s.injected.Load()
+
+	deadline := time.Now().Add(config.LeakTimeout)
+	for report.Goroutines = runtime.NumGoroutine() - goroutines; report.Goroutines > 0 && time.Now().Before(deadline); report.Goroutines = runtime.NumGoroutine() - goroutines {
+		time.Sleep(memoryInterval)
+	}
+	if report.Goroutines < 0 {
+		report.Goroutines = 0
+	}
+	if report.Goroutines > 0 {
+		s.fail(errors.Wrapf(GoroutineLeak, "%d goroutines", report.Goroutines))
+	}
+
+	return report, s.error()
+}
+
+// fail records err (if it is the first error) and stops the soak test
+func (s *soak) fail(err error) {
+	s.errMu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.errMu.Unlock()
+	s.cancel()
+}
+
+func (s *soak) error() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+// monitor samples the in-use heap memory until ctx is cancelled, and returns the peak
+func (s *soak) monitor(ctx context.Context) uint64 {
+	var stats runtime.MemStats
+	var peak uint64
+	ticker := time.NewTicker(memoryInterval)
+	defer ticker.Stop()
+	for {
+		runtime.ReadMemStats(&stats)
+		if stats.HeapInuse > peak {
+			peak = stats.HeapInuse
+		}
+		if s.config.MemoryCeiling > 0 && stats.HeapInuse > s.config.MemoryCeiling {
+			s.fail(errors.Wrapf(MemoryCeilingExceeded, "%d bytes in use", stats.HeapInuse))
+			return peak
+		}
+		select {
+		case <-ctx.Done():
+			return peak
+		case <-ticker.C:
+		}
+	}
+}
+
+// connection connects a single client, sends a random number of echoed packets, runs the Workload, and disconnects
+func (s *soak) connection(ctx context.Context, r *rand.Rand) {
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.connections.Inc()
+
+	echoed := atomic.NewUint64(0)
+	handlerTable := make(frisbee.HandlerTable)
+	handlerTable[EchoOperation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		content := (*incoming.Content)[:incoming.Metadata.ContentLength]
+		if len(content) < checksumSize || binary.BigEndian.Uint32(content) != crc32.ChecksumIEEE(content[checksumSize:]) {
+			s.fail(errors.Wrapf(CorruptPacket, "packet %d", incoming.Metadata.Id))
+			return
+		}
+		echoed.Inc()
+		s.packetsEchoed.Inc()
+		return
+	}
+
+	c, err := frisbee.NewClient(handlerTable, ctx, s.config.Options...)
+	if err != nil {
+		_ = conn.Close()
+		s.fail(err)
+		return
+	}
+	err = c.FromConn(newFaultConn(conn, s.config.Faults, r.Int63(), s.injected))
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	var sent uint64
+	p := packet.Get()
+	content := make([]byte, s.config.MaxPacketSize)
+	packets := 1 + r.Intn(s.config.MaxPacketsPerConnection)
+	for i := 0; i < packets && ctx.Err() == nil; i++ {
+		size := checksumSize + r.Intn(s.config.MaxPacketSize-checksumSize+1)
+		_, _ = r.Read(content[checksumSize:size])
+		binary.BigEndian.PutUint32(content, crc32.ChecksumIEEE(content[checksumSize:size]))
+		p.Reset()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = EchoOperation
+		p.Content.Write(content[:size])
+		p.Metadata.ContentLength = uint32(size)
+		if c.WritePacket(p) != nil {
+			break
+		}
+		sent++
+		s.packetsSent.Inc()
+	}
+	packet.Put(p)
+
+	if s.config.Workload != nil && !c.Closed() {
+		err = s.config.Workload(ctx, r, c)
+		if err != nil && !errors.Is(err, frisbee.ConnectionClosed) && !c.Closed() {
+			s.fail(err)
+		}
+	}
+
+	timeout := time.NewTimer(frisbee.DefaultDeadline)
+	ticker := time.NewTicker(time.Millisecond)
+wait:
+	for echoed.Load() < sent {
+		select {
+		case <-c.CloseChannel():
+			break wait
+		case <-timeout.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+	ticker.Stop()
+	timeout.Stop()
+
+	if echoed.Load() < sent && ctx.Err() == nil && !s.config.Faults.Enabled() {
+		s.fail(errors.Wrapf(PacketLoss, "%d of %d packets echoed", echoed.Load(), sent))
+	}
+
+	_ = c.Close()
+}
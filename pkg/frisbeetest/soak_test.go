@@ -0,0 +1,105 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbeetest
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// soakDuration returns how long the soak tests should run for, which can be set (for example in CI) using
+// the FRISBEE_SOAK_DURATION environment variable
+func soakDuration(t *testing.T) time.Duration {
+	if d, ok := os.LookupEnv("FRISBEE_SOAK_DURATION"); ok {
+		duration, err := time.ParseDuration(d)
+		require.NoError(t, err)
+		return duration
+	}
+	if testing.Short() {
+		t.Skip("skipping soak test in short mode")
+	}
+	return time.Second
+}
+
+func TestSoak(t *testing.T) {
+	duration := soakDuration(t)
+
+	workload := func(_ context.Context, r *rand.Rand, c *frisbee.Client) error {
+		p := packet.Get()
+		defer packet.Put(p)
+		p.Metadata.Operation = EchoOperation + 1
+		p.Metadata.ContentLength = uint32(r.Intn(64))
+		p.Content.Write(make([]byte, p.Metadata.ContentLength))
+		return c.WritePacket(p)
+	}
+
+	handlers := make(frisbee.HandlerTable)
+	handlers[EchoOperation+1] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		return
+	}
+
+	report, err := Soak(context.Background(), SoakConfig{
+		Duration: duration,
+		Faults: Faults{
+			CloseRate: 0.001,
+			DelayRate: 0.01,
+			MaxDelay:  time.Millisecond,
+			SplitRate: 0.05,
+		},
+		MemoryCeiling: 1 << 30,
+		Handlers:      handlers,
+		Workload:      workload,
+	})
+	require.NoError(t, err, "seed %d", report.Seed)
+	t.Logf("%+v", *report)
+	assert.NotZero(t, report.Connections)
+	assert.NotZero(t, report.PacketsEchoed)
+	assert.NotZero(t, report.Faults)
+	assert.Zero(t, report.Goroutines)
+}
+
+func TestSoakNoFaults(t *testing.T) {
+	duration := soakDuration(t)
+
+	report, err := Soak(context.Background(), SoakConfig{
+		Duration:                duration,
+		Clients:                 2,
+		MaxPacketSize:           1024,
+		MaxPacketsPerConnection: 16,
+	})
+	require.NoError(t, err, "seed %d", report.Seed)
+	assert.NotZero(t, report.PacketsEchoed)
+	assert.Zero(t, report.Faults)
+}
+
+func TestSoakMemoryCeiling(t *testing.T) {
+	report, err := Soak(context.Background(), SoakConfig{
+		Duration:      time.Second * 10,
+		Clients:       1,
+		MemoryCeiling: 1,
+	})
+	assert.ErrorIs(t, err, MemoryCeilingExceeded)
+	assert.Zero(t, report.Goroutines)
+}
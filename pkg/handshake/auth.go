@@ -0,0 +1,141 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handshake
+
+import (
+	"io"
+	"net"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/pkg/errors"
+)
+
+// MaxAuthSize is the largest AUTH packet content that will be accepted
+const MaxAuthSize = 1 << 12
+
+// These are the packet IDs of AUTH packets, which are used to tell messages apart from the result of the handshake:
+const (
+	authMessage = uint16(iota)
+	authResult
+)
+
+// These are the possible contents of the result packet of the authentication handshake:
+const (
+	authAccepted = byte(iota)
+	authRejected
+)
+
+// AuthExchange is used by both sides of the authentication handshake to exchange messages with each other.
+// Messages are sent as AUTH packets and can be at most MaxAuthSize bytes in size.
+type AuthExchange interface {
+	// Send sends a message to the peer
+	Send(message []byte) error
+
+	// Receive waits for a message from the peer
+	Receive() ([]byte, error)
+
+	// Conn returns the underlying connection (which can be used to inspect the TLS state of the connection)
+	Conn() net.Conn
+}
+
+// authExchange is the AuthExchange used during the authentication handshake
+type authExchange struct {
+	conn net.Conn
+}
+
+func (a *authExchange) Send(message []byte) error {
+	return writeAuth(a.conn, authMessage, message)
+}
+
+func (a *authExchange) Receive() ([]byte, error) {
+	id, message, err := readAuth(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	if id != authMessage {
+		return nil, AuthenticationFailed
+	}
+	return message, nil
+}
+
+func (a *authExchange) Conn() net.Conn {
+	return a.conn
+}
+
+func writeAuth(conn net.Conn, id uint16, content []byte) error {
+	if len(content) > MaxAuthSize {
+		return MessageTooLarge
+	}
+	encodedMetadata, err := metadata.Encode(id, OperationAuth, uint32(len(content)))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(encodedMetadata[:], content...))
+	return err
+}
+
+func readAuth(conn net.Conn) (uint16, []byte, error) {
+	var encodedMetadata [metadata.Size]byte
+	if _, err := io.ReadFull(conn, encodedMetadata[:]); err != nil {
+		return 0, nil, err
+	}
+	m, err := metadata.Decode(encodedMetadata[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.Operation != OperationAuth || m.ContentLength > MaxAuthSize {
+		return 0, nil, InvalidHandshake
+	}
+	content := make([]byte, m.ContentLength)
+	if _, err = io.ReadFull(conn, content); err != nil {
+		return 0, nil, err
+	}
+	return m.Id, content, nil
+}
+
+// Authenticate performs the client side of the authentication handshake on conn, using authenticate to exchange
+// messages with the server. It returns an error wrapping AuthenticationFailed if the server rejects the client.
+func Authenticate(conn net.Conn, authenticate func(AuthExchange) error) error {
+	err := authenticate(&authExchange{conn: conn})
+	if err != nil {
+		return errors.Wrap(err, AuthenticationFailed.Error())
+	}
+	id, result, err := readAuth(conn)
+	if err != nil {
+		return errors.Wrap(err, AuthenticationFailed.Error())
+	}
+	if id != authResult || len(result) != 1 || result[0] != authAccepted {
+		return AuthenticationFailed
+	}
+	return nil
+}
+
+// Verify performs the server side of the authentication handshake on conn, using verify to exchange messages with
+// the client and decide on its identity. The result of the handshake is sent to the client, and the authenticated
+// identity of the client is returned.
+func Verify(conn net.Conn, verify func(AuthExchange) (identity string, err error)) (string, error) {
+	identity, err := verify(&authExchange{conn: conn})
+	if err != nil {
+		_ = writeAuth(conn, authResult, []byte{authRejected})
+		return "", errors.Wrap(err, AuthenticationFailed.Error())
+	}
+	err = writeAuth(conn, authResult, []byte{authAccepted})
+	if err != nil {
+		return "", errors.Wrap(err, AuthenticationFailed.Error())
+	}
+	return identity, nil
+}
@@ -0,0 +1,177 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package handshake implements the HELLO handshake (which negotiates the protocol version, features, and parameters of a
+// connection) and the authentication handshake that frisbee clients and servers perform before any other packets are
+// exchanged on a connection. Both work over any net.Conn, so that alternative client and server implementations can
+// reuse exactly the same negotiation logic and wire format.
+//
+// The functions in this package do not set any deadlines on the connection, which is the responsibility of the caller.
+package handshake
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/pkg/errors"
+)
+
+var (
+	InvalidHandshake     = errors.New("invalid handshake")
+	AuthenticationFailed = errors.New("authentication failed")
+	MessageTooLarge      = errors.New("handshake message too large")
+)
+
+const (
+	// Version is the current version of the HELLO handshake
+	Version = uint8(1)
+
+	// OperationHello is the reserved operation of HELLO packets (the same as frisbee.HELLO)
+	OperationHello = uint16(3)
+
+	// OperationAuth is the reserved operation of AUTH packets (the same as frisbee.AUTH)
+	OperationAuth = uint16(7)
+
+	// MaxHelloSize is the largest HELLO packet content that will be accepted
+	MaxHelloSize = 1 << 12
+
+	// helloFixedSize is the size of the version and features fields of a HELLO packet
+	helloFixedSize = 1 + 4
+)
+
+// Hello is the content of a HELLO packet, which is encoded as:
+//
+//	version  uint8
+//	features uint32
+//	params   []{key uint8, length uint16, value [length]byte}
+type Hello struct {
+	Version  uint8
+	Features uint32
+	Params   map[uint8][]byte
+}
+
+// Encode returns the encoded content of the HELLO packet
+func (h *Hello) Encode() []byte {
+	size := helloFixedSize
+	for _, v := range h.Params {
+		size += 3 + len(v)
+	}
+	b := make([]byte, helloFixedSize, size)
+	b[0] = h.Version
+	binary.BigEndian.PutUint32(b[1:helloFixedSize], h.Features)
+	for k, v := range h.Params {
+		b = append(b, k, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+// DecodeHello decodes the content of a HELLO packet
+func DecodeHello(b []byte) (*Hello, error) {
+	if len(b) < helloFixedSize {
+		return nil, InvalidHandshake
+	}
+	h := &Hello{
+		Version:  b[0],
+		Features: binary.BigEndian.Uint32(b[1:helloFixedSize]),
+		Params:   make(map[uint8][]byte),
+	}
+	b = b[helloFixedSize:]
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, InvalidHandshake
+		}
+		k, l := b[0], int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+l {
+			return nil, InvalidHandshake
+		}
+		h.Params[k] = b[3 : 3+l]
+		b = b[3+l:]
+	}
+	return h, nil
+}
+
+// WriteHello writes h to conn as a HELLO packet
+func WriteHello(conn net.Conn, h *Hello) error {
+	content := h.Encode()
+	encodedMetadata, err := metadata.Encode(0, OperationHello, uint32(len(content)))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(encodedMetadata[:], content...))
+	return err
+}
+
+// ReadHello reads a HELLO packet from conn
+func ReadHello(conn net.Conn) (*Hello, error) {
+	var encodedMetadata [metadata.Size]byte
+	if _, err := io.ReadFull(conn, encodedMetadata[:]); err != nil {
+		return nil, err
+	}
+	m, err := metadata.Decode(encodedMetadata[:])
+	if err != nil {
+		return nil, err
+	}
+	if m.Operation != OperationHello || m.ContentLength > MaxHelloSize {
+		return nil, InvalidHandshake
+	}
+	content := make([]byte, m.ContentLength)
+	if _, err = io.ReadFull(conn, content); err != nil {
+		return nil, err
+	}
+	return DecodeHello(content)
+}
+
+// Initiate performs the client side of the HELLO handshake on conn, requesting the given features and sending the
+// given parameters. It returns the server's reply, whose features are limited to the requested features.
+func Initiate(conn net.Conn, requested uint32, params map[uint8][]byte) (*Hello, error) {
+	err := WriteHello(conn, &Hello{Version: Version, Features: requested, Params: params})
+	if err != nil {
+		return nil, errors.Wrap(err, InvalidHandshake.Error())
+	}
+	reply, err := ReadHello(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, InvalidHandshake.Error())
+	}
+	if reply.Version == 0 || reply.Version > Version {
+		return nil, InvalidHandshake
+	}
+	reply.Features &= requested
+	return reply, nil
+}
+
+// Accept performs the server side of the HELLO handshake on conn. The features requested by the client are passed to
+// negotiate, which returns the features that should be enabled (any features that were not requested are ignored), and
+// the enabled features are sent back to the client along with the given parameters. It returns the client's request
+// along with the enabled features.
+func Accept(conn net.Conn, negotiate func(requested uint32) uint32, params map[uint8][]byte) (*Hello, uint32, error) {
+	request, err := ReadHello(conn)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, InvalidHandshake.Error())
+	}
+	if request.Version == 0 {
+		return nil, 0, InvalidHandshake
+	}
+	enabled := negotiate(request.Features) & request.Features
+	err = WriteHello(conn, &Hello{Version: Version, Features: enabled, Params: params})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, InvalidHandshake.Error())
+	}
+	return request, enabled, nil
+}
@@ -0,0 +1,165 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handshake
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHelloEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	h := &Hello{
+		Version:  Version,
+		Features: 1<<29 | 1<<31,
+		Params: map[uint8][]byte{
+			1: []byte("param"),
+			2: {},
+		},
+	}
+
+	decoded, err := DecodeHello(h.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, h.Version, decoded.Version)
+	assert.Equal(t, h.Features, decoded.Features)
+	assert.Equal(t, []byte("param"), decoded.Params[1])
+	assert.Equal(t, []byte{}, decoded.Params[2])
+
+	_, err = DecodeHello([]byte{Version})
+	assert.ErrorIs(t, err, InvalidHandshake)
+
+	_, err = DecodeHello(append(h.Encode(), 3, 0, 10))
+	assert.ErrorIs(t, err, InvalidHandshake)
+}
+
+func TestInitiateAccept(t *testing.T) {
+	t.Parallel()
+
+	clientConn, serverConn := net.Pipe()
+
+	type result struct {
+		request *Hello
+		enabled uint32
+		err     error
+	}
+	serverResult := make(chan result, 1)
+	go func() {
+		request, enabled, err := Accept(serverConn, func(requested uint32) uint32 {
+			return requested&^2 | 8
+		}, map[uint8][]byte{1: []byte("server")})
+		serverResult <- result{request, enabled, err}
+	}()
+
+	reply, err := Initiate(clientConn, 1|2|4, map[uint8][]byte{1: []byte("client")})
+	require.NoError(t, err)
+	assert.Equal(t, Version, reply.Version)
+	assert.Equal(t, uint32(1|4), reply.Features)
+	assert.Equal(t, []byte("server"), reply.Params[1])
+
+	r := <-serverResult
+	require.NoError(t, r.err)
+	assert.Equal(t, uint32(1|4), r.enabled)
+	assert.Equal(t, []byte("client"), r.request.Params[1])
+
+	go func() {
+		_, _ = clientConn.Write(make([]byte, 8))
+	}()
+	_, _, err = Accept(serverConn, func(requested uint32) uint32 {
+		return requested
+	}, nil)
+	assert.ErrorIs(t, err, InvalidHandshake)
+
+	assert.NoError(t, clientConn.Close())
+	assert.NoError(t, serverConn.Close())
+}
+
+func TestAuthenticateVerify(t *testing.T) {
+	t.Parallel()
+
+	unknownToken := errors.New("unknown token")
+	verify := func(exchange AuthExchange) (string, error) {
+		token, err := exchange.Receive()
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(token, []byte("token")) {
+			return "", unknownToken
+		}
+		return "device", exchange.Send([]byte("welcome"))
+	}
+
+	for name, token := range map[string][]byte{"accepted": []byte("token"), "rejected": []byte("wrong token")} {
+		token := token
+		accepted := name == "accepted"
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clientConn, serverConn := net.Pipe()
+
+			type result struct {
+				identity string
+				err      error
+			}
+			serverResult := make(chan result, 1)
+			go func() {
+				identity, err := Verify(serverConn, verify)
+				serverResult <- result{identity, err}
+			}()
+
+			err := Authenticate(clientConn, func(exchange AuthExchange) error {
+				if err := exchange.Send(token); err != nil {
+					return err
+				}
+				if !accepted {
+					return nil
+				}
+				message, err := exchange.Receive()
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, []byte("welcome"), message)
+				return nil
+			})
+			r := <-serverResult
+			if accepted {
+				require.NoError(t, err)
+				require.NoError(t, r.err)
+				assert.Equal(t, "device", r.identity)
+			} else {
+				assert.ErrorIs(t, err, AuthenticationFailed)
+				assert.ErrorIs(t, r.err, unknownToken)
+			}
+
+			assert.NoError(t, clientConn.Close())
+			assert.NoError(t, serverConn.Close())
+		})
+	}
+
+	clientConn, serverConn := net.Pipe()
+	err := Authenticate(clientConn, func(exchange AuthExchange) error {
+		return exchange.Send(make([]byte, MaxAuthSize+1))
+	})
+	assert.ErrorIs(t, err, MessageTooLarge)
+	assert.NoError(t, clientConn.Close())
+	assert.NoError(t, serverConn.Close())
+}
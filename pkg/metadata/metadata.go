@@ -48,6 +48,11 @@ const (
 	Size = ContentLengthOffset + ContentLengthSize // 8
 )
 
+// LargeContentLength is the ContentLength of packets whose content does not fit in a uint32, which can only be
+// sent over connections that support large content. The length of the content of such packets is carried in the
+// extended header of their frame.
+const LargeContentLength = ^uint32(0)
+
 // Metadata is 8 bytes in length
 type Metadata struct {
 	Id            uint16 // 2 Bytes
@@ -0,0 +1,133 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package packet
+
+import (
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/polyglot"
+)
+
+// DefaultSlabSize is the size of the slabs of an Arena that is created with a slab size of 0
+const DefaultSlabSize = 1 << 20
+
+// Arena allocates packets whose content is carved out of large slabs of memory, and releases all of them at once
+// when Release is called. This replaces the many small content buffers of a burst of short-lived packets (like the
+// responses to a batch of requests) with a few slabs that are reused for every batch, which reduces the pressure on
+// the garbage collector.
+//
+// Packets from an Arena must not be used once the Arena has been released. Passing them to Put is safe (it ignores
+// them), so they can be returned from handlers like any other packet. An Arena is safe for concurrent use.
+type Arena struct {
+	mu       sync.Mutex
+	slabSize int
+	slab     []byte
+	used     [][]byte
+	free     [][]byte
+	packets  []*Packet
+	next     int
+}
+
+// ArenaStats describes the memory held by an Arena
+type ArenaStats struct {
+	// Packets is the number of packets that were allocated since the Arena was last released
+	Packets int
+
+	// Slabs is the number of slabs that are in use, and Free is the number of released slabs that are kept for reuse
+	Slabs int
+	Free  int
+}
+
+// NewArena returns an Arena that allocates content from slabs of slabSize bytes (DefaultSlabSize if slabSize is 0)
+func NewArena(slabSize int) *Arena {
+	if slabSize <= 0 {
+		slabSize = DefaultSlabSize
+	}
+	return &Arena{
+		slabSize: slabSize,
+	}
+}
+
+// Get returns an empty packet from the Arena whose content has a capacity of at least size bytes. Content that
+// grows beyond its capacity is moved out of the Arena by the allocator, as it would be for any other packet.
+func (a *Arena) Get(size int) *Packet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var p *Packet
+	if a.next < len(a.packets) {
+		p = a.packets[a.next]
+	} else {
+		p = &Packet{
+			Metadata: new(metadata.Metadata),
+			Content:  new(polyglot.Buffer),
+			arena:    a,
+		}
+		a.packets = append(a.packets, p)
+	}
+	a.next++
+	*p.Content = a.alloc(size)
+	return p
+}
+
+// alloc returns an empty slice with a capacity of size bytes, carved out of the current slab of the Arena if it fits
+func (a *Arena) alloc(size int) []byte {
+	if size > a.slabSize {
+		return make([]byte, 0, size)
+	}
+	if len(a.slab) < size {
+		if n := len(a.free); n > 0 {
+			a.slab, a.free = a.free[n-1], a.free[:n-1]
+		} else {
+			a.slab = make([]byte, a.slabSize)
+		}
+		a.used = append(a.used, a.slab)
+	}
+	content := a.slab[:0:size]
+	a.slab = a.slab[size:]
+	return content
+}
+
+// Release releases all the packets that were allocated by the Arena at once, so that their packets and slabs
+// are reused by the next calls to Get. None of the packets may be used after Release is called.
+func (a *Arena) Release() {
+	a.mu.Lock()
+	for _, p := range a.packets[:a.next] {
+		p.Metadata.Id = 0
+		p.Metadata.Operation = 0
+		p.Metadata.ContentLength = 0
+		p.ContentType = frame.ContentTypeUnspecified
+		*p.Content = nil
+	}
+	a.next = 0
+	a.free = append(a.free, a.used...)
+	a.used = a.used[:0]
+	a.slab = nil
+	a.mu.Unlock()
+}
+
+// Stats returns the ArenaStats of the Arena
+func (a *Arena) Stats() ArenaStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return ArenaStats{
+		Packets: a.next,
+		Slabs:   len(a.used),
+		Free:    len(a.free),
+	}
+}
@@ -0,0 +1,100 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArena(t *testing.T) {
+	const slabSize = 64
+
+	a := NewArena(slabSize)
+
+	first := a.Get(16)
+	second := a.Get(16)
+	assert.Equal(t, 0, len(*first.Content))
+	assert.Equal(t, 16, cap(*first.Content))
+
+	// Content that fits in its capacity stays in the slab, and does not overwrite the content of other packets
+	first.Content.Write([]byte("0123456789abcdef"))
+	second.Content.Write([]byte("fedcba9876543210"))
+	assert.Equal(t, "0123456789abcdef", string(*first.Content))
+	assert.Equal(t, "fedcba9876543210", string(*second.Content))
+
+	// Content that grows beyond its capacity is moved out of the slab
+	first.Content.Write([]byte("overflow"))
+	assert.Equal(t, "0123456789abcdefoverflow", string(*first.Content))
+	assert.Equal(t, "fedcba9876543210", string(*second.Content))
+
+	// Packets that do not fit in the current slab start a new one, and packets that are larger than a slab are
+	// allocated separately
+	a.Get(48)
+	large := a.Get(slabSize * 2)
+	assert.Equal(t, slabSize*2, cap(*large.Content))
+	assert.Equal(t, ArenaStats{Packets: 4, Slabs: 2}, a.Stats())
+
+	// Packets from an arena are ignored by Put
+	puts := Stats().Puts
+	Put(first)
+	assert.Equal(t, puts, Stats().Puts)
+
+	a.Release()
+	assert.Equal(t, ArenaStats{Free: 2}, a.Stats())
+	assert.Nil(t, *first.Content)
+
+	// Packets and slabs are reused after the arena is released
+	reused := a.Get(8)
+	require.Same(t, first, reused)
+	assert.Equal(t, uint16(0), reused.Metadata.Id)
+	assert.Equal(t, ArenaStats{Packets: 1, Slabs: 1, Free: 1}, a.Stats())
+
+	assert.Equal(t, DefaultSlabSize, NewArena(0).slabSize)
+}
+
+func BenchmarkArena(b *testing.B) {
+	const batch = 128
+	payload := make([]byte, 512)
+
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		packets := make([]*Packet, batch)
+		for i := 0; i < b.N; i++ {
+			for j := range packets {
+				packets[j] = Get()
+				packets[j].Content.Write(payload)
+			}
+			for _, p := range packets {
+				Put(p)
+			}
+		}
+	})
+
+	b.Run("arena", func(b *testing.B) {
+		b.ReportAllocs()
+		a := NewArena(0)
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < batch; j++ {
+				a.Get(len(payload)).Content.Write(payload)
+			}
+			a.Release()
+		}
+	})
+}
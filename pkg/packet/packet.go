@@ -17,6 +17,7 @@
 package packet
 
 import (
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
 	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/polyglot"
 )
@@ -37,12 +38,20 @@ import (
 type Packet struct {
 	Metadata *metadata.Metadata
 	Content  *polyglot.Buffer
+
+	// ContentType is an optional hint that describes how the content of the packet is encoded. It is carried
+	// in the extended header of the packet, so it is only sent over connections that use extended headers.
+	ContentType frame.ContentType
+
+	// arena is the Arena that the packet was allocated from (if any), whose packets are not returned to the pool
+	arena *Arena
 }
 
 func (p *Packet) Reset() {
 	p.Metadata.Id = 0
 	p.Metadata.Operation = 0
 	p.Metadata.ContentLength = 0
+	p.ContentType = frame.ContentTypeUnspecified
 	p.Content.Reset()
 }
 
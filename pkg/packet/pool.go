@@ -17,21 +17,168 @@
 package packet
 
 import (
+	"sync/atomic"
+
 	"github.com/loopholelabs/common/pkg/pool"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/polyglot"
 )
 
 var (
-	packetPool = NewPool()
+	packetPool = pool.NewPool(func() *Packet {
+		atomic.AddUint64(&packetStats.allocations, 1)
+		return New()
+	})
+
+	// packetStats counts how the packets returned by Get and passed to Put are served by the packet pool
+	packetStats poolStats
+
+	// packetReserve holds the *reserve that is used by Get and Put (which is nil until SetPoolHints or Prewarm are called)
+	packetReserve atomic.Value
 )
 
+// PoolHints are process-wide sizing hints for the packet pool that is used by Get and Put
+type PoolHints struct {
+	// Reserve is the number of packets that the pool keeps even across garbage collections (packets beyond the
+	// reserve are kept in a sync.Pool, which releases them during garbage collections)
+	Reserve int
+
+	// MaxContentCap is the largest content capacity (in bytes) of the packets that are kept by the pool, so that
+	// a few large packets do not keep their memory alive indefinitely. Larger packets are dropped by Put (0 means no limit).
+	MaxContentCap int
+}
+
+// reserve is the packet reserve used by Get and Put
+type reserve struct {
+	hints   PoolHints
+	packets chan *Packet
+}
+
+// poolStats holds the counters of the packet pool used by Get and Put
+type poolStats struct {
+	gets        uint64
+	puts        uint64
+	allocations uint64
+	reserveHits uint64
+	dropped     uint64
+}
+
+// PoolStats describes how the packet pool used by Get and Put has been used since the process started
+type PoolStats struct {
+	// Gets is the number of packets returned by Get
+	Gets uint64 `json:"gets"`
+
+	// Puts is the number of packets passed to Put
+	Puts uint64 `json:"puts"`
+
+	// Allocations is the number of packets that Get had to allocate because the pool was empty
+	Allocations uint64 `json:"allocations"`
+
+	// ReserveHits is the number of packets that Get returned from the reserve of the pool (see PoolHints)
+	ReserveHits uint64 `json:"reserve_hits"`
+
+	// Dropped is the number of packets that Put dropped because they were larger than PoolHints.MaxContentCap
+	Dropped uint64 `json:"dropped"`
+}
+
+// HitRate returns the fraction of the packets returned by Get that were reused instead of allocated
+func (s PoolStats) HitRate() float64 {
+	if s.Gets == 0 || s.Allocations > s.Gets {
+		return 0
+	}
+	return float64(s.Gets-s.Allocations) / float64(s.Gets)
+}
+
+// Stats returns the PoolStats of the packet pool used by Get and Put
+func Stats() PoolStats {
+	return PoolStats{
+		Gets:        atomic.LoadUint64(&packetStats.gets),
+		Puts:        atomic.LoadUint64(&packetStats.puts),
+		Allocations: atomic.LoadUint64(&packetStats.allocations),
+		ReserveHits: atomic.LoadUint64(&packetStats.reserveHits),
+		Dropped:     atomic.LoadUint64(&packetStats.dropped),
+	}
+}
+
 func NewPool() *pool.Pool[Packet, *Packet] {
 	return pool.NewPool(New)
 }
 
+// SetPoolHints sets the sizing hints of the packet pool used by Get and Put. Packets that are already held
+// in the reserve of the pool are kept (up to the new size of the reserve).
+func SetPoolHints(hints PoolHints) {
+	r := &reserve{hints: hints}
+	if hints.Reserve > 0 {
+		r.packets = make(chan *Packet, hints.Reserve)
+	}
+	old, _ := packetReserve.Swap(r).(*reserve)
+	if old != nil && old.packets != nil {
+		for {
+			select {
+			case p := <-old.packets:
+				Put(p)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+// Prewarm allocates n packets whose content buffers have a capacity of contentCap bytes and adds them to the packet pool
+// used by Get and Put, which avoids the allocations that would otherwise happen while a service is ramping up. The packets
+// are kept in the reserve of the pool (which is grown to hold n packets if it is smaller), so they are not released by the
+// garbage collector before they are used.
+func Prewarm(n int, contentCap int) {
+	r, _ := packetReserve.Load().(*reserve)
+	if r == nil || r.hints.Reserve < n {
+		var hints PoolHints
+		if r != nil {
+			hints = r.hints
+		}
+		hints.Reserve = n
+		SetPoolHints(hints)
+	}
+	for i := 0; i < n; i++ {
+		content := polyglot.Buffer(make([]byte, 0, contentCap))
+		Put(&Packet{
+			Metadata: new(metadata.Metadata),
+			Content:  &content,
+		})
+	}
+}
+
 func Get() (s *Packet) {
+	atomic.AddUint64(&packetStats.gets, 1)
+	if r, _ := packetReserve.Load().(*reserve); r != nil && r.packets != nil {
+		select {
+		case p := <-r.packets:
+			atomic.AddUint64(&packetStats.reserveHits, 1)
+			return p
+		default:
+		}
+	}
 	return packetPool.Get()
 }
 
 func Put(p *Packet) {
+	if p != nil && p.arena != nil {
+		return
+	}
+	atomic.AddUint64(&packetStats.puts, 1)
+	if r, _ := packetReserve.Load().(*reserve); r != nil && p != nil {
+		if r.hints.MaxContentCap > 0 && cap(*p.Content) > r.hints.MaxContentCap {
+			atomic.AddUint64(&packetStats.dropped, 1)
+			return
+		}
+		if r.packets != nil {
+			p.Reset()
+			select {
+			case r.packets <- p:
+				return
+			default:
+			}
+		}
+	}
 	packetPool.Put(p)
 }
@@ -68,3 +68,59 @@ func TestRecycle(t *testing.T) {
 
 	pool.Put(p)
 }
+
+func TestPrewarm(t *testing.T) {
+	defer SetPoolHints(PoolHints{})
+
+	const contentCap = 4096
+
+	Prewarm(8, contentCap)
+	reserved := packetReserve.Load().(*reserve)
+	assert.Equal(t, 8, len(reserved.packets))
+
+	packets := make([]*Packet, 8)
+	for i := range packets {
+		packets[i] = Get()
+		assert.Equal(t, 0, len(*packets[i].Content))
+		assert.GreaterOrEqual(t, cap(*packets[i].Content), contentCap)
+	}
+	assert.Equal(t, 0, len(reserved.packets))
+	for _, p := range packets {
+		p.Metadata.Id = 32
+		p.Content.Write([]byte("content"))
+		Put(p)
+	}
+	assert.Equal(t, 8, len(reserved.packets))
+	p := Get()
+	assert.Equal(t, uint16(0), p.Metadata.Id)
+	assert.Equal(t, 0, len(*p.Content))
+	Put(p)
+
+	// Packets with content buffers larger than MaxContentCap are dropped, including the ones that were already reserved
+	SetPoolHints(PoolHints{Reserve: 2, MaxContentCap: contentCap / 2})
+	reserved = packetReserve.Load().(*reserve)
+	assert.Equal(t, 0, len(reserved.packets))
+
+	Put(New())
+	Put(New())
+	Put(New())
+	assert.Equal(t, 2, len(reserved.packets))
+
+	large := New()
+	large.Content.Write(make([]byte, contentCap))
+	Get()
+	Put(large)
+	assert.Equal(t, 1, len(reserved.packets))
+}
+
+func TestStats(t *testing.T) {
+	before := Stats()
+	p := Get()
+	Put(p)
+	after := Stats()
+	assert.GreaterOrEqual(t, after.Gets-before.Gets, uint64(1))
+	assert.GreaterOrEqual(t, after.Puts-before.Puts, uint64(1))
+
+	assert.Equal(t, float64(0), PoolStats{}.HitRate())
+	assert.Equal(t, 0.75, PoolStats{Gets: 4, Allocations: 1}.HitRate())
+}
@@ -0,0 +1,284 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// DefaultQueueSize is the default number of messages that can be queued for a subscriber
+// before it is considered too slow and is evicted
+const DefaultQueueSize = 1 << 10
+
+// connContextKey is the context key used to store the *frisbee.Async of a connection in handler contexts
+type connContextKey struct{}
+
+var defaultOnEvict = func(conn *frisbee.Async) {
+	_ = conn.Close()
+}
+
+// subscriber is a connection that is subscribed to one or more topics. Messages are queued for each
+// subscriber and written to its connection by a dedicated goroutine, so that a slow subscriber
+// does not slow down publishers or other subscribers.
+type subscriber struct {
+	conn    *frisbee.Async
+	topics  map[string]struct{}
+	queue   chan []byte
+	closeCh chan struct{}
+}
+
+// Broker keeps track of the topic subscriptions of the connections to a frisbee Server and fans out the
+// messages published to a topic to all of its subscribers.
+type Broker struct {
+	operations  Operations
+	queueSize   int
+	mu          sync.RWMutex
+	topics      map[string]map[*frisbee.Async]*subscriber
+	subscribers map[*frisbee.Async]*subscriber
+
+	// onEvict is run by the broker whenever a subscriber is evicted
+	onEvict func(*frisbee.Async)
+}
+
+// NewBroker returns a new Broker that uses the given Operations and queues at most queueSize messages
+// for each subscriber (or DefaultQueueSize messages if queueSize is 0).
+//
+// The Register method must then be called to register the Broker with a frisbee Server.
+func NewBroker(operations Operations, queueSize int) *Broker {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Broker{
+		operations:  operations,
+		queueSize:   queueSize,
+		topics:      make(map[string]map[*frisbee.Async]*subscriber),
+		subscribers: make(map[*frisbee.Async]*subscriber),
+		onEvict:     defaultOnEvict,
+	}
+}
+
+// SetOnEvict sets the onEvict function for the broker, which is called whenever a subscriber is evicted because
+// its message queue is full. The subscriber will already have been unsubscribed from all of its topics, and by
+// default its connection is closed so that it can reconnect and resubscribe. If f is nil, it returns an error.
+func (b *Broker) SetOnEvict(f func(*frisbee.Async)) error {
+	if f == nil {
+		return OnEvictNil
+	}
+	b.onEvict = f
+	return nil
+}
+
+// Register adds the handlers for the Subscribe, Unsubscribe and Publish operations to the handler table of the server,
+// and wraps the server's ConnContext so that the handlers can find the connection a packet was received on.
+//
+// This function should not be called once the server has started.
+func (b *Broker) Register(s *frisbee.Server) error {
+	handlerTable := make(frisbee.HandlerTable)
+	for operation, handler := range s.GetHandlerTable() {
+		handlerTable[operation] = handler
+	}
+	for operation, handler := range map[uint16]frisbee.Handler{
+		b.operations.Subscribe:   b.handleSubscribe,
+		b.operations.Unsubscribe: b.handleUnsubscribe,
+		b.operations.Publish:     b.handlePublish,
+	} {
+		if _, ok := handlerTable[operation]; ok {
+			return OperationInUse
+		}
+		handlerTable[operation] = handler
+	}
+	err := s.SetHandlerTable(handlerTable)
+	if err != nil {
+		return err
+	}
+
+	connContext := s.ConnContext
+	s.ConnContext = func(ctx context.Context, conn *frisbee.Async) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		return context.WithValue(ctx, connContextKey{}, conn)
+	}
+	return nil
+}
+
+// Subscribers returns the number of connections that are subscribed to the given topic
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic])
+}
+
+// Publish sends content to all the subscribers of the given topic, and returns the number of subscribers
+// that the message was queued for. Subscribers whose message queues are full are evicted.
+func (b *Broker) Publish(topic string, content []byte) (int, error) {
+	if !validTopic(topic) {
+		return 0, InvalidTopic
+	}
+	p := packet.Get()
+	encodeMessage(p, topic, content)
+	message := append([]byte(nil), p.Content.Bytes()...)
+	packet.Put(p)
+
+	var evicted []*subscriber
+	queued := 0
+	b.mu.RLock()
+	for _, sub := range b.topics[topic] {
+		select {
+		case sub.queue <- message:
+			queued++
+		default:
+			evicted = append(evicted, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range evicted {
+		if b.remove(sub) {
+			b.onEvict(sub.conn)
+		}
+	}
+	return queued, nil
+}
+
+// Close unsubscribes every connection from all of its topics
+func (b *Broker) Close() {
+	b.mu.Lock()
+	subscribers := make([]*subscriber, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	b.mu.Unlock()
+	for _, sub := range subscribers {
+		b.remove(sub)
+	}
+}
+
+func (b *Broker) subscribe(conn *frisbee.Async, topic string) {
+	b.mu.Lock()
+	sub, ok := b.subscribers[conn]
+	if !ok {
+		sub = &subscriber{
+			conn:    conn,
+			topics:  make(map[string]struct{}),
+			queue:   make(chan []byte, b.queueSize),
+			closeCh: make(chan struct{}),
+		}
+		b.subscribers[conn] = sub
+		go b.writeLoop(sub)
+	}
+	sub.topics[topic] = struct{}{}
+	subscribers, ok := b.topics[topic]
+	if !ok {
+		subscribers = make(map[*frisbee.Async]*subscriber)
+		b.topics[topic] = subscribers
+	}
+	subscribers[conn] = sub
+	b.mu.Unlock()
+}
+
+func (b *Broker) unsubscribe(conn *frisbee.Async, topic string) {
+	b.mu.Lock()
+	sub, ok := b.subscribers[conn]
+	if ok {
+		b.removeTopic(sub, topic)
+		if len(sub.topics) == 0 {
+			delete(b.subscribers, conn)
+			close(sub.closeCh)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// remove unsubscribes sub from all of its topics and stops its write loop, and returns
+// false if sub was already removed
+func (b *Broker) remove(sub *subscriber) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[sub.conn] != sub {
+		return false
+	}
+	for topic := range sub.topics {
+		b.removeTopic(sub, topic)
+	}
+	delete(b.subscribers, sub.conn)
+	close(sub.closeCh)
+	return true
+}
+
+// removeTopic removes the subscription of sub to topic, and must be called with b.mu held
+func (b *Broker) removeTopic(sub *subscriber, topic string) {
+	delete(sub.topics, topic)
+	if subscribers, ok := b.topics[topic]; ok {
+		delete(subscribers, sub.conn)
+		if len(subscribers) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+}
+
+// writeLoop writes the messages queued for sub to its connection until sub is removed or its connection is closed
+func (b *Broker) writeLoop(sub *subscriber) {
+	for {
+		select {
+		case message := <-sub.queue:
+			p := packet.Get()
+			p.Metadata.Operation = b.operations.Message
+			p.Content.Write(message)
+			p.Metadata.ContentLength = uint32(len(message))
+			err := sub.conn.WritePacket(p)
+			packet.Put(p)
+			if err != nil {
+				b.remove(sub)
+				return
+			}
+		case <-sub.conn.CloseChannel():
+			b.remove(sub)
+			return
+		case <-sub.closeCh:
+			return
+		}
+	}
+}
+
+func (b *Broker) handleSubscribe(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+	conn, ok := ctx.Value(connContextKey{}).(*frisbee.Async)
+	if ok && incoming.Metadata.ContentLength > 0 {
+		b.subscribe(conn, string(*incoming.Content))
+	}
+	return nil, frisbee.NONE
+}
+
+func (b *Broker) handleUnsubscribe(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+	conn, ok := ctx.Value(connContextKey{}).(*frisbee.Async)
+	if ok && incoming.Metadata.ContentLength > 0 {
+		b.unsubscribe(conn, string(*incoming.Content))
+	}
+	return nil, frisbee.NONE
+}
+
+func (b *Broker) handlePublish(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+	topic, content, err := DecodeMessage(incoming)
+	if err == nil {
+		_, _ = b.Publish(topic, content)
+	}
+	return nil, frisbee.NONE
+}
@@ -0,0 +1,142 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package pubsub
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type message struct {
+	topic   string
+	content string
+}
+
+func newSubscriber(t *testing.T, s *frisbee.Server) (*frisbee.Client, <-chan message) {
+	messages := make(chan message, 16)
+	handlerTable := make(frisbee.HandlerTable)
+	handlerTable[DefaultOperations.Message] = MessageHandler(func(_ context.Context, topic string, content []byte) {
+		messages <- message{topic: topic, content: string(content)}
+	})
+
+	emptyLogger := zerolog.New(io.Discard)
+	c, err := frisbee.NewClient(handlerTable, context.Background(), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	return c, messages
+}
+
+func TestBroker(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	s, err := frisbee.NewServer(make(frisbee.HandlerTable), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	b := NewBroker(DefaultOperations, 0)
+	require.NoError(t, b.Register(s))
+	assert.ErrorIs(t, b.Register(s), OperationInUse)
+	assert.ErrorIs(t, b.SetOnEvict(nil), OnEvictNil)
+
+	first, firstMessages := newSubscriber(t, s)
+	second, secondMessages := newSubscriber(t, s)
+
+	require.NoError(t, Subscribe(first, DefaultOperations, "news"))
+	require.NoError(t, Subscribe(second, DefaultOperations, "news"))
+	require.NoError(t, Subscribe(second, DefaultOperations, "sports"))
+	assert.Eventually(t, func() bool {
+		return b.Subscribers("news") == 2 && b.Subscribers("sports") == 1
+	}, time.Second, time.Millisecond*10)
+
+	require.NoError(t, Publish(first, DefaultOperations, "news", []byte("headline")))
+	assert.Equal(t, message{topic: "news", content: "headline"}, <-firstMessages)
+	assert.Equal(t, message{topic: "news", content: "headline"}, <-secondMessages)
+
+	queued, err := b.Publish("sports", []byte("score"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	assert.Equal(t, message{topic: "sports", content: "score"}, <-secondMessages)
+
+	require.NoError(t, Unsubscribe(second, DefaultOperations, "news"))
+	assert.Eventually(t, func() bool {
+		return b.Subscribers("news") == 1
+	}, time.Second, time.Millisecond*10)
+
+	err = first.Close()
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return b.Subscribers("news") == 0
+	}, time.Second, time.Millisecond*10)
+
+	b.Close()
+	assert.Equal(t, 0, b.Subscribers("sports"))
+
+	err = second.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestBrokerEviction(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+	conn := frisbee.NewAsync(writer, &emptyLogger)
+
+	b := NewBroker(DefaultOperations, 1)
+	evicted := make(chan *frisbee.Async, 1)
+	require.NoError(t, b.SetOnEvict(func(c *frisbee.Async) {
+		evicted <- c
+	}))
+
+	b.subscribe(conn, "slow")
+	b.subscribe(conn, "other")
+
+	content := make([]byte, 1<<16)
+	for i := 0; i < 1<<10 && b.Subscribers("slow") > 0; i++ {
+		_, err := b.Publish("slow", content)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, conn, <-evicted)
+	assert.Equal(t, 0, b.Subscribers("slow"))
+	assert.Equal(t, 0, b.Subscribers("other"))
+
+	err := conn.Close()
+	assert.NoError(t, err)
+	err = reader.Close()
+	assert.NoError(t, err)
+}
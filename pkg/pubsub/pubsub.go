@@ -0,0 +1,147 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package pubsub provides a topic-based publish/subscribe layer on top of a frisbee Server.
+//
+// Clients subscribe to and unsubscribe from string topics, and publish packets to topics, using
+// the operations defined in Operations. The Broker registers handlers for these operations with a frisbee
+// Server and fans out every published packet to all the subscribers of its topic.
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+)
+
+// These are various pubsub errors that can be returned by the Broker or the client functions:
+var (
+	InvalidTopic   = errors.New("invalid topic")
+	InvalidMessage = errors.New("invalid message")
+	OperationInUse = errors.New("operation is already in use by the handler table")
+	OnEvictNil     = errors.New("OnEvict cannot be nil")
+)
+
+// Operations are the packet operations used by the pubsub layer. They must not collide with
+// any of the other operations used by the application.
+type Operations struct {
+	// Subscribe is sent by clients to subscribe to the topic in the packet's content
+	Subscribe uint16
+
+	// Unsubscribe is sent by clients to unsubscribe from the topic in the packet's content
+	Unsubscribe uint16
+
+	// Publish is sent by clients to publish a message to the subscribers of a topic
+	Publish uint16
+
+	// Message is sent by the Broker to deliver a published message to a subscriber
+	Message uint16
+}
+
+// DefaultOperations are the default Operations used by the pubsub layer
+var DefaultOperations = Operations{
+	Subscribe:   0xFF00,
+	Unsubscribe: 0xFF01,
+	Publish:     0xFF02,
+	Message:     0xFF03,
+}
+
+// PacketWriter is implemented by frisbee connections and clients (like *frisbee.Async and *frisbee.Client)
+type PacketWriter interface {
+	WritePacket(p *packet.Packet) error
+}
+
+// Subscribe subscribes the connection to the given topic
+func Subscribe(w PacketWriter, operations Operations, topic string) error {
+	return writeTopic(w, operations.Subscribe, topic)
+}
+
+// Unsubscribe unsubscribes the connection from the given topic
+func Unsubscribe(w PacketWriter, operations Operations, topic string) error {
+	return writeTopic(w, operations.Unsubscribe, topic)
+}
+
+// Publish publishes content to all the subscribers of the given topic
+func Publish(w PacketWriter, operations Operations, topic string, content []byte) error {
+	if !validTopic(topic) {
+		return InvalidTopic
+	}
+	p := packet.Get()
+	p.Metadata.Operation = operations.Publish
+	encodeMessage(p, topic, content)
+	err := w.WritePacket(p)
+	packet.Put(p)
+	return err
+}
+
+// MessageHandler returns a frisbee.Handler that decodes the messages delivered by the Broker
+// and calls f with their topic and content. It should be registered for the Message operation.
+//
+// The content is only valid until f returns.
+func MessageHandler(f func(ctx context.Context, topic string, content []byte)) frisbee.Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		topic, content, err := DecodeMessage(incoming)
+		if err == nil {
+			f(ctx, topic, content)
+		}
+		return nil, frisbee.NONE
+	}
+}
+
+// DecodeMessage decodes the topic and content of a Publish or Message packet
+func DecodeMessage(p *packet.Packet) (topic string, content []byte, err error) {
+	b := (*p.Content)[:p.Metadata.ContentLength]
+	if len(b) < 2 {
+		return "", nil, InvalidMessage
+	}
+	size := int(binary.BigEndian.Uint16(b))
+	if size == 0 || len(b) < 2+size {
+		return "", nil, InvalidMessage
+	}
+	return string(b[2 : 2+size]), b[2+size:], nil
+}
+
+// encodeMessage writes the content of a Publish or Message packet to p, which is
+// made up of the length of the topic as a uint16, the topic, and then the content
+func encodeMessage(p *packet.Packet, topic string, content []byte) {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(topic)))
+	p.Content.Write(size[:])
+	p.Content.Write([]byte(topic))
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+}
+
+func writeTopic(w PacketWriter, operation uint16, topic string) error {
+	if !validTopic(topic) {
+		return InvalidTopic
+	}
+	p := packet.Get()
+	p.Metadata.Operation = operation
+	p.Content.Write([]byte(topic))
+	p.Metadata.ContentLength = uint32(len(topic))
+	err := w.WritePacket(p)
+	packet.Put(p)
+	return err
+}
+
+func validTopic(topic string) bool {
+	return len(topic) > 0 && len(topic) <= math.MaxUint16
+}
@@ -0,0 +1,81 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type packetWriter struct {
+	packets []*packet.Packet
+}
+
+func (w *packetWriter) WritePacket(p *packet.Packet) error {
+	c := packet.Get()
+	c.Metadata.Operation = p.Metadata.Operation
+	c.Content.Write(*p.Content)
+	c.Metadata.ContentLength = p.Metadata.ContentLength
+	w.packets = append(w.packets, c)
+	return nil
+}
+
+func TestClientFunctions(t *testing.T) {
+	t.Parallel()
+
+	w := new(packetWriter)
+	require.NoError(t, Subscribe(w, DefaultOperations, "topic"))
+	require.NoError(t, Unsubscribe(w, DefaultOperations, "topic"))
+	require.NoError(t, Publish(w, DefaultOperations, "topic", []byte("content")))
+	require.Equal(t, 3, len(w.packets))
+
+	assert.Equal(t, DefaultOperations.Subscribe, w.packets[0].Metadata.Operation)
+	assert.Equal(t, "topic", string(*w.packets[0].Content))
+	assert.Equal(t, DefaultOperations.Unsubscribe, w.packets[1].Metadata.Operation)
+
+	assert.Equal(t, DefaultOperations.Publish, w.packets[2].Metadata.Operation)
+	topic, content, err := DecodeMessage(w.packets[2])
+	require.NoError(t, err)
+	assert.Equal(t, "topic", topic)
+	assert.Equal(t, []byte("content"), content)
+
+	var received []string
+	handler := MessageHandler(func(_ context.Context, topic string, content []byte) {
+		received = append(received, topic, string(content))
+	})
+	handler(context.Background(), w.packets[2])
+	assert.Equal(t, []string{"topic", "content"}, received)
+
+	for _, p := range w.packets {
+		packet.Put(p)
+	}
+
+	assert.ErrorIs(t, Subscribe(w, DefaultOperations, ""), InvalidTopic)
+	assert.ErrorIs(t, Publish(w, DefaultOperations, strings.Repeat("t", 1<<16), nil), InvalidTopic)
+
+	p := packet.Get()
+	p.Content.Write([]byte{0, 8, 't'})
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	_, _, err = DecodeMessage(p)
+	assert.ErrorIs(t, err, InvalidMessage)
+	packet.Put(p)
+}
@@ -0,0 +1,243 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/pkg/errors"
+)
+
+var (
+	InvalidCapture = errors.New("invalid pcapng capture")
+)
+
+// LinkType is the pcapng link-type used for frisbee captures (LINKTYPE_USER0). Every captured packet
+// is a complete frisbee frame: the 8 byte encoded metadata followed by the packet content.
+const LinkType = uint16(147)
+
+const (
+	sectionHeaderBlock        = uint32(0x0A0D0D0A)
+	interfaceDescriptionBlock = uint32(0x00000001)
+	enhancedPacketBlock       = uint32(0x00000006)
+
+	byteOrderMagic = uint32(0x1A2B3C4D)
+
+	optionEnd        = uint16(0)
+	optionTSResol    = uint16(9)
+	optionEPBFlags   = uint16(2)
+	nanosecondResol  = uint8(9)
+	microsecondResol = uint8(6)
+
+	maxBlockSize = 1 << 28
+)
+
+var byteOrder = binary.LittleEndian
+
+// WritePcapNG writes all the recorded frames to w in the pcapng format
+func (r *Recorder) WritePcapNG(w io.Writer) error {
+	return WritePcapNG(w, r.Frames())
+}
+
+// WritePcapNG writes frames to w in the pcapng format, using a single interface with the frisbee LinkType
+// and nanosecond timestamps. The direction of each frame is stored in its epb_flags option.
+func WritePcapNG(w io.Writer, frames []Frame) error {
+	bw := bufio.NewWriter(w)
+
+	shb := make([]byte, 28)
+	byteOrder.PutUint32(shb[0:], sectionHeaderBlock)
+	byteOrder.PutUint32(shb[4:], uint32(len(shb)))
+	byteOrder.PutUint32(shb[8:], byteOrderMagic)
+	byteOrder.PutUint16(shb[12:], 1)
+	byteOrder.PutUint16(shb[14:], 0)
+	byteOrder.PutUint64(shb[16:], math.MaxUint64)
+	byteOrder.PutUint32(shb[24:], uint32(len(shb)))
+	if _, err := bw.Write(shb); err != nil {
+		return err
+	}
+
+	idb := make([]byte, 32)
+	byteOrder.PutUint32(idb[0:], interfaceDescriptionBlock)
+	byteOrder.PutUint32(idb[4:], uint32(len(idb)))
+	byteOrder.PutUint16(idb[8:], LinkType)
+	byteOrder.PutUint32(idb[12:], 0)
+	byteOrder.PutUint16(idb[16:], optionTSResol)
+	byteOrder.PutUint16(idb[18:], 1)
+	idb[20] = nanosecondResol
+	byteOrder.PutUint16(idb[24:], optionEnd)
+	byteOrder.PutUint32(idb[28:], uint32(len(idb)))
+	if _, err := bw.Write(idb); err != nil {
+		return err
+	}
+
+	for _, frame := range frames {
+		if _, err := bw.Write(encodeFrame(frame)); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// encodeFrame encodes a frame as an enhanced packet block
+func encodeFrame(frame Frame) []byte {
+	capturedLength := metadata.Size + len(frame.Content)
+	paddedLength := (capturedLength + 3) &^ 3
+	blockLength := 28 + paddedLength + 8 + 4 + 4
+
+	b := make([]byte, blockLength)
+	byteOrder.PutUint32(b[0:], enhancedPacketBlock)
+	byteOrder.PutUint32(b[4:], uint32(blockLength))
+	byteOrder.PutUint32(b[8:], 0)
+	timestamp := uint64(frame.Timestamp.UnixNano())
+	byteOrder.PutUint32(b[12:], uint32(timestamp>>32))
+	byteOrder.PutUint32(b[16:], uint32(timestamp))
+	byteOrder.PutUint32(b[20:], uint32(capturedLength))
+	byteOrder.PutUint32(b[24:], uint32(capturedLength))
+
+	binary.BigEndian.PutUint16(b[28+metadata.IdOffset:], frame.Metadata.Id)
+	binary.BigEndian.PutUint16(b[28+metadata.OperationOffset:], frame.Metadata.Operation)
+	binary.BigEndian.PutUint32(b[28+metadata.ContentLengthOffset:], frame.Metadata.ContentLength)
+	copy(b[28+metadata.Size:], frame.Content)
+
+	options := b[28+paddedLength:]
+	byteOrder.PutUint16(options[0:], optionEPBFlags)
+	byteOrder.PutUint16(options[2:], 4)
+	byteOrder.PutUint32(options[4:], uint32(frame.Direction))
+	byteOrder.PutUint16(options[8:], optionEnd)
+	byteOrder.PutUint32(b[blockLength-4:], uint32(blockLength))
+	return b
+}
+
+// ReadPcapNG reads the frames from a pcapng capture that was written by WritePcapNG. Blocks
+// other than section headers, interface descriptions, and enhanced packets are skipped.
+func ReadPcapNG(r io.Reader) ([]Frame, error) {
+	br := bufio.NewReader(r)
+	var frames []Frame
+	var header [8]byte
+	resolution := microsecondResol
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+			return nil, errors.Wrap(err, InvalidCapture.Error())
+		}
+		blockType := byteOrder.Uint32(header[0:])
+		blockLength := byteOrder.Uint32(header[4:])
+		if blockLength < 12 || blockLength%4 != 0 || blockLength > maxBlockSize {
+			return nil, InvalidCapture
+		}
+		body := make([]byte, blockLength-8)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return nil, errors.Wrap(err, InvalidCapture.Error())
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case sectionHeaderBlock:
+			if len(body) < 4 || byteOrder.Uint32(body[0:]) != byteOrderMagic {
+				return nil, InvalidCapture
+			}
+		case interfaceDescriptionBlock:
+			if len(body) < 8 {
+				return nil, InvalidCapture
+			}
+			if byteOrder.Uint16(body[0:]) != LinkType {
+				return nil, InvalidCapture
+			}
+			resolution = microsecondResol
+			for _, option := range parseOptions(body[8:]) {
+				if option.code == optionTSResol && len(option.value) == 1 {
+					resolution = option.value[0]
+				}
+			}
+		case enhancedPacketBlock:
+			frame, err := decodeFrame(body, resolution)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, frame)
+		}
+	}
+}
+
+// decodeFrame decodes the body of an enhanced packet block
+func decodeFrame(body []byte, resolution uint8) (Frame, error) {
+	if len(body) < 20 {
+		return Frame{}, InvalidCapture
+	}
+	timestamp := uint64(byteOrder.Uint32(body[4:]))<<32 | uint64(byteOrder.Uint32(body[8:]))
+	capturedLength := int(byteOrder.Uint32(body[12:]))
+	paddedLength := (capturedLength + 3) &^ 3
+	if capturedLength < metadata.Size || len(body) < 20+paddedLength {
+		return Frame{}, InvalidCapture
+	}
+	data := body[20 : 20+capturedLength]
+
+	m, err := metadata.Decode(data)
+	if err != nil {
+		return Frame{}, errors.Wrap(err, InvalidCapture.Error())
+	}
+	if int(m.ContentLength) != capturedLength-metadata.Size {
+		return Frame{}, InvalidCapture
+	}
+
+	frame := Frame{
+		Metadata: *m,
+		Content:  append([]byte(nil), data[metadata.Size:]...),
+	}
+	switch resolution {
+	case nanosecondResol:
+		frame.Timestamp = time.Unix(0, int64(timestamp))
+	default:
+		frame.Timestamp = time.UnixMicro(int64(timestamp))
+	}
+	for _, option := range parseOptions(body[20+paddedLength:]) {
+		if option.code == optionEPBFlags && len(option.value) == 4 {
+			frame.Direction = Direction(byteOrder.Uint32(option.value) & 0x3)
+		}
+	}
+	return frame, nil
+}
+
+type option struct {
+	code  uint16
+	value []byte
+}
+
+func parseOptions(b []byte) (options []option) {
+	for len(b) >= 4 {
+		code := byteOrder.Uint16(b[0:])
+		length := int(byteOrder.Uint16(b[2:]))
+		if code == optionEnd || len(b) < 4+length {
+			return
+		}
+		options = append(options, option{code: code, value: b[4 : 4+length]})
+		next := 4 + (length+3)&^3
+		if next > len(b) {
+			return
+		}
+		b = b[next:]
+	}
+	return
+}
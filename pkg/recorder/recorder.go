@@ -0,0 +1,114 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package recorder provides a frisbee PacketRecorder that captures every frame sent or received on a
+// connection along with a timestamp, and can export the captured frames in the pcapng format so they
+// can be inspected in Wireshark or replayed later.
+package recorder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Direction is the direction a Frame was travelling in, relative to the recorded connection
+type Direction uint8
+
+const (
+	// Inbound frames were read from the connection
+	Inbound = Direction(1)
+
+	// Outbound frames were written to the connection
+	Outbound = Direction(2)
+)
+
+// Frame is a single recorded frisbee packet
+type Frame struct {
+	Timestamp time.Time
+	Direction Direction
+	Metadata  metadata.Metadata
+	Content   []byte
+
+	// ContentType is the content type hint that the packet carried (if any)
+	ContentType frame.ContentType
+}
+
+// Recorder records the frames of one or more frisbee connections. It is safe to use concurrently.
+type Recorder struct {
+	mu     sync.Mutex
+	frames []Frame
+	limit  int
+}
+
+// New returns a new Recorder that holds at most limit frames (dropping the oldest frames first),
+// or an unlimited number of frames if limit is 0.
+func New(limit int) *Recorder {
+	return &Recorder{
+		limit: limit,
+	}
+}
+
+// RecordRead records a packet that was read from a connection
+func (r *Recorder) RecordRead(p *packet.Packet) {
+	r.record(Inbound, p)
+}
+
+// RecordWrite records a packet that was written to a connection
+func (r *Recorder) RecordWrite(p *packet.Packet) {
+	r.record(Outbound, p)
+}
+
+// Frames returns a copy of the recorded frames, in the order they were recorded
+func (r *Recorder) Frames() []Frame {
+	r.mu.Lock()
+	frames := make([]Frame, len(r.frames))
+	copy(frames, r.frames)
+	r.mu.Unlock()
+	return frames
+}
+
+// Reset discards all the recorded frames
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.frames = nil
+	r.mu.Unlock()
+}
+
+func (r *Recorder) record(direction Direction, p *packet.Packet) {
+	content := *p.Content
+	if p.Metadata.ContentLength != metadata.LargeContentLength {
+		content = content[:p.Metadata.ContentLength]
+	}
+	frame := Frame{
+		Timestamp: time.Now(),
+		Direction: direction,
+		Metadata:  *p.Metadata,
+		Content:   append([]byte(nil), content...),
+
+		ContentType: p.ContentType,
+	}
+	r.mu.Lock()
+	if r.limit > 0 && len(r.frames) >= r.limit {
+		copy(r.frames, r.frames[1:])
+		r.frames = r.frames[:len(r.frames)-1]
+	}
+	r.frames = append(r.frames, frame)
+	r.mu.Unlock()
+}
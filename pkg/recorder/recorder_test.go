@@ -0,0 +1,97 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package recorder
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	r := New(2)
+
+	p := packet.Get()
+	p.Metadata.Id = 1
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte("first"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	r.RecordWrite(p)
+
+	p.Reset()
+	p.Metadata.Id = 2
+	p.Metadata.Operation = 33
+	r.RecordRead(p)
+
+	p.Metadata.Id = 3
+	r.RecordRead(p)
+	packet.Put(p)
+
+	frames := r.Frames()
+	require.Equal(t, 2, len(frames))
+	assert.Equal(t, uint16(2), frames[0].Metadata.Id)
+	assert.Equal(t, Inbound, frames[0].Direction)
+	assert.Equal(t, uint16(3), frames[1].Metadata.Id)
+
+	r.Reset()
+	assert.Equal(t, 0, len(r.Frames()))
+}
+
+func TestPcapNG(t *testing.T) {
+	t.Parallel()
+
+	r := New(0)
+
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte("odd length"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	r.RecordWrite(p)
+
+	p.Reset()
+	p.Metadata.Id = 65
+	p.Metadata.Operation = 33
+	r.RecordRead(p)
+	packet.Put(p)
+
+	var buf bytes.Buffer
+	err := r.WritePcapNG(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 0, buf.Len()%4)
+
+	frames, err := ReadPcapNG(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(frames))
+
+	recorded := r.Frames()
+	for i := range frames {
+		assert.Equal(t, recorded[i].Metadata, frames[i].Metadata)
+		assert.Equal(t, recorded[i].Direction, frames[i].Direction)
+		assert.Equal(t, recorded[i].Timestamp.UnixNano(), frames[i].Timestamp.UnixNano())
+		assert.Equal(t, len(recorded[i].Content), len(frames[i].Content))
+	}
+	assert.Equal(t, []byte("odd length"), frames[0].Content)
+
+	_, err = ReadPcapNG(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	assert.ErrorIs(t, err, InvalidCapture)
+}
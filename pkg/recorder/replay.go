@@ -0,0 +1,116 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package recorder
+
+import (
+	"context"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// reservedOperations is the number of operations that frisbee reserves for its internal control
+// packets (like PING, PONG and STREAM), which cannot be written directly to a connection
+const reservedOperations = 10
+
+// PacketWriter is implemented by frisbee connections and clients (like *frisbee.Async, *frisbee.Sync and *frisbee.Client)
+type PacketWriter interface {
+	WritePacket(p *packet.Packet) error
+}
+
+// Replayer re-sends a recorded sequence of frames to a frisbee connection, which is useful for
+// regression testing and for reproducing production incidents under load.
+//
+// The zero value replays every outbound application frame as fast as possible.
+type Replayer struct {
+	// Direction selects the frames that are replayed. It defaults to Outbound, which is correct for
+	// recordings made on a client. Recordings made on a server should be replayed with Inbound.
+	Direction Direction
+
+	// Speed scales the original timing of the frames, so 1 replays the frames with the same gaps between them
+	// as when they were recorded, and 2 replays them twice as fast. A Speed of 0 replays the frames as fast as possible.
+	Speed float64
+
+	// Filter is called for every frame that would be replayed, and the frame is skipped if it returns false.
+	Filter func(Frame) bool
+}
+
+// Replay writes the selected frames to w in the order they were recorded, and returns the number of frames
+// that were written. Frames that carry internal control packets are always skipped.
+//
+// If ctx is cancelled while waiting to write a frame then the number of frames already written is returned along
+// with the context's error.
+func (r *Replayer) Replay(ctx context.Context, w PacketWriter, frames []Frame) (int, error) {
+	direction := r.Direction
+	if direction == 0 {
+		direction = Outbound
+	}
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	var start time.Time
+	var first time.Time
+	written := 0
+	for _, frame := range frames {
+		if frame.Direction != direction || frame.Metadata.Operation < reservedOperations {
+			continue
+		}
+		if r.Filter != nil && !r.Filter(frame) {
+			continue
+		}
+
+		if r.Speed > 0 {
+			if start.IsZero() {
+				start = time.Now()
+				first = frame.Timestamp
+			} else if wait := time.Until(start.Add(time.Duration(float64(frame.Timestamp.Sub(first)) / r.Speed))); wait > 0 {
+				if timer == nil {
+					timer = time.NewTimer(wait)
+				} else {
+					timer.Reset(wait)
+				}
+				select {
+				case <-ctx.Done():
+					return written, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		p := packet.Get()
+		p.Metadata.Id = frame.Metadata.Id
+		p.Metadata.Operation = frame.Metadata.Operation
+		p.Content.Write(frame.Content)
+		p.Metadata.ContentLength = uint32(len(frame.Content))
+		err := w.WritePacket(p)
+		packet.Put(p)
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
@@ -0,0 +1,105 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package recorder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type replayWriter struct {
+	frames []Frame
+}
+
+func (w *replayWriter) WritePacket(p *packet.Packet) error {
+	w.frames = append(w.frames, Frame{
+		Timestamp: time.Now(),
+		Metadata:  *p.Metadata,
+		Content:   append([]byte(nil), p.Content.Bytes()...),
+	})
+	return nil
+}
+
+func replayFrames(start time.Time, gap time.Duration) []Frame {
+	return []Frame{
+		{Timestamp: start, Direction: Outbound, Metadata: metadata.Metadata{Id: 1, Operation: 32, ContentLength: 5}, Content: []byte("first")},
+		{Timestamp: start, Direction: Outbound, Metadata: metadata.Metadata{Operation: 0}, Content: []byte{}},
+		{Timestamp: start.Add(gap), Direction: Inbound, Metadata: metadata.Metadata{Id: 2, Operation: 33}, Content: []byte{}},
+		{Timestamp: start.Add(gap * 2), Direction: Outbound, Metadata: metadata.Metadata{Id: 3, Operation: 34, ContentLength: 6}, Content: []byte("second")},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	frames := replayFrames(time.Now(), time.Hour)
+
+	w := new(replayWriter)
+	r := new(Replayer)
+	n, err := r.Replay(context.Background(), w, frames)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	assert.Equal(t, uint16(1), w.frames[0].Metadata.Id)
+	assert.Equal(t, []byte("first"), w.frames[0].Content)
+	assert.Equal(t, uint16(34), w.frames[1].Metadata.Operation)
+	assert.Equal(t, uint32(6), w.frames[1].Metadata.ContentLength)
+	assert.Equal(t, []byte("second"), w.frames[1].Content)
+
+	w = new(replayWriter)
+	r = &Replayer{Direction: Inbound}
+	n, err = r.Replay(context.Background(), w, frames)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	assert.Equal(t, uint16(2), w.frames[0].Metadata.Id)
+
+	w = new(replayWriter)
+	r = &Replayer{Filter: func(frame Frame) bool {
+		return frame.Metadata.Id != 1
+	}}
+	n, err = r.Replay(context.Background(), w, frames)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	assert.Equal(t, uint16(3), w.frames[0].Metadata.Id)
+}
+
+func TestReplayTiming(t *testing.T) {
+	t.Parallel()
+
+	const gap = time.Millisecond * 50
+	frames := replayFrames(time.Now(), gap)
+
+	w := new(replayWriter)
+	r := &Replayer{Speed: 1}
+	n, err := r.Replay(context.Background(), w, frames)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	assert.GreaterOrEqual(t, w.frames[1].Timestamp.Sub(w.frames[0].Timestamp), gap*2)
+
+	w = new(replayWriter)
+	r = &Replayer{Speed: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), gap)
+	defer cancel()
+	n, err = r.Replay(ctx, w, replayFrames(time.Now(), time.Hour))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, n)
+}
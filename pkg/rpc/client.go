@@ -0,0 +1,302 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"go.uber.org/atomic"
+)
+
+const (
+	// DefaultRetryAttempts is the default number of times that a Caller with WithRetry attempts a unary call
+	DefaultRetryAttempts = 3
+
+	// DefaultRetryBackoff is the default amount of time that a Caller with WithRetry waits for before retrying a call
+	DefaultRetryBackoff = time.Millisecond * 100
+
+	// DefaultMaxRetryBackoff is the default maximum amount of time that a Caller with WithRetry waits for before
+	// retrying a call
+	DefaultMaxRetryBackoff = time.Second * 5
+)
+
+// RetryPolicy configures how a Caller retries unary calls (see WithRetry)
+type RetryPolicy struct {
+	// Attempts is the number of times that a call is attempted (defaults to DefaultRetryAttempts)
+	Attempts int
+
+	// Backoff is how long the Caller waits for before the first retry, which doubles with every
+	// further retry (defaults to DefaultRetryBackoff)
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum amount of time that the Caller waits for before a retry
+	// (defaults to DefaultMaxRetryBackoff)
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultRetryAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultMaxRetryBackoff
+	}
+	if p.MaxBackoff < p.Backoff {
+		p.MaxBackoff = p.Backoff
+	}
+	return p
+}
+
+// CallerOption configures a Caller (see NewCaller)
+type CallerOption func(*Caller)
+
+// WithIdempotencyKeys makes the Caller write a new IdempotencyKey in front of the request of every unary call, so
+// that a server whose Router has an IdempotencyCache (see WithIdempotencyCache) only handles every call once
+func WithIdempotencyKeys() CallerOption {
+	return func(c *Caller) {
+		c.idempotent = true
+	}
+}
+
+// WithRetry makes the Caller retry unary calls with the same IdempotencyKey (which enables WithIdempotencyKeys) when
+// the connection fails before the response was received. Calls are retried on the same Conn, so it should replace
+// its connection once it fails, like a Failover. Errors that are not caused by the connection are not retried.
+func WithRetry(policy RetryPolicy) CallerOption {
+	return func(c *Caller) {
+		c.idempotent = true
+		c.retry = policy.withDefaults()
+	}
+}
+
+// response is the response to a unary call
+type response struct {
+	content []byte
+	failed  bool
+}
+
+// Caller makes unary calls and opens streams for a generated client, and matches the responses to unary calls
+// with their callers using the packet ID.
+type Caller struct {
+	codec          codec.Codec
+	errorOperation uint16
+
+	pendingMu sync.Mutex
+	pending   map[uint16]chan response
+	nextId    uint16
+
+	nextStream *atomic.Uint32
+
+	idempotent bool
+	retry      RetryPolicy
+}
+
+// NewCaller returns a new Caller that encodes messages with c, and treats responses with the errorOperation as failed calls
+func NewCaller(c codec.Codec, errorOperation uint16, options ...CallerOption) *Caller {
+	caller := &Caller{
+		codec:          c,
+		errorOperation: errorOperation,
+		pending:        make(map[uint16]chan response),
+		nextStream:     atomic.NewUint32(0),
+		retry:          RetryPolicy{Attempts: 1},
+	}
+	for _, option := range options {
+		option(caller)
+	}
+	return caller
+}
+
+// Register adds the handlers for the responses to unary calls with the given operations (and the Caller's
+// error operation) to the handler table, which must then be used to create the frisbee client.
+func (c *Caller) Register(handlerTable frisbee.HandlerTable, operations ...uint16) error {
+	operations = append([]uint16{c.errorOperation}, operations...)
+	for _, operation := range operations {
+		if _, ok := handlerTable[operation]; ok {
+			return OperationInUse
+		}
+	}
+	for _, operation := range operations {
+		handlerTable[operation] = c.handleResponse
+	}
+	return nil
+}
+
+// Call sends req to the server using the given operation, and waits for its response. If the server's handler
+// failed, a RemoteError is returned. Calls are retried according to the RetryPolicy of the Caller (see WithRetry).
+func Call[Req, Res any](ctx context.Context, c *Caller, conn Conn, operation uint16, req *Req) (*Res, error) {
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if c.idempotent {
+		idempotencyKey, err := NewIdempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+		key = idempotencyKey[:]
+	}
+
+	backoff := c.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, retry, err := c.call(ctx, conn, operation, key, data)
+		if err == nil {
+			if resp.failed {
+				return nil, RemoteError(resp.content)
+			}
+			res := new(Res)
+			if err = c.codec.Unmarshal(resp.content, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		}
+		if !retry || attempt >= c.retry.Attempts {
+			return nil, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
+
+// call makes a single attempt of a unary call, whose request is the given IdempotencyKey (if it is not nil)
+// followed by the encoded data, and returns whether the attempt failed because the connection failed
+func (c *Caller) call(ctx context.Context, conn Conn, operation uint16, key []byte, data []byte) (response, bool, error) {
+	ch := make(chan response, 1)
+	c.pendingMu.Lock()
+	id := c.nextId
+	for _, ok := c.pending[id]; ok; _, ok = c.pending[id] {
+		id++
+	}
+	c.nextId = id + 1
+	c.pending[id] = ch
+	c.pendingMu.Unlock()
+
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	closed := conn.CloseChannel()
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = operation
+	p.Content.Write(key)
+	p.Content.Write(data)
+	p.Metadata.ContentLength = uint32(len(key) + len(data))
+	err := conn.WritePacket(p)
+	packet.Put(p)
+	if err != nil {
+		select {
+		case <-closed:
+			return response{}, true, err
+		default:
+			return response{}, false, err
+		}
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, false, nil
+	case <-ctx.Done():
+		return response{}, false, ctx.Err()
+	case <-closed:
+		select {
+		case resp := <-ch:
+			return resp, false, nil
+		default:
+			return response{}, true, frisbee.ConnectionClosed
+		}
+	}
+}
+
+// OpenStream opens a new stream on conn for the bidirectional streaming method with the given operation
+func OpenStream[Send, Recv any](c *Caller, conn Conn, operation uint16) (*Stream[Send, Recv], error) {
+	stream, err := conn.OpenStream(uint16(c.nextStream.Inc()), frisbee.MessageMode)
+	if err != nil {
+		return nil, err
+	}
+	p := packet.Get()
+	p.Content.Write([]byte{byte(operation >> 8), byte(operation)})
+	p.Metadata.ContentLength = streamHeaderSize
+	err = stream.WritePacket(p)
+	packet.Put(p)
+	if err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	return NewStream[Send, Recv](stream, c.codec), nil
+}
+
+// ServerStreaming calls the server-streaming method with the given operation on conn by sending req, and
+// returns the stream that the responses can be received from.
+func ServerStreaming[Req, Res any](c *Caller, conn Conn, operation uint16, req *Req) (*Stream[Req, Res], error) {
+	stream, err := OpenStream[Req, Res](c, conn, operation)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.Send(req)
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// ClientStreaming opens a new stream on conn for the client-streaming method with the given operation
+func ClientStreaming[Req, Res any](c *Caller, conn Conn, operation uint16) (*ClientStream[Req, Res], error) {
+	stream, err := OpenStream[Req, Res](c, conn, operation)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream[Req, Res]{Stream: stream}, nil
+}
+
+func (c *Caller) handleResponse(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+	c.pendingMu.Lock()
+	ch, ok := c.pending[incoming.Metadata.Id]
+	if ok {
+		delete(c.pending, incoming.Metadata.Id)
+	}
+	c.pendingMu.Unlock()
+	if ok {
+		ch <- response{
+			content: append([]byte(nil), (*incoming.Content)[:incoming.Metadata.ContentLength]...),
+			failed:  incoming.Metadata.Operation == c.errorOperation,
+		}
+	}
+	return nil, frisbee.NONE
+}
@@ -0,0 +1,143 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// closedChannel is returned by Failover.CloseChannel when the Failover is not connected
+var closedChannel = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Failover is a Conn that connects to the first of a list of servers, and replaces its connection with one to the next
+// server once it fails, so that calls that are retried by a Caller (see WithRetry) fail over to the other servers (or
+// are retried against the same server if there is only a single address).
+type Failover struct {
+	mu           sync.Mutex
+	handlerTable frisbee.HandlerTable
+	ctx          context.Context
+	addrs        []string
+	next         int
+	opts         []frisbee.Option
+	client       *frisbee.Client
+	closed       bool
+}
+
+// NewFailover returns a new Failover that connects to the given addresses in order, with Clients
+// created using the given HandlerTable (see Caller.Register) and Options
+func NewFailover(handlerTable frisbee.HandlerTable, ctx context.Context, addrs []string, opts ...frisbee.Option) (*Failover, error) {
+	if len(addrs) == 0 {
+		return nil, frisbee.NoAddresses
+	}
+	if _, err := frisbee.NewClient(handlerTable, ctx, opts...); err != nil {
+		return nil, err
+	}
+	return &Failover{
+		handlerTable: handlerTable,
+		ctx:          ctx,
+		addrs:        addrs,
+		opts:         opts,
+	}, nil
+}
+
+// WritePacket writes p to the current server, connecting to it first if needed
+func (f *Failover) WritePacket(p *packet.Packet) error {
+	client, err := f.connect()
+	if err != nil {
+		return err
+	}
+	return client.WritePacket(p)
+}
+
+// CloseChannel returns the CloseChannel of the connection to the current server, connecting to it first if
+// needed, or a closed channel if it could not be connected to
+func (f *Failover) CloseChannel() <-chan struct{} {
+	client, err := f.connect()
+	if err != nil {
+		return closedChannel
+	}
+	return client.CloseChannel()
+}
+
+// OpenStream opens a new Stream on the connection to the current server, connecting to it first if needed
+func (f *Failover) OpenStream(id uint16, mode frisbee.StreamMode) (*frisbee.Stream, error) {
+	client, err := f.connect()
+	if err != nil {
+		return nil, err
+	}
+	return client.OpenStream(id, mode)
+}
+
+// Close closes the connection of the Failover, after which writes fail with frisbee.ConnectionClosed
+func (f *Failover) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.client != nil {
+		return f.client.Close()
+	}
+	return nil
+}
+
+// connect returns the Client of the current server, connecting a new one to the next server if the
+// connection to the current server failed
+func (f *Failover) connect() (*frisbee.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, frisbee.ConnectionClosed
+	}
+	if f.client != nil && !clientClosed(f.client) {
+		return f.client, nil
+	}
+	if f.client != nil {
+		_ = f.client.Close()
+		f.client = nil
+		f.next = (f.next + 1) % len(f.addrs)
+	}
+	client, err := frisbee.NewClient(f.handlerTable, f.ctx, f.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = client.Connect(f.addrs[f.next]); err != nil {
+		f.next = (f.next + 1) % len(f.addrs)
+		return nil, err
+	}
+	f.client = client
+	return client, nil
+}
+
+// clientClosed returns whether the client or its connection has been closed
+func clientClosed(client *frisbee.Client) bool {
+	if client.Closed() {
+		return true
+	}
+	select {
+	case <-client.CloseChannel():
+		return true
+	default:
+		return false
+	}
+}
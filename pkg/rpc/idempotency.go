@@ -0,0 +1,173 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Unary calls made by a Caller with WithIdempotencyKeys (or WithRetry) carry an IdempotencyKey, which is written in
+// front of the encoded request. A Router with WithIdempotencyCache strips the key from the request before calling the
+// handler, and since the key stays the same when a call is retried, the Router only handles every call once and
+// responds to retried calls with the response of the first one.
+
+const (
+	// IdempotencyKeySize is the size of an IdempotencyKey (in bytes)
+	IdempotencyKeySize = 16
+
+	// DefaultIdempotencyCacheSize is the default number of responses that an IdempotencyCache remembers
+	DefaultIdempotencyCacheSize = 1024
+
+	// DefaultIdempotencyTTL is the default amount of time that an IdempotencyCache remembers responses for
+	DefaultIdempotencyTTL = time.Minute
+)
+
+// IdempotencyKey identifies a unary call and all of its retries
+type IdempotencyKey [IdempotencyKeySize]byte
+
+// NewIdempotencyKey returns a new random IdempotencyKey
+func NewIdempotencyKey() (IdempotencyKey, error) {
+	var key IdempotencyKey
+	_, err := rand.Read(key[:])
+	return key, err
+}
+
+// idempotencyEntry is the response of a call in an IdempotencyCache, which is
+// nil if the handler did not write a response
+type idempotencyEntry struct {
+	key      IdempotencyKey
+	done     chan struct{}
+	response *packet.Packet
+	expires  time.Time
+}
+
+// IdempotencyCache remembers the responses of the unary calls handled by a Router (see WithIdempotencyCache),
+// so that retried calls are only handled once. It can be shared by Routers, and by servers that calls fail over
+// between (if they run in the same process).
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[IdempotencyKey]*idempotencyEntry
+	order   []*idempotencyEntry
+}
+
+// NewIdempotencyCache returns a new IdempotencyCache that remembers up to size responses for the
+// given amount of time (which default to DefaultIdempotencyCacheSize and DefaultIdempotencyTTL)
+func NewIdempotencyCache(size int, ttl time.Duration) *IdempotencyCache {
+	if size <= 0 {
+		size = DefaultIdempotencyCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &IdempotencyCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[IdempotencyKey]*idempotencyEntry),
+	}
+}
+
+// Len returns the number of calls that the cache remembers
+func (c *IdempotencyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// claim returns the entry for the given key, and whether it was created (in which case the caller must handle
+// the call and complete the entry)
+func (c *IdempotencyCache) claim(key IdempotencyKey) (*idempotencyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return entry, false
+	}
+	for len(c.order) >= c.size {
+		evicted := c.order[0]
+		c.order[0] = nil
+		c.order = c.order[1:]
+		if c.entries[evicted.key] == evicted {
+			delete(c.entries, evicted.key)
+		}
+	}
+	entry := &idempotencyEntry{key: key, done: make(chan struct{})}
+	c.entries[key] = entry
+	c.order = append(c.order, entry)
+	return entry, true
+}
+
+// complete records the response of the call of the given entry
+func (c *IdempotencyCache) complete(entry *idempotencyEntry, response *packet.Packet) {
+	c.mu.Lock()
+	entry.response = response
+	entry.expires = time.Now().Add(c.ttl)
+	c.mu.Unlock()
+	close(entry.done)
+}
+
+// idempotent wraps the handler of a unary method so that it strips the IdempotencyKey from requests, and only handles
+// the first request with every key. Requests whose key has already been handled are answered with the response that
+// handler returned for the first request (with the ID of the retried request), and requests that arrive while the
+// first request with the same key is still being handled wait for it to complete.
+func idempotent(cache *IdempotencyCache, handler frisbee.Handler) frisbee.Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		if incoming.Metadata.ContentLength < IdempotencyKeySize {
+			return handler(ctx, incoming)
+		}
+		var key IdempotencyKey
+		copy(key[:], *incoming.Content)
+		*incoming.Content = append((*incoming.Content)[:0], (*incoming.Content)[IdempotencyKeySize:incoming.Metadata.ContentLength]...)
+		incoming.Metadata.ContentLength -= IdempotencyKeySize
+
+		entry, claimed := cache.claim(key)
+		if !claimed {
+			select {
+			case <-entry.done:
+			case <-ctx.Done():
+				return nil, frisbee.NONE
+			}
+			if entry.response == nil {
+				return nil, frisbee.NONE
+			}
+			return copyPacket(entry.response, packet.Get(), incoming.Metadata.Id), frisbee.NONE
+		}
+
+		outgoing, action := handler(ctx, incoming)
+		if outgoing == nil || outgoing.Metadata.ContentLength != uint32(len(*outgoing.Content)) {
+			cache.complete(entry, nil)
+			return outgoing, action
+		}
+		cache.complete(entry, copyPacket(outgoing, packet.New(), outgoing.Metadata.Id))
+		return outgoing, action
+	}
+}
+
+// copyPacket copies the operation and content of p to the given packet, which is returned with the given ID
+func copyPacket(p *packet.Packet, to *packet.Packet, id uint16) *packet.Packet {
+	to.Metadata.Id = id
+	to.Metadata.Operation = p.Metadata.Operation
+	to.Content.Write(*p.Content)
+	to.Metadata.ContentLength = p.Metadata.ContentLength
+	return to
+}
@@ -0,0 +1,153 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	t.Parallel()
+
+	cache := NewIdempotencyCache(2, 0)
+	keys := make([]IdempotencyKey, 3)
+	for i := range keys {
+		keys[i][0] = byte(i + 1)
+	}
+
+	entry, claimed := cache.claim(keys[0])
+	require.True(t, claimed)
+	cache.complete(entry, nil)
+	_, claimed = cache.claim(keys[0])
+	assert.False(t, claimed)
+
+	_, claimed = cache.claim(keys[1])
+	assert.True(t, claimed)
+	_, claimed = cache.claim(keys[2])
+	assert.True(t, claimed)
+	assert.Equal(t, 2, cache.Len())
+
+	// The oldest key was evicted to make room for the newest one
+	_, claimed = cache.claim(keys[0])
+	assert.True(t, claimed)
+}
+
+func TestIdempotentHandler(t *testing.T) {
+	t.Parallel()
+
+	handled := atomic.NewInt32(0)
+	handler := idempotent(NewIdempotencyCache(0, 0), Handle(codec.Protobuf, errorOperation, func(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		handled.Inc()
+		return upper(ctx, req)
+	}))
+
+	key, err := NewIdempotencyKey()
+	require.NoError(t, err)
+	data, err := codec.Protobuf.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	// A retried request is answered with the response to the first request, without being handled again
+	for id := uint16(0); id < 2; id++ {
+		incoming := packet.Get()
+		incoming.Metadata.Id = id
+		incoming.Metadata.Operation = upperOperation
+		incoming.Content.Write(key[:])
+		incoming.Content.Write(data)
+		incoming.Metadata.ContentLength = uint32(len(*incoming.Content))
+
+		outgoing, action := handler(context.Background(), incoming)
+		assert.Equal(t, frisbee.NONE, action)
+		require.NotNil(t, outgoing)
+		assert.Equal(t, id, outgoing.Metadata.Id)
+		assert.Equal(t, uint16(upperOperation), outgoing.Metadata.Operation)
+		res, err := codec.Decode[wrapperspb.StringValue](codec.Protobuf, outgoing)
+		require.NoError(t, err)
+		assert.Equal(t, "HELLO", res.Value)
+		packet.Put(outgoing)
+		packet.Put(incoming)
+	}
+	assert.Equal(t, int32(1), handled.Load())
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	handled := atomic.NewInt32(0)
+	r := NewRouter(WithIdempotencyCache(NewIdempotencyCache(0, 0)))
+	require.NoError(t, r.Handle(upperOperation, Handle(codec.Protobuf, errorOperation, func(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		handled.Inc()
+		return upper(ctx, req)
+	})))
+	s, err := frisbee.NewServer(make(frisbee.HandlerTable), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, r.Register(s))
+	listener, err := net.Listen("tcp", conn.Listen)
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(listener)
+	}()
+	t.Cleanup(func() {
+		_ = s.Shutdown()
+	})
+
+	caller := NewCaller(codec.Protobuf, errorOperation, WithRetry(RetryPolicy{Backoff: 1}))
+	handlerTable := make(frisbee.HandlerTable)
+	require.NoError(t, caller.Register(handlerTable, upperOperation))
+
+	_, err = NewFailover(handlerTable, context.Background(), nil)
+	assert.ErrorIs(t, err, frisbee.NoAddresses)
+
+	// The first address refuses connections, so the call is retried against the server
+	refusing, err := net.Listen("tcp", conn.Listen)
+	require.NoError(t, err)
+	refused := refusing.Addr().String()
+	require.NoError(t, refusing.Close())
+
+	failover, err := NewFailover(handlerTable, context.Background(), []string{refused, listener.Addr().String()}, frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	res, err := Call[wrapperspb.StringValue, wrapperspb.StringValue](context.Background(), caller, failover, upperOperation, wrapperspb.String("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", res.Value)
+	assert.Equal(t, int32(1), handled.Load())
+
+	// Errors returned by the handler are not retried
+	_, err = Call[wrapperspb.StringValue, wrapperspb.StringValue](context.Background(), caller, failover, upperOperation, wrapperspb.String(""))
+	var remote RemoteError
+	require.ErrorAs(t, err, &remote)
+	assert.True(t, strings.Contains(remote.Error(), "empty request"))
+	assert.Equal(t, int32(2), handled.Load())
+
+	require.NoError(t, failover.Close())
+	_, err = Call[wrapperspb.StringValue, wrapperspb.StringValue](context.Background(), caller, failover, upperOperation, wrapperspb.String("hello"))
+	assert.ErrorIs(t, err, frisbee.ConnectionClosed)
+}
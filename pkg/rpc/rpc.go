@@ -0,0 +1,63 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package rpc is the runtime used by clients and servers generated by protoc-gen-frisbee, and maps typed
+// calls and streams onto frisbee operations and Streams.
+//
+// Unary calls are sent as a packet with the method's operation, and the response is sent back with the
+// same packet ID and operation. If the server's handler fails, it responds with a packet with the service's
+// error operation instead, whose content is the error message (which is returned to the caller as a RemoteError).
+//
+// Streaming calls open a new MessageMode frisbee.Stream, and the first packet on the stream is a header that
+// contains the method's operation (as a big-endian uint16) so that the server can route the stream to its handler.
+// Every later packet on the stream is a frame whose first byte is its kind: message frames contain an encoded
+// message, end frames signal that the sender has finished sending messages (and, when sent by the server, that the
+// call succeeded), and error frames signal that the server's handler failed and contain the error's message.
+package rpc
+
+import (
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+)
+
+// These are various errors that can be returned by clients and servers:
+var (
+	OperationInUse      = errors.New("operation is already in use")
+	InvalidStreamHeader = errors.New("invalid stream header")
+	UnknownMethod       = errors.New("unknown method")
+	InvalidFrame        = errors.New("invalid stream frame")
+	SendClosed          = errors.New("stream was closed for sending")
+	StreamCanceled      = errors.New("stream was closed before it completed")
+	MissingRequest      = errors.New("stream ended before the request was received")
+)
+
+// streamHeaderSize is the size of the header that is sent as the first packet of a stream
+const streamHeaderSize = 2
+
+// RemoteError is returned by calls when the server's handler returned an error, and holds the error's message
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+// Conn is a frisbee connection that calls can be made on (like *frisbee.Client or *frisbee.Async)
+type Conn interface {
+	WritePacket(*packet.Packet) error
+	CloseChannel() <-chan struct{}
+	OpenStream(id uint16, mode frisbee.StreamMode) (*frisbee.Stream, error)
+}
@@ -0,0 +1,249 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	errorOperation = uint16(10 + iota)
+	upperOperation
+	countOperation
+	sumOperation
+	chatOperation
+	unknownOperation
+)
+
+func upper(_ context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	if req.Value == "" {
+		return nil, errors.New("empty request")
+	}
+	return wrapperspb.String(strings.ToUpper(req.Value)), nil
+}
+
+func count(req *wrapperspb.UInt32Value, stream Sender[wrapperspb.UInt32Value]) error {
+	if req.Value > 10 {
+		return errors.New("count too large")
+	}
+	for i := uint32(0); i < req.Value; i++ {
+		if err := stream.Send(wrapperspb.UInt32(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sum(stream Receiver[wrapperspb.Int64Value]) (*wrapperspb.Int64Value, error) {
+	var total int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return wrapperspb.Int64(total), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		total += req.Value
+	}
+}
+
+func chat(stream *Stream[wrapperspb.StringValue, wrapperspb.StringValue]) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(wrapperspb.String(strings.ToUpper(msg.Value))); err != nil {
+			return err
+		}
+	}
+}
+
+func newTestPair(t *testing.T) (*Caller, *frisbee.Client, *frisbee.Server) {
+	emptyLogger := zerolog.New(io.Discard)
+
+	r := NewRouter()
+	require.NoError(t, r.Handle(upperOperation, Handle(codec.Protobuf, errorOperation, upper)))
+	require.NoError(t, r.HandleStream(countOperation, ServerStreamingHandler(codec.Protobuf, count)))
+	require.NoError(t, r.HandleStream(sumOperation, ClientStreamingHandler(codec.Protobuf, sum)))
+	require.NoError(t, r.HandleStream(chatOperation, BidiStreamingHandler(codec.Protobuf, chat)))
+	assert.ErrorIs(t, r.HandleStream(upperOperation, BidiStreamingHandler(codec.Protobuf, chat)), OperationInUse)
+
+	s, err := frisbee.NewServer(make(frisbee.HandlerTable), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, r.Register(s))
+	assert.ErrorIs(t, r.Register(s), OperationInUse)
+
+	caller := NewCaller(codec.Protobuf, errorOperation)
+	handlerTable := make(frisbee.HandlerTable)
+	require.NoError(t, caller.Register(handlerTable, upperOperation))
+	assert.ErrorIs(t, caller.Register(handlerTable, upperOperation), OperationInUse)
+
+	c, err := frisbee.NewClient(handlerTable, context.Background(), frisbee.WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	require.NoError(t, c.FromConn(clientConn))
+
+	return caller, c, s
+}
+
+func TestUnary(t *testing.T) {
+	t.Parallel()
+
+	caller, c, s := newTestPair(t)
+
+	res, err := Call[wrapperspb.StringValue, wrapperspb.StringValue](context.Background(), caller, c, upperOperation, wrapperspb.String("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", res.Value)
+
+	_, err = Call[wrapperspb.StringValue, wrapperspb.StringValue](context.Background(), caller, c, upperOperation, wrapperspb.String(""))
+	assert.Equal(t, RemoteError("empty request"), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Call[wrapperspb.StringValue, wrapperspb.StringValue](ctx, caller, c, upperOperation, wrapperspb.String("hello"))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestServerStreaming(t *testing.T) {
+	t.Parallel()
+
+	caller, c, s := newTestPair(t)
+
+	stream, err := ServerStreaming[wrapperspb.UInt32Value, wrapperspb.UInt32Value](caller, c, countOperation, wrapperspb.UInt32(3))
+	require.NoError(t, err)
+	assert.ErrorIs(t, stream.Send(wrapperspb.UInt32(3)), SendClosed)
+	for i := uint32(0); i < 3; i++ {
+		msg, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, i, msg.Value)
+	}
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	stream, err = ServerStreaming[wrapperspb.UInt32Value, wrapperspb.UInt32Value](caller, c, countOperation, wrapperspb.UInt32(11))
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, RemoteError("count too large"), err)
+
+	stream, err = OpenStream[wrapperspb.UInt32Value, wrapperspb.UInt32Value](caller, c, countOperation)
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.Equal(t, RemoteError(MissingRequest.Error()), err)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestClientStreaming(t *testing.T) {
+	t.Parallel()
+
+	caller, c, s := newTestPair(t)
+
+	stream, err := ClientStreaming[wrapperspb.Int64Value, wrapperspb.Int64Value](caller, c, sumOperation)
+	require.NoError(t, err)
+	for _, value := range []int64{1, 2, 3} {
+		require.NoError(t, stream.Send(wrapperspb.Int64(value)))
+	}
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), res.Value)
+
+	stream, err = ClientStreaming[wrapperspb.Int64Value, wrapperspb.Int64Value](caller, c, sumOperation)
+	require.NoError(t, err)
+	res, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), res.Value)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestBidiStreaming(t *testing.T) {
+	t.Parallel()
+
+	caller, c, s := newTestPair(t)
+
+	stream, err := OpenStream[wrapperspb.StringValue, wrapperspb.StringValue](caller, c, chatOperation)
+	require.NoError(t, err)
+	for _, value := range []string{"first", "second", "third"} {
+		require.NoError(t, stream.Send(wrapperspb.String(value)))
+		msg, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToUpper(value), msg.Value)
+	}
+	require.NoError(t, stream.CloseSend())
+	assert.ErrorIs(t, stream.CloseSend(), SendClosed)
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	stream, err = OpenStream[wrapperspb.StringValue, wrapperspb.StringValue](caller, c, chatOperation)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(wrapperspb.String("first")))
+	require.NoError(t, stream.Close())
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	assert.ErrorIs(t, err, StreamCanceled)
+
+	stream, err = OpenStream[wrapperspb.StringValue, wrapperspb.StringValue](caller, c, unknownOperation)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, RemoteError(UnknownMethod.Error()), err)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,207 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+)
+
+// StreamHandler handles a stream that was opened for a streaming method. Once the handler returns, the stream is
+// ended with its result (see Stream) and closed.
+type StreamHandler func(stream *frisbee.Stream) error
+
+// Router collects the handlers of generated servers so they can be registered with a frisbee.Server together.
+type Router struct {
+	handlers frisbee.HandlerTable
+	streams  map[uint16]StreamHandler
+	cache    *IdempotencyCache
+}
+
+// RouterOption configures a Router (see NewRouter)
+type RouterOption func(*Router)
+
+// WithIdempotencyCache makes the Router strip the IdempotencyKey from the requests of unary calls, and only handle
+// the first call with every key, responding to retried calls with the response of the first call (which is remembered
+// by the given IdempotencyCache). Every unary call must then be made by a Caller with WithIdempotencyKeys or WithRetry.
+func WithIdempotencyCache(cache *IdempotencyCache) RouterOption {
+	return func(r *Router) {
+		r.cache = cache
+	}
+}
+
+// NewRouter returns a new, empty Router
+func NewRouter(options ...RouterOption) *Router {
+	r := &Router{
+		handlers: make(frisbee.HandlerTable),
+		streams:  make(map[uint16]StreamHandler),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// Handle adds the handler for the unary method with the given operation
+func (r *Router) Handle(operation uint16, handler frisbee.Handler) error {
+	if err := r.available(operation); err != nil {
+		return err
+	}
+	if r.cache != nil {
+		handler = idempotent(r.cache, handler)
+	}
+	r.handlers[operation] = handler
+	return nil
+}
+
+// HandleStream adds the handler for the streaming method with the given operation
+func (r *Router) HandleStream(operation uint16, handler StreamHandler) error {
+	if err := r.available(operation); err != nil {
+		return err
+	}
+	r.streams[operation] = handler
+	return nil
+}
+
+// Register adds the Router's handlers to the handler table of the server, and sets the server's
+// stream handler so that streams are routed to the handlers of their methods.
+//
+// This function should not be called once the server has started.
+func (r *Router) Register(s *frisbee.Server) error {
+	handlerTable := make(frisbee.HandlerTable)
+	for operation, handler := range s.GetHandlerTable() {
+		handlerTable[operation] = handler
+	}
+	for operation, handler := range r.handlers {
+		if _, ok := handlerTable[operation]; ok {
+			return OperationInUse
+		}
+		handlerTable[operation] = handler
+	}
+	err := s.SetHandlerTable(handlerTable)
+	if err != nil {
+		return err
+	}
+	return s.SetStreamHandler(r.handleStream)
+}
+
+// available returns OperationInUse if the operation already has a handler
+func (r *Router) available(operation uint16) error {
+	if _, ok := r.handlers[operation]; ok {
+		return OperationInUse
+	}
+	if _, ok := r.streams[operation]; ok {
+		return OperationInUse
+	}
+	return nil
+}
+
+// handleStream reads the header of a new stream and calls the handler for its operation, and then ends the
+// stream with the result of the handler
+func (r *Router) handleStream(conn *frisbee.Async, stream *frisbee.Stream) {
+	p, err := stream.ReadPacket()
+	if err != nil {
+		return
+	}
+	var handler StreamHandler
+	if p.Metadata.ContentLength == streamHeaderSize {
+		handler = r.streams[binary.BigEndian.Uint16((*p.Content)[:streamHeaderSize])]
+	}
+	packet.Put(p)
+	if handler == nil {
+		err = UnknownMethod
+	} else {
+		err = handler(stream)
+	}
+	if err != nil {
+		conn.Logger().Debug().Err(err).Uint16("Stream ID", stream.ID()).Msg("error while handling stream")
+		if !errors.Is(err, StreamCanceled) {
+			_ = writeFrame(stream, frameError, []byte(err.Error()))
+		}
+	} else {
+		_ = writeFrame(stream, frameEnd, nil)
+	}
+	_ = stream.Close()
+}
+
+// ServerStreamingHandler returns a StreamHandler for a server-streaming method, which receives a single request
+// and then calls f to send any number of responses.
+func ServerStreamingHandler[Req, Res any](c codec.Codec, f func(req *Req, stream Sender[Res]) error) StreamHandler {
+	return func(stream *frisbee.Stream) error {
+		s := NewStream[Res, Req](stream, c)
+		req, err := s.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = MissingRequest
+			}
+			return err
+		}
+		return f(req, s)
+	}
+}
+
+// ClientStreamingHandler returns a StreamHandler for a client-streaming method, which calls f to receive any number
+// of requests and then sends the response returned by f.
+func ClientStreamingHandler[Req, Res any](c codec.Codec, f func(stream Receiver[Req]) (*Res, error)) StreamHandler {
+	return func(stream *frisbee.Stream) error {
+		s := NewStream[Res, Req](stream, c)
+		res, err := f(s)
+		if err != nil {
+			return err
+		}
+		return s.Send(res)
+	}
+}
+
+// BidiStreamingHandler returns a StreamHandler for a bidirectional streaming method, which calls f to
+// send and receive any number of messages.
+func BidiStreamingHandler[Req, Res any](c codec.Codec, f func(stream *Stream[Res, Req]) error) StreamHandler {
+	return func(stream *frisbee.Stream) error {
+		return f(NewStream[Res, Req](stream, c))
+	}
+}
+
+// Handle returns a frisbee.Handler for a unary method, which decodes requests using c and responds with the result
+// of f. If f returns an error, the response is sent with the errorOperation and contains the error's message.
+func Handle[Req, Res any](c codec.Codec, errorOperation uint16, f func(context.Context, *Req) (*Res, error)) frisbee.Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		outgoing := packet.Get()
+		outgoing.Metadata.Id = incoming.Metadata.Id
+		outgoing.Metadata.Operation = incoming.Metadata.Operation
+		req, err := codec.Decode[Req](c, incoming)
+		if err == nil {
+			var res *Res
+			res, err = f(ctx, req)
+			if err == nil {
+				err = codec.Encode(c, outgoing, res)
+			}
+		}
+		if err != nil {
+			outgoing.Metadata.Operation = errorOperation
+			outgoing.Content.Reset()
+			outgoing.Content.Write([]byte(err.Error()))
+			outgoing.Metadata.ContentLength = uint32(len(err.Error()))
+		}
+		return outgoing, frisbee.NONE
+	}
+}
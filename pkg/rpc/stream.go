@@ -0,0 +1,175 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package rpc
+
+import (
+	"io"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+// These are the kinds of frames that are sent on a stream after its header, and are written as the first byte
+// of the content of every packet
+const (
+	// frameMessage frames contain an encoded message
+	frameMessage = byte(iota)
+
+	// frameEnd frames signal that the sender will not send any more messages
+	frameEnd
+
+	// frameError frames signal that the server's handler failed, and contain the error's message
+	frameError
+)
+
+// Sender is the sending side of a stream
+type Sender[T any] interface {
+	Send(msg *T) error
+}
+
+// Receiver is the receiving side of a stream
+type Receiver[T any] interface {
+	Recv() (*T, error)
+}
+
+// Stream is a typed frisbee.Stream for a streaming method, which sends messages of type Send and receives
+// messages of type Recv.
+//
+// Either side of a stream can signal that it has finished sending messages with CloseSend, after which the peer's
+// Recv returns io.EOF. Once the server's handler returns, the server ends the stream with the handler's result, so
+// the client's Recv returns io.EOF if the handler succeeded or a RemoteError if it failed.
+type Stream[Send, Recv any] struct {
+	stream *frisbee.Stream
+	codec  codec.Codec
+
+	sendClosed *atomic.Bool
+	recvErr    error
+}
+
+// NewStream returns a new Stream that encodes its messages using c
+func NewStream[Send, Recv any](stream *frisbee.Stream, c codec.Codec) *Stream[Send, Recv] {
+	return &Stream[Send, Recv]{
+		stream:     stream,
+		codec:      c,
+		sendClosed: atomic.NewBool(false),
+	}
+}
+
+// Send sends msg to the peer, and returns SendClosed if CloseSend has already been called
+func (s *Stream[Send, Recv]) Send(msg *Send) error {
+	if s.sendClosed.Load() {
+		return SendClosed
+	}
+	data, err := s.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p := packet.Get()
+	p.Content.Write([]byte{frameMessage})
+	p.Content.Write(data)
+	p.Metadata.ContentLength = uint32(len(data) + 1)
+	err = s.stream.WritePacket(p)
+	packet.Put(p)
+	return err
+}
+
+// CloseSend signals to the peer that no more messages will be sent, while still allowing messages to be received
+func (s *Stream[Send, Recv]) CloseSend() error {
+	if !s.sendClosed.CompareAndSwap(false, true) {
+		return SendClosed
+	}
+	return writeFrame(s.stream, frameEnd, nil)
+}
+
+// Recv blocks until the next message has been received from the peer. It returns io.EOF once the peer has finished
+// sending messages, a RemoteError if the server's handler failed, and StreamCanceled if the stream was closed before
+// it completed.
+func (s *Stream[Send, Recv]) Recv() (*Recv, error) {
+	if s.recvErr != nil {
+		return nil, s.recvErr
+	}
+	p, err := s.stream.ReadPacket()
+	if err != nil {
+		if errors.Is(err, frisbee.StreamClosed) {
+			err = StreamCanceled
+		}
+		return nil, err
+	}
+	defer packet.Put(p)
+	content := (*p.Content)[:p.Metadata.ContentLength]
+	if len(content) == 0 {
+		return nil, InvalidFrame
+	}
+	switch content[0] {
+	case frameMessage:
+		msg := new(Recv)
+		if err = s.codec.Unmarshal(content[1:], msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case frameEnd:
+		s.recvErr = io.EOF
+	case frameError:
+		s.recvErr = RemoteError(content[1:])
+	default:
+		return nil, InvalidFrame
+	}
+	return nil, s.recvErr
+}
+
+// Close closes the stream. If the stream has not completed yet, the peer's Recv returns StreamCanceled.
+func (s *Stream[Send, Recv]) Close() error {
+	return s.stream.Close()
+}
+
+// ClientStream is the client's side of a client-streaming method, which sends any number of requests and then
+// receives a single response.
+type ClientStream[Req, Res any] struct {
+	*Stream[Req, Res]
+}
+
+// CloseAndRecv signals to the server that all the requests have been sent, and waits for the server's response
+func (s *ClientStream[Req, Res]) CloseAndRecv() (*Res, error) {
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+	res, err := s.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.Recv(); err != io.EOF {
+		if err == nil {
+			err = InvalidFrame
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// writeFrame writes a frame of the given kind with the given content to stream
+func writeFrame(stream *frisbee.Stream, kind byte, content []byte) error {
+	p := packet.Get()
+	p.Content.Write([]byte{kind})
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content) + 1)
+	err := stream.WritePacket(p)
+	packet.Put(p)
+	return err
+}
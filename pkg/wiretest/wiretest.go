@@ -0,0 +1,200 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package wiretest provides a deterministic harness for checking that two frisbee connection
+// implementations (like frisbee.Async and frisbee.Sync) agree on the wire format.
+//
+// Each Scenario is a fixed sequence of packets that is written by one peer and read back by the other, and
+// covers a specific part of the wire format (like empty packets, payloads that are larger than the read buffer,
+// or bursts of packets that are coalesced into a single write).
+package wiretest
+
+import (
+	"bytes"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+)
+
+// reservedOperations is the number of operations that frisbee reserves for its internal control
+// packets (like PING and PONG), which may be sent by a peer at any time
+const reservedOperations = 10
+
+// bufferSize is the size of the read and write buffers used by frisbee connections
+const bufferSize = 1 << 16
+
+// Peer is a frisbee connection that can write and read packets (like *frisbee.Async and *frisbee.Sync)
+type Peer interface {
+	WritePacket(*packet.Packet) error
+	ReadPacket() (*packet.Packet, error)
+}
+
+// Packet is a single packet in a Scenario
+type Packet struct {
+	Id        uint16
+	Operation uint16
+	Content   []byte
+}
+
+// Scenario is a named sequence of packets that is written by one peer and read by the other
+type Scenario struct {
+	Name    string
+	Packets []Packet
+}
+
+// Scenarios returns the default set of scenarios. The content of every packet is
+// deterministic, so the same scenarios are returned every time.
+func Scenarios() []Scenario {
+	small := make([]Packet, 64)
+	for i := range small {
+		small[i] = packetOf(uint16(i), uint16(reservedOperations+i), i+1)
+	}
+
+	burst := make([]Packet, 4096)
+	for i := range burst {
+		burst[i] = packetOf(uint16(i), 32, 128)
+	}
+
+	return []Scenario{
+		{
+			Name:    "empty",
+			Packets: []Packet{packetOf(64, 32, 0)},
+		},
+		{
+			Name: "limits",
+			Packets: []Packet{
+				packetOf(0, reservedOperations, 1),
+				packetOf(0xFFFF, 0xFFFF, 1),
+				packetOf(0xFFFF, reservedOperations, 0),
+			},
+		},
+		{
+			Name:    "small",
+			Packets: small,
+		},
+		{
+			Name: "buffer boundaries",
+			Packets: []Packet{
+				packetOf(1, 32, metadata.Size-1),
+				packetOf(2, 32, bufferSize-metadata.Size),
+				packetOf(3, 32, bufferSize),
+				packetOf(4, 32, bufferSize+1),
+				packetOf(5, 32, 0),
+			},
+		},
+		{
+			Name: "large",
+			Packets: []Packet{
+				packetOf(1, 32, bufferSize*64),
+				packetOf(2, 33, 1),
+				packetOf(3, 34, bufferSize*16+metadata.Size),
+			},
+		},
+		{
+			Name:    "burst",
+			Packets: burst,
+		},
+	}
+}
+
+// Run writes the packets of s using writer while reading them with reader, and returns an error if any packet
+// is not read back intact and in order. Control packets read by the reader are skipped, since some peers
+// (like frisbee.Async) send PING packets at regular intervals.
+func Run(s Scenario, writer Peer, reader Peer) error {
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- write(s, writer)
+	}()
+
+	readErr := read(s, reader)
+	if err := <-writeErr; err != nil {
+		return errors.Wrapf(err, "%s: error while writing packet", s.Name)
+	}
+	return readErr
+}
+
+// RunAll runs every scenario returned by Scenarios, and returns the first error
+func RunAll(writer Peer, reader Peer) error {
+	for _, s := range Scenarios() {
+		if err := Run(s, writer, reader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func write(s Scenario, writer Peer) error {
+	for _, expected := range s.Packets {
+		p := packet.Get()
+		p.Metadata.Id = expected.Id
+		p.Metadata.Operation = expected.Operation
+		p.Content.Write(expected.Content)
+		p.Metadata.ContentLength = uint32(len(expected.Content))
+		err := writer.WritePacket(p)
+		packet.Put(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func read(s Scenario, reader Peer) error {
+	for i, expected := range s.Packets {
+		p, err := reader.ReadPacket()
+		for err == nil && p.Metadata.Operation < reservedOperations {
+			packet.Put(p)
+			p, err = reader.ReadPacket()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%s: error while reading packet %d", s.Name, i)
+		}
+		err = compare(expected, p)
+		packet.Put(p)
+		if err != nil {
+			return errors.Wrapf(err, "%s: packet %d", s.Name, i)
+		}
+	}
+	return nil
+}
+
+func compare(expected Packet, p *packet.Packet) error {
+	switch {
+	case p.Metadata.Id != expected.Id:
+		return errors.Errorf("expected id %d, got %d", expected.Id, p.Metadata.Id)
+	case p.Metadata.Operation != expected.Operation:
+		return errors.Errorf("expected operation %d, got %d", expected.Operation, p.Metadata.Operation)
+	case int(p.Metadata.ContentLength) != len(expected.Content):
+		return errors.Errorf("expected content length %d, got %d", len(expected.Content), p.Metadata.ContentLength)
+	case len(*p.Content) != len(expected.Content):
+		return errors.Errorf("expected %d bytes of content, got %d", len(expected.Content), len(*p.Content))
+	case !bytes.Equal(*p.Content, expected.Content):
+		return errors.New("content does not match")
+	}
+	return nil
+}
+
+// packetOf returns a packet with size bytes of deterministic content that depends on the id and operation
+func packetOf(id uint16, operation uint16, size int) Packet {
+	content := make([]byte, size)
+	seed := uint32(id)<<16 | uint32(operation)
+	for i := range content {
+		seed = seed*1664525 + 1013904223
+		content[i] = byte(seed >> 24)
+	}
+	return Packet{Id: id, Operation: operation, Content: content}
+}
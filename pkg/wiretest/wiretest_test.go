@@ -0,0 +1,75 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wiretest
+
+import (
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// channelPeer passes packets through a channel, optionally modifying them on the way
+type channelPeer struct {
+	packets chan *packet.Packet
+	modify  func(p *packet.Packet)
+}
+
+func (c *channelPeer) WritePacket(p *packet.Packet) error {
+	copied := packet.Get()
+	copied.Metadata.Id = p.Metadata.Id
+	copied.Metadata.Operation = p.Metadata.Operation
+	copied.Content.Write(*p.Content)
+	copied.Metadata.ContentLength = p.Metadata.ContentLength
+	if c.modify != nil {
+		c.modify(copied)
+	}
+	c.packets <- copied
+	return nil
+}
+
+func (c *channelPeer) ReadPacket() (*packet.Packet, error) {
+	return <-c.packets, nil
+}
+
+func TestScenarios(t *testing.T) {
+	t.Parallel()
+
+	first := Scenarios()
+	second := Scenarios()
+	require.Equal(t, len(first), len(second))
+	for i := range first {
+		assert.Equal(t, first[i], second[i])
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	peer := &channelPeer{packets: make(chan *packet.Packet, 16)}
+	assert.NoError(t, RunAll(peer, peer))
+
+	s := Scenario{Name: "control", Packets: []Packet{packetOf(1, 32, 16)}}
+	peer.packets <- packet.Get()
+	assert.NoError(t, Run(s, peer, peer))
+
+	peer.modify = func(p *packet.Packet) {
+		(*p.Content)[0]++
+	}
+	assert.Error(t, Run(s, peer, peer))
+}
@@ -0,0 +1,192 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Priority is the class of a packet in the outbound path of a connection that schedules its packets by priority
+// (see WithPriorities), where packets of higher priority classes overtake the packets of lower ones that are still
+// waiting to be written.
+type Priority uint8
+
+const (
+	// PriorityControl packets are written to the write buffer right away, ahead of every queued packet.
+	// Internal control packets (like PING, PONG, and REKEY packets) always use this priority.
+	PriorityControl Priority = iota
+
+	// PriorityHigh is used for small urgent packets
+	PriorityHigh
+
+	// PriorityNormal is used by WritePacket and the packets of streams
+	PriorityNormal
+
+	// PriorityBulk is used for large transfers that can wait for more urgent packets
+	PriorityBulk
+)
+
+// maxQueuedBytes is the number of bytes that can be queued in every priority class before writers of that class block
+const maxQueuedBytes = DefaultBufferSize * 4
+
+// PriorityWeights are the number of queued packets of every priority class that are written in each round of the
+// weighted round-robin that drains the outbound queues of a connection. Zero values are replaced with the values from
+// DefaultPriorityWeights.
+type PriorityWeights struct {
+	High   int
+	Normal int
+	Bulk   int
+}
+
+// DefaultPriorityWeights are the PriorityWeights used by WithPriorities by default
+var DefaultPriorityWeights = PriorityWeights{
+	High:   8,
+	Normal: 4,
+	Bulk:   1,
+}
+
+// withDefaults replaces the zero values of w with the values from DefaultPriorityWeights
+func (w PriorityWeights) withDefaults() PriorityWeights {
+	if w.High <= 0 {
+		w.High = DefaultPriorityWeights.High
+	}
+	if w.Normal <= 0 {
+		w.Normal = DefaultPriorityWeights.Normal
+	}
+	if w.Bulk <= 0 {
+		w.Bulk = DefaultPriorityWeights.Bulk
+	}
+	return w
+}
+
+// queuedPacket is a packet that is waiting in an outbound queue, along with its encoded header and content
+type queuedPacket struct {
+	p       *packet.Packet
+	header  []byte
+	content []byte
+	token   *WriteToken
+}
+
+// outbound holds the outbound queues of a connection that schedules its packets by priority
+type outbound struct {
+	weights [3]int
+	queues  [3][]*queuedPacket
+	bytes   [3]int
+	queued  int
+	cond    *sync.Cond
+}
+
+// newOutbound returns the outbound queues for a connection that is guarded by mu
+func newOutbound(weights PriorityWeights, mu sync.Locker) *outbound {
+	weights = weights.withDefaults()
+	return &outbound{
+		weights: [3]int{weights.High, weights.Normal, weights.Bulk},
+		cond:    sync.NewCond(mu),
+	}
+}
+
+// WritePacketPriority is like WritePacket, but the packet is written with the given Priority. Priorities only have an
+// effect on connections that schedule their packets by priority (see WithPriorities), and all packets are written in
+// the order that they were written in otherwise.
+func (c *Async) WritePacketPriority(p *packet.Packet, priority Priority) error {
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if priority > PriorityBulk {
+		return InvalidPriority
+	}
+	return c.writePacketPriority(p, priority, nil)
+}
+
+// enqueue adds the encoded packet p to the outbound queue of its priority, blocking while the queue is full
+func (c *Async) enqueue(p *packet.Packet, priority Priority, header []byte, content []byte, token *WriteToken) error {
+	if c.tracksActivity() {
+		c.markActive()
+	}
+
+	class := int(priority - PriorityHigh)
+	c.Lock()
+	for !c.closed.Load() && c.outbound.bytes[class] >= maxQueuedBytes {
+		c.outbound.cond.Wait()
+	}
+	if c.closed.Load() {
+		c.Unlock()
+		return ConnectionClosed
+	}
+
+	q := &queuedPacket{p: packet.Get(), token: token}
+	*q.p.Metadata = *p.Metadata
+	q.p.Content.Write(*p.Content)
+	encoded := make([]byte, len(header)+len(content))
+	q.header = encoded[:copy(encoded, header)]
+	q.content = encoded[len(header):]
+	copy(q.content, content)
+	c.outbound.queues[class] = append(c.outbound.queues[class], q)
+	c.outbound.bytes[class] += len(encoded)
+	c.outbound.queued++
+
+	c.startFlushLoop()
+	c.signalFlush()
+	c.Unlock()
+	return nil
+}
+
+// drain writes queued packets to the write buffer using a weighted round-robin between the priority classes, until
+// the queues are empty or a full write buffer of packets has been written. It must be called with the connection locked.
+func (c *Async) drain() error {
+	o := c.outbound
+	defer o.cond.Broadcast()
+	var written int
+	for written < DefaultBufferSize && o.queued > 0 {
+		for class := range o.queues {
+			for i := 0; i < o.weights[class] && len(o.queues[class]) > 0; i++ {
+				q := o.queues[class][0]
+				o.queues[class][0] = nil
+				o.queues[class] = o.queues[class][1:]
+				o.bytes[class] -= len(q.header) + len(q.content)
+				o.queued--
+				err := c.emit(q.p, q.header, q.content, q.token)
+				packet.Put(q.p)
+				if err != nil {
+					return err
+				}
+				written += len(q.header) + len(q.content)
+			}
+		}
+	}
+	return nil
+}
+
+// dropQueued discards the packets that are still queued when the connection is closed, resolving their tokens.
+// It must be called with the connection locked.
+func (c *Async) dropQueued() {
+	o := c.outbound
+	for class := range o.queues {
+		for _, q := range o.queues[class] {
+			if q.token != nil {
+				q.token.resolve(WriteDropped, ConnectionClosed)
+			}
+			packet.Put(q.p)
+		}
+		o.queues[class] = nil
+		o.bytes[class] = 0
+	}
+	o.queued = 0
+	o.cond.Broadcast()
+}
@@ -0,0 +1,96 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityWeights(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultPriorityWeights, PriorityWeights{}.withDefaults())
+	assert.Equal(t, PriorityWeights{High: 2, Normal: 4, Bulk: 1}, PriorityWeights{High: 2}.withDefaults())
+	assert.Nil(t, loadOptions().Priorities)
+	assert.Equal(t, &PriorityWeights{Bulk: 3}, loadOptions(WithPriorities(PriorityWeights{Bulk: 3})).Priorities)
+}
+
+func TestAsyncPriorities(t *testing.T) {
+	t.Parallel()
+
+	const bulkSize = 1 << 15
+	const bulkPackets = 8
+	const urgentID = 1024
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), WithPriorities(DefaultPriorityWeights)), NoFeatures)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	assert.ErrorIs(t, writerConn.WritePacketPriority(p, PriorityBulk+1), InvalidPriority)
+	p.Metadata.Operation = PING
+	assert.ErrorIs(t, writerConn.WritePacketPriority(p, PriorityHigh), InvalidOperation)
+	packet.Put(p)
+
+	content := make([]byte, bulkSize)
+	for i := 0; i < bulkPackets; i++ {
+		p := packet.Get()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = metadata.PacketPing
+		p.Content.Write(content)
+		p.Metadata.ContentLength = bulkSize
+		require.NoError(t, writerConn.WritePacketPriority(p, PriorityBulk))
+		packet.Put(p)
+	}
+
+	// The pipe blocks until the reader reads, so most of the bulk packets are still queued
+	p = packet.Get()
+	p.Metadata.Id = urgentID
+	p.Metadata.Operation = metadata.PacketPing
+	require.NoError(t, writerConn.WritePacketPriority(p, PriorityHigh))
+	packet.Put(p)
+
+	urgent := -1
+	next := uint16(0)
+	for i := 0; i < bulkPackets+1; i++ {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		if p.Metadata.Id == urgentID {
+			urgent = i
+		} else {
+			assert.Equal(t, next, p.Metadata.Id)
+			next++
+		}
+		packet.Put(p)
+	}
+	assert.GreaterOrEqual(t, urgent, 0)
+	assert.Less(t, urgent, bulkPackets)
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+}
@@ -0,0 +1,127 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ProbeStatus is the state of a frisbee server reported by its probe endpoints (see Server.ProbeHandler)
+type ProbeStatus struct {
+	// Live is false once the server has been shut down
+	Live bool `json:"live"`
+
+	// Ready is whether the server has started and is neither draining nor shut down
+	Ready bool `json:"ready"`
+
+	// Draining is whether the server is draining (see Server.Drain) or shutting down
+	Draining bool `json:"draining"`
+
+	// Connections is the number of connections that the server is handling
+	Connections int `json:"connections"`
+}
+
+// Drain marks the server as draining, which fails its readiness probe and makes it report HealthDraining to
+// health checks, so that load balancers stop sending it new traffic before it is shut down. Existing and new
+// connections are still handled until Shutdown is called.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Draining returns whether the server is draining or shutting down
+func (s *Server) Draining() bool {
+	return s.draining.Load() || s.shutdown.Load()
+}
+
+// ProbeStatus returns the current state of the server as reported by its probe endpoints
+func (s *Server) ProbeStatus() ProbeStatus {
+	status := ProbeStatus{
+		Live:     !s.shutdown.Load(),
+		Draining: s.Draining(),
+	}
+	select {
+	case <-s.startedCh:
+		status.Ready = !status.Draining
+	default:
+	}
+	s.connectionsMu.Lock()
+	status.Connections = len(s.connections)
+	s.connectionsMu.Unlock()
+	return status
+}
+
+// ProbeHandler returns an http.Handler that exposes the state of the server for Kubernetes probes and load balancers,
+// which can be mounted on an existing HTTP server or served with StartProbes. It serves the following endpoints:
+//
+//	/livez:  200 until the server has been shut down, and 503 afterwards
+//	/readyz: 200 once the server has started, and 503 before that or while it is draining or shutting down
+//	/status: the ProbeStatus of the server as JSON
+func (s *Server) ProbeHandler() http.Handler {
+	mux := http.NewServeMux()
+	probe := func(ok func(ProbeStatus) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			if ok(s.ProbeStatus()) {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte("ok\n"))
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("unavailable\n"))
+		}
+	}
+	mux.Handle("/livez", probe(func(status ProbeStatus) bool {
+		return status.Live
+	}))
+	mux.Handle("/readyz", probe(func(status ProbeStatus) bool {
+		return status.Ready
+	}))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.ProbeStatus())
+	})
+	return mux
+}
+
+// StartProbes serves the ProbeHandler of the server on a separate HTTP listener at the given address, and blocks
+// until the server is shut down (after which the probe listener is closed too) or serving fails.
+func (s *Server) StartProbes(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serveProbes(listener)
+}
+
+// serveProbes serves the ProbeHandler of the server on the given listener until the server is shut down
+func (s *Server) serveProbes(listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s.ProbeHandler(),
+		ReadHeaderTimeout: time.Second * 5,
+	}
+	go func() {
+		<-s.closeCh
+		_ = server.Close()
+	}()
+	err := server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	server, err := NewServer(HandlerTable{}, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+
+	handler := server.ProbeHandler()
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("/livez"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+
+	go func() {
+		_ = server.Start(conn.Listen)
+	}()
+	<-server.started()
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status ProbeStatus
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&status))
+	assert.Equal(t, ProbeStatus{Live: true, Ready: true}, status)
+
+	server.Drain()
+	assert.True(t, server.Draining())
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/livez"))
+	assert.Equal(t, HealthDraining, server.health().Status)
+
+	probes := make(chan error, 1)
+	go func() {
+		probes <- server.StartProbes(conn.Listen)
+	}()
+
+	require.NoError(t, server.Shutdown())
+	assert.Equal(t, http.StatusServiceUnavailable, get("/livez"))
+	assert.NoError(t, <-probes)
+}
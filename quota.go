@@ -0,0 +1,131 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"sync"
+)
+
+// tenantPruneThreshold is the number of tenants that the server tracks before it starts
+// forgetting the tenants whose quotas have been fully replenished
+const tenantPruneThreshold = 1024
+
+// StreamQuota limits the streams of every tenant of a server, so that a single tenant cannot saturate a shared server
+// with bulk stream transfers. The tenant of a connection is its peer ID (see Server.SetPeerIdentifier), or the IP
+// address of its peer if it does not have one, so all the connections of a tenant share the same quota.
+type StreamQuota struct {
+	// OpensPerSecond is the number of streams that a tenant can open per second on average (0 means no limit).
+	// Streams that are opened while the tenant is over its quota are closed before they are handled.
+	OpensPerSecond int
+
+	// OpenBurst is the number of streams that a tenant can open at once (OpensPerSecond by default)
+	OpenBurst int
+
+	// BytesPerSecond is the aggregate number of content bytes per second that can be read from and written to
+	// all the streams of a tenant (0 means no limit). Reads and writes block while the tenant is over its quota.
+	BytesPerSecond int
+
+	// ByteBurst is the number of bytes that a tenant can transfer at once (BytesPerSecond by default)
+	ByteBurst int
+}
+
+// tenant holds the quotas of a single tenant of a server
+type tenant struct {
+	opens *Throttle
+	bytes *Throttle
+}
+
+// tenants tracks the quotas of the tenants of a server
+type tenants struct {
+	mu      sync.Mutex
+	quota   StreamQuota
+	tenants map[string]*tenant
+	prune   int
+}
+
+// SetStreamQuota limits the number of streams that every tenant can open, and the rate at which they can transfer
+// stream content. It must be called before the server is started.
+func (s *Server) SetStreamQuota(quota StreamQuota) {
+	s.tenants = &tenants{
+		quota:   quota,
+		tenants: make(map[string]*tenant),
+		prune:   tenantPruneThreshold,
+	}
+}
+
+// tenantID returns the ID of the tenant of conn
+func tenantID(conn *Async) string {
+	if conn.peerID != "" {
+		return "peer:" + conn.peerID
+	}
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "addr:" + addr
+}
+
+// get returns the quotas of the tenant with the given ID, creating them if required
+func (t *tenants) get(id string) *tenant {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := t.tenants[id]
+	if q == nil {
+		if len(t.tenants) >= t.prune {
+			// Tenants whose quotas have been fully replenished are indistinguishable from new tenants
+			for key, candidate := range t.tenants {
+				if candidate.replenished() {
+					delete(t.tenants, key)
+				}
+			}
+			t.prune = 2 * len(t.tenants)
+			if t.prune < tenantPruneThreshold {
+				t.prune = tenantPruneThreshold
+			}
+		}
+		q = new(tenant)
+		if t.quota.OpensPerSecond > 0 {
+			q.opens = NewThrottle(t.quota.OpensPerSecond, t.quota.OpenBurst)
+		}
+		if t.quota.BytesPerSecond > 0 {
+			q.bytes = NewThrottle(t.quota.BytesPerSecond, t.quota.ByteBurst)
+		}
+		t.tenants[id] = q
+	}
+	return q
+}
+
+func (q *tenant) replenished() bool {
+	return (q.opens == nil || q.opens.replenished()) && (q.bytes == nil || q.bytes.replenished())
+}
+
+// limitStreams applies the stream quota of the tenant of the stream's connection before
+// handing the stream to the server's stream handler
+func (s *Server) limitStreams(stream *Stream) {
+	id := tenantID(stream.Conn())
+	q := s.tenants.get(id)
+	if q.opens != nil && !q.opens.allow(1) {
+		s.Logger().Debug().Err(StreamQuotaExceeded).Str("Tenant", id).Uint16("Stream ID", stream.ID()).Msg("closing stream")
+		_ = stream.Close()
+		return
+	}
+	if q.bytes != nil {
+		stream.throttle.Store(q.bytes)
+	}
+	s.streamHandler(stream)
+}
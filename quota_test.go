@@ -0,0 +1,135 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamQuota(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	// startServer starts a server with the given quota whose streams echo every packet
+	startServer := func(t *testing.T, quota StreamQuota) *Client {
+		server, err := NewServer(nil, WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+		require.NoError(t, err)
+		server.SetStreamQuota(quota)
+		require.NoError(t, server.SetStreamHandler(func(_ *Async, stream *Stream) {
+			for {
+				p, err := stream.ReadPacket()
+				if err != nil {
+					return
+				}
+				err = stream.WritePacket(p)
+				packet.Put(p)
+				if err != nil {
+					return
+				}
+			}
+		}))
+		go func() {
+			_ = server.Start(conn.Listen)
+		}()
+		<-server.started()
+
+		client, err := NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+		require.NoError(t, err)
+		require.NoError(t, client.Connect(server.listener.Addr().String()))
+		t.Cleanup(func() {
+			_ = client.Close()
+			_ = server.Shutdown()
+		})
+		return client
+	}
+
+	echo := func(stream *Stream, content []byte) (*packet.Packet, error) {
+		p := packet.Get()
+		p.Content.Write(content)
+		p.Metadata.ContentLength = uint32(len(content))
+		err := stream.WritePacket(p)
+		packet.Put(p)
+		if err != nil {
+			return nil, err
+		}
+		return stream.ReadPacket()
+	}
+
+	t.Run("opens", func(t *testing.T) {
+		t.Parallel()
+
+		client := startServer(t, StreamQuota{OpensPerSecond: 1, OpenBurst: 2})
+		for id := uint16(0); id < 2; id++ {
+			p, err := echo(client.Stream(id), []byte("echo"))
+			require.NoError(t, err)
+			assert.Equal(t, "echo", string(*p.Content))
+			packet.Put(p)
+		}
+
+		// The third stream is over the quota of the tenant, so the server closes it
+		_, err := echo(client.Stream(2), []byte("echo"))
+		assert.ErrorIs(t, err, StreamClosed)
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		t.Parallel()
+
+		const packetSize = 1 << 11
+		const packets = 8
+
+		client := startServer(t, StreamQuota{BytesPerSecond: 1 << 15, ByteBurst: 1 << 12})
+		stream := client.Stream(0)
+		content := make([]byte, packetSize)
+
+		// Every packet is both read and written by the server, so twice as many bytes count towards the quota
+		start := time.Now()
+		for i := 0; i < packets; i++ {
+			p, err := echo(stream, content)
+			require.NoError(t, err)
+			packet.Put(p)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*500)
+	})
+}
+
+func TestTenantsPrune(t *testing.T) {
+	t.Parallel()
+
+	s := new(Server)
+	s.SetStreamQuota(StreamQuota{OpensPerSecond: 1})
+	s.tenants.prune = 2
+
+	exhausted := s.tenants.get("exhausted")
+	assert.True(t, exhausted.opens.allow(1))
+	s.tenants.get("replenished")
+
+	// Adding a third tenant forgets the tenants whose quotas have been fully replenished
+	s.tenants.get("new")
+	assert.Len(t, s.tenants.tenants, 2)
+	assert.Same(t, exhausted, s.tenants.get("exhausted"))
+	assert.Nil(t, s.tenants.tenants["replenished"])
+}
@@ -0,0 +1,69 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// ReadBuffer limits the memory that is held by the read buffer of a connection, which otherwise grows to fit the
+// largest packet that was read from the connection and keeps that size for as long as the connection is open
+type ReadBuffer struct {
+	// MaxSize is the largest packet content (in bytes) that is read through the read buffer. The content of larger
+	// packets is read straight into the packet instead, so the read buffer never grows beyond MaxSize. A MaxSize of
+	// 0 means that the read buffer grows to fit the largest packet.
+	MaxSize int
+
+	// ShrinkAfter is how long the read buffer must not have been needed at a size larger than DefaultBufferSize before
+	// it is shrunk back to DefaultBufferSize. Since the read buffer is only shrunk before the next packet is read, a
+	// connection that stays idle keeps its read buffer until then. A ShrinkAfter of 0 means that it is never shrunk.
+	ShrinkAfter time.Duration
+}
+
+// shrink returns whether a read buffer that was last needed at a size larger than DefaultBufferSize at the
+// given time should be shrunk
+func (r ReadBuffer) shrink(grown time.Time) bool {
+	return r.ShrinkAfter > 0 && time.Since(grown) > r.ShrinkAfter
+}
+
+// readContent reads the next size bytes of the connection straight into the content of p, without going
+// through the read buffer
+func (c *Async) readContent(p *packet.Packet, size int) error {
+	start := len(*p.Content)
+	if cap(*p.Content)-start < size {
+		content := make([]byte, start, start+size)
+		copy(content, *p.Content)
+		*p.Content = content
+	}
+	content := (*p.Content)[start : start+size]
+	for read := 0; read < size; {
+		err := c.extendReadDeadline()
+		if err != nil {
+			return err
+		}
+		var nn int
+		nn, err = c.read(content[read:])
+		read += nn
+		if err != nil && read < size {
+			return err
+		}
+	}
+	*p.Content = (*p.Content)[:start+size]
+	return nil
+}
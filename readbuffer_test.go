@@ -0,0 +1,87 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBufferShrink(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, ReadBuffer{}.shrink(time.Time{}))
+	assert.True(t, ReadBuffer{ShrinkAfter: time.Second}.shrink(time.Now().Add(-time.Minute)))
+	assert.False(t, ReadBuffer{ShrinkAfter: time.Minute}.shrink(time.Now()))
+}
+
+func TestReadBuffer(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	for name, readBuffer := range map[string]ReadBuffer{
+		"max-size": {MaxSize: 1024},
+		"shrink":   {ShrinkAfter: time.Millisecond},
+		"both":     {MaxSize: DefaultBufferSize * 2, ShrinkAfter: time.Millisecond},
+	} {
+		readBuffer := readBuffer
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithReadBuffer(readBuffer)), NoFeatures)
+			writerConn := NewAsync(writer, &emptyLogger)
+
+			// Large and small packets are written back to back, so that the read buffer holds the start of the next
+			// packet when the content of a large packet is read around it
+			sizes := []int{16, DefaultBufferSize * 4, 16, 1025, 0, DefaultBufferSize*2 + 1, 512}
+			contents := make([][]byte, len(sizes))
+			for i, size := range sizes {
+				contents[i] = bytes.Repeat([]byte{byte(i + 1)}, size)
+				p := packet.Get()
+				p.Metadata.Operation = metadata.PacketPing
+				p.Content.Write(contents[i])
+				p.Metadata.ContentLength = uint32(size)
+				require.NoError(t, writerConn.WritePacket(p))
+				packet.Put(p)
+			}
+
+			for i := range sizes {
+				p, err := readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, contents[i], []byte(*p.Content), "packet %d", i)
+				packet.Put(p)
+				if i == 1 {
+					time.Sleep(time.Millisecond * 5)
+				}
+			}
+
+			_ = writerConn.Close()
+			_ = readerConn.Close()
+		})
+	}
+}
@@ -0,0 +1,202 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reconnectPruneThreshold is the number of identities that the server tracks before it starts
+// forgetting the identities that have not connected recently and are not banned
+const reconnectPruneThreshold = 1024
+
+// ReconnectStats describes how often a remote identity has connected to a server (see Server.SetReconnectTracking).
+type ReconnectStats struct {
+	// Identity is the identity of the remote, which is "addr:" followed by its IP address for the connections of an
+	// address, or "peer:" followed by its peer ID for the connections of an identified peer
+	// (see Server.SetPeerIdentifier and Server.SetVerifier)
+	Identity string
+
+	// Recent is the number of times that the identity connected during the current tracking window
+	Recent int
+
+	// Total is the number of times that the identity connected since it started being tracked
+	Total uint64
+
+	// Bans is the number of times that the identity has been banned
+	Bans int
+
+	// LastConnect is when the identity last connected
+	LastConnect time.Time
+
+	// BannedUntil is when the current ban of the identity ends (the zero time if it has never been banned)
+	BannedUntil time.Time
+}
+
+// ReconnectPolicy is called by the server with the stats of an identity every time it connects (including the
+// current connection), and returns how long the identity should be banned for (0 to accept the connection). Connections
+// from identities that are banned are closed right away, without calling the policy.
+type ReconnectPolicy func(stats ReconnectStats) time.Duration
+
+// ReconnectTracking configures how the server tracks the rate at which remote identities reconnect, which helps
+// diagnose and protect against crash-looping clients that hammer the accept and handshake paths.
+type ReconnectTracking struct {
+	// Window is the length of the tracking windows that ReconnectStats.Recent is counted over (defaults to a minute)
+	Window time.Duration
+
+	// Policy decides whether identities are banned when they connect (if nil, identities are only tracked)
+	Policy ReconnectPolicy
+}
+
+// EscalatingBan returns a ReconnectPolicy that bans identities that connect more than threshold times in a tracking
+// window. The first ban lasts for base, and every further ban of the same identity lasts twice as long, up to max.
+func EscalatingBan(threshold int, base time.Duration, max time.Duration) ReconnectPolicy {
+	return func(stats ReconnectStats) time.Duration {
+		if stats.Recent <= threshold {
+			return 0
+		}
+		ban := base
+		for i := 0; i < stats.Bans && ban < max; i++ {
+			ban *= 2
+		}
+		if ban > max {
+			ban = max
+		}
+		return ban
+	}
+}
+
+// reconnects tracks the ReconnectStats of the remote identities of a server
+type reconnects struct {
+	mu          sync.Mutex
+	window      time.Duration
+	policy      ReconnectPolicy
+	stats       map[string]*reconnectStats
+	prune       int
+	windowStart time.Time
+}
+
+// reconnectStats are the ReconnectStats of an identity, along with the start of its current tracking window
+type reconnectStats struct {
+	ReconnectStats
+	windowStart time.Time
+}
+
+// SetReconnectTracking makes the server track how often every remote identity (both IP addresses and identified peers)
+// connects to it, so that the rates can be inspected with Server.Reconnects and crash-looping clients can be banned with
+// a ReconnectPolicy (see EscalatingBan). Connections from banned addresses are closed before they are handshaked, and
+// connections from banned peers are closed as soon as they have been identified.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetReconnectTracking(tracking ReconnectTracking) {
+	if tracking.Window <= 0 {
+		tracking.Window = time.Minute
+	}
+	s.reconnects = &reconnects{
+		window: tracking.Window,
+		policy: tracking.Policy,
+		stats:  make(map[string]*reconnectStats),
+		prune:  reconnectPruneThreshold,
+	}
+}
+
+// Reconnects returns the ReconnectStats of every identity that the server is tracking, ordered by the number of times
+// that they connected during their current tracking window (most frequent first), or nil if reconnect tracking has not
+// been enabled with Server.SetReconnectTracking
+func (s *Server) Reconnects() []ReconnectStats {
+	if s.reconnects == nil {
+		return nil
+	}
+	s.reconnects.mu.Lock()
+	stats := make([]ReconnectStats, 0, len(s.reconnects.stats))
+	for _, identity := range s.reconnects.stats {
+		stats = append(stats, identity.ReconnectStats)
+	}
+	s.reconnects.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Recent != stats[j].Recent {
+			return stats[i].Recent > stats[j].Recent
+		}
+		return stats[i].Identity < stats[j].Identity
+	})
+	return stats
+}
+
+// addrIdentity returns the reconnect identity of a remote address
+func addrIdentity(remote net.Addr) string {
+	if addr, ok := remoteIP(remote); ok {
+		return "addr:" + addr.String()
+	}
+	if remote == nil {
+		return "addr:"
+	}
+	return "addr:" + remote.String()
+}
+
+// connect records that the given identity connected, and returns false if the identity is banned
+func (r *reconnects) connect(identity string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats[identity]
+	if stats == nil {
+		if len(r.stats) >= r.prune {
+			// Identities that are not banned and have not connected during the last window are indistinguishable from new identities
+			for key, candidate := range r.stats {
+				if now.Sub(candidate.LastConnect) >= r.window && !now.Before(candidate.BannedUntil) {
+					delete(r.stats, key)
+				}
+			}
+			r.prune = 2 * len(r.stats)
+			if r.prune < reconnectPruneThreshold {
+				r.prune = reconnectPruneThreshold
+			}
+		}
+		stats = &reconnectStats{ReconnectStats: ReconnectStats{Identity: identity}, windowStart: now}
+		r.stats[identity] = stats
+	}
+	if now.Sub(stats.windowStart) >= r.window {
+		stats.windowStart = now
+		stats.Recent = 0
+	}
+	stats.Recent++
+	stats.Total++
+	stats.LastConnect = now
+	if now.Before(stats.BannedUntil) {
+		return false
+	}
+	if r.policy != nil {
+		if ban := r.policy(stats.ReconnectStats); ban > 0 {
+			stats.Bans++
+			stats.BannedUntil = now.Add(ban)
+			return false
+		}
+	}
+	return true
+}
+
+// allowReconnect records that the given identity connected to the server (if reconnect tracking is enabled),
+// and returns false if the identity is banned
+func (s *Server) allowReconnect(identity string) bool {
+	if s.reconnects == nil || s.reconnects.connect(identity, time.Now()) {
+		return true
+	}
+	s.Logger().Debug().Str("Identity", identity).Msg("Connection rejected because its identity is banned for reconnecting too often")
+	return false
+}
@@ -0,0 +1,140 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscalatingBan(t *testing.T) {
+	t.Parallel()
+
+	policy := EscalatingBan(3, time.Second, time.Second*5)
+	assert.Zero(t, policy(ReconnectStats{Recent: 3}))
+	assert.Equal(t, time.Second, policy(ReconnectStats{Recent: 4}))
+	assert.Equal(t, time.Second*2, policy(ReconnectStats{Recent: 4, Bans: 1}))
+	assert.Equal(t, time.Second*4, policy(ReconnectStats{Recent: 4, Bans: 2}))
+	assert.Equal(t, time.Second*5, policy(ReconnectStats{Recent: 4, Bans: 3}))
+	assert.Equal(t, time.Second*5, policy(ReconnectStats{Recent: 4, Bans: 64}))
+}
+
+func TestReconnects(t *testing.T) {
+	t.Parallel()
+
+	r := &reconnects{
+		window: time.Minute,
+		policy: EscalatingBan(2, time.Second, time.Minute),
+		stats:  make(map[string]*reconnectStats),
+		prune:  reconnectPruneThreshold,
+	}
+	now := time.Now()
+
+	assert.True(t, r.connect("addr:192.0.2.1", now))
+	assert.True(t, r.connect("addr:192.0.2.1", now))
+	assert.False(t, r.connect("addr:192.0.2.1", now))
+	assert.True(t, r.connect("addr:192.0.2.2", now))
+
+	stats := r.stats["addr:192.0.2.1"].ReconnectStats
+	assert.Equal(t, 3, stats.Recent)
+	assert.Equal(t, uint64(3), stats.Total)
+	assert.Equal(t, 1, stats.Bans)
+	assert.Equal(t, now.Add(time.Second), stats.BannedUntil)
+
+	// Banned identities are rejected without extending their ban
+	assert.False(t, r.connect("addr:192.0.2.1", now.Add(time.Millisecond*500)))
+	assert.Equal(t, 1, r.stats["addr:192.0.2.1"].Bans)
+
+	// The ban escalates if the identity keeps reconnecting once it has been lifted
+	assert.False(t, r.connect("addr:192.0.2.1", now.Add(time.Second)))
+	stats = r.stats["addr:192.0.2.1"].ReconnectStats
+	assert.Equal(t, 2, stats.Bans)
+	assert.Equal(t, now.Add(time.Second*3), stats.BannedUntil)
+
+	// A new tracking window resets the recent connections
+	assert.True(t, r.connect("addr:192.0.2.1", now.Add(time.Minute*2)))
+	stats = r.stats["addr:192.0.2.1"].ReconnectStats
+	assert.Equal(t, 1, stats.Recent)
+	assert.Equal(t, uint64(6), stats.Total)
+
+	assert.Equal(t, "addr:192.0.2.1", addrIdentity(&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 8192}))
+}
+
+func TestServerReconnectTracking(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	assert.Nil(t, s.Reconnects())
+	s.SetReconnectTracking(ReconnectTracking{Policy: EscalatingBan(2, time.Minute, time.Hour)})
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	for i := 0; i < 2; i++ {
+		received := make(chan struct{}, 1)
+		clientHandlerTable := make(HandlerTable)
+		clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+			received <- struct{}{}
+			return
+		}
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		require.NoError(t, c.Connect(s.listener.Addr().String()))
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		require.NoError(t, c.WritePacket(p))
+		packet.Put(p)
+		<-received
+		assert.NoError(t, c.Close())
+	}
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, c.Connect(s.listener.Addr().String()))
+	assert.Eventually(t, c.Closed, time.Second*5, time.Millisecond*10)
+
+	stats := s.Reconnects()
+	require.Len(t, stats, 1)
+	assert.Equal(t, 3, stats[0].Recent)
+	assert.Equal(t, 1, stats[0].Bans)
+	assert.True(t, stats[0].BannedUntil.After(time.Now()))
+
+	assert.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,170 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// rekeyLabel is the label used when ratcheting a key forward
+var rekeyLabel = []byte("frisbee rekey")
+
+// KeyRotator is implemented by connection layers that hold symmetric keys (like packet signing or
+// payload encryption) so that their keys can be rotated in lockstep with the peer.
+//
+// Each direction of a connection is rotated independently: RotateWrite is called directly after
+// a REKEY packet has been written (so every later packet is written with the new keys), and
+// RotateRead is called directly after a REKEY packet has been read.
+type KeyRotator interface {
+	RotateWrite(epoch uint32) error
+	RotateRead(epoch uint32) error
+}
+
+// RatchetKey derives the next key from the given key. The derivation is one-way, so
+// a compromised key cannot be used to recover the keys of earlier epochs.
+func RatchetKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(rekeyLabel)
+	return mac.Sum(nil)
+}
+
+// KeySchedule is a KeyRotator that keeps track of a separate read and write key, and ratchets each of them forward
+// using RatchetKey whenever they are rotated. The two keys should differ, so that the packets written by one side
+// of a connection are not accepted when they are reflected back to it.
+type KeySchedule struct {
+	mu         sync.RWMutex
+	readKey    []byte
+	readEpoch  uint32
+	writeKey   []byte
+	writeEpoch uint32
+}
+
+// NewKeySchedule returns a KeySchedule that starts with the given read and write keys
+func NewKeySchedule(readKey []byte, writeKey []byte) *KeySchedule {
+	return &KeySchedule{
+		readKey:  readKey,
+		writeKey: writeKey,
+	}
+}
+
+// ReadKey returns the current read key and its epoch
+func (k *KeySchedule) ReadKey() ([]byte, uint32) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.readKey, k.readEpoch
+}
+
+// WriteKey returns the current write key and its epoch
+func (k *KeySchedule) WriteKey() ([]byte, uint32) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.writeKey, k.writeEpoch
+}
+
+// RotateRead ratchets the read key forward to the given epoch, which must be the next epoch
+func (k *KeySchedule) RotateRead(epoch uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if epoch != k.readEpoch+1 {
+		return InvalidRekey
+	}
+	k.readKey = RatchetKey(k.readKey)
+	k.readEpoch = epoch
+	return nil
+}
+
+// RotateWrite ratchets the write key forward to the given epoch, which must be the next epoch
+func (k *KeySchedule) RotateWrite(epoch uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if epoch != k.writeEpoch+1 {
+		return InvalidRekey
+	}
+	k.writeKey = RatchetKey(k.writeKey)
+	k.writeEpoch = epoch
+	return nil
+}
+
+// AddKeyRotator registers a KeyRotator with the connection, which will be rotated whenever a REKEY packet is
+// sent or received. It must be called before any packets are sent or received on the connection.
+func (c *Async) AddKeyRotator(r KeyRotator) {
+	c.rotatorsMu.Lock()
+	c.rotators = append(c.rotators, r)
+	c.rotatorsMu.Unlock()
+}
+
+// Rekey sends a REKEY packet to the peer and rotates the write keys of all the registered KeyRotators.
+//
+// The FeatureRekey feature must have been negotiated during the handshake, otherwise FeatureNotNegotiated is returned.
+func (c *Async) Rekey() error {
+	err := c.rekey()
+	if err != nil && err != ConnectionClosed && err != FeatureNotNegotiated {
+		return c.closeWithError(err)
+	}
+	return err
+}
+
+// rekey sends a REKEY packet without closing the connection if the packet could not be written
+func (c *Async) rekey() error {
+	if !c.features.Has(FeatureRekey) {
+		return FeatureNotNegotiated
+	}
+	c.rekeyMu.Lock()
+	defer c.rekeyMu.Unlock()
+	p := packet.Get()
+	p.Metadata.Operation = REKEY
+	var epoch [4]byte
+	binary.BigEndian.PutUint32(epoch[:], c.writeEpoch+1)
+	p.Content.Write(epoch[:])
+	p.Metadata.ContentLength = uint32(len(epoch))
+	err := c.write(p)
+	packet.Put(p)
+	if err != nil {
+		return err
+	}
+	c.writeEpoch++
+	return nil
+}
+
+// rotate calls rotate for every registered KeyRotator with the epoch contained in the REKEY packet p
+func (c *Async) rotate(p *packet.Packet, rotate func(KeyRotator, uint32) error) error {
+	if p.Metadata.ContentLength != 4 {
+		return InvalidRekey
+	}
+	epoch := binary.BigEndian.Uint32((*p.Content)[:4])
+	c.rotatorsMu.Lock()
+	defer c.rotatorsMu.Unlock()
+	for _, r := range c.rotators {
+		if err := rotate(r, epoch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Async) rotateWrite(p *packet.Packet) error {
+	return c.rotate(p, KeyRotator.RotateWrite)
+}
+
+func (c *Async) rotateRead(p *packet.Packet) error {
+	return c.rotate(p, KeyRotator.RotateRead)
+}
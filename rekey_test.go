@@ -0,0 +1,126 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySchedule(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("initial key")
+	k := NewKeySchedule(key, key)
+
+	readKey, readEpoch := k.ReadKey()
+	writeKey, writeEpoch := k.WriteKey()
+	assert.Equal(t, key, readKey)
+	assert.Equal(t, key, writeKey)
+	assert.Equal(t, uint32(0), readEpoch)
+	assert.Equal(t, uint32(0), writeEpoch)
+
+	require.NoError(t, k.RotateWrite(1))
+	writeKey, writeEpoch = k.WriteKey()
+	assert.Equal(t, RatchetKey(key), writeKey)
+	assert.Equal(t, uint32(1), writeEpoch)
+
+	readKey, readEpoch = k.ReadKey()
+	assert.Equal(t, key, readKey)
+	assert.Equal(t, uint32(0), readEpoch)
+
+	assert.ErrorIs(t, k.RotateRead(2), InvalidRekey)
+	assert.ErrorIs(t, k.RotateWrite(1), InvalidRekey)
+}
+
+func TestAsyncRekey(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureRekey)
+	writerConn := newAsync(writer, options, FeatureRekey)
+
+	key := []byte("shared key")
+	readerKeys := NewKeySchedule(key, key)
+	writerKeys := NewKeySchedule(key, key)
+	readerConn.AddKeyRotator(readerKeys)
+	writerConn.AddKeyRotator(writerKeys)
+
+	err := writerConn.Rekey()
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	err = writerConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32), p.Metadata.Operation)
+	packet.Put(p)
+
+	writeKey, writeEpoch := writerKeys.WriteKey()
+	readKey, readEpoch := readerKeys.ReadKey()
+	assert.Equal(t, uint32(1), writeEpoch)
+	assert.Equal(t, uint32(1), readEpoch)
+	assert.Equal(t, writeKey, readKey)
+	assert.NotEqual(t, key, readKey)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestAsyncRekeyInterval(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger), WithRekeyInterval(time.Millisecond*10))
+
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureRekey)
+	writerConn := newAsync(writer, options, NoFeatures)
+
+	readerKeys := NewKeySchedule([]byte("shared key"), []byte("shared key"))
+	readerConn.AddKeyRotator(readerKeys)
+
+	assert.ErrorIs(t, writerConn.Rekey(), FeatureNotNegotiated)
+
+	assert.Eventually(t, func() bool {
+		_, epoch := readerKeys.WriteKey()
+		return epoch > 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	err := readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
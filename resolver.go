@@ -0,0 +1,136 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultResolveInterval is the default interval at which a Balancer polls its Resolver
+const DefaultResolveInterval = time.Second * 30
+
+// Resolver returns the addresses of the backends of a Balancer (see NewResolvingBalancer), which allows
+// frisbee clients to be used in environments where the backends change over time
+type Resolver interface {
+	// Resolve returns the current addresses of the backends
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// Watcher is a Resolver that is notified when the addresses of the backends change, instead of being polled
+type Watcher interface {
+	Resolver
+
+	// Watch calls update with the addresses of the backends whenever they change, until ctx is done
+	Watch(ctx context.Context, update func(addrs []string)) error
+}
+
+// StaticResolver is a Resolver that always returns the same addresses
+type StaticResolver []string
+
+// Resolve returns the addresses of the StaticResolver
+func (r StaticResolver) Resolve(context.Context) ([]string, error) {
+	return r, nil
+}
+
+// SRVResolver is a Resolver that looks up the addresses of the backends using DNS SRV records
+// (like the SRV records of a headless Kubernetes service)
+type SRVResolver struct {
+	// Service, Proto, and Name are looked up as an SRV record of the form _service._proto.name (if Service
+	// and Proto are empty, Name is looked up directly)
+	Service string
+	Proto   string
+	Name    string
+
+	// Resolver is the net.Resolver used to look up the SRV records (defaults to net.DefaultResolver)
+	Resolver *net.Resolver
+}
+
+// Resolve looks up the SRV records and returns their targets, ordered by priority and randomized by weight
+func (r *SRVResolver) Resolve(ctx context.Context) ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return addrs, nil
+}
+
+// NewResolvingBalancer returns a new Balancer (see NewBalancer) whose backends are the addresses returned by the given
+// Resolver, which is polled every BalancerConfig.ResolveInterval (or watched, if it is a Watcher). Resolving the
+// backends must succeed when the Balancer is created, and later errors or empty results keep the current backends.
+func NewResolvingBalancer(handlerTable HandlerTable, ctx context.Context, resolver Resolver, config BalancerConfig, opts ...Option) (*Balancer, error) {
+	if _, err := NewClient(handlerTable, ctx, opts...); err != nil {
+		return nil, err
+	}
+	addrs, err := resolver.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, NoAddresses
+	}
+	b := newBalancer(handlerTable, ctx, addrs, config, opts...)
+	var resolveCtx context.Context
+	resolveCtx, b.cancel = context.WithCancel(ctx)
+	b.wg.Add(1)
+	go b.resolve(resolveCtx, resolver)
+	return b, nil
+}
+
+// resolve keeps the backends of the Balancer up to date with the given Resolver until ctx is done
+func (b *Balancer) resolve(ctx context.Context, resolver Resolver) {
+	defer b.wg.Done()
+	update := func(addrs []string) {
+		if len(addrs) == 0 {
+			b.Logger().Debug().Msg("resolver returned no backends, keeping the current backends")
+			return
+		}
+		b.SetBackends(addrs)
+	}
+	if watcher, ok := resolver.(Watcher); ok {
+		if err := watcher.Watch(ctx, update); err != nil && ctx.Err() == nil {
+			b.Logger().Error().Err(err).Msg("error while watching resolver")
+		}
+		return
+	}
+	ticker := time.NewTicker(b.config.ResolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		addrs, err := resolver.Resolve(ctx)
+		if err != nil {
+			b.Logger().Debug().Err(err).Msg("error while resolving backends")
+			continue
+		}
+		update(addrs)
+	}
+}
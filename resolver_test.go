@@ -0,0 +1,100 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// channelWatcher is a Watcher whose updates are sent on a channel
+type channelWatcher struct {
+	StaticResolver
+	updates chan []string
+}
+
+func (w *channelWatcher) Watch(ctx context.Context, update func(addrs []string)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case addrs := <-w.updates:
+			update(addrs)
+		}
+	}
+}
+
+func TestResolvingBalancer(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	start := func() string {
+		server, err := NewServer(HandlerTable{
+			10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+				return nil, NONE
+			},
+		}, WithLogger(&emptyLogger))
+		require.NoError(t, err)
+		go func() {
+			_ = server.Start(conn.Listen)
+		}()
+		<-server.started()
+		t.Cleanup(func() {
+			_ = server.Shutdown()
+		})
+		return server.listener.Addr().String()
+	}
+	first, second := start(), start()
+
+	_, err := NewResolvingBalancer(HandlerTable{}, context.Background(), StaticResolver{}, BalancerConfig{})
+	assert.ErrorIs(t, err, NoAddresses)
+
+	balancer, err := NewResolvingBalancer(HandlerTable{}, context.Background(), StaticResolver{first}, BalancerConfig{ResolveInterval: time.Millisecond}, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.Len(t, balancer.Backends(), 1)
+	assert.True(t, balancer.Backends()[0].Healthy)
+	require.NoError(t, balancer.Close())
+
+	watcher := &channelWatcher{StaticResolver: StaticResolver{first}, updates: make(chan []string)}
+	balancer, err = NewResolvingBalancer(HandlerTable{}, context.Background(), watcher, BalancerConfig{}, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = balancer.Close()
+	})
+
+	watcher.updates <- []string{first, second}
+	require.Eventually(t, func() bool {
+		statuses := balancer.Backends()
+		return len(statuses) == 2 && statuses[0].Healthy && statuses[1].Healthy
+	}, DefaultDeadline, time.Millisecond)
+
+	// Empty updates keep the current backends
+	watcher.updates <- nil
+	watcher.updates <- []string{second}
+	require.Eventually(t, func() bool {
+		statuses := balancer.Backends()
+		return len(statuses) == 1 && statuses[0].Addr == second && statuses[0].Healthy
+	}, DefaultDeadline, time.Millisecond)
+}
@@ -0,0 +1,45 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/tls"
+)
+
+// withSessionCache returns the TLS configuration of a client that resumes its TLS sessions using the given cache,
+// or the configuration itself if it already has a session cache (or there is no cache or configuration)
+func withSessionCache(config *tls.Config, cache tls.ClientSessionCache) *tls.Config {
+	if config == nil || cache == nil || config.ClientSessionCache != nil {
+		return config
+	}
+	config = config.Clone()
+	config.ClientSessionCache = cache
+	return config
+}
+
+// Resumed returns true if the connection resumed a previous TLS session instead of performing a full handshake
+// (see the WithTLSResumption option). It returns false for connections that do not use TLS.
+func (c *Async) Resumed() bool {
+	state, err := c.ConnectionState()
+	return err == nil && state.DidResume
+}
+
+// Resumed returns true if the client's connection resumed a previous TLS session instead of performing a full
+// handshake (see the WithTLSResumption option)
+func (c *Client) Resumed() bool {
+	return c.conn.Resumed()
+}
@@ -0,0 +1,87 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSResumption(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithTLS(serverTLS))
+	require.NoError(t, err)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	connect := func() bool {
+		received := make(chan struct{}, 1)
+		clientHandlerTable := make(HandlerTable)
+		clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+			received <- struct{}{}
+			return
+		}
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithTLS(clientTLS), WithTLSResumption(cache))
+		require.NoError(t, err)
+		err = c.Connect(s.listener.Addr().String())
+		require.NoError(t, err)
+
+		// The session ticket of the server is read along with the response
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		require.NoError(t, c.WritePacket(p))
+		packet.Put(p)
+		<-received
+
+		resumed := c.Resumed()
+		require.NoError(t, c.Close())
+		return resumed
+	}
+
+	assert.False(t, connect())
+	assert.True(t, connect())
+	assert.Nil(t, clientTLS.ClientSessionCache)
+
+	assert.NotNil(t, loadOptions(WithTLS(clientTLS), WithTLSResumption(nil)).TLSConfig.ClientSessionCache)
+	assert.Nil(t, loadOptions(WithTLSResumption(nil)).TLSConfig)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,167 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"hash"
+	"io"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/frame"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/pkg/errors"
+)
+
+var (
+	ForwardUnsupported = errors.New("packets cannot be forwarded to a connection that compresses or encrypts them")
+)
+
+// ContentRouter is called by the read loop of a connection with the metadata of every incoming packet (other than packets
+// with reserved operations or compressed content) before its content is read. If it returns an io.WriteCloser, the content
+// of the packet is streamed to it as it is read (instead of being buffered in a packet), and it is closed once the content
+// has been read. Packets that are routed are not queued on the connection.
+//
+// This allows proxies and routers to forward large packets without buffering them (see Async.Forward), however no other
+// packets can be read from the connection while the content of a packet is being routed. If the returned io.WriteCloser
+// returns an error, the rest of the content is discarded.
+//
+// When packets are signed (see WithSigningKey), the signature of a routed packet can only be verified once all of its content
+// has been routed, so the connection is closed with the InvalidSignature error after the content has been routed if it
+// does not match.
+type ContentRouter func(metadata.Metadata) io.WriteCloser
+
+// routedWriter wraps the io.WriteCloser returned by a ContentRouter, and stops writing to it after the first error
+type routedWriter struct {
+	w   io.WriteCloser
+	err error
+}
+
+func (r *routedWriter) Write(b []byte) (int, error) {
+	if r.err == nil {
+		_, r.err = r.w.Write(b)
+	}
+	return len(b), nil
+}
+
+// forwarder is the io.WriteCloser returned by Async.Forward
+type forwarder struct {
+	c         *Async
+	remaining int
+	signature hash.Hash
+	err       error
+	closed    bool
+}
+
+// Forward starts writing a packet with the given metadata to the connection, and returns an io.WriteCloser that the
+// content of the packet must be written to (usually by a ContentRouter) before it is closed. The connection is locked
+// until the returned io.WriteCloser is closed, so no other packets can be written to the connection in the meantime.
+//
+// If less than m.ContentLength bytes are written before the io.WriteCloser is closed, the packet cannot be completed, so
+// the connection is closed with the InvalidContentLength error. Packets cannot be forwarded to connections that would
+// compress or encrypt them, in which case ForwardUnsupported is returned.
+func (c *Async) Forward(m metadata.Metadata) (io.WriteCloser, error) {
+	if m.Operation <= RESERVED9 {
+		return nil, InvalidOperation
+	}
+	if c.compressible(m.Operation) || c.encrypted(m.Operation) {
+		return nil, ForwardUnsupported
+	}
+	if c.tracksActivity() {
+		c.markActive()
+	}
+
+	encodedMetadata, err := metadata.Encode(m.Id, m.Operation, m.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+	header := encodedMetadata[:]
+	if c.extended() {
+		header, _ = encodeExtendedHeader(header, nil, 0, c.sequenced())
+	}
+
+	c.Lock()
+	if c.closed.Load() {
+		c.Unlock()
+		return nil, ConnectionClosed
+	}
+	c.startFlushLoop()
+	c.stampSequence(header)
+	f := &forwarder{c: c, remaining: int(m.ContentLength)}
+	if c.signing != nil {
+		f.signature = c.writeSignature()
+		f.signature.Write(header)
+	}
+	if c.compactMetadata() {
+		header = compactHeader(make([]byte, 0, frame.MaxCompactHeaderSize), header)
+	}
+	_, err = f.write(header)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *forwarder) write(b []byte) (int, error) {
+	err := f.c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+	if err != nil {
+		f.err = err
+		return 0, err
+	}
+	n, err := f.c.writer.Write(b)
+	if err != nil {
+		f.err = err
+	}
+	return n, err
+}
+
+func (f *forwarder) Write(b []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	if len(b) > f.remaining {
+		f.err = InvalidContentLength
+		return 0, f.err
+	}
+	n, err := f.write(b)
+	if f.signature != nil {
+		f.signature.Write(b[:n])
+	}
+	f.remaining -= n
+	return n, err
+}
+
+func (f *forwarder) Close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+	if f.err == nil && f.remaining > 0 {
+		f.err = InvalidContentLength
+	}
+	if f.err == nil && f.signature != nil {
+		_, _ = f.write(f.signature.Sum(nil))
+	}
+	if f.err != nil {
+		f.c.Unlock()
+		f.c.Logger().Debug().Err(f.err).Msg("error while forwarding packet, calling closeWithError")
+		return f.c.closeWithError(f.err)
+	}
+	f.c.signalFlush()
+	f.c.Unlock()
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sink struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (s *sink) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestContentRouter(t *testing.T) {
+	t.Parallel()
+
+	const queuedOperation = uint16(11)
+	const forwardedOperation = uint16(12)
+	const sunkOperation = uint16(13)
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	for name, features := range map[string]Features{"plain": NoFeatures, "extended": FeatureExtendedHeaders} {
+		features := features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Packets are written by the sender to the router, which forwards them to the receiver
+			sender, routerIn, err := pair.New()
+			require.NoError(t, err)
+			routerOut, receiver, err := pair.New()
+			require.NoError(t, err)
+
+			routerOutConn := newAsync(routerOut, loadOptions(WithLogger(&emptyLogger)), features)
+			receiverConn := newAsync(receiver, loadOptions(WithLogger(&emptyLogger)), features)
+
+			var sunk *sink
+			router := func(m metadata.Metadata) io.WriteCloser {
+				switch m.Operation {
+				case forwardedOperation:
+					w, err := routerOutConn.Forward(m)
+					assert.NoError(t, err)
+					return w
+				case sunkOperation:
+					sunk = &sink{closed: make(chan struct{})}
+					return sunk
+				}
+				return nil
+			}
+			senderConn := newAsync(sender, loadOptions(WithLogger(&emptyLogger)), features)
+			routerInConn := newAsync(routerIn, loadOptions(WithLogger(&emptyLogger), WithContentRouter(router)), features)
+
+			write := func(operation uint16, content []byte) {
+				p := packet.Get()
+				p.Metadata.Id = 7
+				p.Metadata.Operation = operation
+				p.Content.Write(content)
+				p.Metadata.ContentLength = uint32(len(content))
+				err := senderConn.WritePacket(p)
+				require.NoError(t, err)
+				packet.Put(p)
+			}
+
+			for _, size := range []int{0, 16, DefaultBufferSize * 3} {
+				content := make([]byte, size)
+				_, _ = rand.Read(content)
+
+				write(forwardedOperation, content)
+				p, err := receiverConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, uint16(7), p.Metadata.Id)
+				assert.Equal(t, forwardedOperation, p.Metadata.Operation)
+				assert.Equal(t, uint32(size), p.Metadata.ContentLength)
+				assert.Equal(t, content, []byte(*p.Content))
+				packet.Put(p)
+
+				write(sunkOperation, content)
+				write(queuedOperation, content)
+				p, err = routerInConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, queuedOperation, p.Metadata.Operation)
+				assert.Equal(t, content, []byte(*p.Content))
+				packet.Put(p)
+				<-sunk.closed
+				assert.Equal(t, string(content), sunk.String())
+			}
+
+			_, err = routerOutConn.Forward(metadata.Metadata{Operation: PING})
+			assert.ErrorIs(t, err, InvalidOperation)
+
+			w, err := routerOutConn.Forward(metadata.Metadata{Operation: forwardedOperation, ContentLength: 4})
+			require.NoError(t, err)
+			_, err = w.Write([]byte{1, 2})
+			require.NoError(t, err)
+			err = w.Close()
+			assert.ErrorIs(t, err, InvalidContentLength)
+			assert.True(t, routerOutConn.Closed())
+
+			assert.NoError(t, senderConn.Close())
+			assert.NoError(t, routerInConn.Close())
+			assert.NoError(t, receiverConn.Close())
+		})
+	}
+}
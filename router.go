@@ -0,0 +1,152 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"sort"
+	"sync"
+)
+
+// routeRange is a range of operations (from and to are inclusive) that is routed to a Handler
+type routeRange struct {
+	from    uint16
+	to      uint16
+	handler Handler
+}
+
+// Router maps operations to Handlers like a HandlerTable does, but it can also route whole ranges of operations (or all
+// the operations that share a prefix) to a single Handler, and has a default Handler for operations that are not routed
+// otherwise. Packets are handled by the Handler of their exact operation if there is one, then by the Handler of the
+// narrowest range that contains their operation, and then by the default Handler.
+//
+// Routers are set using the WithRouter option, and are only consulted for operations that are not in the HandlerTable
+// of the client or server. Reserved operations are never routed. A Router is safe for concurrent use.
+type Router struct {
+	mu       sync.RWMutex
+	exact    map[uint16]Handler
+	ranges   []routeRange
+	fallback Handler
+}
+
+// NewRouter returns an empty Router
+func NewRouter() *Router {
+	return &Router{
+		exact: make(map[uint16]Handler),
+	}
+}
+
+// Handle routes packets with the given operation to the Handler h, replacing the previous Handler of the operation.
+// If the operation is reserved, InvalidOperation is returned.
+func (r *Router) Handle(operation uint16, h Handler) error {
+	if operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	r.mu.Lock()
+	r.exact[operation] = h
+	r.mu.Unlock()
+	return nil
+}
+
+// HandleRange routes packets with operations from from to to (inclusive) to the Handler h, replacing the previous
+// Handler of the same range. The range may include reserved operations, which are never routed. If from is greater
+// than to, InvalidRange is returned.
+func (r *Router) HandleRange(from uint16, to uint16, h Handler) error {
+	if from > to {
+		return InvalidRange
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.ranges {
+		if r.ranges[i].from == from && r.ranges[i].to == to {
+			r.ranges[i].handler = h
+			return nil
+		}
+	}
+	r.ranges = append(r.ranges, routeRange{from: from, to: to, handler: h})
+	sort.SliceStable(r.ranges, func(i, j int) bool {
+		return r.ranges[i].to-r.ranges[i].from < r.ranges[j].to-r.ranges[j].from
+	})
+	return nil
+}
+
+// HandlePrefix routes packets with operations whose first bits bits are the same as those of prefix to the Handler h
+// (for example, a prefix of 0x0100 with 8 bits routes the operations 0x0100 to 0x01FF). If bits is greater than 16,
+// InvalidRange is returned.
+func (r *Router) HandlePrefix(prefix uint16, bits uint8, h Handler) error {
+	if bits > 16 {
+		return InvalidRange
+	}
+	mask := ^uint16(0xFFFF >> bits)
+	return r.HandleRange(prefix&mask, prefix|^mask, h)
+}
+
+// HandleDefault routes packets with operations that are not routed otherwise to the Handler h (use nil to remove the
+// default Handler)
+func (r *Router) HandleDefault(h Handler) {
+	r.mu.Lock()
+	r.fallback = h
+	r.mu.Unlock()
+}
+
+// Merge adds all the routes of other (including its default Handler, if it has one) to the Router, replacing the
+// routes of the Router for the same operations and ranges
+func (r *Router) Merge(other *Router) {
+	other.mu.RLock()
+	exact := make(map[uint16]Handler, len(other.exact))
+	for operation, h := range other.exact {
+		exact[operation] = h
+	}
+	ranges := append([]routeRange(nil), other.ranges...)
+	fallback := other.fallback
+	other.mu.RUnlock()
+
+	for operation, h := range exact {
+		_ = r.Handle(operation, h)
+	}
+	for _, route := range ranges {
+		_ = r.HandleRange(route.from, route.to, route.handler)
+	}
+	if fallback != nil {
+		r.HandleDefault(fallback)
+	}
+}
+
+// Handler returns the Handler that packets with the given operation are routed to, or nil if they are not routed
+func (r *Router) Handler(operation uint16) Handler {
+	if operation <= RESERVED9 {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.exact[operation]; ok {
+		return h
+	}
+	for _, route := range r.ranges {
+		if operation >= route.from && operation <= route.to {
+			return route.handler
+		}
+	}
+	return r.fallback
+}
+
+// lookupHandler returns the Handler for the operation from the HandlerTable, falling back to the Router (if there is one)
+func lookupHandler(handlerTable HandlerTable, router *Router, operation uint16) Handler {
+	if h, ok := handlerTable[operation]; ok || router == nil {
+		return h
+	}
+	return router.Handler(operation)
+}
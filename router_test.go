@@ -0,0 +1,135 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedHandler returns a Handler that answers every packet with a packet whose content is the given name
+func namedHandler(name string) Handler {
+	return func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		incoming.Content.Reset()
+		incoming.Content.Write([]byte(name))
+		incoming.Metadata.ContentLength = uint32(len(name))
+		return incoming, NONE
+	}
+}
+
+// routedTo returns the name of the handler that the router routes the operation to
+func routedTo(r *Router, operation uint16) string {
+	h := r.Handler(operation)
+	if h == nil {
+		return ""
+	}
+	p := packet.Get()
+	defer packet.Put(p)
+	outgoing, _ := h(context.Background(), p)
+	return string(*outgoing.Content)
+}
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	r := NewRouter()
+	assert.ErrorIs(t, r.Handle(RESERVED9, namedHandler("reserved")), InvalidOperation)
+	assert.ErrorIs(t, r.HandleRange(20, 10, namedHandler("invalid")), InvalidRange)
+	assert.ErrorIs(t, r.HandlePrefix(0, 17, namedHandler("invalid")), InvalidRange)
+
+	require.NoError(t, r.Handle(100, namedHandler("exact")))
+	require.NoError(t, r.HandleRange(0, 1000, namedHandler("wide")))
+	require.NoError(t, r.HandleRange(90, 110, namedHandler("narrow")))
+	require.NoError(t, r.HandlePrefix(0x0200, 8, namedHandler("prefix")))
+
+	assert.Equal(t, "", routedTo(r, PING))
+	assert.Equal(t, "exact", routedTo(r, 100))
+	assert.Equal(t, "narrow", routedTo(r, 101))
+	assert.Equal(t, "wide", routedTo(r, 10))
+	assert.Equal(t, "prefix", routedTo(r, 0x0200))
+	assert.Equal(t, "prefix", routedTo(r, 0x02FF))
+	assert.Equal(t, "", routedTo(r, 0x0400))
+
+	r.HandleDefault(namedHandler("default"))
+	assert.Equal(t, "default", routedTo(r, 0x0400))
+	assert.Equal(t, "", routedTo(r, PONG))
+
+	// Routes of the same range are replaced
+	require.NoError(t, r.HandleRange(90, 110, namedHandler("replaced")))
+	assert.Equal(t, "replaced", routedTo(r, 101))
+
+	other := NewRouter()
+	require.NoError(t, other.Handle(101, namedHandler("merged")))
+	require.NoError(t, other.HandleRange(0x0300, 0x0310, namedHandler("merged-range")))
+	r.Merge(other)
+	assert.Equal(t, "merged", routedTo(r, 101))
+	assert.Equal(t, "merged-range", routedTo(r, 0x0305))
+	assert.Equal(t, "default", routedTo(r, 0x0400))
+	assert.Equal(t, "exact", routedTo(r, 100))
+}
+
+func TestServerRouter(t *testing.T) {
+	t.Parallel()
+
+	const (
+		tableOperation  = uint16(10)
+		routedOperation = uint16(0x1001)
+		otherOperation  = uint16(0x2000)
+	)
+
+	emptyLogger := zerolog.New(io.Discard)
+	router := NewRouter()
+	require.NoError(t, router.HandlePrefix(0x1000, 4, namedHandler("routed")))
+	router.HandleDefault(namedHandler("default"))
+
+	server, err := NewServer(HandlerTable{tableOperation: namedHandler("table")}, WithLogger(&emptyLogger), WithRouter(router))
+	require.NoError(t, err)
+	server.SetConcurrency(1)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	responses := make(chan string, 1)
+	respond := func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		responses <- string(*incoming.Content)
+		return nil, NONE
+	}
+	clientRouter := NewRouter()
+	clientRouter.HandleDefault(respond)
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger), WithRouter(clientRouter))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+
+	for operation, expected := range map[uint16]string{tableOperation: "table", routedOperation: "routed", otherOperation: "default"} {
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		require.NoError(t, client.WritePacket(p))
+		packet.Put(p)
+		assert.Equal(t, expected, <-responses)
+	}
+
+	require.NoError(t, client.Close())
+	require.NoError(t, server.Shutdown())
+}
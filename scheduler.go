@@ -0,0 +1,269 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type jobKind uint8
+
+const (
+	jobFlush jobKind = iota
+	jobBatchFlush
+	jobPing
+	jobRekey
+)
+
+// job is a unit of work that a Scheduler runs on behalf of a connection
+type job struct {
+	c    *Async
+	kind jobKind
+}
+
+// timedJob is a job that is due at a given time
+type timedJob struct {
+	job
+	at time.Time
+}
+
+// timers is a min-heap of timed jobs ordered by the time they are due
+type timers []timedJob
+
+func (t timers) Len() int            { return len(t) }
+func (t timers) Less(i, j int) bool  { return t[i].at.Before(t[j].at) }
+func (t timers) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *timers) Push(x interface{}) { *t = append(*t, x.(timedJob)) }
+
+func (t *timers) Pop() interface{} {
+	old := *t
+	x := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return x
+}
+
+// peek returns the time at which the next timed job is due
+func (t timers) peek() (at time.Time, ok bool) {
+	if len(t) == 0 {
+		return
+	}
+	return t[0].at, true
+}
+
+// Scheduler runs the flushing, pinging and rekeying of many connections on a shared timer and a fixed number of
+// worker goroutines, instead of the flush and ping loops that every connection otherwise runs. Servers that hold
+// a very large number of mostly quiet connections can use it (see the WithScheduler option) so that every connection
+// only needs its read loop.
+//
+// Connections that use the Idle mode keep their own ping loop, since it adapts its interval to every connection.
+// A Scheduler can be shared by any number of clients and servers, and must only be closed once none of its
+// connections are open anymore.
+type Scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []job
+	timers  timers
+	wakeCh  chan struct{}
+	closeCh chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewScheduler returns a new Scheduler that runs its jobs on the given number of worker goroutines
+// (which defaults to runtime.NumCPU if it is not positive)
+func NewScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	s := &Scheduler{
+		wakeCh:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	go s.timerLoop()
+	return s
+}
+
+// Close stops the goroutines of the Scheduler, discarding any jobs that have not run yet
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	s.timers = nil
+	close(s.closeCh)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// start schedules the periodic jobs of a new connection
+func (s *Scheduler) start(c *Async) {
+	if c.options.Liveness.PingInterval > 0 {
+		s.after(c, jobPing, c.options.Liveness.PingInterval)
+	}
+	if c.features.Has(FeatureRekey) && c.options.RekeyInterval > 0 {
+		s.after(c, jobRekey, c.options.RekeyInterval)
+	}
+}
+
+// signal is called (with the connection locked) whenever packets have been written to the write buffer of the
+// connection, and flushes them according to its FlushStrategy
+func (s *Scheduler) signal(c *Async) {
+	strategy := c.options.FlushStrategy
+	if !strategy.immediate() {
+		sparse := strategy.Adaptive && c.flushRate.observe(time.Now()) >= strategy.Delay
+		if !sparse && (strategy.Bytes <= 0 || c.writer.Buffered() < strategy.Bytes) {
+			if !c.flushPending {
+				c.flushPending = true
+				s.after(c, jobBatchFlush, strategy.Delay)
+			}
+			return
+		}
+	}
+	if c.flushQueued.CompareAndSwap(false, true) {
+		s.enqueue(job{c: c, kind: jobFlush})
+	}
+}
+
+// enqueue adds the job to the queue of jobs that are ready to run
+func (s *Scheduler) enqueue(j job) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, j)
+		s.cond.Signal()
+	}
+	s.mu.Unlock()
+}
+
+// after runs the job of the given kind on the connection once the delay has passed
+func (s *Scheduler) after(c *Async, kind jobKind, delay time.Duration) {
+	at := time.Now().Add(delay)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	next, ok := s.timers.peek()
+	heap.Push(&s.timers, timedJob{job: job{c: c, kind: kind}, at: at})
+	s.mu.Unlock()
+	if !ok || at.Before(next) {
+		select {
+		case s.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// timerLoop moves the timed jobs to the queue once they are due
+func (s *Scheduler) timerLoop() {
+	defer s.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		for len(s.timers) > 0 && !s.timers[0].at.After(now) {
+			s.queue = append(s.queue, heap.Pop(&s.timers).(timedJob).job)
+			s.cond.Signal()
+		}
+		wait := time.Hour
+		if next, ok := s.timers.peek(); ok {
+			wait = next.Sub(now)
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-s.closeCh:
+			return
+		case <-s.wakeCh:
+		case <-timer.C:
+		}
+	}
+}
+
+// worker runs the jobs in the queue until the Scheduler is closed
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		j := s.queue[0]
+		s.queue[0] = job{}
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		s.run(j)
+	}
+}
+
+// run runs a single job, and closes its connection if the job fails
+func (s *Scheduler) run(j job) {
+	c := j.c
+	if c.closed.Load() {
+		return
+	}
+	var err error
+	switch j.kind {
+	case jobFlush:
+		c.flushQueued.Store(false)
+		err = c.flush()
+	case jobBatchFlush:
+		c.Lock()
+		c.flushPending = false
+		c.Unlock()
+		err = c.flush()
+	case jobPing:
+		err = c.writeWith(PINGPacket, false, nil)
+		if err == nil {
+			s.after(c, jobPing, c.options.Liveness.PingInterval)
+		}
+	case jobRekey:
+		err = c.rekey()
+		if err == nil {
+			s.after(c, jobRekey, c.options.RekeyInterval)
+		}
+	}
+	if err != nil {
+		// Closing the connection may block on its handlers, which must not hold up the jobs of other connections
+		go func() {
+			_ = c.closeWithError(err)
+		}()
+	}
+}
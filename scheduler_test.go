@@ -0,0 +1,110 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+
+	const packets = 32
+
+	emptyLogger := zerolog.New(io.Discard)
+	scheduler := NewScheduler(2)
+	t.Cleanup(scheduler.Close)
+
+	// transfer writes packets from the writer to the reader through the scheduler, and returns the number of
+	// writes to the underlying connection
+	transfer := func(t *testing.T, strategy FlushStrategy) int64 {
+		reader, writer := net.Pipe()
+		counter := &writeCountingConn{Conn: writer, writes: atomic.NewInt64(0)}
+		readerConn := NewAsync(reader, &emptyLogger)
+		writerConn := newAsync(counter, loadOptions(WithLogger(&emptyLogger), WithFlushStrategy(strategy), WithScheduler(scheduler)), NoFeatures)
+
+		for i := 0; i < packets; i++ {
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			require.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+		}
+		for i := 0; i < packets; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			packet.Put(p)
+		}
+
+		assert.Equal(t, []string{"read"}, writerConn.Debug().Goroutines)
+		assert.Contains(t, writerConn.modes(), "scheduled")
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+		return counter.writes.Load()
+	}
+
+	t.Run("immediate", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Positive(t, transfer(t, FlushStrategy{}))
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, int64(1), transfer(t, FlushStrategy{Bytes: packets * metadata.Size, Delay: time.Minute}))
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		t.Parallel()
+
+		assert.LessOrEqual(t, transfer(t, FlushStrategy{Delay: time.Millisecond * 100}), int64(2))
+	})
+}
+
+func TestSchedulerPing(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	scheduler := NewScheduler(1)
+	t.Cleanup(scheduler.Close)
+
+	liveness := Liveness{PingInterval: time.Millisecond * 50, Timeout: time.Millisecond * 300}
+	reader, writer := net.Pipe()
+	readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger), WithLiveness(liveness), WithScheduler(scheduler)), NoFeatures)
+	writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger), WithLiveness(liveness), WithScheduler(scheduler)), NoFeatures)
+
+	// Both connections are kept alive by the pings that the scheduler sends for them
+	time.Sleep(time.Second)
+	assert.False(t, readerConn.Closed())
+	assert.False(t, writerConn.Closed())
+	assert.Equal(t, []string{"read"}, readerConn.Debug().Goroutines)
+
+	// Jobs that are still scheduled for a closed connection are skipped
+	require.NoError(t, writerConn.Close())
+	assert.Eventually(t, readerConn.Closed, time.Second*2, time.Millisecond*10)
+}
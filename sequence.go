@@ -0,0 +1,210 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"go.uber.org/atomic"
+)
+
+const (
+	// MaxSequenceWindow is the largest supported sequence window
+	MaxSequenceWindow = 63
+
+	// sequenceSize is the size of the sequence number in the sequence extension
+	sequenceSize = 8
+
+	// sequenceOffset is the offset of the sequence number in an encoded header, which
+	// directly follows the size of the extended header and the type and length of the sequence extension
+	sequenceOffset = metadata.Size + 1 + 2
+)
+
+// sequenceWindow validates the sequence numbers of the packets read from a connection. It keeps track of the
+// highest sequence number that has been received, along with which of the window sequence numbers before it
+// have been received, so that duplicate (or replayed) packets and packets that arrive too far out of order are rejected.
+type sequenceWindow struct {
+	size       uint64
+	highest    uint64
+	seen       uint64
+	missed     *atomic.Uint64
+	gaps       *atomic.Uint64
+	reordered  *atomic.Uint64
+	duplicates *atomic.Uint64
+	late       *atomic.Uint64
+}
+
+// sequenceResult is how a sequence number relates to the sequence numbers that were received before it
+type sequenceResult uint8
+
+const (
+	sequenceInOrder sequenceResult = iota
+	sequenceGap
+	sequenceReordered
+	sequenceDuplicate
+	sequenceLate
+)
+
+func (r sequenceResult) String() string {
+	switch r {
+	case sequenceInOrder:
+		return "in-order"
+	case sequenceGap:
+		return "gap"
+	case sequenceReordered:
+		return "reordered"
+	case sequenceDuplicate:
+		return "duplicate"
+	default:
+		return "late"
+	}
+}
+
+func newSequenceWindow(size int) *sequenceWindow {
+	return &sequenceWindow{
+		size:       uint64(size),
+		missed:     atomic.NewUint64(0),
+		gaps:       atomic.NewUint64(0),
+		reordered:  atomic.NewUint64(0),
+		duplicates: atomic.NewUint64(0),
+		late:       atomic.NewUint64(0),
+	}
+}
+
+// accept returns whether a packet with the given sequence number should be accepted, and marks it as received
+func (w *sequenceWindow) accept(sequence uint64) bool {
+	result := w.check(sequence)
+	return result != sequenceDuplicate && result != sequenceLate
+}
+
+// check marks the given sequence number as received and returns how it relates to the sequence numbers that were
+// received before it. Duplicate sequence numbers, and sequence numbers that are more than size packets behind
+// the highest sequence number received, are not marked as received.
+func (w *sequenceWindow) check(sequence uint64) sequenceResult {
+	if sequence == 0 {
+		w.duplicates.Inc()
+		return sequenceDuplicate
+	}
+	if sequence > w.highest {
+		shift := sequence - w.highest
+		if shift > MaxSequenceWindow {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.missed.Add(shift - 1)
+		w.highest = sequence
+		if shift > 1 {
+			w.gaps.Inc()
+			return sequenceGap
+		}
+		return sequenceInOrder
+	}
+	offset := w.highest - sequence
+	if offset > w.size {
+		w.late.Inc()
+		return sequenceLate
+	}
+	if w.seen&(1<<offset) != 0 {
+		w.duplicates.Inc()
+		return sequenceDuplicate
+	}
+	w.seen |= 1 << offset
+	w.missed.Dec()
+	w.reordered.Inc()
+	return sequenceReordered
+}
+
+// SequenceDiagnostics counts the anomalies in the sequence numbers of the packets read from a connection
+// (see WithSequenceDiagnostics)
+type SequenceDiagnostics struct {
+	// Missed is the number of packets that were skipped over by the sequence numbers and have not (yet) been received
+	Missed uint64
+
+	// Gaps is the number of times that a sequence number skipped over one or more packets
+	Gaps uint64
+
+	// Reordered is the number of packets that were received after a packet with a higher sequence number
+	Reordered uint64
+
+	// Duplicates is the number of packets whose sequence number had already been received
+	Duplicates uint64
+
+	// Late is the number of packets that were too far behind the highest sequence number to be checked for duplicates
+	Late uint64
+}
+
+// sequenced returns whether the packets on the connection carry sequence numbers
+func (c *Async) sequenced() bool {
+	return c.features.Has(FeatureSequenceNumbers)
+}
+
+// stampSequence writes the next sequence number into the encoded header of a packet that is about to be written.
+// It must be called while the connection is locked, so that sequence numbers are written in order.
+func (c *Async) stampSequence(header []byte) {
+	if c.sequenced() {
+		c.writeSequence++
+		binary.BigEndian.PutUint64(header[sequenceOffset:sequenceOffset+sequenceSize], c.writeSequence)
+	}
+}
+
+// MissedPackets returns the number of packets that were skipped over by the sequence numbers of the packets read from
+// the connection and that have not (yet) been received, which can be used to detect gaps in the packets sent by the peer.
+//
+// If the FeatureSequenceNumbers feature was not negotiated, MissedPackets always returns 0.
+func (c *Async) MissedPackets() uint64 {
+	if c.sequence == nil {
+		return 0
+	}
+	return c.sequence.missed.Load()
+}
+
+// SequenceDiagnostics returns the anomalies in the sequence numbers of the packets read from the connection, which can
+// be used to tell whether packets are being lost or reordered by frisbee, by the application, or by the network.
+//
+// If the FeatureSequenceNumbers feature was not negotiated, SequenceDiagnostics always returns an empty SequenceDiagnostics.
+func (c *Async) SequenceDiagnostics() SequenceDiagnostics {
+	if c.sequence == nil {
+		return SequenceDiagnostics{}
+	}
+	return SequenceDiagnostics{
+		Missed:     c.sequence.missed.Load(),
+		Gaps:       c.sequence.gaps.Load(),
+		Reordered:  c.sequence.reordered.Load(),
+		Duplicates: c.sequence.duplicates.Load(),
+		Late:       c.sequence.late.Load(),
+	}
+}
+
+// checkSequence checks the sequence number of a packet read from the connection, logging any anomalies when
+// sequence diagnostics are enabled, and returns whether the packet should be accepted
+func (c *Async) checkSequence(sequence uint64) bool {
+	highest := c.sequence.highest
+	result := c.sequence.check(sequence)
+	if result == sequenceInOrder {
+		return true
+	}
+	if c.options.SequenceDiagnostics {
+		c.Logger().Warn().Uint64("Sequence", sequence).Uint64("Highest", highest).Str("Anomaly", result.String()).Msg("sequence anomaly detected by read loop")
+		if !c.options.SequenceNumbers {
+			return true
+		}
+	}
+	return result != sequenceDuplicate && result != sequenceLate
+}
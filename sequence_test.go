@@ -0,0 +1,188 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceWindow(t *testing.T) {
+	t.Parallel()
+
+	strict := newSequenceWindow(0)
+	assert.False(t, strict.accept(0))
+	assert.True(t, strict.accept(1))
+	assert.True(t, strict.accept(2))
+	assert.False(t, strict.accept(2))
+	assert.True(t, strict.accept(5))
+	assert.Equal(t, uint64(2), strict.missed.Load())
+	assert.False(t, strict.accept(4))
+	assert.Equal(t, uint64(2), strict.missed.Load())
+
+	window := newSequenceWindow(4)
+	assert.True(t, window.accept(1))
+	assert.True(t, window.accept(4))
+	assert.Equal(t, uint64(2), window.missed.Load())
+	assert.True(t, window.accept(3))
+	assert.False(t, window.accept(3))
+	assert.True(t, window.accept(2))
+	assert.Equal(t, uint64(0), window.missed.Load())
+	assert.False(t, window.accept(1))
+
+	assert.True(t, window.accept(10))
+	assert.False(t, window.accept(5))
+	assert.True(t, window.accept(6))
+	assert.Equal(t, uint64(4), window.missed.Load())
+
+	// Jumps larger than the bitmap reset the received sequence numbers
+	assert.True(t, window.accept(200))
+	assert.False(t, window.accept(200))
+	assert.True(t, window.accept(199))
+
+	diagnostics := newSequenceWindow(2)
+	assert.Equal(t, sequenceInOrder, diagnostics.check(1))
+	assert.Equal(t, sequenceGap, diagnostics.check(4))
+	assert.Equal(t, sequenceReordered, diagnostics.check(3))
+	assert.Equal(t, sequenceDuplicate, diagnostics.check(3))
+	assert.Equal(t, sequenceLate, diagnostics.check(1))
+	assert.Equal(t, uint64(1), diagnostics.gaps.Load())
+	assert.Equal(t, uint64(1), diagnostics.reordered.Load())
+	assert.Equal(t, uint64(1), diagnostics.duplicates.Load())
+	assert.Equal(t, uint64(1), diagnostics.late.Load())
+
+	options := loadOptions(WithSequenceNumbers(MaxSequenceWindow * 2))
+	assert.Equal(t, MaxSequenceWindow, options.SequenceWindow)
+	assert.True(t, options.Handshake)
+	assert.True(t, options.Features.Has(FeatureSequenceNumbers))
+}
+
+func TestAsyncSequenceNumbers(t *testing.T) {
+	t.Parallel()
+
+	const packetCount = 128
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	for name, features := range map[string]Features{"plain": FeatureSequenceNumbers, "extended": FeatureSequenceNumbers | FeatureExtendedHeaders} {
+		features := features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger)), features)
+			writerConn := newAsync(writer, loadOptions(WithLogger(&emptyLogger)), features)
+
+			for i := 0; i < packetCount; i++ {
+				p := packet.Get()
+				p.Metadata.Id = uint16(i)
+				p.Metadata.Operation = metadata.PacketPing
+				p.Content.Write([]byte("sequenced"))
+				p.Metadata.ContentLength = 9
+				require.NoError(t, writerConn.WritePacket(p))
+				packet.Put(p)
+			}
+			for i := 0; i < packetCount; i++ {
+				p, err := readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, uint16(i), p.Metadata.Id)
+				assert.Equal(t, "sequenced", string(*p.Content))
+				packet.Put(p)
+			}
+			assert.Equal(t, uint64(0), readerConn.MissedPackets())
+
+			assert.NoError(t, writerConn.Close())
+			assert.NoError(t, readerConn.Close())
+		})
+	}
+
+	t.Run("replayed", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger)), FeatureSequenceNumbers)
+
+		encode := func(sequence uint64) []byte {
+			header, _ := encodeExtendedHeader(make([]byte, metadata.Size), nil, 0, true)
+			binary.BigEndian.PutUint16(header[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], metadata.PacketPing)
+			binary.BigEndian.PutUint64(header[sequenceOffset:sequenceOffset+sequenceSize], sequence)
+			return header
+		}
+
+		_, err = writer.Write(encode(1))
+		require.NoError(t, err)
+		_, err = writer.Write(encode(3))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			packet.Put(p)
+		}
+		assert.Equal(t, uint64(1), readerConn.MissedPackets())
+
+		_, err = writer.Write(encode(3))
+		require.NoError(t, err)
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), InvalidSequence)
+
+		_ = writer.Close()
+		_ = readerConn.Close()
+	})
+
+	t.Run("diagnostics", func(t *testing.T) {
+		t.Parallel()
+
+		options := loadOptions(WithLogger(&emptyLogger), WithSequenceDiagnostics())
+		assert.Equal(t, MaxSequenceWindow, options.SequenceWindow)
+		assert.True(t, options.Features.Has(FeatureSequenceNumbers))
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, options, FeatureSequenceNumbers)
+
+		encode := func(sequence uint64) []byte {
+			header, _ := encodeExtendedHeader(make([]byte, metadata.Size), nil, 0, true)
+			binary.BigEndian.PutUint16(header[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], metadata.PacketPing)
+			binary.BigEndian.PutUint64(header[sequenceOffset:sequenceOffset+sequenceSize], sequence)
+			return header
+		}
+
+		for _, sequence := range []uint64{1, 4, 3, 3, 5} {
+			_, err = writer.Write(encode(sequence))
+			require.NoError(t, err)
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			packet.Put(p)
+		}
+		assert.Equal(t, SequenceDiagnostics{Missed: 1, Gaps: 1, Reordered: 1, Duplicates: 1}, readerConn.SequenceDiagnostics())
+		assert.Contains(t, readerConn.Stats().Modes, "sequence-diagnostics")
+
+		_ = writer.Close()
+		_ = readerConn.Close()
+	})
+}
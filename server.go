@@ -30,11 +30,23 @@ import (
 )
 
 var (
-	BaseContextNil   = errors.New("BaseContext cannot be nil")
-	OnClosedNil      = errors.New("OnClosed cannot be nil")
-	PreWriteNil      = errors.New("PreWrite cannot be nil")
-	StreamHandlerNil = errors.New("StreamHandler cannot be nil")
-	ListenerNil      = errors.New("Listener cannot be nil")
+	BaseContextNil    = errors.New("BaseContext cannot be nil")
+	OnClosedNil       = errors.New("OnClosed cannot be nil")
+	OnConnectNil      = errors.New("OnConnect cannot be nil")
+	OnDisconnectNil   = errors.New("OnDisconnect cannot be nil")
+	OnHandshakeNil    = errors.New("OnHandshakeComplete cannot be nil")
+	PreWriteNil       = errors.New("PreWrite cannot be nil")
+	StreamHandlerNil  = errors.New("StreamHandler cannot be nil")
+	FeaturePolicyNil  = errors.New("FeaturePolicy cannot be nil")
+	LivenessPolicyNil = errors.New("LivenessPolicy cannot be nil")
+	PeerIdentifierNil = errors.New("PeerIdentifier cannot be nil")
+	VerifierNil       = errors.New("Verifier cannot be nil")
+	HealthReporterNil = errors.New("HealthReporter cannot be nil")
+	HandoffNil        = errors.New("Handoff cannot be nil")
+	AcceptFilterNil   = errors.New("AcceptFilter cannot be nil")
+	SNIPolicyNil      = errors.New("SNIPolicy cannot be nil")
+	ListenerNil       = errors.New("Listener cannot be nil")
+	HandlerNil        = errors.New("Handler cannot be nil")
 )
 
 var (
@@ -52,11 +64,15 @@ var (
 // Server accepts connections from frisbee Clients and can send and receive frisbee Packets
 type Server struct {
 	listener      net.Listener
-	handlerTable  HandlerTable
+	handlerTable  atomic.Pointer[HandlerTable]
+	handlersMu    sync.Mutex
 	shutdown      *atomic.Bool
+	draining      *atomic.Bool
+	accepted      *atomic.Uint64
 	options       *Options
 	wg            sync.WaitGroup
-	connections   map[*Async]struct{}
+	connections   map[uint64]*Async
+	peers         map[string]*Async
 	connectionsMu sync.Mutex
 	startedCh     chan struct{}
 	concurrency   uint64
@@ -68,12 +84,73 @@ type Server struct {
 	// onClosed is a function run by the server whenever a connection is closed
 	onClosed func(*Async, error)
 
+	// onHandshakeComplete is run by the server once an incoming connection has completed its handshake (if nil, it is not run)
+	onHandshakeComplete func(*Async)
+
+	// onConnect is run by the server once an incoming connection has been accepted (if nil, it is not run)
+	onConnect func(*Async)
+
+	// onDisconnect is run by the server once for every accepted connection after it is removed (if nil, it is not run)
+	onDisconnect func(*Async, error)
+
 	// preWrite is run by the server before a write happens
 	preWrite func()
 
 	// streamHandler is used to handle incoming client-initiated streams on the server
 	streamHandler func(*Stream)
 
+	// featurePolicy is used to decide which of the requested features are enabled for an incoming connection
+	featurePolicy FeaturePolicy
+
+	// wired is true if the wire wrappers are installed by the server's listener
+	wired bool
+
+	// livenessPolicy is used to decide the Liveness of an incoming connection (if nil, options.Liveness is used)
+	livenessPolicy LivenessPolicy
+
+	// sniPolicy is used to choose the HandlerTable of an incoming connection based on its server name (if nil, the
+	// HandlerTable of the server is used for every connection)
+	sniPolicy SNIPolicy
+
+	// peerIdentifier is used to identify the peer of an incoming connection (if nil, connections are not indexed by peer ID)
+	peerIdentifier PeerIdentifier
+
+	// verifier is used to authenticate incoming connections (if nil, connections are not authenticated)
+	verifier Verifier
+
+	// duplicatePolicy decides what happens when a peer connects while it already has a connection
+	duplicatePolicy DuplicatePolicy
+
+	// acceptFilter is used to reject incoming connections based on their remote address (if nil, all connections are accepted)
+	acceptFilter AcceptFilter
+
+	// slots limits the number of connections handled by the server at the same time (if nil, the number is unlimited)
+	slots chan struct{}
+
+	// overflowPolicy decides what happens to incoming connections when there are no free slots
+	overflowPolicy OverflowPolicy
+
+	// closeCh is closed when the server is shut down
+	closeCh chan struct{}
+
+	// handoff takes ownership of incoming connections once they have been established (if nil, connections are handled by the server)
+	handoff func(context.Context, *Async)
+
+	// deprecations holds the deprecated operations of the server (if nil, no operations are deprecated)
+	deprecations map[uint16]Deprecation
+
+	// reconnects tracks how often remote identities connect to the server (if nil, reconnects are not tracked)
+	reconnects *reconnects
+
+	// sessions holds the sessions of the server's connections (if nil, the session layer is disabled)
+	sessions *sessions
+
+	// tenants tracks the stream quotas of the tenants of the server (if nil, streams are not limited)
+	tenants *tenants
+
+	// healthReporter decides the Health reported by the server (if nil, the server reports its own view of its Health)
+	healthReporter HealthReporter
+
 	// ConnContext is used to define a connection-specific context based on the incoming connection
 	// and is run whenever a new connection is opened
 	ConnContext func(context.Context, *Async) context.Context
@@ -94,12 +171,24 @@ func NewServer(handlerTable HandlerTable, opts ...Option) (*Server, error) {
 	s := &Server{
 		options:       options,
 		shutdown:      atomic.NewBool(false),
-		connections:   make(map[*Async]struct{}),
+		draining:      atomic.NewBool(false),
+		accepted:      atomic.NewUint64(0),
+		connections:   make(map[uint64]*Async),
+		peers:         make(map[string]*Async),
 		startedCh:     make(chan struct{}),
+		closeCh:       make(chan struct{}),
 		baseContext:   defaultBaseContext,
 		onClosed:      defaultOnClosed,
 		preWrite:      defaultPreWrite,
 		streamHandler: defaultStreamHandler,
+		featurePolicy: defaultFeaturePolicy,
+	}
+	options.health = s.health
+	if options.Session != nil {
+		s.sessions = &sessions{
+			session:  options.Session.withDefaults(),
+			sessions: make(map[[sessionTokenSize]byte]*sessionConn),
+		}
 	}
 
 	return s, s.SetHandlerTable(handlerTable)
@@ -123,6 +212,46 @@ func (s *Server) SetOnClosed(f func(*Async, error)) error {
 	return nil
 }
 
+// SetOnHandshakeComplete sets a function that is run by the server once an incoming connection has completed its handshake
+// and has been authenticated and identified (see SetVerifier and SetPeerIdentifier), with the connection along with its
+// negotiated Features and peer ID. It runs before the connection is accepted, so it also runs for connections that are then
+// rejected (for example as duplicates). If f is nil, it returns an error.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOnHandshakeComplete(f func(*Async)) error {
+	if f == nil {
+		return OnHandshakeNil
+	}
+	s.onHandshakeComplete = f
+	return nil
+}
+
+// SetOnConnect sets a function that is run by the server once an incoming connection has been accepted, before any of
+// its packets are handled, which allows applications to maintain presence state together with SetOnDisconnect. It is
+// also run for connections that are handed off (see SetHandoff). If f is nil, it returns an error.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOnConnect(f func(*Async)) error {
+	if f == nil {
+		return OnConnectNil
+	}
+	s.onConnect = f
+	return nil
+}
+
+// SetOnDisconnect sets a function that is run by the server exactly once for every connection that SetOnConnect ran for
+// (apart from connections that were handed off), once the connection has been closed and removed from the server, with the
+// error that caused the connection to close (see Async.Error), whose CloseReason tells why. If f is nil, it returns an error.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOnDisconnect(f func(*Async, error)) error {
+	if f == nil {
+		return OnDisconnectNil
+	}
+	s.onDisconnect = f
+	return nil
+}
+
 // SetPreWrite sets the preWrite function for the server. If f is nil, it returns an error.
 func (s *Server) SetPreWrite(f func()) error {
 	if f == nil {
@@ -143,9 +272,98 @@ func (s *Server) SetStreamHandler(f func(*Async, *Stream)) error {
 	return nil
 }
 
-// SetHandlerTable sets the handler table for the server.
+// SetFeaturePolicy sets the featurePolicy function for the server. If f is nil, it returns an error.
+//
+// The policy is only used when the handshake has been enabled using the WithFeatures option.
+func (s *Server) SetFeaturePolicy(f FeaturePolicy) error {
+	if f == nil {
+		return FeaturePolicyNil
+	}
+	s.featurePolicy = f
+	return nil
+}
+
+// SetLivenessPolicy sets the livenessPolicy function for the server, which is used to set the Liveness of
+// every incoming connection based on its remote address. If f is nil, it returns an error.
+func (s *Server) SetLivenessPolicy(f LivenessPolicy) error {
+	if f == nil {
+		return LivenessPolicyNil
+	}
+	s.livenessPolicy = f
+	return nil
+}
+
+// SetSNIPolicy sets the sniPolicy function for the server, which is used to choose the HandlerTable of (or reject)
+// every incoming connection based on the server name that it requested using SNI. If f is nil, it returns an error.
+func (s *Server) SetSNIPolicy(f SNIPolicy) error {
+	if f == nil {
+		return SNIPolicyNil
+	}
+	s.sniPolicy = f
+	return nil
+}
+
+// SetPeerIdentifier sets the peerIdentifier function for the server, which is used to identify the peer of
+// every incoming connection so that it can be looked up using Server.Connection. If f is nil, it returns an error.
+func (s *Server) SetPeerIdentifier(f PeerIdentifier) error {
+	if f == nil {
+		return PeerIdentifierNil
+	}
+	s.peerIdentifier = f
+	return nil
+}
+
+// SetVerifier sets the verifier function for the server, which is used to authenticate every incoming connection
+// before any packets are read from it. The identity returned by the verifier is used as the PeerID of the connection
+// (unless a PeerIdentifier has also been set). If f is nil, it returns an error.
+func (s *Server) SetVerifier(f Verifier) error {
+	if f == nil {
+		return VerifierNil
+	}
+	s.verifier = f
+	return nil
+}
+
+// SetDuplicatePolicy sets the DuplicatePolicy of the server, which decides what happens when a peer connects while
+// it already has a connection with the same peer ID. The default policy is KickOld.
+func (s *Server) SetDuplicatePolicy(policy DuplicatePolicy) {
+	s.connectionsMu.Lock()
+	s.duplicatePolicy = policy
+	s.connectionsMu.Unlock()
+}
+
+// SetAcceptFilter sets the acceptFilter function for the server, which is called with the remote address of every
+// incoming connection before anything else is done with it (see CIDRFilter). If f is nil, it returns an error.
 //
 // This function should not be called once the server has started.
+func (s *Server) SetAcceptFilter(f AcceptFilter) error {
+	if f == nil {
+		return AcceptFilterNil
+	}
+	s.acceptFilter = f
+	return nil
+}
+
+// SetHandoff sets the handoff function for the server, which makes the server hand every incoming connection to f
+// once it has been fully established (after the TLS handshake, the HELLO handshake, authentication, and peer identification)
+// instead of handling its packets using the handler table. If f is nil, it returns an error.
+//
+// The handoff function takes ownership of the connection: it is responsible for reading packets from it and closing it,
+// and the connection is not tracked by the server (so it is not closed by Shutdown, and cannot be reached using Broadcast,
+// WriteTo, or Connection). The context passed to f is created using the baseContext and ConnContext functions of the server.
+//
+// The handoff function is called in the goroutine that established the connection, which the server does not wait for
+// once f has been called. This function should not be called once the server has started.
+func (s *Server) SetHandoff(f func(context.Context, *Async)) error {
+	if f == nil {
+		return HandoffNil
+	}
+	s.handoff = f
+	return nil
+}
+
+// SetHandlerTable sets the handler table for the server. The handler table must not be modified
+// afterwards, use Handle and RemoveHandler to change the handlers of a running server instead.
 func (s *Server) SetHandlerTable(handlerTable HandlerTable) error {
 	for i := uint16(0); i < RESERVED9; i++ {
 		if _, ok := handlerTable[i]; ok {
@@ -153,15 +371,58 @@ func (s *Server) SetHandlerTable(handlerTable HandlerTable) error {
 		}
 	}
 
-	s.handlerTable = handlerTable
+	s.handlersMu.Lock()
+	s.handlerTable.Store(&handlerTable)
+	s.handlersMu.Unlock()
 	return nil
 }
 
-// GetHandlerTable gets the handler table for the server.
-//
-// This function should not be called once the server has started.
+// GetHandlerTable gets the handler table for the server, which must not be modified.
 func (s *Server) GetHandlerTable() HandlerTable {
-	return s.handlerTable
+	if handlerTable := s.handlerTable.Load(); handlerTable != nil {
+		return *handlerTable
+	}
+	return nil
+}
+
+// Handle sets the Handler of the given operation, replacing its previous Handler. Unlike SetHandlerTable, it
+// can be called while the server is running, and the packets that are read once it returns are handled by h.
+// Packets that are already being handled are not affected.
+//
+// If the operation is reserved, InvalidOperation is returned, and if h is nil, HandlerNil is returned.
+func (s *Server) Handle(operation uint16, h Handler) error {
+	if operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if h == nil {
+		return HandlerNil
+	}
+	s.updateHandlerTable(func(handlerTable HandlerTable) {
+		handlerTable[operation] = h
+	})
+	return nil
+}
+
+// RemoveHandler removes the Handler of the given operation while the server is running (see Handle). Packets
+// with the operation are then handled by the Router of the server (see WithRouter), or dropped if there is none.
+func (s *Server) RemoveHandler(operation uint16) {
+	s.updateHandlerTable(func(handlerTable HandlerTable) {
+		delete(handlerTable, operation)
+	})
+}
+
+// updateHandlerTable replaces the handler table with a copy that was modified by update, so that the
+// handler table can be read by the connections of the server without locking
+func (s *Server) updateHandlerTable(update func(HandlerTable)) {
+	s.handlersMu.Lock()
+	current := s.GetHandlerTable()
+	handlerTable := make(HandlerTable, len(current)+1)
+	for operation, h := range current {
+		handlerTable[operation] = h
+	}
+	update(handlerTable)
+	s.handlerTable.Store(&handlerTable)
+	s.handlersMu.Unlock()
 }
 
 // SetConcurrency sets the maximum number of concurrent goroutines that will be created
@@ -184,16 +445,20 @@ func (s *Server) SetConcurrency(concurrency uint64) {
 // onClosed, OnShutdown, or preWrite functions have not been defined, it will
 // use the default functions for these.
 func (s *Server) Start(addr string) error {
-	var listener net.Listener
-	var err error
-	if s.options.TLSConfig != nil {
-		listener, err = tls.Listen("tcp", addr, s.options.TLSConfig)
-	} else {
-		listener, err = net.Listen("tcp", addr)
+	listen := net.Listen
+	if s.options.FastOpen {
+		listen = listenFastOpen
 	}
+	listener, err := listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	if s.options.TLSConfig != nil && len(s.options.WireWrappers) > 0 {
+		listener = tls.NewListener(&wireListener{Listener: listener, wrappers: s.options.WireWrappers}, s.options.TLSConfig)
+		s.wired = true
+	} else if s.options.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.options.TLSConfig)
+	}
 	return s.StartWithListener(listener)
 }
 
@@ -249,13 +514,21 @@ func (s *Server) handleListener() error {
 		}
 		backoff = 0
 
-		s.ServeConn(newConn)
+		if !s.accept(newConn) {
+			continue
+		}
+		s.wg.Add(1)
+		go s.serveConn(newConn, s.wired)
 	}
 }
 
 func (s *Server) createHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGroup, ctx context.Context, cancel context.CancelFunc) func(*packet.Packet) {
+	warnings := s.newDeprecationWarnings()
 	return func(p *packet.Packet) {
-		handlerFunc := s.handlerTable[p.Metadata.Operation]
+		handlerFunc := lookupHandler(s.handlerTableOf(conn), s.options.Router, p.Metadata.Operation)
+		if handlerFunc != nil && s.sunset(conn, p, warnings) {
+			handlerFunc = nil
+		}
 		if handlerFunc != nil {
 			packetCtx := ctx
 			if s.PacketContext != nil {
@@ -312,8 +585,12 @@ func (s *Server) handleSinglePacket(frisbeeConn *Async, connCtx context.Context)
 	if s.ConnContext != nil {
 		connCtx = s.ConnContext(connCtx, frisbeeConn)
 	}
+	warnings := s.newDeprecationWarnings()
 	for {
-		handlerFunc = s.handlerTable[p.Metadata.Operation]
+		handlerFunc = lookupHandler(s.handlerTableOf(frisbeeConn), s.options.Router, p.Metadata.Operation)
+		if handlerFunc != nil && s.sunset(frisbeeConn, p, warnings) {
+			handlerFunc = nil
+		}
 		if handlerFunc != nil {
 			packetCtx := connCtx
 			if s.PacketContext != nil {
@@ -428,15 +705,24 @@ func (s *Server) handleLimitedPacket(frisbeeConn *Async, connCtx context.Context
 	}
 }
 
-// ServeConn takes a net.Conn and starts a goroutine to handle it using the Server.
+// ServeConn takes a net.Conn and starts a goroutine to handle it using the Server (unless it is rejected by the AcceptFilter).
 func (s *Server) ServeConn(conn net.Conn) {
+	if !s.accept(conn) {
+		return
+	}
 	s.wg.Add(1)
-	go s.serveConn(conn)
+	go s.serveConn(conn, false)
 }
 
 // serveConn takes a net.Conn and serves it using the Server
 // and assumes that the server's wait group has been incremented by 1.
-func (s *Server) serveConn(newConn net.Conn) {
+//
+// If wired is true, the wire wrappers have already been installed on the connection.
+func (s *Server) serveConn(newConn net.Conn, wired bool) {
+	if s.slots != nil {
+		defer s.release()
+	}
+
 	var err error
 	switch v := newConn.(type) {
 	case *net.TCPConn:
@@ -456,15 +742,176 @@ func (s *Server) serveConn(newConn net.Conn) {
 		}
 	}
 
-	frisbeeConn := NewAsync(newConn, s.Logger(), s.streamHandler)
-	connCtx := s.baseContext()
+	if s.options.KeepAliveConfig != nil {
+		err = s.options.KeepAliveConfig.apply(newConn)
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error while setting TCP Keepalive Config")
+			_ = newConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+
+	if s.options.SocketOptions != nil {
+		err = s.options.SocketOptions.apply(newConn)
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error while setting socket options")
+			_ = newConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+
+	if s.options.Session != nil {
+		var resumed bool
+		newConn, resumed, err = s.sessions.accept(newConn)
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error while starting or resuming session")
+		}
+		if err != nil || resumed {
+			s.wg.Done()
+			return
+		}
+	}
+
+	var handlerTable HandlerTable
+	if s.sniPolicy != nil {
+		handlerTable, err = s.routeSNI(newConn)
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error while routing connection by server name")
+			_ = newConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+
+	newConn = s.options.wrapConn(newConn, wired)
+
+	features := NoFeatures
+	var signingKeys, encryptionKeys connectionKeys
+	var dict *dictionary
+	if s.options.Handshake {
+		nonce, params, err := s.options.signingParams()
+		var exchange *encryptionExchange
+		if err == nil {
+			exchange, params, err = s.options.encryptionParams(params)
+		}
+		var dictionaries []byte
+		if err == nil {
+			dictionaries, params = s.options.dictionaryParams(params)
+		}
+		if err == nil {
+			var request map[uint8][]byte
+			features, request, err = handshakeAccept(newConn, s.options.Features, s.featurePolicy, params)
+			if err == nil && !features.Has(s.options.requiredFeatures()) {
+				err = FeatureNotNegotiated
+			}
+			if err == nil {
+				signingKeys, err = s.options.connectionSigningKeys(request[helloParamSigningNonce], nonce, false)
+			}
+			if err == nil {
+				encryptionKeys, err = s.options.connectionEncryptionKeys(exchange, request[helloParamEncryptionShare], false)
+			}
+			dict = s.options.negotiateDictionary(request[helloParamDictionaries], dictionaries, features)
+		}
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error during handshake")
+			_ = newConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+
+	var peerID string
+	if s.verifier != nil {
+		peerID, err = authenticateAccept(newConn, s.verifier)
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error during authentication")
+			_ = newConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+
+	options := s.options.withSigningKeys(signingKeys).withEncryptionKeys(encryptionKeys).withDictionary(dict)
+	if s.livenessPolicy != nil {
+		connOptions := *options
+		connOptions.Liveness = s.livenessPolicy(newConn.RemoteAddr()).withDefaults()
+		options = &connOptions
+	}
+
+	streamHandler := s.streamHandler
+	if s.tenants != nil {
+		streamHandler = s.limitStreams
+	}
+	frisbeeConn := newAsync(newConn, options, features, streamHandler)
+	frisbeeConn.peerID = peerID
+	frisbeeConn.handlerTable = handlerTable
+	if s.peerIdentifier != nil {
+		frisbeeConn.peerID, err = s.peerIdentifier(frisbeeConn)
+		if err == nil && frisbeeConn.peerID == "" {
+			err = InvalidHandshake
+		}
+		if err != nil {
+			s.Logger().Error().Err(err).Msg("Error while identifying peer")
+			_ = frisbeeConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+	if frisbeeConn.peerID != "" && !s.allowReconnect("peer:"+frisbeeConn.peerID) {
+		_ = frisbeeConn.Close()
+		s.wg.Done()
+		return
+	}
+	if s.onHandshakeComplete != nil {
+		s.onHandshakeComplete(frisbeeConn)
+	}
+	connCtx, cancel := connContext(s.baseContext(), frisbeeConn, s.closeCh)
+	if s.handoff != nil {
+		if s.shutdown.Load() {
+			_ = frisbeeConn.Close()
+			s.wg.Done()
+			return
+		}
+		if s.ConnContext != nil {
+			connCtx = s.ConnContext(connCtx, frisbeeConn)
+		}
+		if s.onConnect != nil {
+			s.onConnect(frisbeeConn)
+		}
+		s.wg.Done()
+		s.handoff(connCtx, frisbeeConn)
+		return
+	}
 	s.connectionsMu.Lock()
 	if s.shutdown.Load() {
+		s.connectionsMu.Unlock()
+		_ = frisbeeConn.Close()
 		s.wg.Done()
 		return
 	}
-	s.connections[frisbeeConn] = struct{}{}
+	var replaced *Async
+	if frisbeeConn.peerID != "" {
+		replaced, err = s.registerPeer(frisbeeConn)
+		if err != nil {
+			s.connectionsMu.Unlock()
+			s.Logger().Debug().Err(err).Str("Peer ID", frisbeeConn.peerID).Msg("Rejecting duplicate connection")
+			_ = frisbeeConn.Close()
+			s.wg.Done()
+			return
+		}
+	}
+	s.connections[frisbeeConn.ID()] = frisbeeConn
 	s.connectionsMu.Unlock()
+	s.accepted.Inc()
+	if replaced != nil {
+		s.Logger().Debug().Str("Peer ID", frisbeeConn.peerID).Msg("Closing replaced connection of peer")
+		_ = replaced.Close()
+	}
+	if s.onConnect != nil {
+		s.onConnect(frisbeeConn)
+	}
 	if s.concurrency == 0 {
 		s.handleUnlimitedPacket(frisbeeConn, connCtx)
 	} else if s.concurrency == 1 {
@@ -472,26 +919,36 @@ func (s *Server) serveConn(newConn net.Conn) {
 	} else {
 		s.handleLimitedPacket(frisbeeConn, connCtx)
 	}
+	cancel()
 	s.connectionsMu.Lock()
 	if !s.shutdown.Load() {
-		delete(s.connections, frisbeeConn)
+		delete(s.connections, frisbeeConn.ID())
+		s.unregisterPeer(frisbeeConn)
 	}
 	s.connectionsMu.Unlock()
+	if s.onDisconnect != nil {
+		s.onDisconnect(frisbeeConn, frisbeeConn.Error())
+	}
 	s.wg.Done()
 }
 
 // Logger returns the server's logger (useful for ServerRouter functions)
 func (s *Server) Logger() *zerolog.Logger {
-	return s.options.Logger
+	return s.options.logger()
 }
 
 // Shutdown shuts down the frisbee server and kills all the goroutines and active connections
 func (s *Server) Shutdown() error {
-	s.shutdown.Store(true)
+	if s.shutdown.CompareAndSwap(false, true) {
+		close(s.closeCh)
+	}
 	s.connectionsMu.Lock()
-	for c := range s.connections {
+	for id, c := range s.connections {
 		_ = c.Close()
-		delete(s.connections, c)
+		delete(s.connections, id)
+	}
+	for peerID := range s.peers {
+		delete(s.peers, peerID)
 	}
 	s.connectionsMu.Unlock()
 	defer s.wg.Wait()
@@ -865,6 +865,68 @@ func TestServerMultipleConnectionsLimited(t *testing.T) {
 	t.Run("100", func(t *testing.T) { runner(t, 100) })
 }
 
+func TestServerHandoff(t *testing.T) {
+	t.Parallel()
+
+	type connKey struct{}
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	server, err := NewServer(nil, WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+	require.NoError(t, err)
+	assert.ErrorIs(t, server.SetHandoff(nil), HandoffNil)
+	server.ConnContext = func(ctx context.Context, c *Async) context.Context {
+		return context.WithValue(ctx, connKey{}, c.ID())
+	}
+	handedOff := make(chan *Async, 1)
+	require.NoError(t, server.SetHandoff(func(ctx context.Context, c *Async) {
+		assert.Equal(t, c.ID(), ctx.Value(connKey{}))
+		handedOff <- c
+	}))
+
+	go func() {
+		_ = server.Start(conn.Listen)
+	}()
+	<-server.started()
+
+	received := make(chan struct{}, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- struct{}{}
+		return
+	}
+	client, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+	require.NoError(t, err)
+	require.NoError(t, client.Connect(server.listener.Addr().String()))
+
+	serverConn := <-handedOff
+	assert.True(t, serverConn.Features().Has(FeatureStreamClose))
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	require.NoError(t, client.WritePacket(p))
+	packet.Put(p)
+
+	// Packets are read by the owner of the connection instead of the server's handler table
+	p, err = serverConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	p.Metadata.Operation = metadata.PacketPong
+	require.NoError(t, serverConn.WritePacket(p))
+	packet.Put(p)
+	<-received
+
+	// Connections that were handed off are not tracked or closed by the server
+	server.connectionsMu.Lock()
+	assert.Empty(t, server.connections)
+	server.connectionsMu.Unlock()
+	require.NoError(t, server.Shutdown())
+	assert.False(t, serverConn.Closed())
+
+	assert.NoError(t, serverConn.Close())
+	_ = client.Close()
+}
+
 func BenchmarkThroughputServerSingle(b *testing.B) {
 	const testSize = 1<<16 - 1
 	const packetSize = 512
@@ -1400,3 +1462,106 @@ func BenchmarkThroughputResponseServerSlowLimited(b *testing.B) {
 		b.Fatal(err)
 	}
 }
+
+func TestServerLifecycleCallbacks(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	server, err := NewServer(HandlerTable{}, WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, server.SetOnHandshakeComplete(nil), OnHandshakeNil)
+	assert.ErrorIs(t, server.SetOnConnect(nil), OnConnectNil)
+	assert.ErrorIs(t, server.SetOnDisconnect(nil), OnDisconnectNil)
+
+	events := make(chan string, 3)
+	require.NoError(t, server.SetOnHandshakeComplete(func(c *Async) {
+		events <- "handshake " + c.Features().String()
+	}))
+	require.NoError(t, server.SetOnConnect(func(c *Async) {
+		events <- "connect"
+	}))
+	require.NoError(t, server.SetOnDisconnect(func(c *Async, err error) {
+		server.connectionsMu.Lock()
+		_, ok := server.connections[c.ID()]
+		server.connectionsMu.Unlock()
+		assert.False(t, ok)
+		events <- "disconnect"
+	}))
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+
+	assert.Equal(t, "handshake stream-close", <-events)
+	assert.Equal(t, "connect", <-events)
+	require.NoError(t, client.Close())
+	assert.Equal(t, "disconnect", <-events)
+
+	require.NoError(t, server.Shutdown())
+}
+
+func TestServerDynamicHandlers(t *testing.T) {
+	t.Parallel()
+
+	const operation = uint16(10)
+
+	emptyLogger := zerolog.New(io.Discard)
+	server, err := NewServer(HandlerTable{operation: namedHandler("initial")}, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	server.SetConcurrency(1)
+
+	assert.ErrorIs(t, server.Handle(PING, namedHandler("reserved")), InvalidOperation)
+	assert.ErrorIs(t, server.Handle(operation, nil), HandlerNil)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	responses := make(chan string, 4)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[operation] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		responses <- string(*incoming.Content)
+		return nil, NONE
+	}
+	client, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+
+	request := func() {
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		require.NoError(t, client.WritePacket(p))
+		packet.Put(p)
+	}
+
+	request()
+	assert.Equal(t, "initial", <-responses)
+
+	table := server.GetHandlerTable()
+	require.NoError(t, server.Handle(operation, namedHandler("replaced")))
+	request()
+	assert.Equal(t, "replaced", <-responses)
+
+	// Handler tables that were returned before the handlers were changed are not modified
+	p := packet.Get()
+	outgoing, _ := table[operation](context.Background(), p)
+	assert.Equal(t, "initial", string(*outgoing.Content))
+	packet.Put(p)
+
+	// Packets without a handler are dropped (the packet may also be answered by the added handler if
+	// it is read after the handler was added)
+	server.RemoveHandler(operation)
+	assert.Empty(t, server.GetHandlerTable())
+	request()
+	require.NoError(t, server.Handle(operation, namedHandler("added")))
+	request()
+	assert.Equal(t, "added", <-responses)
+
+	require.NoError(t, client.Close())
+	require.NoError(t, server.Shutdown())
+}
@@ -0,0 +1,527 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSessionBufferSize is the default number of sent bytes that a session keeps to replay after resuming
+	DefaultSessionBufferSize = 1 << 20
+
+	// DefaultSessionTimeout is the default time that a session waits to be resumed after its connection breaks
+	DefaultSessionTimeout = time.Second * 10
+)
+
+const (
+	// sessionTokenSize is the size of the token that identifies a session
+	sessionTokenSize = 16
+
+	// sessionHelloSize is the size of the message that starts every connection of a session, which holds
+	// the status of the session, its token, and the number of bytes that the sender has received on it
+	sessionHelloSize = 1 + sessionTokenSize + 8
+
+	// minSessionBackoff and maxSessionBackoff bound the delay between the attempts of a client to resume a session
+	minSessionBackoff = time.Millisecond * 50
+	maxSessionBackoff = time.Second
+)
+
+// These are the statuses of the session that are exchanged at the start of every connection:
+const (
+	// sessionNew requests a new session, or acknowledges that one was created
+	sessionNew = byte(iota)
+
+	// sessionResume requests that an existing session be resumed, or acknowledges that it was resumed
+	sessionResume
+
+	// sessionUnknown is replied by the server when the session that the client requested cannot be resumed
+	sessionUnknown
+)
+
+// Session configures the session layer of a frisbee client and server (see the WithSession option), which keeps a
+// connection alive across brief network failures by reconnecting and replaying the bytes that the peer did not
+// receive. Since the session sits below the frisbee connection, open streams and in-flight packets survive the
+// reconnect without the application noticing anything but a delay.
+//
+// A closed connection cannot be told apart from a broken one, so when one side of a session is closed without the
+// other side closing it as well, the other side only notices once it fails to resume the session.
+type Session struct {
+	// BufferSize is the number of sent bytes that are kept to be replayed after resuming the session, which bounds
+	// how much data can be in flight when the connection breaks (defaults to DefaultSessionBufferSize)
+	BufferSize int
+
+	// Timeout is how long the session waits to be resumed after its connection breaks before it fails
+	// (defaults to DefaultSessionTimeout)
+	Timeout time.Duration
+}
+
+// withDefaults returns the Session with the default values for the fields that have not been set
+func (s Session) withDefaults() Session {
+	if s.BufferSize <= 0 {
+		s.BufferSize = DefaultSessionBufferSize
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = DefaultSessionTimeout
+	}
+	return s
+}
+
+// replayBuffer keeps the most recent bytes written to a session
+type replayBuffer struct {
+	buf   []byte
+	start uint64
+	sent  uint64
+}
+
+// write adds b to the buffer, which forgets the oldest bytes once it is full
+func (r *replayBuffer) write(b []byte) {
+	size := uint64(len(r.buf))
+	for len(b) > 0 {
+		n := copy(r.buf[r.sent%size:], b)
+		b = b[n:]
+		r.sent += uint64(n)
+	}
+	if r.sent-r.start > size {
+		r.start = r.sent - size
+	}
+}
+
+// truncate removes the last n bytes that were written to the buffer
+func (r *replayBuffer) truncate(n int) {
+	r.sent -= uint64(n)
+	if r.start > r.sent {
+		r.start = r.sent
+	}
+}
+
+// since returns the bytes written after the first offset bytes, and false if they are no longer in the buffer
+func (r *replayBuffer) since(offset uint64) ([]byte, bool) {
+	if offset < r.start || offset > r.sent {
+		return nil, false
+	}
+	size := uint64(len(r.buf))
+	data := make([]byte, 0, r.sent-offset)
+	for offset < r.sent {
+		end := offset - offset%size + size
+		if end > r.sent {
+			end = r.sent
+		}
+		data = append(data, r.buf[offset%size:offset%size+end-offset]...)
+		offset = end
+	}
+	return data, true
+}
+
+// sessionConn is the net.Conn of a session, which replaces its underlying connection whenever the session is
+// resumed. The client resumes the session by redialing the server, and the server waits for the client to do so.
+type sessionConn struct {
+	token   [sessionTokenSize]byte
+	session Session
+
+	// redial creates a new connection to the server (nil for the sessions of the server)
+	redial func() (net.Conn, error)
+
+	// onClose is called once the session is closed or fails
+	onClose func()
+
+	// mu guards the underlying connection, and cond is broadcast whenever it is replaced or the session fails
+	mu            sync.Mutex
+	cond          *sync.Cond
+	conn          net.Conn
+	generation    uint64
+	broken        bool
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// readMu guards received, which counts the bytes read from the session
+	readMu   sync.Mutex
+	received uint64
+
+	// writeMu guards replay, and serializes the writes to the underlying connection
+	writeMu sync.Mutex
+	replay  replayBuffer
+}
+
+func newSessionConn(conn net.Conn, token [sessionTokenSize]byte, session Session) *sessionConn {
+	s := &sessionConn{
+		token:   token,
+		session: session,
+		conn:    conn,
+		replay:  replayBuffer{buf: make([]byte, session.BufferSize)},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// dialSession starts a new session on conn, which redials the server using redial to resume the session
+// whenever its connection breaks
+func dialSession(conn net.Conn, session Session, redial func() (net.Conn, error)) (net.Conn, error) {
+	session = session.withDefaults()
+	status, token, _, err := exchangeSessionHello(conn, session.Timeout, sessionNew, [sessionTokenSize]byte{}, 0)
+	if err == nil && status != sessionNew {
+		err = SessionExpired
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	s := newSessionConn(conn, token, session)
+	s.redial = redial
+	return s, nil
+}
+
+// exchangeSessionHello writes the session hello with the given status, token, and received bytes to conn,
+// and reads the peer's session hello from it
+func exchangeSessionHello(conn net.Conn, timeout time.Duration, status byte, token [sessionTokenSize]byte, received uint64) (byte, [sessionTokenSize]byte, uint64, error) {
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	defer func() {
+		_ = conn.SetDeadline(emptyTime)
+	}()
+	if err := writeSessionHello(conn, status, token, received); err != nil {
+		return 0, token, 0, err
+	}
+	return readSessionHello(conn)
+}
+
+// writeSessionHello writes a session hello with the given status, token, and received bytes to conn
+func writeSessionHello(conn net.Conn, status byte, token [sessionTokenSize]byte, received uint64) error {
+	var hello [sessionHelloSize]byte
+	hello[0] = status
+	copy(hello[1:], token[:])
+	binary.BigEndian.PutUint64(hello[1+sessionTokenSize:], received)
+	_, err := conn.Write(hello[:])
+	return err
+}
+
+// readSessionHello reads a session hello from conn
+func readSessionHello(conn net.Conn) (status byte, token [sessionTokenSize]byte, received uint64, err error) {
+	var hello [sessionHelloSize]byte
+	if _, err = io.ReadFull(conn, hello[:]); err != nil {
+		return
+	}
+	status = hello[0]
+	copy(token[:], hello[1:])
+	received = binary.BigEndian.Uint64(hello[1+sessionTokenSize:])
+	return
+}
+
+// current waits until the session is not being resumed, and returns its underlying connection and generation
+func (s *sessionConn) current() (net.Conn, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.broken && s.err == nil {
+		s.cond.Wait()
+	}
+	return s.conn, s.generation, s.err
+}
+
+// valid returns whether the given generation of the underlying connection is still in use
+func (s *sessionConn) valid(generation uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.broken && s.err == nil && s.generation == generation
+}
+
+func (s *sessionConn) Read(b []byte) (int, error) {
+	for {
+		conn, generation, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+		s.readMu.Lock()
+		n, err := conn.Read(b)
+		s.received += uint64(n)
+		s.readMu.Unlock()
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, err
+		}
+		s.detach(generation)
+	}
+}
+
+func (s *sessionConn) Write(b []byte) (int, error) {
+	for {
+		conn, generation, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+		s.writeMu.Lock()
+		if !s.valid(generation) {
+			s.writeMu.Unlock()
+			continue
+		}
+		s.replay.write(b)
+		n, err := conn.Write(b)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.replay.truncate(len(b) - n)
+			s.writeMu.Unlock()
+			return n, err
+		}
+		s.writeMu.Unlock()
+		if err != nil {
+			// b is already in the replay buffer, so it is written once the session has been resumed
+			s.detach(generation)
+			if _, _, err = s.current(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+}
+
+// detach closes the given generation of the underlying connection (if it is still in use), and starts resuming the
+// session. Clients redial the server, and servers fail the session unless it is resumed within the session's Timeout.
+func (s *sessionConn) detach(generation uint64) {
+	s.mu.Lock()
+	if s.broken || s.err != nil || s.generation != generation {
+		s.mu.Unlock()
+		return
+	}
+	s.broken = true
+	_ = s.conn.Close()
+	s.mu.Unlock()
+	if s.redial != nil {
+		go s.resume()
+	} else {
+		time.AfterFunc(s.session.Timeout, func() {
+			s.mu.Lock()
+			expired := s.broken && s.generation == generation
+			s.mu.Unlock()
+			if expired {
+				s.fail(SessionExpired)
+			}
+		})
+	}
+}
+
+// resume redials the server until the session is resumed, or until the session's Timeout has passed
+func (s *sessionConn) resume() {
+	deadline := time.Now().Add(s.session.Timeout)
+	backoff := minSessionBackoff
+	for time.Now().Before(deadline) {
+		conn, err := s.redial()
+		if err == nil {
+			s.readMu.Lock()
+			received := s.received
+			s.readMu.Unlock()
+			var status byte
+			var peerReceived uint64
+			status, _, peerReceived, err = exchangeSessionHello(conn, s.session.Timeout, sessionResume, s.token, received)
+			if err == nil && status != sessionResume {
+				_ = conn.Close()
+				s.fail(SessionExpired)
+				return
+			}
+			if err == nil {
+				err = s.attach(conn, peerReceived)
+			}
+			if err == nil {
+				return
+			}
+			_ = conn.Close()
+			if errors.Is(err, SessionExpired) || errors.Is(err, net.ErrClosed) {
+				s.fail(err)
+				return
+			}
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxSessionBackoff {
+			backoff = maxSessionBackoff
+		}
+	}
+	s.fail(SessionExpired)
+}
+
+// attach replays the bytes that the peer has not received on conn, and makes it the underlying connection
+func (s *sessionConn) attach(conn net.Conn, peerReceived uint64) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	data, ok := s.replay.since(peerReceived)
+	if !ok {
+		return SessionExpired
+	}
+	if len(data) > 0 {
+		if _, err := conn.Write(data); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	_ = conn.SetReadDeadline(s.readDeadline)
+	_ = conn.SetWriteDeadline(s.writeDeadline)
+	s.conn = conn
+	s.generation++
+	s.broken = false
+	s.cond.Broadcast()
+	return nil
+}
+
+// fail closes the session with the given error
+func (s *sessionConn) fail(err error) error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.err = err
+	conn := s.conn
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if s.onClose != nil {
+		s.onClose()
+	}
+	return conn.Close()
+}
+
+func (s *sessionConn) Close() error {
+	return s.fail(net.ErrClosed)
+}
+
+func (s *sessionConn) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.LocalAddr()
+}
+
+func (s *sessionConn) RemoteAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.RemoteAddr()
+}
+
+// SetDeadline, SetReadDeadline, and SetWriteDeadline record the deadlines of the session, which are applied to the
+// underlying connection. While the session is broken they are only recorded, and attach applies them to the next
+// underlying connection, so that writes wait for the session to be resumed instead of failing on the dead connection.
+func (s *sessionConn) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline, s.writeDeadline = t, t
+	if s.broken {
+		return nil
+	}
+	return s.conn.SetDeadline(t)
+}
+
+func (s *sessionConn) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	if s.broken {
+		return nil
+	}
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *sessionConn) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	if s.broken {
+		return nil
+	}
+	return s.conn.SetWriteDeadline(t)
+}
+
+// sessions holds the sessions of a server, indexed by their tokens
+type sessions struct {
+	session  Session
+	mu       sync.Mutex
+	sessions map[[sessionTokenSize]byte]*sessionConn
+}
+
+// accept reads the session hello from an incoming connection, and either starts a new session on conn or resumes
+// the existing session with conn. It returns true if an existing session was resumed, in which case the connection
+// is already being served, and closes conn if it returns an error.
+func (s *sessions) accept(conn net.Conn) (net.Conn, bool, error) {
+	_ = conn.SetDeadline(time.Now().Add(s.session.Timeout))
+	status, token, received, err := readSessionHello(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, false, err
+	}
+
+	if status == sessionNew {
+		if _, err = rand.Read(token[:]); err == nil {
+			err = writeSessionHello(conn, sessionNew, token, 0)
+		}
+		_ = conn.SetDeadline(emptyTime)
+		if err != nil {
+			_ = conn.Close()
+			return nil, false, err
+		}
+		session := newSessionConn(conn, token, s.session)
+		session.onClose = func() {
+			s.mu.Lock()
+			if s.sessions[token] == session {
+				delete(s.sessions, token)
+			}
+			s.mu.Unlock()
+		}
+		s.mu.Lock()
+		s.sessions[token] = session
+		s.mu.Unlock()
+		return session, false, nil
+	}
+
+	s.mu.Lock()
+	session := s.sessions[token]
+	s.mu.Unlock()
+	if status != sessionResume || session == nil {
+		_ = writeSessionHello(conn, sessionUnknown, token, 0)
+		_ = conn.Close()
+		return nil, false, SessionExpired
+	}
+
+	// The old connection of the session may not have failed yet, so it is detached before reading how much was received
+	session.mu.Lock()
+	generation := session.generation
+	session.mu.Unlock()
+	session.detach(generation)
+	session.readMu.Lock()
+	ownReceived := session.received
+	session.readMu.Unlock()
+
+	err = writeSessionHello(conn, sessionResume, token, ownReceived)
+	_ = conn.SetDeadline(emptyTime)
+	if err == nil {
+		err = session.attach(conn, received)
+	}
+	if err != nil {
+		_ = conn.Close()
+		if errors.Is(err, SessionExpired) {
+			_ = session.fail(err)
+		}
+		return nil, false, err
+	}
+	return nil, true, nil
+}
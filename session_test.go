@@ -0,0 +1,137 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	t.Parallel()
+
+	r := replayBuffer{buf: make([]byte, 8)}
+	r.write([]byte("hello"))
+	data, ok := r.since(1)
+	require.True(t, ok)
+	assert.Equal(t, "ello", string(data))
+
+	// Writing past the size of the buffer forgets the oldest bytes
+	r.write([]byte(" world"))
+	_, ok = r.since(2)
+	assert.False(t, ok)
+	data, ok = r.since(3)
+	require.True(t, ok)
+	assert.Equal(t, "lo world", string(data))
+
+	r.truncate(3)
+	data, ok = r.since(5)
+	require.True(t, ok)
+	assert.Equal(t, " wo", string(data))
+	_, ok = r.since(9)
+	assert.False(t, ok)
+}
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	const op = 10
+
+	emptyLogger := zerolog.New(io.Discard)
+	session := Session{Timeout: DefaultDeadline * 5}
+
+	server, err := NewServer(HandlerTable{
+		op: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return incoming, NONE
+		},
+	}, WithLogger(&emptyLogger), WithSession(session))
+	require.NoError(t, err)
+	go func() {
+		_ = server.Start(conn.Listen)
+	}()
+	<-server.started()
+
+	// wires records the connections of the client's session, so that they can be broken
+	var wiresMu sync.Mutex
+	var wires []net.Conn
+	record := func(c net.Conn) net.Conn {
+		wiresMu.Lock()
+		wires = append(wires, c)
+		wiresMu.Unlock()
+		return c
+	}
+
+	received := make(chan string, 1)
+	client, err := NewClient(HandlerTable{
+		op: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			received <- string(*incoming.Content)
+			return nil, NONE
+		},
+	}, context.Background(), WithLogger(&emptyLogger), WithSession(session), WithConnWrapper(record, true))
+	require.NoError(t, err)
+	require.NoError(t, client.Connect(server.listener.Addr().String()))
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Shutdown()
+	})
+
+	echo := func(content string) {
+		p := packet.Get()
+		p.Metadata.Operation = op
+		p.Content.Write([]byte(content))
+		p.Metadata.ContentLength = uint32(len(content))
+		require.NoError(t, client.WritePacket(p))
+		packet.Put(p)
+		select {
+		case echoed := <-received:
+			assert.Equal(t, content, echoed)
+		case <-time.After(session.Timeout):
+			t.Fatal("timed out waiting for echo")
+		}
+	}
+
+	echo("before")
+
+	// Breaking the connection resumes the session on a new connection without closing the frisbee connection
+	wiresMu.Lock()
+	require.Len(t, wires, 1)
+	_ = wires[0].Close()
+	wiresMu.Unlock()
+
+	echo("after")
+	wiresMu.Lock()
+	assert.Len(t, wires, 2)
+	wiresMu.Unlock()
+	assert.False(t, client.Closed())
+
+	server.connectionsMu.Lock()
+	assert.Len(t, server.connections, 1)
+	server.connectionsMu.Unlock()
+	server.sessions.mu.Lock()
+	assert.Len(t, server.sessions.sessions, 1)
+	server.sessions.mu.Unlock()
+}
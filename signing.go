@@ -0,0 +1,152 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"hash"
+	"io"
+)
+
+const (
+	// signatureSize is the size of the signature that follows every packet when FeatureSigning is enabled
+	signatureSize = sha256.Size
+
+	// signingNonceSize is the size of the nonce that each side sends during the handshake to derive the signing key
+	signingNonceSize = 32
+
+	// helloParamSigningNonce is the HELLO parameter that carries the signing nonce
+	helloParamSigningNonce = uint8(1)
+)
+
+// signingLabel is the label used when deriving the signing keys of a connection
+var signingLabel = []byte("frisbee signing")
+
+// These are the labels of the two directions of a connection, which are used when deriving the keys of a connection
+// so that the packets written by one side cannot be reflected back to it by an attacker
+var (
+	clientToServerLabel = []byte("client to server")
+	serverToClientLabel = []byte("server to client")
+)
+
+// These identify the two directions of a connection in the data that the packets of the connection are bound to
+const (
+	directionClientToServer = byte(iota + 1)
+	directionServerToClient
+)
+
+// connectionKeys holds the keys that a connection reads and writes packets with
+type connectionKeys struct {
+	read  []byte
+	write []byte
+
+	// initiator is true for the keys of the client
+	initiator bool
+}
+
+// directionalKeys returns the connectionKeys of the client (if initiator is true) or the server,
+// given the keys of the two directions of the connection
+func directionalKeys(clientToServer []byte, serverToClient []byte, initiator bool) connectionKeys {
+	if initiator {
+		return connectionKeys{read: serverToClient, write: clientToServer, initiator: true}
+	}
+	return connectionKeys{read: clientToServer, write: serverToClient}
+}
+
+// directions returns the directions that the connection reads and writes packets in
+func (k connectionKeys) directions() (byte, byte) {
+	if k.initiator {
+		return directionServerToClient, directionClientToServer
+	}
+	return directionClientToServer, directionServerToClient
+}
+
+// newSigningNonce returns a new random nonce for deriving the signing key of a connection
+func newSigningNonce() ([]byte, error) {
+	nonce := make([]byte, signingNonceSize)
+	_, err := io.ReadFull(rand.Reader, nonce)
+	return nonce, err
+}
+
+// signingParams returns the signing nonce and the HELLO parameters that should be sent during the handshake
+// (both are nil if signing was not configured)
+func (o *Options) signingParams() ([]byte, map[uint8][]byte, error) {
+	if o.SigningKey == nil {
+		return nil, nil, nil
+	}
+	nonce, err := newSigningNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonce, map[uint8][]byte{helloParamSigningNonce: nonce}, nil
+}
+
+// deriveSigningKey derives the signing key of one direction of a connection from the shared key and the nonces sent
+// by the client and server during the handshake, so that every connection (and each of its directions) is signed with
+// a different key
+func deriveSigningKey(key []byte, direction []byte, clientNonce []byte, serverNonce []byte) ([]byte, error) {
+	if len(clientNonce) != signingNonceSize || len(serverNonce) != signingNonceSize {
+		return nil, InvalidHandshake
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signingLabel)
+	mac.Write(direction)
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil), nil
+}
+
+// connectionSigningKeys returns the signing keys that the client (if initiator is true) or the server should use,
+// given the nonces that were exchanged during the handshake. If signing was not configured, no keys are returned.
+func (o *Options) connectionSigningKeys(clientNonce []byte, serverNonce []byte, initiator bool) (connectionKeys, error) {
+	if o.SigningKey == nil {
+		return connectionKeys{}, nil
+	}
+	clientToServer, err := deriveSigningKey(o.SigningKey, clientToServerLabel, clientNonce, serverNonce)
+	if err != nil {
+		return connectionKeys{}, err
+	}
+	serverToClient, err := deriveSigningKey(o.SigningKey, serverToClientLabel, clientNonce, serverNonce)
+	if err != nil {
+		return connectionKeys{}, err
+	}
+	return directionalKeys(clientToServer, serverToClient, initiator), nil
+}
+
+// withSigningKeys returns a copy of the options that signs connections with the given (already derived) keys
+func (o *Options) withSigningKeys(keys connectionKeys) *Options {
+	if keys.write == nil {
+		return o
+	}
+	options := *o
+	options.signingKeys = keys
+	return &options
+}
+
+// writeSignature returns a new HMAC for signing a packet with the current write key
+func (c *Async) writeSignature() hash.Hash {
+	key, _ := c.signing.WriteKey()
+	return hmac.New(sha256.New, key)
+}
+
+// readSignature returns a new HMAC for verifying a packet with the current read key
+func (c *Async) readSignature() hash.Hash {
+	key, _ := c.signing.ReadKey()
+	return hmac.New(sha256.New, key)
+}
@@ -0,0 +1,235 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncSigning(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 1 << 14
+
+	emptyLogger := zerolog.New(io.Discard)
+	signed := func(key string) *Options {
+		options := loadOptions(WithLogger(&emptyLogger))
+		options.signingKeys = connectionKeys{read: []byte(key), write: []byte(key)}
+		return options
+	}
+
+	for name, features := range map[string]Features{"plain": FeatureRekey, "extended": FeatureRekey | FeatureExtendedHeaders} {
+		features := features
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			readerConn := newAsync(reader, signed("connection key"), features)
+			writerConn := newAsync(writer, signed("connection key"), features)
+
+			large := make([]byte, testSize)
+			for i := range large {
+				large[i] = byte(i)
+			}
+			write := func(content []byte) {
+				p := packet.Get()
+				p.Metadata.Operation = metadata.PacketPing
+				p.Content.Write(content)
+				p.Metadata.ContentLength = uint32(len(content))
+				require.NoError(t, writerConn.WritePacket(p))
+				packet.Put(p)
+			}
+			read := func(content []byte) {
+				p, err := readerConn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, string(content), string(*p.Content))
+				packet.Put(p)
+			}
+
+			write([]byte("small"))
+			read([]byte("small"))
+			write(large)
+			read(large)
+
+			// Packets are still verified after the signing keys are rotated
+			require.NoError(t, writerConn.Rekey())
+			write([]byte("rekeyed"))
+			read([]byte("rekeyed"))
+
+			// Forwarded packets are signed as they are written
+			w, err := writerConn.Forward(metadata.Metadata{Operation: metadata.PacketPing, ContentLength: testSize})
+			require.NoError(t, err)
+			_, err = w.Write(large[:testSize/2])
+			require.NoError(t, err)
+			_, err = w.Write(large[testSize/2:])
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			read(large)
+
+			assert.NoError(t, writerConn.Close())
+			assert.NoError(t, readerConn.Close())
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+		readerConn := newAsync(reader, signed("connection key"), NoFeatures)
+		writerConn := newAsync(writer, signed("wrong key"), NoFeatures)
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		_, err = readerConn.ReadPacket()
+		assert.Error(t, err)
+		assert.ErrorIs(t, readerConn.Error(), InvalidSignature)
+
+		_ = writerConn.Close()
+		_ = readerConn.Close()
+	})
+}
+
+func TestSigningReflection(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	clientNonce, err := newSigningNonce()
+	require.NoError(t, err)
+	serverNonce, err := newSigningNonce()
+	require.NoError(t, err)
+
+	keys := func(initiator bool) *Options {
+		options := loadOptions(WithLogger(&emptyLogger), WithSigningKey([]byte("shared signing key")))
+		signingKeys, err := options.connectionSigningKeys(clientNonce, serverNonce, initiator)
+		require.NoError(t, err)
+		assert.NotEqual(t, signingKeys.read, signingKeys.write)
+		return options.withSigningKeys(signingKeys)
+	}
+	client, server := keys(true), keys(false)
+
+	for name, test := range map[string]struct {
+		reader *Options
+		valid  bool
+	}{
+		"forwarded": {reader: server, valid: true},
+		"reflected": {reader: client, valid: false},
+	} {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, writer, err := pair.New()
+			require.NoError(t, err)
+			readerConn := newAsync(reader, test.reader, NoFeatures)
+			writerConn := newAsync(writer, client, NoFeatures)
+
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			require.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+
+			p, err = readerConn.ReadPacket()
+			if test.valid {
+				require.NoError(t, err)
+				packet.Put(p)
+			} else {
+				// A packet written by the client is rejected when it is sent back to the client
+				assert.Error(t, err)
+				assert.ErrorIs(t, readerConn.Error(), InvalidSignature)
+			}
+
+			_ = writerConn.Close()
+			_ = readerConn.Close()
+		})
+	}
+}
+
+func TestServerSigning(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	key := []byte("shared signing key")
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithSigningKey(key))
+	require.NoError(t, err)
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+	addr := s.listener.Addr().String()
+
+	received := make(chan struct{}, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- struct{}{}
+		return
+	}
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithSigningKey(key))
+	require.NoError(t, err)
+	require.NoError(t, c.Connect(addr))
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write([]byte("signed"))
+	p.Metadata.ContentLength = 6
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+	<-received
+	assert.NoError(t, c.Close())
+
+	// Clients that do not sign their packets are closed by the server
+	unsigned, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, unsigned.Connect(addr))
+	assert.Eventually(t, unsigned.Closed, time.Second*5, time.Millisecond*10)
+
+	// Servers that do not verify signatures are rejected by the client
+	plain, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithFeatures(FeatureRekey))
+	require.NoError(t, err)
+	go func() {
+		_ = plain.Start(conn.Listen)
+	}()
+	<-plain.started()
+	rejected, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithSigningKey(key))
+	require.NoError(t, err)
+	assert.ErrorIs(t, rejected.Connect(plain.listener.Addr().String()), FeatureNotNegotiated)
+
+	assert.NoError(t, s.Shutdown())
+	assert.NoError(t, plain.Shutdown())
+}
@@ -0,0 +1,106 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"expvar"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Snapshot is a snapshot of the aggregate stats of a frisbee server and its connections, which gives basic
+// observability without a metrics system (see Server.PublishExpvar)
+type Snapshot struct {
+	// Connections is the number of connections that the server is handling
+	Connections int `json:"connections"`
+
+	// Accepted is the number of connections that the server has accepted since it was created
+	Accepted uint64 `json:"accepted"`
+
+	// Draining is whether the server is draining or shutting down (see Server.Drain)
+	Draining bool `json:"draining"`
+
+	// Streams is the number of open streams on the connections of the server
+	Streams int `json:"streams"`
+
+	// Incoming is the number of packets in the incoming queues of the connections of the server
+	Incoming int `json:"incoming"`
+
+	// MissedPackets is the number of packets that were missing from the sequence numbers received by the connections
+	MissedPackets uint64 `json:"missed_packets"`
+
+	// Features is the number of connections that negotiated each feature, by the name of the feature
+	Features map[string]int `json:"features"`
+
+	// Pool is how the packet pool has been used by the process
+	Pool packet.PoolStats `json:"pool"`
+}
+
+// Snapshot returns the aggregate stats of the server and its connections
+func (s *Server) Snapshot() Snapshot {
+	s.connectionsMu.Lock()
+	connections := make([]*Async, 0, len(s.connections))
+	for _, conn := range s.connections {
+		connections = append(connections, conn)
+	}
+	s.connectionsMu.Unlock()
+
+	snapshot := Snapshot{
+		Connections: len(connections),
+		Accepted:    s.accepted.Load(),
+		Draining:    s.Draining(),
+		Features:    make(map[string]int),
+		Pool:        packet.Stats(),
+	}
+	for _, conn := range connections {
+		stats := conn.Stats()
+		snapshot.Streams += stats.Streams
+		snapshot.Incoming += conn.incoming.Length()
+		snapshot.MissedPackets += stats.MissedPackets
+		for _, name := range stats.Features.Names() {
+			snapshot.Features[name]++
+		}
+	}
+	return snapshot
+}
+
+// PublishExpvar publishes the Snapshot of the server as an expvar.Var with the given name, which is served as JSON
+// on /debug/vars by the expvar package. It returns VarPublished if a variable with the name has already been published.
+func (s *Server) PublishExpvar(name string) error {
+	return publishExpvar(name, func() interface{} {
+		return s.Snapshot()
+	})
+}
+
+// PublishExpvar publishes the Stats of the client's connection as an expvar.Var with the given name (see Server.PublishExpvar)
+func (c *Client) PublishExpvar(name string) error {
+	return publishExpvar(name, func() interface{} {
+		if c.conn == nil {
+			return nil
+		}
+		return c.conn.Stats()
+	})
+}
+
+// publishExpvar publishes f as an expvar.Var with the given name, unless the name is already taken
+func publishExpvar(name string, f func() interface{}) error {
+	if expvar.Get(name) != nil {
+		return VarPublished
+	}
+	expvar.Publish(name, expvar.Func(f))
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	server, err := NewServer(HandlerTable{}, WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger), WithFeatures(FeatureStreamClose))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Shutdown()
+	})
+
+	require.Eventually(t, func() bool {
+		return server.Snapshot().Connections == 1
+	}, DefaultDeadline, time.Millisecond)
+	snapshot := server.Snapshot()
+	assert.Equal(t, uint64(1), snapshot.Accepted)
+	assert.Equal(t, map[string]int{"stream-close": 1}, snapshot.Features)
+	assert.False(t, snapshot.Draining)
+
+	require.NoError(t, server.PublishExpvar("frisbee-snapshot-test"))
+	assert.ErrorIs(t, server.PublishExpvar("frisbee-snapshot-test"), VarPublished)
+	var published Snapshot
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("frisbee-snapshot-test").String()), &published))
+	assert.Equal(t, 1, published.Connections)
+
+	require.NoError(t, client.PublishExpvar("frisbee-client-test"))
+	var stats map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("frisbee-client-test").String()), &stats))
+	assert.Equal(t, float64(client.conn.ID()), stats["ID"])
+}
@@ -0,0 +1,86 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	UnknownServerName = errors.New("no handlers for the requested server name")
+)
+
+// SNIPolicy is called by the server for every incoming connection with the server name that the client requested
+// using SNI (which is empty for connections that do not use TLS, or clients that do not send SNI), and returns the
+// HandlerTable that handles the packets of the connection. This allows a single server to serve many tenants on
+// the same port. If it returns a nil HandlerTable, the HandlerTable of the server is used, and if it returns an
+// error, the connection is rejected.
+//
+// The Router of the server (see WithRouter) is still used for operations that are not in the returned HandlerTable.
+type SNIPolicy func(serverName string) (HandlerTable, error)
+
+// SNITables returns an SNIPolicy that routes connections to the HandlerTable of their server name, and uses the
+// fallback for all other connections (or rejects them with UnknownServerName if the fallback is nil)
+func SNITables(tables map[string]HandlerTable, fallback HandlerTable) SNIPolicy {
+	return func(serverName string) (HandlerTable, error) {
+		if table, ok := tables[serverName]; ok {
+			return table, nil
+		}
+		if fallback == nil {
+			return nil, UnknownServerName
+		}
+		return fallback, nil
+	}
+}
+
+// ServerName returns the server name that the peer requested using SNI, or an empty string if the
+// connection does not use TLS or the TLS handshake has not completed
+func (c *Async) ServerName() string {
+	state, err := c.ConnectionState()
+	if err != nil {
+		return ""
+	}
+	return state.ServerName
+}
+
+// routeSNI completes the TLS handshake of an incoming connection (if it uses TLS) and returns the HandlerTable
+// that the SNIPolicy of the server chose for the requested server name
+func (s *Server) routeSNI(conn net.Conn) (HandlerTable, error) {
+	var serverName string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultDeadline)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		serverName = tlsConn.ConnectionState().ServerName
+	}
+	return s.sniPolicy(serverName)
+}
+
+// handlerTableOf returns the HandlerTable that handles the packets of conn
+func (s *Server) handlerTableOf(conn *Async) HandlerTable {
+	if conn.handlerTable != nil {
+		return conn.handlerTable
+	}
+	return s.GetHandlerTable()
+}
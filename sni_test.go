@@ -0,0 +1,117 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNITables(t *testing.T) {
+	t.Parallel()
+
+	// The tables use different operations so that they can be told apart
+	tenant := HandlerTable{11: namedHandler("tenant")}
+	fallback := HandlerTable{12: namedHandler("fallback")}
+
+	table, err := SNITables(map[string]HandlerTable{"tenant.example": tenant}, nil)("tenant.example")
+	require.NoError(t, err)
+	assert.Contains(t, table, uint16(11))
+
+	_, err = SNITables(map[string]HandlerTable{"tenant.example": tenant}, nil)("other.example")
+	assert.ErrorIs(t, err, UnknownServerName)
+
+	table, err = SNITables(map[string]HandlerTable{"tenant.example": tenant}, fallback)("other.example")
+	require.NoError(t, err)
+	assert.Contains(t, table, uint16(12))
+}
+
+func TestServerSNIPolicy(t *testing.T) {
+	t.Parallel()
+
+	const testOperation = uint16(11)
+
+	serverTLS, _ := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+
+	reply := func(name string) HandlerTable {
+		return HandlerTable{testOperation: namedHandler(name)}
+	}
+
+	s, err := NewServer(reply("default"), WithLogger(&emptyLogger), WithTLS(serverTLS))
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetSNIPolicy(nil), SNIPolicyNil)
+	require.NoError(t, s.SetSNIPolicy(func(serverName string) (HandlerTable, error) {
+		switch serverName {
+		case "a.example":
+			return reply("a"), nil
+		case "default.example":
+			return nil, nil
+		}
+		return nil, UnknownServerName
+	}))
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	// connect writes a packet using the given server name and returns the name of the HandlerTable that handled it
+	connect := func(serverName string) string {
+		received := make(chan string, 1)
+		clientHandlerTable := HandlerTable{testOperation: func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			received <- string(*incoming.Content)
+			return
+		}}
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger),
+			WithTLS(&tls.Config{ServerName: serverName, InsecureSkipVerify: true}))
+		require.NoError(t, err)
+		defer func() {
+			_ = c.Close()
+		}()
+		err = c.Connect(s.listener.Addr().String())
+		require.NoError(t, err)
+
+		p := packet.Get()
+		p.Metadata.Operation = testOperation
+		_ = c.WritePacket(p)
+		packet.Put(p)
+		select {
+		case name := <-received:
+			assert.Equal(t, serverName, c.conn.ServerName())
+			return name
+		case <-time.After(time.Millisecond * 500):
+			return ""
+		}
+	}
+
+	assert.Equal(t, "a", connect("a.example"))
+	assert.Equal(t, "default", connect("default.example"))
+	assert.Equal(t, "", connect("unknown.example"))
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
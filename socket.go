@@ -0,0 +1,120 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+	"time"
+)
+
+// SocketOptions are the options that are set on the TCP socket underlying a frisbee connection (see the
+// WithSocketOptions option and Async.SetSocketOptions). Zero values leave the corresponding option unchanged.
+type SocketOptions struct {
+	// Nagle enables Nagle's algorithm by clearing TCP_NODELAY, which Go sets on every TCP socket by default
+	Nagle bool
+
+	// ReadBuffer sets the size of the socket's receive buffer (SO_RCVBUF) in bytes
+	ReadBuffer int
+
+	// WriteBuffer sets the size of the socket's send buffer (SO_SNDBUF) in bytes
+	WriteBuffer int
+
+	// UserTimeout sets how long transmitted data may remain unacknowledged before the connection is closed
+	// by the kernel (TCP_USER_TIMEOUT). It is only supported on Linux and is ignored on other platforms.
+	UserTimeout time.Duration
+
+	// TOS sets the type of service byte of outgoing packets (IP_TOS for IPv4 and IPV6_TCLASS for IPv6), where
+	// the DSCP is stored in the upper 6 bits (so a DSCP of 46 is a TOS of 184). It is only supported on Linux
+	// and is ignored on other platforms.
+	TOS int
+
+	// Control is called with the file descriptor of the socket after the other options have been set,
+	// and can be used to set socket options that are not covered by SocketOptions
+	Control func(fd uintptr) error
+}
+
+// SetSocketOptions sets the given SocketOptions on the TCP socket underlying the connection, which is useful for
+// connections created using ConnectAsync or NewAsync (for server connections, use the Server's ConnContext). Connections
+// that are not backed by a TCP socket (or that are wrapped using a ConnWrapper) are left unchanged.
+func (c *Async) SetSocketOptions(options SocketOptions) error {
+	return options.apply(c.conn)
+}
+
+// apply sets the socket options on the TCP socket underlying conn, and does nothing if conn is not backed by a TCP socket
+func (o *SocketOptions) apply(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if o.Nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.UserTimeout <= 0 && o.TOS <= 0 && o.Control == nil {
+		return nil
+	}
+	rc, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if o.UserTimeout > 0 {
+		if err = setUserTimeout(rc, durationMilliseconds(o.UserTimeout)); err != nil {
+			return err
+		}
+	}
+	if o.TOS > 0 {
+		ipv6 := false
+		if addr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok {
+			ipv6 = addr.IP.To4() == nil
+		}
+		if err = setTOS(rc, o.TOS, ipv6); err != nil {
+			return err
+		}
+	}
+	if o.Control != nil {
+		return control(rc, o.Control)
+	}
+	return nil
+}
+
+// control calls f with the file descriptor of the socket
+func control(rc syscall.RawConn, f func(fd uintptr) error) error {
+	var err error
+	controlErr := rc.Control(func(fd uintptr) {
+		err = f(fd)
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setUserTimeout sets the TCP_USER_TIMEOUT socket option (in milliseconds) on the given socket
+func setUserTimeout(rc syscall.RawConn, msec int) error {
+	return control(rc, func(fd uintptr) error {
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, msec)
+	})
+}
+
+// setTOS sets the IP_TOS (or IPV6_TCLASS if ipv6 is true) socket option on the given socket
+func setTOS(rc syscall.RawConn, tos int, ipv6 bool) error {
+	return control(rc, func(fd uintptr) error {
+		if ipv6 {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+		}
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	})
+}
+
+// setKeepAliveProbes sets the TCP_KEEPINTVL (in seconds) and TCP_KEEPCNT socket options on the given socket,
+// leaving the options that are 0 unchanged
+func setKeepAliveProbes(rc syscall.RawConn, interval int, count int) error {
+	return control(rc, func(fd uintptr) error {
+		if interval > 0 {
+			err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, interval)
+			if err != nil {
+				return err
+			}
+		}
+		if count > 0 {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count)
+		}
+		return nil
+	})
+}
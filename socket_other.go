@@ -0,0 +1,38 @@
+//go:build !linux
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"syscall"
+)
+
+// setUserTimeout does nothing, since the TCP_USER_TIMEOUT socket option is only supported on Linux
+func setUserTimeout(_ syscall.RawConn, _ int) error {
+	return nil
+}
+
+// setTOS does nothing, since setting the type of service is only supported on Linux
+func setTOS(_ syscall.RawConn, _ int, _ bool) error {
+	return nil
+}
+
+// setKeepAliveProbes does nothing, since the TCP_KEEPINTVL and TCP_KEEPCNT socket options are only set on Linux
+func setKeepAliveProbes(_ syscall.RawConn, _ int, _ int) error {
+	return nil
+}
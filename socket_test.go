@@ -0,0 +1,120 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestAsyncSetSocketOptions(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	var fds []uintptr
+	err = readerConn.SetSocketOptions(SocketOptions{
+		Nagle:       true,
+		ReadBuffer:  1 << 16,
+		WriteBuffer: 1 << 16,
+		UserTimeout: time.Second * 10,
+		TOS:         184,
+		Control: func(fd uintptr) error {
+			fds = append(fds, fd)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Len(t, fds, 1)
+
+	controlErr := errors.New("control error")
+	err = writerConn.SetSocketOptions(SocketOptions{
+		Control: func(uintptr) error {
+			return controlErr
+		},
+	})
+	assert.ErrorIs(t, err, controlErr)
+
+	// Connections that are not backed by a TCP socket are left unchanged
+	pipeReader, pipeWriter := net.Pipe()
+	pipeConn := NewAsync(pipeReader, &emptyLogger)
+	err = pipeConn.SetSocketOptions(SocketOptions{
+		Control: func(uintptr) error {
+			return controlErr
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+	assert.NoError(t, pipeConn.Close())
+	assert.NoError(t, pipeWriter.Close())
+}
+
+func TestSocketOptionsOption(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	serverControlled := atomic.NewBool(false)
+	server, err := NewServer(nil, WithLogger(&emptyLogger), WithSocketOptions(SocketOptions{
+		Nagle: true,
+		Control: func(uintptr) error {
+			serverControlled.Store(true)
+			return nil
+		},
+	}))
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.StartWithListener(listener)
+	}()
+	<-server.started()
+
+	clientControlled := atomic.NewBool(false)
+	client, err := NewClient(make(HandlerTable), context.Background(), WithLogger(&emptyLogger), WithSocketOptions(SocketOptions{
+		WriteBuffer: 1 << 16,
+		Control: func(uintptr) error {
+			clientControlled.Store(true)
+			return nil
+		},
+	}))
+	require.NoError(t, err)
+	require.NoError(t, client.Connect(listener.Addr().String()))
+	assert.True(t, clientControlled.Load())
+	assert.Eventually(t, serverControlled.Load, time.Second, time.Millisecond*10)
+
+	require.NoError(t, client.Close())
+	require.NoError(t, server.Shutdown())
+}
@@ -0,0 +1,177 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// featureNames are the names of the Features, which are used by Features.String and for metrics labels
+var featureNames = [...]struct {
+	feature Features
+	name    string
+}{
+	{FeatureRekey, "rekey"},
+	{FeatureCompression, "compression"},
+	{FeatureExtendedHeaders, "extended-headers"},
+	{FeatureStreamClose, "stream-close"},
+	{FeatureByteStreams, "byte-streams"},
+	{FeatureSigning, "signing"},
+	{FeatureSequenceNumbers, "sequence-numbers"},
+	{FeatureDeprecation, "deprecation"},
+	{FeatureStreamReset, "stream-reset"},
+	{FeatureAcknowledgements, "acknowledgements"},
+	{FeatureHealth, "health"},
+	{FeatureControl, "control"},
+	{FeatureEncryption, "encryption"},
+	{FeatureStreamCompression, "stream-compression"},
+	{FeatureLargeContent, "large-content"},
+	{FeatureCompactMetadata, "compact-metadata"},
+}
+
+// Names returns the names of the features in the feature set, in the order that they are defined. Unknown
+// features are named after their bit (like "bit31").
+func (fs Features) Names() []string {
+	var names []string
+	known := NoFeatures
+	for _, f := range featureNames {
+		known |= f.feature
+		if fs.Has(f.feature) {
+			names = append(names, f.name)
+		}
+	}
+	for bit := 0; bit < 32; bit++ {
+		if f := Features(1 << bit); f&known == 0 && fs.Has(f) {
+			names = append(names, "bit"+strconv.Itoa(bit))
+		}
+	}
+	return names
+}
+
+// String returns the names of the features in the feature set separated by "|", or "none" for NoFeatures
+func (fs Features) String() string {
+	if fs == NoFeatures {
+		return "none"
+	}
+	return strings.Join(fs.Names(), "|")
+}
+
+// Stats is a snapshot of the state of a frisbee connection, which includes the features that were negotiated for
+// the connection and the optional modes that it is using so that operators can correlate regressions with the rollout
+// of new features at the connection level (see Labels).
+type Stats struct {
+	// ID is the unique ID of the connection (see Async.ID)
+	ID uint64
+
+	// PeerID is the ID of the peer of the connection (see Async.PeerID)
+	PeerID string
+
+	// Features are the Features that were negotiated for the connection during the handshake
+	Features Features
+
+	// Modes are the names of the optional modes that are enabled on the connection (like "idle" or "busy-poll")
+	Modes []string
+
+	// Idle is whether the connection is currently in idle mode
+	Idle bool
+
+	// BusyPoll is how long ReadPacket spins for before parking (0 if busy-polling is disabled)
+	BusyPoll time.Duration
+
+	// MissedPackets is the number of packets that were missing from the sequence numbers received by the connection
+	MissedPackets uint64
+
+	// Sequence is the anomalies in the sequence numbers received by the connection (see WithSequenceDiagnostics)
+	Sequence SequenceDiagnostics
+
+	// Streams is the number of open streams on the connection
+	Streams int
+}
+
+// Labels returns the features and modes of the connection as metrics labels, which have a bounded
+// number of values and can be attached to per-connection metrics
+func (s Stats) Labels() map[string]string {
+	modes := "none"
+	if len(s.Modes) > 0 {
+		modes = strings.Join(s.Modes, "|")
+	}
+	return map[string]string{
+		"features": s.Features.String(),
+		"modes":    modes,
+	}
+}
+
+// Stats returns a snapshot of the state of the connection
+func (c *Async) Stats() Stats {
+	c.streamsMu.Lock()
+	streams := len(c.streams)
+	c.streamsMu.Unlock()
+	return Stats{
+		ID:            c.id,
+		PeerID:        c.peerID,
+		Features:      c.features,
+		Modes:         c.modes(),
+		Idle:          c.Idle(),
+		BusyPoll:      c.BusyPoll(),
+		MissedPackets: c.MissedPackets(),
+		Sequence:      c.SequenceDiagnostics(),
+		Streams:       streams,
+	}
+}
+
+// modes returns the names of the optional modes that are enabled on the connection
+func (c *Async) modes() []string {
+	var modes []string
+	if c.options.Idle.enabled() {
+		modes = append(modes, "idle")
+	}
+	if c.BusyPoll() > 0 {
+		modes = append(modes, "busy-poll")
+	}
+	if c.options.LazyStart {
+		modes = append(modes, "lazy-start")
+	}
+	if c.options.CloseNotify > 0 {
+		modes = append(modes, "close-notify")
+	}
+	if c.sequence != nil && c.options.SequenceDiagnostics {
+		modes = append(modes, "sequence-diagnostics")
+	}
+	if c.inlineThreshold() > 0 {
+		modes = append(modes, "inline")
+	}
+	if c.recorder != nil {
+		modes = append(modes, "recorder")
+	}
+	if c.outbound != nil {
+		modes = append(modes, "priorities")
+	}
+	if c.options.Scheduler != nil {
+		modes = append(modes, "scheduled")
+	}
+	if c.options.WriteCoalescer != nil {
+		modes = append(modes, "coalesced")
+	}
+	return modes
+}
+
+// Stats returns a snapshot of the state of the client's connection
+func (c *Client) Stats() Stats {
+	return c.conn.Stats()
+}
@@ -0,0 +1,72 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeaturesString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "none", NoFeatures.String())
+	assert.Equal(t, "compression", FeatureCompression.String())
+	assert.Equal(t, "rekey|extended-headers|signing", (FeatureSigning | FeatureRekey | FeatureExtendedHeaders).String())
+	assert.Equal(t, []string{"stream-close", "bit31"}, (FeatureStreamClose | Features(1<<31)).Names())
+}
+
+func TestAsyncStats(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger), WithIdle(Idle{After: time.Minute}), WithLazyStart())
+
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureCompression|FeatureExtendedHeaders)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	readerConn.NewStream(1)
+	stats := readerConn.Stats()
+	assert.Equal(t, readerConn.ID(), stats.ID)
+	assert.Equal(t, FeatureCompression|FeatureExtendedHeaders, stats.Features)
+	assert.Equal(t, []string{"idle", "lazy-start", "inline"}, stats.Modes)
+	assert.False(t, stats.Idle)
+	assert.Equal(t, 1, stats.Streams)
+	assert.Equal(t, map[string]string{
+		"features": "compression|extended-headers",
+		"modes":    "idle|lazy-start|inline",
+	}, stats.Labels())
+
+	stats = writerConn.Stats()
+	assert.Equal(t, NoFeatures, stats.Features)
+	assert.Empty(t, stats.Modes)
+	assert.Equal(t, map[string]string{
+		"features": "none",
+		"modes":    "none",
+	}, stats.Labels())
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
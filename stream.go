@@ -17,38 +17,157 @@
 package frisbee
 
 import (
+	"bytes"
+	"compress/flate"
+	"context"
 	"github.com/loopholelabs/common/pkg/queue"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
 	"go.uber.org/atomic"
+	"io"
+	"net"
+	"os"
 	"sync"
+	"time"
 )
 
 // DefaultStreamBufferSize is the default size of the stream buffer.
 const DefaultStreamBufferSize = 1 << 12
 
+// maxStreamWriteSize is the largest amount of content that Stream.Write sends in a single packet
+const maxStreamWriteSize = DefaultBufferSize
+
+// StreamMode decides how the data sent on a stream is delivered to the receiver.
+type StreamMode uint8
+
+const (
+	// MessageMode streams deliver every packet written to the stream as a separate packet (using ReadPacket and WritePacket)
+	MessageMode = StreamMode(iota)
+
+	// ByteMode streams deliver an ordered stream of bytes where the boundaries between writes are
+	// not preserved (using Read and Write), which is useful for tunneling protocols like TLS or HTTP
+	ByteMode
+)
+
 type NewStreamHandler func(*Stream)
 
+// streamConn is the connection that a stream belongs to, which is either an Async or a Sync connection
+type streamConn interface {
+	Features() Features
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	writePacket(p *packet.Packet) error
+	removeStream(id uint16)
+}
+
 type Stream struct {
 	id      uint16
 	conn    *Async
+	owner   streamConn
+	sync    *Sync
+	mode    StreamMode
 	closed  *atomic.Bool
 	queue   *queue.Circular[packet.Packet, *packet.Packet]
 	staleMu sync.Mutex
 	stale   []*packet.Packet
+
+	readMu  sync.Mutex
+	current *packet.Packet
+	offset  int
+
+	// local is true if the stream was opened by this side of the connection
+	local bool
+
+	// capacity is the size of the queue, which queueLimits can lower, and dropped counts the packets
+	// discarded because the queue was full (see StreamQueue)
+	capacity    int
+	queueLimits *atomic.Pointer[StreamQueue]
+	dropped     *atomic.Uint64
+
+	// code is the error code that the stream was reset with (see CloseWithError), and remoteReset is true if it was reset by the peer
+	code        *atomic.Uint32
+	remoteReset *atomic.Bool
+
+	// throttle limits the rate of the content read from and written to the stream (see StreamQuota)
+	throttle *atomic.Pointer[Throttle]
+
+	// readable is signalled whenever a packet is pushed to the queue, space whenever a packet is popped from it,
+	// and done is closed once the stream is closed
+	readable chan struct{}
+	space    chan struct{}
+	done     chan struct{}
+
+	// deadlineMu guards the deadlines of the stream, and deadlineChanged is closed (and replaced) whenever they change
+	deadlineMu      sync.Mutex
+	readDeadline    time.Time
+	writeDeadline   time.Time
+	deadlineChanged chan struct{}
+
+	// compressor is the persistent compression context of the packets written to the stream, and compressed holds the
+	// compressed content of the packet being written (both are guarded by compressMu). decompressor is the persistent
+	// decompression context of the packets read from the stream (see FeatureStreamCompression).
+	compressMu   sync.Mutex
+	compressor   *flate.Writer
+	compressed   bytes.Buffer
+	decompressor *streamDecompressor
+}
+
+func newStream(id uint16, conn *Async, mode StreamMode) *Stream {
+	stream := newStreamWithQueue(id, mode, conn.options.StreamQueue)
+	stream.conn = conn
+	stream.owner = conn
+	return stream
 }
 
-func newStream(id uint16, conn *Async) *Stream {
+// newStreamWithQueue returns a new stream without a connection whose queue is configured by config
+func newStreamWithQueue(id uint16, mode StreamMode, config StreamQueue) *Stream {
+	config = config.withDefaults()
 	return &Stream{
-		id:     id,
-		conn:   conn,
-		closed: atomic.NewBool(false),
-		queue:  queue.NewCircular[packet.Packet, *packet.Packet](DefaultStreamBufferSize),
+		id:          id,
+		mode:        mode,
+		closed:      atomic.NewBool(false),
+		queue:       queue.NewCircular[packet.Packet, *packet.Packet](uint64(config.Size)),
+		capacity:    config.Size,
+		queueLimits: atomic.NewPointer(&config),
+		dropped:     atomic.NewUint64(0),
+		code:        atomic.NewUint32(StreamCodeNone),
+		remoteReset: atomic.NewBool(false),
+		throttle:    atomic.NewPointer[Throttle](nil),
+		readable:    make(chan struct{}, 1),
+		space:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+
+		deadlineChanged: make(chan struct{}),
 	}
 }
 
 // ReadPacket is a blocking function that will wait until a Frisbee packet is available and then return it (and its content).
 // In the event that the connection is closed, ReadPacket will return an error.
+//
+// ReadPacket can only be used on MessageMode streams.
 func (s *Stream) ReadPacket() (*packet.Packet, error) {
+	if s.mode != MessageMode {
+		return nil, InvalidStreamMode
+	}
+	return s.readPacket()
+}
+
+func (s *Stream) readPacket() (*packet.Packet, error) {
+	if s.sync != nil {
+		// Sync connections have no read loop, so the reader of the stream reads from the connection itself
+		ctx := context.Background()
+		if deadline := s.deadline(&s.readDeadline); !deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		err := s.sync.pull(ctx, func() bool {
+			return !s.queue.IsEmpty() || s.closed.Load()
+		})
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
 	if s.closed.Load() {
 		s.staleMu.Lock()
 		if len(s.stale) > 0 {
@@ -58,9 +177,13 @@ func (s *Stream) ReadPacket() (*packet.Packet, error) {
 			return p, nil
 		}
 		s.staleMu.Unlock()
-		return nil, StreamClosed
+		return nil, s.closedError()
 	}
 
+	err := s.waitReadable()
+	if err != nil {
+		return nil, err
+	}
 	readPacket, err := s.queue.Pop()
 	if err != nil {
 		if s.closed.Load() {
@@ -72,27 +195,119 @@ func (s *Stream) ReadPacket() (*packet.Packet, error) {
 				return p, nil
 			}
 			s.staleMu.Unlock()
-			return nil, StreamClosed
+			return nil, s.closedError()
 		}
 		return nil, err
 	}
 
+	if !s.queue.IsEmpty() {
+		s.signal()
+	}
+	s.signalSpace()
+	if throttle := s.throttle.Load(); throttle != nil {
+		throttle.waitN(int(readPacket.Metadata.ContentLength))
+	}
 	return readPacket, nil
 }
 
 // WritePacket will write the given packet to the stream but the ID and Operation will be
 // overwritten with the stream's ID and the STREAM operation. Packets send to a stream
-// must have a ContentLength greater than 0.
+// must have a ContentLength greater than 0, unless the FeatureStreamClose feature was negotiated.
+//
+// WritePacket can only be used on MessageMode streams.
 func (s *Stream) WritePacket(p *packet.Packet) error {
+	if s.mode != MessageMode {
+		return InvalidStreamMode
+	}
+	return s.writePacket(p)
+}
+
+func (s *Stream) writePacket(p *packet.Packet) error {
 	if s.closed.Load() {
-		return StreamClosed
+		return s.closedError()
 	}
-	if p.Metadata.ContentLength == 0 {
+	if p.Metadata.ContentLength == 0 && !s.owner.Features().Has(FeatureStreamClose) {
 		return InvalidStreamPacket
 	}
+	if err := s.waitWritable(int(p.Metadata.ContentLength)); err != nil {
+		return err
+	}
 	p.Metadata.Id = s.id
 	p.Metadata.Operation = STREAM
-	return s.conn.writePacket(p)
+	if p.Metadata.ContentLength > 0 && s.conn != nil && s.conn.features.Has(FeatureStreamCompression) {
+		return s.writeCompressed(p)
+	}
+	return s.owner.writePacket(p)
+}
+
+// Read reads the next bytes from the stream into b, blocking until at least one byte is available. Once the stream
+// has been closed and all of its data has been read, Read returns io.EOF.
+//
+// Read can only be used on ByteMode streams.
+func (s *Stream) Read(b []byte) (int, error) {
+	if s.mode != ByteMode {
+		return 0, InvalidStreamMode
+	}
+	return s.read(b)
+}
+
+// read reads the content of the packets of the stream into b as a stream of bytes, regardless of the stream's mode
+func (s *Stream) read(b []byte) (int, error) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	for s.current == nil || s.offset == int(s.current.Metadata.ContentLength) {
+		if s.current != nil {
+			packet.Put(s.current)
+			s.current = nil
+		}
+		p, err := s.readPacket()
+		if err != nil {
+			if err == StreamClosed {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		s.current, s.offset = p, 0
+	}
+	n := copy(b, (*s.current.Content)[s.offset:s.current.Metadata.ContentLength])
+	s.offset += n
+	return n, nil
+}
+
+// Write writes b to the stream, splitting it into as many packets as required.
+//
+// Write can only be used on ByteMode streams.
+func (s *Stream) Write(b []byte) (int, error) {
+	if s.mode != ByteMode {
+		return 0, InvalidStreamMode
+	}
+	return s.write(b)
+}
+
+// write writes b to the stream as the content of as many packets as required, regardless of the stream's mode
+func (s *Stream) write(b []byte) (int, error) {
+	p := packet.Get()
+	defer packet.Put(p)
+	var n int
+	for n < len(b) {
+		size := len(b) - n
+		if size > maxStreamWriteSize {
+			size = maxStreamWriteSize
+		}
+		p.Content.Reset()
+		p.Content.Write(b[n : n+size])
+		p.Metadata.ContentLength = uint32(size)
+		if err := s.writePacket(p); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// Mode returns the stream's StreamMode.
+func (s *Stream) Mode() StreamMode {
+	return s.mode
 }
 
 // ID returns the stream's ID.
@@ -100,41 +315,48 @@ func (s *Stream) ID() uint16 {
 	return s.id
 }
 
-// Conn returns the connection that the stream is associated with.
+// Conn returns the connection that the stream is associated with, or nil if the stream belongs to a Sync connection.
 func (s *Stream) Conn() *Async {
 	return s.conn
 }
 
 // Close will close the stream and prevent any further reads or writes.
 func (s *Stream) Close() error {
-	s.staleMu.Lock()
-	if s.closed.CompareAndSwap(false, true) {
-		s.queue.Close()
-		s.stale = s.queue.Drain()
-		s.staleMu.Unlock()
-
-		p := packet.Get()
-		p.Metadata.Id = s.id
-		p.Metadata.Operation = STREAM
-		err := s.conn.writePacket(p)
-		packet.Put(p)
+	if !s.closeWith(StreamCodeNone, false) {
+		return StreamClosed
+	}
+	err := s.sendClose(StreamCodeNone)
 
-		s.conn.streamsMu.Lock()
-		delete(s.conn.streams, s.id)
-		s.conn.streamsMu.Unlock()
+	s.owner.removeStream(s.id)
 
-		return err
-	}
-	s.staleMu.Unlock()
-	return StreamClosed
+	return err
 }
 
 // close will close the stream and prevent any further reads or writes without sending a stream close packet.
 func (s *Stream) close() {
+	s.closeWith(StreamCodeNone, false)
+}
+
+// closeWith closes the stream with the given error code (see CloseWithError), which was received from the peer if
+// remote is true, and returns false if the stream was already closed. The packets that have not been read yet
+// remain readable, unless the stream was reset.
+func (s *Stream) closeWith(code uint32, remote bool) bool {
 	s.staleMu.Lock()
-	if s.closed.CompareAndSwap(false, true) {
-		s.queue.Close()
-		s.stale = s.queue.Drain()
+	defer s.staleMu.Unlock()
+	if s.closed.Load() {
+		return false
+	}
+	s.code.Store(code)
+	s.remoteReset.Store(remote)
+	s.closed.Store(true)
+	s.queue.Close()
+	s.stale = s.queue.Drain()
+	if code != StreamCodeNone {
+		for _, p := range s.stale {
+			packet.Put(p)
+		}
+		s.stale = nil
 	}
-	s.staleMu.Unlock()
+	close(s.done)
+	return true
 }
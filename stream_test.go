@@ -225,3 +225,241 @@ func TestNewStreamDualCreate(t *testing.T) {
 	err = readerConn.Close()
 	assert.NoError(t, err)
 }
+
+func TestStreamClose(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureStreamClose)
+	writerConn := newAsync(writer, options, FeatureStreamClose)
+
+	readerStreamCh := make(chan *Stream, 1)
+	readerConn.SetNewStreamHandler(func(stream *Stream) {
+		readerStreamCh <- stream
+	})
+
+	writerStream := writerConn.NewStream(0)
+
+	p := packet.Get()
+	err := writerStream.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	var readerStream *Stream
+	timer := time.NewTimer(DefaultDeadline)
+	select {
+	case <-timer.C:
+		t.Fatal("timed out waiting for reader stream")
+	case readerStream = <-readerStreamCh:
+	}
+
+	p, err = readerStream.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, STREAM, p.Metadata.Operation)
+	assert.Equal(t, uint32(0), p.Metadata.ContentLength)
+	packet.Put(p)
+
+	err = writerStream.Close()
+	require.NoError(t, err)
+
+	_, err = readerStream.ReadPacket()
+	assert.ErrorIs(t, err, StreamClosed)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestStreamWithoutHandler(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, NoFeatures)
+	writerConn := newAsync(writer, options, NoFeatures)
+
+	readerConn.SetNewStreamHandler(func(stream *Stream) {
+		p, err := stream.ReadPacket()
+		if err != nil {
+			return
+		}
+		_ = stream.WritePacket(p)
+		packet.Put(p)
+	})
+
+	writerStream, err := writerConn.OpenStream(0, MessageMode)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Content.Write([]byte("echo"))
+	p.Metadata.ContentLength = 4
+	err = writerStream.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = writerStream.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, "echo", string((*p.Content)[:p.Metadata.ContentLength]))
+	packet.Put(p)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestStreamCloseLegacy(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	writerStream := writerConn.NewStream(0)
+	readerStream := readerConn.NewStream(0)
+
+	p := packet.Get()
+	err := writerStream.WritePacket(p)
+	assert.ErrorIs(t, err, InvalidStreamPacket)
+	packet.Put(p)
+
+	err = writerStream.Close()
+	require.NoError(t, err)
+
+	_, err = readerStream.ReadPacket()
+	assert.ErrorIs(t, err, StreamClosed)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestStreamConnClose(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	writerStream := writerConn.NewStream(0)
+
+	err := writerConn.Close()
+	assert.NoError(t, err)
+
+	_, err = writerStream.ReadPacket()
+	assert.ErrorIs(t, err, StreamClosed)
+	p := packet.Get()
+	assert.ErrorIs(t, writerStream.WritePacket(p), StreamClosed)
+	packet.Put(p)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestStreamByteMode(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureByteStreams)
+	writerConn := newAsync(writer, options, FeatureByteStreams)
+
+	readerStreamCh := make(chan *Stream, 1)
+	readerConn.SetNewStreamHandler(func(stream *Stream) {
+		readerStreamCh <- stream
+	})
+
+	_, err := writerConn.OpenStream(0, StreamMode(2))
+	assert.ErrorIs(t, err, InvalidStreamMode)
+
+	writerStream, err := writerConn.OpenStream(0, ByteMode)
+	require.NoError(t, err)
+	assert.Equal(t, ByteMode, writerStream.Mode())
+
+	_, err = writerConn.OpenStream(0, MessageMode)
+	assert.ErrorIs(t, err, InvalidStreamMode)
+
+	p := packet.Get()
+	p.Content.Write([]byte("message"))
+	p.Metadata.ContentLength = uint32(len("message"))
+	assert.ErrorIs(t, writerStream.WritePacket(p), InvalidStreamMode)
+	packet.Put(p)
+
+	data := make([]byte, maxStreamWriteSize*2+512)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := writerStream.Write(data[:16])
+		if err == nil {
+			_, err = writerStream.Write(data[16:])
+		}
+		if err == nil {
+			err = writerStream.Close()
+		}
+		writeErr <- err
+	}()
+
+	var readerStream *Stream
+	timer := time.NewTimer(DefaultDeadline)
+	select {
+	case <-timer.C:
+		t.Fatal("timed out waiting for reader stream")
+	case readerStream = <-readerStreamCh:
+	}
+	assert.Equal(t, ByteMode, readerStream.Mode())
+
+	_, err = readerStream.ReadPacket()
+	assert.ErrorIs(t, err, InvalidStreamMode)
+
+	received, err := io.ReadAll(readerStream)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+	require.NoError(t, <-writeErr)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestStreamByteModeNotNegotiated(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	_, err := writerConn.OpenStream(0, ByteMode)
+	assert.ErrorIs(t, err, FeatureNotNegotiated)
+
+	stream, err := writerConn.OpenStream(0, MessageMode)
+	require.NoError(t, err)
+	assert.Equal(t, MessageMode, stream.Mode())
+
+	_, err = stream.Write([]byte("bytes"))
+	assert.ErrorIs(t, err, InvalidStreamMode)
+	_, err = stream.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, InvalidStreamMode)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
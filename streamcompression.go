@@ -0,0 +1,137 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// DefaultStreamCompressionLevel is the compression level of streams when none is set using the WithStreamCompression option
+const DefaultStreamCompressionLevel = flate.BestSpeed
+
+// streamInput is the compressed content of the packets read from a stream, which the decompression context of the
+// stream reads from as a single continuous input
+type streamInput struct {
+	buf []byte
+	off int
+}
+
+// append adds the compressed content of a packet to the input
+func (in *streamInput) append(b []byte) {
+	if in.off > 0 {
+		in.buf = in.buf[:copy(in.buf, in.buf[in.off:])]
+		in.off = 0
+	}
+	in.buf = append(in.buf, b...)
+}
+
+func (in *streamInput) Read(b []byte) (int, error) {
+	if in.off == len(in.buf) {
+		return 0, io.EOF
+	}
+	n := copy(b, in.buf[in.off:])
+	in.off += n
+	return n, nil
+}
+
+// ReadByte keeps flate from buffering the input, which would read past the content of the current packet
+func (in *streamInput) ReadByte() (byte, error) {
+	if in.off == len(in.buf) {
+		return 0, io.EOF
+	}
+	in.off++
+	return in.buf[in.off-1], nil
+}
+
+// streamDecompressor is the persistent decompression context of the packets read from a stream, which is only used
+// by the read loop of the connection
+type streamDecompressor struct {
+	in streamInput
+	r  io.ReadCloser
+}
+
+// compressStream compresses the content of a packet written to the stream with the persistent compression context
+// of the stream, which makes later packets compress better when they repeat the content of earlier ones. The content
+// is prefixed with its uncompressed length and ends with a sync flush, so the receiver can decompress it as soon as
+// it arrives. It must be called with the compressMu of the stream held, and the returned content is only valid until
+// the next packet is compressed.
+func (s *Stream) compressStream(content []byte) ([]byte, error) {
+	s.compressed.Reset()
+	var length [binary.MaxVarintLen64]byte
+	s.compressed.Write(length[:binary.PutUvarint(length[:], uint64(len(content)))])
+	if s.compressor == nil {
+		level := s.conn.options.StreamCompressionLevel
+		if level == 0 || !validCompressionLevel(level) {
+			level = DefaultStreamCompressionLevel
+		}
+		s.compressor, _ = flate.NewWriter(&s.compressed, level)
+	}
+	_, err := s.compressor.Write(content)
+	if err == nil {
+		err = s.compressor.Flush()
+	}
+	return s.compressed.Bytes(), err
+}
+
+// writeCompressed writes a packet whose content is compressed with the persistent compression context of the stream.
+// Packets are compressed and written while holding the compressMu, so that they arrive in the order they were compressed.
+func (s *Stream) writeCompressed(p *packet.Packet) error {
+	s.compressMu.Lock()
+	defer s.compressMu.Unlock()
+	content, err := s.compressStream((*p.Content)[:p.Metadata.ContentLength])
+	if err != nil {
+		return err
+	}
+	compressed := packet.Get()
+	defer packet.Put(compressed)
+	*compressed.Metadata = *p.Metadata
+	compressed.Content.Write(content)
+	compressed.Metadata.ContentLength = uint32(len(content))
+	return s.owner.writePacket(compressed)
+}
+
+// decompressStream decompresses the content of p in place with the persistent decompression context of the stream
+// after it has been read from the wire. It is only called by the read loop of the connection.
+func (s *Stream) decompressStream(p *packet.Packet) error {
+	content := (*p.Content)[:p.Metadata.ContentLength]
+	length, n := binary.Uvarint(content)
+	if n <= 0 || length > math.MaxUint32 {
+		return InvalidContentEncoding
+	}
+	if s.decompressor == nil {
+		s.decompressor = new(streamDecompressor)
+		s.decompressor.r = flate.NewReader(&s.decompressor.in)
+	}
+	s.decompressor.in.append(content[n:])
+	buf := compressionBuffers.Get().(*bytes.Buffer)
+	defer compressionBuffers.Put(buf)
+	buf.Reset()
+	_, err := io.CopyN(buf, s.decompressor.r, int64(length))
+	if err != nil {
+		return InvalidContentEncoding
+	}
+	p.Content.Reset()
+	p.Content.Write(buf.Bytes())
+	p.Metadata.ContentLength = uint32(length)
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCompression(t *testing.T) {
+	t.Parallel()
+
+	const messages = 128
+
+	emptyLogger := zerolog.New(io.Discard)
+	features := FeatureStreamCompression | FeatureStreamClose | FeatureByteStreams
+
+	// connect returns a pair of connections with stream compression, where the bytes written by the writer are counted
+	connect := func() (*Async, *Async, *ByteCounter, chan *Stream) {
+		reader, writer := net.Pipe()
+		counter := NewByteCounter()
+		readerConn := newAsync(reader, loadOptions(WithLogger(&emptyLogger)), features)
+		writerConn := newAsync(counter.Wrap(writer), loadOptions(WithLogger(&emptyLogger), WithStreamCompression(0)), features)
+		streams := make(chan *Stream, 1)
+		readerConn.SetNewStreamHandler(func(stream *Stream) {
+			streams <- stream
+		})
+		return readerConn, writerConn, counter, streams
+	}
+
+	t.Run("messages", func(t *testing.T) {
+		t.Parallel()
+
+		readerConn, writerConn, counter, streams := connect()
+		writerStream := writerConn.NewStream(0)
+
+		// Small messages that repeat each other compress well with a persistent context, but not on their own
+		var raw int
+		for i := 0; i < messages; i++ {
+			message := fmt.Sprintf(`{"sensor":"temperature","unit":"celsius","sequence":%d}`, i)
+			raw += len(message)
+			p := packet.Get()
+			p.Content.Write([]byte(message))
+			p.Metadata.ContentLength = uint32(len(message))
+			require.NoError(t, writerStream.WritePacket(p))
+			packet.Put(p)
+		}
+
+		var readerStream *Stream
+		select {
+		case readerStream = <-streams:
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for reader stream")
+		}
+		for i := 0; i < messages; i++ {
+			p, err := readerStream.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf(`{"sensor":"temperature","unit":"celsius","sequence":%d}`, i), string(*p.Content))
+			packet.Put(p)
+		}
+		assert.Less(t, counter.BytesWritten(), uint64(raw))
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		t.Parallel()
+
+		readerConn, writerConn, _, streams := connect()
+		writerStream, err := writerConn.OpenStream(0, ByteMode)
+		require.NoError(t, err)
+
+		data := make([]byte, maxStreamWriteSize*3)
+		rand.New(rand.NewSource(1)).Read(data[:maxStreamWriteSize])
+		copy(data[maxStreamWriteSize:], bytes.Repeat(data[:maxStreamWriteSize], 2))
+		go func() {
+			_, _ = writerStream.Write(data)
+		}()
+
+		var readerStream *Stream
+		select {
+		case readerStream = <-streams:
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for reader stream")
+		}
+		received := make([]byte, len(data))
+		_, err = io.ReadFull(readerStream, received)
+		require.NoError(t, err)
+		assert.Equal(t, data, received)
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+}
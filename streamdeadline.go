@@ -0,0 +1,121 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"os"
+	"time"
+)
+
+// SetDeadline sets the read and write deadlines of the stream, which work independently of the deadlines of the
+// connection (see SetReadDeadline and SetWriteDeadline). A zero value for t means that reads and writes do not time out.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.setDeadline(&s.readDeadline, t)
+	s.setDeadline(&s.writeDeadline, t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for reads from the stream, including the reads that are already blocked. Reads
+// that time out return os.ErrDeadlineExceeded, and the stream can still be read from after extending the deadline.
+// A zero value for t means that reads do not time out.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.setDeadline(&s.readDeadline, t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes to the stream. Writes that would have to wait for the stream's quota
+// (see StreamQuota) beyond the deadline, or that are made after it, return os.ErrDeadlineExceeded without writing
+// anything. Once a packet has been handed to the connection, it is bounded by the write deadline of the connection
+// instead. A zero value for t means that writes do not time out.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.setDeadline(&s.writeDeadline, t)
+	return nil
+}
+
+// setDeadline sets the given deadline of the stream to t, and wakes up the readers that are waiting so that
+// they can apply the new deadline
+func (s *Stream) setDeadline(deadline *time.Time, t time.Time) {
+	s.deadlineMu.Lock()
+	*deadline = t
+	close(s.deadlineChanged)
+	s.deadlineChanged = make(chan struct{})
+	s.deadlineMu.Unlock()
+}
+
+// deadline returns the given deadline of the stream
+func (s *Stream) deadline(deadline *time.Time) time.Time {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+	return *deadline
+}
+
+// signal wakes up a reader that is waiting for a packet
+func (s *Stream) signal() {
+	select {
+	case s.readable <- struct{}{}:
+	default:
+	}
+}
+
+// waitReadable blocks until the queue of the stream has a packet or the stream is closed, and returns
+// os.ErrDeadlineExceeded if the read deadline of the stream passes first
+func (s *Stream) waitReadable() error {
+	for s.queue.IsEmpty() && !s.closed.Load() {
+		s.deadlineMu.Lock()
+		deadline, changed := s.readDeadline, s.deadlineChanged
+		s.deadlineMu.Unlock()
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case <-s.readable:
+		case <-s.done:
+		case <-changed:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	return nil
+}
+
+// waitWritable waits until the quota of the stream (if it has one) allows n bytes to be written, and returns
+// os.ErrDeadlineExceeded without consuming the quota if that would take longer than the write deadline of the stream
+func (s *Stream) waitWritable(n int) error {
+	deadline := s.deadline(&s.writeDeadline)
+	if deadline.IsZero() {
+		if throttle := s.throttle.Load(); throttle != nil {
+			throttle.waitN(n)
+		}
+		return nil
+	}
+	if !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	if throttle := s.throttle.Load(); throttle != nil && !throttle.waitUntil(n, deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
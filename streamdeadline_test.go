@@ -0,0 +1,110 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamDeadline(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+	t.Cleanup(func() {
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+
+	readerStream := readerConn.NewStream(0)
+	writerStream := writerConn.NewStream(0)
+
+	t.Run("read", func(t *testing.T) {
+		require.NoError(t, readerStream.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+		start := time.Now()
+		_, err := readerStream.ReadPacket()
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*50)
+
+		// The stream and the connection are still usable after a read times out
+		assert.False(t, readerStream.closed.Load())
+		assert.False(t, readerConn.Closed())
+	})
+
+	t.Run("extended", func(t *testing.T) {
+		require.NoError(t, readerStream.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+
+		errCh := make(chan error, 1)
+		go func() {
+			p, err := readerStream.ReadPacket()
+			if err == nil {
+				packet.Put(p)
+			}
+			errCh <- err
+		}()
+
+		// Clearing the deadline while the read is blocked keeps it waiting for the packet
+		time.Sleep(time.Millisecond * 10)
+		require.NoError(t, readerStream.SetReadDeadline(time.Time{}))
+		time.Sleep(time.Millisecond * 100)
+
+		p := packet.Get()
+		p.Content.Write([]byte("late"))
+		p.Metadata.ContentLength = 4
+		require.NoError(t, writerStream.WritePacket(p))
+		packet.Put(p)
+
+		select {
+		case err := <-errCh:
+			assert.NoError(t, err)
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for the stream read")
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		p := packet.Get()
+		p.Content.Write([]byte("data"))
+		p.Metadata.ContentLength = 4
+		defer packet.Put(p)
+
+		require.NoError(t, writerStream.SetWriteDeadline(time.Now().Add(-time.Second)))
+		assert.ErrorIs(t, writerStream.WritePacket(p), os.ErrDeadlineExceeded)
+
+		// Writes that would wait for the quota of the stream beyond the deadline fail without waiting
+		writerStream.throttle.Store(NewThrottle(4, 4))
+		defer writerStream.throttle.Store(nil)
+		require.NoError(t, writerStream.SetWriteDeadline(time.Now().Add(time.Second)))
+		require.NoError(t, writerStream.WritePacket(p))
+		start := time.Now()
+		assert.ErrorIs(t, writerStream.WritePacket(p), os.ErrDeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Millisecond*100)
+		assert.False(t, writerStream.closed.Load())
+	})
+}
@@ -0,0 +1,158 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Initiator returns whether this side of the connection initiated it, which is true for the connections of a Client
+// and connections created using ConnectAsync or ConnectAsyncWithDialer.
+//
+// The initiator allocates odd stream IDs and the other peer allocates even stream IDs in OpenNextStream, and
+// the initiator's stream wins when both peers open the same stream ID in different modes at the same time.
+func (c *Async) Initiator() bool {
+	return c.initiator.Load()
+}
+
+// SetInitiator sets whether this side of the connection initiated it, which is required for symmetric peers that
+// wrap both sides of a connection using NewAsync (where neither side is the initiator by default). It must be called
+// before any streams are opened on the connection, and exactly one of the peers must be the initiator.
+func (c *Async) SetInitiator(initiator bool) {
+	c.initiator.Store(initiator)
+}
+
+// OpenNextStream opens a new stream in the given StreamMode (see OpenStream) using the next unused stream ID from
+// this side's half of the ID space: odd IDs for the initiator of the connection and even IDs for the other peer.
+// Since the peers allocate from disjoint IDs, streams opened by both peers at the same time never collide.
+//
+// If every stream ID in this side's half of the ID space is in use, StreamIDsExhausted is returned.
+func (c *Async) OpenNextStream(mode StreamMode) (*Stream, error) {
+	err := c.checkStreamMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	c.streamsMu.Lock()
+	id, ok := c.allocateStreamID()
+	if !ok {
+		c.streamsMu.Unlock()
+		return nil, StreamIDsExhausted
+	}
+	stream := newStream(id, c, mode)
+	stream.local = true
+	c.streams[id] = stream
+	c.streamsMu.Unlock()
+
+	err = c.announceStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// allocateStreamID returns the next unused stream ID with the parity of this side of the connection,
+// and must be called with the streams locked
+func (c *Async) allocateStreamID() (uint16, bool) {
+	parity := uint16(0)
+	if c.initiator.Load() {
+		parity = 1
+	}
+	id := c.nextStreamID&^1 | parity
+	for i := 0; i < 1<<15; i++ {
+		if _, ok := c.streams[id]; !ok {
+			c.nextStreamID = id + 2
+			return id, true
+		}
+		id += 2
+	}
+	return 0, false
+}
+
+// checkStreamMode returns an error if streams cannot be opened in the given StreamMode on the connection
+func (c *Async) checkStreamMode(mode StreamMode) error {
+	switch mode {
+	case MessageMode:
+		return nil
+	case ByteMode:
+		if !c.features.Has(FeatureByteStreams) {
+			return FeatureNotNegotiated
+		}
+		return nil
+	default:
+		return InvalidStreamMode
+	}
+}
+
+// announceStream signals a newly opened ByteMode stream to the peer with a STREAMOPEN packet, removing
+// the stream if the packet cannot be written. MessageMode streams are not announced.
+func (c *Async) announceStream(stream *Stream) error {
+	if stream.mode == MessageMode {
+		return nil
+	}
+	err := c.writeStreamOpen(stream.id, stream.mode, false)
+	if err != nil {
+		stream.close()
+		c.streamsMu.Lock()
+		if c.streams[stream.id] == stream {
+			delete(c.streams, stream.id)
+		}
+		c.streamsMu.Unlock()
+	}
+	return err
+}
+
+// writeStreamOpen writes a STREAMOPEN packet for the stream with the given ID and mode. If internal is true,
+// the packet is written without closing the connection on errors (see write).
+func (c *Async) writeStreamOpen(id uint16, mode StreamMode, internal bool) error {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = STREAMOPEN
+	p.Content.Write([]byte{byte(mode)})
+	p.Metadata.ContentLength = 1
+	var err error
+	if internal {
+		err = c.write(p)
+	} else {
+		err = c.writePacket(p)
+	}
+	packet.Put(p)
+	return err
+}
+
+// resolveStreamOpen resolves a STREAMOPEN packet for a stream that was also opened locally in a different mode, which
+// happens when both peers open the same stream ID at the same time. The initiator's stream wins, so the initiator tells
+// the peer which mode it opened the stream in, and the peer replaces its stream with one in the initiator's mode. It is
+// called by the read loop, and returns the replacement stream (if there is one) that must be passed to the NewStreamHandler.
+func (c *Async) resolveStreamOpen(stream *Stream, mode StreamMode) (*Stream, error) {
+	if c.initiator.Load() {
+		c.Logger().Debug().Uint16("Stream ID", stream.id).Msg("stream opened by both peers, keeping the local stream")
+		return nil, c.writeStreamOpen(stream.id, stream.mode, true)
+	}
+	c.Logger().Debug().Uint16("Stream ID", stream.id).Msg("stream opened by both peers, replacing the local stream")
+	stream.close()
+	replacement := newStream(stream.id, c, mode)
+	c.streamsMu.Lock()
+	c.streams[stream.id] = replacement
+	c.streamsMu.Unlock()
+	return replacement, nil
+}
+
+// OpenNextStream opens a new Stream in the given StreamMode using the next unused odd stream ID (see Async.OpenNextStream)
+func (c *Client) OpenNextStream(mode StreamMode) (*Stream, error) {
+	return c.conn.OpenNextStream(mode)
+}
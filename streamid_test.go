@@ -0,0 +1,190 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncOpenNextStream(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	initiatorPipe, acceptorPipe := net.Pipe()
+
+	initiator := newAsync(initiatorPipe, options, FeatureByteStreams)
+	acceptor := newAsync(acceptorPipe, options, FeatureByteStreams)
+	initiator.SetInitiator(true)
+	assert.True(t, initiator.Initiator())
+	assert.False(t, acceptor.Initiator())
+
+	// Both peers open streams at the same time and echo the streams opened by the other peer
+	echo := func(stream *Stream) {
+		p, err := stream.ReadPacket()
+		if err != nil {
+			return
+		}
+		_ = stream.WritePacket(p)
+		packet.Put(p)
+	}
+	initiator.SetNewStreamHandler(echo)
+	acceptor.SetNewStreamHandler(echo)
+
+	var wg sync.WaitGroup
+	for _, conn := range []*Async{initiator, acceptor} {
+		conn := conn
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < testSize; i++ {
+				stream, err := conn.OpenNextStream(MessageMode)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, conn.Initiator(), stream.ID()%2 == 1)
+
+				p := packet.Get()
+				p.Content.Write([]byte{byte(i)})
+				p.Metadata.ContentLength = 1
+				assert.NoError(t, stream.WritePacket(p))
+				packet.Put(p)
+
+				p, err = stream.ReadPacket()
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, []byte{byte(i)}, []byte(*p.Content))
+				packet.Put(p)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Stream IDs that are in use are skipped
+	initiator.NewStream(2*testSize + 1)
+	stream, err := initiator.OpenNextStream(ByteMode)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(2*testSize+3), stream.ID())
+
+	assert.NoError(t, initiator.Close())
+	assert.NoError(t, acceptor.Close())
+}
+
+func TestAsyncStreamIDsExhausted(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer := net.Pipe()
+	conn := NewAsync(reader, &emptyLogger)
+
+	stream, err := conn.OpenNextStream(MessageMode)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0), stream.ID())
+
+	// Fill every even stream ID with the same stream
+	conn.streamsMu.Lock()
+	for id := 0; id < 1<<16; id += 2 {
+		conn.streams[uint16(id)] = stream
+	}
+	conn.streamsMu.Unlock()
+	_, err = conn.OpenNextStream(MessageMode)
+	assert.ErrorIs(t, err, StreamIDsExhausted)
+
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, writer.Close())
+}
+
+func TestAsyncSimultaneousOpen(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+
+	for name, initiatorMode := range map[string]StreamMode{"message": MessageMode, "byte": ByteMode} {
+		initiatorMode := initiatorMode
+		acceptorMode := ByteMode
+		if initiatorMode == ByteMode {
+			acceptorMode = MessageMode
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			initiatorPipe, acceptorPipe := net.Pipe()
+			initiator := newAsync(initiatorPipe, options, FeatureByteStreams)
+			acceptor := newAsync(acceptorPipe, options, FeatureByteStreams)
+			initiator.SetInitiator(true)
+
+			acceptorStreams := make(chan *Stream, 1)
+			acceptor.SetNewStreamHandler(func(stream *Stream) {
+				acceptorStreams <- stream
+			})
+
+			// Both peers open stream 7 in different modes at the same time
+			initiatorStream, err := initiator.OpenStream(7, initiatorMode)
+			require.NoError(t, err)
+			acceptorStream, err := acceptor.OpenStream(7, acceptorMode)
+			require.NoError(t, err)
+
+			// The initiator's stream wins, so the acceptor's stream is replaced by one in the initiator's mode
+			var replacement *Stream
+			select {
+			case replacement = <-acceptorStreams:
+			case <-time.After(time.Second):
+				t.Fatal("acceptor stream was not replaced")
+			}
+			assert.Equal(t, initiatorMode, replacement.Mode())
+			assert.Equal(t, uint16(7), replacement.ID())
+			assert.Equal(t, initiatorMode, initiatorStream.Mode())
+			_, err = acceptorStream.readPacket()
+			assert.ErrorIs(t, err, StreamClosed)
+
+			if initiatorMode == ByteMode {
+				_, err = initiatorStream.Write([]byte("hello"))
+				require.NoError(t, err)
+				buf := make([]byte, 5)
+				_, err = io.ReadFull(replacement, buf)
+				require.NoError(t, err)
+				assert.Equal(t, "hello", string(buf))
+			} else {
+				p := packet.Get()
+				p.Content.Write([]byte("hello"))
+				p.Metadata.ContentLength = 5
+				require.NoError(t, initiatorStream.WritePacket(p))
+				packet.Put(p)
+				p, err = replacement.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, "hello", string(*p.Content))
+				packet.Put(p)
+			}
+
+			assert.NoError(t, initiator.Close())
+			assert.NoError(t, acceptor.Close())
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+var _ net.Listener = (*StreamListener)(nil)
+
+// StreamListener is a net.Listener whose connections are the streams opened by the peer of an Async connection,
+// which allows servers (like an http.Server) to be hosted directly on top of frisbee streams.
+type StreamListener struct {
+	conn      *Async
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// Listen returns a StreamListener that accepts the streams opened by the peer using AcceptStream, and returns
+// them as net.Conns (see Stream.NetConn). It cannot be used if a NewStreamHandler was set.
+func (c *Async) Listen() *StreamListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &StreamListener{
+		conn:   c,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Accept waits for the next stream that is opened by the peer and returns it as a net.Conn. It returns net.ErrClosed
+// once the listener is closed, and ConnectionClosed once the connection is closed.
+func (l *StreamListener) Accept() (net.Conn, error) {
+	stream, err := l.conn.AcceptStream(l.ctx)
+	if err != nil {
+		if l.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		return nil, err
+	}
+	return stream.NetConn(), nil
+}
+
+// Close stops the listener from accepting streams, and unblocks any Accept calls. It does not close the connection
+// or the streams that were already accepted.
+func (l *StreamListener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		l.cancel()
+		err = nil
+	})
+	return err
+}
+
+// Addr returns the local address of the connection
+func (l *StreamListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,80 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamListener(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	reader, writer := net.Pipe()
+
+	serverConn := newAsync(reader, options, FeatureStreamClose|FeatureByteStreams)
+	clientConn := newAsync(writer, options, FeatureStreamClose|FeatureByteStreams)
+	t.Cleanup(func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	})
+
+	listener := serverConn.Listen()
+	assert.Equal(t, serverConn.LocalAddr(), listener.Addr())
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello from "+r.URL.Path)
+	})}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	var nextID uint16
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			nextID++
+			stream, err := clientConn.OpenStream(nextID, ByteMode)
+			if err != nil {
+				return nil, err
+			}
+			return stream.NetConn(), nil
+		},
+	}}
+
+	for _, path := range []string{"/first", "/second"} {
+		res, err := client.Get("http://frisbee" + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, "hello from "+path, string(body))
+	}
+
+	require.NoError(t, listener.Close())
+	assert.ErrorIs(t, <-serveErr, net.ErrClosed)
+	assert.ErrorIs(t, listener.Close(), net.ErrClosed)
+}
@@ -0,0 +1,83 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+var _ net.Conn = (*streamNetConn)(nil)
+
+// StreamAddr is the address of one end of a stream, which is the address of its connection and the ID of the stream
+type StreamAddr struct {
+	net.Addr
+	ID uint16
+}
+
+// String returns the address of the connection followed by the ID of the stream (like "127.0.0.1:8192/1")
+func (a StreamAddr) String() string {
+	return a.Addr.String() + "/" + strconv.Itoa(int(a.ID))
+}
+
+// streamNetConn is the net.Conn returned by Stream.NetConn
+type streamNetConn struct {
+	stream *Stream
+}
+
+// NetConn returns a net.Conn that reads and writes the stream as a stream of bytes, which allows any protocol
+// (like HTTP, SSH, or a database protocol) to be tunneled over the stream. Its deadlines are the deadlines of the
+// stream (see SetDeadline), its addresses are StreamAddrs, and closing it closes the stream.
+//
+// The returned net.Conn can be used with streams in either mode, but MessageMode streams do not preserve
+// the boundaries between its writes, so NetConn is best used with ByteMode streams (see Async.OpenStream).
+func (s *Stream) NetConn() net.Conn {
+	return &streamNetConn{stream: s}
+}
+
+func (c *streamNetConn) Read(b []byte) (int, error) {
+	return c.stream.read(b)
+}
+
+func (c *streamNetConn) Write(b []byte) (int, error) {
+	return c.stream.write(b)
+}
+
+func (c *streamNetConn) Close() error {
+	return c.stream.Close()
+}
+
+func (c *streamNetConn) LocalAddr() net.Addr {
+	return StreamAddr{Addr: c.stream.owner.LocalAddr(), ID: c.stream.id}
+}
+
+func (c *streamNetConn) RemoteAddr() net.Addr {
+	return StreamAddr{Addr: c.stream.owner.RemoteAddr(), ID: c.stream.id}
+}
+
+func (c *streamNetConn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *streamNetConn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *streamNetConn) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamNetConn(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+	reader, writer := net.Pipe()
+
+	readerConn := newAsync(reader, options, FeatureStreamClose|FeatureByteStreams)
+	writerConn := newAsync(writer, options, FeatureStreamClose|FeatureByteStreams)
+	t.Cleanup(func() {
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+
+	writerStream, err := writerConn.OpenStream(3, ByteMode)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDeadline)
+	defer cancel()
+	readerStream, err := readerConn.AcceptStream(ctx)
+	require.NoError(t, err)
+
+	client, server := writerStream.NetConn(), readerStream.NetConn()
+	assert.Equal(t, writerConn.LocalAddr().String()+"/3", client.LocalAddr().String())
+	assert.Equal(t, StreamAddr{Addr: readerConn.RemoteAddr(), ID: 3}, server.RemoteAddr())
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(server, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// Reads time out like the reads of any other net.Conn
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Millisecond*20)))
+	_, err = server.Read(buf)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+
+	require.NoError(t, client.Close())
+	_, err = server.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,116 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// StreamOverflow decides what happens when a packet is received for a stream whose queue is full (see StreamQueue).
+type StreamOverflow uint8
+
+const (
+	// StreamOverflowBlock stops reading from the connection until the stream's reader makes room in the queue,
+	// which applies backpressure to the peer but also delays the packets of every other stream on the connection
+	StreamOverflowBlock = StreamOverflow(iota)
+
+	// StreamOverflowReset resets the stream with StreamCodeResourceExhausted (see Stream.CloseWithError), which
+	// discards the packets in its queue so that its reads return the StreamResetError right away. Packets that the
+	// peer sends before it receives the reset open a new stream with the same ID.
+	StreamOverflowReset
+
+	// StreamOverflowDrop discards the packet (see Stream.Dropped)
+	StreamOverflowDrop
+)
+
+// StreamQueue configures the queue of received packets that every stream of a connection has (see the
+// WithStreamQueue option and Stream.SetQueue), which bounds the memory used by streams whose packets are not read.
+type StreamQueue struct {
+	// Size is the number of packets that can be queued for a stream before its Overflow policy is applied
+	// (defaults to DefaultStreamBufferSize)
+	Size int
+
+	// Overflow is what happens when a packet is received for a stream whose queue is full
+	// (defaults to StreamOverflowBlock)
+	Overflow StreamOverflow
+}
+
+// withDefaults returns the StreamQueue with the default size if it has none
+func (q StreamQueue) withDefaults() StreamQueue {
+	if q.Size <= 0 {
+		q.Size = DefaultStreamBufferSize
+	}
+	return q
+}
+
+// SetQueue changes the queue of the stream, where the Size can only be lowered from the StreamQueue of the
+// connection (larger sizes are capped to it) and defaults to it if it is 0. Packets that are already queued
+// are kept, even if there are more of them than the new size allows.
+func (s *Stream) SetQueue(q StreamQueue) {
+	if q.Size <= 0 || q.Size > s.capacity {
+		q.Size = s.capacity
+	}
+	s.queueLimits.Store(&q)
+	s.signalSpace()
+}
+
+// Dropped returns the number of packets that were discarded because the queue of the stream was full
+// (see StreamOverflowDrop).
+func (s *Stream) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// push adds p to the queue of the stream, and wakes up a reader that is waiting for it. If the queue is full, the
+// Overflow policy of the stream is applied.
+func (s *Stream) push(p *packet.Packet) error {
+	limits := s.queueLimits.Load()
+	for s.queue.Length() >= limits.Size && !s.closed.Load() {
+		switch limits.Overflow {
+		case StreamOverflowDrop:
+			packet.Put(p)
+			s.dropped.Inc()
+			return nil
+		case StreamOverflowReset:
+			packet.Put(p)
+			if s.closeWith(StreamCodeResourceExhausted, false) {
+				s.owner.removeStream(s.id)
+				go func() {
+					_ = s.sendClose(StreamCodeResourceExhausted)
+				}()
+			}
+			return nil
+		}
+		select {
+		case <-s.space:
+		case <-s.done:
+		}
+		limits = s.queueLimits.Load()
+	}
+	err := s.queue.Push(p)
+	if err == nil {
+		s.signal()
+	}
+	return err
+}
+
+// signalSpace wakes up a writer that is waiting for room in the queue
+func (s *Stream) signalSpace() {
+	select {
+	case s.space <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,134 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamQueue(t *testing.T) {
+	t.Parallel()
+
+	const packets = 8
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	// fill writes n packets to a new stream and returns the matching stream of the reader
+	fill := func(t *testing.T, queue StreamQueue, n int) (*Async, *Stream, *Stream) {
+		options := loadOptions(WithLogger(&emptyLogger), WithStreamQueue(queue))
+		reader, writer := net.Pipe()
+		readerConn := newAsync(reader, options, FeatureStreamClose|FeatureStreamReset)
+		writerConn := newAsync(writer, options, FeatureStreamClose|FeatureStreamReset)
+		t.Cleanup(func() {
+			_ = readerConn.Close()
+			_ = writerConn.Close()
+		})
+
+		readerStreamCh := make(chan *Stream, 1)
+		readerConn.SetNewStreamHandler(func(stream *Stream) {
+			readerStreamCh <- stream
+		})
+
+		writerStream := writerConn.NewStream(0)
+		for i := 0; i < n; i++ {
+			p := packet.Get()
+			p.Content.Write([]byte{byte(i)})
+			p.Metadata.ContentLength = 1
+			require.NoError(t, writerStream.WritePacket(p))
+			packet.Put(p)
+		}
+
+		select {
+		case readerStream := <-readerStreamCh:
+			return readerConn, writerStream, readerStream
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for reader stream")
+			return nil, nil, nil
+		}
+	}
+
+	t.Run("block", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, readerStream := fill(t, StreamQueue{Size: 2}, packets)
+		time.Sleep(time.Millisecond * 50)
+		assert.Equal(t, 2, readerStream.queue.Length())
+
+		// Every packet is delivered once the reader makes room in the queue
+		for i := 0; i < packets; i++ {
+			p, err := readerStream.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, byte(i), (*p.Content)[0])
+			packet.Put(p)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, readerStream := fill(t, StreamQueue{Size: 2, Overflow: StreamOverflowDrop}, packets)
+		require.Eventually(t, func() bool {
+			return readerStream.Dropped() == packets-2
+		}, DefaultDeadline, time.Millisecond)
+		for i := 0; i < 2; i++ {
+			p, err := readerStream.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, byte(i), (*p.Content)[0])
+			packet.Put(p)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		t.Parallel()
+
+		// Only one packet more than the queue holds is written, because the packets that arrive after the reset
+		// open a new stream with the same ID on the reader
+		readerConn, writerStream, readerStream := fill(t, StreamQueue{Size: 2, Overflow: StreamOverflowReset}, 3)
+		require.Eventually(t, writerStream.closed.Load, DefaultDeadline, time.Millisecond)
+
+		var reset *StreamResetError
+		_, err := writerStream.ReadPacket()
+		require.True(t, errors.As(err, &reset))
+		assert.Equal(t, StreamCodeResourceExhausted, reset.Code)
+		assert.True(t, reset.Remote)
+
+		// The queued packets were discarded by the reset
+		_, err = readerStream.ReadPacket()
+		require.True(t, errors.As(err, &reset))
+		assert.Equal(t, StreamCodeResourceExhausted, reset.Code)
+		assert.False(t, readerConn.Closed())
+	})
+
+	t.Run("set", func(t *testing.T) {
+		t.Parallel()
+
+		stream := newStreamWithQueue(0, MessageMode, StreamQueue{Size: 4})
+		stream.SetQueue(StreamQueue{Size: 16, Overflow: StreamOverflowDrop})
+		assert.Equal(t, StreamQueue{Size: 4, Overflow: StreamOverflowDrop}, *stream.queueLimits.Load())
+		stream.SetQueue(StreamQueue{Size: 1})
+		assert.Equal(t, StreamQueue{Size: 1}, *stream.queueLimits.Load())
+	})
+}
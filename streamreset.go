@@ -0,0 +1,151 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// streamCodeSize is the size of the error code that is carried in the content of a STREAMCLOSE packet
+// when a stream is reset (see Stream.CloseWithError)
+const streamCodeSize = 4
+
+// These are the error codes that streams can be reset with (see Stream.CloseWithError). Applications
+// can use their own codes as well, starting from StreamCodeApplication:
+const (
+	// StreamCodeNone means that the stream was closed normally
+	StreamCodeNone = uint32(iota)
+
+	// StreamCodeCancelled means that the stream was no longer needed by the peer that reset it
+	StreamCodeCancelled
+
+	// StreamCodeResourceExhausted means that the peer that reset the stream ran out of resources
+	// (like memory or a quota) to handle it
+	StreamCodeResourceExhausted
+
+	// StreamCodeProtocolError means that the peer that reset the stream received data that it did not expect
+	StreamCodeProtocolError
+
+	// StreamCodeInternalError means that the peer that reset the stream failed to handle it
+	StreamCodeInternalError
+
+	// StreamCodeApplication is the first of the error codes that are reserved for applications
+	StreamCodeApplication = uint32(1 << 16)
+)
+
+// StreamResetError is returned by the reads and writes of a stream that was reset with an error code by either peer
+// (see Stream.CloseWithError). It matches StreamClosed when used with errors.Is.
+type StreamResetError struct {
+	// Code is the error code that the stream was reset with
+	Code uint32
+
+	// Remote is true if the stream was reset by the peer
+	Remote bool
+}
+
+func (e *StreamResetError) Error() string {
+	var name string
+	switch e.Code {
+	case StreamCodeCancelled:
+		name = "cancelled"
+	case StreamCodeResourceExhausted:
+		name = "resource exhausted"
+	case StreamCodeProtocolError:
+		name = "protocol error"
+	case StreamCodeInternalError:
+		name = "internal error"
+	default:
+		name = fmt.Sprintf("code %d", e.Code)
+	}
+	if e.Remote {
+		return "stream reset by peer: " + name
+	}
+	return "stream reset: " + name
+}
+
+// Is returns true for StreamClosed, so that callers that only check whether a stream was closed keep working
+func (e *StreamResetError) Is(target error) bool {
+	return target == StreamClosed
+}
+
+// CloseWithError resets the stream with the given error code, which discards the packets that have not been read
+// yet and prevents any further reads or writes. If the FeatureStreamReset feature was negotiated, the code is sent
+// to the peer so that the reads and writes of its stream return a StreamResetError with the same code, and otherwise
+// the peer sees the stream being closed normally.
+//
+// Resetting a stream with StreamCodeNone is the same as closing it with Close.
+func (s *Stream) CloseWithError(code uint32) error {
+	if code == StreamCodeNone {
+		return s.Close()
+	}
+	if !s.closeWith(code, false) {
+		return StreamClosed
+	}
+	err := s.sendClose(code)
+
+	s.owner.removeStream(s.id)
+
+	return err
+}
+
+// sendClose sends the packet that closes the stream to the peer, which carries the given error code if the
+// FeatureStreamReset feature was negotiated
+func (s *Stream) sendClose(code uint32) error {
+	p := packet.Get()
+	p.Metadata.Id = s.id
+	p.Metadata.Operation = STREAM
+	features := s.owner.Features()
+	if code != StreamCodeNone && features.Has(FeatureStreamReset) {
+		p.Metadata.Operation = STREAMCLOSE
+		var content [streamCodeSize]byte
+		binary.BigEndian.PutUint32(content[:], code)
+		p.Content.Write(content[:])
+		p.Metadata.ContentLength = streamCodeSize
+	} else if features.Has(FeatureStreamClose) {
+		p.Metadata.Operation = STREAMCLOSE
+	}
+	err := s.owner.writePacket(p)
+	packet.Put(p)
+	return err
+}
+
+// Code returns the error code that the stream was reset with by either peer, or StreamCodeNone if it
+// was not reset (see CloseWithError).
+func (s *Stream) Code() uint32 {
+	return s.code.Load()
+}
+
+// closedError returns the error for the reads and writes of the closed stream
+func (s *Stream) closedError() error {
+	if code := s.code.Load(); code != StreamCodeNone {
+		return &StreamResetError{Code: code, Remote: s.remoteReset.Load()}
+	}
+	return StreamClosed
+}
+
+// closeRemote closes the stream after receiving the given STREAMCLOSE packet from the peer,
+// and resets it with the error code in the packet's content (if it has one)
+func (s *Stream) closeRemote(p *packet.Packet, features Features) {
+	if features.Has(FeatureStreamReset) && p.Metadata.Operation == STREAMCLOSE && p.Metadata.ContentLength == streamCodeSize {
+		s.closeWith(binary.BigEndian.Uint32((*p.Content)[:streamCodeSize]), true)
+		return
+	}
+	s.close()
+}
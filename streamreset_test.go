@@ -0,0 +1,111 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCloseWithError(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	options := loadOptions(WithLogger(&emptyLogger))
+
+	// openStreams returns a stream of the writer and the matching stream of the reader
+	openStreams := func(t *testing.T, features Features) (*Stream, *Stream) {
+		reader, writer := net.Pipe()
+		readerConn := newAsync(reader, options, features)
+		writerConn := newAsync(writer, options, features)
+		t.Cleanup(func() {
+			_ = readerConn.Close()
+			_ = writerConn.Close()
+		})
+
+		readerStreamCh := make(chan *Stream, 1)
+		readerConn.SetNewStreamHandler(func(stream *Stream) {
+			readerStreamCh <- stream
+		})
+
+		writerStream := writerConn.NewStream(0)
+		p := packet.Get()
+		p.Content.Write([]byte("data"))
+		p.Metadata.ContentLength = 4
+		require.NoError(t, writerStream.WritePacket(p))
+		packet.Put(p)
+
+		select {
+		case readerStream := <-readerStreamCh:
+			return writerStream, readerStream
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for reader stream")
+			return nil, nil
+		}
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		t.Parallel()
+
+		writerStream, readerStream := openStreams(t, FeatureStreamClose|FeatureStreamReset)
+		require.NoError(t, writerStream.CloseWithError(StreamCodeResourceExhausted))
+		assert.Equal(t, StreamCodeResourceExhausted, writerStream.Code())
+
+		_, err := writerStream.ReadPacket()
+		var reset *StreamResetError
+		require.True(t, errors.As(err, &reset))
+		assert.Equal(t, StreamCodeResourceExhausted, reset.Code)
+		assert.False(t, reset.Remote)
+
+		// The reader learns why the stream was closed, and the unread packet is discarded
+		require.Eventually(t, readerStream.closed.Load, DefaultDeadline, time.Millisecond)
+		_, err = readerStream.ReadPacket()
+		require.True(t, errors.As(err, &reset))
+		assert.Equal(t, StreamCodeResourceExhausted, reset.Code)
+		assert.True(t, reset.Remote)
+		assert.ErrorIs(t, err, StreamClosed)
+		assert.ErrorIs(t, readerStream.WritePacket(packet.Get()), StreamClosed)
+		assert.Equal(t, StreamCodeResourceExhausted, readerStream.Code())
+
+		assert.ErrorIs(t, writerStream.CloseWithError(StreamCodeCancelled), StreamClosed)
+	})
+
+	t.Run("not negotiated", func(t *testing.T) {
+		t.Parallel()
+
+		writerStream, readerStream := openStreams(t, FeatureStreamClose)
+		require.NoError(t, writerStream.CloseWithError(StreamCodeCancelled))
+
+		// Without FeatureStreamReset the reader sees the stream being closed normally
+		require.Eventually(t, readerStream.closed.Load, DefaultDeadline, time.Millisecond)
+		p, err := readerStream.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(*p.Content))
+		packet.Put(p)
+		_, err = readerStream.ReadPacket()
+		assert.Equal(t, StreamClosed, err)
+		assert.Equal(t, StreamCodeNone, readerStream.Code())
+	})
+}
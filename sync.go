@@ -22,10 +22,10 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"time"
 
-	"github.com/loopholelabs/frisbee-go/internal/dialer"
 	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 	"github.com/pkg/errors"
@@ -33,55 +33,145 @@ import (
 	"go.uber.org/atomic"
 )
 
+// syncFeatures are the Features that Sync connections support
+const syncFeatures = FeatureStreamClose | FeatureByteStreams | FeatureStreamReset
+
 // Sync is the underlying synchronous frisbee connection which has extremely efficient read and write logic and
 // can handle the specific frisbee requirements. This is not meant to be used on its own, and instead is
 // meant to be used by frisbee client and server implementations
 type Sync struct {
 	sync.Mutex
-	conn   net.Conn
-	closed *atomic.Bool
-	logger *zerolog.Logger
-	error  *atomic.Error
-	ctxMu  sync.RWMutex
-	ctx    context.Context
+	conn     net.Conn
+	closed   *atomic.Bool
+	logger   *zerolog.Logger
+	error    *atomic.Error
+	ctxMu    sync.RWMutex
+	ctx      context.Context
+	features Features
+	recorder PacketRecorder
+
+	// readMu guards the fields that coordinate the goroutines that read from the connection, since
+	// both ReadPacket and the readers of streams read packets from the connection themselves
+	readMu   sync.Mutex
+	readCond *sync.Cond
+	reading  bool
+	reader   uint64
+	readers  uint64
+	pending  []*packet.Packet
+
+	streamsMu        sync.Mutex
+	streams          map[uint16]*Stream
+	streamQueue      StreamQueue
+	newStreamHandler NewStreamHandler
 }
 
 // ConnectSync creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
 func ConnectSync(addr string, keepAlive time.Duration, logger *zerolog.Logger, TLSConfig *tls.Config) (*Sync, error) {
-	var conn net.Conn
-	var err error
+	conn, err := dial(addr, keepAlive, TLSConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	d := dialer.NewRetry()
+	return NewSync(conn, logger), nil
+}
 
-	if TLSConfig != nil {
-		conn, err = d.DialTLS("tcp", addr, TLSConfig)
-	} else {
-		conn, err = d.Dial("tcp", addr)
-		if err == nil {
-			_ = conn.(*net.TCPConn).SetKeepAlive(true)
-			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
-		}
+// ConnectSyncWithDialer creates a new connection to addr using the given DialFunc (which is responsible for
+// establishing TLS, if required) and wraps it in a frisbee connection
+func ConnectSyncWithDialer(ctx context.Context, addr string, dial DialFunc, logger *zerolog.Logger) (*Sync, error) {
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
 	}
 
+	return NewSync(conn, logger), nil
+}
+
+// ConnectSyncWithOptions creates a new connection to addr in the same way as Client.Connect (honouring the proxy,
+// dialer, socket, TLS, and wrapper options) and wraps it in a frisbee connection using NewSyncWithOptions
+func ConnectSyncWithOptions(ctx context.Context, addr string, opts ...Option) (*Sync, error) {
+	options := loadOptions(opts...)
+	conn, err := connect(ctx, addr, options)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewSync(conn, logger), nil
+	return newSyncWithOptions(conn, options, true)
 }
 
 // NewSync takes an existing net.Conn object and wraps it in a frisbee connection
 func NewSync(c net.Conn, logger *zerolog.Logger) (conn *Sync) {
-	conn = &Sync{
-		conn:   c,
-		closed: atomic.NewBool(false),
-		logger: logger,
-		error:  atomic.NewError(nil),
+	if logger == nil {
+		logger = &defaultLogger
 	}
+	return newSync(c, loadOptions(WithLogger(logger)), NoFeatures)
+}
 
-	if logger == nil {
-		conn.logger = &defaultLogger
+// NewSyncWithOptions takes an existing net.Conn object and wraps it in a frisbee connection configured with the
+// given options. Like a frisbee client, it installs the connection wrappers, performs the handshake if one is
+// required, and authenticates with the Authenticator (if there is one), so Sync connections can talk to frisbee
+// servers that require them. Sync connections only support the FeatureStreamClose and FeatureByteStreams features,
+// so options that require other features (like WithSigningKey) cause FeatureNotNegotiated to be returned.
+//
+// The Logger, Recorder, ReadBandwidth, and WriteBandwidth options are also honoured by Sync connections.
+func NewSyncWithOptions(c net.Conn, opts ...Option) (*Sync, error) {
+	return newSyncWithOptions(c, loadOptions(opts...), false)
+}
+
+// newSyncWithOptions wraps c in a Sync connection configured with options, where wired
+// is true if the wire wrappers have already been installed (see Options.wrapConn)
+func newSyncWithOptions(c net.Conn, options *Options, wired bool) (*Sync, error) {
+	if options.requiredFeatures()&^syncFeatures != NoFeatures {
+		_ = c.Close()
+		return nil, FeatureNotNegotiated
+	}
+	c = options.wrapConn(c, wired)
+	if read, write := options.ReadBandwidth.throttle(), options.WriteBandwidth.throttle(); read != nil || write != nil {
+		var reader func(io.Reader) io.Reader
+		var writer func(io.Writer) io.Writer
+		if read != nil {
+			reader = read.Reader
+		}
+		if write != nil {
+			writer = write.Writer
+		}
+		c = WrapConn(c, reader, writer)
+	}
+	features := NoFeatures
+	if options.Handshake {
+		var err error
+		features, _, err = handshakeInitiate(c, options.Features&syncFeatures, nil)
+		if err != nil {
+			options.Logger.Debug().Err(err).Msg("error during handshake")
+			_ = c.Close()
+			return nil, err
+		}
 	}
+	if options.Authenticator != nil {
+		err := authenticateInitiate(c, options.Authenticator)
+		if err != nil {
+			options.Logger.Debug().Err(err).Msg("error during authentication")
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return newSync(c, options, features), nil
+}
+
+// newSync wraps an existing net.Conn object in a frisbee connection which has already
+// completed the handshake and negotiated the given features
+func newSync(c net.Conn, options *Options, features Features) (conn *Sync) {
+	conn = &Sync{
+		conn:     c,
+		closed:   atomic.NewBool(false),
+		logger:   options.Logger,
+		error:    atomic.NewError(nil),
+		features: features,
+		recorder: options.Recorder,
+		streams:  make(map[uint16]*Stream),
+
+		streamQueue: options.StreamQueue,
+	}
+	conn.readCond = sync.NewCond(&conn.readMu)
 	return
 }
 
@@ -137,13 +227,34 @@ func (c *Sync) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// Features returns the Features that were negotiated during the handshake (see NewSyncWithOptions)
+func (c *Sync) Features() Features {
+	return c.features
+}
+
+// Closed returns whether the frisbee.Sync connection is closed
+func (c *Sync) Closed() bool {
+	return c.closed.Load()
+}
+
 // WritePacket takes a packet.Packet and sends it synchronously.
 //
 // If packet.Metadata.ContentLength == 0, then the content array must be nil. Otherwise, it is required that packet.Metadata.ContentLength == len(content).
 func (c *Sync) WritePacket(p *packet.Packet) error {
+	return c.WritePacketContext(context.Background(), p)
+}
+
+// WritePacketContext is like WritePacket, but it gives up once ctx is done (or its deadline passes), in which case
+// it returns the error of ctx. If the packet was partially written when ctx was done, the connection is closed,
+// since the peer would otherwise misread the rest of the stream. The write deadline of the underlying connection is
+// replaced with the deadline of ctx while the packet is being written, and is cleared afterwards.
+func (c *Sync) WritePacketContext(ctx context.Context, p *packet.Packet) error {
 	if int(p.Metadata.ContentLength) != len(*p.Content) {
 		return InvalidContentLength
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	var encodedMetadata [metadata.Size]byte
 
@@ -154,52 +265,195 @@ func (c *Sync) WritePacket(p *packet.Packet) error {
 	c.Lock()
 	if c.closed.Load() {
 		c.Unlock()
-		return ConnectionClosed
+		return c.withCause(ConnectionClosed)
 	}
 
-	_, err := c.conn.Write(encodedMetadata[:])
+	stop := c.interruptWrites(ctx)
+	n, err := c.conn.Write(encodedMetadata[:])
+	if err == nil && p.Metadata.ContentLength != 0 {
+		_, err = c.conn.Write((*p.Content)[:p.Metadata.ContentLength])
+	}
+	stop()
 	if err != nil {
 		c.Unlock()
 		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-			return ConnectionClosed
+			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
+			return c.withCause(ConnectionClosed)
 		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-		return c.closeWithError(err)
-	}
-	if p.Metadata.ContentLength != 0 {
-		_, err = c.conn.Write((*p.Content)[:p.Metadata.ContentLength])
-		if err != nil {
-			c.Unlock()
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-				return ConnectionClosed
+		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
+		if ctxErr := contextError(ctx, err); ctxErr != nil {
+			if n == 0 {
+				return ctxErr
 			}
-			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-			return c.closeWithError(err)
+			_ = c.closeWithError(directed("write", ctxErr))
+			return ctxErr
 		}
+		return c.withCause(c.closeWithError(directed("write", err)))
 	}
 
+	if c.recorder != nil {
+		c.recorder.RecordWrite(p)
+	}
 	c.Unlock()
 	return nil
 }
 
+// writePacket writes a packet for one of the streams of the connection
+func (c *Sync) writePacket(p *packet.Packet) error {
+	return c.WritePacket(p)
+}
+
+// interruptWrites applies the deadline of ctx to the writes of the underlying connection and interrupts them once
+// ctx is done, until the returned function is called. It must be called with the connection locked.
+func (c *Sync) interruptWrites(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	deadline, _ := ctx.Deadline()
+	_ = c.conn.SetWriteDeadline(deadline)
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetWriteDeadline(pastTime)
+		case <-stopCh:
+		}
+	}()
+	return func() {
+		close(stopCh)
+		<-stopped
+		_ = c.conn.SetWriteDeadline(emptyTime)
+	}
+}
+
+// contextError returns the error of ctx if err was caused by ctx being done (or its deadline passing), and otherwise nil
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
 // ReadPacket is a blocking function that will wait until a frisbee packet is available and then return it (and its content).
 // In the event that the connection is closed, ReadPacket will return an error.
+//
+// If the connection has open streams (or a NewStreamHandler), the packets of streams are delivered to their streams
+// instead of being returned by ReadPacket. Otherwise, they are returned like any other packet.
 func (c *Sync) ReadPacket() (*packet.Packet, error) {
+	return c.ReadPacketContext(context.Background())
+}
+
+// ReadPacketContext is like ReadPacket, but it gives up once ctx is done (or its deadline passes), in which case
+// it returns the error of ctx. If a packet was partially read when ctx was done, the connection is closed, since
+// the rest of the stream could not be read correctly. The read deadline of the underlying connection is replaced
+// with the deadline of ctx while ReadPacketContext is reading from it, and is cleared afterwards.
+func (c *Sync) ReadPacketContext(ctx context.Context) (*packet.Packet, error) {
 	if c.closed.Load() {
-		return nil, ConnectionClosed
+		return nil, c.withCause(ConnectionClosed)
 	}
-	var encodedPacket [metadata.Size]byte
+	var p *packet.Packet
+	err := c.pull(ctx, func() bool {
+		if len(c.pending) == 0 {
+			return false
+		}
+		p, c.pending = c.pending[0], c.pending[1:]
+		return true
+	})
+	if p != nil {
+		return p, nil
+	}
+	return nil, c.withCause(err)
+}
 
-	_, err := io.ReadAtLeast(c.conn, encodedPacket[:], metadata.Size)
-	if err != nil {
+// pull reads packets from the connection until done returns true (done is called with readMu locked), delivering the
+// packets of streams to their streams and queueing all other packets for ReadPacket. Only one goroutine reads from
+// the connection at a time, and the others wait for it to deliver their packets.
+func (c *Sync) pull(ctx context.Context, done func() bool) error {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.readers++
+	id := c.readers
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.readMu.Lock()
+				if c.reading && c.reader == id {
+					_ = c.conn.SetReadDeadline(pastTime)
+				}
+				c.readCond.Broadcast()
+				c.readMu.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	for !done() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Msg("error while reading from underlying net.Conn")
-			return nil, ConnectionClosed
+			return ConnectionClosed
 		}
-		c.Logger().Debug().Err(err).Msg("error while reading from underlying net.Conn")
-		return nil, c.closeWithError(err)
+		if c.reading {
+			c.readCond.Wait()
+			continue
+		}
+		c.reading, c.reader = true, id
+		if ctx.Done() != nil {
+			deadline, _ := ctx.Deadline()
+			_ = c.conn.SetReadDeadline(deadline)
+		}
+		c.readMu.Unlock()
+		p, n, err := c.readFrame()
+		if err == nil && c.deliver(p) {
+			p = nil
+		}
+		c.readMu.Lock()
+		if ctx.Done() != nil {
+			_ = c.conn.SetReadDeadline(emptyTime)
+		}
+		c.reading = false
+		c.readCond.Broadcast()
+		if err != nil {
+			if c.closed.Load() {
+				c.Logger().Debug().Err(ConnectionClosed).Msg("error while reading from underlying net.Conn")
+				return ConnectionClosed
+			}
+			c.Logger().Debug().Err(err).Msg("error while reading from underlying net.Conn")
+			if ctxErr := contextError(ctx, err); ctxErr != nil {
+				if n > 0 {
+					c.readMu.Unlock()
+					_ = c.closeWithError(directed("read", ctxErr))
+					c.readMu.Lock()
+				}
+				return ctxErr
+			}
+			c.readMu.Unlock()
+			err = c.closeWithError(directed("read", err))
+			c.readMu.Lock()
+			return err
+		}
+		if p != nil {
+			c.pending = append(c.pending, p)
+		}
+	}
+	return nil
+}
+
+// readFrame reads the next packet from the underlying connection, returning the number of bytes that were read
+func (c *Sync) readFrame() (*packet.Packet, int, error) {
+	var encodedPacket [metadata.Size]byte
+
+	n, err := io.ReadAtLeast(c.conn, encodedPacket[:], metadata.Size)
+	if err != nil {
+		return nil, n, err
 	}
 	p := packet.Get()
 
@@ -214,16 +468,15 @@ func (c *Sync) ReadPacket() (*packet.Packet, error) {
 		*p.Content = (*p.Content)[:p.Metadata.ContentLength]
 		_, err = io.ReadAtLeast(c.conn, *p.Content, int(p.Metadata.ContentLength))
 		if err != nil {
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Msg("error while reading from underlying net.Conn")
-				return nil, ConnectionClosed
-			}
-			c.Logger().Debug().Err(err).Msg("error while reading from underlying net.Conn")
-			return nil, c.closeWithError(err)
+			packet.Put(p)
+			return nil, n, err
 		}
 	}
 
-	return p, nil
+	if c.recorder != nil {
+		c.recorder.RecordRead(p)
+	}
+	return p, n, nil
 }
 
 // SetContext allows users to save a context within a connection
@@ -246,11 +499,25 @@ func (c *Sync) Logger() *zerolog.Logger {
 	return c.logger
 }
 
-// Error returns the error that caused the frisbee.Sync to close or go into a paused state
+// Error returns the error that caused the frisbee.Sync to close or go into a paused state, which is a
+// *CloseError if the connection was closed because of an error
 func (c *Sync) Error() error {
 	return c.error.Load()
 }
 
+// CloseReason returns the CloseReason of the error that caused the connection to close
+func (c *Sync) CloseReason() CloseReason {
+	return CloseReasonOf(c.Error())
+}
+
+// withCause replaces ConnectionClosed with a ConnectionClosedError that carries the error that closed the connection
+func (c *Sync) withCause(err error) error {
+	if err != nil && errors.Is(err, ConnectionClosed) {
+		return closedError(c.Error())
+	}
+	return err
+}
+
 // Raw shuts off all of frisbee's underlying functionality and converts the frisbee connection into a normal TCP connection (net.Conn)
 func (c *Sync) Raw() net.Conn {
 	_ = c.close()
@@ -269,12 +536,22 @@ func (c *Sync) Close() error {
 
 func (c *Sync) close() error {
 	if c.closed.CompareAndSwap(false, true) {
+		c.streamsMu.Lock()
+		for id, stream := range c.streams {
+			stream.close()
+			delete(c.streams, id)
+		}
+		c.streamsMu.Unlock()
+		c.readMu.Lock()
+		c.readCond.Broadcast()
+		c.readMu.Unlock()
 		return nil
 	}
 	return ConnectionClosed
 }
 
 func (c *Sync) closeWithError(err error) error {
+	err = closeError(err)
 	closeError := c.close()
 	if errors.Is(closeError, ConnectionClosed) {
 		c.Logger().Debug().Err(err).Msg("attempted to close connection with error, but connection already closed")
@@ -17,6 +17,7 @@
 package frisbee
 
 import (
+	"context"
 	"crypto/rand"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 	"github.com/loopholelabs/polyglot"
@@ -27,6 +28,7 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestNewSync(t *testing.T) {
@@ -330,6 +332,113 @@ func TestSyncWriteClose(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSyncContext(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer := net.Pipe()
+	readerConn := NewSync(reader, &emptyLogger)
+	writerConn := NewSync(writer, &emptyLogger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	_, err := readerConn.ReadPacketContext(ctx)
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+	_, err = readerConn.ReadPacketContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Nothing was read, so the connection can still be used
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, writerConn.WritePacketContext(ctx, p), context.Canceled)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	assert.ErrorIs(t, writerConn.WritePacketContext(ctx, p), context.DeadlineExceeded)
+	cancel()
+
+	written := make(chan error, 1)
+	go func() {
+		written <- writerConn.WritePacketContext(context.Background(), p)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	readPacket, err := readerConn.ReadPacketContext(ctx)
+	cancel()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(64), readPacket.Metadata.Id)
+	packet.Put(readPacket)
+	assert.NoError(t, <-written)
+	packet.Put(p)
+
+	assert.False(t, readerConn.Closed())
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+}
+
+func TestSyncStreams(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer := net.Pipe()
+	readerConn := NewSync(reader, &emptyLogger)
+	writerConn := NewSync(writer, &emptyLogger)
+
+	_, err := writerConn.OpenStream(1, ByteMode)
+	assert.ErrorIs(t, err, FeatureNotNegotiated)
+
+	streams := make(chan *Stream, 1)
+	readerConn.SetNewStreamHandler(func(stream *Stream) {
+		streams <- stream
+	})
+
+	stream, err := writerConn.OpenStream(1, MessageMode)
+	require.NoError(t, err)
+	assert.Nil(t, stream.Conn())
+
+	go func() {
+		p := packet.Get()
+		p.Content.Write([]byte("stream"))
+		p.Metadata.ContentLength = 6
+		assert.NoError(t, stream.WritePacket(p))
+
+		p.Metadata.Id = 64
+		p.Metadata.Operation = 32
+		assert.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+
+		assert.NoError(t, stream.Close())
+	}()
+
+	// Reading the connection delivers the stream packet that was written before the connection packet
+	p, err := readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	packet.Put(p)
+
+	remote := <-streams
+	assert.Equal(t, uint16(1), remote.ID())
+	p, err = remote.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, polyglot.Buffer("stream"), *p.Content)
+	packet.Put(p)
+
+	// The stream reads the close packet from the connection itself
+	_, err = remote.ReadPacket()
+	assert.ErrorIs(t, err, StreamClosed)
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+}
+
 func BenchmarkSyncThroughputPipe(b *testing.B) {
 	const testSize = 100
 
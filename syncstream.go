@@ -0,0 +1,158 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// newSyncStream returns a new stream that belongs to the Sync connection c
+func newSyncStream(id uint16, c *Sync, mode StreamMode) *Stream {
+	stream := newStreamWithQueue(id, mode, c.streamQueue)
+	stream.owner = c
+	stream.sync = c
+	return stream
+}
+
+// OpenStream opens a new stream with the given ID and StreamMode, or returns the existing stream with the given ID.
+// ByteMode streams can only be opened if the FeatureByteStreams feature was negotiated, and are announced to the
+// peer with a STREAMOPEN packet.
+//
+// Sync connections have no read loop, so the packets of streams are read from the connection by whichever goroutine
+// is reading from it (using ReadPacket or the ReadPacket and Read methods of any of its streams).
+func (c *Sync) OpenStream(id uint16, mode StreamMode) (*Stream, error) {
+	switch mode {
+	case MessageMode:
+	case ByteMode:
+		if !c.features.Has(FeatureByteStreams) {
+			return nil, FeatureNotNegotiated
+		}
+	default:
+		return nil, InvalidStreamMode
+	}
+
+	c.streamsMu.Lock()
+	stream := c.streams[id]
+	if stream != nil {
+		c.streamsMu.Unlock()
+		if stream.mode != mode {
+			return nil, InvalidStreamMode
+		}
+		return stream, nil
+	}
+	stream = newSyncStream(id, c, mode)
+	stream.local = true
+	c.streams[id] = stream
+	c.streamsMu.Unlock()
+
+	if mode == MessageMode {
+		return stream, nil
+	}
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = STREAMOPEN
+	p.Content.Write([]byte{byte(mode)})
+	p.Metadata.ContentLength = 1
+	err := c.WritePacket(p)
+	packet.Put(p)
+	if err != nil {
+		stream.close()
+		c.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// SetNewStreamHandler sets the callback handler for new streams opened by the peer. The handler is called
+// in its own goroutine, and the packets of its stream are delivered while the stream (or the connection) is read.
+func (c *Sync) SetNewStreamHandler(handler NewStreamHandler) {
+	c.streamsMu.Lock()
+	c.newStreamHandler = handler
+	c.streamsMu.Unlock()
+}
+
+// removeStream removes the stream with the given ID from the connection, and wakes up the readers
+// that are waiting for packets so that the readers of the stream notice that it was closed
+func (c *Sync) removeStream(id uint16) {
+	c.streamsMu.Lock()
+	delete(c.streams, id)
+	c.streamsMu.Unlock()
+	c.readMu.Lock()
+	c.readCond.Broadcast()
+	c.readMu.Unlock()
+}
+
+// deliver delivers p to its stream if it is a stream packet, and returns false if p should be returned by ReadPacket
+// instead. Stream packets are only delivered once the connection has a stream or a NewStreamHandler, so connections
+// that do not use streams keep receiving STREAM packets from ReadPacket.
+func (c *Sync) deliver(p *packet.Packet) bool {
+	if p.Metadata.Operation != STREAM && p.Metadata.Operation != STREAMCLOSE && p.Metadata.Operation != STREAMOPEN {
+		return false
+	}
+	c.streamsMu.Lock()
+	newStreamHandler := c.newStreamHandler
+	if newStreamHandler == nil && len(c.streams) == 0 {
+		c.streamsMu.Unlock()
+		return false
+	}
+	stream := c.streams[p.Metadata.Id]
+	switch {
+	case p.Metadata.Operation == STREAMCLOSE || (p.Metadata.Operation == STREAM && p.Metadata.ContentLength == 0 && !c.features.Has(FeatureStreamClose)):
+		if stream != nil {
+			stream.closeRemote(p, c.features)
+			delete(c.streams, p.Metadata.Id)
+		}
+		c.streamsMu.Unlock()
+		packet.Put(p)
+		return true
+	case p.Metadata.Operation == STREAMOPEN:
+		mode := StreamMode(0xff)
+		if p.Metadata.ContentLength == 1 {
+			mode = StreamMode((*p.Content)[0])
+		}
+		packet.Put(p)
+		if mode > ByteMode || (stream != nil && stream.mode != mode) {
+			c.streamsMu.Unlock()
+			c.Logger().Debug().Err(InvalidStreamMode).Msg("error while opening stream")
+			_ = c.closeWithError(InvalidStreamMode)
+			return true
+		}
+		if stream == nil && newStreamHandler != nil {
+			stream = newSyncStream(p.Metadata.Id, c, mode)
+			c.streams[p.Metadata.Id] = stream
+			go newStreamHandler(stream)
+		}
+		c.streamsMu.Unlock()
+		return true
+	}
+	if stream == nil && newStreamHandler == nil {
+		c.streamsMu.Unlock()
+		c.Logger().Debug().Msg("STREAM Packet discarded")
+		packet.Put(p)
+		return true
+	}
+	if stream == nil {
+		stream = newSyncStream(p.Metadata.Id, c, MessageMode)
+		c.streams[p.Metadata.Id] = stream
+		go newStreamHandler(stream)
+	}
+	c.streamsMu.Unlock()
+	if err := stream.push(p); err != nil {
+		packet.Put(p)
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/internal/dialer"
+	"github.com/pkg/errors"
+)
+
+// UpgradeProtocol is the protocol used in the Upgrade header when a frisbee connection is established
+// by upgrading an HTTP/1.1 request
+const UpgradeProtocol = "frisbee"
+
+// maxHTTPResponseSize is the maximum size of the HTTP response headers that will be read while
+// establishing a frisbee connection through an HTTP proxy or HTTP upgrade
+const maxHTTPResponseSize = 1 << 14
+
+// connect creates a new connection to addr based on the given options (using the options' Dialer, if one has been
+// configured). The connection is tunneled through
+// an HTTP CONNECT proxy if one has been configured, wrapped with the wire wrappers, optionally wrapped in TLS,
+// and then upgraded from an HTTP/1.1 request if an upgrade path has been configured.
+func connect(ctx context.Context, addr string, options *Options) (net.Conn, error) {
+	// Connections whose socket does not need to be modified before TLS is established are dialed directly
+	direct := options.Proxy == nil && len(options.WireWrappers) == 0 && options.Dialer == nil &&
+		options.SocketOptions == nil && options.KeepAliveConfig == nil && !options.FastOpen
+	if direct {
+		conn, err := dial(addr, options.KeepAlive, options.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		return upgrade(conn, addr, options)
+	}
+
+	dialAddr := addr
+	if options.Proxy != nil {
+		dialAddr = options.Proxy.Host
+		if options.Proxy.Port() == "" {
+			dialAddr = net.JoinHostPort(options.Proxy.Hostname(), "80")
+		}
+	}
+	dialContext := options.Dialer
+	if dialContext == nil && options.FastOpen {
+		dialContext = fastOpenDialer().DialContext
+	} else if dialContext == nil {
+		dialContext = dialer.NewRetry().DialContext
+	}
+	conn, err := dialContext(ctx, "tcp", dialAddr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetKeepAlive(true)
+		_ = tcpConn.SetKeepAlivePeriod(options.KeepAlive)
+	}
+	if options.KeepAliveConfig != nil {
+		err = options.KeepAliveConfig.apply(conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if options.SocketOptions != nil {
+		err = options.SocketOptions.apply(conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	conn = wrap(conn, options.WireWrappers)
+
+	if options.Proxy != nil {
+		err = httpConnect(conn, addr, options.Proxy)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if options.TLSConfig != nil {
+		config := options.TLSConfig
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.Handshake()
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	return upgrade(conn, addr, options)
+}
+
+// upgrade upgrades conn from an HTTP/1.1 request if an upgrade path has been configured
+func upgrade(conn net.Conn, addr string, options *Options) (net.Conn, error) {
+	if options.UpgradePath == "" {
+		return conn, nil
+	}
+	err := httpUpgrade(conn, addr, options.UpgradePath)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// httpConnect asks the HTTP proxy on the other end of conn to tunnel the connection to addr
+func httpConnect(conn net.Conn, addr string, proxy *url.URL) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := proxy.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	res, err := roundTrip(conn, req)
+	if err != nil {
+		return errors.Wrap(err, InvalidProxyResponse.Error())
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Wrap(InvalidProxyResponse, res.Status)
+	}
+	return nil
+}
+
+// httpUpgrade sends an HTTP/1.1 upgrade request for the given path over conn and waits for the server to
+// switch to the frisbee protocol
+func httpUpgrade(conn net.Conn, addr string, path string) error {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: path},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", UpgradeProtocol)
+	res, err := roundTrip(conn, req)
+	if err != nil {
+		return errors.Wrap(err, InvalidUpgrade.Error())
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || !headerContains(res.Header, "Upgrade", UpgradeProtocol) {
+		return errors.Wrap(InvalidUpgrade, res.Status)
+	}
+	return nil
+}
+
+// roundTrip writes req to conn and reads the response headers. The response is read one byte at a time so
+// that none of the data that the server sends after the response headers is consumed.
+func roundTrip(conn net.Conn, req *http.Request) (*http.Response, error) {
+	err := conn.SetDeadline(time.Now().Add(DefaultDeadline))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.SetDeadline(emptyTime)
+	}()
+
+	err = req.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")) {
+		if buf.Len() >= maxHTTPResponseSize {
+			return nil, errors.New("HTTP response headers are too large")
+		}
+		if _, err = conn.Read(b); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(b[0])
+	}
+	return http.ReadResponse(bufio.NewReader(&buf), req)
+}
+
+// headerContains returns whether the comma-separated values of the given header contain the token (case-insensitively)
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bufferedConn is a net.Conn that first returns the data that was already buffered from it
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// ServeHTTP upgrades HTTP/1.1 requests with the "Upgrade: frisbee" header to frisbee connections, and serves them
+// using the Server, which allows frisbee connections to traverse L7 proxies and ingress controllers that only forward HTTP.
+//
+// Requests that do not ask to be upgraded are answered with a 426 Upgrade Required response.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "Upgrade") || !headerContains(r.Header, "Upgrade", UpgradeProtocol) {
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", UpgradeProtocol)
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		s.Logger().Error().Err(err).Msg("Error while hijacking HTTP connection")
+		return
+	}
+
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + UpgradeProtocol + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		s.Logger().Error().Err(err).Msg("Error while writing HTTP upgrade response")
+		_ = conn.Close()
+		return
+	}
+
+	if rw.Reader.Buffered() > 0 {
+		conn = &bufferedConn{Conn: conn, reader: rw.Reader}
+	}
+	_ = conn.SetDeadline(emptyTime)
+	s.ServeConn(conn)
+}
@@ -0,0 +1,151 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTunnel starts a frisbee server that is served over HTTP using ServeHTTP, connects a frisbee client to
+// it with the given options, and checks that a packet can be sent and answered over the resulting connection
+func testTunnel(t *testing.T, options ...Option) {
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable)
+	require.NoError(t, err)
+
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(httpServer.Close)
+
+	received := make(chan []byte, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- append([]byte(nil), *incoming.Content...)
+		return
+	}
+
+	c, err := NewClient(clientHandlerTable, context.Background(), options...)
+	require.NoError(t, err)
+
+	err = c.Connect(httpServer.Listener.Addr().String())
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write([]byte("TUNNEL"))
+	p.Metadata.ContentLength = uint32(len("TUNNEL"))
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	assert.Equal(t, []byte("TUNNEL"), <-received)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestTunnelUpgrade(t *testing.T) {
+	t.Parallel()
+
+	testTunnel(t, WithUpgrade("/frisbee"))
+}
+
+func TestTunnelProxy(t *testing.T) {
+	t.Parallel()
+
+	credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:password"))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != credentials {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = target.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(target, conn)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+		_ = conn.Close()
+	}))
+	t.Cleanup(proxy.Close)
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	proxyURL.User = url.UserPassword("user", "password")
+	testTunnel(t, WithProxy(proxyURL), WithUpgrade("/frisbee"))
+
+	proxyURL.User = nil
+	c, err := NewClient(make(HandlerTable), context.Background(), WithProxy(proxyURL))
+	require.NoError(t, err)
+	err = c.Connect(proxy.Listener.Addr().String())
+	assert.ErrorIs(t, err, InvalidProxyResponse)
+}
+
+func TestTunnelUpgradeRequired(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(make(HandlerTable))
+	require.NoError(t, err)
+
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(httpServer.Close)
+
+	res, err := http.Get(httpServer.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUpgradeRequired, res.StatusCode)
+	assert.Equal(t, UpgradeProtocol, res.Header.Get("Upgrade"))
+	assert.NoError(t, res.Body.Close())
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notFound.Close)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithUpgrade("/frisbee"))
+	require.NoError(t, err)
+	err = c.Connect(notFound.Listener.Addr().String())
+	assert.ErrorIs(t, err, InvalidUpgrade)
+}
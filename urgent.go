@@ -0,0 +1,198 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// priorityUrgent is the internal Priority of the packets written with Async.WriteUrgent
+const priorityUrgent = PriorityBulk + 1
+
+// urgentFrame is an encoded urgent packet that is waiting to be written between two frames of the write buffer
+type urgentFrame struct {
+	frame []byte
+	sent  bool
+	err   error
+
+	// done is closed once the frame has been written (or discarded)
+	done chan struct{}
+}
+
+// WriteUrgent writes a small control packet (like a close reason, a window update, or a cancellation) to the connection
+// through a dedicated path that bypasses the write buffer. Instead of waiting behind the packets that are already
+// buffered, the packet is written to the underlying connection at the first boundary between two buffered frames,
+// so it gets out even when the write buffer is full of bulk data. WriteUrgent returns once the packet has been
+// written to the underlying connection.
+//
+// Urgent packets can overtake packets that were written before them. On connections that sign their packets or
+// stamp them with sequence numbers, urgent packets are written and flushed in order like internal control packets.
+func (c *Async) WriteUrgent(p *packet.Packet) error {
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	return c.writePacketPriority(p, priorityUrgent, nil)
+}
+
+// writeUrgent writes the already encoded header and content of the urgent packet p (see WriteUrgent)
+//
+// The caller does not wait for the connection to be unlocked: a writer that is flushing the write buffer writes the
+// urgent frame at the next frame boundary and signals the caller. If no writer gets to it first, flushUrgent writes
+// the frame once it has locked the connection.
+func (c *Async) writeUrgent(p *packet.Packet, header []byte, content []byte) error {
+	if c.signing != nil || c.sequenced() {
+		return c.writeEncoded(p, header, content, true, nil)
+	}
+
+	u := &urgentFrame{frame: make([]byte, len(header)+len(content)), done: make(chan struct{})}
+	copy(u.frame[copy(u.frame, header):], content)
+	w := c.sent
+	w.urgentMu.Lock()
+	w.urgent = append(w.urgent, u)
+	w.urgentMu.Unlock()
+	w.pending.Inc()
+
+	go c.flushUrgent(u, p.Metadata.Id)
+	<-u.done
+	return u.err
+}
+
+// flushUrgent writes the urgent frame u if no writer has written it by the time the connection can be locked,
+// flushing the write buffer up to a frame boundary first
+func (c *Async) flushUrgent(u *urgentFrame, id uint16) {
+	c.Lock()
+	defer c.Unlock()
+	w := c.sent
+	w.urgentMu.Lock()
+	sent := u.sent
+	w.urgentMu.Unlock()
+	if sent {
+		return
+	}
+	if c.closed.Load() {
+		w.urgentMu.Lock()
+		if !u.sent {
+			w.discardUrgent(u)
+			u.finish(ConnectionClosed)
+		}
+		w.urgentMu.Unlock()
+		return
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+	if err == nil && (!w.tracking || !w.atBoundary()) {
+		// Flushing the write buffer writes the urgent frame at its first frame boundary
+		err = c.writer.Flush()
+		c.settleWrites(err)
+		w.tracking = true
+		w.ends = w.ends[:0]
+		w.lastEnd = w.sent
+	}
+	if err == nil {
+		err = w.writeUrgent()
+	}
+	if err != nil {
+		c.Logger().Debug().Err(err).Uint16("Packet ID", id).Msg("error while writing urgent packet")
+		w.urgentMu.Lock()
+		if !u.sent {
+			w.discardUrgent(u)
+			u.finish(err)
+		}
+		w.urgentMu.Unlock()
+	}
+}
+
+// finish marks the urgent frame as written (or discarded) with the given error, and signals the writer waiting for
+// it. It must be called with urgentMu locked.
+func (u *urgentFrame) finish(err error) {
+	u.sent = true
+	u.err = err
+	close(u.done)
+}
+
+// discardUrgent removes u from the urgent frames that are waiting to be written. It must
+// be called with urgentMu locked.
+func (w *writeCounter) discardUrgent(u *urgentFrame) {
+	for i, frame := range w.urgent {
+		if frame == u {
+			w.urgent = append(w.urgent[:i], w.urgent[i+1:]...)
+			w.pending.Dec()
+			return
+		}
+	}
+}
+
+// atBoundary returns true if the bytes that have been flushed to the underlying connection end at the end of a frame
+func (w *writeCounter) atBoundary() bool {
+	i := 0
+	for ; i < len(w.ends) && w.ends[i] <= w.sent; i++ {
+		w.lastEnd = w.ends[i]
+	}
+	w.ends = w.ends[i:]
+	return w.lastEnd == w.sent
+}
+
+// writeUrgent writes the urgent frames that are waiting to the underlying connection, and must only
+// be called at a frame boundary. The bytes of urgent frames are not counted as flushed bytes.
+func (w *writeCounter) writeUrgent() error {
+	if w.pending.Load() == 0 {
+		return nil
+	}
+	w.urgentMu.Lock()
+	defer w.urgentMu.Unlock()
+	for len(w.urgent) > 0 {
+		u := w.urgent[0]
+		w.urgent[0] = nil
+		w.urgent = w.urgent[1:]
+		w.pending.Dec()
+		_, err := w.conn.Write(u.frame)
+		u.finish(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTracked writes b to the underlying connection like write does, but when urgent frames are waiting it splits
+// b at the ends of its frames so that the urgent frames can be written at the first frame boundary
+func (w *writeCounter) writeTracked(b []byte) (int, error) {
+	var written int
+	for {
+		// Checking for a boundary also forgets the ends of the frames that have been flushed
+		if w.atBoundary() && w.pending.Load() > 0 {
+			err := w.writeUrgent()
+			if err != nil {
+				return written, err
+			}
+		}
+		if written == len(b) {
+			return written, nil
+		}
+		chunk := b[written:]
+		if w.pending.Load() > 0 && len(w.ends) > 0 && w.ends[0]-w.sent < uint64(len(chunk)) {
+			chunk = chunk[:w.ends[0]-w.sent]
+		}
+		n, err := w.write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedConn holds the writes to the underlying connection while its gate is locked
+type gatedConn struct {
+	net.Conn
+	gate sync.RWMutex
+}
+
+func (c *gatedConn) Write(b []byte) (int, error) {
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+	return c.Conn.Write(b)
+}
+
+func TestAsyncWriteUrgent(t *testing.T) {
+	t.Parallel()
+
+	const bulkSize = 1 << 15
+	const bulkPackets = 8
+	const urgentID = 1024
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	reader, writer := net.Pipe()
+	gated := &gatedConn{Conn: writer}
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(gated, &emptyLogger)
+
+	urgent := func() error {
+		p := packet.Get()
+		defer packet.Put(p)
+		p.Metadata.Id = urgentID
+		p.Metadata.Operation = metadata.PacketPing
+		return writerConn.WriteUrgent(p)
+	}
+
+	p := packet.Get()
+	p.Metadata.Operation = PING
+	assert.ErrorIs(t, writerConn.WriteUrgent(p), InvalidOperation)
+	packet.Put(p)
+
+	go func() {
+		assert.NoError(t, urgent())
+	}()
+	p, err := readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(urgentID), p.Metadata.Id)
+	packet.Put(p)
+
+	// Holding the writes to the pipe makes the bulk packets fill up the write buffer, and leaves
+	// the writer that is flushing it blocked with the connection locked
+	gated.gate.Lock()
+	bulkDone := make(chan struct{})
+	go func() {
+		defer close(bulkDone)
+		content := make([]byte, bulkSize)
+		for i := 0; i < bulkPackets; i++ {
+			p := packet.Get()
+			p.Metadata.Id = uint16(i)
+			p.Metadata.Operation = metadata.PacketPing
+			p.Content.Write(content)
+			p.Metadata.ContentLength = bulkSize
+			assert.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+		}
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	urgentDone := make(chan struct{})
+	go func() {
+		defer close(urgentDone)
+		assert.NoError(t, urgent())
+	}()
+	time.Sleep(time.Millisecond * 50)
+	gated.gate.Unlock()
+
+	urgentIndex := -1
+	next := uint16(0)
+	for i := 0; i < bulkPackets+1; i++ {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		if p.Metadata.Id == urgentID {
+			urgentIndex = i
+		} else {
+			assert.Equal(t, next, p.Metadata.Id)
+			next++
+		}
+		packet.Put(p)
+	}
+	assert.GreaterOrEqual(t, urgentIndex, 0)
+	assert.Less(t, urgentIndex, bulkPackets/2)
+
+	<-bulkDone
+	<-urgentDone
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+}
@@ -0,0 +1,71 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"sync"
+)
+
+// values is the per-connection store behind Async.Value and Async.SetValue
+type values struct {
+	mu sync.RWMutex
+	m  map[interface{}]interface{}
+}
+
+// Value returns the value that was stored on the connection for key using SetValue, or nil if there is none.
+//
+// Values let handlers keep per-connection state (like the authenticated identity of the peer, counters, or caches)
+// on the connection itself, which they can get from the context they are given (see ConnFromContext). Values are
+// kept after the connection is closed, so they are still available to the OnDisconnect callback of the Server.
+func (c *Async) Value(key interface{}) interface{} {
+	c.values.mu.RLock()
+	defer c.values.mu.RUnlock()
+	return c.values.m[key]
+}
+
+// SetValue stores value on the connection for key, replacing the previous value. Like with context.WithValue,
+// keys should be of an unexported type to avoid collisions.
+func (c *Async) SetValue(key interface{}, value interface{}) {
+	c.values.mu.Lock()
+	if c.values.m == nil {
+		c.values.m = make(map[interface{}]interface{})
+	}
+	c.values.m[key] = value
+	c.values.mu.Unlock()
+}
+
+// LoadOrStoreValue returns the value stored on the connection for key if there is one, and otherwise stores
+// and returns the given value. The loaded result is true if the value was already stored.
+func (c *Async) LoadOrStoreValue(key interface{}, value interface{}) (actual interface{}, loaded bool) {
+	c.values.mu.Lock()
+	defer c.values.mu.Unlock()
+	if actual, loaded = c.values.m[key]; loaded {
+		return actual, true
+	}
+	if c.values.m == nil {
+		c.values.m = make(map[interface{}]interface{})
+	}
+	c.values.m[key] = value
+	return value, false
+}
+
+// DeleteValue removes the value stored on the connection for key
+func (c *Async) DeleteValue(key interface{}) {
+	c.values.mu.Lock()
+	delete(c.values.m, key)
+	c.values.mu.Unlock()
+}
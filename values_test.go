@@ -0,0 +1,111 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testValueKey struct{}
+
+func TestAsyncValues(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+	readerConn := NewAsync(reader, &emptyLogger)
+	writerConn := NewAsync(writer, &emptyLogger)
+
+	assert.Nil(t, readerConn.Value(testValueKey{}))
+	readerConn.SetValue(testValueKey{}, "identity")
+	assert.Equal(t, "identity", readerConn.Value(testValueKey{}))
+	assert.Nil(t, writerConn.Value(testValueKey{}))
+
+	actual, loaded := readerConn.LoadOrStoreValue(testValueKey{}, "other")
+	assert.True(t, loaded)
+	assert.Equal(t, "identity", actual)
+
+	readerConn.DeleteValue(testValueKey{})
+	assert.Nil(t, readerConn.Value(testValueKey{}))
+	actual, loaded = readerConn.LoadOrStoreValue(testValueKey{}, "other")
+	assert.False(t, loaded)
+	assert.Equal(t, "other", actual)
+
+	// Values are kept after the connection is closed
+	require.NoError(t, readerConn.Close())
+	assert.Equal(t, "other", readerConn.Value(testValueKey{}))
+
+	_ = writerConn.Close()
+}
+
+func TestServerValues(t *testing.T) {
+	t.Parallel()
+
+	const count = uint16(10)
+
+	// The handler counts the packets of every connection using a counter that is stored on the connection
+	emptyLogger := zerolog.New(io.Discard)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[count] = func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		conn := ConnFromContext(ctx).(*Async)
+		counter, _ := conn.LoadOrStoreValue(testValueKey{}, new(int))
+		*counter.(*int)++
+		return nil, NONE
+	}
+	server, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	server.SetConcurrency(1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.NoError(t, server.SetOnDisconnect(func(c *Async, err error) {
+		counter, ok := c.Value(testValueKey{}).(*int)
+		assert.True(t, ok)
+		assert.Equal(t, 3, *counter)
+		wg.Done()
+	}))
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(HandlerTable{}, context.Background(), WithLogger(&emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, client.FromConn(clientConn))
+
+	for i := 0; i < 3; i++ {
+		p := packet.Get()
+		p.Metadata.Operation = count
+		require.NoError(t, client.WritePacket(p))
+		packet.Put(p)
+	}
+	require.NoError(t, client.Flush())
+	require.NoError(t, client.Close())
+	wg.Wait()
+
+	require.NoError(t, server.Shutdown())
+}
@@ -0,0 +1,73 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package webtransport
+
+import (
+	"net"
+	"sync"
+
+	"github.com/quic-go/webtransport-go"
+)
+
+// Conn is a net.Conn that reads from and writes to a bidirectional WebTransport stream
+type Conn struct {
+	*webtransport.Stream
+	session     *webtransport.Session
+	ownsSession bool
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+// NewConn returns a Conn for the given bidirectional stream of session. If ownsSession is true then closing the Conn
+// also closes the session, otherwise only the stream is closed.
+func NewConn(session *webtransport.Session, stream *webtransport.Stream, ownsSession bool) *Conn {
+	return &Conn{
+		Stream:      stream,
+		session:     session,
+		ownsSession: ownsSession,
+	}
+}
+
+// Session returns the WebTransport session that the stream of the Conn belongs to
+func (c *Conn) Session() *webtransport.Session {
+	return c.session
+}
+
+// LocalAddr returns the local address of the WebTransport session
+func (c *Conn) LocalAddr() net.Addr {
+	return c.session.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the WebTransport session
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
+
+// Close closes both directions of the stream, and the session if it is owned by the Conn
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.Stream.Close()
+		c.Stream.CancelRead(0)
+		if c.ownsSession {
+			err := c.session.CloseWithError(0, "")
+			if c.closeErr == nil {
+				c.closeErr = err
+			}
+		}
+	})
+	return c.closeErr
+}
@@ -0,0 +1,115 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package webtransport runs frisbee connections over WebTransport (HTTP/3) bidirectional streams, so that browser
+// clients and QUIC-native environments can speak frisbee framing.
+//
+// Every bidirectional stream of a WebTransport session carries a single frisbee connection, so a session can carry
+// several independent frisbee connections. The server only learns about a stream once the client has written to it,
+// which frisbee clients do as soon as they send their first packet (or their HELLO packet, if the handshake is enabled).
+//
+// It lives in its own module so that the core frisbee module does not depend on QUIC.
+package webtransport
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/pkg/errors"
+	"github.com/quic-go/webtransport-go"
+)
+
+// These are various errors that can be returned when establishing WebTransport connections:
+var (
+	ServerNil     = errors.New("frisbee server cannot be nil")
+	UpgraderNil   = errors.New("webtransport server cannot be nil")
+	DialFailed    = errors.New("webtransport session could not be established")
+	InvalidStatus = errors.New("invalid webtransport response status")
+)
+
+// Handler returns an http.Handler that upgrades requests to WebTransport sessions using upgrader, and then serves
+// every bidirectional stream that the client opens on a session as a frisbee connection using s.
+//
+// The handler must be registered with the http3.Server of the upgrader, which must have been
+// configured with webtransport.ConfigureHTTP3Server.
+func Handler(s *frisbee.Server, upgrader *webtransport.Server) (http.Handler, error) {
+	if s == nil {
+		return nil, ServerNil
+	}
+	if upgrader == nil {
+		return nil, UpgraderNil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			s.Logger().Debug().Err(err).Msg("error while upgrading to a webtransport session")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for {
+			conn, err := Accept(session.Context(), session)
+			if err != nil {
+				s.Logger().Debug().Err(err).Msg("webtransport session closed")
+				return
+			}
+			s.ServeConn(conn)
+		}
+	}), nil
+}
+
+// Dial establishes a new WebTransport session with the server at url using d, and opens a bidirectional stream on it
+// that can be used as the connection of a frisbee client (using frisbee.Client.FromConn). Closing the
+// returned connection also closes the session.
+func Dial(ctx context.Context, d *webtransport.Dialer, url string, header http.Header) (net.Conn, error) {
+	res, session, err := d.Dial(ctx, url, header)
+	if err != nil {
+		return nil, errors.Wrap(err, DialFailed.Error())
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		if session != nil {
+			_ = session.CloseWithError(0, "")
+		}
+		return nil, errors.Wrap(InvalidStatus, res.Status)
+	}
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		_ = session.CloseWithError(0, "")
+		return nil, err
+	}
+	return NewConn(session, stream, true), nil
+}
+
+// Open opens a new bidirectional stream on session that can be used as a frisbee connection. Closing the
+// returned connection does not close the session.
+func Open(ctx context.Context, session *webtransport.Session) (net.Conn, error) {
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(session, stream, false), nil
+}
+
+// Accept waits for the peer to open a bidirectional stream on session, and returns it as a connection that can be
+// served as a frisbee connection. Closing the returned connection does not close the session.
+func Accept(ctx context.Context, session *webtransport.Session) (net.Conn, error) {
+	stream, err := session.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(session, stream, false), nil
+}
@@ -0,0 +1,161 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package webtransport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	echoOperation = uint16(10)
+	testPath      = "/frisbee"
+)
+
+// testTLS returns a server TLS config with a self-signed certificate for localhost, and a client
+// TLS config that trusts it
+func testTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{http3.NextProtoH3},
+	}
+	clientConfig := &tls.Config{
+		RootCAs:    pool,
+		NextProtos: []string{http3.NextProtoH3},
+	}
+	return serverConfig, clientConfig
+}
+
+func TestWebTransport(t *testing.T) {
+	t.Parallel()
+
+	handlerTable := make(frisbee.HandlerTable)
+	handlerTable[echoOperation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		outgoing = incoming
+		return
+	}
+	s, err := frisbee.NewServer(handlerTable)
+	require.NoError(t, err)
+
+	serverTLS, clientTLS := testTLS(t)
+	upgrader := &webtransport.Server{
+		H3: &http3.Server{TLSConfig: serverTLS},
+	}
+	webtransport.ConfigureHTTP3Server(upgrader.H3)
+
+	_, err = Handler(nil, upgrader)
+	assert.ErrorIs(t, err, ServerNil)
+	_, err = Handler(s, nil)
+	assert.ErrorIs(t, err, UpgraderNil)
+
+	handler, err := Handler(s, upgrader)
+	require.NoError(t, err)
+	upgrader.H3.Handler = handler
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- upgrader.Serve(udpConn)
+	}()
+
+	d := &webtransport.Dialer{TLSClientConfig: clientTLS}
+	url := fmt.Sprintf("https://localhost:%d%s", udpConn.LocalAddr().(*net.UDPAddr).Port, testPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), frisbee.DefaultDeadline*10)
+	defer cancel()
+
+	echoed := make(chan []byte, 2)
+	clientHandlerTable := make(frisbee.HandlerTable)
+	clientHandlerTable[echoOperation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action frisbee.Action) {
+		echoed <- append([]byte(nil), *incoming.Content...)
+		return
+	}
+
+	var clients []*frisbee.Client
+	for i := 0; i < 2; i++ {
+		conn, err := Dial(ctx, d, url, nil)
+		require.NoError(t, err)
+
+		c, err := frisbee.NewClient(clientHandlerTable, context.Background())
+		require.NoError(t, err)
+		err = c.FromConn(conn)
+		require.NoError(t, err)
+		clients = append(clients, c)
+
+		p := packet.Get()
+		p.Metadata.Operation = echoOperation
+		p.Content.Write([]byte("WEBTRANSPORT"))
+		p.Metadata.ContentLength = uint32(len("WEBTRANSPORT"))
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		select {
+		case content := <-echoed:
+			assert.Equal(t, []byte("WEBTRANSPORT"), content)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for echoed packet")
+		}
+	}
+
+	for _, c := range clients {
+		assert.NoError(t, c.Close())
+	}
+	assert.NoError(t, s.Shutdown())
+	assert.NoError(t, upgrader.Close())
+	<-serveErr
+	assert.NoError(t, d.Close())
+	_ = udpConn.Close()
+}
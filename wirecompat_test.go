@@ -0,0 +1,204 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/frisbee-go/pkg/wiretest"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readNonControlPacket reads packets from a Sync connection until one that is not a PING or PONG packet is read
+func readNonControlPacket(c *Sync) (*packet.Packet, error) {
+	for {
+		p, err := c.ReadPacket()
+		if err != nil || (p.Metadata.Operation != PING && p.Metadata.Operation != PONG) {
+			return p, err
+		}
+		packet.Put(p)
+	}
+}
+
+func TestWireCompat(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	type conn interface {
+		wiretest.Peer
+		Close() error
+	}
+
+	modes := map[string]func(net.Conn) conn{
+		"Async": func(c net.Conn) conn {
+			return NewAsync(c, &emptyLogger)
+		},
+		"Sync": func(c net.Conn) conn {
+			return NewSync(c, &emptyLogger)
+		},
+	}
+
+	for writerMode, newWriter := range modes {
+		for readerMode, newReader := range modes {
+			writerMode, newWriter, readerMode, newReader := writerMode, newWriter, readerMode, newReader
+			t.Run(writerMode+" to "+readerMode, func(t *testing.T) {
+				t.Parallel()
+
+				writer, reader, err := pair.New()
+				require.NoError(t, err)
+
+				writerConn := newWriter(writer)
+				readerConn := newReader(reader)
+
+				assert.NoError(t, wiretest.RunAll(writerConn, readerConn))
+
+				err = writerConn.Close()
+				assert.NoError(t, err)
+				err = readerConn.Close()
+				assert.NoError(t, err)
+			})
+		}
+	}
+}
+
+func TestWireCompatControl(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	asyncConn, syncConn, err := pair.New()
+	require.NoError(t, err)
+
+	a := NewAsync(asyncConn, &emptyLogger)
+	s := NewSync(syncConn, &emptyLogger)
+
+	err = s.WritePacket(PINGPacket)
+	require.NoError(t, err)
+
+	var sawPing, sawPong bool
+	for !sawPing || !sawPong {
+		p, err := s.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(0), p.Metadata.ContentLength)
+		switch p.Metadata.Operation {
+		case PING:
+			sawPing = true
+		case PONG:
+			sawPong = true
+		default:
+			t.Fatalf("unexpected operation %d", p.Metadata.Operation)
+		}
+		packet.Put(p)
+	}
+
+	err = a.Close()
+	assert.NoError(t, err)
+	err = s.Close()
+	assert.NoError(t, err)
+}
+
+func TestWireCompatStreams(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := zerolog.New(io.Discard)
+
+	asyncConn, syncConn, err := pair.New()
+	require.NoError(t, err)
+
+	streams := make(chan *Stream, 1)
+	a := NewAsync(asyncConn, &emptyLogger, func(stream *Stream) {
+		streams <- stream
+	})
+	s := NewSync(syncConn, &emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 7
+	p.Metadata.Operation = STREAM
+	p.Content.Write([]byte("from sync"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	err = s.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	incoming := <-streams
+	assert.Equal(t, uint16(7), incoming.ID())
+	p, err = incoming.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from sync"), p.Content.Bytes())
+	packet.Put(p)
+
+	p = packet.Get()
+	p.Content.Write([]byte("from async"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	err = incoming.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = readNonControlPacket(s)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(7), p.Metadata.Id)
+	assert.Equal(t, STREAM, p.Metadata.Operation)
+	assert.Equal(t, []byte("from async"), p.Content.Bytes())
+	packet.Put(p)
+
+	p = packet.Get()
+	p.Metadata.Id = 7
+	p.Metadata.Operation = STREAM
+	err = s.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	_, err = incoming.ReadPacket()
+	assert.ErrorIs(t, err, StreamClosed)
+
+	outgoing := a.NewStream(9)
+	p = packet.Get()
+	p.Content.Write([]byte("new stream"))
+	p.Metadata.ContentLength = uint32(len(*p.Content))
+	err = outgoing.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = readNonControlPacket(s)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(9), p.Metadata.Id)
+	assert.Equal(t, STREAM, p.Metadata.Operation)
+	assert.Equal(t, []byte("new stream"), p.Content.Bytes())
+	packet.Put(p)
+
+	err = outgoing.Close()
+	require.NoError(t, err)
+
+	p, err = readNonControlPacket(s)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(9), p.Metadata.Id)
+	assert.Equal(t, STREAM, p.Metadata.Operation)
+	assert.Equal(t, uint32(0), p.Metadata.ContentLength)
+	packet.Put(p)
+
+	err = a.Close()
+	assert.NoError(t, err)
+	err = s.Close()
+	assert.NoError(t, err)
+}
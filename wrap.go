@@ -0,0 +1,317 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// ConnWrapper wraps a connection, and is used to install wrappers (like a ByteCounter or a Throttle) around the
+// connections of a frisbee client or server (see the WithConnWrapper option).
+type ConnWrapper func(net.Conn) net.Conn
+
+// WrapConn returns a net.Conn that reads from conn through the io.Reader returned by reader, and writes to conn
+// through the io.Writer returned by writer. Either function can be nil, in which case conn is used directly.
+func WrapConn(conn net.Conn, reader func(io.Reader) io.Reader, writer func(io.Writer) io.Writer) net.Conn {
+	w := &wrappedConn{Conn: conn, reader: conn, writer: conn}
+	if reader != nil {
+		w.reader = reader(conn)
+	}
+	if writer != nil {
+		w.writer = writer(conn)
+	}
+	return w
+}
+
+type wrappedConn struct {
+	net.Conn
+	reader io.Reader
+	writer io.Writer
+}
+
+func (c *wrappedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *wrappedConn) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+func (c *wrappedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return NotCloseWriter
+}
+
+// wrap applies the wrappers to conn in order, so the last wrapper is the outermost one
+func wrap(conn net.Conn, wrappers []ConnWrapper) net.Conn {
+	for _, wrapper := range wrappers {
+		conn = wrapper(conn)
+	}
+	return conn
+}
+
+// wrapConn applies the wire wrappers to conn (unless wired is true, in which case they have already been installed
+// beneath TLS) and then the connection wrappers
+func (o *Options) wrapConn(conn net.Conn, wired bool) net.Conn {
+	if !wired {
+		conn = wrap(conn, o.WireWrappers)
+	}
+	return wrap(conn, o.ConnWrappers)
+}
+
+// wireListener is a net.Listener that applies wire wrappers to accepted connections before TLS is established
+type wireListener struct {
+	net.Listener
+	wrappers []ConnWrapper
+}
+
+func (l *wireListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return wrap(conn, l.wrappers), nil
+}
+
+// ByteCounter counts the bytes read from and written to the readers, writers, and connections that it wraps,
+// which is useful for metering or billing bandwidth.
+type ByteCounter struct {
+	read    *atomic.Uint64
+	written *atomic.Uint64
+}
+
+// NewByteCounter returns a new ByteCounter with both of its counts at zero
+func NewByteCounter() *ByteCounter {
+	return &ByteCounter{
+		read:    atomic.NewUint64(0),
+		written: atomic.NewUint64(0),
+	}
+}
+
+// BytesRead returns the number of bytes read through the ByteCounter
+func (c *ByteCounter) BytesRead() uint64 {
+	return c.read.Load()
+}
+
+// BytesWritten returns the number of bytes written through the ByteCounter
+func (c *ByteCounter) BytesWritten() uint64 {
+	return c.written.Load()
+}
+
+// Reader returns an io.Reader that counts the bytes read from r
+func (c *ByteCounter) Reader(r io.Reader) io.Reader {
+	return &countingReader{reader: r, count: c.read}
+}
+
+// Writer returns an io.Writer that counts the bytes written to w
+func (c *ByteCounter) Writer(w io.Writer) io.Writer {
+	return &countingWriter{writer: w, count: c.written}
+}
+
+// Wrap returns a net.Conn that counts the bytes read from and written to conn, and can be used as a ConnWrapper
+func (c *ByteCounter) Wrap(conn net.Conn) net.Conn {
+	return WrapConn(conn, c.Reader, c.Writer)
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  *atomic.Uint64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.count.Add(uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  *atomic.Uint64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.writer.Write(b)
+	w.count.Add(uint64(n))
+	return n, err
+}
+
+// Throttle limits the rate at which bytes can be read from and written to the readers, writers, and connections that
+// it wraps, using a token bucket that is shared between all of them.
+type Throttle struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle returns a new Throttle that allows bytesPerSecond bytes per second on average, and bursts of
+// up to burst bytes. If burst is less than 1, it defaults to bytesPerSecond.
+func NewThrottle(bytesPerSecond int, burst int) *Throttle {
+	if burst < 1 {
+		burst = bytesPerSecond
+	}
+	return &Throttle{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes are allowed by the Throttle, where n must not be larger than the burst
+func (t *Throttle) wait(n int) {
+	if delay := t.reserve(n); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// reserve consumes n bytes from the Throttle (where n must not be larger than the burst),
+// and returns how long the caller must wait before they are allowed
+func (t *Throttle) reserve(n int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(time.Now())
+	t.tokens -= float64(n)
+	if t.tokens < 0 {
+		return time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	return 0
+}
+
+// waitN blocks until n bytes are allowed by the Throttle, waiting for at most a burst of bytes at a time
+func (t *Throttle) waitN(n int) {
+	for n > 0 {
+		size := n
+		if size > t.burst {
+			size = t.burst
+		}
+		t.wait(size)
+		n -= size
+	}
+}
+
+// waitUntil blocks until n bytes are allowed by the Throttle and returns true, unless they would not be allowed
+// before the deadline, in which case it returns false right away without consuming anything
+func (t *Throttle) waitUntil(n int, deadline time.Time) bool {
+	t.mu.Lock()
+	now := time.Now()
+	t.refill(now)
+	var delay time.Duration
+	if t.tokens < float64(n) {
+		delay = time.Duration((float64(n) - t.tokens) / t.rate * float64(time.Second))
+		if now.Add(delay).After(deadline) {
+			t.mu.Unlock()
+			return false
+		}
+	}
+	t.tokens -= float64(n)
+	t.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// allow consumes n bytes from the Throttle and returns true if they are available
+// right away, and otherwise returns false without consuming anything
+func (t *Throttle) allow(n int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(time.Now())
+	if t.tokens < float64(n) {
+		return false
+	}
+	t.tokens -= float64(n)
+	return true
+}
+
+// replenished returns true if the Throttle allows a full burst of bytes
+func (t *Throttle) replenished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(time.Now())
+	return t.tokens >= float64(t.burst)
+}
+
+// refill adds the bytes that have been allowed since the last refill, and must be called with the Throttle locked
+func (t *Throttle) refill(now time.Time) {
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > float64(t.burst) {
+		t.tokens = float64(t.burst)
+	}
+	t.last = now
+}
+
+// Reader returns an io.Reader that limits the rate at which bytes are read from r
+func (t *Throttle) Reader(r io.Reader) io.Reader {
+	return &throttledReader{reader: r, throttle: t}
+}
+
+// Writer returns an io.Writer that limits the rate at which bytes are written to w
+func (t *Throttle) Writer(w io.Writer) io.Writer {
+	return &throttledWriter{writer: w, throttle: t}
+}
+
+// Wrap returns a net.Conn that limits the rate at which bytes are read from and written to conn, and can be
+// used as a ConnWrapper
+func (t *Throttle) Wrap(conn net.Conn) net.Conn {
+	return WrapConn(conn, t.Reader, t.Writer)
+}
+
+type throttledReader struct {
+	reader   io.Reader
+	throttle *Throttle
+}
+
+func (r *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > r.throttle.burst {
+		b = b[:r.throttle.burst]
+	}
+	n, err := r.reader.Read(b)
+	r.throttle.wait(n)
+	return n, err
+}
+
+type throttledWriter struct {
+	writer   io.Writer
+	throttle *Throttle
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		size := len(b) - written
+		if size > w.throttle.burst {
+			size = w.throttle.burst
+		}
+		w.throttle.wait(size)
+		n, err := w.writer.Write(b[written : written+size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,165 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package frisbee
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+	"github.com/loopholelabs/testing/conn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTLSConfigs returns a server TLS config with a self-signed certificate for 127.0.0.1, and a client
+// TLS config that trusts it
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, &tls.Config{RootCAs: pool}
+}
+
+func TestByteCounter(t *testing.T) {
+	t.Parallel()
+
+	counter := NewByteCounter()
+
+	var buf bytes.Buffer
+	n, err := counter.Writer(&buf).Write([]byte("frisbee"))
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, uint64(7), counter.BytesWritten())
+
+	read, err := io.ReadAll(counter.Reader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("frisbee"), read)
+	assert.Equal(t, uint64(7), counter.BytesRead())
+}
+
+func TestThrottle(t *testing.T) {
+	t.Parallel()
+
+	throttle := NewThrottle(1000, 100)
+
+	var buf bytes.Buffer
+	start := time.Now()
+	n, err := throttle.Writer(&buf).Write(make([]byte, 300))
+	require.NoError(t, err)
+	assert.Equal(t, 300, n)
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*150)
+
+	start = time.Now()
+	read, err := io.ReadAll(throttle.Reader(&buf))
+	require.NoError(t, err)
+	assert.Len(t, read, 300)
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*250)
+}
+
+func TestConnWrapper(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	emptyLogger := zerolog.New(io.Discard)
+	noPings := WithLiveness(Liveness{PingInterval: -1})
+
+	serverWire, serverConn := NewByteCounter(), NewByteCounter()
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		incoming.Metadata.Operation = metadata.PacketPong
+		outgoing = incoming
+		return
+	}
+	s, err := NewServer(serverHandlerTable, WithLogger(&emptyLogger), WithTLS(serverTLS), noPings,
+		WithConnWrapper(serverWire.Wrap, true), WithConnWrapper(serverConn.Wrap, false))
+	require.NoError(t, err)
+
+	go func() {
+		_ = s.Start(conn.Listen)
+	}()
+	<-s.started()
+
+	clientWire, clientConn := NewByteCounter(), NewByteCounter()
+	received := make(chan struct{}, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPong] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- struct{}{}
+		return
+	}
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(&emptyLogger), WithTLS(clientTLS), noPings,
+		WithConnWrapper(clientWire.Wrap, true), WithConnWrapper(clientConn.Wrap, false))
+	require.NoError(t, err)
+
+	err = c.Connect(s.listener.Addr().String())
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write(make([]byte, 512))
+	p.Metadata.ContentLength = 512
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	<-received
+
+	assert.Equal(t, uint64(metadata.Size+512), clientConn.BytesWritten())
+	assert.Equal(t, uint64(metadata.Size+512), clientConn.BytesRead())
+	assert.Greater(t, clientWire.BytesWritten(), clientConn.BytesWritten())
+	assert.Greater(t, clientWire.BytesRead(), clientConn.BytesRead())
+
+	assert.Eventually(t, func() bool {
+		return serverConn.BytesWritten() == clientConn.BytesRead() && serverWire.BytesRead() == clientWire.BytesWritten()
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, clientConn.BytesWritten(), serverConn.BytesRead())
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}